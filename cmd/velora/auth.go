@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+)
+
+// runAuth handles the "auth" command group.
+func runAuth(args []string) error {
+	if len(args) < 1 || args[0] != "check" {
+		return fmt.Errorf("usage: velora auth check [--config path]")
+	}
+
+	fs := flag.NewFlagSet("auth check", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	clientFactory, err := azure.NewClientFactory(&cfg.Azure)
+	if err != nil {
+		return err
+	}
+
+	info, err := clientFactory.CheckAuth(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("credential type:", info.CredentialType)
+	if info.Chain != "" {
+		fmt.Println("credential chain:", info.Chain)
+	}
+	fmt.Println("object ID:      ", info.ObjectID)
+	fmt.Println("tenant ID:      ", info.TenantID)
+	if info.AppID != "" {
+		fmt.Println("application ID: ", info.AppID)
+	}
+	if info.IdentityType != "" {
+		fmt.Println("identity type:  ", info.IdentityType)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/akos011221/velora/internal/breakglass"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/metrics"
+)
+
+// runBreakGlass activates, deactivates or prints break-glass mode.
+func runBreakGlass(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: velora breakglass activate|deactivate|status")
+	}
+
+	switch args[0] {
+	case "activate":
+		return runBreakGlassActivate(args[1:])
+	case "deactivate":
+		return runBreakGlassDeactivate(args[1:])
+	case "status":
+		return runBreakGlassStatus(args[1:])
+	default:
+		return fmt.Errorf("unknown breakglass command %q, usage: velora breakglass activate|deactivate|status", args[0])
+	}
+}
+
+// runBreakGlassActivate activates break-glass mode. The token is read from
+// the environment, never from a flag or the configuration.
+func runBreakGlassActivate(args []string) error {
+	fs := flag.NewFlagSet("breakglass activate", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	reason := fs.String("reason", "", "reason for break-glass mode, e.g. an incident number")
+	duration := fs.Duration("duration", 0, fmt.Sprintf("deactivate automatically after this duration, at most %s", breakglass.MaxDuration))
+	setBy := fs.String("by", os.Getenv("USER"), "who activates break-glass mode")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, manager, refreshMetrics, err := newBreakGlassManager(*configPath)
+	if err != nil {
+		return err
+	}
+
+	activation, err := manager.Activate(cfg.BreakGlass, os.Getenv(breakglass.TokenEnv), *reason, *setBy, *duration)
+	if err != nil {
+		return err
+	}
+	fmt.Println(activation)
+	fmt.Println("WARNING: approvals, onboarding acknowledgment and maintenance windows are bypassed until then, pauses and read-only mode still apply")
+	refreshMetrics()
+	return nil
+}
+
+// runBreakGlassDeactivate ends break-glass mode before it expires.
+func runBreakGlassDeactivate(args []string) error {
+	fs := flag.NewFlagSet("breakglass deactivate", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	by := fs.String("by", os.Getenv("USER"), "who deactivates break-glass mode")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	_, manager, refreshMetrics, err := newBreakGlassManager(*configPath)
+	if err != nil {
+		return err
+	}
+
+	if err := manager.Deactivate(*by); err != nil {
+		return err
+	}
+	refreshMetrics()
+	return nil
+}
+
+// runBreakGlassStatus prints the activation and the latest audit events.
+func runBreakGlassStatus(args []string) error {
+	fs := flag.NewFlagSet("breakglass status", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	events := fs.Int("events", 20, "number of audit events to print, the latest first")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	_, manager, _, err := newBreakGlassManager(*configPath)
+	if err != nil {
+		return err
+	}
+
+	activation, err := manager.Active()
+	if err != nil {
+		return err
+	}
+	if activation == nil {
+		fmt.Println("break-glass mode isn't active")
+	} else {
+		fmt.Printf("BREAK-GLASS MODE: %s, %d runs executed under it\n", activation, activation.Runs)
+	}
+
+	audit, err := manager.Events()
+	if err != nil {
+		return err
+	}
+	for i := len(audit) - 1; i >= 0 && i >= len(audit)-*events; i-- {
+		fmt.Println(audit[i])
+	}
+	return nil
+}
+
+// newBreakGlassManager creates a break-glass manager on the configured state
+// store, and a function updating the break-glass metric, if metrics are
+// enabled.
+func newBreakGlassManager(configPath string) (*config.Config, *breakglass.Manager, func(), error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	refreshMetrics := func() {
+		if cfg.Metrics.TextfilePath == "" {
+			return
+		}
+		if err := metrics.Refresh(store, cfg.Metrics.TextfilePath); err != nil {
+			fmt.Println("WARNING: metrics not written:", err)
+		}
+	}
+	return cfg, breakglass.NewManager(store), refreshMetrics, nil
+}
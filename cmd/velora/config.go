@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/preflight"
+)
+
+// runConfig handles the "config" command group.
+func runConfig(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: velora config schema|show|validate|status|activate|migrate [--config path]")
+	}
+
+	switch args[0] {
+	case "schema":
+		return runConfigSchema(args[1:])
+	case "show":
+		return runConfigShow(args[1:])
+	case "validate":
+		return runConfigValidate(args[1:])
+	case "status":
+		return runConfigStatus(args[1:])
+	case "activate":
+		return runConfigActivate(args[1:])
+	case "migrate":
+		return runConfigMigrate(args[1:])
+	default:
+		return fmt.Errorf("unknown config command: %s", args[0])
+	}
+}
+
+// runConfigValidate loads the configuration and prints its warnings. With
+// --deep it also checks the resources it references, with reads only.
+func runConfigValidate(args []string) error {
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	deep := fs.Bool("deep", false, "also check the referenced resources exist and the subscriptions are accessible")
+	output := fs.String("output", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output %q, allowed values are text, json", *output)
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil && *output == "text" {
+		return err
+	}
+	validation, err := validateConfig(cfg, err, *deep)
+	if err != nil {
+		return err
+	}
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(validation); err != nil {
+			return err
+		}
+	} else {
+		for _, issue := range validation.Warnings {
+			if issue.Subject == "config" {
+				fmt.Println("WARNING:", issue.Message)
+				continue
+			}
+			fmt.Printf("WARNING: %s: %s\n", issue.Subject, issue.Message)
+		}
+		for _, issue := range validation.Errors {
+			fmt.Printf("ERROR: %s: %s\n", issue.Subject, issue.Message)
+		}
+	}
+	if !validation.Valid {
+		return &exitError{code: 1, err: fmt.Errorf("configuration is invalid: %d errors", len(validation.Errors))}
+	}
+	if *output == "text" {
+		fmt.Printf("configuration is valid: %d hubs, %d subscriptions\n", len(cfg.Hubs), len(cfg.Subscriptions))
+	}
+	return nil
+}
+
+// validateConfig returns the validation of the configuration, loadErr is the
+// error of the static validation. The deep checks only read, the client
+// factory refuses writes.
+func validateConfig(cfg *config.Config, loadErr error, deep bool) (*preflight.Validation, error) {
+	if loadErr != nil {
+		return &preflight.Validation{
+			Errors:   []preflight.Issue{{Subject: "config", Message: loadErr.Error()}},
+			Warnings: []preflight.Issue{},
+		}, nil
+	}
+	if !deep {
+		validation := &preflight.Validation{Valid: true, Errors: []preflight.Issue{}, Warnings: []preflight.Issue{}}
+		for _, warning := range cfg.Warnings() {
+			validation.Warnings = append(validation.Warnings, preflight.Issue{Subject: "config", Message: warning})
+		}
+		return validation, nil
+	}
+
+	clientFactory, err := azure.NewClientFactory(&cfg.Azure)
+	if err != nil {
+		return nil, err
+	}
+	clientFactory.EnableReadOnly()
+	return preflight.Validate(context.Background(), cfg, clientFactory), nil
+}
+
+// runConfigShow prints the effective configuration with secrets redacted.
+func runConfigShow(args []string) error {
+	fs := flag.NewFlagSet("config show", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	withSources := fs.Bool("with-sources", false, "print the file each hub and subscription came from")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(cfg.Redacted(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	fmt.Println(string(out))
+	// on stderr, the output stays valid JSON
+	printTimezones(os.Stderr, cfg, time.Now())
+
+	if *withSources {
+		fmt.Println()
+		fmt.Println("Sources:")
+		for _, hub := range cfg.Hubs {
+			fmt.Printf("  hub %s: %s\n", hub.Name, cfg.Sources.Hubs[hub.Name])
+		}
+
+		subIDs := make([]string, 0, len(cfg.Subscriptions))
+		for subID := range cfg.Subscriptions {
+			subIDs = append(subIDs, subID)
+		}
+		sort.Strings(subIDs)
+		for _, subID := range subIDs {
+			fmt.Printf("  subscription %s: %s\n", subID, cfg.Sources.Subscriptions[subID])
+		}
+	}
+
+	return nil
+}
+
+// printTimezones prints how the times of the config are interpreted, so
+// operators can confirm what e.g. a window starting at 20:00 means.
+func printTimezones(w io.Writer, cfg *config.Config, now time.Time) {
+	fmt.Fprintln(w, "Time zones:")
+	fmt.Fprintln(w, "  persisted times: UTC")
+	for _, tz := range []struct {
+		field string
+		loc   *time.Location
+	}{
+		{"defaultTimezone", cfg.Location()},
+		{"displayTimezone", cfg.DisplayLocation()},
+	} {
+		name, offset := now.In(tz.loc).Zone()
+		fmt.Fprintf(w, "  %s: %s, currently %s (UTC%+03d:%02d)\n", tz.field, tz.loc, name, offset/3600, abs(offset%3600)/60)
+	}
+
+	window := cfg.Peering.MaintenanceWindow
+	if window == nil {
+		return
+	}
+	days := "every day"
+	if len(window.Days) > 0 {
+		days = strings.Join(window.Days, ", ")
+	}
+	loc := window.Location(cfg.Location())
+	fmt.Fprintf(w, "  peering.maintenanceWindow: %s to %s %s, %s", window.Start, window.End, loc, days)
+	if window.Contains(now, cfg.Location()) {
+		fmt.Fprintln(w, ", open now")
+		return
+	}
+	next := window.NextStart(now, cfg.Location())
+	fmt.Fprintf(w, ", next opens %s (%s)\n", next.Format(time.RFC3339), next.UTC().Format(time.RFC3339))
+}
+
+// abs returns the absolute value of n.
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// runConfigSchema prints the JSON Schema of the configuration file, for
+// editors and CI to check configurations before they're loaded.
+func runConfigSchema(args []string) error {
+	fs := flag.NewFlagSet("config schema", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(config.Schema(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode schema: %w", err)
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// runConfigMigrate prints the configuration files of an older version
+// migrated to the current one, or rewrites them with --write. Keys keep
+// their order, including the _comment keys.
+func runConfigMigrate(args []string) error {
+	fs := flag.NewFlagSet("config migrate", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	write := fs.Bool("write", false, "rewrite the files instead of printing them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *write && *configPath == config.StdinPath {
+		return fmt.Errorf("--write can't rewrite a configuration read from stdin")
+	}
+	files, err := config.MigrateFiles(*configPath)
+	if err != nil {
+		return err
+	}
+	migrated := 0
+	for _, file := range files {
+		if !file.Migrated() {
+			continue
+		}
+		migrated++
+		for _, warning := range file.Warnings {
+			fmt.Printf("WARNING: %s: %s\n", file.Path, warning)
+		}
+		if !*write {
+			fmt.Printf("%s, version %d to %d:\n%s", file.Path, file.From, config.CurrentVersion, file.Data)
+			continue
+		}
+		if err := writeFileAtomic(file.Path, file.Data); err != nil {
+			return err
+		}
+		fmt.Printf("migrated %s from version %d to %d\n", file.Path, file.From, config.CurrentVersion)
+	}
+
+	if migrated == 0 {
+		fmt.Printf("all %d files are already version %d\n", len(files), config.CurrentVersion)
+	} else if !*write {
+		fmt.Println("run with --write to rewrite the files")
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file with data, keeping its permissions, so
+// a failed write leaves the original in place.
+func writeFileAtomic(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+)
+
+func TestRunConfigMigrate(t *testing.T) {
+	// the test configuration declares no version, it is version 1
+	path := writeConfig(t, configtest.New(t))
+	if err := os.Chmod(path, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	original, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var runErr error
+	output := captureStdout(t, func() { runErr = runConfigMigrate([]string{"--config", path}) })
+	if runErr != nil {
+		t.Fatalf("config migrate error = %v", runErr)
+	}
+	if !strings.Contains(string(output), "version 1 to 2") || !strings.Contains(string(output), "run with --write") {
+		t.Errorf("config migrate output = %s, want the migrated file", output)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, original) {
+		t.Errorf("config migrate without --write rewrote the file")
+	}
+
+	output = captureStdout(t, func() { runErr = runConfigMigrate([]string{"--config", path, "--write"}) })
+	if runErr != nil {
+		t.Fatalf("config migrate --write error = %v", runErr)
+	}
+	if !strings.Contains(string(output), "migrated "+path+" from version 1 to 2") {
+		t.Errorf("config migrate --write output = %s", output)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() of the rewritten file error = %v", err)
+	}
+	if cfg.Version != config.CurrentVersion {
+		t.Errorf("rewritten file version = %d, want %d", cfg.Version, config.CurrentVersion)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("rewritten file mode = %v, want the original 0640 kept", info.Mode().Perm())
+	}
+	// the rewrite leaves no temporary file behind
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("files next to the config = %v, want the config only", entries)
+	}
+
+	output = captureStdout(t, func() { runErr = runConfigMigrate([]string{"--config", path}) })
+	if runErr != nil || !strings.Contains(string(output), "all 1 files are already version 2") {
+		t.Errorf("config migrate of the rewritten file = %s, %v", output, runErr)
+	}
+}
+
+func TestPrintTimezones(t *testing.T) {
+	// 2026-10-15 is a Thursday, Berlin is on CEST and New York on EDT
+	now := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		mutate func(*config.Config)
+		want   string
+	}{
+		{
+			name:   "defaults",
+			mutate: func(*config.Config) {},
+			want: `Time zones:
+  persisted times: UTC
+  defaultTimezone: UTC, currently UTC (UTC+00:00)
+  displayTimezone: UTC, currently UTC (UTC+00:00)
+`,
+		},
+		{
+			name: "window in defaultTimezone",
+			mutate: func(c *config.Config) {
+				c.DefaultTimezone = "Europe/Berlin"
+				c.DisplayTimezone = "America/New_York"
+				c.Peering.MaintenanceWindow = &config.MaintenanceWindow{Days: []string{"Saturday"}, Start: "20:00", End: "22:00"}
+			},
+			want: `Time zones:
+  persisted times: UTC
+  defaultTimezone: Europe/Berlin, currently CEST (UTC+02:00)
+  displayTimezone: America/New_York, currently EDT (UTC-04:00)
+  peering.maintenanceWindow: 20:00 to 22:00 Europe/Berlin, Saturday, next opens 2026-10-17T20:00:00+02:00 (2026-10-17T18:00:00Z)
+`,
+		},
+		{
+			name: "window with its own time zone, open now",
+			mutate: func(c *config.Config) {
+				c.DisplayTimezone = "Asia/Kolkata"
+				c.Peering.MaintenanceWindow = &config.MaintenanceWindow{Start: "11:00", End: "13:00", Timezone: "Europe/Berlin"}
+			},
+			want: `Time zones:
+  persisted times: UTC
+  defaultTimezone: UTC, currently UTC (UTC+00:00)
+  displayTimezone: Asia/Kolkata, currently IST (UTC+05:30)
+  peering.maintenanceWindow: 11:00 to 13:00 Europe/Berlin, every day, open now
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			printTimezones(&out, configtest.New(t, tt.mutate), now)
+			if out.String() != tt.want {
+				t.Errorf("printTimezones() =\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2026, 10, 25, 0, 30, 0, 0, time.UTC)
+	if got := formatTime(at, time.UTC); got != "2026-10-25T00:30:00Z" {
+		t.Errorf("formatTime() in UTC = %s", got)
+	}
+	// an hour later the clock is moved back, the offset tells the 02:30s apart
+	if got := formatTime(at, berlin); got != "2026-10-25T02:30:00+02:00" {
+		t.Errorf("formatTime() in Europe/Berlin = %s", got)
+	}
+	if got := formatTime(at.Add(time.Hour), berlin); got != "2026-10-25T02:30:00+01:00" {
+		t.Errorf("formatTime() an hour later in Europe/Berlin = %s", got)
+	}
+	if got := formatTime(time.Time{}, berlin); got != "-" {
+		t.Errorf("formatTime() of the zero time = %s, want -", got)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/configstage"
+)
+
+// runConfigActivate activates the staged config, runs apply it from then on.
+func runConfigActivate(args []string) error {
+	fs := flag.NewFlagSet("config activate", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	hash := fs.String("hash", "", "hash of the staged config, activation fails if another config is staged")
+	by := fs.String("by", os.Getenv("USER"), "who activates the config")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	manager, err := newConfigStageManager(*configPath)
+	if err != nil {
+		return err
+	}
+	stage, err := manager.Activate(*hash, *by)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("activated %s\n", stage)
+	return nil
+}
+
+// runConfigStatus prints the active, staged and previous configs with the
+// estimated impact of their changes.
+func runConfigStatus(args []string) error {
+	fs := flag.NewFlagSet("config status", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	output := fs.String("output", "text", "output format: text or json")
+	withConfig := fs.Bool("with-config", false, "include the configs, secrets redacted, in the json output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output %q, allowed values are text, json", *output)
+	}
+
+	manager, err := newConfigStageManager(*configPath)
+	if err != nil {
+		return err
+	}
+	st, err := manager.State()
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		if !*withConfig {
+			for _, stage := range []*configstage.Stage{st.Active, st.Staged, st.Previous} {
+				if stage != nil {
+					stage.Config = nil
+				}
+			}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	}
+
+	if st.Active == nil {
+		fmt.Println("no config has been run yet")
+		return nil
+	}
+	printStage("active", st.Active)
+	if st.Staged != nil {
+		printStage("staged", st.Staged)
+		fmt.Printf("  activate with velora config activate --hash %s\n", st.Staged.Hash)
+	}
+	if st.Previous != nil {
+		printStage("previous", st.Previous)
+	}
+	return nil
+}
+
+// printStage prints a config stage and the changes it made.
+func printStage(label string, stage *configstage.Stage) {
+	fmt.Printf("%-9s config %s, seen %s", label+":", stage.Hash, stage.SeenAt.Format(time.RFC3339))
+	if !stage.ActivatedAt.IsZero() {
+		fmt.Printf(", activated %s by %s", stage.ActivatedAt.Format(time.RFC3339), stage.ActivatedBy)
+	}
+	fmt.Println()
+	if stage.Impact == nil {
+		return
+	}
+	fmt.Printf("  estimated impact against config %s: %s\n", stage.Replaces, stage.Impact)
+	for _, change := range stage.Impact.Changes {
+		fmt.Printf("  %s\n", change)
+	}
+	for _, reason := range stage.Impact.NotEstimated {
+		fmt.Printf("  not estimated: %s\n", reason)
+	}
+}
+
+// newConfigStageManager creates a config stage manager on the configured
+// state store.
+func newConfigStageManager(configPath string) (*configstage.Manager, error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return configstage.NewManager(store), nil
+}
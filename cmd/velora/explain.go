@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/inventory"
+)
+
+// runExplain prints the routes of a subnet, the system routes derived from
+// the inventory alongside its user-defined routes, and which system routes
+// the user-defined ones override.
+func runExplain(args []string) error {
+	fs := flag.NewFlagSet("explain", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	subnetID := fs.String("subnet", "", "ID of the subnet")
+	refresh := fs.Bool("refresh", false, "collect the inventory of the subnet's subscription first")
+	output := fs.String("output", "text", "output format, text or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *subnetID == "" {
+		return fmt.Errorf("usage: velora explain [--config path] [--refresh] [--output text|json] --subnet id")
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output %q, allowed values are text, json", *output)
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	subscriptionID := azure.SubscriptionIDOf(*subnetID)
+	if *refresh {
+		if err := refreshInventory(cfg, subscriptionID); err != nil {
+			return err
+		}
+	}
+	snapshot, err := inventory.LoadSnapshot(store)
+	if err != nil {
+		return err
+	}
+
+	// routes are velora's if the subnet's hub owns them, service routes never are
+	var managed func(route inventory.RouteRecord) bool
+	hub := cfg.Hub(cfg.Subscriptions[subscriptionID].HubName)
+	if hub != nil {
+		serviceMatchers := cfg.EffectiveServiceRouteMatchers()
+		managed = func(route inventory.RouteRecord) bool {
+			return hub.OwnsRoute(route.Name, "") && config.ServiceOfRoute(serviceMatchers, route.RouteTableID, nil, route.Name) == ""
+		}
+	}
+	routes, err := inventory.EffectiveRoutes(snapshot, *subnetID, managed)
+	if err != nil {
+		return err
+	}
+	class, classErr := subnetClass(hub, snapshot, *subnetID)
+
+	if *output == "json" {
+		out := struct {
+			*inventory.SubnetRoutes
+			SubnetClass      *config.SubnetClassMatch `json:"subnetClass,omitempty"`
+			SubnetClassError string                   `json:"subnetClassError,omitempty"`
+		}{SubnetRoutes: routes, SubnetClass: class}
+		if classErr != nil {
+			out.SubnetClassError = classErr.Error()
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	if err := printExplain(routes, cfg.DisplayLocation()); err != nil {
+		return err
+	}
+	switch {
+	case classErr != nil:
+		fmt.Println("subnet class:", classErr)
+	case class != nil:
+		fmt.Println("subnet class:", class)
+	case hub != nil && len(hub.SubnetClasses) > 0:
+		fmt.Println("subnet class: none matched, the subscription's policy applies")
+	}
+	return nil
+}
+
+// subnetClass returns the subnet class of the hub the subnet matches, by
+// its name and the tags of its VNet in the snapshot.
+func subnetClass(hub *config.HubVNetConfig, snapshot *inventory.Snapshot, subnetID string) (*config.SubnetClassMatch, error) {
+	if hub == nil || len(hub.SubnetClasses) == 0 {
+		return nil, nil
+	}
+	for _, subnet := range snapshot.Subnets {
+		if !strings.EqualFold(subnet.ID, subnetID) {
+			continue
+		}
+		var tags map[string]string
+		for _, vnet := range snapshot.VNets {
+			if strings.EqualFold(vnet.ID, subnet.VNetID) {
+				tags = vnet.Tags
+				break
+			}
+		}
+		return hub.ClassifySubnet(tags, subnet.Name)
+	}
+	return nil, nil
+}
+
+// printExplain prints the routes of the subnet as a table.
+func printExplain(routes *inventory.SubnetRoutes, loc *time.Location) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tPREFIX\tNEXT HOP\tSTATUS\tORIGIN")
+	for _, r := range routes.Routes {
+		nextHop := r.NextHopType
+		if r.NextHopIP != "" {
+			nextHop += " " + r.NextHopIP
+		}
+		status := "active"
+		switch {
+		case !r.Active() && r.OverriddenByManaged:
+			status = "overridden by velora route " + r.OverriddenBy
+		case !r.Active():
+			status = "overridden by route " + r.OverriddenBy
+		case r.Managed:
+			status = "active, managed by velora"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Source, r.Prefix, nextHop, status, r.Origin)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for _, note := range routes.Notes {
+		fmt.Println("NOTE:", note)
+	}
+	fmt.Println("routes with different prefixes are chosen by longest prefix match, routes learned from gateways aren't shown")
+	fmt.Printf("inventory collected %s (%s ago)\n", formatTime(routes.CollectedAt, loc), time.Since(routes.CollectedAt).Round(time.Minute))
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/flapping"
+)
+
+// runFlapping handles the "flapping" command group.
+func runFlapping(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: velora flapping list|clear [--config path]")
+	}
+
+	switch args[0] {
+	case "list":
+		return runFlappingList(args[1:])
+	case "clear":
+		return runFlappingClear(args[1:])
+	default:
+		return fmt.Errorf("unknown flapping command: %s", args[0])
+	}
+}
+
+// runFlappingList prints the resources remediated within the flapping
+// window, and those whose remediation is held.
+func runFlappingList(args []string) error {
+	fs := flag.NewFlagSet("flapping list", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	resources, err := flapping.List(store)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REMEDIATIONS\tLAST REMEDIATED AT\tHELD SINCE\tRESOURCE")
+	for _, r := range resources {
+		last := "-"
+		if n := len(r.Remediations); n > 0 {
+			last = formatTime(r.Remediations[n-1], cfg.DisplayLocation())
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", len(r.Remediations), last, formatTime(r.HeldSince, cfg.DisplayLocation()), r.ResourceID)
+	}
+	return w.Flush()
+}
+
+// runFlappingClear clears the flag of held resources, so the next run
+// remediates them again.
+func runFlappingClear(args []string) error {
+	fs := flag.NewFlagSet("flapping clear", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	resource := fs.String("resource", "", "clear the resource ID and forget its remediations")
+	all := fs.Bool("all", false, "clear every held resource")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if (*resource == "") == !*all {
+		return fmt.Errorf("usage: velora flapping clear [--config path] --resource id|--all")
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	cleared, err := flapping.Clear(store, *resource)
+	if err != nil {
+		return err
+	}
+	if len(cleared) == 0 {
+		fmt.Println("no resource cleared")
+		return nil
+	}
+	for _, id := range cleared {
+		fmt.Println("cleared", id)
+	}
+	fmt.Printf("cleared %d resources, the next run remediates them again\n", len(cleared))
+	return nil
+}
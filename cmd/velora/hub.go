@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/failover"
+)
+
+// runHub handles the "hub" command group.
+func runHub(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: velora hub failover|failback|status [--config path] [--hub name]")
+	}
+
+	switch args[0] {
+	case "failover":
+		return runHubFailover(args[1:])
+	case "failback":
+		return runHubFailback(args[1:])
+	case "status":
+		return runHubStatus(args[1:])
+	default:
+		return fmt.Errorf("unknown hub command: %s", args[0])
+	}
+}
+
+// runHubFailover repoints the subscriptions of a hub to its failover hub.
+func runHubFailover(args []string) error {
+	fs := flag.NewFlagSet("hub failover", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	hub := fs.String("hub", "", "name of the hub to fail over")
+	setBy := fs.String("by", os.Getenv("USER"), "who triggers the failover")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, failovers, err := newFailoverManager(*configPath)
+	if err != nil {
+		return err
+	}
+
+	f, err := failovers.Failover(cfg, *hub, *setBy)
+	if err != nil {
+		return err
+	}
+	fmt.Println(f)
+	return nil
+}
+
+// runHubFailback returns the subscriptions of a hub to the hub itself.
+func runHubFailback(args []string) error {
+	fs := flag.NewFlagSet("hub failback", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	hub := fs.String("hub", "", "name of the hub to fail back")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	_, failovers, err := newFailoverManager(*configPath)
+	if err != nil {
+		return err
+	}
+
+	return failovers.Failback(*hub)
+}
+
+// runHubStatus prints the active failovers.
+func runHubStatus(args []string) error {
+	fs := flag.NewFlagSet("hub status", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	_, failovers, err := newFailoverManager(*configPath)
+	if err != nil {
+		return err
+	}
+
+	active, err := failovers.List()
+	if err != nil {
+		return err
+	}
+	if len(active) == 0 {
+		fmt.Println("no active failovers")
+	}
+	for _, f := range active {
+		fmt.Println(f)
+	}
+	return nil
+}
+
+// newFailoverManager creates a failover manager on the configured state store.
+func newFailoverManager(configPath string) (*config.Config, *failover.Manager, error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, failover.NewManager(store), nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/bootstrap"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/redact"
+)
+
+// runInit writes a starter configuration discovered from an existing hub
+// VNet. It only reads from Azure.
+func runInit(args []string) error {
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	hubVNetID := flags.String("hub-vnet", "", "resource ID of the hub VNet")
+	output := flags.String("output", "velora.json", "path of the configuration file to write")
+	nvaNextHop := flags.String("nva-next-hop", "", "private IP of the NVA, discovered from the hub NICs if not set")
+	force := flags.Bool("force", false, "overwrite an existing configuration file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *hubVNetID == "" {
+		return fmt.Errorf("usage: velora init --hub-vnet <resourceID> [--output path] [--nva-next-hop ip] [--force]")
+	}
+	if _, err := os.Stat(*output); err == nil && !*force {
+		return fmt.Errorf("%s already exists, pass --force to overwrite it", *output)
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to check %s: %w", *output, err)
+	}
+
+	clientFactory, err := azure.NewClientFactory(&config.AzureConfig{UseAzureIdentity: true})
+	if err != nil {
+		return err
+	}
+	// init must never change anything in Azure
+	clientFactory.EnableReadOnly()
+
+	discovery, err := bootstrap.Discover(context.Background(), clientFactory, *hubVNetID)
+	if err != nil {
+		return err
+	}
+
+	nextHop := *nvaNextHop
+	if nextHop == "" {
+		if nextHop, err = chooseNVA(discovery.NVACandidates); err != nil {
+			return err
+		}
+	}
+	if net.ParseIP(nextHop) == nil {
+		return fmt.Errorf("invalid NVA IP: %s", nextHop)
+	}
+	if len(discovery.Spokes) == 0 {
+		fmt.Println("WARNING: the hub has no peerings, the config has no subscriptions yet")
+	}
+
+	data, err := json.MarshalIndent(discovery.StarterConfig(nextHop), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := os.WriteFile(*output, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	// the written file must load as is
+	cfg, err := config.LoadConfig(*output)
+	if err != nil {
+		return fmt.Errorf("generated config %s doesn't validate: %w", *output, err)
+	}
+
+	fmt.Printf("wrote %s: hub %s (NVA %s), %d spokes in %d subscriptions\n",
+		*output, discovery.Name, nextHop, len(discovery.Spokes), len(cfg.Subscriptions))
+	fmt.Println("all features only observe, run velora scan --config", *output, "to review the findings")
+	return nil
+}
+
+// chooseNVA returns the IP of the only NVA candidate, or prompts for it if
+// there are none or several.
+func chooseNVA(candidates []bootstrap.NVACandidate) (string, error) {
+	if len(candidates) == 1 {
+		fmt.Printf("using NVA %s (%s)\n", candidates[0].PrivateIP, candidates[0].NICID)
+		return candidates[0].PrivateIP, nil
+	}
+
+	if len(candidates) == 0 {
+		fmt.Println("no NIC with IP forwarding enabled found in the hub VNet")
+	} else {
+		fmt.Println("several NICs with IP forwarding enabled found in the hub VNet:")
+		for i, c := range candidates {
+			fmt.Printf("  %d) %s (%s)\n", i+1, c.PrivateIP, c.NICID)
+		}
+	}
+	// the prompt has no newline, it must not wait in the redacted output
+	if err := redact.Prompt("NVA next hop IP (or number): "); err != nil {
+		return "", err
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer := strings.TrimSpace(line)
+	if answer == "" {
+		if err != nil {
+			return "", fmt.Errorf("failed to read the NVA next hop, pass --nva-next-hop: %w", err)
+		}
+		return "", fmt.Errorf("no NVA next hop given, pass --nva-next-hop")
+	}
+	if n, err := strconv.Atoi(answer); err == nil {
+		if n < 1 || n > len(candidates) {
+			return "", fmt.Errorf("invalid choice %d", n)
+		}
+		return candidates[n-1].PrivateIP, nil
+	}
+	return answer, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/inventory"
+	"github.com/akos011221/velora/internal/limits"
+)
+
+// runLimits prints the consumption of the Azure networking limits per
+// subscription, warning about those above the threshold. It only reads.
+func runLimits(args []string) error {
+	fs := flag.NewFlagSet("limits", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	output := fs.String("output", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output %q, allowed values are text, json", *output)
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	clientFactory, err := azure.NewClientFactory(&cfg.Azure)
+	if err != nil {
+		return err
+	}
+	clientFactory.EnableReadOnly()
+
+	runInventory := inventory.NewRunInventory(clientFactory, cfg.Inventory.EffectiveMaxCachedResources())
+	report := limits.Collect(context.Background(), cfg, clientFactory, runInventory)
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		for _, warning := range report.Warnings {
+			fmt.Println("WARNING:", warning)
+		}
+		failed := make([]string, 0, len(report.Failed))
+		for subID := range report.Failed {
+			failed = append(failed, subID)
+		}
+		sort.Strings(failed)
+		for _, subID := range failed {
+			fmt.Printf("ERROR: subscription %s: %s\n", subID, report.Failed[subID])
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SUBSCRIPTION\tLIMIT\tUSED\tMAX\tUTILIZATION\tSOURCE\tSCOPE")
+		for _, u := range report.Usages {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.0f%%\t%s\t%s\n", u.SubscriptionID, u.Limit, u.Used, u.Max, 100*u.Utilization(), u.Source, u.Scope)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	if len(report.Failed) > 0 {
+		return &exitError{code: 1, err: fmt.Errorf("failed to read the limits of %d subscriptions", len(report.Failed))}
+	}
+	return nil
+}
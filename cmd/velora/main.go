@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+	// time zones of the config resolve without a zoneinfo database on the host
+	_ "time/tzdata"
+
+	"github.com/akos011221/velora/internal/redact"
+)
+
+const usage = `Usage: velora <command> [arguments]
+
+Commands:
+  apply         apply the unchanged resources of a plan
+  auth check    acquire an ARM token and print the resolved identity
+  breakglass    activate, deactivate or print the time-limited break-glass mode
+                bypassing approvals and maintenance windows
+  config schema print the JSON Schema of the configuration file
+  config show   print the effective configuration
+  config validate
+                check a configuration file loads and validates, with --deep
+                also that the resources it references exist in Azure
+  config status print the active, staged and previous configs and the
+                estimated impact of their changes
+  config activate
+                activate the config staged because of its estimated impact
+  config migrate
+                upgrade configuration files of older velora versions to the
+                current format, with --write rewrite them in place
+  explain       print the system routes of a subnet derived from the
+                inventory alongside its route table, and which it overrides
+  flapping      list the resources remediated run after run and clear those
+                whose remediation is held
+  hub           fail hubs over to their failover hub and back
+  init          write a starter configuration from an existing hub VNet
+  limits        print the consumption of the Azure networking limits
+  managed       list the resources velora manages
+  notifications list the findings alerted and flush them, so the next run
+                alerts them again
+  nsg           list and roll back the NSG associations velora made
+  pause         stop velora from making changes
+  plan          write the changes enforcement would make to a signed plan
+  preflight     check access to the managed subscriptions
+  queue         enqueue and process targeted enforcement runs, list dead letters
+  resume        remove a pause
+  scores        print the network posture score of every subscription and its trend
+  search        search the collected inventory for routes, peerings and subnets
+  scan          evaluate compliance without making changes, for pipelines
+  selftest      check a deployment is healthy
+  shards        print which shard owns each subscription and the instances' claims
+  slo           print the time-to-remediation statistics and SLO breaches
+  stats         print the compliance and findings statistics over time
+  subscriptions list new subscriptions and acknowledge them to start remediation
+  support-bundle
+                package the configuration, recent runs, logs and health of
+                the deployment into a sanitized zip for support
+  trace         log everything velora does about one resource for a while
+  ui            serve the read-only web UI of the runs, findings, coverage,
+                pauses and configuration
+  version       print the build metadata
+  webhook       send a signed sample event to a webhook, list the events
+                webhooks didn't accept
+
+The configuration is read from --config, else VELORA_CONFIG, else
+~/.velora/config.json. --config - reads it from stdin. Without the default
+file, the VELORA_AZURE_* and VELORA_HUB_DISCOVERY_SUBSCRIPTIONS variables
+alone configure velora.
+`
+
+// exitError makes velora exit with a specific code, err is printed if set.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit code %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func main() {
+	// everything printed goes through the redaction of registered secrets
+	restore, err := redact.Stdio()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: failed to set up output redaction:", err)
+		os.Exit(1)
+	}
+
+	code := runRecovered(os.Args[1:])
+	restore()
+	os.Exit(code)
+}
+
+// runRecovered runs the command line and returns the exit code. Panics are
+// recovered, so their message and stack are redacted too.
+func runRecovered(args []string) (code int) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "panic: %v\n\n%s", r, debug.Stack())
+			code = 1
+		}
+	}()
+
+	err := run(args)
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		if exitErr.err != nil {
+			fmt.Fprintln(os.Stderr, "error:", exitErr.err)
+		}
+		return exitErr.code
+	}
+	fmt.Fprintln(os.Stderr, "error:", err)
+	return 1
+}
+
+// run dispatches the command line to the matching command.
+func run(args []string) error {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("no command given")
+	}
+
+	switch args[0] {
+	case "apply":
+		return runApply(args[1:])
+	case "auth":
+		return runAuth(args[1:])
+	case "breakglass":
+		return runBreakGlass(args[1:])
+	case "config":
+		return runConfig(args[1:])
+	case "explain":
+		return runExplain(args[1:])
+	case "flapping":
+		return runFlapping(args[1:])
+	case "hub":
+		return runHub(args[1:])
+	case "init":
+		return runInit(args[1:])
+	case "limits":
+		return runLimits(args[1:])
+	case "managed":
+		return runManaged(args[1:])
+	case "notifications":
+		return runNotifications(args[1:])
+	case "nsg":
+		return runNSG(args[1:])
+	case "pause":
+		return runPause(args[1:])
+	case "plan":
+		return runPlan(args[1:])
+	case "preflight":
+		return runPreflight(args[1:])
+	case "queue":
+		return runQueue(args[1:])
+	case "resume":
+		return runResume(args[1:])
+	case "scan":
+		return runScan(args[1:])
+	case "scores":
+		return runScores(args[1:])
+	case "search":
+		return runSearch(args[1:])
+	case "selftest":
+		return runSelftest(args[1:])
+	case "shards":
+		return runShards(args[1:])
+	case "slo":
+		return runSLO(args[1:])
+	case "stats":
+		return runStats(args[1:])
+	case "subscriptions":
+		return runSubscriptions(args[1:])
+	case "support-bundle":
+		return runSupportBundle(args[1:])
+	case "trace":
+		return runTrace(args[1:])
+	case "ui":
+		return runUI(args[1:])
+	case "version":
+		return runVersion()
+	case "webhook":
+		return runWebhook(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+}
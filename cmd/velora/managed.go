@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/managed"
+)
+
+// runManaged lists the resources velora manages, optionally only the stale ones.
+func runManaged(args []string) error {
+	fs := flag.NewFlagSet("managed", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	staleAfter := fs.Int("stale-after", 0, "only list resources not touched in this many runs")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	clientFactory, err := azure.NewClientFactory(&cfg.Azure)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	resources, err := managed.List(context.Background(), cfg, clientFactory, store)
+	if err != nil {
+		return err
+	}
+
+	type key struct{ subscription, hub string }
+	counts := make(map[key]int)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SUBSCRIPTION\tHUB\tKIND\tFIRST SEEN\tLAST TOUCHED\tRUNS SINCE\tRESOURCE")
+	for _, r := range resources {
+		if r.RunsSinceTouched < *staleAfter {
+			continue
+		}
+		counts[key{r.SubscriptionID, r.Hub}]++
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", r.SubscriptionID, r.Hub, r.Kind,
+			formatTime(r.FirstSeen, cfg.DisplayLocation()), formatTime(r.LastTouched, cfg.DisplayLocation()), r.RunsSinceTouched, r.ID)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "SUBSCRIPTION\tHUB\tCOUNT")
+	for k, n := range counts {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", k.subscription, k.hub, n)
+	}
+	return w.Flush()
+}
+
+// formatTime formats a time for tables in the display location, "-" if
+// it's zero.
+func formatTime(t time.Time, loc *time.Location) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.In(loc).Format(time.RFC3339)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/notifications"
+)
+
+// runNotifications handles the "notifications" command group.
+func runNotifications(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: velora notifications list|flush [--config path]")
+	}
+
+	switch args[0] {
+	case "list":
+		return runNotificationsList(args[1:])
+	case "flush":
+		return runNotificationsFlush(args[1:])
+	default:
+		return fmt.Errorf("unknown notifications command: %s", args[0])
+	}
+}
+
+// runNotificationsList prints the findings alerted and still open, whose
+// alerts the throttle suppresses.
+func runNotificationsList(args []string) error {
+	fs := flag.NewFlagSet("notifications list", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	notified, err := notifications.NewThrottle(store, cfg.Notifications.Throttle).List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NOTIFIED AT\tSEVERITY\tRULE\tSUPPRESSED\tRESOURCE")
+	for _, n := range notified {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", formatTime(n.NotifiedAt, cfg.DisplayLocation()), n.Severity, n.RuleID, n.Suppressed, n.ResourceID)
+	}
+	return w.Flush()
+}
+
+// runNotificationsFlush forgets the alerted findings, so the next run alerts
+// them again.
+func runNotificationsFlush(args []string) error {
+	fs := flag.NewFlagSet("notifications flush", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	rule := fs.String("rule", "", "only flush the findings of the rule ID")
+	resource := fs.String("resource", "", "only flush the findings of the resource ID")
+	all := fs.Bool("all", false, "flush every alerted finding")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if (*rule == "" && *resource == "") == !*all {
+		return fmt.Errorf("usage: velora notifications flush [--config path] --rule id|--resource id|--all")
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	flushed, err := notifications.NewThrottle(store, cfg.Notifications.Throttle).Flush(*rule, *resource)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("flushed %d alerted findings, the next run alerts them again if still reported\n", flushed)
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/controllers/nsg"
+	"github.com/akos011221/velora/internal/guard"
+	"github.com/akos011221/velora/internal/pause"
+)
+
+// runNSG handles the "nsg" command group.
+func runNSG(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: velora nsg list|rollback [--config path]")
+	}
+
+	switch args[0] {
+	case "list":
+		return runNSGList(args[1:])
+	case "rollback":
+		return runNSGRollback(args[1:])
+	default:
+		return fmt.Errorf("unknown nsg command: %s", args[0])
+	}
+}
+
+// runNSGList prints the NSG associations velora made.
+func runNSGList(args []string) error {
+	fs := flag.NewFlagSet("nsg list", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	associations, err := nsg.NewJournal(store).List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ASSOCIATED AT\tSUBSCRIPTION\tNSG\tPREVIOUS NSG\tSUBNET")
+	for _, a := range associations {
+		previous := a.PreviousNSGID
+		if previous == "" {
+			previous = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", formatTime(a.AssociatedAt, cfg.DisplayLocation()), a.SubscriptionID, a.NSGID, previous, a.SubnetID)
+	}
+	return w.Flush()
+}
+
+// runNSGRollback restores the NSGs subnets had before velora associated the
+// baseline NSG.
+func runNSGRollback(args []string) error {
+	fs := flag.NewFlagSet("nsg rollback", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	subnet := fs.String("subnet", "", "ID of the subnet to roll back")
+	all := fs.Bool("all", false, "roll back every recorded association")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if (*subnet == "") == !*all {
+		return fmt.Errorf("usage: velora nsg rollback [--config path] --subnet id|--all")
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if cfg.ReadOnly {
+		return fmt.Errorf("NSG associations can't be rolled back in read-only mode")
+	}
+	clientFactory, err := azure.NewClientFactory(&cfg.Azure)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	journal := nsg.NewJournal(store)
+	g := guard.New(pause.NewManager(store))
+
+	associations, err := journal.List()
+	if err != nil {
+		return err
+	}
+	if *subnet != "" {
+		associations = []nsg.Association{{SubnetID: *subnet, SubscriptionID: azure.SubscriptionIDOf(*subnet)}}
+	}
+
+	failed := 0
+	for _, a := range associations {
+		if !g.WritesAllowed(a.SubscriptionID) {
+			failed++
+			continue
+		}
+		if err := journal.Rollback(context.Background(), clientFactory, a.SubnetID); err != nil {
+			fmt.Println("WARNING:", err)
+			failed++
+			continue
+		}
+		fmt.Printf("rolled back NSG association of subnet %s\n", a.SubnetID)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d associations were not rolled back", failed, len(associations))
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/metrics"
+	"github.com/akos011221/velora/internal/pause"
+	"github.com/akos011221/velora/internal/webhooks"
+)
+
+// runPause pauses enforcement globally or for one subscription.
+func runPause(args []string) error {
+	fs := flag.NewFlagSet("pause", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	subscription := fs.String("subscription", pause.GlobalScope, "pause only this subscription")
+	reason := fs.String("reason", "", "reason for the pause, e.g. an incident number")
+	duration := fs.Duration("duration", 0, "resume automatically after this duration")
+	haltObservation := fs.Bool("halt-observation", false, "also stop evaluation, not only writes")
+	setBy := fs.String("by", os.Getenv("USER"), "who sets the pause")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	pauses, refreshMetrics, notifyWebhooks, err := newPauseManager(*configPath)
+	if err != nil {
+		return err
+	}
+
+	p, err := pauses.Pause(*subscription, *reason, *setBy, *duration, *haltObservation)
+	if err != nil {
+		return err
+	}
+	fmt.Println(p)
+	refreshMetrics()
+	notifyWebhooks(p)
+	return nil
+}
+
+// runResume removes a pause.
+func runResume(args []string) error {
+	fs := flag.NewFlagSet("resume", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	subscription := fs.String("subscription", pause.GlobalScope, "resume only this subscription")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	pauses, refreshMetrics, _, err := newPauseManager(*configPath)
+	if err != nil {
+		return err
+	}
+
+	if err := pauses.Resume(*subscription); err != nil {
+		return err
+	}
+	refreshMetrics()
+	return nil
+}
+
+// newPauseManager creates a pause manager on the configured state store,
+// a function updating the paused metric, if metrics are enabled, and one
+// posting a pause to the webhooks. Webhooks failing don't fail the command.
+func newPauseManager(configPath string) (*pause.Manager, func(), func(p *pause.Pause), error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	refreshMetrics := func() {
+		if cfg.Metrics.TextfilePath == "" {
+			return
+		}
+		if err := metrics.Refresh(store, cfg.Metrics.TextfilePath); err != nil {
+			fmt.Println("WARNING: metrics not written:", err)
+		}
+	}
+	notifyWebhooks := func(p *pause.Pause) {
+		if len(cfg.Notifications.Webhooks) == 0 {
+			return
+		}
+		event, err := webhooks.PauseActivated(p)
+		if err != nil {
+			fmt.Println("WARNING: webhooks not notified:", err)
+			return
+		}
+		outcomes, err := webhooks.NewNotifier(cfg.Notifications.Webhooks, store).Deliver(context.Background(), []webhooks.Event{event})
+		if err != nil {
+			fmt.Println("WARNING: webhooks not notified:", err)
+		}
+		for _, o := range outcomes {
+			if o.Error != "" {
+				fmt.Printf("WARNING: pause dead-lettered for webhook %s: %s\n", o.Endpoint, o.Error)
+			}
+		}
+	}
+	return pause.NewManager(store), refreshMetrics, notifyWebhooks, nil
+}
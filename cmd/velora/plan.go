@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/guard"
+	"github.com/akos011221/velora/internal/pause"
+	"github.com/akos011221/velora/internal/plan"
+	"github.com/akos011221/velora/internal/runner"
+)
+
+// runPlan scans all subscriptions and writes the changes enforcement would
+// make to a signed plan file, without making them.
+func runPlan(args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	out := fs.String("out", "plan.json", "path of the plan file")
+	expectNoChanges := fs.Bool("expect-no-changes", false, "fail if enforcement would make any change, e.g. to check a run right after remediation is idempotent")
+	format := fs.String("format", "text", "how the changes are printed, text for one line per change or diff for their properties before and after")
+	diffOut := fs.String("diff-out", "", "also write the diff to this file, e.g. to attach it to a change request")
+	maxChanges := fs.Int("max-changes", plan.DefaultDiffMaxChanges, "changes the diff shows, the others are counted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "diff" {
+		return fmt.Errorf("unknown format %q, allowed values are text, diff", *format)
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	// fail before scanning if the plan can't be signed
+	if cfg.Plans.SigningKey == "" {
+		return fmt.Errorf("plans.signingKey is required to sign and verify plans")
+	}
+	clientFactory, err := azure.NewClientFactory(&cfg.Azure)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	p := plan.New(cfg)
+	r := runner.New(cfg, clientFactory, store)
+	r.Guard().SetPlan(plan.NewRecorder(p))
+
+	if _, err := r.Run(context.Background()); err != nil {
+		return err
+	}
+
+	if *format == "diff" {
+		if err := p.WriteDiff(os.Stdout, plan.DiffOptions{MaxChanges: *maxChanges}); err != nil {
+			return err
+		}
+	} else {
+		for _, change := range p.Changes {
+			fmt.Println(change.Description)
+		}
+	}
+	if err := p.Write(*out, cfg.Plans.SigningKey); err != nil {
+		return err
+	}
+	if *diffOut != "" {
+		if err := writeDiffFile(*diffOut, p, *maxChanges); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%d changes written to %s\n", len(p.Changes), *out)
+	if *expectNoChanges && len(p.Changes) > 0 {
+		return &exitError{code: 1, err: fmt.Errorf("%d changes planned, expected none", len(p.Changes))}
+	}
+	return nil
+}
+
+// writeDiffFile writes the diff of the plan to path.
+func writeDiffFile(path string, p *plan.Plan, maxChanges int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to write diff: %w", err)
+	}
+	if err := p.WriteDiff(f, plan.DiffOptions{MaxChanges: maxChanges}); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write diff: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write diff: %w", err)
+	}
+	return nil
+}
+
+// runApply applies the changes of a plan whose resources are unchanged.
+func runApply(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: velora apply [--config path] plan.json")
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if cfg.ReadOnly {
+		return fmt.Errorf("plans can't be applied in read-only mode")
+	}
+	p, err := plan.Read(fs.Arg(0), cfg.Plans.SigningKey)
+	if err != nil {
+		return err
+	}
+	if p.ConfigHash != cfg.Hash() {
+		fmt.Printf("WARNING: the plan was created with config %s, the current config is %s\n", p.ConfigHash, cfg.Hash())
+	}
+
+	clientFactory, err := azure.NewClientFactory(&cfg.Azure)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	results := plan.Apply(context.Background(), p, clientFactory, guard.New(pause.NewManager(store)))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANGE\tSTATUS\tDETAIL")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Change.Description, r.Status, strings.Join(strings.Fields(r.Detail), " "))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Println(plan.Summary(results))
+
+	var denials []*azure.PolicyDenial
+	for _, r := range results {
+		if r.Denial != nil {
+			denials = append(denials, r.Denial)
+		}
+	}
+	for _, counted := range runner.CountPolicyDenials(denials) {
+		fmt.Printf("Azure Policy %s blocked %d changes: %s\n", counted.AssignmentName, counted.Blocked, counted.AssignmentID)
+	}
+
+	for _, r := range results {
+		if r.Status == plan.StatusStale || r.Status == plan.StatusFailed || r.Status == plan.StatusBlocked {
+			return fmt.Errorf("not all changes were applied")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/preflight"
+)
+
+// runPreflight runs the preflight checks and prints the access per subscription.
+func runPreflight(args []string) error {
+	fs := flag.NewFlagSet("preflight", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	clientFactory, err := azure.NewClientFactory(&cfg.Azure)
+	if err != nil {
+		return err
+	}
+
+	report := preflight.Run(context.Background(), cfg, clientFactory)
+
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	if err := report.TrackInactive(store); err != nil {
+		return err
+	}
+
+	for _, warning := range report.Warnings {
+		fmt.Println("WARNING:", warning)
+	}
+	for _, note := range report.Notes {
+		fmt.Println(note)
+	}
+	for _, f := range report.Findings {
+		fmt.Printf("[%s] %s %s\n", f.Severity, f.RuleID, f.Message)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HUB\tNEXT HOP\tSOURCE\tOWNER\tERROR")
+	for _, hub := range report.Hubs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", hub.Name, hub.NextHop, hub.Source, hub.NextHopOwner, hub.Error)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "SUBSCRIPTION\tACCESS\tDETAIL")
+	for _, sub := range report.Subscriptions {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", sub.SubscriptionID, sub.Access, sub.Detail)
+	}
+	return w.Flush()
+}
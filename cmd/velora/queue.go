@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/configstage"
+	"github.com/akos011221/velora/internal/runner"
+	"github.com/akos011221/velora/internal/state"
+	"github.com/akos011221/velora/internal/workqueue"
+)
+
+// runQueue handles the "queue" command group.
+func runQueue(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: velora queue enqueue|work|status|deadletter [--config path]")
+	}
+
+	switch args[0] {
+	case "enqueue":
+		return runQueueEnqueue(args[1:])
+	case "work":
+		return runQueueWork(args[1:])
+	case "status":
+		return runQueueStatus(args[1:])
+	case "deadletter":
+		return runQueueDeadLetter(args[1:])
+	default:
+		return fmt.Errorf("unknown queue command: %s", args[0])
+	}
+}
+
+// openQueue loads the configuration and opens its state store and work
+// queue. With controllers, the queue of the role running them is opened:
+// each role has its own.
+func openQueue(configPath, controllers string) (*config.Config, state.Store, *workqueue.Queue, error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := cfg.SetControllers(controllers); err != nil {
+		return nil, nil, nil, err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// the credential is only needed for a Storage queue
+	var cred azcore.TokenCredential
+	if cfg.Queue != nil && cfg.Queue.EffectiveBackend() == config.QueueBackendStorage {
+		clientFactory, err := azure.NewClientFactory(&cfg.Azure)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		cred = clientFactory.GetCredential()
+	}
+	queue, err := workqueue.Open(cfg, store, cred)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return cfg, store, queue, nil
+}
+
+// runQueueEnqueue adds a targeted enforcement item for a resource group or
+// VNet, unless an identical item is waiting.
+func runQueueEnqueue(args []string) error {
+	fs := flag.NewFlagSet("queue enqueue", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	controllers := fs.String("controllers", "", "comma-separated controllers of the role, e.g. nsg,egress, instead of the controllers setting")
+	scope := fs.String("scope", "", "resource group or VNet ID to enforce")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *scope == "" {
+		return fmt.Errorf("usage: velora queue enqueue [--config path] --scope id")
+	}
+
+	cfg, _, queue, err := openQueue(*configPath, *controllers)
+	if err != nil {
+		return err
+	}
+	if _, err := scopedConfig(cfg, *scope); err != nil {
+		return err
+	}
+
+	item, added, err := queue.Enqueue(context.Background(), *scope, workqueue.SourceCLI)
+	if err != nil {
+		return err
+	}
+	if !added {
+		fmt.Printf("item %s for %s is already waiting, enqueued at %s\n", item.ID, item.Scope, formatTime(item.EnqueuedAt, cfg.DisplayLocation()))
+		return nil
+	}
+	fmt.Printf("enqueued item %s for %s\n", item.ID, item.Scope)
+	return nil
+}
+
+// runQueueWork processes the items of the queue with targeted enforcement
+// runs until interrupted, or with --until-empty until the queue is empty.
+func runQueueWork(args []string) error {
+	fs := flag.NewFlagSet("queue work", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	controllers := fs.String("controllers", "", "comma-separated controllers of the role, e.g. nsg,egress, instead of the controllers setting")
+	untilEmpty := fs.Bool("until-empty", false, "stop once no item is waiting")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, store, queue, err := openQueue(*configPath, *controllers)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("processing the work queue with %d workers\n", cfg.Queue.EffectiveWorkers())
+	queue.Work(ctx, func(ctx context.Context, item workqueue.Item, attempt int) error {
+		return enforceQueueItem(ctx, cfg, store, item, attempt)
+	}, *untilEmpty, cfg.Metrics.TextfilePath)
+	return nil
+}
+
+// enforceQueueItem runs enforcement limited to the resource group of the
+// item and prints the findings inside its scope.
+func enforceQueueItem(ctx context.Context, cfg *config.Config, store state.Store, item workqueue.Item, attempt int) error {
+	// a staged config is only observed, activating it takes effect on the next item
+	staged, err := configstage.NewManager(store).Reconcile(cfg)
+	if err != nil {
+		return err
+	}
+	scoped, err := scopedConfig(cfg, item.Scope)
+	if err != nil {
+		return err
+	}
+	clientFactory, err := azure.NewClientFactory(&scoped.Azure)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("queue item %s: enforcing %s, attempt %d\n", item.ID, item.Scope, attempt)
+	r := runner.New(scoped, clientFactory, store)
+	r.SetQueueItem(item.ID)
+	r.SetScope(item.Scope)
+	r.SetStagedConfig(staged)
+	result, err := r.Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the subscription wasn't evaluated, e.g. it's paused or unreachable
+	subID := scoped.SubscriptionIDs()[0]
+	if reason, skipped := result.Skipped[subID]; skipped {
+		return fmt.Errorf("subscription %s skipped: %s", subID, strings.Join(strings.Fields(reason), " "))
+	}
+	found := inScope(result.Findings, item.Scope)
+	for _, f := range found {
+		fmt.Printf("queue item %s: [%s] %s %s\n", item.ID, f.Severity, f.RuleID, f.Message)
+	}
+	fmt.Printf("queue item %s: done, %d findings in %s\n", item.ID, len(found), item.Scope)
+	return nil
+}
+
+// runQueueStatus prints the depth of the queue and the size of the
+// dead-letter list.
+func runQueueStatus(args []string) error {
+	fs := flag.NewFlagSet("queue status", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	controllers := fs.String("controllers", "", "comma-separated controllers of the role, e.g. nsg,egress, instead of the controllers setting")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, _, queue, err := openQueue(*configPath, *controllers)
+	if err != nil {
+		return err
+	}
+	depth, err := queue.Depth(context.Background())
+	if err != nil {
+		return err
+	}
+	deadLetters, err := queue.DeadLetters()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("backend:      %s\n", cfg.Queue.EffectiveBackend())
+	fmt.Printf("depth:        %d\n", depth)
+	fmt.Printf("dead letters: %d\n", len(deadLetters))
+	return nil
+}
+
+// runQueueDeadLetter prints the items that failed every attempt.
+func runQueueDeadLetter(args []string) error {
+	fs := flag.NewFlagSet("queue deadletter", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	controllers := fs.String("controllers", "", "comma-separated controllers of the role, e.g. nsg,egress, instead of the controllers setting")
+	output := fs.String("output", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output %q, allowed values are text, json", *output)
+	}
+
+	cfg, _, queue, err := openQueue(*configPath, *controllers)
+	if err != nil {
+		return err
+	}
+	deadLetters, err := queue.DeadLetters()
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(deadLetters)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DEAD-LETTERED AT\tITEM\tSOURCE\tATTEMPTS\tSCOPE\tERROR")
+	for _, d := range deadLetters {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", formatTime(d.At, cfg.DisplayLocation()), d.Item.ID, d.Item.Source, d.Attempts, d.Item.Scope,
+			strings.Join(strings.Fields(d.Error), " "))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/plan"
+	"github.com/akos011221/velora/internal/runner"
+)
+
+// newClientFactory creates the client factory of the scan, tests replace it
+// to scan a fake ARM.
+var newClientFactory = azure.NewClientFactory
+
+// runScan evaluates compliance without making changes and exits with the
+// code of runner.NewOutput, see the Exit constants of the runner package.
+func runScan(args []string) error {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	output := fs.String("output", "text", "output format, text or json")
+	failOn := fs.String("fail-on", string(findings.SeverityHigh), "lowest severity failing the scan")
+	scope := fs.String("scope", "", "resource group or VNet ID to scan, only its resource group is listed and only findings inside it are reported")
+	timings := fs.Bool("timings", false, "print the latency and list pages of the ARM requests per operation, the JSON output always has them")
+	controllers := fs.String("controllers", "", "comma-separated controllers to evaluate, e.g. nsg,egress, instead of the controllers setting")
+	if err := fs.Parse(args); err != nil {
+		return &exitError{code: runner.ExitError, err: err}
+	}
+
+	if err := scan(*configPath, *output, findings.Severity(*failOn), *scope, *controllers, *timings); err != nil {
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			return err
+		}
+		return &exitError{code: runner.ExitError, err: err}
+	}
+	return nil
+}
+
+// scan runs the scan and prints its output.
+func scan(configPath, output string, failOn findings.Severity, scope, controllers string, timings bool) error {
+	if output != "text" && output != "json" {
+		return fmt.Errorf("unknown output %q, allowed values are text, json", output)
+	}
+	if !failOn.Valid() {
+		return fmt.Errorf("unknown severity %q for --fail-on", failOn)
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	configHash := cfg.Hash()
+	if err := cfg.SetControllers(controllers); err != nil {
+		return err
+	}
+	if scope != "" {
+		if cfg, err = scopedConfig(cfg, scope); err != nil {
+			return err
+		}
+	}
+
+	clientFactory, err := newClientFactory(&cfg.Azure)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	// the run logs to stdout, which must only hold the JSON document
+	stdout := os.Stdout
+	if output == "json" {
+		os.Stdout = os.Stderr
+	}
+	r := runner.New(cfg, clientFactory, store)
+	// writes are recorded in a plan that is thrown away, a scan never writes
+	r.Guard().SetPlan(plan.NewRecorder(plan.New(cfg)))
+	r.SetScope(scope)
+	result, err := r.Run(context.Background())
+	os.Stdout = stdout
+	if err != nil {
+		return err
+	}
+
+	// the output is streamed, a large scan's isn't held in memory twice
+	result.Metadata.ConfigHash = configHash
+	var writer runner.OutputWriter
+	if output == "json" {
+		writer = runner.NewJSONOutputWriter(os.Stdout, failOn, scope)
+	} else {
+		writer = newTextOutputWriter(failOn, scope, timings)
+	}
+	for _, f := range result.Findings {
+		if scope != "" && !resourceInScope(f.ResourceID, scope) {
+			continue
+		}
+		if err := writer.Add(f); err != nil {
+			return err
+		}
+	}
+	summary, err := writer.Flush(result)
+	if err != nil {
+		return err
+	}
+
+	if summary.ExitCode != runner.ExitCompliant {
+		return &exitError{code: summary.ExitCode}
+	}
+	return nil
+}
+
+// scopedConfig returns the config limited to the subscription of the scope,
+// a resource group or VNet ID.
+func scopedConfig(cfg *config.Config, scope string) (*config.Config, error) {
+	parts := azure.ExtractResourceIDParts(scope)
+	subscriptionID := azure.SubscriptionIDOf(scope)
+	if subscriptionID == "" || parts["resourceGroups"] == "" {
+		return nil, fmt.Errorf("invalid --scope %q, expected a resource group or VNet ID", scope)
+	}
+
+	for id, subCFG := range cfg.Subscriptions {
+		if strings.EqualFold(id, subscriptionID) {
+			scoped := *cfg
+			scoped.Subscriptions = map[string]config.SubscriptionConfig{id: subCFG}
+			return &scoped, nil
+		}
+	}
+	return nil, fmt.Errorf("subscription %s of --scope is not managed by velora", subscriptionID)
+}
+
+// inScope returns the findings of resources inside the scope.
+func inScope(all []findings.Finding, scope string) []findings.Finding {
+	var result []findings.Finding
+	for _, f := range all {
+		if resourceInScope(f.ResourceID, scope) {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// resourceInScope reports whether the resource is inside the scope.
+func resourceInScope(resourceID, scope string) bool {
+	prefix := strings.ToLower(strings.TrimRight(scope, "/"))
+	id := strings.ToLower(resourceID)
+	return id == prefix || strings.HasPrefix(id, prefix+"/")
+}
+
+// textOutputWriter prints the findings of the scan as they are added, and
+// the summary once the scan is complete.
+type textOutputWriter struct {
+	scope      string
+	timings    bool
+	summarizer *runner.Summarizer
+}
+
+// newTextOutputWriter creates a writer printing the scan output as text,
+// with the ARM latencies if timings is set.
+func newTextOutputWriter(failOn findings.Severity, scope string, timings bool) *textOutputWriter {
+	return &textOutputWriter{scope: scope, timings: timings, summarizer: runner.NewSummarizer(failOn)}
+}
+
+// Add prints a finding.
+func (t *textOutputWriter) Add(f findings.Finding) error {
+	t.summarizer.Add(f)
+	fmt.Printf("[%s] %s %s\n", f.Severity, f.RuleID, f.Message)
+	if f.SeverityReason != "" {
+		fmt.Printf("  severity %s\n", f.SeverityReason)
+	}
+	if f.Caller != nil {
+		fmt.Printf("  changed by %s\n", f.Caller)
+	}
+	return nil
+}
+
+// Flush prints the summary of the scan.
+func (t *textOutputWriter) Flush(result *runner.Result) (runner.OutputSummary, error) {
+	out := runner.NewStreamedOutput(result, t.summarizer.Summary(len(result.Skipped) > 0), t.scope)
+	if t.timings {
+		printLatencies(out.Latencies)
+		printListPages(out.ListPages)
+	}
+	printScan(out)
+	return out.Summary, nil
+}
+
+// printLatencies prints the latency of the ARM requests per operation.
+func printLatencies(latencies []azure.OperationLatency) {
+	if len(latencies) == 0 {
+		fmt.Println("no ARM requests")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OPERATION\tCOUNT\tP50\tP95\tMAX\tERRORS")
+	for _, l := range latencies {
+		fmt.Fprintf(w, "%s\t%d\t%.0fms\t%.0fms\t%.0fms\t%.1f%%\n", l.Operation, l.Count, l.P50Ms, l.P95Ms, l.MaxMs, l.ErrorRate*100)
+	}
+	w.Flush()
+}
+
+// printListPages prints the list pages fetched per operation.
+func printListPages(pages azure.ListPages) {
+	if len(pages) == 0 {
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LIST OPERATION\tPAGES\tPAGE SIZE\tREDUCED")
+	for _, operation := range pages.Operations() {
+		stats := pages[operation]
+		size := "default"
+		if stats.PageSize > 0 {
+			size = strconv.Itoa(stats.PageSize)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\n", operation, stats.Pages, size, stats.Reductions)
+	}
+	w.Flush()
+}
+
+// printScan prints the summary of the scan, its findings were printed as
+// they were added.
+func printScan(out *runner.Output) {
+	if bg := out.Metadata.BreakGlass; bg != nil {
+		fmt.Printf("BREAK-GLASS MODE: %s\n", bg)
+	}
+	subIDs := make([]string, 0, len(out.Skipped))
+	for subID := range out.Skipped {
+		subIDs = append(subIDs, subID)
+	}
+	sort.Strings(subIDs)
+	for _, subID := range subIDs {
+		fmt.Printf("skipped subscription %s: %s\n", subID, strings.Join(strings.Fields(out.Skipped[subID]), " "))
+	}
+	for _, id := range out.Disappeared {
+		fmt.Printf("skipped resource %s: disappeared during run\n", id)
+	}
+	for _, r := range out.PendingAcknowledgment {
+		fmt.Printf("subscription %s is new and only observed until acknowledged: %d findings after %d observe runs\n",
+			r.SubscriptionID, r.FindingCount(), r.ObserveRuns)
+	}
+	for _, counted := range out.BlockedByPolicy {
+		fmt.Printf("Azure Policy %s blocked %d remediations: %s\n", counted.AssignmentName, counted.Blocked, counted.AssignmentID)
+	}
+	if reads := out.Reads; reads != nil {
+		fmt.Printf("%d ARM reads, %d inventory lists shared %d times, %d hub fetches shared %d times\n", reads.ARM,
+			reads.Inventory.Lists, reads.Inventory.Reused, reads.HubCache.Misses, reads.HubCache.Hits)
+	}
+
+	s := out.Summary
+	fmt.Printf("%d critical, %d high, %d medium, %d low, %d info findings\n",
+		s.Severities[findings.SeverityCritical], s.Severities[findings.SeverityHigh], s.Severities[findings.SeverityMedium],
+		s.Severities[findings.SeverityLow], s.Severities[findings.SeverityInfo])
+	switch s.ExitCode {
+	case runner.ExitFindings:
+		fmt.Printf("non-compliant, rules failing at %s or above: %s\n", s.FailOn, strings.Join(s.FailedRules, ", "))
+	case runner.ExitPartial:
+		fmt.Println("compliant, but not all subscriptions were scanned")
+	default:
+		fmt.Println("compliant")
+	}
+}
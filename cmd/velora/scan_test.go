@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/runner"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+// secondSubscriptionID is a second spoke subscription, see withSecondSpoke.
+const secondSubscriptionID = "00000000-0000-0000-0000-000000000003"
+
+// withSecondSpoke adds secondSubscriptionID to the configuration, like the
+// first spoke subscription.
+func withSecondSpoke(cfg *config.Config) {
+	cfg.Subscriptions[secondSubscriptionID] = cfg.Subscriptions[configtest.SubscriptionID]
+}
+
+// newTestARM returns a fake ARM with the hub of the test configuration and a
+// spoke VNet per subscription whose subnet has a route table with the routes.
+func newTestARM(subscriptionIDs []string, routes ...*armnetwork.Route) *azuretest.Server {
+	arm := azuretest.NewServer()
+	arm.Put(configtest.HubVNetID, azuretest.VNet(configtest.HubVNetID, []string{"10.0.0.0/16"}, azuretest.Subnet("nva", "10.0.0.0/24", "")))
+	nicID := "/subscriptions/" + configtest.HubSubscriptionID + "/resourceGroups/hub-rg/providers/Microsoft.Network/networkInterfaces/nva"
+	arm.Put(nicID, azuretest.ForwardingNIC(nicID, configtest.HubVNetID+"/subnets/nva", configtest.NVANextHop))
+	for i, subscriptionID := range subscriptionIDs {
+		rg := "/subscriptions/" + subscriptionID + "/resourceGroups/spoke-rg/providers/Microsoft.Network"
+		vnetID, routeTableID := rg+"/virtualNetworks/spoke", rg+"/routeTables/spoke-rt"
+		arm.Put(routeTableID, azuretest.RouteTable(routeTableID, routes...))
+		arm.Put(vnetID, azuretest.VNet(vnetID, []string{fmt.Sprintf("10.%d.0.0/16", i+1)},
+			azuretest.Subnet("app", fmt.Sprintf("10.%d.0.0/24", i+1), routeTableID)))
+	}
+	return arm
+}
+
+// scanARM makes the scans of the test run against the fake ARM.
+func scanARM(t *testing.T, arm *azuretest.Server) {
+	t.Helper()
+	previous := newClientFactory
+	newClientFactory = func(cfg *config.AzureConfig) (*azure.ClientFactory, error) {
+		return azure.NewClientFactoryWithTransport(cfg, azuretest.Credential{ObjectID: "velora"}, arm), nil
+	}
+	t.Cleanup(func() { newClientFactory = previous })
+}
+
+// writeConfig writes the configuration to a file and returns its path.
+func writeConfig(t *testing.T, cfg *config.Config) string {
+	t.Helper()
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(configtest.WriteFiles(t, map[string]string{"config.json": string(data)}), "config.json")
+}
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t *testing.T, f func()) []byte {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	stdout := os.Stdout
+	os.Stdout = file
+	defer func() { os.Stdout = stdout }()
+	f()
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// exitCode returns the exit code of the error of runScan.
+func exitCode(err error) int {
+	if err == nil {
+		return runner.ExitCompliant
+	}
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return runner.ExitError
+}
+
+// defaultRoute is the route the test configuration requires.
+func defaultRoute() *armnetwork.Route {
+	return azuretest.Route("DefaultRoute-To-NVA", "0.0.0.0/0", configtest.NVANextHop)
+}
+
+// halt pauses the evaluation of the subscription, the scan skips it.
+func halt(t *testing.T, configPath, subscriptionID string) {
+	t.Helper()
+	args := []string{"--config", configPath, "--subscription", subscriptionID, "--reason", "INC-1", "--by", "oncall", "--halt-observation"}
+	captureStdout(t, func() {
+		if err := runPause(args); err != nil {
+			t.Fatalf("runPause() error = %v", err)
+		}
+	})
+}
+
+func TestRunScanExitCodes(t *testing.T) {
+	both := []string{configtest.SubscriptionID, secondSubscriptionID}
+	tests := []struct {
+		name   string
+		args   []string
+		routes []*armnetwork.Route
+		halted string
+		want   int
+	}{
+		{
+			name:   "compliant",
+			routes: []*armnetwork.Route{defaultRoute()},
+			want:   runner.ExitCompliant,
+		},
+		{
+			name: "missing default routes",
+			want: runner.ExitFindings,
+		},
+		{
+			name: "findings below the threshold",
+			args: []string{"--fail-on", "critical"},
+			want: runner.ExitCompliant,
+		},
+		{
+			name:   "subscription not scanned",
+			routes: []*armnetwork.Route{defaultRoute()},
+			halted: secondSubscriptionID,
+			want:   runner.ExitPartial,
+		},
+		{
+			name:   "findings and a subscription not scanned",
+			halted: secondSubscriptionID,
+			want:   runner.ExitFindings,
+		},
+		{
+			name: "unknown output",
+			args: []string{"--output", "yaml"},
+			want: runner.ExitError,
+		},
+		{
+			name: "unknown flag",
+			args: []string{"--unknown"},
+			want: runner.ExitError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arm := newTestARM(both, tt.routes...)
+			scanARM(t, arm)
+			path := writeConfig(t, configtest.New(t, withSecondSpoke))
+			if tt.halted != "" {
+				halt(t, path, tt.halted)
+			}
+			args := append([]string{"--config", path, "--output", "json"}, tt.args...)
+
+			var err error
+			captureStdout(t, func() { err = runScan(args) })
+			if got := exitCode(err); got != tt.want {
+				t.Errorf("runScan() exit code = %d (%v), want %d", got, err, tt.want)
+			}
+			if writes := arm.Writes(); len(writes) != 0 {
+				t.Errorf("writes reaching ARM = %v, want none", writes)
+			}
+		})
+	}
+}
+
+func TestRunScanJSONGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []*armnetwork.Route
+		halted string
+	}{
+		{name: "compliant", routes: []*armnetwork.Route{defaultRoute()}},
+		{name: "findings"},
+		{name: "partial", routes: []*armnetwork.Route{defaultRoute()}, halted: secondSubscriptionID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arm := newTestARM([]string{configtest.SubscriptionID, secondSubscriptionID}, tt.routes...)
+			scanARM(t, arm)
+			path := writeConfig(t, configtest.New(t, withSecondSpoke))
+			if tt.halted != "" {
+				halt(t, path, tt.halted)
+			}
+
+			got := captureStdout(t, func() { _ = runScan([]string{"--config", path, "--output", "json"}) })
+			got = stableOutput(t, got)
+
+			golden := filepath.Join("testdata", "scan-"+tt.name+".json")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("scan output differs from %s, run go test -update to accept it:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+// volatileOutput matches the fields of the scan output changing from run
+// to run: the config hash, which covers the temporary state path, the
+// latencies, and the findings, whose order follows the subscriptions.
+var volatileOutput = regexp.MustCompile(`(?s)"configHash": "[0-9a-f]*"|"latencies": \[.*?\n  \],\n  |"findings": (\[\]|\[.*?\n  \])`)
+
+// stableOutput returns the scan output with its volatile fields replaced,
+// and its findings sorted.
+func stableOutput(t *testing.T, output []byte) []byte {
+	t.Helper()
+	if !json.Valid(output) {
+		t.Fatalf("scan output isn't JSON:\n%s", output)
+	}
+	return volatileOutput.ReplaceAllFunc(output, func(field []byte) []byte {
+		switch {
+		case bytes.HasPrefix(field, []byte(`"configHash"`)):
+			return []byte(`"configHash": "<config hash>"`)
+		case bytes.HasPrefix(field, []byte(`"findings"`)):
+			return sortedFindings(t, field)
+		}
+		return nil
+	})
+}
+
+// sortedFindings returns the findings field of the scan output with the
+// findings sorted.
+func sortedFindings(t *testing.T, field []byte) []byte {
+	t.Helper()
+	var all []json.RawMessage
+	if err := json.Unmarshal(bytes.TrimPrefix(field, []byte(`"findings": `)), &all); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(all, func(i, j int) bool { return bytes.Compare(all[i], all[j]) < 0 })
+	sorted, err := json.MarshalIndent(all, "  ", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte(`"findings": `), sorted...)
+}
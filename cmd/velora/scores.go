@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/stats"
+)
+
+// runScores prints the posture score of every subscription over the last
+// week, with its trend against the week before.
+func runScores(args []string) error {
+	fs := flag.NewFlagSet("scores", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	output := fs.String("output", "text", "output format, text or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output %q, allowed values are text, json", *output)
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	trends, err := stats.NewHistory(store, cfg.Stats).Trends(time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	scored := make([]stats.Trend, 0, len(trends))
+	for _, t := range trends {
+		if t.Score != nil {
+			scored = append(scored, t)
+		}
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(scored)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SUBSCRIPTION\tSCORE\tLAST WEEK\tTREND")
+	for _, t := range scored {
+		previous := "-"
+		if t.PreviousScore != nil {
+			previous = fmt.Sprintf("%.1f", *t.PreviousScore)
+		}
+		fmt.Fprintf(w, "%s\t%.1f\t%s\t%s\n", t.SubscriptionID, *t.Score, previous, t.ScoreArrow())
+	}
+	return w.Flush()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/inventory"
+)
+
+// runSearch searches the persisted inventory for routes, peerings or subnets.
+func runSearch(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	refresh := fs.Bool("refresh", false, "collect the inventory before searching")
+	subscription := fs.String("subscription", "", "only refresh this subscription")
+	offset := fs.Int("offset", 0, "index of the first result")
+	limit := fs.Int("limit", inventory.DefaultSearchLimit, "maximum number of results")
+	output := fs.String("output", "text", "output format, text or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return fmt.Errorf("usage: velora search [flags] routes|peerings|subnets [field=value ...]")
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output %q, allowed values are text, json", *output)
+	}
+
+	query, err := inventory.ParseQuery(fs.Arg(0), fs.Args()[1:], *offset, *limit)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	if *refresh {
+		if err := refreshInventory(cfg, *subscription); err != nil {
+			return err
+		}
+	}
+	snapshot, err := inventory.LoadSnapshot(store)
+	if err != nil {
+		return err
+	}
+
+	result := inventory.NewSearcher(snapshot).Search(query)
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+	return printSearch(result, cfg.DisplayLocation())
+}
+
+// refreshInventory collects the inventory of the subscription, or of all
+// managed subscriptions, and merges it into the persisted snapshot.
+func refreshInventory(cfg *config.Config, subscriptionID string) error {
+	subscriptionIDs := cfg.SubscriptionIDs()
+	if subscriptionID != "" {
+		if !cfg.Manages(subscriptionID) {
+			return fmt.Errorf("subscription %s is not managed by velora", subscriptionID)
+		}
+		subscriptionIDs = []string{subscriptionID}
+	}
+
+	clientFactory, err := azure.NewClientFactory(&cfg.Azure)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	collected, err := inventory.Collect(context.Background(), clientFactory, subscriptionIDs)
+	if err != nil {
+		return err
+	}
+	snapshot, err := inventory.LoadSnapshot(store)
+	if errors.Is(err, inventory.ErrNoSnapshot) {
+		snapshot = &inventory.Snapshot{}
+	} else if err != nil {
+		return err
+	}
+	snapshot.Merge(collected)
+	return inventory.SaveSnapshot(store, snapshot)
+}
+
+// printSearch prints the results as a table, followed by the paging state.
+func printSearch(result inventory.SearchResult, loc *time.Location) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	switch result.Kind {
+	case inventory.KindRoutes:
+		fmt.Fprintln(w, "PREFIX\tNEXT HOP TYPE\tNEXT HOP IP\tSUBNETS\tROUTE")
+		for _, r := range result.Routes {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", r.Prefix, r.NextHopType, r.NextHopIP, len(r.Subnets), r.ID)
+		}
+	case inventory.KindPeerings:
+		fmt.Fprintln(w, "STATE\tSYNC\tREMOTE VNET\tPEERING")
+		for _, p := range result.Peerings {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.State, p.SyncLevel, p.RemoteVNetID, p.ID)
+		}
+	case inventory.KindSubnets:
+		fmt.Fprintln(w, "PREFIXES\tROUTE TABLE\tNSG\tSUBNET")
+		for _, s := range result.Subnets {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", strings.Join(s.Prefixes, ","), dashIfEmpty(s.RouteTableID), dashIfEmpty(s.NSGID), s.ID)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%d results, inventory collected %s (%s ago)\n", result.Total,
+		formatTime(result.CollectedAt, loc), time.Since(result.CollectedAt).Round(time.Minute))
+	if result.NextOffset > 0 {
+		fmt.Printf("more results with --offset %d\n", result.NextOffset)
+	}
+	return nil
+}
+
+// dashIfEmpty returns "-" for empty table cells.
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/selftest"
+)
+
+// runSelftest checks a deployment is healthy and fails if any check fails.
+func runSelftest(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	notify := fs.Bool("notify", false, "send a test message through every notification channel")
+	timeout := fs.Duration("timeout", selftest.DefaultTimeout, "timeout of each check")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	clientFactory, err := azure.NewClientFactory(&cfg.Azure)
+	if err != nil {
+		return err
+	}
+
+	checks := selftest.Checks(cfg, clientFactory, selftest.Options{Notify: *notify})
+	results := selftest.Run(context.Background(), checks, *timeout)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tSTATUS\tDETAIL")
+	for _, r := range results {
+		// keep multi-line errors on one row
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Name, r.Status, strings.Join(strings.Fields(r.Detail), " "))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if selftest.Failed(results) {
+		return fmt.Errorf("self-test failed")
+	}
+	return nil
+}
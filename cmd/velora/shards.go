@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/shard"
+)
+
+// runShards prints the shard owning each subscription and the claims of
+// the running instances.
+func runShards(args []string) error {
+	fs := flag.NewFlagSet("shards", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	count := fs.Int("count", 0, "shard count to print the assignment for, to preview a rebalancing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if *count == 0 && cfg.Sharding != nil {
+		*count = cfg.Sharding.ShardCount
+	}
+	if *count < 1 {
+		return fmt.Errorf("sharding is not configured, pass --count to preview an assignment")
+	}
+
+	// the claims are shared by all shards, no shard index is needed to read them
+	unsharded := *cfg
+	unsharded.Sharding = nil
+	store, err := openStateStore(&unsharded)
+	if err != nil {
+		return err
+	}
+	claims, err := shard.Claims(store)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SUBSCRIPTION\tSHARD")
+	for _, subID := range cfg.SubscriptionIDs() {
+		fmt.Fprintf(w, "%s\t%d/%d\n", subID, shard.Of(subID, *count), *count)
+	}
+
+	if len(claims) > 0 {
+		instances := make([]string, 0, len(claims))
+		for instance := range claims {
+			instances = append(instances, instance)
+		}
+		sort.Strings(instances)
+
+		ttl := config.DefaultShardClaimTTL
+		if cfg.Sharding != nil {
+			ttl = cfg.Sharding.ClaimTTL()
+		}
+		now := time.Now()
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "INSTANCE\tSHARD\tRENEWED\tSTATUS")
+		for _, instance := range instances {
+			c := claims[instance]
+			status := "live"
+			if c.Expired(now, ttl) {
+				status = "expired"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", instance, c.Shard, c.RenewedAt.Format(time.RFC3339), status)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/slo"
+)
+
+// runSLO prints the time-to-remediation statistics of the finding history
+// and the open findings breaching the SLO.
+func runSLO(args []string) error {
+	fs := flag.NewFlagSet("slo", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	report, err := slo.NewTracker(store, cfg).Load(now)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tRESOLVED\tP50\tP95")
+	for _, r := range report.Rules {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", r.RuleID, r.Resolved, r.P50.Round(time.Minute), r.P95.Round(time.Minute))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "TIME TO REMEDIATION\tCOUNT")
+	for _, b := range report.Histogram {
+		bound := "+Inf"
+		if b.UpperBound > 0 {
+			bound = "<= " + b.UpperBound.String()
+		}
+		fmt.Fprintf(w, "%s\t%d\n", bound, b.Count)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "SLO BREACHES")
+	fmt.Fprintln(w, "SUBSCRIPTION\tSEVERITY\tRULE\tOPEN FOR\tRESOURCE")
+	for _, r := range report.Breaches {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.SubscriptionID, r.Severity, r.RuleID, r.Age(now).Round(time.Minute), r.ResourceID)
+	}
+	return w.Flush()
+}
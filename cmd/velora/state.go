@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// openStateStore opens the configured state store. A credential is only
+// created if the encryption key is in Key Vault.
+func openStateStore(cfg *config.Config) (state.Store, error) {
+	var cred azcore.TokenCredential
+	if enc := cfg.State.Encryption; enc != nil && enc.UsesKeyVault() {
+		clientFactory, err := azure.NewClientFactory(&cfg.Azure)
+		if err != nil {
+			return nil, err
+		}
+		cred = clientFactory.GetCredential()
+	}
+	return state.Open(cfg, cred)
+}
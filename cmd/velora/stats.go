@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/stats"
+)
+
+// statsDateLayout is the layout of the --from and --to dates.
+const statsDateLayout = "2006-01-02"
+
+// runStats prints the enforcement statistics time series.
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	subscription := fs.String("subscription", "", "only print this subscription")
+	from := fs.String("from", "", "first day to print, YYYY-MM-DD, defaults to 90 days ago")
+	to := fs.String("to", "", "last day to print, YYYY-MM-DD, defaults to today")
+	output := fs.String("output", "text", "output format, text, json or csv")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" && *output != "csv" {
+		return fmt.Errorf("unknown output %q, allowed values are text, json, csv", *output)
+	}
+
+	now := time.Now().UTC()
+	fromTime := now.AddDate(0, 0, -90)
+	if *from != "" {
+		t, err := time.Parse(statsDateLayout, *from)
+		if err != nil {
+			return fmt.Errorf("invalid --from date: %w", err)
+		}
+		fromTime = t
+	}
+	var toTime time.Time
+	if *to != "" {
+		t, err := time.Parse(statsDateLayout, *to)
+		if err != nil {
+			return fmt.Errorf("invalid --to date: %w", err)
+		}
+		// the whole last day is included
+		toTime = t.Add(24*time.Hour - time.Nanosecond)
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	points, err := stats.NewHistory(store, cfg.Stats).Query(*subscription, fromTime, toTime)
+	if err != nil {
+		return err
+	}
+
+	switch *output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(points)
+	case "csv":
+		return stats.WriteCSV(os.Stdout, points)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tSUBSCRIPTION\tRUNS\tCOMPLIANCE\tSCORE\tCRITICAL\tHIGH\tMEDIUM\tLOW\tREMEDIATIONS")
+	for _, p := range points {
+		score := "-"
+		if p.Score != nil {
+			score = fmt.Sprintf("%.1f", *p.Score)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.1f%%\t%s\t%d\t%d\t%d\t%d\t%d\n", formatTime(p.Time, cfg.DisplayLocation()), p.SubscriptionID, p.Runs, p.ComplianceRate, score,
+			p.Findings[findings.SeverityCritical], p.Findings[findings.SeverityHigh],
+			p.Findings[findings.SeverityMedium], p.Findings[findings.SeverityLow], p.Remediations)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/onboarding"
+)
+
+// runSubscriptions handles the "subscriptions" command group.
+func runSubscriptions(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: velora subscriptions list|ack [--config path]")
+	}
+
+	switch args[0] {
+	case "list":
+		return runSubscriptionsList(args[1:])
+	case "ack":
+		return runSubscriptionsAck(args[1:])
+	default:
+		return fmt.Errorf("unknown subscriptions command: %s", args[0])
+	}
+}
+
+// runSubscriptionsList prints the onboarding state of the subscriptions:
+// whether remediation waits for an acknowledgment, and who gave it.
+func runSubscriptionsList(args []string) error {
+	fs := flag.NewFlagSet("subscriptions list", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	records, err := onboarding.NewManager(store).List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SUBSCRIPTION\tSTATUS\tFIRST SEEN\tOBSERVE RUNS\tSTABLE RUNS\tFINDINGS\tACKNOWLEDGED AT\tACKNOWLEDGED BY")
+	for _, r := range records {
+		status, acknowledgedAt := "pending", "-"
+		if !r.Pending() {
+			status, acknowledgedAt = "acknowledged", formatTime(*r.AcknowledgedAt, cfg.DisplayLocation())
+		}
+		if _, ok := cfg.Subscriptions[r.SubscriptionID]; !ok {
+			status += " (not configured)"
+		}
+		by := r.AcknowledgedBy
+		if by == "" {
+			by = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\n", r.SubscriptionID, status, formatTime(r.FirstSeen, cfg.DisplayLocation()), r.ObserveRuns,
+			r.StableRuns, r.FindingCount(), acknowledgedAt, by)
+	}
+	return w.Flush()
+}
+
+// runSubscriptionsAck acknowledges the findings of a new subscription, so
+// the next run remediates it.
+func runSubscriptionsAck(args []string) error {
+	fs := flag.NewFlagSet("subscriptions ack", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	subscription := fs.String("subscription", "", "ID of the subscription to acknowledge")
+	by := fs.String("by", os.Getenv("USER"), "who acknowledges the findings")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *subscription == "" || *by == "" {
+		return fmt.Errorf("usage: velora subscriptions ack [--config path] --subscription id [--by name]")
+	}
+	subscriptionID := *subscription
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.Subscriptions[subscriptionID]; !ok {
+		fmt.Printf("WARNING: subscription %s is not configured, it is acknowledged ahead of being added\n", subscriptionID)
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	r, err := onboarding.NewManager(store).Acknowledge(subscriptionID, *by)
+	if err != nil {
+		return err
+	}
+	if r.ObserveRuns == 0 {
+		fmt.Printf("acknowledged subscription %s before any observe run, it is remediated from its first run\n", subscriptionID)
+		return nil
+	}
+	fmt.Printf("acknowledged %d findings of subscription %s after %d observe runs, remediation starts with the next run\n",
+		r.FindingCount(), subscriptionID, r.ObserveRuns)
+	return nil
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/bundle"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/inventory"
+	"github.com/akos011221/velora/internal/metrics"
+	"github.com/akos011221/velora/internal/preflight"
+	"github.com/akos011221/velora/internal/selftest"
+	"github.com/akos011221/velora/internal/state"
+	"github.com/akos011221/velora/internal/stats"
+	"github.com/akos011221/velora/internal/version"
+)
+
+// Artifacts of a support bundle that can be excluded.
+const (
+	artifactConfig    = "config"
+	artifactRuns      = "runs"
+	artifactLogs      = "logs"
+	artifactPreflight = "preflight"
+	artifactState     = "state"
+	artifactInventory = "inventory"
+)
+
+// bundleArtifacts are the artifacts --exclude accepts.
+var bundleArtifacts = []string{artifactConfig, artifactRuns, artifactLogs, artifactPreflight, artifactState, artifactInventory}
+
+// preflightTimeout bounds the preflight checks of a support bundle.
+const preflightTimeout = 2 * time.Minute
+
+// runSummary is a run of the statistics history with its totals.
+type runSummary struct {
+	Time          time.Time                 `json:"time"`
+	Evaluated     int                       `json:"evaluated"`
+	Compliant     int                       `json:"compliant"`
+	Findings      map[findings.Severity]int `json:"findings"`
+	Remediations  int                       `json:"remediations"`
+	Subscriptions []stats.Record            `json:"subscriptions"`
+}
+
+// stateHealth is the state store as seen by a support bundle.
+type stateHealth struct {
+	// Path is the state directory of the file backend.
+	Path string `json:"path,omitempty"`
+	// Backend describes the backend, with the guarantees it makes.
+	Backend    string           `json:"backend"`
+	Guarantees state.Guarantees `json:"guarantees"`
+	Encrypted  bool             `json:"encrypted"`
+	Check      string           `json:"check"`
+	Detail     string           `json:"detail,omitempty"`
+	Files      []stateFile      `json:"files"`
+	Errors     []string         `json:"errors,omitempty"`
+}
+
+// stateFile is a file of the state directory, or a key of another
+// backend.
+type stateFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Modified is zero for keys of backends other than files.
+	Modified  time.Time `json:"modified"`
+	Encrypted bool      `json:"encrypted"`
+}
+
+// runSupportBundle packages what is needed to investigate an issue into a
+// zip archive, sanitized of secrets and optionally of subscription IDs.
+func runSupportBundle(args []string) error {
+	fs := flag.NewFlagSet("support-bundle", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	out := fs.String("out", "", "path of the zip archive to write")
+	runs := fs.Int("runs", 20, "number of recent runs to include")
+	logLines := fs.Int("log-lines", 1000, "number of recent log lines to include")
+	maxFileSize := fs.Int("max-file-size", 25, "size limit of each artifact in MiB, larger ones are excluded")
+	exclude := fs.String("exclude", "", "comma-separated artifacts to leave out: "+strings.Join(bundleArtifacts, ", "))
+	withInventory := fs.Bool("inventory", false, "include the latest inventory snapshot")
+	anonymize := fs.Bool("anonymize", false, "replace subscription IDs with pseudonyms")
+	keepMapping := fs.Bool("keep-mapping", false, "include the pseudonyms of the subscription IDs, with --anonymize")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return fmt.Errorf("usage: velora support-bundle [--config path] --out bundle.zip [--anonymize [--keep-mapping]] [--exclude artifacts] [--inventory]")
+	}
+	if *keepMapping && !*anonymize {
+		return fmt.Errorf("--keep-mapping requires --anonymize")
+	}
+	if *runs < 0 || *logLines < 0 || *maxFileSize <= 0 {
+		return fmt.Errorf("--runs and --log-lines must not be negative, --max-file-size must be positive")
+	}
+	excluded := make(map[string]bool)
+	for _, name := range strings.Split(*exclude, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(bundleArtifacts, name) {
+			return fmt.Errorf("unknown artifact %q, allowed values are %s", name, strings.Join(bundleArtifacts, ", "))
+		}
+		excluded[name] = true
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	var anonymizer *bundle.Anonymizer
+	if *anonymize {
+		anonymizer = bundle.NewAnonymizer(bundleSubscriptionIDs(cfg))
+	}
+	b := bundle.New(*maxFileSize<<20, anonymizer)
+	b.AddJSON("version.json", "build metadata of velora", version.Get())
+	if excluded[artifactConfig] {
+		b.Exclude(artifactConfig, "excluded with --exclude")
+	} else {
+		b.AddJSON("config.json", "effective configuration, secrets redacted", cfg.Redacted())
+	}
+
+	store, storeErr := openStateStore(cfg)
+	clientFactory, factoryErr := azure.NewClientFactory(&cfg.Azure)
+	if factoryErr == nil {
+		// the bundle only reads from Azure
+		clientFactory.EnableReadOnly()
+	}
+
+	switch {
+	case excluded[artifactRuns]:
+		b.Exclude(artifactRuns, "excluded with --exclude")
+	case storeErr != nil:
+		b.Exclude(artifactRuns, fmt.Sprintf("state store not readable: %v", storeErr))
+	default:
+		addRuns(b, cfg, store, *runs)
+	}
+
+	if excluded[artifactLogs] {
+		b.Exclude(artifactLogs, "excluded with --exclude")
+	} else {
+		addLogs(b, cfg, *logLines, *maxFileSize<<20)
+	}
+
+	switch {
+	case excluded[artifactPreflight]:
+		b.Exclude(artifactPreflight, "excluded with --exclude")
+	case factoryErr != nil:
+		b.Exclude(artifactPreflight, fmt.Sprintf("failed to create the Azure client: %v", factoryErr))
+	default:
+		ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+		report := preflight.Run(ctx, cfg, clientFactory)
+		cancel()
+		b.AddJSON("preflight.json", "preflight checks run while the bundle was collected", report)
+	}
+
+	if excluded[artifactState] {
+		b.Exclude(artifactState, "excluded with --exclude")
+	} else {
+		b.AddJSON("state.json", "state store health and files", checkStateHealth(cfg, clientFactory, factoryErr))
+	}
+
+	switch {
+	case !*withInventory:
+		b.Exclude(artifactInventory, "only included with --inventory")
+	case excluded[artifactInventory]:
+		b.Exclude(artifactInventory, "excluded with --exclude")
+	case storeErr != nil:
+		b.Exclude(artifactInventory, fmt.Sprintf("state store not readable: %v", storeErr))
+	default:
+		snapshot, err := inventory.LoadSnapshot(store)
+		if err != nil {
+			b.Exclude(artifactInventory, err.Error())
+		} else {
+			b.AddJSON("inventory-snapshot.json", "latest inventory snapshot", snapshot)
+		}
+	}
+
+	if err := writeBundle(b, *out, *keepMapping); err != nil {
+		return err
+	}
+	manifest := b.Manifest()
+	fmt.Printf("wrote support bundle %s: %d files, %d artifacts excluded\n", *out, len(manifest.Files)+1, len(manifest.Excluded))
+	for _, e := range manifest.Excluded {
+		fmt.Printf("  excluded %s: %s\n", e.Name, e.Reason)
+	}
+	if *anonymize && !*keepMapping {
+		fmt.Println("subscription IDs are anonymized, the mapping isn't included")
+	}
+	return nil
+}
+
+// bundleSubscriptionIDs returns the subscription IDs the configuration
+// knows about, anonymized in every bundle.
+func bundleSubscriptionIDs(cfg *config.Config) []string {
+	ids := cfg.SubscriptionIDs()
+	if cfg.Azure.SubscriptionID != "" {
+		ids = append(ids, cfg.Azure.SubscriptionID)
+	}
+	if cfg.HubDiscovery != nil {
+		ids = append(ids, cfg.HubDiscovery.Subscriptions...)
+	}
+	for _, hub := range cfg.Hubs {
+		if subID := azure.SubscriptionIDOf(hub.VNetID); subID != "" {
+			ids = append(ids, subID)
+		}
+	}
+	return ids
+}
+
+// addRuns adds the last runs of the statistics history with their totals,
+// and the metrics of the last run of each subscription.
+func addRuns(b *bundle.Bundle, cfg *config.Config, store state.Store, n int) {
+	records, err := stats.NewHistory(store, cfg.Stats).Recent(n)
+	if err != nil {
+		b.Exclude(artifactRuns, err.Error())
+		return
+	}
+	summaries := []runSummary{}
+	for _, r := range records {
+		if len(summaries) == 0 || !summaries[len(summaries)-1].Time.Equal(r.Time) {
+			summaries = append(summaries, runSummary{Time: r.Time, Findings: make(map[findings.Severity]int)})
+		}
+		s := &summaries[len(summaries)-1]
+		s.Evaluated += r.Evaluated
+		s.Compliant += r.Compliant
+		s.Remediations += r.Remediations
+		for severity, count := range r.Findings {
+			s.Findings[severity] += count
+		}
+		s.Subscriptions = append(s.Subscriptions, r)
+	}
+	b.AddJSON("runs.json", fmt.Sprintf("statistics of the last %d runs, per subscription and in total", len(summaries)), summaries)
+
+	snapshot, err := metrics.Load(store)
+	if err != nil {
+		b.Exclude("metrics", err.Error())
+		return
+	}
+	b.AddJSON("metrics.json", "outcome of the last run of each subscription, as exported in the metrics", snapshot)
+}
+
+// addLogs adds the last lines of the log file, if velora logs to a file.
+func addLogs(b *bundle.Bundle, cfg *config.Config, lines, maxBytes int) {
+	path, err := cfg.Logging.FilePath()
+	if err != nil {
+		b.Exclude(artifactLogs, err.Error())
+		return
+	}
+	if path == "" {
+		b.Exclude(artifactLogs, "logging.outputPath isn't a file, collect the logs of the process output instead")
+		return
+	}
+	excerpt, err := tailLines(path, lines, maxBytes)
+	if err != nil {
+		b.Exclude(artifactLogs, err.Error())
+		return
+	}
+	b.Add("logs.txt", fmt.Sprintf("last %d lines of %s, secrets redacted", lines, filepath.Base(path)), excerpt)
+}
+
+// tailLines returns the last lines of the file, reading at most maxBytes.
+func tailLines(path string, lines, maxBytes int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log file: %w", err)
+	}
+	offset := max(info.Size()-int64(maxBytes), 0)
+	data, err := io.ReadAll(io.NewSectionReader(f, offset, info.Size()-offset))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log file: %w", err)
+	}
+	// a cut line is dropped
+	if offset > 0 {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+
+	all := bytes.SplitAfter(data, []byte("\n"))
+	if len(all) > 0 && len(all[len(all)-1]) == 0 {
+		all = all[:len(all)-1]
+	}
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return bytes.Join(all, nil), nil
+}
+
+// checkStateHealth checks the state store can be written and read, and
+// lists the files of the state directory, or the keys of another backend.
+func checkStateHealth(cfg *config.Config, clientFactory *azure.ClientFactory, factoryErr error) stateHealth {
+	health := stateHealth{Encrypted: cfg.State.Encryption != nil, Files: []stateFile{}}
+	backend, err := state.OpenBackend(cfg)
+	if err != nil {
+		health.Check = string(selftest.StatusFail)
+		health.Detail = err.Error()
+		return health
+	}
+	health.Backend = backend.String()
+	health.Guarantees = backend.Guarantees()
+
+	if factoryErr != nil {
+		health.Check = string(selftest.StatusFail)
+		health.Detail = fmt.Sprintf("failed to create the Azure client: %v", factoryErr)
+	} else {
+		status, detail := selftest.CheckState(cfg, clientFactory)
+		health.Check = string(status)
+		health.Detail = detail
+	}
+	if cfg.State.EffectiveBackend() != config.StateBackendFile {
+		health.Files, health.Errors = backendFiles(backend, health.Errors)
+		return health
+	}
+
+	// files have their modification times
+	dir, err := cfg.StatePath()
+	if err != nil {
+		health.Errors = append(health.Errors, err.Error())
+		return health
+	}
+	health.Path = dir
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		health.Errors = append(health.Errors, fmt.Sprintf("failed to list the state directory: %v", err))
+		return health
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			health.Errors = append(health.Errors, fmt.Sprintf("failed to stat %s: %v", entry.Name(), err))
+			continue
+		}
+		file := stateFile{Name: entry.Name(), Size: info.Size(), Modified: info.ModTime().UTC()}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			health.Errors = append(health.Errors, fmt.Sprintf("failed to read %s: %v", entry.Name(), err))
+		} else {
+			file.Encrypted = state.IsEncrypted(data)
+		}
+		health.Files = append(health.Files, file)
+	}
+	return health
+}
+
+// backendFiles lists the keys of a backend other than files, with the
+// errors reading them appended to errs.
+func backendFiles(backend state.Backend, errs []string) ([]stateFile, []string) {
+	files := []stateFile{}
+	keys, err := backend.List("")
+	if err != nil {
+		return files, append(errs, fmt.Sprintf("failed to list the state keys: %v", err))
+	}
+	for _, key := range keys {
+		data, _, err := backend.Read(key)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("failed to read %s: %v", key, err))
+			continue
+		}
+		files = append(files, stateFile{Name: key, Size: int64(len(data)), Encrypted: state.IsEncrypted(data)})
+	}
+	return files, errs
+}
+
+// writeBundle writes the bundle to path, removing it if writing fails.
+func writeBundle(b *bundle.Bundle, path string, keepMapping bool) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if err := b.Write(f, keepMapping); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/trace"
+)
+
+// runTrace handles the "trace" command group.
+func runTrace(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: velora trace start|list|cancel [--config path]")
+	}
+
+	switch args[0] {
+	case "start":
+		return runTraceStart(args[1:])
+	case "list":
+		return runTraceList(args[1:])
+	case "cancel":
+		return runTraceCancel(args[1:])
+	default:
+		return fmt.Errorf("unknown trace command: %s", args[0])
+	}
+}
+
+// runTraceStart traces a resource for the next runs.
+func runTraceStart(args []string) error {
+	fs := flag.NewFlagSet("trace start", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	resourceID := fs.String("resource", "", "ID of the resource to trace")
+	minutes := fs.Int("minutes", 60, "stop tracing after this many minutes")
+	setBy := fs.String("by", os.Getenv("USER"), "who starts the trace")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *resourceID == "" {
+		return fmt.Errorf("--resource is required")
+	}
+
+	_, traces, err := newTraceManager(*configPath)
+	if err != nil {
+		return err
+	}
+
+	t, err := traces.Start(*resourceID, *setBy, time.Duration(*minutes)*time.Minute)
+	if err != nil {
+		return err
+	}
+	fmt.Println(t)
+	return nil
+}
+
+// runTraceList prints the active traces.
+func runTraceList(args []string) error {
+	fs := flag.NewFlagSet("trace list", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, traces, err := newTraceManager(*configPath)
+	if err != nil {
+		return err
+	}
+	active, err := traces.List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED AT\tEXPIRES AT\tBY\tRESOURCE")
+	for _, t := range active {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", formatTime(t.StartedAt, cfg.DisplayLocation()), formatTime(t.ExpiresAt, cfg.DisplayLocation()), t.SetBy, t.ResourceID)
+	}
+	return w.Flush()
+}
+
+// runTraceCancel stops tracing a resource before its trace expires.
+func runTraceCancel(args []string) error {
+	fs := flag.NewFlagSet("trace cancel", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	resourceID := fs.String("resource", "", "ID of the traced resource")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *resourceID == "" {
+		return fmt.Errorf("--resource is required")
+	}
+
+	_, traces, err := newTraceManager(*configPath)
+	if err != nil {
+		return err
+	}
+	return traces.Cancel(*resourceID)
+}
+
+// newTraceManager loads the configuration and creates a trace manager on
+// its state store.
+func newTraceManager(configPath string) (*config.Config, *trace.Manager, error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, trace.NewManager(store), nil
+}
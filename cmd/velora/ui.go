@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/ui"
+)
+
+// defaultUIPort is the port the UI listens on when api.port is unset.
+const defaultUIPort = 8080
+
+// runUI serves the read-only web UI on the API address until interrupted.
+func runUI(args []string) error {
+	fs := flag.NewFlagSet("ui", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if !cfg.API.UIEnabled {
+		return fmt.Errorf("the web UI is disabled, set api.uiEnabled to serve it")
+	}
+	address := cfg.API.EffectiveListenAddress()
+	if cfg.API.TokenSHA256 == "" && !isLoopback(address) {
+		return fmt.Errorf("the web UI would listen on %s without authentication, set api.tokenSha256 or listen on a loopback address", address)
+	}
+
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	handler, err := ui.Handler(cfg, store)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(ui.Prefix, handler)
+	mux.Handle("GET /{$}", http.RedirectHandler(ui.Prefix, http.StatusFound))
+	port := cfg.API.Port
+	if port == 0 {
+		port = defaultUIPort
+	}
+	var root http.Handler = mux
+	if cfg.API.TokenSHA256 != "" {
+		root = ui.RequireToken(mux, cfg.API.TokenSHA256)
+	}
+	server := &http.Server{
+		Addr:              net.JoinHostPort(address, strconv.Itoa(port)),
+		Handler:           root,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	scheme := "http"
+	if cfg.API.TLSEnabled {
+		scheme = "https"
+	}
+	fmt.Printf("serving the web UI on %s://%s%s\n", scheme, server.Addr, ui.Prefix)
+	if cfg.API.TLSEnabled {
+		err = server.ListenAndServeTLS(cfg.API.TLSCertPath, cfg.API.TLSKeyPath)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve the web UI: %w", err)
+	}
+	return nil
+}
+
+// isLoopback reports whether the listen address only accepts local
+// connections: the unspecified address listens on all interfaces.
+func isLoopback(address string) bool {
+	if address == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
+}
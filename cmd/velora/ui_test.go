@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+)
+
+func TestIsLoopback(t *testing.T) {
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{address: "127.0.0.1", want: true},
+		{address: "127.0.0.53", want: true},
+		{address: "::1", want: true},
+		{address: "localhost", want: true},
+		{address: "0.0.0.0", want: false},
+		{address: "::", want: false},
+		{address: "10.0.0.5", want: false},
+		{address: "velora.example.com", want: false},
+	}
+	for _, tt := range tests {
+		if got := isLoopback(tt.address); got != tt.want {
+			t.Errorf("isLoopback(%q) = %v, want %v", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestRunUIRefusesUnauthenticatedAddress(t *testing.T) {
+	for _, address := range []string{"0.0.0.0", "10.0.0.5"} {
+		cfg := configtest.New(t, func(c *config.Config) {
+			c.API.UIEnabled = true
+			c.API.ListenAddress = address
+		})
+		err := runUI([]string{"-config", writeConfig(t, cfg)})
+		if err == nil || !strings.Contains(err.Error(), "without authentication") {
+			t.Errorf("runUI on %s: got error %v, want a refusal without authentication", address, err)
+		}
+	}
+}
+
+func TestEffectiveListenAddress(t *testing.T) {
+	if got := (&config.APIConfig{}).EffectiveListenAddress(); got != "127.0.0.1" {
+		t.Errorf("default listen address = %q, want 127.0.0.1", got)
+	}
+	if got := (&config.APIConfig{ListenAddress: "0.0.0.0"}).EffectiveListenAddress(); got != "0.0.0.0" {
+		t.Errorf("listen address = %q, want 0.0.0.0", got)
+	}
+}
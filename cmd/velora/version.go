@@ -0,0 +1,13 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/akos011221/velora/internal/version"
+)
+
+// runVersion prints the build metadata.
+func runVersion() error {
+	fmt.Println(version.Get())
+	return nil
+}
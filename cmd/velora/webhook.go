@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/webhooks"
+)
+
+// runWebhook handles the "webhook" command group.
+func runWebhook(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: velora webhook test|deadletter [--config path]")
+	}
+
+	switch args[0] {
+	case "test":
+		return runWebhookTest(args[1:])
+	case "deadletter":
+		return runWebhookDeadLetter(args[1:])
+	default:
+		return fmt.Errorf("unknown webhook command: %s", args[0])
+	}
+}
+
+// runWebhookTest sends a signed sample event to a webhook once, and prints
+// the body and signature sent so integrators can check their verification.
+func runWebhookTest(args []string) error {
+	fs := flag.NewFlagSet("webhook test", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	eventType := fs.String("event", config.WebhookEventRunCompleted, "type of the sample event: "+strings.Join(config.WebhookEvents, ", "))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: velora webhook test [--config path] [--event type] <endpoint>")
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	var endpoint *config.WebhookConfig
+	for i := range cfg.Notifications.Webhooks {
+		if cfg.Notifications.Webhooks[i].Name == fs.Arg(0) {
+			endpoint = &cfg.Notifications.Webhooks[i]
+		}
+	}
+	if endpoint == nil {
+		return fmt.Errorf("no webhook named %s in notifications.webhooks", fs.Arg(0))
+	}
+	secret, err := webhooks.Secret(*endpoint)
+	if err != nil {
+		return err
+	}
+
+	event, err := webhooks.Sample(*eventType)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	// a single attempt and no dead letter, the integrator is watching
+	delivery, err := webhooks.NewNotifier(nil, nil).Send(ctx, *endpoint, secret, event.Type, event.ID, body)
+
+	fmt.Printf("POST %s\n", endpoint.URL)
+	fmt.Printf("X-Velora-Event: %s\n", event.Type)
+	fmt.Printf("X-Velora-Delivery: %s\n", event.ID)
+	fmt.Printf("%s: %s\n\n", webhooks.SignatureHeader, delivery.Signature)
+	fmt.Println(string(delivery.Body))
+	fmt.Println()
+	if err != nil {
+		return fmt.Errorf("webhook %s didn't accept the sample event: %w", endpoint.Name, err)
+	}
+	fmt.Printf("webhook %s accepted the sample event with status %d\n", endpoint.Name, delivery.StatusCode)
+	return nil
+}
+
+// runWebhookDeadLetter prints the events the webhooks didn't accept after
+// every attempt.
+func runWebhookDeadLetter(args []string) error {
+	fs := flag.NewFlagSet("webhook deadletter", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the configuration file")
+	endpoint := fs.String("endpoint", "", "only the events of this webhook")
+	output := fs.String("output", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output %q, allowed values are text, json", *output)
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	all, err := webhooks.DeadLetters(store)
+	if err != nil {
+		return err
+	}
+	deadLetters := []webhooks.DeadLetter{}
+	for _, d := range all {
+		if *endpoint == "" || d.Endpoint == *endpoint {
+			deadLetters = append(deadLetters, d)
+		}
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(deadLetters)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DEAD-LETTERED AT\tENDPOINT\tEVENT\tTYPE\tATTEMPTS\tERROR")
+	for _, d := range deadLetters {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", formatTime(d.At, cfg.DisplayLocation()), d.Endpoint, d.Event.ID, d.Event.Type, d.Attempts,
+			strings.Join(strings.Fields(d.Error), " "))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,303 @@
+// Package attribution finds who made the changes velora reports. When a
+// finding is first reported, the last write of its resource is looked up in
+// the Activity Log and its caller is attached to the finding. Lookups run in
+// the background while the run goes on, paced, and are kept in the state
+// store so later runs attach the caller without looking it up again.
+package attribution
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// stateKey is the state store key holding the callers looked up, by rule
+// and lower-case resource ID.
+const stateKey = "attributions"
+
+// record is the looked up caller of a finding.
+type record struct {
+	SubscriptionID string `json:"subscriptionId"`
+	// Caller is nil if the Activity Log had no write of the resource.
+	Caller     *findings.Caller `json:"caller,omitempty"`
+	LookedUpAt time.Time        `json:"lookedUpAt"`
+}
+
+// lookup is a finding whose caller is to be looked up.
+type lookup struct {
+	key            string
+	subscriptionID string
+	resourceGroup  string
+	resourceID     string
+}
+
+// Enricher attaches the callers of the Activity Log to findings. Findings
+// are observed as the controllers report them and the lookups of those
+// reported for the first time run in the background. Activity Log requests
+// are paced, and the writes of a resource group are listed once per run
+// for all its findings. Subscriptions whose Activity Log can't be read
+// leave their findings unattributed.
+type Enricher struct {
+	clientFactory *azure.ClientFactory
+	config        *config.AttributionConfig
+	store         state.Store
+	// until is the end of the lookback window, the start of the run:
+	// velora's own writes of the run come after it.
+	until time.Time
+
+	mu      sync.Mutex
+	records map[string]*record
+	queued  map[string]bool
+	pending []lookup
+	// events are the writes listed per subscription and resource group.
+	events map[string][]azure.ActivityEvent
+	// denied are the subscriptions whose Activity Log isn't readable.
+	denied map[string]bool
+	busy   bool
+	wake   chan struct{}
+	idle   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewEnricher creates an enricher with the callers looked up by earlier
+// runs.
+func NewEnricher(clientFactory *azure.ClientFactory, cfg *config.AttributionConfig, store state.Store) (*Enricher, error) {
+	records := make(map[string]*record)
+	if err := store.Get(stateKey, &records); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load attributions: %w", err)
+	}
+	return &Enricher{
+		clientFactory: clientFactory,
+		config:        cfg,
+		store:         store,
+		until:         time.Now().UTC(),
+		records:       records,
+		queued:        make(map[string]bool),
+		events:        make(map[string][]azure.ActivityEvent),
+		denied:        make(map[string]bool),
+		wake:          make(chan struct{}, 1),
+		idle:          make(chan struct{}, 1),
+	}, nil
+}
+
+// Start starts looking up the callers of the observed findings in the
+// background, until Stop.
+func (e *Enricher) Start(ctx context.Context) {
+	ctx, e.cancel = context.WithCancel(ctx)
+	e.done = make(chan struct{})
+	go e.work(ctx)
+}
+
+// Stop stops the lookups, those still pending are retried by the next run.
+func (e *Enricher) Stop() {
+	if e.cancel == nil {
+		return
+	}
+	e.cancel()
+	<-e.done
+}
+
+// Observe attaches the callers already known to the findings, and queues
+// the lookup of those reported for the first time. It doesn't wait for the
+// lookups.
+func (e *Enricher) Observe(all []findings.Finding) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range all {
+		f := &all[i]
+		resourceGroup := azure.ExtractResourceIDParts(f.ResourceID)["resourceGroups"]
+		if strings.HasPrefix(f.RuleID, "general/") || resourceGroup == "" {
+			continue
+		}
+		key := findingKey(f.RuleID, f.ResourceID)
+		if r, ok := e.records[key]; ok {
+			f.Caller = r.Caller
+			continue
+		}
+		if e.queued[key] {
+			continue
+		}
+		e.queued[key] = true
+		e.pending = append(e.pending, lookup{key: key, subscriptionID: f.SubscriptionID, resourceGroup: resourceGroup, resourceID: f.ResourceID})
+	}
+	if len(e.pending) > 0 {
+		select {
+		case e.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Wait waits for the pending lookups, at most the configured wait, then
+// stops the lookups.
+func (e *Enricher) Wait() {
+	timeout := time.NewTimer(e.config.Wait())
+	defer timeout.Stop()
+	for {
+		e.mu.Lock()
+		finished := len(e.pending) == 0 && !e.busy
+		e.mu.Unlock()
+		if finished {
+			break
+		}
+		select {
+		case <-e.idle:
+			continue
+		case <-timeout.C:
+		}
+		e.mu.Lock()
+		if n := len(e.pending); n > 0 || e.busy {
+			fmt.Printf("WARNING: %d Activity Log lookups still pending after %s, they're retried by the next run\n", n, e.config.Wait())
+		}
+		e.mu.Unlock()
+		break
+	}
+	e.Stop()
+}
+
+// Attach attaches the known callers to the findings.
+func (e *Enricher) Attach(all []findings.Finding) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range all {
+		if r, ok := e.records[findingKey(all[i].RuleID, all[i].ResourceID)]; ok {
+			all[i].Caller = r.Caller
+		}
+	}
+}
+
+// Commit forgets the callers of the findings no longer reported in the
+// subscriptions the run evaluated, so a finding reported again is looked up
+// again, and saves the callers.
+func (e *Enricher) Commit(reported []findings.Finding, evaluated func(subscriptionID string) bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	current := make(map[string]bool, len(reported))
+	for _, f := range reported {
+		current[findingKey(f.RuleID, f.ResourceID)] = true
+	}
+	for key, r := range e.records {
+		if !current[key] && evaluated(r.SubscriptionID) {
+			delete(e.records, key)
+		}
+	}
+	if err := e.store.Put(stateKey, e.records); err != nil {
+		return fmt.Errorf("failed to save attributions: %w", err)
+	}
+	return nil
+}
+
+// work looks up the pending findings one at a time, pacing the Activity
+// Log requests, until the context is cancelled.
+func (e *Enricher) work(ctx context.Context) {
+	defer close(e.done)
+
+	// velora's own writes from earlier runs aren't attributed
+	self := ""
+	if info, err := e.clientFactory.CheckAuth(ctx); err == nil {
+		self = info.ObjectID
+	}
+	pace := time.Minute / time.Duration(e.config.EffectiveRequestsPerMinute())
+	var next time.Time
+	for {
+		e.mu.Lock()
+		if len(e.pending) == 0 {
+			e.busy = false
+			e.mu.Unlock()
+			select {
+			case e.idle <- struct{}{}:
+			default:
+			}
+			select {
+			case <-e.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		l := e.pending[0]
+		e.pending = e.pending[1:]
+		e.busy = true
+		groupKey := strings.ToLower(l.subscriptionID + "/" + l.resourceGroup)
+		events, listed := e.events[groupKey]
+		denied := e.denied[l.subscriptionID]
+		e.mu.Unlock()
+
+		if denied {
+			continue
+		}
+		if !listed {
+			select {
+			case <-time.After(time.Until(next)):
+			case <-ctx.Done():
+				return
+			}
+			next = time.Now().Add(pace)
+			var err error
+			events, err = e.listWrites(ctx, l)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				e.mu.Lock()
+				if azure.IsAccessDenied(err) {
+					if !e.denied[l.subscriptionID] {
+						fmt.Printf("WARNING: Activity Log of subscription %s isn't readable, its findings stay unattributed: %v\n", l.subscriptionID, err)
+					}
+					e.denied[l.subscriptionID] = true
+				} else {
+					fmt.Printf("WARNING: Activity Log of resource group %s not read, its findings are attributed by the next run: %v\n", l.resourceGroup, err)
+				}
+				e.mu.Unlock()
+				continue
+			}
+		}
+
+		e.mu.Lock()
+		e.events[groupKey] = events
+		e.records[l.key] = &record{SubscriptionID: l.subscriptionID, Caller: lastWrite(events, l.resourceID, self), LookedUpAt: time.Now().UTC()}
+		e.mu.Unlock()
+	}
+}
+
+// listWrites lists the writes of the resource group of the lookup within
+// the lookback window.
+func (e *Enricher) listWrites(ctx context.Context, l lookup) ([]azure.ActivityEvent, error) {
+	client, err := e.clientFactory.ForSubscription(l.subscriptionID).NewActivityLogClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.ListWrites(ctx, l.resourceGroup, e.until.Add(-e.config.Lookback()), e.until)
+}
+
+// lastWrite returns the caller of the newest write of the resource or its
+// child resources, nil if there is none. Writes by self are left out.
+func lastWrite(events []azure.ActivityEvent, resourceID, self string) *findings.Caller {
+	id := strings.ToLower(strings.TrimRight(resourceID, "/"))
+	for _, event := range events {
+		eventID := strings.ToLower(event.ResourceID)
+		if eventID != id && !strings.HasPrefix(eventID, id+"/") {
+			continue
+		}
+		if self != "" && strings.EqualFold(event.ObjectID, self) {
+			continue
+		}
+		return &findings.Caller{Identity: event.Caller, Operation: event.Operation, Timestamp: event.Timestamp}
+	}
+	return nil
+}
+
+// findingKey identifies a finding by rule and resource.
+func findingKey(ruleID, resourceID string) string {
+	return ruleID + "|" + strings.ToLower(resourceID)
+}
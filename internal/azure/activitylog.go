@@ -0,0 +1,119 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// activityLogAPIVersion is the Microsoft.Insights activity log API version.
+const activityLogAPIVersion = "2015-04-01"
+
+// objectIDClaim is the claim of the Activity Log holding the object ID of
+// the caller.
+const objectIDClaim = "http://schemas.microsoft.com/identity/claims/objectidentifier"
+
+// ActivityEvent is a succeeded write or delete recorded in the Activity Log.
+type ActivityEvent struct {
+	ResourceID string
+	// Caller is the user principal name, or the ID of a service principal.
+	Caller string
+	// ObjectID is the object ID of the caller, empty if not recorded.
+	ObjectID  string
+	Operation string
+	Timestamp time.Time
+}
+
+// ActivityLogClient reads the Activity Log of a subscription.
+type ActivityLogClient struct {
+	client         *arm.Client
+	subscriptionID string
+}
+
+// NewActivityLogClient creates a client of the Activity Log of the
+// factory's subscription.
+func (f *ClientFactory) NewActivityLogClient() (*ActivityLogClient, error) {
+	client, err := f.newARMClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure resource manager client: %w", err)
+	}
+	return &ActivityLogClient{client: client, subscriptionID: f.subscriptionID}, nil
+}
+
+// ListWrites returns the succeeded writes and deletes of the resources of
+// the resource group between from and to, newest first.
+func (c *ActivityLogClient) ListWrites(ctx context.Context, resourceGroup string, from, to time.Time) ([]ActivityEvent, error) {
+	endpoint := runtime.JoinPaths(c.client.Endpoint(), "subscriptions", url.PathEscape(c.subscriptionID),
+		"providers/Microsoft.Insights/eventtypes/management/values")
+	filter := fmt.Sprintf("eventTimestamp ge '%s' and eventTimestamp le '%s' and resourceGroupName eq '%s'",
+		from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339), strings.ReplaceAll(resourceGroup, "'", "''"))
+
+	var events []ActivityEvent
+	for endpoint != "" {
+		req, err := runtime.NewRequest(ctx, http.MethodGet, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.Contains(endpoint, "api-version=") {
+			query := req.Raw().URL.Query()
+			query.Set("api-version", activityLogAPIVersion)
+			query.Set("$filter", filter)
+			query.Set("$select", "caller,claims,eventTimestamp,operationName,resourceId,status")
+			req.Raw().URL.RawQuery = query.Encode()
+		}
+
+		resp, err := c.client.Pipeline().Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list activity log events: %w", err)
+		}
+		if !runtime.HasStatusCode(resp, http.StatusOK) {
+			return nil, fmt.Errorf("failed to list activity log events: %w", runtime.NewResponseError(resp))
+		}
+
+		var page struct {
+			Value []struct {
+				ResourceID     string            `json:"resourceId"`
+				Caller         string            `json:"caller"`
+				Claims         map[string]string `json:"claims"`
+				EventTimestamp time.Time         `json:"eventTimestamp"`
+				OperationName  struct {
+					Value string `json:"value"`
+				} `json:"operationName"`
+				Status struct {
+					Value string `json:"value"`
+				} `json:"status"`
+			} `json:"value"`
+			NextLink string `json:"nextLink"`
+		}
+		if err := runtime.UnmarshalAsJSON(resp, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse activity log events: %w", err)
+		}
+		for _, e := range page.Value {
+			operation := strings.ToLower(e.OperationName.Value)
+			if e.Status.Value != "Succeeded" || e.Caller == "" ||
+				!strings.HasSuffix(operation, "/write") && !strings.HasSuffix(operation, "/delete") {
+				continue
+			}
+			events = append(events, ActivityEvent{
+				ResourceID: e.ResourceID,
+				Caller:     e.Caller,
+				ObjectID:   e.Claims[objectIDClaim],
+				Operation:  e.OperationName.Value,
+				Timestamp:  e.EventTimestamp,
+			})
+		}
+		endpoint = page.NextLink
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.After(events[j].Timestamp)
+	})
+	return events, nil
+}
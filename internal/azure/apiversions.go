@@ -0,0 +1,71 @@
+package azure
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+)
+
+// Resource types of the clients created by the factory, as used in
+// azure.apiVersionOverrides.
+const (
+	ResourceTypeVirtualNetworks        = "Microsoft.Network/virtualNetworks"
+	ResourceTypeSubnets                = "Microsoft.Network/virtualNetworks/subnets"
+	ResourceTypePeerings               = "Microsoft.Network/virtualNetworks/virtualNetworkPeerings"
+	ResourceTypeRouteTables            = "Microsoft.Network/routeTables"
+	ResourceTypeRoutes                 = "Microsoft.Network/routeTables/routes"
+	ResourceTypeVirtualNetworkGateways = "Microsoft.Network/virtualNetworkGateways"
+	ResourceTypeAzureFirewalls         = "Microsoft.Network/azureFirewalls"
+	ResourceTypeNetworkWatchers        = "Microsoft.Network/networkWatchers"
+	ResourceTypeFlowLogs               = "Microsoft.Network/networkWatchers/flowLogs"
+	ResourceTypeVirtualHubs            = "Microsoft.Network/virtualHubs"
+	ResourceTypeHubConnections         = "Microsoft.Network/virtualHubs/hubVirtualNetworkConnections"
+	ResourceTypeHubRouteTables         = "Microsoft.Network/virtualHubs/hubRouteTables"
+	ResourceTypeSecurityGroups         = "Microsoft.Network/networkSecurityGroups"
+	ResourceTypeSecurityRules          = "Microsoft.Network/networkSecurityGroups/securityRules"
+	ResourceTypeNetworkInterfaces      = "Microsoft.Network/networkInterfaces"
+	ResourceTypeLoadBalancers          = "Microsoft.Network/loadBalancers"
+	ResourceTypeIPGroups               = "Microsoft.Network/ipGroups"
+	ResourceTypeNatGateways            = "Microsoft.Network/natGateways"
+)
+
+// APIVersion returns the API version used for the resource type, the
+// configured override or NetworkAPIVersion.
+func (f *ClientFactory) APIVersion(resourceType string) string {
+	if version, ok := f.apiVersions[strings.ToLower(resourceType)]; ok {
+		return version
+	}
+	return NetworkAPIVersion
+}
+
+// options returns the client options for the resource type, pinning the
+// API version if it's overridden.
+func (f *ClientFactory) options(resourceType string) *arm.ClientOptions {
+	version, ok := f.apiVersions[strings.ToLower(resourceType)]
+	if !ok {
+		return f.clientOptions
+	}
+	options := *f.clientOptions
+	options.APIVersion = version
+	return &options
+}
+
+// logAPIVersions prints the pinned API versions, so the versions in effect
+// are visible at startup.
+func logAPIVersions(overrides map[string]string) {
+	if len(overrides) == 0 {
+		return
+	}
+
+	types := make([]string, 0, len(overrides))
+	for resourceType := range overrides {
+		types = append(types, resourceType)
+	}
+	sort.Strings(types)
+	for _, resourceType := range types {
+		fmt.Printf("using api-version %s for %s (pinned)\n", overrides[resourceType], resourceType)
+	}
+	fmt.Printf("using api-version %s for other network resources\n", NetworkAPIVersion)
+}
@@ -0,0 +1,72 @@
+package azure
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+)
+
+func TestAPIVersionOverrides(t *testing.T) {
+	const (
+		pinned       = "2022-01-01"
+		routeTableID = "/subscriptions/00000000-0000-0000-0000-000000000002/resourceGroups/spoke-rg/providers/Microsoft.Network/routeTables/spoke-rt"
+	)
+	cfg := &config.AzureConfig{
+		SubscriptionID: "00000000-0000-0000-0000-000000000002",
+		// resource types are matched regardless of case
+		APIVersionOverrides: map[string]string{"microsoft.network/routetables": pinned},
+	}
+	arm := azuretest.NewServer()
+	arm.Put(routeTableID, azuretest.RouteTable(routeTableID))
+	factory := NewClientFactoryWithTransport(cfg, azuretest.Credential{}, arm)
+	ctx := context.Background()
+
+	routeTables, err := factory.NewRouteTablesClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := routeTables.Get(ctx, "spoke-rg", "spoke-rt", nil); err != nil {
+		t.Fatal(err)
+	}
+	routes, err := factory.NewRoutesClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := armnetwork.Route{Properties: &armnetwork.RoutePropertiesFormat{
+		AddressPrefix: to.Ptr("0.0.0.0/0"),
+		NextHopType:   to.Ptr(armnetwork.RouteNextHopTypeInternet),
+	}}
+	poller, err := routes.BeginCreateOrUpdate(ctx, "spoke-rg", "spoke-rt", "default", route, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	// created, PutResource refuses to overwrite without an etag
+	if err := factory.PutResource(ctx, routeTableID+"-new", factory.APIVersion(ResourceTypeRouteTables), "", []byte(`{"location":"westeurope"}`), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := factory.APIVersion(ResourceTypeRouteTables); got != pinned {
+		t.Errorf("APIVersion(%s) = %s, want %s", ResourceTypeRouteTables, got, pinned)
+	}
+	if got := factory.APIVersion(ResourceTypeRoutes); got != NetworkAPIVersion {
+		t.Errorf("APIVersion(%s) = %s, want %s", ResourceTypeRoutes, got, NetworkAPIVersion)
+	}
+	want := []string{pinned, NetworkAPIVersion, pinned}
+	requests := arm.Requests()
+	if len(requests) != len(want) {
+		t.Fatalf("requests = %v, want %d", requests, len(want))
+	}
+	for i, req := range requests {
+		if got := req.Query.Get("api-version"); got != want[i] {
+			t.Errorf("%s api-version = %s, want %s", req, got, want[i])
+		}
+	}
+}
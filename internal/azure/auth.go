@@ -0,0 +1,92 @@
+package azure
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+const (
+	// CredentialTypeDefault is the DefaultAzureCredential chain (managed identity, environment, CLI).
+	CredentialTypeDefault = "DefaultAzureCredential"
+	// CredentialTypeClientSecret is a service principal with a client secret.
+	CredentialTypeClientSecret = "ClientSecretCredential"
+
+	// armScope is the token scope for Azure Resource Manager.
+	armScope = "https://management.azure.com/.default"
+)
+
+// AuthInfo describes the identity resolved from an ARM access token.
+type AuthInfo struct {
+	// CredentialType is the credential that acquired the token, the member
+	// of the chain with DefaultAzureCredential.
+	CredentialType string
+	// Chain is DefaultAzureCredential when the credential is a member of
+	// its chain.
+	Chain        string
+	ObjectID     string
+	TenantID     string
+	AppID        string
+	IdentityType string
+}
+
+// CheckAuth acquires a token for the ARM scope and returns the identity it was issued for.
+func (f *ClientFactory) CheckAuth(ctx context.Context) (*AuthInfo, error) {
+	token, err := f.cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{armScope}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire token using %s: %w", f.credentialType, err)
+	}
+
+	claims, err := parseTokenClaims(token.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &AuthInfo{
+		CredentialType: f.credentialType,
+		ObjectID:       claims.ObjectID,
+		TenantID:       claims.TenantID,
+		AppID:          claims.AppID,
+		IdentityType:   claims.IdentityType,
+	}
+	if f.chain != nil {
+		if member := f.chain.member(); member != "" {
+			info.CredentialType = member
+			info.Chain = CredentialTypeDefault
+		}
+	}
+	return info, nil
+}
+
+// tokenClaims are the claims of interest in an Entra ID access token.
+type tokenClaims struct {
+	ObjectID     string `json:"oid"`
+	TenantID     string `json:"tid"`
+	AppID        string `json:"appid"`
+	IdentityType string `json:"idtyp"`
+}
+
+// parseTokenClaims decodes the payload of a JWT without verifying it,
+// the token is only inspected, never trusted.
+func parseTokenClaims(token string) (*tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("unexpected access token format")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode access token payload: %w", err)
+	}
+
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("failed to parse access token claims: %w", err)
+	}
+
+	return &claims, nil
+}
@@ -0,0 +1,97 @@
+package azuretest
+
+import (
+	"net/netip"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// Location is the location of the fixtures.
+const Location = "westeurope"
+
+// VNet returns a VNet with the address space and the subnets.
+func VNet(id string, addressPrefixes []string, subnets ...*armnetwork.Subnet) *armnetwork.VirtualNetwork {
+	return &armnetwork.VirtualNetwork{
+		ID:       to.Ptr(id),
+		Location: to.Ptr(Location),
+		Properties: &armnetwork.VirtualNetworkPropertiesFormat{
+			AddressSpace: &armnetwork.AddressSpace{AddressPrefixes: to.SliceOfPtrs(addressPrefixes...)},
+			Subnets:      subnets,
+		},
+	}
+}
+
+// Subnet returns a subnet with the prefix, associated with the route table
+// unless its ID is empty.
+func Subnet(name, prefix, routeTableID string) *armnetwork.Subnet {
+	subnet := &armnetwork.Subnet{
+		Name:       to.Ptr(name),
+		Properties: &armnetwork.SubnetPropertiesFormat{AddressPrefix: to.Ptr(prefix)},
+	}
+	if routeTableID != "" {
+		subnet.Properties.RouteTable = &armnetwork.RouteTable{ID: to.Ptr(routeTableID)}
+	}
+	return subnet
+}
+
+// RouteTable returns a route table with the routes.
+func RouteTable(id string, routes ...*armnetwork.Route) *armnetwork.RouteTable {
+	return &armnetwork.RouteTable{
+		ID:         to.Ptr(id),
+		Location:   to.Ptr(Location),
+		Properties: &armnetwork.RouteTablePropertiesFormat{Routes: routes},
+	}
+}
+
+// Route returns a route to the virtual appliance at nextHop, or of the next
+// hop type nextHop if it isn't an IP address.
+func Route(name, prefix, nextHop string) *armnetwork.Route {
+	route := &armnetwork.Route{
+		Name:       to.Ptr(name),
+		Properties: &armnetwork.RoutePropertiesFormat{AddressPrefix: to.Ptr(prefix)},
+	}
+	if _, err := netip.ParseAddr(nextHop); err == nil {
+		route.Properties.NextHopType = to.Ptr(armnetwork.RouteNextHopTypeVirtualAppliance)
+		route.Properties.NextHopIPAddress = to.Ptr(nextHop)
+	} else {
+		route.Properties.NextHopType = to.Ptr(armnetwork.RouteNextHopType(nextHop))
+	}
+	return route
+}
+
+// ForwardingNIC returns a NIC with IP forwarding enabled holding the IP in
+// the subnet, an NVA.
+func ForwardingNIC(id, subnetID, ip string) *armnetwork.Interface {
+	return &armnetwork.Interface{
+		ID:       to.Ptr(id),
+		Location: to.Ptr(Location),
+		Properties: &armnetwork.InterfacePropertiesFormat{
+			EnableIPForwarding: to.Ptr(true),
+			IPConfigurations: []*armnetwork.InterfaceIPConfiguration{{
+				Name: to.Ptr("ipconfig1"),
+				Properties: &armnetwork.InterfaceIPConfigurationPropertiesFormat{
+					PrivateIPAddress: to.Ptr(ip),
+					Subnet:           &armnetwork.Subnet{ID: to.Ptr(subnetID)},
+				},
+			}},
+		},
+	}
+}
+
+// Peering returns a Connected peering with the remote VNet, recording its
+// address space, in the sync level.
+func Peering(name, remoteVNetID string, remotePrefixes []string, syncLevel armnetwork.VirtualNetworkPeeringLevel) *armnetwork.VirtualNetworkPeering {
+	return &armnetwork.VirtualNetworkPeering{
+		Name: to.Ptr(name),
+		Properties: &armnetwork.VirtualNetworkPeeringPropertiesFormat{
+			RemoteVirtualNetwork:      &armnetwork.SubResource{ID: to.Ptr(remoteVNetID)},
+			RemoteAddressSpace:        &armnetwork.AddressSpace{AddressPrefixes: to.SliceOfPtrs(remotePrefixes...)},
+			PeeringState:              to.Ptr(armnetwork.VirtualNetworkPeeringStateConnected),
+			PeeringSyncLevel:          to.Ptr(syncLevel),
+			AllowVirtualNetworkAccess: to.Ptr(true),
+			AllowForwardedTraffic:     to.Ptr(true),
+			UseRemoteGateways:         to.Ptr(false),
+		},
+	}
+}
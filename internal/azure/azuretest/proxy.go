@@ -0,0 +1,124 @@
+package azuretest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ARMHost is the host the clients send ARM requests to.
+const ARMHost = "management.azure.com"
+
+// NewTLSServer serves the server over TLS with a certificate for ARMHost,
+// issued by a CA of its own. It returns the listener, and the path of a PEM
+// bundle of the CA for azure.caBundlePath. ARM requests only reach it
+// through a Proxy tunneling to its address.
+func NewTLSServer(t testing.TB, s *Server) (*httptest.Server, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: ARMHost},
+		DNSNames:              []string{ARMHost},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(s)
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	// clients refusing the certificate are expected
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server, caBundle
+}
+
+// Proxy is a forward proxy recording the hosts asked of it. It tunnels
+// every CONNECT to its target address, whatever host is asked, and refuses
+// anything else.
+type Proxy struct {
+	*httptest.Server
+	target string
+
+	mu    sync.Mutex
+	hosts []string
+}
+
+// NewProxy starts a proxy tunneling to the target address, e.g. the
+// listener of NewTLSServer. With an empty target it refuses every request.
+func NewProxy(t testing.TB, target string) *Proxy {
+	p := &Proxy{target: target}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serve))
+	t.Cleanup(p.Close)
+	return p
+}
+
+// Hosts returns the hosts asked of the proxy, in order.
+func (p *Proxy) Hosts() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.hosts...)
+}
+
+// serve records the host and tunnels a CONNECT to the target.
+func (p *Proxy) serve(w http.ResponseWriter, req *http.Request) {
+	p.mu.Lock()
+	p.hosts = append(p.hosts, req.Host)
+	p.mu.Unlock()
+
+	if req.Method != http.MethodConnect || p.target == "" {
+		http.Error(w, "proxy refused the request", http.StatusBadGateway)
+		return
+	}
+	upstream, err := net.Dial("tcp", p.target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	conn, buffered, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		conn.Close()
+		upstream.Close()
+		return
+	}
+	go func() {
+		_, _ = io.Copy(upstream, buffered)
+		upstream.Close()
+	}()
+	_, _ = io.Copy(conn, upstream)
+	conn.Close()
+}
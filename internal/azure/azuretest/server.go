@@ -0,0 +1,493 @@
+// Package azuretest is an in-memory Azure Resource Manager for tests: a
+// transport serving the resources put into it, and a credential.
+package azuretest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// inlineCollections are the child resources ARM returns inline in the
+// properties of their parent, e.g. the subnets of a VNet.
+var inlineCollections = []string{"subnets", "virtualNetworkPeerings", "routes", "securityRules"}
+
+// Request is a request the server received.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+// String returns the method and path of the request.
+func (r Request) String() string {
+	return r.Method + " " + r.Path
+}
+
+// Server serves the resources it holds by ID: GET reads a resource or lists
+// a collection, PUT replaces, PATCH merges and DELETE removes a resource.
+// Writes honour If-Match against the etag of the resource. Subscriptions are
+// Enabled and the caller has every permission unless they're put.
+type Server struct {
+	mu        sync.Mutex
+	resources map[string]map[string]any
+	etags     int
+	handlers  map[string]http.HandlerFunc
+	requests  []Request
+}
+
+// NewServer creates an empty server.
+func NewServer() *Server {
+	return &Server{
+		resources: make(map[string]map[string]any),
+		handlers:  make(map[string]http.HandlerFunc),
+	}
+}
+
+// Put stores the resource under the ID, replacing it. The resource is an
+// SDK model or any value encoding to a JSON object, its child resources
+// listed inline are stored as resources of their own.
+func (s *Server) Put(id string, resource any) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		panic(fmt.Sprintf("azuretest: failed to encode %s: %v", id, err))
+	}
+	var body map[string]any
+	if err := json.Unmarshal(data, &body); err != nil {
+		panic(fmt.Sprintf("azuretest: %s isn't a JSON object: %v", id, err))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(id, body)
+}
+
+// Get decodes the resource with the ID into v, with its inline child
+// resources. It returns false if there is no such resource.
+func (s *Server) Get(id string, v any) bool {
+	s.mu.Lock()
+	body, ok := s.get(id)
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+	data, _ := json.Marshal(body)
+	if err := json.Unmarshal(data, v); err != nil {
+		panic(fmt.Sprintf("azuretest: failed to decode %s: %v", id, err))
+	}
+	return true
+}
+
+// Delete removes the resource with the ID and its child resources.
+func (s *Server) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delete(id)
+}
+
+// Handle serves the requests of the method to the path with the handler
+// instead, an empty method matches every method. The path is compared
+// without its query and case-insensitively.
+func (s *Server) Handle(method, path string, handler http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[handlerKey(method, path)] = handler
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Writes returns the requests received so far that aren't reads.
+func (s *Server) Writes() []Request {
+	var writes []Request
+	for _, req := range s.Requests() {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			writes = append(writes, req)
+		}
+	}
+	return writes
+}
+
+// Reset forgets the requests received so far.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+}
+
+// Do implements policy.Transporter.
+func (s *Server) Do(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  req.URL.Query(),
+		Header: req.Header.Clone(),
+		Body:   body,
+	})
+	handler, ok := s.handlers[handlerKey(req.Method, req.URL.Path)]
+	if !ok {
+		handler, ok = s.handlers[handlerKey("", req.URL.Path)]
+	}
+	s.mu.Unlock()
+
+	if ok {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+		resp := recorder.Result()
+		resp.Request = req
+		return resp, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, payload := s.serve(req, body)
+	return response(req, status, payload), nil
+}
+
+// ServeHTTP implements http.Handler, serving the resources over a listener,
+// see NewTLSServer.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	resp, err := s.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// serve answers the request from the resources.
+func (s *Server) serve(req *http.Request, body []byte) (int, any) {
+	path := strings.TrimSuffix(req.URL.Path, "/")
+	if strings.HasSuffix(strings.ToLower(path), tagsPath) {
+		return s.serveTags(req, path[:len(path)-len(tagsPath)], body)
+	}
+	current, exists := s.get(path)
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		if match := req.Header.Get("If-Match"); match != "" && (!exists || (match != "*" && match != current["etag"])) {
+			return http.StatusPreconditionFailed, armError("PreconditionFailed", "the etag of %s doesn't match %s", path, match)
+		}
+		if req.Header.Get("If-None-Match") == "*" && exists {
+			return http.StatusPreconditionFailed, armError("PreconditionFailed", "%s already exists", path)
+		}
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		if exists {
+			return http.StatusOK, current
+		}
+		if def, ok := defaultResource(path); ok {
+			return http.StatusOK, def
+		}
+		if isCollection(path) {
+			return http.StatusOK, map[string]any{"value": s.list(path)}
+		}
+		return http.StatusNotFound, armError("ResourceNotFound", "the resource %s was not found", path)
+
+	case http.MethodPut:
+		var resource map[string]any
+		if err := json.Unmarshal(body, &resource); err != nil {
+			return http.StatusBadRequest, armError("InvalidRequestContent", "the body of %s isn't a JSON object: %v", path, err)
+		}
+		s.put(path, resource)
+		stored, _ := s.get(path)
+		return http.StatusOK, stored
+
+	case http.MethodPatch:
+		if !exists {
+			return http.StatusNotFound, armError("ResourceNotFound", "the resource %s was not found", path)
+		}
+		var patch map[string]any
+		if err := json.Unmarshal(body, &patch); err != nil {
+			return http.StatusBadRequest, armError("InvalidRequestContent", "the body of %s isn't a JSON object: %v", path, err)
+		}
+		stored := s.resources[strings.ToLower(path)]
+		for key, value := range patch {
+			stored[key] = value
+		}
+		s.etags++
+		stored["etag"] = fmt.Sprintf(`W/"%d"`, s.etags)
+		patched, _ := s.get(path)
+		return http.StatusOK, patched
+
+	case http.MethodDelete:
+		if !exists {
+			return http.StatusNoContent, nil
+		}
+		s.delete(path)
+		return http.StatusOK, nil
+	}
+	return http.StatusNotFound, armError("ResourceNotFound", "%s %s isn't supported", req.Method, path)
+}
+
+// tagsPath is the lower-case suffix of the tags of a resource in the
+// Microsoft.Resources tags API.
+const tagsPath = "/providers/microsoft.resources/tags/default"
+
+// serveTags answers a request to the tags of the resource. Only the Merge
+// operation is supported, it doesn't change the etag of the resource.
+func (s *Server) serveTags(req *http.Request, resourceID string, body []byte) (int, any) {
+	stored, ok := s.resources[strings.ToLower(resourceID)]
+	if !ok {
+		return http.StatusNotFound, armError("ResourceNotFound", "the resource %s was not found", resourceID)
+	}
+	if req.Method != http.MethodPatch {
+		return http.StatusNotFound, armError("ResourceNotFound", "%s of the tags of %s isn't supported", req.Method, resourceID)
+	}
+	var patch struct {
+		Operation  string `json:"operation"`
+		Properties struct {
+			Tags map[string]any `json:"tags"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(body, &patch); err != nil || patch.Operation != "Merge" {
+		return http.StatusBadRequest, armError("InvalidRequestContent", "the tags of %s must be merged", resourceID)
+	}
+	tags, _ := stored["tags"].(map[string]any)
+	if tags == nil {
+		tags = make(map[string]any)
+		stored["tags"] = tags
+	}
+	for key, value := range patch.Properties.Tags {
+		tags[key] = value
+	}
+	return http.StatusOK, map[string]any{
+		"id":         resourceID + tagsPath,
+		"properties": map[string]any{"tags": tags},
+	}
+}
+
+// put stores the resource with a new etag, its inline child resources
+// replace the stored ones.
+func (s *Server) put(id string, body map[string]any) {
+	id = strings.TrimSuffix(id, "/")
+	body["id"] = id
+	body["name"] = id[strings.LastIndex(id, "/")+1:]
+	s.etags++
+	body["etag"] = fmt.Sprintf(`W/"%d"`, s.etags)
+	properties, _ := body["properties"].(map[string]any)
+	if properties == nil {
+		properties = make(map[string]any)
+		body["properties"] = properties
+	}
+	if _, ok := properties["provisioningState"]; !ok {
+		properties["provisioningState"] = "Succeeded"
+	}
+
+	for _, collection := range inlineCollections {
+		children, ok := properties[collection].([]any)
+		if !ok {
+			continue
+		}
+		delete(properties, collection)
+		for _, childID := range s.children(id, collection) {
+			s.delete(childID)
+		}
+		for _, child := range children {
+			child, ok := child.(map[string]any)
+			if !ok {
+				continue
+			}
+			name, _ := child["name"].(string)
+			if name == "" {
+				continue
+			}
+			s.put(id+"/"+collection+"/"+name, child)
+		}
+	}
+	s.resources[strings.ToLower(id)] = body
+}
+
+// get returns a copy of the resource with its inline child resources.
+func (s *Server) get(id string) (map[string]any, bool) {
+	stored, ok := s.resources[strings.ToLower(strings.TrimSuffix(id, "/"))]
+	if !ok {
+		return nil, false
+	}
+	data, _ := json.Marshal(stored)
+	var body map[string]any
+	_ = json.Unmarshal(data, &body)
+	properties, _ := body["properties"].(map[string]any)
+	for _, collection := range inlineCollections {
+		childIDs := s.children(stored["id"].(string), collection)
+		if len(childIDs) == 0 || properties == nil {
+			continue
+		}
+		children := make([]any, 0, len(childIDs))
+		for _, childID := range childIDs {
+			child, _ := s.get(childID)
+			children = append(children, child)
+		}
+		properties[collection] = children
+	}
+	return body, true
+}
+
+// delete removes the resource and its child resources.
+func (s *Server) delete(id string) {
+	prefix := strings.ToLower(strings.TrimSuffix(id, "/"))
+	for key := range s.resources {
+		if key == prefix || strings.HasPrefix(key, prefix+"/") {
+			delete(s.resources, key)
+		}
+	}
+}
+
+// children returns the IDs of the child resources of the collection of
+// the parent, sorted.
+func (s *Server) children(parentID, collection string) []string {
+	prefix := strings.ToLower(parentID + "/" + collection + "/")
+	var ids []string
+	for key, body := range s.resources {
+		if strings.HasPrefix(key, prefix) && !strings.Contains(key[len(prefix):], "/") {
+			ids = append(ids, body["id"].(string))
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// list returns the resources of the collection, those of the resource
+// groups of the subscription for a subscription-wide collection, sorted by
+// ID.
+func (s *Server) list(collection string) []any {
+	collection = strings.ToLower(collection)
+	var ids []string
+	for key, body := range s.resources {
+		parent := key[:strings.LastIndex(key, "/")]
+		if parent == collection || stripResourceGroup(parent) == collection {
+			ids = append(ids, body["id"].(string))
+		}
+	}
+	sort.Strings(ids)
+	items := make([]any, 0, len(ids))
+	for _, id := range ids {
+		item, _ := s.get(id)
+		items = append(items, item)
+	}
+	return items
+}
+
+// stripResourceGroup removes the resource group from a lower-case path.
+func stripResourceGroup(path string) string {
+	segments := strings.Split(path, "/")
+	if len(segments) > 5 && segments[3] == "resourcegroups" && segments[5] == "providers" {
+		return strings.Join(append(segments[:3:3], segments[5:]...), "/")
+	}
+	return path
+}
+
+// isCollection reports whether the path is a collection of resources
+// rather than a resource: its last segment is a resource type.
+func isCollection(path string) bool {
+	segments := strings.Split(strings.Trim(strings.ToLower(path), "/"), "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		if segments[i] == "providers" {
+			return len(segments[i+1:])%2 == 0
+		}
+	}
+	return len(segments)%2 == 1
+}
+
+// defaultResource returns the resources the server has without being put:
+// an Enabled subscription and every permission on it.
+func defaultResource(path string) (any, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) == 2 && strings.EqualFold(segments[0], "subscriptions") {
+		return map[string]any{
+			"id":             path,
+			"subscriptionId": segments[1],
+			"displayName":    segments[1],
+			"state":          "Enabled",
+		}, true
+	}
+	if strings.HasSuffix(strings.ToLower(path), "/providers/microsoft.authorization/permissions") {
+		return map[string]any{"value": []any{map[string]any{"actions": []string{"*"}, "notActions": []string{}}}}, true
+	}
+	return nil, false
+}
+
+// armError is the body of an ARM error response.
+func armError(code, format string, args ...any) map[string]any {
+	return map[string]any{"error": map[string]any{"code": code, "message": fmt.Sprintf(format, args...)}}
+}
+
+// response encodes the payload as the JSON body of the response.
+func response(req *http.Request, status int, payload any) *http.Response {
+	var body []byte
+	if payload != nil {
+		body, _ = json.Marshal(payload)
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	if status >= 400 {
+		if e, ok := payload.(map[string]any)["error"].(map[string]any); ok {
+			header.Set("x-ms-error-code", fmt.Sprint(e["code"]))
+		}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// handlerKey is the key of the handler of the method and path.
+func handlerKey(method, path string) string {
+	return strings.ToUpper(method) + " " + strings.ToLower(strings.TrimSuffix(path, "/"))
+}
+
+// Credential issues tokens for a fixed identity, without a token endpoint.
+type Credential struct {
+	ObjectID string
+	TenantID string
+}
+
+// GetToken implements azcore.TokenCredential.
+func (c Credential) GetToken(ctx context.Context, _ policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	claims, _ := json.Marshal(map[string]string{"oid": c.ObjectID, "tid": c.TenantID})
+	return azcore.AccessToken{
+		Token:     "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".",
+		ExpiresOn: time.Now().Add(time.Hour),
+	}, nil
+}
@@ -0,0 +1,119 @@
+package azuretest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+const (
+	subscriptionID = "00000000-0000-0000-0000-000000000001"
+	routeTableID   = "/subscriptions/" + subscriptionID + "/resourceGroups/rg/providers/Microsoft.Network/routeTables/rt"
+)
+
+func newRoutesClients(t *testing.T, server *Server) (*armnetwork.RouteTablesClient, *armnetwork.RoutesClient) {
+	t.Helper()
+	options := &arm.ClientOptions{}
+	options.Transport = server
+	routeTables, err := armnetwork.NewRouteTablesClient(subscriptionID, Credential{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err := armnetwork.NewRoutesClient(subscriptionID, Credential{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return routeTables, routes
+}
+
+func TestServerInlineChildren(t *testing.T) {
+	server := NewServer()
+	server.Put(routeTableID, RouteTable(routeTableID, Route("default", "0.0.0.0/0", "10.0.0.4")))
+	routeTables, routes := newRoutesClients(t, server)
+	ctx := context.Background()
+
+	poller, err := routes.BeginCreateOrUpdate(ctx, "rg", "rt", "onprem", *Route("", "192.168.0.0/16", "10.0.0.4"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *created.ID != routeTableID+"/routes/onprem" || created.Etag == nil {
+		t.Errorf("created route = %s etag %v", *created.ID, created.Etag)
+	}
+
+	pager := routeTables.NewListAllPager(nil)
+	page, err := pager.NextPage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Value) != 1 || len(page.Value[0].Properties.Routes) != 2 {
+		t.Fatalf("listed route tables = %+v", page.Value)
+	}
+	if names := []string{*page.Value[0].Properties.Routes[0].Name, *page.Value[0].Properties.Routes[1].Name}; names[0] != "default" || names[1] != "onprem" {
+		t.Errorf("inline routes = %v", names)
+	}
+
+	if _, err := routes.Get(ctx, "rg", "rt", "missing", nil); !isStatus(err, http.StatusNotFound) {
+		t.Errorf("Get() of a missing route error = %v, want 404", err)
+	}
+}
+
+func TestServerIfMatch(t *testing.T) {
+	server := NewServer()
+	server.Put(routeTableID, RouteTable(routeTableID, Route("default", "0.0.0.0/0", "10.0.0.4")))
+	var route armnetwork.Route
+	if !server.Get(routeTableID+"/routes/default", &route) {
+		t.Fatal("inline route not stored")
+	}
+	_, routes := newRoutesClients(t, server)
+	ctx := context.Background()
+
+	if _, err := routes.BeginDelete(ifMatch(ctx, `W/"stale"`), "rg", "rt", "default", nil); !isStatus(err, http.StatusPreconditionFailed) {
+		t.Errorf("BeginDelete() with a stale etag error = %v, want 412", err)
+	}
+	if _, err := routes.BeginDelete(ifMatch(ctx, *route.Etag), "rg", "rt", "default", nil); err != nil {
+		t.Errorf("BeginDelete() with the current etag error = %v", err)
+	}
+	if server.Get(routeTableID+"/routes/default", &route) {
+		t.Error("route not deleted")
+	}
+	if writes := server.Writes(); len(writes) != 2 {
+		t.Errorf("Writes() = %v, want the 2 deletes", writes)
+	}
+}
+
+// ifMatch adds the If-Match header to the requests of the context.
+func ifMatch(ctx context.Context, etag string) context.Context {
+	return runtime.WithHTTPHeader(ctx, http.Header{"If-Match": {etag}})
+}
+
+func isStatus(err error, status int) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == status
+}
+
+func TestIsCollection(t *testing.T) {
+	tests := map[string]bool{
+		"/subscriptions":                                       true,
+		"/subscriptions/" + subscriptionID:                     false,
+		"/subscriptions/" + subscriptionID + "/resourceGroups": true,
+		"/subscriptions/" + subscriptionID + "/providers/Microsoft.Network/routeTables": true,
+		routeTableID:                     false,
+		routeTableID + "/routes":         true,
+		routeTableID + "/routes/default": false,
+	}
+	for path, want := range tests {
+		if got := isCollection(path); got != want {
+			t.Errorf("isCollection(%s) = %t, want %t", path, got, want)
+		}
+	}
+}
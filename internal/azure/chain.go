@@ -0,0 +1,125 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// Members of the default credential chain, in the order they are tried.
+const (
+	CredentialTypeEnvironment       = "EnvironmentCredential"
+	CredentialTypeWorkloadIdentity  = "WorkloadIdentityCredential"
+	CredentialTypeManagedIdentity   = "ManagedIdentityCredential"
+	CredentialTypeAzureCLI          = "AzureCLICredential"
+	CredentialTypeAzureDeveloperCLI = "AzureDeveloperCLICredential"
+)
+
+// credentialChain tries the credentials of the DefaultAzureCredential chain
+// in turn, and records the one that acquired a token.
+type credentialChain struct {
+	cred azcore.TokenCredential
+	used atomic.Value
+}
+
+// chainMember is a named credential of a chain.
+type chainMember struct {
+	name string
+	cred azcore.TokenCredential
+	// unreachableUnavailable moves on to the next member when the credential
+	// fails without a response, the managed identity endpoint only exists
+	// on Azure
+	unreachableUnavailable bool
+	chain                  *credentialChain
+}
+
+// unavailableCredential is a member that couldn't be created, it is skipped.
+type unavailableCredential struct {
+	name string
+	err  error
+}
+
+// GetToken implements azcore.TokenCredential.
+func (c unavailableCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{}, azidentity.NewCredentialUnavailableError(c.name + ": " + c.err.Error())
+}
+
+// newDefaultCredentialChain builds the chain of DefaultAzureCredential from
+// its members: environment, workload identity, managed identity, Azure CLI
+// and Azure Developer CLI.
+func newDefaultCredentialChain(clientOptions azcore.ClientOptions) (*credentialChain, error) {
+	managedIdentity := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: clientOptions}
+	if id, ok := os.LookupEnv("AZURE_CLIENT_ID"); ok {
+		managedIdentity.ID = azidentity.ClientID(id)
+	}
+
+	environment, err := azidentity.NewEnvironmentCredential(&azidentity.EnvironmentCredentialOptions{ClientOptions: clientOptions})
+	members := []chainMember{namedMember(CredentialTypeEnvironment, environment, err)}
+	workloadIdentity, err := azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{ClientOptions: clientOptions})
+	members = append(members, namedMember(CredentialTypeWorkloadIdentity, workloadIdentity, err))
+	managed, err := azidentity.NewManagedIdentityCredential(managedIdentity)
+	member := namedMember(CredentialTypeManagedIdentity, managed, err)
+	member.unreachableUnavailable = true
+	members = append(members, member)
+	cli, err := azidentity.NewAzureCLICredential(nil)
+	members = append(members, namedMember(CredentialTypeAzureCLI, cli, err))
+	developerCLI, err := azidentity.NewAzureDeveloperCLICredential(nil)
+	members = append(members, namedMember(CredentialTypeAzureDeveloperCLI, developerCLI, err))
+	return newCredentialChain(members)
+}
+
+// namedMember returns the named chain member of a credential, skipped by
+// the chain if it couldn't be created.
+func namedMember(name string, cred azcore.TokenCredential, err error) chainMember {
+	if err != nil {
+		return chainMember{name: name, cred: unavailableCredential{name: name, err: err}}
+	}
+	return chainMember{name: name, cred: cred}
+}
+
+// newCredentialChain chains the members in order.
+func newCredentialChain(members []chainMember) (*credentialChain, error) {
+	chain := &credentialChain{}
+	sources := make([]azcore.TokenCredential, len(members))
+	for i := range members {
+		members[i].chain = chain
+		sources[i] = &members[i]
+	}
+	cred, err := azidentity.NewChainedTokenCredential(sources, nil)
+	if err != nil {
+		return nil, err
+	}
+	chain.cred = cred
+	return chain, nil
+}
+
+// GetToken implements azcore.TokenCredential.
+func (c *credentialChain) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return c.cred.GetToken(ctx, options)
+}
+
+// member returns the name of the member that acquired the last token, empty
+// before one was acquired.
+func (c *credentialChain) member() string {
+	name, _ := c.used.Load().(string)
+	return name
+}
+
+// GetToken implements azcore.TokenCredential.
+func (m *chainMember) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	token, err := m.cred.GetToken(ctx, options)
+	if err == nil {
+		m.chain.used.Store(m.name)
+		return token, nil
+	}
+	var authErr *azidentity.AuthenticationFailedError
+	if m.unreachableUnavailable && ctx.Err() == nil && !(errors.As(err, &authErr) && authErr.RawResponse != nil) {
+		return token, azidentity.NewCredentialUnavailableError(m.name + ": " + err.Error())
+	}
+	return token, err
+}
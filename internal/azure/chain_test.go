@@ -0,0 +1,118 @@
+package azure
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// stubCredential returns its token or error and counts its calls.
+type stubCredential struct {
+	token string
+	err   error
+	calls int
+}
+
+func (c *stubCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.calls++
+	return azcore.AccessToken{Token: c.token}, c.err
+}
+
+// jwt returns an unsigned token with the claims.
+func jwt(claims string) string {
+	return "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+}
+
+func TestCheckAuthReportsChainMember(t *testing.T) {
+	token := jwt(`{"oid":"object","tid":"tenant"}`)
+	tests := []struct {
+		name    string
+		members []chainMember
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "first available member",
+			members: []chainMember{
+				{name: CredentialTypeEnvironment, cred: &stubCredential{err: azidentity.NewCredentialUnavailableError("not configured")}},
+				{name: CredentialTypeWorkloadIdentity, cred: &stubCredential{err: azidentity.NewCredentialUnavailableError("not configured")}},
+				{name: CredentialTypeAzureCLI, cred: &stubCredential{token: token}},
+			},
+			want: CredentialTypeAzureCLI,
+		},
+		{
+			name: "unreachable managed identity endpoint",
+			members: []chainMember{
+				{name: CredentialTypeManagedIdentity, cred: &stubCredential{err: errors.New("connection refused")}, unreachableUnavailable: true},
+				{name: CredentialTypeAzureCLI, cred: &stubCredential{token: token}},
+			},
+			want: CredentialTypeAzureCLI,
+		},
+		{
+			name: "managed identity",
+			members: []chainMember{
+				{name: CredentialTypeEnvironment, cred: unavailableCredential{name: CredentialTypeEnvironment, err: errors.New("missing environment variables")}},
+				{name: CredentialTypeManagedIdentity, cred: &stubCredential{token: token}, unreachableUnavailable: true},
+				{name: CredentialTypeAzureCLI, cred: &stubCredential{err: errors.New("must not be called")}},
+			},
+			want: CredentialTypeManagedIdentity,
+		},
+		{
+			name: "failing member ends the chain",
+			members: []chainMember{
+				{name: CredentialTypeEnvironment, cred: &stubCredential{err: errors.New("invalid client secret")}},
+				{name: CredentialTypeAzureCLI, cred: &stubCredential{token: token}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := newCredentialChain(tt.members)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if member := chain.member(); member != "" {
+				t.Fatalf("member before the first token = %q", member)
+			}
+			factory := &ClientFactory{cred: chain, chain: chain, credentialType: CredentialTypeDefault}
+
+			info, err := factory.CheckAuth(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("CheckAuth() = %+v, want an error", info)
+				}
+				if member := chain.member(); member != "" {
+					t.Errorf("member after a failure = %q", member)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CheckAuth() error = %v", err)
+			}
+			if info.CredentialType != tt.want || info.Chain != CredentialTypeDefault {
+				t.Errorf("CheckAuth() credential = %q (chain %q), want %q (chain %q)", info.CredentialType, info.Chain, tt.want, CredentialTypeDefault)
+			}
+			if info.ObjectID != "object" || info.TenantID != "tenant" {
+				t.Errorf("CheckAuth() identity = %+v", info)
+			}
+		})
+	}
+}
+
+func TestCheckAuthClientSecret(t *testing.T) {
+	factory := &ClientFactory{cred: &stubCredential{token: jwt(`{"oid":"object","appid":"app"}`)}, credentialType: CredentialTypeClientSecret}
+	info, err := factory.CheckAuth(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CredentialType != CredentialTypeClientSecret || info.Chain != "" || info.AppID != "app" {
+		t.Errorf("CheckAuth() = %+v", info)
+	}
+}
@@ -3,13 +3,18 @@ package azure
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
 
 	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/version"
 )
 
 // ClientFactory is for creating factory-like clients for Azure services.
@@ -17,40 +22,120 @@ type ClientFactory struct {
 	cred           azcore.TokenCredential
 	clientOptions  *arm.ClientOptions
 	subscriptionID string
+	credentialType string
+	// chain is the default credential chain, nil with a client secret.
+	chain *credentialChain
+	// apiVersions holds the API version overrides by lower-case resource type.
+	apiVersions map[string]string
+	readOnly    bool
+	// reads, latencies and pages are shared with the factories scoped from
+	// this one.
+	reads     *atomic.Uint64
+	latencies *latencyRecorder
+	pages     *pageSizer
 }
 
 // NewClientFactory creates a new (Azure) ClientFactory instance.
 func NewClientFactory(cfg *config.AzureConfig) (*ClientFactory, error) {
 	var cred azcore.TokenCredential
+	var credentialType string
+	var chain *credentialChain
+	var endpoint string
 	var err error
 
+	// fail early instead of at the first token acquisition
+	if missing := cfg.MissingCredentialFields(); len(missing) > 0 {
+		return nil, fmt.Errorf("client secret authentication requires %s to be set", strings.Join(missing, ", "))
+	}
+	for _, field := range cfg.IgnoredCredentialFields() {
+		fmt.Println("WARNING:", field, "is set but ignored because azure.useAzureIdentity is true")
+	}
+
+	// ARM and identity traffic share the configured proxy and CA bundle
+	httpClient, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var transport policy.Transporter
+	if httpClient != nil {
+		transport = httpClient
+	}
+
 	// credential is created based on the configuration
 	if cfg.UseAzureIdentity {
-		// use managed identity or environment credentials
-		cred, err = azidentity.NewDefaultAzureCredential(nil)
+		// use managed identity or environment credentials, the chain of
+		// DefaultAzureCredential built from named members to tell which
+		// acquired the token
+		chain, err = newDefaultCredentialChain(azcore.ClientOptions{Transport: transport})
 		if err != nil {
 			return nil, fmt.Errorf("failed to create default azure credential: %w", err)
 		}
+		cred = chain
+		credentialType = CredentialTypeDefault
+		endpoint = imdsEndpoint
 	} else {
 		// use client credentials
 		cred, err = azidentity.NewClientSecretCredential(
 			cfg.TenantID,
 			cfg.ClientID,
 			cfg.ClientSecret,
-			nil,
+			&azidentity.ClientSecretCredentialOptions{ClientOptions: azcore.ClientOptions{Transport: transport}},
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create azure client credential: %w", err)
 		}
+		credentialType = CredentialTypeClientSecret
+		endpoint = entraIDEndpoint
+	}
+
+	// a blackholed token endpoint would otherwise hang the first request
+	timeout := defaultTokenTimeout
+	if cfg.TokenTimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.TokenTimeoutSeconds) * time.Second
 	}
+	cred = &timeoutCredential{cred: cred, timeout: timeout, endpoint: endpoint}
+
+	return newClientFactory(cfg, cred, credentialType, chain, transport), nil
+}
 
+// NewClientFactoryWithTransport creates a ClientFactory sending its requests
+// through the transport with the credential, for tests against a fake ARM
+// such as azuretest.Server. Retries wait a few milliseconds, unless a
+// throttled response asks for longer.
+func NewClientFactoryWithTransport(cfg *config.AzureConfig, cred azcore.TokenCredential, transport policy.Transporter) *ClientFactory {
+	f := newClientFactory(cfg, cred, "", nil, transport)
+	f.clientOptions.Retry = policy.RetryOptions{RetryDelay: time.Millisecond, MaxRetryDelay: 2 * time.Second}
+	return f
+}
+
+// newClientFactory creates a ClientFactory with the credential, its clients
+// count, time and size their requests.
+func newClientFactory(cfg *config.AzureConfig, cred azcore.TokenCredential, credentialType string, chain *credentialChain, transport policy.Transporter) *ClientFactory {
 	clientOptions := &arm.ClientOptions{}
+	clientOptions.Transport = transport
+	clientOptions.Telemetry.ApplicationID = version.ApplicationID()
+	reads := new(atomic.Uint64)
+	latencies := &latencyRecorder{samples: make(Latencies)}
+	pages := newPageSizer(cfg)
+	clientOptions.PerCallPolicies = append(clientOptions.PerCallPolicies, readCounter{reads: reads}, latencyPolicy{recorder: latencies}, pagePolicy{sizer: pages})
+
+	apiVersions := make(map[string]string, len(cfg.APIVersionOverrides))
+	for resourceType, apiVersion := range cfg.APIVersionOverrides {
+		apiVersions[strings.ToLower(resourceType)] = apiVersion
+	}
+	logAPIVersions(cfg.APIVersionOverrides)
 
 	return &ClientFactory{
 		cred:           cred,
 		clientOptions:  clientOptions,
 		subscriptionID: cfg.SubscriptionID,
-	}, nil
+		credentialType: credentialType,
+		chain:          chain,
+		apiVersions:    apiVersions,
+		reads:          reads,
+		latencies:      latencies,
+		pages:          pages,
+	}
 }
 
 // GetCredential returns the Azure credential.
@@ -58,6 +143,11 @@ func (f *ClientFactory) GetCredential() azcore.TokenCredential {
 	return f.cred
 }
 
+// GetCredentialType returns the type of the credential in use.
+func (f *ClientFactory) GetCredentialType() string {
+	return f.credentialType
+}
+
 // GetSubscriptionID returns the current Azure subscription ID.
 func (f *ClientFactory) GetSubscriptionID() string {
 	return f.subscriptionID
@@ -70,7 +160,7 @@ func (f *ClientFactory) SetSubscriptionID(subscriptionID string) {
 
 // NewVirtualNeworksClient creates a new VNet client.
 func (f *ClientFactory) NewVirtualNeworksClient(ctx context.Context) (*armnetwork.VirtualNetworksClient, error) {
-	client, err := armnetwork.NewVirtualNetworksClient(f.subscriptionID, f.cred, f.clientOptions)
+	client, err := armnetwork.NewVirtualNetworksClient(f.subscriptionID, f.cred, f.options(ResourceTypeVirtualNetworks))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create azure virtual networks client: %w", err)
 	}
@@ -79,7 +169,7 @@ func (f *ClientFactory) NewVirtualNeworksClient(ctx context.Context) (*armnetwor
 
 // NewSubnetsClient creates a new Subnets client.
 func (f *ClientFactory) NewSubnetsClient(ctx context.Context) (*armnetwork.SubnetsClient, error) {
-	client, err := armnetwork.NewSubnetsClient(f.subscriptionID, f.cred, f.clientOptions)
+	client, err := armnetwork.NewSubnetsClient(f.subscriptionID, f.cred, f.options(ResourceTypeSubnets))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create azure subnets client: %w", err)
 	}
@@ -88,7 +178,7 @@ func (f *ClientFactory) NewSubnetsClient(ctx context.Context) (*armnetwork.Subne
 
 // NewRouteTablesClient creates a new Route Tables client.
 func (f *ClientFactory) NewRouteTablesClient(ctx context.Context) (*armnetwork.RouteTablesClient, error) {
-	client, err := armnetwork.NewRouteTablesClient(f.subscriptionID, f.cred, f.clientOptions)
+	client, err := armnetwork.NewRouteTablesClient(f.subscriptionID, f.cred, f.options(ResourceTypeRouteTables))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create azure route tables client: %w", err)
 	}
@@ -97,7 +187,7 @@ func (f *ClientFactory) NewRouteTablesClient(ctx context.Context) (*armnetwork.R
 
 // NewRoutesClient creates a new Routes client.
 func (f *ClientFactory) NewRoutesClient(ctx context.Context) (*armnetwork.RoutesClient, error) {
-	client, err := armnetwork.NewRoutesClient(f.subscriptionID, f.cred, f.clientOptions)
+	client, err := armnetwork.NewRoutesClient(f.subscriptionID, f.cred, f.options(ResourceTypeRoutes))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create azure routes client: %w", err)
 	}
@@ -106,9 +196,141 @@ func (f *ClientFactory) NewRoutesClient(ctx context.Context) (*armnetwork.Routes
 
 // NewVirtualNetworkPeeringsClient creates a new VNet peerings client.
 func (f *ClientFactory) NewVirtualNetworkPeeringsClient(ctx context.Context) (*armnetwork.VirtualNetworkPeeringsClient, error) {
-	client, err := armnetwork.NewVirtualNetworkPeeringsClient(f.subscriptionID, f.cred, f.clientOptions)
+	client, err := armnetwork.NewVirtualNetworkPeeringsClient(f.subscriptionID, f.cred, f.options(ResourceTypePeerings))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create azure virtual network peerings client: %w", err)
 	}
 	return client, nil
 }
+
+// NewVirtualNetworkGatewaysClient creates a new VNet gateways client.
+func (f *ClientFactory) NewVirtualNetworkGatewaysClient(ctx context.Context) (*armnetwork.VirtualNetworkGatewaysClient, error) {
+	client, err := armnetwork.NewVirtualNetworkGatewaysClient(f.subscriptionID, f.cred, f.options(ResourceTypeVirtualNetworkGateways))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure virtual network gateways client: %w", err)
+	}
+	return client, nil
+}
+
+// NewAzureFirewallsClient creates a new Azure Firewalls client.
+func (f *ClientFactory) NewAzureFirewallsClient(ctx context.Context) (*armnetwork.AzureFirewallsClient, error) {
+	client, err := armnetwork.NewAzureFirewallsClient(f.subscriptionID, f.cred, f.options(ResourceTypeAzureFirewalls))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure firewalls client: %w", err)
+	}
+	return client, nil
+}
+
+// NewSecurityGroupsClient creates a new network security groups client.
+func (f *ClientFactory) NewSecurityGroupsClient(ctx context.Context) (*armnetwork.SecurityGroupsClient, error) {
+	client, err := armnetwork.NewSecurityGroupsClient(f.subscriptionID, f.cred, f.options(ResourceTypeSecurityGroups))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure network security groups client: %w", err)
+	}
+	return client, nil
+}
+
+// NewIPGroupsClient creates a new IP Groups client.
+func (f *ClientFactory) NewIPGroupsClient(ctx context.Context) (*armnetwork.IPGroupsClient, error) {
+	client, err := armnetwork.NewIPGroupsClient(f.subscriptionID, f.cred, f.options(ResourceTypeIPGroups))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure IP groups client: %w", err)
+	}
+	return client, nil
+}
+
+// NewWatchersClient creates a new Network Watchers client.
+func (f *ClientFactory) NewWatchersClient(ctx context.Context) (*armnetwork.WatchersClient, error) {
+	client, err := armnetwork.NewWatchersClient(f.subscriptionID, f.cred, f.options(ResourceTypeNetworkWatchers))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure network watchers client: %w", err)
+	}
+	return client, nil
+}
+
+// NewFlowLogsClient creates a new Network Watcher flow logs client.
+func (f *ClientFactory) NewFlowLogsClient(ctx context.Context) (*armnetwork.FlowLogsClient, error) {
+	client, err := armnetwork.NewFlowLogsClient(f.subscriptionID, f.cred, f.options(ResourceTypeFlowLogs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure flow logs client: %w", err)
+	}
+	return client, nil
+}
+
+// NewVirtualHubsClient creates a new virtual WAN hubs client.
+func (f *ClientFactory) NewVirtualHubsClient(ctx context.Context) (*armnetwork.VirtualHubsClient, error) {
+	client, err := armnetwork.NewVirtualHubsClient(f.subscriptionID, f.cred, f.options(ResourceTypeVirtualHubs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure virtual hubs client: %w", err)
+	}
+	return client, nil
+}
+
+// NewHubVirtualNetworkConnectionsClient creates a new virtual hub VNet connections client.
+func (f *ClientFactory) NewHubVirtualNetworkConnectionsClient(ctx context.Context) (*armnetwork.HubVirtualNetworkConnectionsClient, error) {
+	client, err := armnetwork.NewHubVirtualNetworkConnectionsClient(f.subscriptionID, f.cred, f.options(ResourceTypeHubConnections))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure hub virtual network connections client: %w", err)
+	}
+	return client, nil
+}
+
+// NewHubRouteTablesClient creates a new virtual hub route tables client.
+func (f *ClientFactory) NewHubRouteTablesClient(ctx context.Context) (*armnetwork.HubRouteTablesClient, error) {
+	client, err := armnetwork.NewHubRouteTablesClient(f.subscriptionID, f.cred, f.options(ResourceTypeHubRouteTables))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure hub route tables client: %w", err)
+	}
+	return client, nil
+}
+
+// NewInterfacesClient creates a new network interfaces client.
+func (f *ClientFactory) NewInterfacesClient(ctx context.Context) (*armnetwork.InterfacesClient, error) {
+	client, err := armnetwork.NewInterfacesClient(f.subscriptionID, f.cred, f.options(ResourceTypeNetworkInterfaces))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure network interfaces client: %w", err)
+	}
+	return client, nil
+}
+
+// NewLoadBalancersClient creates a new load balancers client.
+func (f *ClientFactory) NewLoadBalancersClient(ctx context.Context) (*armnetwork.LoadBalancersClient, error) {
+	client, err := armnetwork.NewLoadBalancersClient(f.subscriptionID, f.cred, f.options(ResourceTypeLoadBalancers))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure load balancers client: %w", err)
+	}
+	return client, nil
+}
+
+// NewNatGatewaysClient creates a new NAT gateways client.
+func (f *ClientFactory) NewNatGatewaysClient(ctx context.Context) (*armnetwork.NatGatewaysClient, error) {
+	client, err := armnetwork.NewNatGatewaysClient(f.subscriptionID, f.cred, f.options(ResourceTypeNatGateways))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure NAT gateways client: %w", err)
+	}
+	return client, nil
+}
+
+// NewUsagesClient creates a new network usages client.
+func (f *ClientFactory) NewUsagesClient(ctx context.Context) (*armnetwork.UsagesClient, error) {
+	client, err := armnetwork.NewUsagesClient(f.subscriptionID, f.cred, f.clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure network usages client: %w", err)
+	}
+	return client, nil
+}
+
+// ForSubscription returns a copy of the factory scoped to the given subscription,
+// sharing the credential and client options. Unlike SetSubscriptionID it is
+// safe to use from concurrent workers.
+func (f *ClientFactory) ForSubscription(subscriptionID string) *ClientFactory {
+	scoped := *f
+	scoped.subscriptionID = subscriptionID
+	return &scoped
+}
+
+// newARMClient creates a client for the ARM requests velora builds itself.
+// The SDK requires a semantic version of the module.
+func (f *ClientFactory) newARMClient() (*arm.Client, error) {
+	return arm.NewClient("velora", "v1.0.0", f.cred, f.clientOptions)
+}
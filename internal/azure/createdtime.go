@@ -0,0 +1,75 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// resourcesAPIVersion is the Microsoft.Resources resources API version.
+const resourcesAPIVersion = "2021-04-01"
+
+// ListCreatedTimes returns the creation time of the resources of the given
+// types in the factory's subscription, keyed by lowercased resource ID.
+// Resources ARM has no creation time for are left out.
+func (f *ClientFactory) ListCreatedTimes(ctx context.Context, resourceTypes ...string) (map[string]time.Time, error) {
+	client, err := f.newARMClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure resource manager client: %w", err)
+	}
+
+	var filters []string
+	for _, resourceType := range resourceTypes {
+		filters = append(filters, fmt.Sprintf("resourceType eq '%s'", resourceType))
+	}
+	endpoint := runtime.JoinPaths(client.Endpoint(), "subscriptions", url.PathEscape(f.subscriptionID), "resources")
+
+	created := make(map[string]time.Time)
+	for endpoint != "" {
+		req, err := runtime.NewRequest(ctx, http.MethodGet, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.Contains(endpoint, "api-version=") {
+			query := req.Raw().URL.Query()
+			query.Set("api-version", resourcesAPIVersion)
+			query.Set("$expand", "createdTime")
+			if len(filters) > 0 {
+				query.Set("$filter", strings.Join(filters, " or "))
+			}
+			req.Raw().URL.RawQuery = query.Encode()
+		}
+
+		resp, err := client.Pipeline().Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list resources: %w", err)
+		}
+		if !runtime.HasStatusCode(resp, http.StatusOK) {
+			return nil, fmt.Errorf("failed to list resources: %w", runtime.NewResponseError(resp))
+		}
+
+		var page struct {
+			Value []struct {
+				ID          string    `json:"id"`
+				CreatedTime time.Time `json:"createdTime"`
+			} `json:"value"`
+			NextLink string `json:"nextLink"`
+		}
+		if err := runtime.UnmarshalAsJSON(resp, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse resources: %w", err)
+		}
+		for _, resource := range page.Value {
+			if resource.ID != "" && !resource.CreatedTime.IsZero() {
+				created[strings.ToLower(resource.ID)] = resource.CreatedTime
+			}
+		}
+		endpoint = page.NextLink
+	}
+
+	return created, nil
+}
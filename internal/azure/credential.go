@@ -0,0 +1,49 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// Token endpoints named in timeout errors.
+const (
+	imdsEndpoint        = "169.254.169.254 (managed identity) or login.microsoftonline.com"
+	entraIDEndpoint     = "login.microsoftonline.com"
+	defaultTokenTimeout = 30 * time.Second
+)
+
+// TokenTimeoutError is returned when acquiring a token takes longer than the
+// configured timeout, usually because the endpoint isn't reachable.
+type TokenTimeoutError struct {
+	Endpoint string
+	Timeout  time.Duration
+}
+
+// Error implements error.
+func (e *TokenTimeoutError) Error() string {
+	return fmt.Sprintf("token acquisition timed out after %s talking to %s", e.Timeout, e.Endpoint)
+}
+
+// timeoutCredential bounds the token acquisition of a credential.
+type timeoutCredential struct {
+	cred     azcore.TokenCredential
+	timeout  time.Duration
+	endpoint string
+}
+
+// GetToken implements azcore.TokenCredential.
+func (c *timeoutCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	token, err := c.cred.GetToken(ctx, options)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return token, &TokenTimeoutError{Endpoint: c.endpoint, Timeout: c.timeout}
+	}
+	return token, err
+}
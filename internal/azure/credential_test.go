@@ -0,0 +1,85 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+)
+
+// blackhole is a token endpoint that never answers, like an IMDS endpoint
+// dropped by a firewall.
+type blackhole struct{}
+
+// Do implements policy.Transporter.
+func (blackhole) Do(req *http.Request) (*http.Response, error) {
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func TestTimeoutCredentialBlackholedIMDS(t *testing.T) {
+	mi, err := azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+		ClientOptions: azcore.ClientOptions{Transport: blackhole{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const timeout = 100 * time.Millisecond
+	cred := &timeoutCredential{cred: mi, timeout: timeout, endpoint: imdsEndpoint}
+
+	started := time.Now()
+	_, err = cred.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{"https://management.azure.com/.default"}})
+	elapsed := time.Since(started)
+
+	var timeoutErr *TokenTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("GetToken() error = %v, want a TokenTimeoutError", err)
+	}
+	if timeoutErr.Endpoint != imdsEndpoint || timeoutErr.Timeout != timeout {
+		t.Errorf("GetToken() error = %v, want the IMDS endpoint and the timeout", err)
+	}
+	if elapsed > 10*timeout {
+		t.Errorf("GetToken() took %s, want it bounded by %s", elapsed, timeout)
+	}
+}
+
+func TestTimeoutCredentialPassesErrors(t *testing.T) {
+	failed := errors.New("invalid client secret")
+	cred := &timeoutCredential{cred: &stubCredential{err: failed}, timeout: time.Minute, endpoint: entraIDEndpoint}
+
+	_, err := cred.GetToken(context.Background(), policy.TokenRequestOptions{})
+	var timeoutErr *TokenTimeoutError
+	if !errors.Is(err, failed) || errors.As(err, &timeoutErr) {
+		t.Errorf("GetToken() error = %v, want %v", err, failed)
+	}
+}
+
+func TestTimeoutCredentialBoundsRequests(t *testing.T) {
+	cfg := &config.AzureConfig{SubscriptionID: "00000000-0000-0000-0000-000000000002"}
+	mi, err := azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+		ClientOptions: azcore.ClientOptions{Transport: blackhole{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := &timeoutCredential{cred: mi, timeout: 100 * time.Millisecond, endpoint: imdsEndpoint}
+	client, err := NewClientFactoryWithTransport(cfg, cred, azuretest.NewServer()).NewRouteTablesClient(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.NewListAllPager(&armnetwork.RouteTablesClientListAllOptions{}).NextPage(context.Background())
+	var timeoutErr *TokenTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Errorf("NextPage() error = %v, want a TokenTimeoutError", err)
+	}
+}
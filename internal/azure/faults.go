@@ -0,0 +1,121 @@
+package azure
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/akos011221/velora/internal/config"
+)
+
+// faultRule is a compiled fault injection rule.
+type faultRule struct {
+	config.FaultInjectionRule
+	pattern *regexp.Regexp
+	matched int
+}
+
+// FaultInjectionTransport wraps a transport and injects ARM faults into
+// matching requests. It is only meant for non-production testing.
+type FaultInjectionTransport struct {
+	next policy.Transporter
+
+	mu    sync.Mutex
+	rand  *rand.Rand
+	rules []*faultRule
+}
+
+// NewFaultInjectionTransport creates a new fault injection transport wrapping next.
+// The same seed produces the same sequence of faults for the same requests.
+func NewFaultInjectionTransport(cfg *config.FaultInjectionConfig, next policy.Transporter) (*FaultInjectionTransport, error) {
+	if next == nil {
+		next = http.DefaultClient
+	}
+
+	t := &FaultInjectionTransport{
+		next: next,
+		rand: rand.New(rand.NewSource(cfg.Seed)),
+	}
+	for i, rule := range cfg.Faults {
+		pattern, err := regexp.Compile(rule.ResourcePattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resourcePattern in fault %d: %w", i, err)
+		}
+		t.rules = append(t.rules, &faultRule{FaultInjectionRule: rule, pattern: pattern})
+	}
+
+	return t, nil
+}
+
+// Do implements policy.Transporter.
+func (t *FaultInjectionTransport) Do(req *http.Request) (*http.Response, error) {
+	if rule := t.pick(req); rule != nil {
+		if rule.Hang {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}
+		return faultResponse(req, rule.StatusCode), nil
+	}
+	return t.next.Do(req)
+}
+
+// pick returns the first rule injecting a fault into the request, if any.
+func (t *FaultInjectionTransport) pick(req *http.Request) *faultRule {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, rule := range t.rules {
+		if rule.Method != "" && !strings.EqualFold(rule.Method, req.Method) {
+			continue
+		}
+		if !rule.pattern.MatchString(req.URL.Path) {
+			continue
+		}
+
+		rule.matched++
+		if rule.NthRequest > 0 && rule.matched%rule.NthRequest == 0 {
+			return rule
+		}
+		if rule.Probability > 0 && t.rand.Float64() < rule.Probability {
+			return rule
+		}
+	}
+	return nil
+}
+
+// faultResponse builds an ARM-style error response.
+func faultResponse(req *http.Request, statusCode int) *http.Response {
+	code := "InjectedFault"
+	header := http.Header{"Content-Type": {"application/json"}}
+	if statusCode == http.StatusTooManyRequests {
+		code = "TooManyRequests"
+		header.Set("Retry-After", "1")
+	}
+
+	body := fmt.Sprintf(`{"error":{"code":%q,"message":"fault injected by velora for %s %s"}}`, code, req.Method, req.URL.Path)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// EnableFaultInjection wraps the transport of all clients created by the
+// factory with a fault injection transport.
+func (f *ClientFactory) EnableFaultInjection(cfg *config.FaultInjectionConfig) error {
+	transport, err := NewFaultInjectionTransport(cfg, f.clientOptions.Transport)
+	if err != nil {
+		return err
+	}
+	f.clientOptions.Transport = transport
+	return nil
+}
@@ -0,0 +1,59 @@
+package azure
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+)
+
+// injected sends n GETs of the path through a fault injection transport of
+// the configuration and returns their status codes.
+func injected(t *testing.T, cfg *config.FaultInjectionConfig, path string, n int) []int {
+	t.Helper()
+	transport, err := NewFaultInjectionTransport(cfg, azuretest.NewServer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var codes []int
+	for i := 0; i < n; i++ {
+		req, err := http.NewRequest(http.MethodGet, "https://management.azure.com"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := transport.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		codes = append(codes, resp.StatusCode)
+	}
+	return codes
+}
+
+func TestFaultInjectionTransport(t *testing.T) {
+	const routeTables = "/subscriptions/00000000-0000-0000-0000-000000000002/providers/Microsoft.Network/routeTables"
+
+	nth := &config.FaultInjectionConfig{Faults: []config.FaultInjectionRule{{ResourcePattern: "routeTables$", NthRequest: 3, StatusCode: 429}}}
+	if got, want := injected(t, nth, routeTables, 6), []int{200, 200, 429, 200, 200, 429}; !reflect.DeepEqual(got, want) {
+		t.Errorf("nthRequest faults = %v, want %v", got, want)
+	}
+
+	other := &config.FaultInjectionConfig{Faults: []config.FaultInjectionRule{{ResourcePattern: "virtualNetworks$", Probability: 1, StatusCode: 500}}}
+	if got, want := injected(t, other, routeTables, 2), []int{200, 200}; !reflect.DeepEqual(got, want) {
+		t.Errorf("faults of another resource = %v, want %v", got, want)
+	}
+
+	seeded := func(seed int64) *config.FaultInjectionConfig {
+		return &config.FaultInjectionConfig{Seed: seed, Faults: []config.FaultInjectionRule{{ResourcePattern: "routeTables$", Probability: 0.5, StatusCode: 500}}}
+	}
+	first := injected(t, seeded(42), routeTables, 32)
+	if again := injected(t, seeded(42), routeTables, 32); !reflect.DeepEqual(first, again) {
+		t.Errorf("faults of the same seed differ:\n%v\n%v", first, again)
+	}
+	if other := injected(t, seeded(7), routeTables, 32); reflect.DeepEqual(first, other) {
+		t.Errorf("faults of seeds 42 and 7 are the same: %v", first)
+	}
+}
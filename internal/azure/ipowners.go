@@ -0,0 +1,85 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// IPOwner is a NIC or load balancer frontend holding a private IP in a VNet.
+type IPOwner struct {
+	// ID is the ID of the NIC or the load balancer.
+	ID        string
+	PrivateIP string
+	// LoadBalancer is set for load balancer frontends, which forward
+	// traffic to their backends without IP forwarding.
+	LoadBalancer bool
+	// IPForwarding is set for NICs with IP forwarding enabled.
+	IPForwarding bool
+}
+
+// CanForward reports whether traffic routed to the IP reaches a forwarder.
+func (o IPOwner) CanForward() bool {
+	return o.LoadBalancer || o.IPForwarding
+}
+
+// ListIPOwners lists the NICs and load balancer frontends of the factory's
+// subscription with a private IP in a subnet of the VNet.
+func (f *ClientFactory) ListIPOwners(ctx context.Context, vnetID string) ([]IPOwner, error) {
+	subnetPrefix := strings.ToLower(vnetID) + "/subnets/"
+	inVNet := func(subnetID *string) bool {
+		return subnetID != nil && strings.HasPrefix(strings.ToLower(*subnetID), subnetPrefix)
+	}
+
+	interfacesClient, err := f.NewInterfacesClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var owners []IPOwner
+	nicPager := interfacesClient.NewListAllPager(nil)
+	for nicPager.More() {
+		page, err := nicPager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list network interfaces: %w", err)
+		}
+		for _, nic := range page.Value {
+			if nic == nil || nic.ID == nil || nic.Properties == nil {
+				continue
+			}
+			forwarding := nic.Properties.EnableIPForwarding != nil && *nic.Properties.EnableIPForwarding
+			for _, ipConfig := range nic.Properties.IPConfigurations {
+				if ipConfig == nil || ipConfig.Properties == nil || ipConfig.Properties.Subnet == nil ||
+					ipConfig.Properties.PrivateIPAddress == nil || !inVNet(ipConfig.Properties.Subnet.ID) {
+					continue
+				}
+				owners = append(owners, IPOwner{ID: *nic.ID, PrivateIP: *ipConfig.Properties.PrivateIPAddress, IPForwarding: forwarding})
+			}
+		}
+	}
+
+	loadBalancersClient, err := f.NewLoadBalancersClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lbPager := loadBalancersClient.NewListAllPager(nil)
+	for lbPager.More() {
+		page, err := lbPager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list load balancers: %w", err)
+		}
+		for _, lb := range page.Value {
+			if lb == nil || lb.ID == nil || lb.Properties == nil {
+				continue
+			}
+			for _, frontend := range lb.Properties.FrontendIPConfigurations {
+				if frontend == nil || frontend.Properties == nil || frontend.Properties.Subnet == nil ||
+					frontend.Properties.PrivateIPAddress == nil || !inVNet(frontend.Properties.Subnet.ID) {
+					continue
+				}
+				owners = append(owners, IPOwner{ID: *lb.ID, PrivateIP: *frontend.Properties.PrivateIPAddress, LoadBalancer: true})
+			}
+		}
+	}
+
+	return owners, nil
+}
@@ -0,0 +1,188 @@
+package azure
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// RequestSample is the outcome of an ARM request, retries included.
+type RequestSample struct {
+	Duration time.Duration
+	Failed   bool
+}
+
+// Latencies are ARM requests by operation, see OperationOf.
+type Latencies map[string][]RequestSample
+
+// OperationLatency summarizes the requests of an operation.
+type OperationLatency struct {
+	Operation string  `json:"operation"`
+	Count     int     `json:"count"`
+	P50Ms     float64 `json:"p50Ms"`
+	P95Ms     float64 `json:"p95Ms"`
+	MaxMs     float64 `json:"maxMs"`
+	ErrorRate float64 `json:"errorRate"`
+}
+
+// Table summarizes the requests per operation, sorted by operation.
+func (l Latencies) Table() []OperationLatency {
+	table := make([]OperationLatency, 0, len(l))
+	for operation, samples := range l {
+		if len(samples) == 0 {
+			continue
+		}
+		durations := make([]time.Duration, len(samples))
+		failed := 0
+		for i, sample := range samples {
+			durations[i] = sample.Duration
+			if sample.Failed {
+				failed++
+			}
+		}
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		table = append(table, OperationLatency{
+			Operation: operation,
+			Count:     len(samples),
+			P50Ms:     milliseconds(percentile(durations, 50)),
+			P95Ms:     milliseconds(percentile(durations, 95)),
+			MaxMs:     milliseconds(durations[len(durations)-1]),
+			ErrorRate: float64(failed) / float64(len(samples)),
+		})
+	}
+	sort.Slice(table, func(i, j int) bool { return table[i].Operation < table[j].Operation })
+	return table
+}
+
+// percentile returns the nearest-rank percentile of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// milliseconds returns d in milliseconds, to the microsecond.
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// latencyRecorder records the latency of the ARM requests of the clients of
+// a factory by operation.
+type latencyRecorder struct {
+	mu      sync.Mutex
+	samples Latencies
+}
+
+// latencyPolicy records the requests of the clients in the recorder. It is
+// a per-call policy, the latency of a request includes its retries.
+type latencyPolicy struct {
+	recorder *latencyRecorder
+}
+
+// Do implements policy.Policy.
+func (p latencyPolicy) Do(req *policy.Request) (*http.Response, error) {
+	raw := req.Raw()
+	start := time.Now()
+	resp, err := req.Next()
+	sample := RequestSample{Duration: time.Since(start), Failed: err != nil || resp.StatusCode >= 400}
+
+	operation := OperationOf(raw.Method, raw.URL.Path)
+	p.recorder.mu.Lock()
+	p.recorder.samples[operation] = append(p.recorder.samples[operation], sample)
+	p.recorder.mu.Unlock()
+	return resp, err
+}
+
+// LatencyMark marks the requests recorded so far, LatenciesSince returns
+// those after it.
+type LatencyMark map[string]int
+
+// MarkLatencies marks the requests recorded by the clients of the factory
+// and of the factories scoped from it.
+func (f *ClientFactory) MarkLatencies() LatencyMark {
+	f.latencies.mu.Lock()
+	defer f.latencies.mu.Unlock()
+	mark := make(LatencyMark, len(f.latencies.samples))
+	for operation, samples := range f.latencies.samples {
+		mark[operation] = len(samples)
+	}
+	return mark
+}
+
+// LatenciesSince returns the requests recorded after the mark.
+func (f *ClientFactory) LatenciesSince(mark LatencyMark) Latencies {
+	f.latencies.mu.Lock()
+	defer f.latencies.mu.Unlock()
+	latencies := make(Latencies)
+	for operation, samples := range f.latencies.samples {
+		if n := mark[operation]; n < len(samples) {
+			latencies[operation] = append([]RequestSample(nil), samples[n:]...)
+		}
+	}
+	return latencies
+}
+
+// OperationOf derives the operation of an ARM request from its method and
+// URL path, like routeTables/list or virtualNetworkPeerings/get: the type of
+// the last resource in the path and what is done to it. Subscription IDs,
+// resource group and resource names are dropped, so operations are bounded
+// by the APIs velora calls and never identify a resource.
+func OperationOf(method, path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	var types []string
+	named := false
+	for i := 0; i < len(segments); {
+		segment := segments[i]
+		switch strings.ToLower(segment) {
+		case "":
+			i++
+			continue
+		case "providers":
+			// the namespace follows, then the resource types
+			i += 2
+			named = false
+			continue
+		case "subscriptions":
+			segment = "subscriptions"
+		case "resourcegroups":
+			segment = "resourceGroups"
+		}
+		types = append(types, segment)
+		named = i+1 < len(segments)
+		i += 2
+	}
+	if len(types) == 0 {
+		return "unknown/" + strings.ToLower(method)
+	}
+
+	last := types[len(types)-1]
+	switch method {
+	case http.MethodGet:
+		if named {
+			return last + "/get"
+		}
+		return last + "/list"
+	case http.MethodHead:
+		return last + "/head"
+	case http.MethodPut:
+		return last + "/createOrUpdate"
+	case http.MethodPatch:
+		return last + "/update"
+	case http.MethodDelete:
+		return last + "/delete"
+	case http.MethodPost:
+		// the last segment of a POST is the action on its parent
+		if !named && len(types) > 1 {
+			return types[len(types)-2] + "/" + last
+		}
+		return last + "/post"
+	default:
+		return last + "/" + strings.ToLower(method)
+	}
+}
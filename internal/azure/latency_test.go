@@ -0,0 +1,125 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+)
+
+// The identifiers of the ARM URLs of TestOperationOf, none may appear in an
+// operation.
+const (
+	latencySubscription = "7c1e4a2b-93d5-4f0e-b6a8-2d9c0e5f1a37"
+	latencyGroup        = "rg-spoke-prod-weu"
+	latencyNetwork      = "/subscriptions/" + latencySubscription + "/resourceGroups/" + latencyGroup + "/providers/Microsoft.Network"
+)
+
+// TestOperationOf derives the operations of a corpus of ARM URLs velora
+// calls, taken from request logs with their identifiers replaced.
+func TestOperationOf(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{method: http.MethodGet, path: "/subscriptions/" + latencySubscription + "/providers/Microsoft.Network/routeTables", want: "routeTables/list"},
+		{method: http.MethodGet, path: latencyNetwork + "/routeTables/rt-app-weu", want: "routeTables/get"},
+		{method: http.MethodGet, path: latencyNetwork + "/routeTables/rt-app-weu/routes", want: "routes/list"},
+		{method: http.MethodPut, path: latencyNetwork + "/routeTables/rt-app-weu/routes/DefaultRoute-To-NVA", want: "routes/createOrUpdate"},
+		{method: http.MethodDelete, path: latencyNetwork + "/routeTables/rt-app-weu/routes/legacy-default", want: "routes/delete"},
+		{method: http.MethodGet, path: "/subscriptions/" + latencySubscription + "/providers/Microsoft.Network/virtualNetworks", want: "virtualNetworks/list"},
+		{method: http.MethodPatch, path: latencyNetwork + "/virtualNetworks/vnet-spoke-weu", want: "virtualNetworks/update"},
+		{method: http.MethodGet, path: latencyNetwork + "/virtualNetworks/vnet-spoke-weu/virtualNetworkPeerings/vnet-spoke-weu-to-hub-vnet", want: "virtualNetworkPeerings/get"},
+		{method: http.MethodPut, path: latencyNetwork + "/virtualNetworks/vnet-spoke-weu/virtualNetworkPeerings/vnet-spoke-weu-to-hub-vnet", want: "virtualNetworkPeerings/createOrUpdate"},
+		{method: http.MethodGet, path: latencyNetwork + "/virtualNetworks/vnet-spoke-weu/subnets", want: "subnets/list"},
+		{method: http.MethodPut, path: latencyNetwork + "/virtualNetworks/vnet-spoke-weu/subnets/snet-app", want: "subnets/createOrUpdate"},
+		{method: http.MethodPost, path: latencyNetwork + "/networkInterfaces/nic-nva-01/effectiveRouteTable", want: "networkInterfaces/effectiveRouteTable"},
+		{method: http.MethodGet, path: latencyNetwork + "/networkWatchers/NetworkWatcher_westeurope/flowLogs/fl-nsg-app", want: "flowLogs/get"},
+		{method: http.MethodGet, path: latencyNetwork + "/networkSecurityGroups/nsg-app/providers/Microsoft.Insights/diagnosticSettings/velora", want: "diagnosticSettings/get"},
+		// polls of long-running operations
+		{method: http.MethodGet, path: "/subscriptions/" + latencySubscription + "/providers/Microsoft.Network/locations/westeurope/operations/3f2b8c1d-6e4a-4b7f-9d0c-5a1e2f3b4c6d", want: "operations/get"},
+		{method: http.MethodGet, path: "/subscriptions/" + latencySubscription + "/providers/Microsoft.Network/locations/westeurope/operationResults/3f2b8c1d-6e4a-4b7f-9d0c-5a1e2f3b4c6d", want: "operationResults/get"},
+		{method: http.MethodGet, path: "/subscriptions", want: "subscriptions/list"},
+		{method: http.MethodGet, path: "/subscriptions/" + latencySubscription, want: "subscriptions/get"},
+		{method: http.MethodGet, path: "/subscriptions/" + latencySubscription + "/resourcegroups/" + latencyGroup, want: "resourceGroups/get"},
+		{method: http.MethodGet, path: "/subscriptions/" + latencySubscription + "/resourceGroups/" + latencyGroup + "/providers/Microsoft.Authorization/permissions", want: "permissions/list"},
+		{method: http.MethodPost, path: "/providers/Microsoft.ResourceGraph/resources", want: "resources/post"},
+		{method: http.MethodHead, path: latencyNetwork + "/routeTables/rt-app-weu", want: "routeTables/head"},
+		{method: http.MethodGet, path: "/", want: "unknown/get"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			got := OperationOf(tt.method, tt.path)
+			if got != tt.want {
+				t.Errorf("OperationOf() = %q, want %q", got, tt.want)
+			}
+			for _, identifier := range []string{latencySubscription, latencyGroup, "westeurope", "weu", "nva", "3f2b8c1d"} {
+				if strings.Contains(strings.ToLower(got), identifier) {
+					t.Errorf("OperationOf() = %q, leaks %s", got, identifier)
+				}
+			}
+		})
+	}
+}
+
+func TestLatenciesTable(t *testing.T) {
+	var samples []RequestSample
+	for i := 1; i <= 20; i++ {
+		samples = append(samples, RequestSample{Duration: time.Duration(i) * time.Millisecond, Failed: i%5 == 0})
+	}
+	latencies := Latencies{
+		"routes/createOrUpdate": samples,
+		"routeTables/list":      {{Duration: 1500 * time.Microsecond}},
+		"subnets/list":          nil,
+	}
+
+	want := []OperationLatency{
+		{Operation: "routeTables/list", Count: 1, P50Ms: 1.5, P95Ms: 1.5, MaxMs: 1.5},
+		// nearest rank: the 10th and the 19th of 20
+		{Operation: "routes/createOrUpdate", Count: 20, P50Ms: 10, P95Ms: 19, MaxMs: 20, ErrorRate: 0.2},
+	}
+	if got := latencies.Table(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Table() = %+v, want %+v", got, want)
+	}
+}
+
+// TestLatenciesSince records the requests of a factory's clients, and of the
+// factories scoped from it, after a mark.
+func TestLatenciesSince(t *testing.T) {
+	const routeTableID = "/subscriptions/00000000-0000-0000-0000-000000000002/resourceGroups/spoke-rg/providers/Microsoft.Network/routeTables/spoke-rt"
+	arm := azuretest.NewServer()
+	arm.Put(routeTableID, azuretest.RouteTable(routeTableID))
+	arm.Handle(http.MethodGet, "/subscriptions/00000000-0000-0000-0000-000000000002/resourceGroups/spoke-rg/providers/Microsoft.Network/routeTables/missing",
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"code":"ResourceNotFound","message":"not found"}}`))
+		})
+	factory := NewClientFactoryWithTransport(&config.AzureConfig{}, azuretest.Credential{}, arm)
+	ctx := context.Background()
+
+	get := func(f *ClientFactory, name string) {
+		t.Helper()
+		client, err := f.ForSubscription("00000000-0000-0000-0000-000000000002").NewRouteTablesClient(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = client.Get(ctx, "spoke-rg", name, nil)
+	}
+	get(factory, "spoke-rt")
+	mark := factory.MarkLatencies()
+	get(factory, "spoke-rt")
+	get(factory.ForSubscription("00000000-0000-0000-0000-000000000002"), "missing")
+
+	table := factory.LatenciesSince(mark).Table()
+	if len(table) != 1 || table[0].Operation != "routeTables/get" || table[0].Count != 2 || table[0].ErrorRate != 0.5 {
+		t.Errorf("LatenciesSince() = %+v, want the 2 route table reads after the mark, one failed", table)
+	}
+}
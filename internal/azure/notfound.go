@@ -0,0 +1,35 @@
+package azure
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// notFoundErrorCodes are the ARM error codes returned for resources, or
+// their parents, that don't exist.
+var notFoundErrorCodes = map[string]bool{
+	"NotFound":                true,
+	"ResourceNotFound":        true,
+	"ParentResourceNotFound":  true,
+	"ResourceGroupNotFound":   true,
+	"InvalidResourceNotFound": true,
+}
+
+// IsThrottled reports whether the error was returned because ARM throttled
+// the request, after the SDK's retries.
+func IsThrottled(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusTooManyRequests
+}
+
+// IsNotFound reports whether the error was returned because the resource
+// or one of its parents doesn't exist.
+func IsNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) {
+		return false
+	}
+	return respErr.StatusCode == http.StatusNotFound || notFoundErrorCodes[respErr.ErrorCode]
+}
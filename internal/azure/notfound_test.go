@@ -0,0 +1,35 @@
+package azure
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+func TestIsNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "404", err: &azcore.ResponseError{StatusCode: http.StatusNotFound}, want: true},
+		{name: "ResourceNotFound", err: &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "ResourceNotFound"}, want: true},
+		// ARM reports some missing parents with another status
+		{name: "ParentResourceNotFound", err: &azcore.ResponseError{StatusCode: http.StatusBadRequest, ErrorCode: "ParentResourceNotFound"}, want: true},
+		{name: "wrapped", err: fmt.Errorf("failed to read: %w", &azcore.ResponseError{StatusCode: http.StatusNotFound}), want: true},
+		{name: "forbidden", err: &azcore.ResponseError{StatusCode: http.StatusForbidden, ErrorCode: "AuthorizationFailed"}},
+		{name: "not an ARM error", err: errors.New("not found")},
+		{name: "nil", err: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsNotFound(tt.err); got != tt.want {
+				t.Errorf("IsNotFound(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/akos011221/velora/internal/config"
+)
+
+// minListPageSize is the smallest page size the adaptive page size is
+// reduced to.
+const minListPageSize = 10
+
+// networkProvider is the path of the network resources, whose lists accept $top.
+const networkProvider = "/providers/microsoft.network/"
+
+// ListPageStats counts the list pages of an operation.
+type ListPageStats struct {
+	Pages uint64 `json:"pages"`
+	// Reductions counts the pages fetched again with a smaller page size,
+	// the first attempt was too large or timed out.
+	Reductions uint64 `json:"reductions,omitempty"`
+	// PageSize is the $top of the operation's requests, 0 if ARM picks it.
+	PageSize int `json:"pageSize,omitempty"`
+}
+
+// ListPages are the list pages fetched per operation, see OperationOf.
+type ListPages map[string]ListPageStats
+
+// Operations returns the operations of the pages, sorted.
+func (l ListPages) Operations() []string {
+	operations := make([]string, 0, len(l))
+	for operation := range l {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+// pageSizer sizes the pages of the list requests of the clients of a
+// factory and counts them per operation. Network lists ask for pageSize
+// items per page with $top. An operation whose page is larger than maxBytes,
+// or takes longer than timeout, has its page size halved and the page is
+// fetched again, down to minListPageSize; its later pages keep the reduced
+// size.
+type pageSizer struct {
+	pageSize int
+	maxBytes int
+	timeout  time.Duration
+
+	mu    sync.Mutex
+	sizes map[string]int
+	pages ListPages
+}
+
+// newPageSizer creates a page sizer for the list page settings of the
+// configuration.
+func newPageSizer(cfg *config.AzureConfig) *pageSizer {
+	return &pageSizer{
+		pageSize: cfg.ListPageSize,
+		maxBytes: cfg.EffectiveListPageMaxKB() << 10,
+		timeout:  cfg.ListPageTimeout(),
+		sizes:    make(map[string]int),
+		pages:    make(ListPages),
+	}
+}
+
+// size returns the page size of the operation.
+func (s *pageSizer) size(operation string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if size, ok := s.sizes[operation]; ok {
+		return size
+	}
+	return s.pageSize
+}
+
+// reduce halves the page size of the operation, if it was size, and
+// returns the new size.
+func (s *pageSizer) reduce(operation string, size int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.pages[operation]
+	stats.Reductions++
+	s.pages[operation] = stats
+	if current, ok := s.sizes[operation]; ok && current < size {
+		return current
+	}
+	s.sizes[operation] = max(size/2, minListPageSize)
+	return s.sizes[operation]
+}
+
+// count counts a page of the operation fetched with the page size.
+func (s *pageSizer) count(operation string, size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.pages[operation]
+	stats.Pages++
+	stats.PageSize = size
+	s.pages[operation] = stats
+}
+
+// pagePolicy sizes the list requests with the sizer. It is a per-call
+// policy, a page is retried by the retry policy before it is fetched again
+// with a smaller size.
+type pagePolicy struct {
+	sizer *pageSizer
+}
+
+// Do implements policy.Policy.
+func (p pagePolicy) Do(req *policy.Request) (*http.Response, error) {
+	raw := req.Raw()
+	if raw.Method != http.MethodGet {
+		return req.Next()
+	}
+	operation := OperationOf(raw.Method, raw.URL.Path)
+	if !strings.HasSuffix(operation, "/list") {
+		return req.Next()
+	}
+	if p.sizer.pageSize == 0 || !strings.Contains(strings.ToLower(raw.URL.Path), networkProvider) {
+		resp, err := req.Next()
+		if err == nil && resp.StatusCode == http.StatusOK {
+			p.sizer.count(operation, 0)
+		}
+		return resp, err
+	}
+
+	size := p.sizer.size(operation)
+	for {
+		resp, tooLarge, err := p.fetch(req, size)
+		timedOut := err != nil && errors.Is(err, context.DeadlineExceeded) && raw.Context().Err() == nil
+		if !tooLarge && !timedOut {
+			if err == nil && resp.StatusCode == http.StatusOK {
+				p.sizer.count(operation, size)
+			}
+			return resp, err
+		}
+		if size <= minListPageSize {
+			return nil, fmt.Errorf("list page of %s timed out at the smallest page size %d: %w", operation, size, err)
+		}
+		reason := fmt.Sprintf("took longer than %s", p.sizer.timeout)
+		if tooLarge {
+			reason = fmt.Sprintf("is larger than %d KB", p.sizer.maxBytes>>10)
+		}
+		size = p.sizer.reduce(operation, size)
+		fmt.Printf("WARNING: list page of %s %s, fetching it again with %d items per page\n", operation, reason, size)
+	}
+}
+
+// fetch sends the request for a page of size items, within the page
+// timeout. tooLarge reports whether the page is larger than the maximum,
+// its body is read only up to it then. The page of the smallest size is
+// never too large, it is returned however large it is.
+func (p pagePolicy) fetch(req *policy.Request, size int) (resp *http.Response, tooLarge bool, err error) {
+	ctx, cancel := context.WithTimeout(req.Raw().Context(), p.sizer.timeout)
+	defer cancel()
+	try := req.Clone(ctx)
+	query := try.Raw().URL.Query()
+	query.Set("$top", strconv.Itoa(size))
+	try.Raw().URL.RawQuery = query.Encode()
+
+	resp, err = try.Next()
+	if err != nil {
+		return nil, false, err
+	}
+	// the body is read before the timeout releases the request
+	var body io.Reader = resp.Body
+	if size > minListPageSize {
+		body = io.LimitReader(resp.Body, int64(p.sizer.maxBytes)+1)
+	}
+	data, err := io.ReadAll(body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, false, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return resp, size > minListPageSize && resp.StatusCode == http.StatusOK && len(data) > p.sizer.maxBytes, nil
+}
+
+// MarkListPages marks the pages counted so far, ListPagesSince returns
+// those after it.
+func (f *ClientFactory) MarkListPages() ListPages {
+	f.pages.mu.Lock()
+	defer f.pages.mu.Unlock()
+	mark := make(ListPages, len(f.pages.pages))
+	for operation, stats := range f.pages.pages {
+		mark[operation] = stats
+	}
+	return mark
+}
+
+// ListPagesSince returns the pages counted by the clients of the factory
+// and of the factories scoped from it after the mark.
+func (f *ClientFactory) ListPagesSince(mark ListPages) ListPages {
+	f.pages.mu.Lock()
+	defer f.pages.mu.Unlock()
+	pages := make(ListPages)
+	for operation, stats := range f.pages.pages {
+		before := mark[operation]
+		if stats.Pages == before.Pages && stats.Reductions == before.Reductions {
+			continue
+		}
+		pages[operation] = ListPageStats{
+			Pages:      stats.Pages - before.Pages,
+			Reductions: stats.Reductions - before.Reductions,
+			PageSize:   stats.PageSize,
+		}
+	}
+	return pages
+}
@@ -0,0 +1,243 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+)
+
+const (
+	pagesSubscription = "00000000-0000-0000-0000-000000000002"
+	routeTablesPath   = "/subscriptions/" + pagesSubscription + "/providers/Microsoft.Network/routeTables"
+)
+
+// handleRouteTables serves the route tables of the subscription, a page
+// asking for more than maxTop items is padded beyond 1 KB.
+func handleRouteTables(arm *azuretest.Server, maxTop int) {
+	arm.Handle(http.MethodGet, routeTablesPath, func(w http.ResponseWriter, r *http.Request) {
+		table := map[string]any{"id": routeTablesPath + "/spoke-rt", "name": "spoke-rt"}
+		if top, _ := strconv.Atoi(r.URL.Query().Get("$top")); top > maxTop {
+			table["tags"] = map[string]string{"padding": strings.Repeat("x", 2<<10)}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"value": []any{table}})
+	})
+}
+
+// listRouteTables lists the route tables of the subscription.
+func listRouteTables(t *testing.T, factory *ClientFactory) {
+	t.Helper()
+	ctx := context.Background()
+	client, err := factory.ForSubscription(pagesSubscription).NewRouteTablesClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pager := client.NewListAllPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			t.Fatalf("NextPage() error = %v", err)
+		}
+		if len(page.Value) != 1 {
+			t.Errorf("page = %d route tables, want 1", len(page.Value))
+		}
+	}
+}
+
+// tops returns the $top of the requests to the path, "" if unset.
+func tops(arm *azuretest.Server, path string) []string {
+	var tops []string
+	for _, req := range arm.Requests() {
+		if req.Path == path {
+			tops = append(tops, req.Query.Get("$top"))
+		}
+	}
+	return tops
+}
+
+func TestListPageSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		pageSize int
+		wantTop  string
+	}{
+		{name: "configured", pageSize: 50, wantTop: "50"},
+		{name: "left to ARM", wantTop: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arm := azuretest.NewServer()
+			handleRouteTables(arm, 1000)
+			permissionsPath := "/subscriptions/" + pagesSubscription + "/providers/Microsoft.Authorization/permissions"
+			arm.Handle(http.MethodGet, permissionsPath, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"value":[{"actions":["*"]}]}`))
+			})
+			factory := NewClientFactoryWithTransport(&config.AzureConfig{ListPageSize: tt.pageSize}, azuretest.Credential{}, arm)
+			mark := factory.MarkListPages()
+
+			listRouteTables(t, factory)
+			if _, err := factory.ForSubscription(pagesSubscription).ListPermissions(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := tops(arm, routeTablesPath); !slices.Equal(got, []string{tt.wantTop}) {
+				t.Errorf("$top of the route table list = %q, want %q", got, tt.wantTop)
+			}
+			// only network lists accept $top
+			if got := tops(arm, permissionsPath); !slices.Equal(got, []string{""}) {
+				t.Errorf("$top of the permissions list = %q, want none", got)
+			}
+			want := ListPages{
+				"routeTables/list": {Pages: 1, PageSize: tt.pageSize},
+				"permissions/list": {Pages: 1},
+			}
+			if got := factory.ListPagesSince(mark); !equalListPages(got, want) {
+				t.Errorf("ListPagesSince() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestListPageSizeReduction(t *testing.T) {
+	tests := []struct {
+		name     string
+		pageSize int
+		maxTop   int
+		// wantTops are the $top of the first list, then of the second
+		wantTops []string
+		want     ListPageStats
+	}{
+		{
+			name:     "fits",
+			pageSize: 100,
+			maxTop:   100,
+			wantTops: []string{"100", "100"},
+			want:     ListPageStats{Pages: 2, PageSize: 100},
+		},
+		{
+			// the reduced size is kept for the second list
+			name:     "too large",
+			pageSize: 100,
+			maxTop:   25,
+			wantTops: []string{"100", "50", "25", "25"},
+			want:     ListPageStats{Pages: 2, Reductions: 2, PageSize: 25},
+		},
+		{
+			// the smallest page is returned however large it is
+			name:     "too large at the smallest size",
+			pageSize: 40,
+			maxTop:   0,
+			wantTops: []string{"40", "20", "10", "10"},
+			want:     ListPageStats{Pages: 2, Reductions: 2, PageSize: 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arm := azuretest.NewServer()
+			handleRouteTables(arm, tt.maxTop)
+			factory := NewClientFactoryWithTransport(&config.AzureConfig{ListPageSize: tt.pageSize, ListPageMaxKB: 1}, azuretest.Credential{}, arm)
+
+			listRouteTables(t, factory)
+			listRouteTables(t, factory)
+
+			if got := tops(arm, routeTablesPath); !slices.Equal(got, tt.wantTops) {
+				t.Errorf("$top of the requests = %q, want %q", got, tt.wantTops)
+			}
+			if got := factory.ListPagesSince(nil)["routeTables/list"]; got != tt.want {
+				t.Errorf("list pages = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// slowTransport times out the list pages asking for more than maxTop items,
+// as the HTTP transport does once the context of a request is done.
+type slowTransport struct {
+	arm    *azuretest.Server
+	maxTop int
+}
+
+// Do implements policy.Transporter.
+func (s slowTransport) Do(req *http.Request) (*http.Response, error) {
+	if top, _ := strconv.Atoi(req.URL.Query().Get("$top")); top > s.maxTop {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+	return s.arm.Do(req)
+}
+
+var _ policy.Transporter = slowTransport{}
+
+func TestListPageSizeTimeout(t *testing.T) {
+	arm := azuretest.NewServer()
+	handleRouteTables(arm, 1000)
+	factory := NewClientFactoryWithTransport(&config.AzureConfig{ListPageSize: 100}, azuretest.Credential{}, slowTransport{arm: arm, maxTop: 50})
+	factory.pages.timeout = 20 * time.Millisecond
+
+	listRouteTables(t, factory)
+
+	// the timed-out request never reached the server
+	if got := tops(arm, routeTablesPath); !slices.Equal(got, []string{"50"}) {
+		t.Errorf("$top of the served requests = %q, want 50", got)
+	}
+	want := ListPageStats{Pages: 1, Reductions: 1, PageSize: 50}
+	if got := factory.ListPagesSince(nil)["routeTables/list"]; got != want {
+		t.Errorf("list pages = %+v, want %+v", got, want)
+	}
+
+	// at the smallest size the timeout is an error
+	factory = NewClientFactoryWithTransport(&config.AzureConfig{ListPageSize: minListPageSize}, azuretest.Credential{}, slowTransport{arm: arm})
+	factory.pages.timeout = 20 * time.Millisecond
+	client, err := factory.ForSubscription(pagesSubscription).NewRouteTablesClient(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.NewListAllPager(nil).NextPage(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "smallest page size") {
+		t.Errorf("NextPage() error = %v, want the timeout at the smallest page size", err)
+	}
+}
+
+func TestListPagesSince(t *testing.T) {
+	arm := azuretest.NewServer()
+	handleRouteTables(arm, 25)
+	factory := NewClientFactoryWithTransport(&config.AzureConfig{ListPageSize: 50, ListPageMaxKB: 1}, azuretest.Credential{}, arm)
+	listRouteTables(t, factory)
+	mark := factory.MarkListPages()
+
+	if got := factory.ListPagesSince(mark); len(got) != 0 {
+		t.Errorf("ListPagesSince() without new pages = %+v, want none", got)
+	}
+	listRouteTables(t, factory.ForSubscription(pagesSubscription))
+	want := ListPageStats{Pages: 1, PageSize: 25}
+	if got := factory.ListPagesSince(mark)["routeTables/list"]; got != want {
+		t.Errorf("ListPagesSince() = %+v, want %+v", got, want)
+	}
+}
+
+// equalListPages reports whether the list pages are the same.
+func equalListPages(a, b ListPages) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for operation, stats := range a {
+		if b[operation] != stats {
+			return false
+		}
+	}
+	return true
+}
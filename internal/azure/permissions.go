@@ -0,0 +1,124 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// permissionsAPIVersion is the Microsoft.Authorization permissions API version.
+const permissionsAPIVersion = "2022-04-01"
+
+// Permission is a set of actions granted to the caller at a scope.
+type Permission struct {
+	Actions    []string `json:"actions"`
+	NotActions []string `json:"notActions"`
+}
+
+// IsAccessDenied reports whether the error was returned because the caller
+// isn't allowed to access the resource.
+func IsAccessDenied(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && (respErr.StatusCode == http.StatusForbidden || respErr.StatusCode == http.StatusUnauthorized)
+}
+
+// ListPermissions returns the permissions of the caller at the scope of the
+// factory's subscription.
+func (f *ClientFactory) ListPermissions(ctx context.Context) ([]Permission, error) {
+	client, err := f.newARMClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure resource manager client: %w", err)
+	}
+
+	endpoint := runtime.JoinPaths(client.Endpoint(), "subscriptions", url.PathEscape(f.subscriptionID),
+		"providers/Microsoft.Authorization/permissions")
+
+	var permissions []Permission
+	for endpoint != "" {
+		req, err := runtime.NewRequest(ctx, http.MethodGet, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.Contains(endpoint, "api-version=") {
+			query := req.Raw().URL.Query()
+			query.Set("api-version", permissionsAPIVersion)
+			req.Raw().URL.RawQuery = query.Encode()
+		}
+
+		resp, err := client.Pipeline().Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list permissions: %w", err)
+		}
+		if !runtime.HasStatusCode(resp, http.StatusOK) {
+			return nil, fmt.Errorf("failed to list permissions: %w", runtime.NewResponseError(resp))
+		}
+
+		var page struct {
+			Value    []Permission `json:"value"`
+			NextLink string       `json:"nextLink"`
+		}
+		if err := runtime.UnmarshalAsJSON(resp, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse permissions: %w", err)
+		}
+		permissions = append(permissions, page.Value...)
+		endpoint = page.NextLink
+	}
+
+	return permissions, nil
+}
+
+// Allows reports whether the permissions grant the action, honoring
+// wildcards and notActions.
+func Allows(permissions []Permission, action string) bool {
+	for _, p := range permissions {
+		granted := false
+		for _, a := range p.Actions {
+			if actionMatches(a, action) {
+				granted = true
+				break
+			}
+		}
+		for _, a := range p.NotActions {
+			if actionMatches(a, action) {
+				granted = false
+				break
+			}
+		}
+		if granted {
+			return true
+		}
+	}
+	return false
+}
+
+// actionMatches matches an action against a pattern where * matches any
+// sequence of characters, case-insensitive.
+func actionMatches(pattern, action string) bool {
+	pattern, action = strings.ToLower(pattern), strings.ToLower(action)
+
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == action
+	}
+	if !strings.HasPrefix(action, parts[0]) {
+		return false
+	}
+	action = action[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 {
+			return strings.HasSuffix(action, part)
+		}
+		idx := strings.Index(action, part)
+		if idx < 0 {
+			return false
+		}
+		action = action[idx+len(part):]
+	}
+	return true
+}
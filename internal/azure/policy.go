@@ -0,0 +1,116 @@
+package azure
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// policyDeniedErrorCode is the ARM error code of writes an Azure Policy
+// assignment with the deny effect refused.
+const policyDeniedErrorCode = "RequestDisallowedByPolicy"
+
+// PolicyDenial is the Azure Policy assignment that refused a write.
+type PolicyDenial struct {
+	AssignmentID   string `json:"assignmentId"`
+	AssignmentName string `json:"assignmentName"`
+	DefinitionID   string `json:"definitionId,omitempty"`
+}
+
+// Name returns the display name of the assignment, or the name in its ID.
+func (d *PolicyDenial) Name() string {
+	if d.AssignmentName != "" {
+		return d.AssignmentName
+	}
+	if d.AssignmentID != "" {
+		return d.AssignmentID[strings.LastIndex(d.AssignmentID, "/")+1:]
+	}
+	return "unknown assignment"
+}
+
+// PortalURL returns the link to the assignment in the Azure portal.
+func (d *PolicyDenial) PortalURL() string {
+	return "https://portal.azure.com/#resource" + d.AssignmentID
+}
+
+// policyErrorBody is the part of a RequestDisallowedByPolicy response
+// identifying the assignment.
+type policyErrorBody struct {
+	Error struct {
+		Message        string `json:"message"`
+		AdditionalInfo []struct {
+			Type string `json:"type"`
+			Info struct {
+				PolicyAssignmentID          string `json:"policyAssignmentId"`
+				PolicyAssignmentName        string `json:"policyAssignmentName"`
+				PolicyAssignmentDisplayName string `json:"policyAssignmentDisplayName"`
+				PolicyDefinitionID          string `json:"policyDefinitionId"`
+			} `json:"info"`
+		} `json:"additionalInfo"`
+	} `json:"error"`
+}
+
+// policyIdentifier is an entry of the "Policy identifiers" list of the
+// error message, present even when additionalInfo isn't.
+type policyIdentifier struct {
+	PolicyAssignment struct {
+		Name string `json:"name"`
+		ID   string `json:"id"`
+	} `json:"policyAssignment"`
+	PolicyDefinition struct {
+		ID string `json:"id"`
+	} `json:"policyDefinition"`
+}
+
+// AsPolicyDenial returns the Azure Policy assignment that refused the write
+// if the error is a RequestDisallowedByPolicy. The assignment is read from
+// the additionalInfo of the error, or else from the policy identifiers of
+// its message; if neither names it, the denial has an empty assignment.
+func AsPolicyDenial(err error) (*PolicyDenial, bool) {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) || respErr.ErrorCode != policyDeniedErrorCode {
+		return nil, false
+	}
+	denial := &PolicyDenial{}
+	if respErr.RawResponse == nil {
+		return denial, true
+	}
+	payload, err := runtime.Payload(respErr.RawResponse)
+	if err != nil {
+		return denial, true
+	}
+	var body policyErrorBody
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return denial, true
+	}
+
+	for _, info := range body.Error.AdditionalInfo {
+		if info.Type != "PolicyViolation" || info.Info.PolicyAssignmentID == "" {
+			continue
+		}
+		denial.AssignmentID = info.Info.PolicyAssignmentID
+		denial.AssignmentName = info.Info.PolicyAssignmentDisplayName
+		if denial.AssignmentName == "" {
+			denial.AssignmentName = info.Info.PolicyAssignmentName
+		}
+		denial.DefinitionID = info.Info.PolicyDefinitionID
+		return denial, true
+	}
+
+	// Policy identifiers: '[{"policyAssignment":{...},"policyDefinition":{...}}]'.
+	_, identifiers, found := strings.Cut(body.Error.Message, "Policy identifiers: '")
+	if !found {
+		return denial, true
+	}
+	identifiers, _, _ = strings.Cut(identifiers, "'")
+	var ids []policyIdentifier
+	if err := json.Unmarshal([]byte(identifiers), &ids); err == nil && len(ids) > 0 {
+		denial.AssignmentID = ids[0].PolicyAssignment.ID
+		denial.AssignmentName = ids[0].PolicyAssignment.Name
+		denial.DefinitionID = ids[0].PolicyDefinition.ID
+	}
+	return denial, true
+}
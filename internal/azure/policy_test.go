@@ -0,0 +1,108 @@
+package azure
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// deniedRouteAssignment is the assignment of the captured denial in testdata.
+const deniedRouteAssignment = "/providers/Microsoft.Management/managementGroups/platform/providers/Microsoft.Authorization/policyAssignments/deny-routes"
+
+// responseError returns the error of an ARM response with the body.
+func responseError(t *testing.T, status int, body string) error {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPut, "https://management.azure.com/subscriptions/s/resourceGroups/rg/providers/Microsoft.Network/routeTables/spoke-rt/routes/r", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runtime.NewResponseError(&http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	})
+}
+
+func TestAsPolicyDenial(t *testing.T) {
+	captured, err := os.ReadFile(filepath.Join("testdata", "policy-denied.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// older API versions only name the assignment in the message
+	messageOnly := `{"error":{"code":"RequestDisallowedByPolicy","message":"Resource 'spoke-rt' was disallowed by policy. Policy identifiers: '[{\"policyAssignment\":{\"name\":\"deny-routes\",\"id\":\"` + deniedRouteAssignment + `\"},\"policyDefinition\":{\"name\":\"deny-route-changes\",\"id\":\"/providers/Microsoft.Authorization/policyDefinitions/deny-route-changes\"}}]'."}}`
+
+	tests := []struct {
+		name       string
+		err        error
+		wantDenied bool
+		want       PolicyDenial
+	}{
+		{
+			name:       "captured denial",
+			err:        responseError(t, http.StatusForbidden, string(captured)),
+			wantDenied: true,
+			want: PolicyDenial{
+				AssignmentID:   deniedRouteAssignment,
+				AssignmentName: "Deny route changes outside the pipeline",
+				DefinitionID:   "/providers/Microsoft.Management/managementGroups/platform/providers/Microsoft.Authorization/policyDefinitions/deny-route-changes",
+			},
+		},
+		{
+			name:       "policy identifiers of the message",
+			err:        fmt.Errorf("failed to write the route: %w", responseError(t, http.StatusForbidden, messageOnly)),
+			wantDenied: true,
+			want: PolicyDenial{
+				AssignmentID:   deniedRouteAssignment,
+				AssignmentName: "deny-routes",
+				DefinitionID:   "/providers/Microsoft.Authorization/policyDefinitions/deny-route-changes",
+			},
+		},
+		{
+			name:       "assignment not named",
+			err:        responseError(t, http.StatusForbidden, `{"error":{"code":"RequestDisallowedByPolicy","message":"disallowed by policy"}}`),
+			wantDenied: true,
+		},
+		{
+			name: "other authorization error",
+			err:  responseError(t, http.StatusForbidden, `{"error":{"code":"AuthorizationFailed","message":"no access"}}`),
+		},
+		{name: "not an ARM error", err: errors.New("RequestDisallowedByPolicy")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			denial, denied := AsPolicyDenial(tt.err)
+			if denied != tt.wantDenied {
+				t.Fatalf("AsPolicyDenial() denied = %t, want %t", denied, tt.wantDenied)
+			}
+			if denied && *denial != tt.want {
+				t.Errorf("AsPolicyDenial() = %+v, want %+v", *denial, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyDenialName(t *testing.T) {
+	tests := []struct {
+		denial PolicyDenial
+		want   string
+	}{
+		{denial: PolicyDenial{AssignmentID: deniedRouteAssignment, AssignmentName: "Deny routes"}, want: "Deny routes"},
+		{denial: PolicyDenial{AssignmentID: deniedRouteAssignment}, want: "deny-routes"},
+		{want: "unknown assignment"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.denial.Name(); got != tt.want {
+			t.Errorf("Name() of %+v = %q, want %q", tt.denial, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package azure
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// ReadOnlyError is returned for a write request made in read-only mode.
+// Controllers check the guard before writing, so it is a programming error.
+type ReadOnlyError struct {
+	Method string
+	URL    string
+}
+
+func (e *ReadOnlyError) Error() string {
+	return fmt.Sprintf("refused %s %s: velora runs in read-only mode, this write bypassed the write guard", e.Method, e.URL)
+}
+
+// readOnlyPolicy rejects every request that isn't a read before it's sent.
+// POST is refused too: ARM actions like listKeys are POSTs that read
+// secrets, others change state, and velora reads nothing through them.
+type readOnlyPolicy struct{}
+
+// Do implements policy.Policy.
+func (readOnlyPolicy) Do(req *policy.Request) (*http.Response, error) {
+	raw := req.Raw()
+	if raw.Method != http.MethodGet && raw.Method != http.MethodHead {
+		return nil, &ReadOnlyError{Method: raw.Method, URL: raw.URL.Path}
+	}
+	return req.Next()
+}
+
+// EnableReadOnly makes all clients created by the factory refuse writes.
+// It is the single chokepoint behind the write guard, no write reaches
+// ARM once it's enabled.
+func (f *ClientFactory) EnableReadOnly() {
+	f.clientOptions.PerCallPolicies = append(f.clientOptions.PerCallPolicies, readOnlyPolicy{})
+	f.readOnly = true
+}
+
+// ReadOnly reports whether the factory refuses writes.
+func (f *ClientFactory) ReadOnly() bool {
+	return f.readOnly
+}
@@ -0,0 +1,70 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+)
+
+func TestReadOnly(t *testing.T) {
+	const routeTableID = "/subscriptions/00000000-0000-0000-0000-000000000002/resourceGroups/spoke-rg/providers/Microsoft.Network/routeTables/spoke-rt"
+	tests := []struct {
+		method  string
+		path    string
+		allowed bool
+	}{
+		{method: http.MethodGet, path: routeTableID, allowed: true},
+		{method: http.MethodHead, path: routeTableID, allowed: true},
+		{method: http.MethodPut, path: routeTableID},
+		{method: http.MethodPatch, path: routeTableID},
+		{method: http.MethodDelete, path: routeTableID},
+		// list-keys style actions read secrets, velora makes none
+		{method: http.MethodPost, path: "/subscriptions/00000000-0000-0000-0000-000000000002/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/logs/listKeys"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			arm := azuretest.NewServer()
+			arm.Put(routeTableID, azuretest.RouteTable(routeTableID))
+			factory := NewClientFactoryWithTransport(&config.AzureConfig{}, azuretest.Credential{}, arm)
+			factory.EnableReadOnly()
+			client, err := factory.newARMClient()
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := runtime.NewRequest(context.Background(), tt.method, runtime.JoinPaths(client.Endpoint(), tt.path)+"?api-version="+NetworkAPIVersion)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.method == http.MethodPut || tt.method == http.MethodPatch {
+				if err := runtime.MarshalAsJSON(req, map[string]any{"location": "westeurope"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err = client.Pipeline().Do(req)
+			var readOnlyErr *ReadOnlyError
+			if tt.allowed {
+				if err != nil {
+					t.Errorf("%s error = %v", tt.method, err)
+				}
+				if len(arm.Requests()) != 1 {
+					t.Errorf("requests reaching ARM = %v, want the read", arm.Requests())
+				}
+				return
+			}
+			if !errors.As(err, &readOnlyErr) || readOnlyErr.Method != tt.method {
+				t.Errorf("%s error = %v, want a ReadOnlyError", tt.method, err)
+			}
+			if requests := arm.Requests(); len(requests) != 0 {
+				t.Errorf("requests reaching ARM = %v, want none", requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package azure
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// readCounter counts the reads sent to ARM by the clients of a factory.
+// Retries of a read count once.
+type readCounter struct {
+	reads *atomic.Uint64
+}
+
+// Do implements policy.Policy.
+func (c readCounter) Do(req *policy.Request) (*http.Response, error) {
+	method := req.Raw().Method
+	if method == http.MethodGet || method == http.MethodHead {
+		c.reads.Add(1)
+	}
+	return req.Next()
+}
+
+// Reads returns the number of reads sent to ARM by the clients of the
+// factory and of the factories scoped from it.
+func (f *ClientFactory) Reads() uint64 {
+	return f.reads.Load()
+}
@@ -0,0 +1,54 @@
+package azure
+
+import "strings"
+
+// ExtractResourceIDParts is a helper to get resource parts from Azure resource ID.
+func ExtractResourceIDParts(resourceID string) map[string]string {
+	result := make(map[string]string)
+	parts := strings.Split(resourceID, "/")
+
+	for i := 1; i < len(parts)-1; i += 2 {
+		result[parts[i]] = parts[i+1]
+	}
+
+	return result
+}
+
+// SubscriptionIDOf returns the subscription ID of a resource ID, empty if
+// the ID has no subscription segment.
+func SubscriptionIDOf(resourceID string) string {
+	parts := strings.Split(resourceID, "/")
+	for i := 1; i < len(parts)-1; i += 2 {
+		if strings.EqualFold(parts[i], "subscriptions") {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// ValidResourceID reports whether the resource ID has a subscription, a
+// resource group and a name for each of the resource types, e.g.
+// "virtualNetworks", as ExtractResourceIDParts returns them.
+func ValidResourceID(resourceID string, resourceTypes ...string) bool {
+	if !strings.HasPrefix(resourceID, "/") {
+		return false
+	}
+	parts := ExtractResourceIDParts(resourceID)
+	for _, segment := range append([]string{"subscriptions", "resourceGroups"}, resourceTypes...) {
+		if parts[segment] == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// TopLevelResourceID returns the ID of the top-level resource of a child
+// resource ID, e.g. the VNet of a subnet. Other IDs are returned unchanged.
+func TopLevelResourceID(resourceID string) string {
+	parts := strings.Split(resourceID, "/")
+	// "", subscriptions, id, resourceGroups, name, providers, namespace, type, name
+	if len(parts) > 9 && strings.EqualFold(parts[5], "providers") {
+		return strings.Join(parts[:9], "/")
+	}
+	return resourceID
+}
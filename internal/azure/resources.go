@@ -0,0 +1,134 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+)
+
+// NetworkAPIVersion is the Microsoft.Network API version of the armnetwork
+// module in use, used for raw requests.
+const NetworkAPIVersion = "2022-01-01"
+
+// GetResourceEtag returns the etag of a resource, and false if the resource
+// doesn't exist.
+func (f *ClientFactory) GetResourceEtag(ctx context.Context, resourceID, apiVersion string) (string, bool, error) {
+	client, err := f.newARMClient()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create azure resource manager client: %w", err)
+	}
+
+	req, err := runtime.NewRequest(ctx, http.MethodGet, runtime.JoinPaths(client.Endpoint(), resourceID))
+	if err != nil {
+		return "", false, err
+	}
+	req.Raw().URL.RawQuery = url.Values{"api-version": {apiVersion}}.Encode()
+
+	resp, err := client.Pipeline().Do(req)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get %s: %w", resourceID, err)
+	}
+	if runtime.HasStatusCode(resp, http.StatusNotFound) {
+		return "", false, nil
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return "", false, fmt.Errorf("failed to get %s: %w", resourceID, runtime.NewResponseError(resp))
+	}
+
+	var resource struct {
+		Etag string `json:"etag"`
+	}
+	if err := runtime.UnmarshalAsJSON(resp, &resource); err != nil {
+		return "", false, fmt.Errorf("failed to parse %s: %w", resourceID, err)
+	}
+	return resource.Etag, true, nil
+}
+
+// pollFrequency is how often a write in progress is polled, unless ARM
+// asks for another interval. Tests lower it.
+var pollFrequency = 2 * time.Second
+
+// DeleteResource deletes a resource if its etag still matches, and waits
+// for the delete to complete.
+func (f *ClientFactory) DeleteResource(ctx context.Context, resourceID, apiVersion, etag string) error {
+	client, err := f.newARMClient()
+	if err != nil {
+		return fmt.Errorf("failed to create azure resource manager client: %w", err)
+	}
+
+	req, err := runtime.NewRequest(ctx, http.MethodDelete, runtime.JoinPaths(client.Endpoint(), resourceID))
+	if err != nil {
+		return err
+	}
+	req.Raw().URL.RawQuery = url.Values{"api-version": {apiVersion}}.Encode()
+	if etag != "" {
+		req.Raw().Header.Set("If-Match", etag)
+	}
+
+	resp, err := client.Pipeline().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", resourceID, err)
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK, http.StatusAccepted, http.StatusNoContent) {
+		return fmt.Errorf("failed to delete %s: %w", resourceID, runtime.NewResponseError(resp))
+	}
+	return await(ctx, client.Pipeline(), resp, resourceID)
+}
+
+// PutResource creates or updates a resource and waits for the write to
+// complete. A non-empty etag must match the current one, an empty etag
+// requires the resource not to exist.
+func (f *ClientFactory) PutResource(ctx context.Context, resourceID, apiVersion, query string, body []byte, etag string) error {
+	client, err := f.newARMClient()
+	if err != nil {
+		return fmt.Errorf("failed to create azure resource manager client: %w", err)
+	}
+
+	req, err := runtime.NewRequest(ctx, http.MethodPut, runtime.JoinPaths(client.Endpoint(), resourceID))
+	if err != nil {
+		return err
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return fmt.Errorf("invalid query %q: %w", query, err)
+	}
+	values.Set("api-version", apiVersion)
+	req.Raw().URL.RawQuery = values.Encode()
+
+	if etag != "" {
+		req.Raw().Header.Set("If-Match", etag)
+	} else {
+		req.Raw().Header.Set("If-None-Match", "*")
+	}
+	if err := req.SetBody(streaming.NopCloser(bytes.NewReader(body)), "application/json"); err != nil {
+		return err
+	}
+
+	resp, err := client.Pipeline().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to put %s: %w", resourceID, err)
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK, http.StatusCreated) {
+		return fmt.Errorf("failed to put %s: %w", resourceID, runtime.NewResponseError(resp))
+	}
+	return await(ctx, client.Pipeline(), resp, resourceID)
+}
+
+// await waits for the long-running write of the response to complete, the
+// resource may still be updating when ARM accepts it.
+func await(ctx context.Context, pipeline runtime.Pipeline, resp *http.Response, resourceID string) error {
+	poller, err := runtime.NewPoller[struct{}](resp, pipeline, nil)
+	if err != nil {
+		return fmt.Errorf("failed to poll the write of %s: %w", resourceID, err)
+	}
+	if _, err := poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: pollFrequency}); err != nil {
+		return fmt.Errorf("failed to write %s: %w", resourceID, err)
+	}
+	return nil
+}
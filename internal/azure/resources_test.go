@@ -0,0 +1,100 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+)
+
+const testRouteID = "/subscriptions/00000000-0000-0000-0000-000000000002/resourceGroups/spoke-rg/providers/Microsoft.Network/routeTables/spoke-rt/routes/default"
+
+// newResourcesFactory returns a client factory of the fake ARM.
+func newResourcesFactory(arm *azuretest.Server) *ClientFactory {
+	return NewClientFactoryWithTransport(&config.AzureConfig{SubscriptionID: "00000000-0000-0000-0000-000000000002"}, azuretest.Credential{}, arm)
+}
+
+func TestPutResourceEtag(t *testing.T) {
+	arm := azuretest.NewServer()
+	factory := newResourcesFactory(arm)
+	ctx := context.Background()
+	body := []byte(`{"properties":{"addressPrefix":"0.0.0.0/0","nextHopType":"Internet"}}`)
+
+	if err := factory.PutResource(ctx, testRouteID, NetworkAPIVersion, "", body, ""); err != nil {
+		t.Fatalf("PutResource() of a new resource error = %v", err)
+	}
+	etag, _, err := factory.GetResourceEtag(ctx, testRouteID, NetworkAPIVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the resource exists, a write without its etag must not overwrite it
+	if err := factory.PutResource(ctx, testRouteID, NetworkAPIVersion, "", body, ""); err == nil {
+		t.Error("PutResource() without an etag overwrote an existing resource")
+	}
+	if err := factory.PutResource(ctx, testRouteID, NetworkAPIVersion, "", body, etag); err != nil {
+		t.Fatalf("PutResource() with the current etag error = %v", err)
+	}
+	// the write changed the etag, the one read before is stale
+	if err := factory.PutResource(ctx, testRouteID, NetworkAPIVersion, "", body, etag); err == nil {
+		t.Error("PutResource() with a stale etag overwrote the resource")
+	}
+	if err := factory.DeleteResource(ctx, testRouteID, NetworkAPIVersion, etag); err == nil {
+		t.Error("DeleteResource() with a stale etag deleted the resource")
+	}
+}
+
+func TestPutResourceAwaitsWrite(t *testing.T) {
+	previous := pollFrequency
+	pollFrequency = time.Millisecond
+	t.Cleanup(func() { pollFrequency = previous })
+
+	tests := []struct {
+		name    string
+		final   string
+		wantErr bool
+	}{
+		{name: "succeeded", final: "Succeeded"},
+		{name: "failed", final: "Failed", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const operation = "/subscriptions/00000000-0000-0000-0000-000000000002/providers/Microsoft.Network/locations/westeurope/operations/1"
+			arm := azuretest.NewServer()
+			arm.Put(testRouteID, map[string]any{"properties": map[string]any{"provisioningState": "Succeeded"}})
+			// the write is accepted and completes after two polls
+			arm.Handle(http.MethodPut, testRouteID, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Azure-AsyncOperation", "https://"+r.Host+operation)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte(`{"properties":{"provisioningState":"Updating"}}`))
+			})
+			var polls atomic.Int32
+			arm.Handle(http.MethodGet, operation, func(w http.ResponseWriter, r *http.Request) {
+				status := "InProgress"
+				if polls.Add(1) >= 2 {
+					status = tt.final
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"status":"` + status + `"}`))
+			})
+
+			err := newResourcesFactory(arm).PutResource(context.Background(), testRouteID, NetworkAPIVersion, "", []byte(`{}`), "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PutResource() error = %v, want error: %t", err, tt.wantErr)
+			}
+			if got := polls.Load(); got != 2 {
+				t.Errorf("operation polled %d times, want 2", got)
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), testRouteID) {
+				t.Errorf("PutResource() error = %v, want it to name the resource", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// UpdateSubnet reads the subnet, lets update change it and writes the whole
+// subnet back with its etag. Properties velora doesn't manage are kept, and a
+// change made between the read and the write fails the write instead of
+// being overwritten. update returns false to skip the write.
+func (f *ClientFactory) UpdateSubnet(ctx context.Context, subnetID string, update func(subnet *armnetwork.Subnet) (bool, error)) error {
+	parts := ExtractResourceIDParts(subnetID)
+	if parts["resourceGroups"] == "" || parts["virtualNetworks"] == "" || parts["subnets"] == "" {
+		return fmt.Errorf("invalid subnet ID format: %s", subnetID)
+	}
+
+	subnetsClient, err := f.ForSubscription(SubscriptionIDOf(subnetID)).NewSubnetsClient(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := subnetsClient.Get(ctx, parts["resourceGroups"], parts["virtualNetworks"], parts["subnets"], nil)
+	if err != nil {
+		return fmt.Errorf("failed to get subnet %s: %w", subnetID, err)
+	}
+	subnet := resp.Subnet
+	if subnet.Etag == nil || subnet.Properties == nil {
+		return fmt.Errorf("subnet %s was returned without etag or properties", subnetID)
+	}
+
+	write, err := update(&subnet)
+	if err != nil || !write {
+		return err
+	}
+
+	body, err := json.Marshal(subnet)
+	if err != nil {
+		return fmt.Errorf("failed to encode subnet %s: %w", subnetID, err)
+	}
+	return f.PutResource(ctx, subnetID, f.APIVersion(ResourceTypeSubnets), "", body, *subnet.Etag)
+}
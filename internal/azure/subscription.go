@@ -0,0 +1,86 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// subscriptionsAPIVersion is the Microsoft.Resources subscriptions API version.
+const subscriptionsAPIVersion = "2022-12-01"
+
+// SubscriptionState is the state of an Azure subscription.
+type SubscriptionState string
+
+const (
+	SubscriptionEnabled  SubscriptionState = "Enabled"
+	SubscriptionWarned   SubscriptionState = "Warned"
+	SubscriptionPastDue  SubscriptionState = "PastDue"
+	SubscriptionDisabled SubscriptionState = "Disabled"
+	SubscriptionDeleted  SubscriptionState = "Deleted"
+)
+
+// Inactive reports whether velora should skip a subscription in this state.
+// Warned subscriptions are about to be disabled and ARM rejects their writes.
+func (s SubscriptionState) Inactive() bool {
+	switch s {
+	case SubscriptionWarned, SubscriptionDisabled, SubscriptionDeleted:
+		return true
+	}
+	return false
+}
+
+// inactiveErrorCodes are the ARM error codes returned for calls against
+// subscriptions that aren't active.
+var inactiveErrorCodes = map[string]bool{
+	"ReadOnlyDisabledSubscription": true,
+	"DisabledSubscription":         true,
+	"SubscriptionDisabled":         true,
+	"SubscriptionNotActive":        true,
+	"SubscriptionWarned":           true,
+}
+
+// IsInactiveSubscriptionError reports whether the error was returned because
+// the subscription isn't active.
+func IsInactiveSubscriptionError(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && inactiveErrorCodes[respErr.ErrorCode]
+}
+
+// GetSubscriptionState returns the state of the factory's subscription.
+func (f *ClientFactory) GetSubscriptionState(ctx context.Context) (SubscriptionState, error) {
+	client, err := f.newARMClient()
+	if err != nil {
+		return "", fmt.Errorf("failed to create azure resource manager client: %w", err)
+	}
+
+	endpoint := runtime.JoinPaths(client.Endpoint(), "subscriptions", url.PathEscape(f.subscriptionID))
+	req, err := runtime.NewRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return "", err
+	}
+	query := req.Raw().URL.Query()
+	query.Set("api-version", subscriptionsAPIVersion)
+	req.Raw().URL.RawQuery = query.Encode()
+
+	resp, err := client.Pipeline().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get subscription: %w", err)
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return "", fmt.Errorf("failed to get subscription: %w", runtime.NewResponseError(resp))
+	}
+
+	var subscription struct {
+		State SubscriptionState `json:"state"`
+	}
+	if err := runtime.UnmarshalAsJSON(resp, &subscription); err != nil {
+		return "", fmt.Errorf("failed to parse subscription: %w", err)
+	}
+	return subscription.State, nil
+}
@@ -0,0 +1,54 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// tagsAPIVersion is the Microsoft.Resources tags API version.
+const tagsAPIVersion = "2021-04-01"
+
+// IsScopeLocked reports whether the error was returned because a resource
+// lock forbids the write.
+func IsScopeLocked(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.ErrorCode == "ScopeLocked"
+}
+
+// MergeTags adds the tags to the resource, keeping its other tags. Only the
+// tags are written, not the resource itself.
+func (f *ClientFactory) MergeTags(ctx context.Context, resourceID string, tags map[string]string) error {
+	client, err := f.newARMClient()
+	if err != nil {
+		return fmt.Errorf("failed to create azure resource manager client: %w", err)
+	}
+
+	req, err := runtime.NewRequest(ctx, http.MethodPatch,
+		runtime.JoinPaths(client.Endpoint(), resourceID, "providers/Microsoft.Resources/tags/default"))
+	if err != nil {
+		return err
+	}
+	req.Raw().URL.RawQuery = url.Values{"api-version": {tagsAPIVersion}}.Encode()
+	body := map[string]any{
+		"operation":  "Merge",
+		"properties": map[string]any{"tags": tags},
+	}
+	if err := runtime.MarshalAsJSON(req, body); err != nil {
+		return err
+	}
+
+	resp, err := client.Pipeline().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to tag %s: %w", resourceID, err)
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return fmt.Errorf("failed to tag %s: %w", resourceID, runtime.NewResponseError(resp))
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package azure
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// tracePolicy logs the requests about traced resources with their bodies,
+// the response status and the time taken. Headers aren't logged, they carry
+// the bearer token.
+type tracePolicy struct {
+	traced func(resourceID string) bool
+}
+
+// Do implements policy.Policy.
+func (p tracePolicy) Do(req *policy.Request) (*http.Response, error) {
+	raw := req.Raw()
+	if !p.traced(raw.URL.Path) {
+		return req.Next()
+	}
+
+	var requestBody []byte
+	if body := req.Body(); body != nil {
+		requestBody, _ = io.ReadAll(body)
+		if err := req.RewindBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	resp, err := req.Next()
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		fmt.Printf("TRACE %s: ARM %s failed after %s: %v\n", raw.URL.Path, raw.Method, elapsed, err)
+		return resp, err
+	}
+
+	responseBody, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(responseBody))
+	if readErr != nil {
+		return resp, readErr
+	}
+	fmt.Printf("TRACE %s: ARM %s %s -> %d in %s\n  request: %s\n  response: %s\n",
+		raw.URL.Path, raw.Method, raw.URL.RawQuery, resp.StatusCode, elapsed, requestBody, responseBody)
+	return resp, nil
+}
+
+// EnableTracing makes all clients created by the factory log the requests
+// about the resources traced reports true for.
+func (f *ClientFactory) EnableTracing(traced func(resourceID string) bool) {
+	f.clientOptions.PerCallPolicies = append(f.clientOptions.PerCallPolicies, tracePolicy{traced: traced})
+}
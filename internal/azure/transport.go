@@ -0,0 +1,44 @@
+package azure
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http/httpproxy"
+
+	"github.com/akos011221/velora/internal/config"
+)
+
+// newHTTPClient returns the HTTP client of ARM and identity traffic, with
+// the configured proxy and CA bundle. It is nil if neither is configured,
+// the SDK's default client is used then. Other traffic, like notifications,
+// doesn't use it.
+func newHTTPClient(cfg *config.AzureConfig) (*http.Client, error) {
+	if cfg.HTTPProxy == "" && cfg.CABundlePath == "" {
+		return nil, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.HTTPProxy != "" {
+		// the configured proxy replaces HTTPS_PROXY and NO_PROXY, which
+		// still apply to the other traffic
+		proxy := (&httpproxy.Config{
+			HTTPProxy:  cfg.HTTPProxy,
+			HTTPSProxy: cfg.HTTPProxy,
+			NoProxy:    cfg.NoProxy,
+		}).ProxyFunc()
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxy(req.URL)
+		}
+	}
+	if cfg.CABundlePath != "" {
+		pool, err := config.LoadCABundle(cfg.CABundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load azure.caBundlePath: %w", err)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &http.Client{Transport: transport}, nil
+}
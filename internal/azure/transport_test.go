@@ -0,0 +1,100 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+)
+
+// TestHTTPClientProxy checks ARM requests go through the configured proxy,
+// trusting the CA bundle, and not through HTTPS_PROXY.
+func TestHTTPClientProxy(t *testing.T) {
+	const vnetID = "/subscriptions/00000000-0000-0000-0000-000000000002/resourceGroups/spoke-rg/providers/Microsoft.Network/virtualNetworks/spoke"
+	arm := azuretest.NewServer()
+	arm.Put(vnetID, azuretest.VNet(vnetID, []string{"10.1.0.0/16"}))
+	server, caBundle := azuretest.NewTLSServer(t, arm)
+	// ambient proxy settings don't apply to ARM traffic
+	t.Setenv("HTTPS_PROXY", azuretest.NewProxy(t, "").URL)
+
+	tests := []struct {
+		name      string
+		caBundle  bool
+		wantErr   bool
+		wantHosts []string
+	}{
+		{name: "proxied", caBundle: true, wantHosts: []string{azuretest.ARMHost + ":443"}},
+		// the proxy's TLS inspection certificate isn't trusted by default
+		{name: "without CA bundle", caBundle: false, wantErr: true, wantHosts: []string{azuretest.ARMHost + ":443"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := azuretest.NewProxy(t, server.Listener.Addr().String())
+			cfg := &config.AzureConfig{HTTPProxy: proxy.URL}
+			if tt.caBundle {
+				cfg.CABundlePath = caBundle
+			}
+			httpClient, err := newHTTPClient(cfg)
+			if err != nil {
+				t.Fatalf("newHTTPClient() error = %v", err)
+			}
+			defer httpClient.CloseIdleConnections()
+			factory := NewClientFactoryWithTransport(cfg, azuretest.Credential{}, httpClient).ForSubscription("00000000-0000-0000-0000-000000000002")
+			vnetsClient, err := factory.NewVirtualNeworksClient(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = vnetsClient.Get(context.Background(), "spoke-rg", "spoke", nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, want error %v", err, tt.wantErr)
+			}
+			// retries of the failed handshake ask again
+			if hosts := slices.Compact(proxy.Hosts()); !slices.Equal(hosts, tt.wantHosts) {
+				t.Errorf("proxied hosts = %v, want %v", hosts, tt.wantHosts)
+			}
+		})
+	}
+}
+
+// TestHTTPClientNoProxy checks the hosts of azure.noProxy are reached
+// directly.
+func TestHTTPClientNoProxy(t *testing.T) {
+	tests := []struct {
+		name    string
+		noProxy string
+		host    string
+		want    string
+	}{
+		{name: "proxied", host: azuretest.ARMHost, want: "http://proxy:3128"},
+		{name: "identity proxied", noProxy: "vault.azure.net", host: "login.microsoftonline.com", want: "http://proxy:3128"},
+		{name: "no proxy", noProxy: azuretest.ARMHost, host: azuretest.ARMHost},
+		{name: "no proxy domain", noProxy: ".azure.com", host: azuretest.ARMHost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpClient, err := newHTTPClient(&config.AzureConfig{HTTPProxy: "http://proxy:3128", NoProxy: tt.noProxy})
+			if err != nil {
+				t.Fatalf("newHTTPClient() error = %v", err)
+			}
+			req, err := http.NewRequest(http.MethodGet, "https://"+tt.host+"/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxy, err := httpClient.Transport.(*http.Transport).Proxy(req)
+			if err != nil {
+				t.Fatalf("Proxy() error = %v", err)
+			}
+			got := ""
+			if proxy != nil {
+				got = proxy.String()
+			}
+			if got != tt.want {
+				t.Errorf("Proxy(%s) = %q, want %q", tt.host, got, tt.want)
+			}
+		})
+	}
+}
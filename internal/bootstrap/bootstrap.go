@@ -0,0 +1,199 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+)
+
+// clientSecretComment explains the empty client secret of the starter config.
+const clientSecretComment = "clientSecret is left empty on purpose, useAzureIdentity authenticates with " +
+	"the managed identity or environment credentials. To use a service principal set useAzureIdentity " +
+	"to false, set tenantId and clientId, and pass the secret in the config file or a secret store, never in git."
+
+// readOnlyComment explains why the starter config doesn't make changes.
+const readOnlyComment = "the starter config only observes: readOnly blocks every write and autoRemediation " +
+	"is off. Review the findings of velora scan before turning either on."
+
+// NVACandidate is a NIC in the hub VNet with IP forwarding enabled, a
+// likely NVA.
+type NVACandidate struct {
+	NICID     string
+	PrivateIP string
+}
+
+// Spoke is a VNet peered with the hub.
+type Spoke struct {
+	VNetID         string
+	SubscriptionID string
+	AddressSpace   []string
+}
+
+// Discovery is what was read about a hub and its spokes.
+type Discovery struct {
+	HubVNetID      string
+	SubscriptionID string
+	ResourceGroup  string
+	Name           string
+	AddressSpace   []string
+	NVACandidates  []NVACandidate
+	Spokes         []Spoke
+}
+
+// Discover reads the hub VNet, the NICs that may be its NVA, and the spokes
+// peered with it. It only reads, the factory should refuse writes.
+func Discover(ctx context.Context, clientFactory *azure.ClientFactory, hubVNetID string) (*Discovery, error) {
+	parts := azure.ExtractResourceIDParts(hubVNetID)
+	d := &Discovery{
+		HubVNetID:      hubVNetID,
+		SubscriptionID: parts["subscriptions"],
+		ResourceGroup:  parts["resourceGroups"],
+		Name:           parts["virtualNetworks"],
+	}
+	if d.SubscriptionID == "" || d.ResourceGroup == "" || d.Name == "" {
+		return nil, fmt.Errorf("invalid hub VNet resource ID: %s", hubVNetID)
+	}
+
+	subFactory := clientFactory.ForSubscription(d.SubscriptionID)
+	vnetsClient, err := subFactory.NewVirtualNeworksClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vnet, err := vnetsClient.Get(ctx, d.ResourceGroup, d.Name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hub VNet %s: %w", hubVNetID, err)
+	}
+	if vnet.ID != nil {
+		d.HubVNetID = *vnet.ID
+	}
+
+	if props := vnet.Properties; props != nil {
+		if props.AddressSpace != nil {
+			d.AddressSpace = derefAll(props.AddressSpace.AddressPrefixes)
+		}
+
+		for _, peering := range props.VirtualNetworkPeerings {
+			if peering == nil || peering.Properties == nil || peering.Properties.RemoteVirtualNetwork == nil ||
+				peering.Properties.RemoteVirtualNetwork.ID == nil {
+				continue
+			}
+			remoteID := *peering.Properties.RemoteVirtualNetwork.ID
+			spoke := Spoke{VNetID: remoteID, SubscriptionID: azure.SubscriptionIDOf(remoteID)}
+			// the peering knows the remote address space, spokes in other
+			// subscriptions may not be readable yet
+			if space := peering.Properties.RemoteAddressSpace; space != nil {
+				spoke.AddressSpace = derefAll(space.AddressPrefixes)
+			}
+			d.Spokes = append(d.Spokes, spoke)
+		}
+	}
+
+	if d.NVACandidates, err = nvaCandidates(ctx, subFactory, d.HubVNetID); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// nvaCandidates lists the NICs with IP forwarding enabled that are attached
+// to a subnet of the hub VNet.
+func nvaCandidates(ctx context.Context, subFactory *azure.ClientFactory, hubVNetID string) ([]NVACandidate, error) {
+	owners, err := subFactory.ListIPOwners(ctx, hubVNetID)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []NVACandidate
+	for _, owner := range owners {
+		if owner.IPForwarding {
+			candidates = append(candidates, NVACandidate{NICID: owner.ID, PrivateIP: owner.PrivateIP})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].NICID < candidates[j].NICID
+	})
+	return candidates, nil
+}
+
+// StarterConfig is the config file written by velora init. It is a subset
+// of config.Config, with comments on the settings left for the user.
+type StarterConfig struct {
+	Version       int                                  `json:"version"`
+	Comment       string                               `json:"_comment"`
+	Azure         StarterAzureConfig                   `json:"azure"`
+	ReadOnly      bool                                 `json:"readOnly"`
+	Features      config.FeaturesConfig                `json:"features"`
+	Hubs          []config.HubVNetConfig               `json:"hubs"`
+	Subscriptions map[string]config.SubscriptionConfig `json:"subscriptions"`
+}
+
+// StarterAzureConfig is the azure section of the starter config.
+type StarterAzureConfig struct {
+	Comment string `json:"_comment"`
+	config.AzureConfig
+}
+
+// StarterConfig returns a config enforcing NVA routing and hub peering on
+// every spoke, with all features observing only. Spokes are grouped by
+// subscription, their address spaces become the allowed CIDRs.
+func (d *Discovery) StarterConfig(nvaNextHop string) *StarterConfig {
+	cfg := &StarterConfig{
+		Version: config.CurrentVersion,
+		Comment: readOnlyComment,
+		Azure: StarterAzureConfig{
+			Comment: clientSecretComment,
+			AzureConfig: config.AzureConfig{
+				SubscriptionID:   d.SubscriptionID,
+				UseAzureIdentity: true,
+			},
+		},
+		ReadOnly: true,
+		Features: config.FeaturesConfig{
+			IPAMEnforcement:    true,
+			RoutingEnforcement: true,
+			PeeringEnforcement: true,
+			GatewayPolicy:      true,
+			ComplianceScanning: true,
+			AutoRemediation:    false,
+		},
+		Hubs: []config.HubVNetConfig{{
+			VNetID:     d.HubVNetID,
+			Name:       d.Name,
+			NVANextHop: nvaNextHop,
+		}},
+		Subscriptions: make(map[string]config.SubscriptionConfig),
+	}
+
+	for _, spoke := range d.Spokes {
+		sub, ok := cfg.Subscriptions[spoke.SubscriptionID]
+		if !ok {
+			sub = config.SubscriptionConfig{
+				HubName:           d.Name,
+				RequireHubPeering: true,
+				RequireNVARouting: true,
+			}
+		}
+		for _, prefix := range spoke.AddressSpace {
+			if !slices.Contains(sub.AllowedCIDRs, prefix) {
+				sub.AllowedCIDRs = append(sub.AllowedCIDRs, prefix)
+			}
+		}
+		sort.Strings(sub.AllowedCIDRs)
+		cfg.Subscriptions[spoke.SubscriptionID] = sub
+	}
+	return cfg
+}
+
+// derefAll returns the non-nil strings.
+func derefAll(values []*string) []string {
+	var result []string
+	for _, v := range values {
+		if v != nil {
+			result = append(result, *v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,257 @@
+package breakglass
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// stateKey is the state store key of the activation and its audit events.
+const stateKey = "break-glass"
+
+// TokenEnv is the environment variable the break-glass token is read from,
+// so it never appears in the configuration or the shell history.
+const TokenEnv = "VELORA_BREAK_GLASS_TOKEN"
+
+// MaxDuration bounds an activation, break-glass mode always expires.
+const MaxDuration = 12 * time.Hour
+
+// maxEvents bounds the audit events kept, the oldest are dropped.
+const maxEvents = 1000
+
+// Kinds of audit events.
+const (
+	EventActivated   = "activated"
+	EventDenied      = "denied"
+	EventRun         = "run"
+	EventExpired     = "expired"
+	EventDeactivated = "deactivated"
+)
+
+// Activation is an active break-glass mode: approvals, onboarding
+// acknowledgment and maintenance windows don't hold back writes until it
+// expires. Pauses, read-only mode and quotas still apply.
+type Activation struct {
+	Reason    string    `json:"reason"`
+	SetBy     string    `json:"setBy"`
+	SetAt     time.Time `json:"setAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// Runs counts the runs executed under the activation.
+	Runs int `json:"runs"`
+}
+
+// Expired reports whether the activation has automatically ended.
+func (a *Activation) Expired(now time.Time) bool {
+	return !now.Before(a.ExpiresAt)
+}
+
+// String describes the activation for run summaries.
+func (a *Activation) String() string {
+	return fmt.Sprintf("break-glass activated by %s until %s, reason: %s",
+		a.SetBy, a.ExpiresAt.UTC().Format(time.RFC3339), a.Reason)
+}
+
+// Event is an audit event of break-glass mode.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	By     string    `json:"by,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// String describes the event for the audit log.
+func (e Event) String() string {
+	s := fmt.Sprintf("%s break-glass %s", e.Time.UTC().Format(time.RFC3339), e.Kind)
+	if e.By != "" {
+		s += " by " + e.By
+	}
+	if e.Reason != "" {
+		s += ", reason: " + e.Reason
+	}
+	if e.Detail != "" {
+		s += ", " + e.Detail
+	}
+	return s
+}
+
+// record is what the state store keeps.
+type record struct {
+	Active *Activation `json:"active,omitempty"`
+	Events []Event     `json:"events"`
+}
+
+// Manager manages break-glass mode persisted in the state store.
+type Manager struct {
+	store state.Store
+	mu    sync.Mutex
+}
+
+// NewManager creates a new break-glass manager instance.
+func NewManager(store state.Store) *Manager {
+	return &Manager{store: store}
+}
+
+// Activate activates break-glass mode for the duration. The token must hash
+// to the configured one, a wrong token is audited as a denied activation.
+func (m *Manager) Activate(cfg *config.BreakGlassConfig, token, reason, setBy string, duration time.Duration) (*Activation, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("break-glass mode isn't configured, set breakGlass.tokenSha256")
+	}
+	if reason == "" {
+		return nil, fmt.Errorf("a reason is required to activate break-glass mode")
+	}
+	if duration <= 0 || duration > MaxDuration {
+		return nil, fmt.Errorf("break-glass mode requires a duration of at most %s", MaxDuration)
+	}
+	if setBy == "" {
+		return nil, fmt.Errorf("who activates break-glass mode is required")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("the break-glass token is required in %s", TokenEnv)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, err := m.load(time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if !verifyToken(token, cfg.TokenSHA256) {
+		m.audit(rec, Event{Time: now, Kind: EventDenied, By: setBy, Reason: reason, Detail: "wrong token"})
+		if err := m.save(rec); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("wrong break-glass token")
+	}
+	if rec.Active != nil {
+		return nil, fmt.Errorf("break-glass mode is already active: %s", rec.Active)
+	}
+
+	rec.Active = &Activation{Reason: reason, SetBy: setBy, SetAt: now, ExpiresAt: now.Add(duration)}
+	m.audit(rec, Event{Time: now, Kind: EventActivated, By: setBy, Reason: reason,
+		Detail: "until " + rec.Active.ExpiresAt.Format(time.RFC3339)})
+	if err := m.save(rec); err != nil {
+		return nil, err
+	}
+	return rec.Active, nil
+}
+
+// Deactivate ends break-glass mode before it expires.
+func (m *Manager) Deactivate(by string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	rec, err := m.load(now)
+	if err != nil {
+		return err
+	}
+	if rec.Active == nil {
+		return fmt.Errorf("break-glass mode isn't active")
+	}
+	m.audit(rec, Event{Time: now, Kind: EventDeactivated, By: by,
+		Detail: fmt.Sprintf("%d runs executed under it", rec.Active.Runs)})
+	rec.Active = nil
+	return m.save(rec)
+}
+
+// Active returns the activation in effect, nil if break-glass mode isn't
+// active. An expiry is audited the first time it is noticed.
+func (m *Manager) Active() (*Activation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, err := m.load(time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	return rec.Active, nil
+}
+
+// RecordRun audits a run executed under break-glass mode and returns the
+// activation, nil if break-glass mode isn't active.
+func (m *Manager) RecordRun(detail string) (*Activation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	rec, err := m.load(now)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Active == nil {
+		return nil, nil
+	}
+	rec.Active.Runs++
+	m.audit(rec, Event{Time: now, Kind: EventRun, By: rec.Active.SetBy, Reason: rec.Active.Reason, Detail: detail})
+	if err := m.save(rec); err != nil {
+		return nil, err
+	}
+	return rec.Active, nil
+}
+
+// Events returns the audit events, oldest first.
+func (m *Manager) Events() ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, err := m.load(time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	return rec.Events, nil
+}
+
+// load reads the record from the state store. An expired activation is
+// ended and its expiry audited.
+func (m *Manager) load(now time.Time) (*record, error) {
+	rec := &record{}
+	if err := m.store.Get(stateKey, rec); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load break-glass state: %w", err)
+	}
+	if rec.Active != nil && rec.Active.Expired(now) {
+		m.audit(rec, Event{Time: rec.Active.ExpiresAt, Kind: EventExpired, Reason: rec.Active.Reason,
+			Detail: fmt.Sprintf("activated by %s, %d runs executed under it", rec.Active.SetBy, rec.Active.Runs)})
+		rec.Active = nil
+		if err := m.save(rec); err != nil {
+			return nil, err
+		}
+	}
+	return rec, nil
+}
+
+// save writes the record to the state store.
+func (m *Manager) save(rec *record) error {
+	if err := m.store.Put(stateKey, rec); err != nil {
+		return fmt.Errorf("failed to save break-glass state: %w", err)
+	}
+	return nil
+}
+
+// audit appends the event and prints it, break-glass events are never
+// silent.
+func (m *Manager) audit(rec *record, event Event) {
+	rec.Events = append(rec.Events, event)
+	if len(rec.Events) > maxEvents {
+		rec.Events = rec.Events[len(rec.Events)-maxEvents:]
+	}
+	fmt.Println("AUDIT:", event)
+}
+
+// verifyToken reports whether the token hashes to the hex-encoded SHA-256.
+func verifyToken(token, tokenSHA256 string) bool {
+	sum := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(tokenSHA256))) == 1
+}
@@ -0,0 +1,83 @@
+package bundle
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// subscriptionPattern matches the subscription of resource IDs.
+var subscriptionPattern = regexp.MustCompile(`(?i)/subscriptions/([0-9a-z-]+)`)
+
+// Anonymizer replaces subscription IDs with pseudonyms, the same ID gets the
+// same pseudonym in every file of a bundle.
+type Anonymizer struct {
+	// pseudonyms are the pseudonyms by lower-case subscription ID.
+	pseudonyms map[string]string
+	// ids are the subscription IDs as first seen, in order of pseudonym.
+	ids     []string
+	pattern *regexp.Regexp
+}
+
+// NewAnonymizer creates a new anonymizer. The known subscription IDs get
+// their pseudonyms in sorted order, so bundles of the same configuration
+// agree; IDs found in resource IDs are added as they are seen.
+func NewAnonymizer(subscriptionIDs []string) *Anonymizer {
+	a := &Anonymizer{pseudonyms: make(map[string]string)}
+	sorted := append([]string(nil), subscriptionIDs...)
+	sort.Strings(sorted)
+	for _, id := range sorted {
+		a.add(id)
+	}
+	return a
+}
+
+// add assigns the next pseudonym to a subscription ID not seen yet, and
+// reports whether it did.
+func (a *Anonymizer) add(id string) bool {
+	key := strings.ToLower(id)
+	if key == "" {
+		return false
+	}
+	if _, ok := a.pseudonyms[key]; ok {
+		return false
+	}
+	a.ids = append(a.ids, id)
+	a.pseudonyms[key] = fmt.Sprintf("00000000-0000-0000-0000-%012d", len(a.ids))
+	a.pattern = nil
+	return true
+}
+
+// Apply returns s with every subscription ID replaced by its pseudonym.
+func (a *Anonymizer) Apply(s string) string {
+	for _, m := range subscriptionPattern.FindAllStringSubmatch(s, -1) {
+		a.add(m[1])
+	}
+	if len(a.ids) == 0 {
+		return s
+	}
+	if a.pattern == nil {
+		// longest first, so an ID containing another is replaced whole
+		quoted := make([]string, 0, len(a.ids))
+		for _, id := range a.ids {
+			quoted = append(quoted, regexp.QuoteMeta(id))
+		}
+		sort.Slice(quoted, func(i, j int) bool {
+			return len(quoted[i]) > len(quoted[j])
+		})
+		a.pattern = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+	return a.pattern.ReplaceAllStringFunc(s, func(id string) string {
+		return a.pseudonyms[strings.ToLower(id)]
+	})
+}
+
+// Mapping returns the subscription IDs by pseudonym.
+func (a *Anonymizer) Mapping() map[string]string {
+	mapping := make(map[string]string, len(a.ids))
+	for _, id := range a.ids {
+		mapping[a.pseudonyms[strings.ToLower(id)]] = id
+	}
+	return mapping
+}
@@ -0,0 +1,145 @@
+package bundle
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/akos011221/velora/internal/redact"
+	"github.com/akos011221/velora/internal/version"
+)
+
+// Names of the files every bundle has.
+const (
+	ManifestName = "manifest.json"
+	MappingName  = "anonymization-map.json"
+)
+
+// File describes a file of the bundle in the manifest.
+type File struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Size        int    `json:"size"`
+}
+
+// Excluded describes an artifact left out of the bundle, with the reason.
+type Excluded struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// Manifest describes the contents of a bundle.
+type Manifest struct {
+	CreatedAt time.Time    `json:"createdAt"`
+	Version   version.Info `json:"version"`
+	// Anonymized is set if the subscription IDs were replaced by pseudonyms.
+	Anonymized bool `json:"anonymized"`
+	// MappingIncluded is set if the bundle maps the pseudonyms back to the
+	// subscription IDs.
+	MappingIncluded bool       `json:"mappingIncluded"`
+	Files           []File     `json:"files"`
+	Excluded        []Excluded `json:"excluded"`
+}
+
+// file is a file added to the bundle.
+type file struct {
+	name string
+	data []byte
+}
+
+// Bundle collects the artifacts of a support bundle. Everything added is
+// scrubbed of registered secrets and, with an anonymizer, of subscription
+// IDs.
+type Bundle struct {
+	maxFileSize int
+	anonymizer  *Anonymizer
+	files       []file
+	manifest    Manifest
+}
+
+// New creates a new bundle. Artifacts larger than maxFileSize bytes are
+// excluded, anonymizer may be nil to keep subscription IDs.
+func New(maxFileSize int, anonymizer *Anonymizer) *Bundle {
+	return &Bundle{
+		maxFileSize: maxFileSize,
+		anonymizer:  anonymizer,
+		manifest: Manifest{
+			CreatedAt:  time.Now().UTC(),
+			Version:    version.Get(),
+			Anonymized: anonymizer != nil,
+			Files:      []File{},
+			Excluded:   []Excluded{},
+		},
+	}
+}
+
+// Add adds an artifact, sanitized.
+func (b *Bundle) Add(name, description string, data []byte) {
+	if len(data) > b.maxFileSize {
+		b.Exclude(name, fmt.Sprintf("%d bytes, over the limit of %d bytes", len(data), b.maxFileSize))
+		return
+	}
+	sanitized := redact.String(string(data))
+	if b.anonymizer != nil {
+		sanitized = b.anonymizer.Apply(sanitized)
+	}
+	b.files = append(b.files, file{name: name, data: []byte(sanitized)})
+	b.manifest.Files = append(b.manifest.Files, File{Name: name, Description: description, Size: len(sanitized)})
+}
+
+// AddJSON adds an artifact encoded as indented JSON.
+func (b *Bundle) AddJSON(name, description string, v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		b.Exclude(name, fmt.Sprintf("failed to encode: %v", err))
+		return
+	}
+	b.Add(name, description, append(data, '\n'))
+}
+
+// Exclude records an artifact left out of the bundle.
+func (b *Bundle) Exclude(name, reason string) {
+	b.manifest.Excluded = append(b.manifest.Excluded, Excluded{Name: name, Reason: redact.String(reason)})
+}
+
+// Manifest returns the manifest of the artifacts added so far.
+func (b *Bundle) Manifest() Manifest {
+	return b.manifest
+}
+
+// Write writes the bundle as a zip archive: the manifest, the artifacts and,
+// with keepMapping, the pseudonyms of the anonymized subscription IDs.
+func (b *Bundle) Write(w io.Writer, keepMapping bool) error {
+	manifest := b.manifest
+	manifest.MappingIncluded = keepMapping && b.anonymizer != nil
+
+	zw := zip.NewWriter(w)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	files := append([]file{{name: ManifestName, data: append(data, '\n')}}, b.files...)
+	if manifest.MappingIncluded {
+		mapping, err := json.MarshalIndent(b.anonymizer.Mapping(), "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode anonymization map: %w", err)
+		}
+		files = append(files, file{name: MappingName, data: append(mapping, '\n')})
+	}
+
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: manifest.CreatedAt})
+		if err != nil {
+			return fmt.Errorf("failed to add %s to the bundle: %w", f.name, err)
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return fmt.Errorf("failed to add %s to the bundle: %w", f.name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to write the bundle: %w", err)
+	}
+	return nil
+}
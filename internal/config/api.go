@@ -0,0 +1,40 @@
+package config
+
+import "fmt"
+
+// APIConfig represents the API configuration.
+type APIConfig struct {
+	ListenAddress string `json:"listenAddress"`
+	Port          int    `json:"port"`
+	TLSEnabled    bool   `json:"tlsEnabled"`
+	TLSCertPath   string `json:"tlsCertPath"`
+	TLSKeyPath    string `json:"tlsKeyPath"`
+	// UIEnabled serves the read-only web UI under /ui, and keeps the
+	// recent runs with their findings for it.
+	UIEnabled bool `json:"uiEnabled"`
+	// TokenSHA256 is the hash of the token the web UI requires, as a
+	// bearer token or the password of basic auth. Without it the UI only
+	// listens on a loopback address.
+	TokenSHA256 string `json:"tokenSha256,omitempty"`
+}
+
+// DefaultAPIListenAddress is the address the web UI listens on when
+// api.listenAddress is unset.
+const DefaultAPIListenAddress = "127.0.0.1"
+
+// EffectiveListenAddress returns the listen address, the loopback address
+// if unset.
+func (a *APIConfig) EffectiveListenAddress() string {
+	if a.ListenAddress != "" {
+		return a.ListenAddress
+	}
+	return DefaultAPIListenAddress
+}
+
+// validate checks the token hash of the web UI.
+func (a *APIConfig) validate() error {
+	if a.TokenSHA256 != "" && !sha256Hex.MatchString(a.TokenSHA256) {
+		return fmt.Errorf("api.tokenSha256 must be the hex-encoded SHA-256 of the UI token")
+	}
+	return nil
+}
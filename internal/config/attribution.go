@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Defaults of the Activity Log attribution.
+const (
+	DefaultAttributionLookback          = 24 * time.Hour
+	DefaultAttributionRequestsPerMinute = 30
+	DefaultAttributionWait              = 30 * time.Second
+)
+
+// AttributionConfig looks up the last write of the resource of a finding in
+// the Activity Log when the finding is first reported, and attaches its
+// caller to the finding.
+type AttributionConfig struct {
+	// LookbackHours is how far before the finding writes are looked up,
+	// DefaultAttributionLookback if unset.
+	LookbackHours int `json:"lookbackHours,omitempty"`
+	// RequestsPerMinute paces the Activity Log requests,
+	// DefaultAttributionRequestsPerMinute if unset.
+	RequestsPerMinute int `json:"requestsPerMinute,omitempty"`
+	// WaitSeconds is how long a run waits for the lookups still pending
+	// once its controllers are done, DefaultAttributionWait if unset. The
+	// lookups it doesn't wait for are retried by the next run.
+	WaitSeconds int `json:"waitSeconds,omitempty"`
+}
+
+// Lookback returns the lookback window, DefaultAttributionLookback if unset.
+func (a *AttributionConfig) Lookback() time.Duration {
+	if a.LookbackHours > 0 {
+		return time.Duration(a.LookbackHours) * time.Hour
+	}
+	return DefaultAttributionLookback
+}
+
+// EffectiveRequestsPerMinute returns RequestsPerMinute, or its default.
+func (a *AttributionConfig) EffectiveRequestsPerMinute() int {
+	if a.RequestsPerMinute > 0 {
+		return a.RequestsPerMinute
+	}
+	return DefaultAttributionRequestsPerMinute
+}
+
+// Wait returns how long a run waits for pending lookups,
+// DefaultAttributionWait if unset.
+func (a *AttributionConfig) Wait() time.Duration {
+	if a.WaitSeconds > 0 {
+		return time.Duration(a.WaitSeconds) * time.Second
+	}
+	return DefaultAttributionWait
+}
+
+// validate checks the settings aren't negative. The Activity Log keeps
+// 90 days.
+func (a *AttributionConfig) validate() error {
+	if a == nil {
+		return nil
+	}
+	if a.LookbackHours < 0 || a.LookbackHours > 90*24 {
+		return fmt.Errorf("invalid attribution.lookbackHours %d, must be between 0 and 2160", a.LookbackHours)
+	}
+	if a.RequestsPerMinute < 0 {
+		return fmt.Errorf("invalid attribution.requestsPerMinute %d, must not be negative", a.RequestsPerMinute)
+	}
+	if a.WaitSeconds < 0 {
+		return fmt.Errorf("invalid attribution.waitSeconds %d, must not be negative", a.WaitSeconds)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// AzureConfig represents the Azure-specific configuration.
+type AzureConfig struct {
+	SubscriptionID   string `json:"subscriptionId"`
+	TenantID         string `json:"tenantId"`
+	ClientID         string `json:"clientId"`
+	ClientSecret     string `json:"clientSecret"`
+	UseAzureIdentity bool   `json:"useAzureIdentity"`
+	// TokenTimeoutSeconds bounds every token acquisition, 0 uses the default.
+	TokenTimeoutSeconds int `json:"tokenTimeoutSeconds"`
+	// APIVersionOverrides pins the API version per resource type, e.g.
+	// "Microsoft.Network/routeTables": "2021-08-01".
+	APIVersionOverrides map[string]string `json:"apiVersionOverrides,omitempty"`
+	// HTTPProxy is the proxy of ARM and identity traffic, e.g.
+	// "http://proxy:3128". Unset uses HTTPS_PROXY. Notifications aren't
+	// affected.
+	HTTPProxy string `json:"httpProxy,omitempty"`
+	// NoProxy are the hosts reached directly instead of through HTTPProxy,
+	// comma-separated with the syntax of NO_PROXY.
+	NoProxy string `json:"noProxy,omitempty"`
+	// CABundlePath is a PEM file of CA certificates trusted for ARM and
+	// identity traffic in addition to the system ones, e.g. for a proxy
+	// inspecting TLS.
+	CABundlePath string `json:"caBundlePath,omitempty"`
+	// ListPageSize is the $top of network list requests, the items per
+	// page. 0 leaves the page size to ARM.
+	ListPageSize int `json:"listPageSize,omitempty"`
+	// ListPageMaxKB is the largest list page, a larger page is fetched again
+	// with half the page size. 0 uses DefaultListPageMaxKB.
+	ListPageMaxKB int `json:"listPageMaxKB,omitempty"`
+	// ListPageTimeoutSeconds bounds a list page, one taking longer is
+	// fetched again with half the page size. 0 uses
+	// DefaultListPageTimeout.
+	ListPageTimeoutSeconds int `json:"listPageTimeoutSeconds,omitempty"`
+}
+
+// Defaults of the adaptive list page size.
+const (
+	DefaultListPageMaxKB   = 8 << 10
+	DefaultListPageTimeout = 60 * time.Second
+)
+
+// EffectiveListPageMaxKB returns the largest list page in KB,
+// DefaultListPageMaxKB if unset.
+func (a *AzureConfig) EffectiveListPageMaxKB() int {
+	if a.ListPageMaxKB == 0 {
+		return DefaultListPageMaxKB
+	}
+	return a.ListPageMaxKB
+}
+
+// ListPageTimeout returns the timeout of a list page, DefaultListPageTimeout
+// if unset.
+func (a *AzureConfig) ListPageTimeout() time.Duration {
+	if a.ListPageTimeoutSeconds == 0 {
+		return DefaultListPageTimeout
+	}
+	return time.Duration(a.ListPageTimeoutSeconds) * time.Second
+}
+
+var (
+	// resourceTypePattern matches resource types like Microsoft.Network/routeTables/routes.
+	resourceTypePattern = regexp.MustCompile(`^[A-Za-z0-9]+(\.[A-Za-z0-9]+)+(/[A-Za-z0-9]+)+$`)
+	// apiVersionPattern matches ARM API versions like 2022-01-01 or 2022-01-01-preview.
+	apiVersionPattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(-preview)?$`)
+)
+
+// MissingCredentialFields returns the fields required by client secret
+// authentication that are not set. It is empty when managed identity is used.
+func (a *AzureConfig) MissingCredentialFields() []string {
+	if a.UseAzureIdentity {
+		return nil
+	}
+
+	var missing []string
+	if a.TenantID == "" {
+		missing = append(missing, "azure.tenantId")
+	}
+	if a.ClientID == "" {
+		missing = append(missing, "azure.clientId")
+	}
+	if a.ClientSecret == "" {
+		missing = append(missing, "azure.clientSecret")
+	}
+	return missing
+}
+
+// IgnoredCredentialFields returns the credential fields that are set but
+// not used because managed identity authentication is selected.
+func (a *AzureConfig) IgnoredCredentialFields() []string {
+	if !a.UseAzureIdentity {
+		return nil
+	}
+
+	var ignored []string
+	if a.ClientSecret != "" {
+		ignored = append(ignored, "azure.clientSecret")
+	}
+	return ignored
+}
+
+// validateTransport checks the proxy and the CA bundle.
+func (a *AzureConfig) validateTransport() error {
+	if a.HTTPProxy != "" {
+		u, err := url.Parse(a.HTTPProxy)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid azure.httpProxy %q, expected e.g. http://proxy:3128", redactURL(a.HTTPProxy))
+		}
+	} else if a.NoProxy != "" {
+		return fmt.Errorf("azure.noProxy requires azure.httpProxy")
+	}
+
+	if a.CABundlePath != "" {
+		if _, err := LoadCABundle(a.CABundlePath); err != nil {
+			return fmt.Errorf("invalid azure.caBundlePath: %w", err)
+		}
+	}
+	return nil
+}
+
+// validate checks the credentials, the transport, the list page settings
+// and the API version overrides.
+func (a *AzureConfig) validate() error {
+	if missing := a.MissingCredentialFields(); len(missing) > 0 {
+		return fmt.Errorf("client secret authentication requires %s to be set", strings.Join(missing, ", "))
+	}
+	if err := a.validateTransport(); err != nil {
+		return err
+	}
+	if a.TokenTimeoutSeconds < 0 {
+		return fmt.Errorf("invalid azure.tokenTimeoutSeconds %d, must not be negative", a.TokenTimeoutSeconds)
+	}
+	if a.ListPageSize < 0 {
+		return fmt.Errorf("invalid azure.listPageSize %d, must not be negative", a.ListPageSize)
+	}
+	if a.ListPageMaxKB < 0 {
+		return fmt.Errorf("invalid azure.listPageMaxKB %d, must not be negative", a.ListPageMaxKB)
+	}
+	if a.ListPageTimeoutSeconds < 0 {
+		return fmt.Errorf("invalid azure.listPageTimeoutSeconds %d, must not be negative", a.ListPageTimeoutSeconds)
+	}
+	for resourceType, apiVersion := range a.APIVersionOverrides {
+		if !resourceTypePattern.MatchString(resourceType) {
+			return fmt.Errorf("invalid resource type %q in azure.apiVersionOverrides, expected e.g. Microsoft.Network/routeTables", resourceType)
+		}
+		if !apiVersionPattern.MatchString(apiVersion) {
+			return fmt.Errorf("invalid api version %q for %s in azure.apiVersionOverrides, expected e.g. 2022-01-01", apiVersion, resourceType)
+		}
+	}
+	return nil
+}
+
+// LoadCABundle returns the system CA certificates with the ones of the PEM
+// file added.
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// redactURL drops the credentials of a URL for error messages.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		if at := strings.LastIndex(raw, "@"); at >= 0 {
+			return "REDACTED" + raw[at:]
+		}
+		return raw
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,38 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/akos011221/velora/internal/config/configtest"
+)
+
+func TestValidateAPIVersionOverrides(t *testing.T) {
+	tests := []struct {
+		resourceType string
+		apiVersion   string
+		wantErr      string
+	}{
+		{resourceType: "Microsoft.Network/routeTables", apiVersion: "2023-09-01"},
+		{resourceType: "Microsoft.Network/virtualNetworks/virtualNetworkPeerings", apiVersion: "2024-01-01-preview"},
+		{resourceType: "routeTables", apiVersion: "2023-09-01", wantErr: "invalid resource type"},
+		{resourceType: "Microsoft.Network/routeTables", apiVersion: "2023-9-1", wantErr: "invalid api version"},
+		{resourceType: "Microsoft.Network/routeTables", apiVersion: "latest", wantErr: "invalid api version"},
+		{resourceType: "Microsoft.Network/routeTables", apiVersion: "2023-09-01-beta", wantErr: "invalid api version"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.resourceType+"="+tt.apiVersion, func(t *testing.T) {
+			cfg := configtest.New(t)
+			cfg.Azure.APIVersionOverrides = map[string]string{tt.resourceType: tt.apiVersion}
+
+			err := cfg.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// Package configtest provides configurations for tests: a valid hub and
+// spoke subscription to start from, and loading configuration files.
+package configtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/akos011221/velora/internal/config"
+)
+
+// The hub and the spoke subscription of New.
+const (
+	HubSubscriptionID = "00000000-0000-0000-0000-000000000001"
+	SubscriptionID    = "00000000-0000-0000-0000-000000000002"
+	HubName           = "hub"
+	HubVNetID         = "/subscriptions/" + HubSubscriptionID + "/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub-vnet"
+	NVANextHop        = "10.0.0.4"
+)
+
+// New returns a valid configuration of the hub and the spoke subscription,
+// requiring NVA routing with routing enforcement and auto-remediation on.
+// The subscription isn't observed first, runs remediate it right away.
+// The mutators change it before it is validated, the test fails if it
+// isn't valid. The state is kept in a temporary directory.
+func New(t testing.TB, mutators ...func(*config.Config)) *config.Config {
+	t.Helper()
+	observeFirst := false
+	cfg := &config.Config{
+		Azure: config.AzureConfig{
+			SubscriptionID:   SubscriptionID,
+			UseAzureIdentity: true,
+		},
+		Hubs: []config.HubVNetConfig{{
+			Name:            HubName,
+			VNetID:          HubVNetID,
+			NVANextHop:      NVANextHop,
+			AddressPrefixes: []string{"10.0.0.0/16"},
+		}},
+		Subscriptions: map[string]config.SubscriptionConfig{
+			SubscriptionID: {
+				HubName:           HubName,
+				RequireNVARouting: true,
+				ObserveFirst:      &observeFirst,
+			},
+		},
+		Features: config.FeaturesConfig{
+			RoutingEnforcement: true,
+			AutoRemediation:    true,
+		},
+		State: config.StateConfig{Path: t.TempDir()},
+	}
+	for _, mutate := range mutators {
+		mutate(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid test configuration: %v", err)
+	}
+	return cfg
+}
+
+// WriteFiles writes the files, by path relative to a temporary directory,
+// and returns the directory.
+func WriteFiles(t testing.TB, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// Load writes the configuration file and loads it with config.LoadConfig.
+// The VELORA_ environment variables of the process still apply.
+func Load(t testing.TB, content string) (*config.Config, error) {
+	t.Helper()
+	dir := WriteFiles(t, map[string]string{"config.json": content})
+	return config.LoadConfig(filepath.Join(dir, "config.json"))
+}
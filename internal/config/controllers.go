@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DefaultControllerOrder runs the controllers a resource depends on first:
+// routes are only remediated once the VNet is peered with its hub, the
+// controllers after peering can hold back a VNet it couldn't peer.
+var DefaultControllerOrder = []string{"peering", "routing", "vwan", "gateways", "flowlogs", "nsg", "egress"}
+
+// EffectiveControllerOrder returns the order of the controllers, those of
+// ControllerOrder first and then the others in DefaultControllerOrder.
+func (c *Config) EffectiveControllerOrder() []string {
+	order := append([]string(nil), c.ControllerOrder...)
+	for _, name := range DefaultControllerOrder {
+		if !slices.Contains(order, name) {
+			order = append(order, name)
+		}
+	}
+	return order
+}
+
+// RunsController reports whether the instance runs the controller.
+func (c *Config) RunsController(name string) bool {
+	return len(c.Controllers) == 0 || slices.Contains(c.Controllers, name)
+}
+
+// Role names the controllers the instance runs in their order, joined by
+// "+", e.g. nsg+egress. It is empty for an instance running them all.
+// Instances of different roles keep their state apart, except the run
+// history and statistics they contribute to together.
+func (c *Config) Role() string {
+	if len(c.Controllers) == 0 {
+		return ""
+	}
+	var role []string
+	for _, name := range c.EffectiveControllerOrder() {
+		if slices.Contains(c.Controllers, name) {
+			role = append(role, name)
+		}
+	}
+	return strings.Join(role, "+")
+}
+
+// SetControllers limits the instance to the comma-separated controllers,
+// e.g. from --controllers. An empty list leaves the config unchanged.
+func (c *Config) SetControllers(list string) error {
+	if list == "" {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		names = append(names, strings.TrimSpace(name))
+	}
+	if err := validateControllers(names, "--controllers"); err != nil {
+		return err
+	}
+	c.Controllers = names
+	return nil
+}
+
+// validateControllers checks the names are known controllers, listed once.
+func validateControllers(names []string, field string) error {
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !slices.Contains(DefaultControllerOrder, name) {
+			return fmt.Errorf("unknown controller %q in %s, allowed values are %s", name, field, strings.Join(DefaultControllerOrder, ", "))
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate controller %q in %s", name, field)
+		}
+		seen[name] = true
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+)
+
+func TestControllerOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		order   []string
+		want    string
+		wantErr string
+	}{
+		{name: "default", want: strings.Join(config.DefaultControllerOrder, ",")},
+		{name: "routing first", order: []string{"routing"}, want: "routing,peering,vwan,gateways,flowlogs,nsg,egress"},
+		{name: "all listed", order: []string{"egress", "nsg", "flowlogs", "gateways", "vwan", "routing", "peering"}, want: "egress,nsg,flowlogs,gateways,vwan,routing,peering"},
+		{name: "unknown controller", order: []string{"ipam"}, wantErr: `unknown controller "ipam"`},
+		{name: "duplicate controller", order: []string{"routing", "routing"}, wantErr: `duplicate controller "routing"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t)
+			cfg.ControllerOrder = tt.order
+			err := cfg.Validate()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if got := strings.Join(cfg.EffectiveControllerOrder(), ","); got != tt.want {
+				t.Errorf("EffectiveControllerOrder() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
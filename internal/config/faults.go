@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// TestingConfig represents settings only meant for non-production environments.
+type TestingConfig struct {
+	FaultInjection *FaultInjectionConfig `json:"faultInjection"`
+}
+
+// FaultInjectionConfig configures injection of ARM faults.
+type FaultInjectionConfig struct {
+	Enabled bool                 `json:"enabled"`
+	Seed    int64                `json:"seed"`
+	Faults  []FaultInjectionRule `json:"faults"`
+}
+
+// FaultInjectionRule describes a fault and the requests it applies to.
+type FaultInjectionRule struct {
+	// ResourcePattern is a regular expression matched against the request path.
+	ResourcePattern string `json:"resourcePattern"`
+	// Method restricts the fault to one HTTP method, e.g. PUT.
+	Method string `json:"method"`
+	// Probability of injecting the fault into a matching request (0-1).
+	Probability float64 `json:"probability"`
+	// NthRequest injects the fault into every nth matching request.
+	NthRequest int `json:"nthRequest"`
+	// StatusCode is the status of the injected response, e.g. 429 or 500.
+	StatusCode int `json:"statusCode"`
+	// Hang blocks the request until its context is cancelled instead of responding.
+	Hang bool `json:"hang"`
+}
+
+// validateFaultInjection checks the injected faults, which production
+// subscriptions never get.
+func (c *Config) validateFaultInjection() error {
+	fi := c.Testing.FaultInjection
+	if fi == nil || !fi.Enabled {
+		return nil
+	}
+	for subID, subCFG := range c.Subscriptions {
+		if subCFG.IsProduction() {
+			return fmt.Errorf("fault injection can't be enabled with production subscription %s", subID)
+		}
+	}
+	for i, fault := range fi.Faults {
+		if _, err := regexp.Compile(fault.ResourcePattern); err != nil {
+			return fmt.Errorf("invalid resourcePattern in fault %d: %w", i, err)
+		}
+		if fault.Probability < 0 || fault.Probability > 1 {
+			return fmt.Errorf("invalid probability in fault %d: %v", i, fault.Probability)
+		}
+		if fault.Probability == 0 && fault.NthRequest <= 0 {
+			return fmt.Errorf("fault %d needs a probability or nthRequest", i)
+		}
+		if !fault.Hang && (fault.StatusCode < 400 || fault.StatusCode > 599) {
+			return fmt.Errorf("fault %d needs an error statusCode or hang", i)
+		}
+	}
+	return nil
+}
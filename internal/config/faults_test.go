@@ -0,0 +1,64 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+)
+
+func TestValidateFaultInjection(t *testing.T) {
+	tests := []struct {
+		name    string
+		sub     func(*config.SubscriptionConfig)
+		fault   config.FaultInjectionRule
+		wantErr string
+	}{
+		{
+			name:  "non-production",
+			fault: config.FaultInjectionRule{ResourcePattern: "routes", Probability: 0.1, StatusCode: 500},
+		},
+		{
+			name:    "production subscription",
+			sub:     func(s *config.SubscriptionConfig) { s.Environment = config.EnvironmentProd },
+			fault:   config.FaultInjectionRule{ResourcePattern: "routes", Probability: 0.1, StatusCode: 500},
+			wantErr: "production subscription",
+		},
+		{
+			name:    "invalid pattern",
+			fault:   config.FaultInjectionRule{ResourcePattern: "(", Probability: 0.1, StatusCode: 500},
+			wantErr: "invalid resourcePattern",
+		},
+		{
+			name:    "never injected",
+			fault:   config.FaultInjectionRule{ResourcePattern: "routes", StatusCode: 500},
+			wantErr: "needs a probability or nthRequest",
+		},
+		{
+			name:    "success status",
+			fault:   config.FaultInjectionRule{ResourcePattern: "routes", NthRequest: 2, StatusCode: 200},
+			wantErr: "needs an error statusCode or hang",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t)
+			if tt.sub != nil {
+				sub := cfg.Subscriptions[configtest.SubscriptionID]
+				tt.sub(&sub)
+				cfg.Subscriptions[configtest.SubscriptionID] = sub
+			}
+			cfg.Testing.FaultInjection = &config.FaultInjectionConfig{Enabled: true, Faults: []config.FaultInjectionRule{tt.fault}}
+
+			err := cfg.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
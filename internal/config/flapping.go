@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Defaults of the flapping detection.
+const (
+	DefaultFlappingMaxRemediations = 3
+	DefaultFlappingWindow          = 24 * time.Hour
+)
+
+// FlappingConfig detects flapping resources: remediated by more than
+// MaxRemediations runs within the window.
+type FlappingConfig struct {
+	// MaxRemediations is how many runs may remediate a resource within
+	// the window, DefaultFlappingMaxRemediations if unset.
+	MaxRemediations int `json:"maxRemediations,omitempty"`
+	// WindowHours is the window, DefaultFlappingWindow if unset.
+	WindowHours int `json:"windowHours,omitempty"`
+	// HoldRemediation stops remediating flapping resources, they are only
+	// reported until their flag is cleared with velora flapping clear.
+	HoldRemediation bool `json:"holdRemediation,omitempty"`
+}
+
+// EffectiveMaxRemediations returns MaxRemediations, or its default.
+func (f *FlappingConfig) EffectiveMaxRemediations() int {
+	if f.MaxRemediations > 0 {
+		return f.MaxRemediations
+	}
+	return DefaultFlappingMaxRemediations
+}
+
+// Window returns the window, DefaultFlappingWindow if unset.
+func (f *FlappingConfig) Window() time.Duration {
+	if f.WindowHours > 0 {
+		return time.Duration(f.WindowHours) * time.Hour
+	}
+	return DefaultFlappingWindow
+}
+
+// validate checks the thresholds.
+func (f *FlappingConfig) validate() error {
+	if f == nil {
+		return nil
+	}
+	if f.MaxRemediations < 0 {
+		return fmt.Errorf("invalid flapping.maxRemediations %d, must not be negative", f.MaxRemediations)
+	}
+	if f.WindowHours < 0 {
+		return fmt.Errorf("invalid flapping.windowHours %d, must not be negative", f.WindowHours)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Hash returns a short, stable hash of the effective configuration, recorded
+// in reports to identify the config that produced them. Secrets are excluded.
+func (c *Config) Hash() string {
+	redacted := *c
+	redacted.Azure.ClientSecret = ""
+	redacted.Plans.SigningKey = ""
+	if redacted.Notifications.Email != nil {
+		email := *redacted.Notifications.Email
+		email.Password = ""
+		redacted.Notifications.Email = &email
+	}
+
+	// json.Marshal sorts map keys, so the output is deterministic
+	data, err := json.Marshal(redacted)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// Redacted returns a copy of the configuration with its secrets replaced.
+func (c *Config) Redacted() Config {
+	redacted := *c
+	if redacted.Azure.ClientSecret != "" {
+		redacted.Azure.ClientSecret = "REDACTED"
+	}
+	if redacted.Plans.SigningKey != "" {
+		redacted.Plans.SigningKey = "REDACTED"
+	}
+	if email := redacted.Notifications.Email; email != nil && email.Password != "" {
+		emailCopy := *email
+		emailCopy.Password = "REDACTED"
+		redacted.Notifications.Email = &emailCopy
+	}
+	return redacted
+}
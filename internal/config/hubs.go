@@ -0,0 +1,606 @@
+package config
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/akos011221/velora/internal/naming"
+)
+
+// Hub types.
+const (
+	HubTypeClassic    = "classic"
+	HubTypeVirtualWAN = "virtualWAN"
+)
+
+// HubVNetConfig represents the configuration for a hub VNet.
+type HubVNetConfig struct {
+	// Type is HubTypeClassic, the default, or HubTypeVirtualWAN.
+	Type       string `json:"type,omitempty"`
+	VNetID     string `json:"vnetId"`
+	Name       string `json:"name"`
+	NVANextHop string `json:"nvaNextHop"`
+	// GatewayTransitRequired means spokes must use the hub's gateways
+	// through their peering (useRemoteGateways).
+	GatewayTransitRequired bool `json:"gatewayTransitRequired"`
+	// HubWriteAccess is false when the hub is owned by another team: velora
+	// then never writes the hub side of peerings, it reports the command the
+	// hub team must run instead. Unset means true.
+	HubWriteAccess *bool `json:"hubWriteAccess,omitempty"`
+	// ManagedRoutePrefix is prepended to the names of routes velora creates,
+	// routes whose name starts with it are considered owned by velora.
+	ManagedRoutePrefix string `json:"managedRoutePrefix"`
+	// DefaultRouteName overrides the name of the managed default route.
+	DefaultRouteName string `json:"defaultRouteName"`
+	// DefaultRoutePrefixes are the prefixes that make up the enforced
+	// default route, DefaultRoutePrefix if unset.
+	DefaultRoutePrefixes []string `json:"defaultRoutePrefixes,omitempty"`
+	// StrictCoverage requires the default route prefixes to cover the
+	// whole 0.0.0.0/0 address space.
+	StrictCoverage bool `json:"strictCoverage,omitempty"`
+	// OverriddenOnPremPrefixes are BGP-learned on-prem prefixes forced
+	// through the NVA in spoke route tables. Routes of prefixes removed
+	// from the list are deleted.
+	OverriddenOnPremPrefixes []string `json:"overriddenOnPremPrefixes,omitempty"`
+	// AddressPrefixes are the address prefixes of the hub VNet, IPv4 or
+	// IPv6. They are read from Azure, setting them is only needed when the
+	// hub can't be read or its address space is split across prefixes
+	// velora must know without reading it.
+	AddressPrefixes []string `json:"addressPrefixes,omitempty"`
+	// ReplaceForeignRoutes allows velora to modify routes it doesn't own.
+	ReplaceForeignRoutes bool `json:"replaceForeignRoutes"`
+	// FailoverHub is the name of the hub taking over during a failover.
+	FailoverHub string `json:"failoverHub"`
+	// NextHopSource resolves the NVA next hop from a resource instead of
+	// using the static NVANextHop.
+	NextHopSource *NextHopSourceConfig `json:"nextHopSource,omitempty"`
+	// BlockOnUnownedNextHop puts the hub's subscriptions in observe mode
+	// when no NIC or load balancer in the hub VNet owns NVANextHop.
+	BlockOnUnownedNextHop bool `json:"blockOnUnownedNextHop,omitempty"`
+	// FallbackNVANextHops are enforced in order instead of NVANextHop while
+	// no forwarding NVA owns it.
+	FallbackNVANextHops []string `json:"fallbackNvaNextHops,omitempty"`
+	// FailbackAfterHealthyChecks is how many runs in a row NVANextHop must
+	// be owned again before routes move back to it,
+	// DefaultFailbackAfterHealthyChecks if unset.
+	FailbackAfterHealthyChecks int `json:"failbackAfterHealthyChecks,omitempty"`
+	// FlowLogs is the flow log every NSG of the hub's spokes must have.
+	FlowLogs *FlowLogsConfig `json:"flowLogs,omitempty"`
+	// SubnetClasses override the routing policy of the spokes' subnets
+	// they match.
+	SubnetClasses []SubnetClassConfig `json:"subnetClasses,omitempty"`
+	// VirtualWAN configures a virtual WAN hub, only for HubTypeVirtualWAN.
+	VirtualWAN *VirtualWANConfig `json:"virtualWan,omitempty"`
+	// Capacity is what the hub was designed for, nil if unchecked.
+	Capacity *HubCapacityConfig `json:"capacity,omitempty"`
+}
+
+// HubCapacityConfig is the design capacity of a hub. Spokes are the hub's
+// peerings with VNets other than hubs.
+type HubCapacityConfig struct {
+	// MaxSpokes is the number of spokes the hub was designed for, 0 for
+	// no limit.
+	MaxSpokes int `json:"maxSpokes,omitempty"`
+	// MaxTotalSpokePrefixes is the number of address prefixes of all its
+	// spokes together the hub was designed for, 0 for no limit.
+	MaxTotalSpokePrefixes int `json:"maxTotalSpokePrefixes,omitempty"`
+	// WarnUtilization is the fraction of a limit above which velora warns,
+	// limits.warnUtilization if unset.
+	WarnUtilization float64 `json:"warnUtilization,omitempty"`
+	// RefuseAtCapacity makes the peering controller refuse new spokes once
+	// a limit is reached, reporting the hub at capacity instead.
+	RefuseAtCapacity bool `json:"refuseAtCapacity,omitempty"`
+}
+
+// EffectiveWarnUtilization returns WarnUtilization, defaultWarn if unset.
+func (h *HubCapacityConfig) EffectiveWarnUtilization(defaultWarn float64) float64 {
+	if h.WarnUtilization > 0 {
+		return h.WarnUtilization
+	}
+	return defaultWarn
+}
+
+// DefaultHubRouteTable is the route table every virtual hub has.
+const DefaultHubRouteTable = "defaultRouteTable"
+
+// VirtualWANConfig is the routing of a virtual WAN hub.
+type VirtualWANConfig struct {
+	VirtualHubID string `json:"virtualHubId"`
+	// AssociatedRouteTable is the name of the hub route table spoke
+	// connections must be associated with, DefaultHubRouteTable if unset.
+	AssociatedRouteTable string `json:"associatedRouteTable"`
+	// NextHopID is the resource ID of the firewall or NVA the default route
+	// of the default route table must point to.
+	NextHopID string `json:"nextHopId"`
+}
+
+// EffectiveAssociatedRouteTable returns the associated route table, with the default applied.
+func (v *VirtualWANConfig) EffectiveAssociatedRouteTable() string {
+	if v.AssociatedRouteTable == "" {
+		return DefaultHubRouteTable
+	}
+	return v.AssociatedRouteTable
+}
+
+// DefaultFailbackAfterHealthyChecks is how many runs in a row the primary
+// next hop of a hub must be owned again before routes move back to it.
+const DefaultFailbackAfterHealthyChecks = 3
+
+// EffectiveFailbackAfterHealthyChecks returns FailbackAfterHealthyChecks,
+// with the default applied.
+func (h *HubVNetConfig) EffectiveFailbackAfterHealthyChecks() int {
+	if h.FailbackAfterHealthyChecks > 0 {
+		return h.FailbackAfterHealthyChecks
+	}
+	return DefaultFailbackAfterHealthyChecks
+}
+
+// IsVirtualWAN reports whether the hub is a virtual WAN hub.
+func (h *HubVNetConfig) IsVirtualWAN() bool {
+	return h.Type == HubTypeVirtualWAN
+}
+
+// WritesHubSide reports whether velora may write the hub side of peerings.
+func (h *HubVNetConfig) WritesHubSide() bool {
+	return h.HubWriteAccess == nil || *h.HubWriteAccess
+}
+
+// classicFields returns the classic hub fields that are set.
+func (h *HubVNetConfig) classicFields() []string {
+	var set []string
+	if h.VNetID != "" {
+		set = append(set, "vnetId")
+	}
+	if h.NVANextHop != "" {
+		set = append(set, "nvaNextHop")
+	}
+	if h.NextHopSource != nil {
+		set = append(set, "nextHopSource")
+	}
+	if h.BlockOnUnownedNextHop {
+		set = append(set, "blockOnUnownedNextHop")
+	}
+	if len(h.FallbackNVANextHops) > 0 {
+		set = append(set, "fallbackNvaNextHops")
+	}
+	if h.FailbackAfterHealthyChecks != 0 {
+		set = append(set, "failbackAfterHealthyChecks")
+	}
+	if h.GatewayTransitRequired {
+		set = append(set, "gatewayTransitRequired")
+	}
+	if h.HubWriteAccess != nil {
+		set = append(set, "hubWriteAccess")
+	}
+	if h.FlowLogs != nil {
+		set = append(set, "flowLogs")
+	}
+	if h.FailoverHub != "" {
+		set = append(set, "failoverHub")
+	}
+	if len(h.DefaultRoutePrefixes) > 0 || h.StrictCoverage {
+		set = append(set, "defaultRoutePrefixes")
+	}
+	if len(h.OverriddenOnPremPrefixes) > 0 {
+		set = append(set, "overriddenOnPremPrefixes")
+	}
+	if len(h.AddressPrefixes) > 0 {
+		set = append(set, "addressPrefixes")
+	}
+	if len(h.SubnetClasses) > 0 {
+		set = append(set, "subnetClasses")
+	}
+	if h.Capacity != nil {
+		set = append(set, "capacity")
+	}
+	return set
+}
+
+// FlowLogsConfig is the flow log template of a hub.
+type FlowLogsConfig struct {
+	// StorageAccountID may be in any subscription.
+	StorageAccountID string `json:"storageAccountId"`
+	RetentionDays    int32  `json:"retentionDays"`
+	// TrafficAnalytics is optional, it is required and checked if set.
+	TrafficAnalytics *TrafficAnalyticsConfig `json:"trafficAnalytics,omitempty"`
+}
+
+// TrafficAnalyticsConfig is the Log Analytics workspace of traffic analytics.
+type TrafficAnalyticsConfig struct {
+	WorkspaceResourceID string `json:"workspaceResourceId"`
+	// WorkspaceID is the workspace GUID.
+	WorkspaceID     string `json:"workspaceId"`
+	WorkspaceRegion string `json:"workspaceRegion"`
+	// IntervalMinutes is 10 or 60, 60 if unset.
+	IntervalMinutes int32 `json:"intervalMinutes"`
+}
+
+// NextHopSourceConfig selects where the next hop of a hub is read from.
+type NextHopSourceConfig struct {
+	// AzureFirewallID is the resource ID of an Azure Firewall whose private
+	// IP is the next hop, resolved at the start of every run.
+	AzureFirewallID string `json:"azureFirewallId"`
+}
+
+// DefaultRoutePrefix is the default route enforced unless configured otherwise.
+const DefaultRoutePrefix = "0.0.0.0/0"
+
+// validatePrefixes checks the prefixes are distinct IPv4
+// networks and, with strict coverage, that together they cover 0.0.0.0/0.
+func validatePrefixes(prefixes []string, strict bool) error {
+	type span struct{ first, last uint64 }
+	var spans []span
+	seen := make(map[string]bool)
+	for _, prefix := range prefixes {
+		ip, network, err := net.ParseCIDR(prefix)
+		if err != nil || ip.To4() == nil {
+			return fmt.Errorf("invalid IPv4 prefix %q", prefix)
+		}
+		if network.String() != prefix {
+			return fmt.Errorf("prefix %q is not a network address, did you mean %s", prefix, network)
+		}
+		if seen[prefix] {
+			return fmt.Errorf("duplicate prefix %s", prefix)
+		}
+		seen[prefix] = true
+
+		ones, _ := network.Mask.Size()
+		first := uint64(binary.BigEndian.Uint32(network.IP.To4()))
+		spans = append(spans, span{first, first + 1<<(32-ones) - 1})
+	}
+	if !strict {
+		return nil
+	}
+
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].first < spans[j].first
+	})
+	var next uint64
+	for _, sp := range spans {
+		if sp.first > next {
+			break
+		}
+		next = max(next, sp.last+1)
+	}
+	if next <= 1<<32-1 {
+		return fmt.Errorf("prefixes %s don't cover %s, %s is not routed to the NVA",
+			strings.Join(prefixes, ", "), DefaultRoutePrefix, net.IP(binary.BigEndian.AppendUint32(nil, uint32(next))))
+	}
+	return nil
+}
+
+// validateAddressPrefixes checks the prefixes are IPv4 or IPv6 network
+// addresses that don't overlap each other.
+func validateAddressPrefixes(prefixes []string) error {
+	for i, prefix := range prefixes {
+		_, network, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return fmt.Errorf("invalid prefix %q", prefix)
+		}
+		if network.String() != prefix {
+			return fmt.Errorf("prefix %q is not a network address, did you mean %s", prefix, network)
+		}
+		for _, other := range prefixes[:i] {
+			if prefixesOverlap(prefix, other) {
+				return fmt.Errorf("prefixes %s and %s overlap", other, prefix)
+			}
+		}
+	}
+	return nil
+}
+
+// insidePrefixes reports whether the IP is inside one of the prefixes.
+func insidePrefixes(ip string, prefixes []string) bool {
+	parsed := net.ParseIP(ip)
+	for _, prefix := range prefixes {
+		if _, network, err := net.ParseCIDR(prefix); err == nil && parsed != nil && network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixesOverlap reports whether two CIDR prefixes share any address.
+func prefixesOverlap(a, b string) bool {
+	_, na, errA := net.ParseCIDR(a)
+	_, nb, errB := net.ParseCIDR(b)
+	return errA == nil && errB == nil && (na.Contains(nb.IP) || nb.Contains(na.IP))
+}
+
+// Base names of the managed routes, after the prefix.
+const (
+	defaultRouteBaseName   = "DefaultRoute-To-NVA"
+	isolationRouteBaseName = "Route-To-"
+	onPremRouteBaseName    = "OnPrem-"
+)
+
+// EffectiveDefaultRouteName returns the name of the managed default route.
+func (h *HubVNetConfig) EffectiveDefaultRouteName() string {
+	if h.DefaultRouteName != "" {
+		return h.DefaultRouteName
+	}
+	return h.ManagedRoutePrefix + defaultRouteBaseName
+}
+
+// DefaultRouteNameFor returns the name of the managed route for a prefix of
+// the default route. DefaultRoutePrefix keeps the plain default route name.
+func (h *HubVNetConfig) DefaultRouteNameFor(prefix string) (string, error) {
+	if prefix == DefaultRoutePrefix {
+		return h.EffectiveDefaultRouteName(), nil
+	}
+	return naming.ResourceName(h.EffectiveDefaultRouteName() + "-" + strings.NewReplacer(".", "-", "/", "-").Replace(prefix))
+}
+
+// OnPremRouteName returns the name of the managed route overriding an
+// on-prem prefix.
+func (h *HubVNetConfig) OnPremRouteName(prefix string) (string, error) {
+	return naming.ResourceName(h.ManagedRoutePrefix + onPremRouteBaseName + strings.NewReplacer(".", "-", "/", "-").Replace(prefix))
+}
+
+// IsOnPremRoute reports whether the route is a managed on-prem override,
+// whatever its prefix.
+func (h *HubVNetConfig) IsOnPremRoute(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), strings.ToLower(h.ManagedRoutePrefix+onPremRouteBaseName))
+}
+
+// CompatRouteName returns the name of the managed route of a service tag
+// required by a compatibility profile.
+func (h *HubVNetConfig) CompatRouteName(serviceTag string) (string, error) {
+	return naming.ResourceName(h.EffectiveDefaultRouteName() + "-" + strings.ToLower(serviceTag))
+}
+
+// IsolationRouteName returns the name of the managed route to a subnet.
+func (h *HubVNetConfig) IsolationRouteName(subnet string) (string, error) {
+	return naming.ResourceName(h.ManagedRoutePrefix + isolationRouteBaseName + subnet)
+}
+
+// OwnsRoute reports whether velora owns a route, i.e. may modify or delete
+// it. expectedName is the name velora would give the route, if known.
+func (h *HubVNetConfig) OwnsRoute(name, expectedName string) bool {
+	if strings.EqualFold(name, expectedName) || strings.EqualFold(name, h.EffectiveDefaultRouteName()) {
+		return true
+	}
+	lower := strings.ToLower(name)
+	if strings.HasPrefix(lower, strings.ToLower(h.EffectiveDefaultRouteName()+"-")) || h.IsOnPremRoute(name) {
+		return true
+	}
+	if strings.HasPrefix(lower, strings.ToLower(h.ManagedRoutePrefix+isolationRouteBaseName)) {
+		return true
+	}
+	return h.ManagedRoutePrefix != "" && strings.HasPrefix(lower, strings.ToLower(h.ManagedRoutePrefix))
+}
+
+// Tags of the hub VNets read by hub discovery.
+const (
+	// DefaultHubTag marks a hub VNet with the value "true".
+	DefaultHubTag = "velora-hub"
+	// HubTagNVAIP is the NVA next hop of the hub.
+	HubTagNVAIP = "velora-nva-ip"
+	// HubTagName is the name of the hub, the VNet name if unset.
+	HubTagName = "velora-hub-name"
+	// HubTagRoutePrefix is the managedRoutePrefix of the hub.
+	HubTagRoutePrefix = "velora-route-prefix"
+	// HubTagDefaultRouteName is the defaultRouteName of the hub.
+	HubTagDefaultRouteName = "velora-default-route-name"
+)
+
+// HubDiscoveryConfig builds hubs from the tags of the hub VNets in the
+// connectivity subscriptions, instead of repeating them in the
+// configuration. Statically configured hubs win over discovered ones.
+type HubDiscoveryConfig struct {
+	Enabled bool `json:"enabled"`
+	// Subscriptions are the connectivity subscriptions searched for hub VNets.
+	Subscriptions []string `json:"subscriptions"`
+	// Tag marks the hub VNets with the value "true", DefaultHubTag if unset.
+	Tag string `json:"tag,omitempty"`
+}
+
+// IsEnabled reports whether hubs are discovered, it is false for a nil config.
+func (d *HubDiscoveryConfig) IsEnabled() bool {
+	return d != nil && d.Enabled
+}
+
+// EffectiveTag returns the tag marking hub VNets, DefaultHubTag if unset.
+func (d *HubDiscoveryConfig) EffectiveTag() string {
+	if d.Tag == "" {
+		return DefaultHubTag
+	}
+	return d.Tag
+}
+
+// validate checks the hub discovery configuration.
+func (d *HubDiscoveryConfig) validate() error {
+	if !d.Enabled {
+		return nil
+	}
+	if len(d.Subscriptions) == 0 {
+		return fmt.Errorf("hubDiscovery.subscriptions is required when hub discovery is enabled")
+	}
+	for _, subID := range d.Subscriptions {
+		if subID == "" {
+			return fmt.Errorf("hubDiscovery.subscriptions must not contain empty IDs")
+		}
+	}
+	return nil
+}
+
+// validateHubs checks the hubs and hub discovery, the hubs may all be
+// discovered at run time.
+func (c *Config) validateHubs() error {
+	if c.HubDiscovery != nil {
+		if err := c.HubDiscovery.validate(); err != nil {
+			return err
+		}
+	}
+	if len(c.Hubs) == 0 && !c.HubDiscovery.IsEnabled() {
+		return fmt.Errorf("at least one hub configuration is required")
+	}
+	for i := range c.Hubs {
+		if err := c.Hubs[i].validate(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks the configuration of the hub, c is the configuration it
+// belongs to.
+func (h *HubVNetConfig) validate(c *Config) error {
+	// validate the NVA IP
+	if h.NVANextHop != "" && net.ParseIP(h.NVANextHop) == nil {
+		return fmt.Errorf("invalid NVA IP: %s", h.NVANextHop)
+	}
+
+	// validate the address prefixes, the NVAs must be inside one of them
+	if err := validateAddressPrefixes(h.AddressPrefixes); err != nil {
+		return fmt.Errorf("invalid addressPrefixes for hub %s: %w", h.Name, err)
+	}
+	if len(h.AddressPrefixes) > 0 {
+		for _, ip := range append([]string{h.NVANextHop}, h.FallbackNVANextHops...) {
+			if ip != "" && !insidePrefixes(ip, h.AddressPrefixes) {
+				return fmt.Errorf("NVA IP %s of hub %s is outside its addressPrefixes %s", ip, h.Name, strings.Join(h.AddressPrefixes, ", "))
+			}
+		}
+		for _, prefix := range h.OverriddenOnPremPrefixes {
+			for _, hubPrefix := range h.AddressPrefixes {
+				if prefixesOverlap(prefix, hubPrefix) {
+					return fmt.Errorf("overridden on-prem prefix %s of hub %s overlaps its address prefix %s", prefix, h.Name, hubPrefix)
+				}
+			}
+		}
+	}
+
+	// validate fallback next hops, they replace a static next hop only
+	if len(h.FallbackNVANextHops) > 0 || h.FailbackAfterHealthyChecks != 0 {
+		if h.NVANextHop == "" {
+			return fmt.Errorf("hub %s sets fallbackNvaNextHops without nvaNextHop", h.Name)
+		}
+		if h.FailbackAfterHealthyChecks < 0 {
+			return fmt.Errorf("invalid failbackAfterHealthyChecks for hub %s: %d", h.Name, h.FailbackAfterHealthyChecks)
+		}
+		seen := map[string]bool{h.NVANextHop: true}
+		for _, ip := range h.FallbackNVANextHops {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("invalid fallback NVA IP for hub %s: %s", h.Name, ip)
+			}
+			if seen[ip] {
+				return fmt.Errorf("duplicate NVA IP for hub %s: %s", h.Name, ip)
+			}
+			seen[ip] = true
+		}
+	}
+
+	// validate the next hop source
+	if h.NextHopSource != nil {
+		if h.NVANextHop != "" {
+			return fmt.Errorf("hub %s sets both nvaNextHop and nextHopSource", h.Name)
+		}
+		if !strings.Contains(strings.ToLower(h.NextHopSource.AzureFirewallID), "/providers/microsoft.network/azurefirewalls/") {
+			return fmt.Errorf("invalid nextHopSource.azureFirewallId for hub %s: %q", h.Name, h.NextHopSource.AzureFirewallID)
+		}
+	}
+
+	// validate the flow log template
+	if fl := h.FlowLogs; fl != nil {
+		if !strings.Contains(strings.ToLower(fl.StorageAccountID), "/providers/microsoft.storage/storageaccounts/") {
+			return fmt.Errorf("invalid flowLogs.storageAccountId for hub %s: %q", h.Name, fl.StorageAccountID)
+		}
+		if fl.RetentionDays < 0 || fl.RetentionDays > 365 {
+			return fmt.Errorf("invalid flowLogs.retentionDays for hub %s: %d, must be between 0 and 365", h.Name, fl.RetentionDays)
+		}
+		if ta := fl.TrafficAnalytics; ta != nil {
+			if ta.WorkspaceResourceID == "" || ta.WorkspaceID == "" || ta.WorkspaceRegion == "" {
+				return fmt.Errorf("flowLogs.trafficAnalytics of hub %s requires workspaceResourceId, workspaceId and workspaceRegion", h.Name)
+			}
+			if ta.IntervalMinutes != 0 && ta.IntervalMinutes != 10 && ta.IntervalMinutes != 60 {
+				return fmt.Errorf("invalid flowLogs.trafficAnalytics.intervalMinutes for hub %s: %d, allowed values are 10, 60", h.Name, ta.IntervalMinutes)
+			}
+		}
+	}
+
+	if err := h.validateSubnetClasses(); err != nil {
+		return err
+	}
+
+	// validate the capacity
+	if capacity := h.Capacity; capacity != nil {
+		if capacity.MaxSpokes < 0 || capacity.MaxTotalSpokePrefixes < 0 {
+			return fmt.Errorf("capacity limits of hub %s must not be negative", h.Name)
+		}
+		if capacity.MaxSpokes == 0 && capacity.MaxTotalSpokePrefixes == 0 {
+			return fmt.Errorf("capacity of hub %s requires maxSpokes or maxTotalSpokePrefixes", h.Name)
+		}
+		if w := capacity.WarnUtilization; w < 0 || w > 1 {
+			return fmt.Errorf("capacity.warnUtilization of hub %s must be between 0 and 1", h.Name)
+		}
+	}
+
+	// validate the type, virtual WAN and classic fields can't be mixed
+	switch h.Type {
+	case "", HubTypeClassic:
+		if h.VirtualWAN != nil {
+			return fmt.Errorf("hub %s sets virtualWan but isn't of type %s", h.Name, HubTypeVirtualWAN)
+		}
+	case HubTypeVirtualWAN:
+		if set := h.classicFields(); len(set) > 0 {
+			return fmt.Errorf("virtual WAN hub %s sets classic hub fields: %s", h.Name, strings.Join(set, ", "))
+		}
+		vwan := h.VirtualWAN
+		if vwan == nil {
+			return fmt.Errorf("virtual WAN hub %s requires virtualWan", h.Name)
+		}
+		if !strings.Contains(strings.ToLower(vwan.VirtualHubID), "/providers/microsoft.network/virtualhubs/") {
+			return fmt.Errorf("invalid virtualWan.virtualHubId for hub %s: %q", h.Name, vwan.VirtualHubID)
+		}
+		if vwan.NextHopID == "" {
+			return fmt.Errorf("virtualWan.nextHopId is required for hub %s", h.Name)
+		}
+	default:
+		return fmt.Errorf("unknown type %q of hub %s, allowed values are %s, %s", h.Type, h.Name, HubTypeClassic, HubTypeVirtualWAN)
+	}
+
+	// validate the failover hub
+	if h.FailoverHub != "" {
+		if h.FailoverHub == h.Name {
+			return fmt.Errorf("hub %s can't fail over to itself", h.Name)
+		}
+		failoverHub := c.Hub(h.FailoverHub)
+		if failoverHub == nil {
+			return fmt.Errorf("failoverHub %s of hub %s is not defined", h.FailoverHub, h.Name)
+		}
+		if failoverHub.IsVirtualWAN() {
+			return fmt.Errorf("hub %s can't fail over to virtual WAN hub %s", h.Name, failoverHub.Name)
+		}
+	}
+
+	// validate the managed route names
+	if err := naming.ValidateTemplate(h.ManagedRoutePrefix + "{name}"); err != nil {
+		return fmt.Errorf("invalid managedRoutePrefix for hub %s: %w", h.Name, err)
+	}
+	if err := naming.Validate(h.EffectiveDefaultRouteName()); err != nil {
+		return fmt.Errorf("invalid defaultRouteName for hub %s: %w", h.Name, err)
+	}
+
+	// validate the default route and overridden on-prem prefixes
+	if err := validatePrefixes(h.DefaultRoutePrefixes, h.StrictCoverage); err != nil {
+		return fmt.Errorf("invalid defaultRoutePrefixes for hub %s: %w", h.Name, err)
+	}
+	for _, prefix := range h.DefaultRoutePrefixes {
+		if _, err := h.DefaultRouteNameFor(prefix); err != nil {
+			return fmt.Errorf("invalid default route name for prefix %s of hub %s: %w", prefix, h.Name, err)
+		}
+	}
+	if err := validatePrefixes(h.OverriddenOnPremPrefixes, false); err != nil {
+		return fmt.Errorf("invalid overriddenOnPremPrefixes for hub %s: %w", h.Name, err)
+	}
+	for _, prefix := range h.OverriddenOnPremPrefixes {
+		if prefix == DefaultRoutePrefix || slices.Contains(h.DefaultRoutePrefixes, prefix) {
+			return fmt.Errorf("overridden on-prem prefix %s of hub %s is a default route prefix", prefix, h.Name)
+		}
+	}
+	return nil
+}
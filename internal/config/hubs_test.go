@@ -0,0 +1,150 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+)
+
+func TestWithDiscoveredHubs(t *testing.T) {
+	const discoveredVNetID = "/subscriptions/" + configtest.HubSubscriptionID + "/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/east"
+	discovered := func(name, vnetID, nvaIP string) config.HubVNetConfig {
+		return config.HubVNetConfig{Name: name, VNetID: vnetID, NVANextHop: nvaIP}
+	}
+	tests := []struct {
+		name string
+		hubs []config.HubVNetConfig
+		// unrelated breaks a field of the static configuration unrelated to
+		// hubs, the discovered hubs are still checked on their own
+		unrelated    bool
+		wantHubs     []string
+		wantRejected map[string]string
+		wantWarning  string
+	}{
+		{
+			name:     "new hub",
+			hubs:     []config.HubVNetConfig{discovered("east", discoveredVNetID, "10.10.0.4")},
+			wantHubs: []string{configtest.HubName, "east"},
+		},
+		{
+			name:        "name of a static hub",
+			hubs:        []config.HubVNetConfig{discovered(configtest.HubName, discoveredVNetID, "10.10.0.4")},
+			wantHubs:    []string{configtest.HubName},
+			wantWarning: "hub " + configtest.HubName + " is configured statically",
+		},
+		{
+			name:        "VNet of a static hub",
+			hubs:        []config.HubVNetConfig{discovered("east", strings.ToUpper(configtest.HubVNetID), "10.10.0.4")},
+			wantHubs:    []string{configtest.HubName},
+			wantWarning: "is configured statically",
+		},
+		{
+			name:         "invalid NVA IP",
+			hubs:         []config.HubVNetConfig{discovered("east", discoveredVNetID, "10.10.0.400")},
+			wantHubs:     []string{configtest.HubName},
+			wantRejected: map[string]string{discoveredVNetID: "invalid NVA IP"},
+		},
+		{
+			name: "name discovered twice",
+			hubs: []config.HubVNetConfig{
+				discovered("east", discoveredVNetID, "10.10.0.4"),
+				discovered("east", discoveredVNetID+"-2", "10.11.0.4"),
+			},
+			wantHubs:     []string{configtest.HubName, "east"},
+			wantRejected: map[string]string{discoveredVNetID + "-2": "more than one VNet"},
+		},
+		{
+			name:      "static configuration invalid elsewhere",
+			hubs:      []config.HubVNetConfig{discovered("east", discoveredVNetID, "10.10.0.4")},
+			unrelated: true,
+			wantHubs:  []string{configtest.HubName, "east"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t)
+			if tt.unrelated {
+				cfg.Limits.WarnUtilization = 2
+			}
+
+			merged, rejected, warnings := cfg.WithDiscoveredHubs(tt.hubs)
+			var names []string
+			for _, hub := range merged.Hubs {
+				names = append(names, hub.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantHubs, ",") {
+				t.Errorf("hubs = %v, want %v", names, tt.wantHubs)
+			}
+			if len(rejected) != len(tt.wantRejected) {
+				t.Errorf("rejected = %v, want %v", rejected, tt.wantRejected)
+			}
+			for vnetID, want := range tt.wantRejected {
+				if err := rejected[vnetID]; err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("rejected[%s] = %v, want %q", vnetID, err, want)
+				}
+			}
+			if got := strings.Join(warnings, "\n"); (tt.wantWarning == "") != (got == "") || !strings.Contains(got, tt.wantWarning) {
+				t.Errorf("warnings = %q, want %q", got, tt.wantWarning)
+			}
+			if len(cfg.Hubs) != 1 {
+				t.Errorf("static hubs = %d, want the configuration unchanged", len(cfg.Hubs))
+			}
+			if hub := merged.Hub("east"); hub != nil && merged.Sources.Hubs["east"] != "discovered from "+hub.VNetID {
+				t.Errorf("source of the discovered hub = %q", merged.Sources.Hubs["east"])
+			}
+		})
+	}
+}
+
+func TestValidateHubAddressPrefixes(t *testing.T) {
+	tests := []struct {
+		name      string
+		prefixes  []string
+		nextHop   string
+		fallbacks []string
+		overrides []string
+		wantErr   string
+	}{
+		{name: "single prefix", prefixes: []string{"10.0.0.0/16"}},
+		{name: "dual prefix", prefixes: []string{"10.0.0.0/16", "10.100.0.0/24"}},
+		{name: "NVA in the second prefix", prefixes: []string{"10.100.0.0/24", "10.0.0.0/24"}, nextHop: "10.0.0.4"},
+		{name: "mixed IPv4 and IPv6", prefixes: []string{"10.0.0.0/16", "fd00:db8::/48"}},
+		{name: "IPv6 NVA", prefixes: []string{"10.1.0.0/16", "fd00:db8::/48"}, nextHop: "fd00:db8::4"},
+		{name: "fallback in the second prefix", prefixes: []string{"10.0.0.0/24", "10.100.0.0/24"}, fallbacks: []string{"10.100.0.5"}},
+		{name: "override outside the hub", prefixes: []string{"10.0.0.0/16", "10.100.0.0/24"}, overrides: []string{"192.168.0.0/16"}},
+		{name: "invalid prefix", prefixes: []string{"10.0.0.0/16", "10.100.0.0/33"}, wantErr: `invalid prefix "10.100.0.0/33"`},
+		{name: "not a network address", prefixes: []string{"10.0.0.4/16"}, wantErr: "did you mean 10.0.0.0/16"},
+		{name: "overlapping prefixes", prefixes: []string{"10.0.0.0/16", "10.0.128.0/17"}, wantErr: "prefixes 10.0.0.0/16 and 10.0.128.0/17 overlap"},
+		{name: "overlapping IPv6 prefixes", prefixes: []string{"10.0.0.0/16", "fd00:db8::/48", "fd00:db8:0:1::/64"}, wantErr: "overlap"},
+		{name: "NVA outside every prefix", prefixes: []string{"10.100.0.0/24", "10.101.0.0/24"}, wantErr: "NVA IP 10.0.0.4 of hub hub is outside its addressPrefixes"},
+		{name: "fallback outside every prefix", prefixes: []string{"10.0.0.0/24"}, fallbacks: []string{"10.100.0.5"}, wantErr: "NVA IP 10.100.0.5"},
+		{name: "override overlapping the second prefix", prefixes: []string{"10.0.0.0/16", "10.100.0.0/24"}, overrides: []string{"10.100.0.0/16"}, wantErr: "overlaps its address prefix 10.100.0.0/24"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t)
+			hub := &cfg.Hubs[0]
+			hub.AddressPrefixes = tt.prefixes
+			if tt.nextHop != "" {
+				hub.NVANextHop = tt.nextHop
+			}
+			hub.FallbackNVANextHops = tt.fallbacks
+			hub.OverriddenOnPremPrefixes = tt.overrides
+
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package config
+
+import "fmt"
+
+// DefaultMaxCachedResources bounds the resources the run inventory holds in
+// memory, about 1 GiB for VNets with a dozen subnets and peerings each.
+const DefaultMaxCachedResources = 200000
+
+// InventoryConfig represents the memory budget of the run inventory, which
+// shares the listed VNets and route tables of a subscription between the
+// controllers of a run.
+type InventoryConfig struct {
+	// MaxCachedResources is the number of VNets, subnets, peerings, route
+	// tables and routes held at once. Subscriptions used least recently are
+	// dropped first and listed again when needed.
+	MaxCachedResources int `json:"maxCachedResources"`
+}
+
+// EffectiveMaxCachedResources returns the budget, DefaultMaxCachedResources if unset.
+func (i *InventoryConfig) EffectiveMaxCachedResources() int {
+	if i.MaxCachedResources > 0 {
+		return i.MaxCachedResources
+	}
+	return DefaultMaxCachedResources
+}
+
+// validate checks the cache size.
+func (i *InventoryConfig) validate() error {
+	if i.MaxCachedResources < 0 {
+		return fmt.Errorf("inventory.maxCachedResources must not be negative")
+	}
+	return nil
+}
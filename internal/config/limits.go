@@ -0,0 +1,52 @@
+package config
+
+import "fmt"
+
+// Azure networking limits velora tracks.
+const (
+	LimitPeeringsPerVNet      = "peeringsPerVNet"
+	LimitRoutesPerRouteTable  = "routesPerRouteTable"
+	LimitSecurityRulesPerNSG  = "securityRulesPerNSG"
+	LimitVNetsPerRegion       = "vnetsPerRegion"
+	LimitRouteTablesPerRegion = "routeTablesPerRegion"
+	LimitNSGsPerRegion        = "nsgsPerRegion"
+)
+
+// DefaultLimits are the default Azure limits by name. Per-region limits are
+// reported by the network usages API, which takes precedence over them.
+var DefaultLimits = map[string]int{
+	LimitPeeringsPerVNet:      500,
+	LimitRoutesPerRouteTable:  400,
+	LimitSecurityRulesPerNSG:  1000,
+	LimitVNetsPerRegion:       1000,
+	LimitRouteTablesPerRegion: 200,
+	LimitNSGsPerRegion:        5000,
+}
+
+// DefaultWarnUtilization is the utilization of a limit above which it's
+// reported.
+const DefaultWarnUtilization = 0.8
+
+// LimitsConfig represents the checks of the Azure limits.
+type LimitsConfig struct {
+	// WarnUtilization is the fraction of a limit above which velora limits
+	// warns, unset uses DefaultWarnUtilization.
+	WarnUtilization float64 `json:"warnUtilization"`
+}
+
+// EffectiveWarnUtilization returns the warning threshold,
+// DefaultWarnUtilization if unset.
+func (l *LimitsConfig) EffectiveWarnUtilization() float64 {
+	if l.WarnUtilization > 0 {
+		return l.WarnUtilization
+	}
+	return DefaultWarnUtilization
+}
+
+// validate checks the warning utilization.
+func (l *LimitsConfig) validate() error {
+	if w := l.WarnUtilization; w < 0 || w > 1 {
+		return fmt.Errorf("limits.warnUtilization must be between 0 and 1")
+	}
+	return nil
+}
@@ -2,10 +2,19 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/akos011221/velora/internal/redact"
 )
 
 const (
@@ -13,50 +22,395 @@ const (
 	ConfigEnvVar = "VELORA_CONFIG"
 	// DefaultConfigPath is the default path to the configuration file
 	DefaultConfigPath = "~/.velora/config.json"
+	// DefaultStatePath is the default directory of the state store
+	DefaultStatePath = "~/.velora/state"
 	// EnvPrefix is the prefix for environment variables that override config
 	EnvPrefix = "VELORA_"
+	// StdinPath is the configuration path reading the configuration from
+	// stdin, its includes are relative to the working directory
+	StdinPath = "-"
 )
 
-// LoadConfig loads the configuration from the specified path or environment variable.
+// LoadConfig loads the configuration from the specified path or environment
+// variable, StdinPath reads it from stdin. If the default file doesn't exist
+// and the Azure settings are all set by environment variables, the
+// configuration is built from the environment variables alone.
 func LoadConfig(path string) (*Config, error) {
-	if path == "" {
-		path = os.Getenv(ConfigEnvVar)
-		if path == "" {
-			path = DefaultConfigPath
+	path, checked := locateConfig(path)
+	var cfg *Config
+	fromEnv := false
+	if path != StdinPath {
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			if checked[len(checked)-1].Source != defaultSource || !envConfigured() {
+				return nil, &MissingConfigError{Path: path, Checked: checked, err: err}
+			}
+			cfg = &Config{Sources: Sources{Hubs: make(map[string]string), Subscriptions: make(map[string]string)}}
+			fromEnv = true
 		}
 	}
-
-	cfg, err := loadFromFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load config from file: %w", err)
+	if cfg == nil {
+		var err error
+		if cfg, err = loadFromFile(path); err != nil {
+			return nil, fmt.Errorf("failed to load config from file: %w", err)
+		}
 	}
 
 	if err := overrideFromEnv(cfg); err != nil {
 		return nil, fmt.Errorf("failed to apply environment overrides: %w", err)
 	}
+	cfg.registerSecrets()
 
 	if err := cfg.Validate(); err != nil {
+		if fromEnv {
+			return nil, fmt.Errorf("configuration of the environment variables failed validation, %s doesn't exist: %w", path, err)
+		}
 		return nil, fmt.Errorf("configuration validation failed: %w", err)
 	}
 
 	return cfg, nil
 }
 
-// loadFromFile loads configuration from a JSON file
+// ConfigLocation is where the configuration file is looked up.
+type ConfigLocation struct {
+	// Source is the --config flag, the VELORA_CONFIG variable or the default.
+	Source string
+	// Path is the expanded path, empty if the source isn't set.
+	Path string
+}
+
+// locateConfig returns the path of the configuration file: path if set,
+// else the one of the environment variable, else the default, and the
+// locations checked in order.
+func locateConfig(path string) (string, []ConfigLocation) {
+	checked := []ConfigLocation{{Source: "--config flag", Path: path}}
+	if path != "" {
+		return path, checked
+	}
+	if path = os.Getenv(ConfigEnvVar); path != "" {
+		if expanded, err := expandPath(path); err == nil {
+			path = expanded
+		}
+		return path, append(checked, ConfigLocation{Source: ConfigEnvVar, Path: path})
+	}
+	checked = append(checked, ConfigLocation{Source: ConfigEnvVar})
+	path = DefaultConfigPath
+	if expanded, err := expandPath(path); err == nil {
+		path = expanded
+	}
+	return path, append(checked, ConfigLocation{Source: defaultSource, Path: path})
+}
+
+// defaultSource is the source of DefaultConfigPath.
+const defaultSource = "default"
+
+// MissingConfigError is the error of a configuration file that doesn't
+// exist. It lists the locations checked and how to get started.
+type MissingConfigError struct {
+	Path    string
+	Checked []ConfigLocation
+	err     error
+}
+
+// Error implements error.
+func (e *MissingConfigError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "no configuration file at %s, locations checked in order:\n", e.Path)
+	for _, location := range e.Checked {
+		switch location.Path {
+		case "":
+			fmt.Fprintf(&b, "  %s: not set\n", location.Source)
+		case e.Path:
+			fmt.Fprintf(&b, "  %s: %s (not found)\n", location.Source, location.Path)
+		}
+	}
+	fmt.Fprintf(&b, "write a starter configuration from your hub VNet with\n")
+	fmt.Fprintf(&b, "  velora init --hub-vnet <resource ID> --output %s\n", e.Path)
+	fmt.Fprintf(&b, "or read the configuration from stdin with --config %s", StdinPath)
+	// only a missing default file falls back to the environment variables
+	if e.Checked[len(e.Checked)-1].Source == defaultSource {
+		fmt.Fprintf(&b, ", or run without a file by setting\n")
+		fmt.Fprintf(&b, "  %sAZURE_SUBSCRIPTION_ID, %sHUB_DISCOVERY_SUBSCRIPTIONS and either %sAZURE_USE_AZURE_IDENTITY=true\n", EnvPrefix, EnvPrefix, EnvPrefix)
+		fmt.Fprintf(&b, "  or %sAZURE_TENANT_ID, %sAZURE_CLIENT_ID and %sAZURE_CLIENT_SECRET", EnvPrefix, EnvPrefix, EnvPrefix)
+	}
+	return b.String()
+}
+
+// Unwrap returns the error reading the file.
+func (e *MissingConfigError) Unwrap() error {
+	return e.err
+}
+
+// envConfigured reports whether the environment variables set the
+// subscription and the credential, so velora can run without a file.
+func envConfigured() bool {
+	if os.Getenv(EnvPrefix+"AZURE_SUBSCRIPTION_ID") == "" {
+		return false
+	}
+	if strings.ToLower(os.Getenv(EnvPrefix+"AZURE_USE_AZURE_IDENTITY")) == "true" {
+		return true
+	}
+	return os.Getenv(EnvPrefix+"AZURE_TENANT_ID") != "" && os.Getenv(EnvPrefix+"AZURE_CLIENT_ID") != "" &&
+		os.Getenv(EnvPrefix+"AZURE_CLIENT_SECRET") != ""
+}
+
+// FileMigration is a configuration file migrated to CurrentVersion.
+type FileMigration struct {
+	Path string
+	*Migration
+}
+
+// MigrateFiles migrates the configuration file at path, resolved like
+// LoadConfig, and the files it includes. Nothing is written.
+func MigrateFiles(path string) ([]FileMigration, error) {
+	path, checked := locateConfig(path)
+	if path != StdinPath {
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			return nil, &MissingConfigError{Path: path, Checked: checked, err: err}
+		}
+	}
+	mainFile, err := migrateFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(mainFile.Data, &cfg); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	includes, err := expandIncludes(path, cfg.Includes)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []FileMigration{mainFile}
+	for _, include := range includes {
+		part, err := migrateFile(include)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, part)
+	}
+	return files, nil
+}
+
+// migrateFile migrates a single configuration file.
+func migrateFile(path string) (FileMigration, error) {
+	data, err := readFile(path)
+	if err != nil {
+		return FileMigration{}, fmt.Errorf("error reading config file: %w", err)
+	}
+	migration, err := Migrate(data)
+	if err != nil {
+		return FileMigration{}, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	return FileMigration{Path: path, Migration: migration}, nil
+}
+
+// loadFromFile loads configuration from a JSON file and the files it includes
 func loadFromFile(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
+	cfg, err := parseFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Sources = Sources{
+		Hubs:          make(map[string]string),
+		Subscriptions: make(map[string]string),
+	}
+	if err := cfg.recordSources(path); err != nil {
+		return nil, err
+	}
+
+	includes, err := expandIncludes(path, cfg.Includes)
+	if err != nil {
+		return nil, err
+	}
+
+	// merge included files in deterministic order
+	for _, include := range includes {
+		part, err := parseFile(include)
+		if err != nil {
+			return nil, err
+		}
+		if len(part.Includes) > 0 {
+			return nil, fmt.Errorf("nested includes are not supported: %s", include)
+		}
+		if fields := definedFields(part); len(fields) > 0 {
+			return nil, fmt.Errorf("included file %s may only define hubs and subscriptions, it sets %s", include, strings.Join(fields, ", "))
+		}
+
+		cfg.outdatedFiles = append(cfg.outdatedFiles, part.outdatedFiles...)
+		cfg.migrationWarnings = append(cfg.migrationWarnings, part.migrationWarnings...)
+		if err := cfg.merge(part, include); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// StatePath returns the directory of the state store, expanded
+func (c *Config) StatePath() (string, error) {
+	path := c.State.Path
+	if path == "" {
+		path = DefaultStatePath
+	}
+	return expandPath(path)
+}
+
+// expandPath expands a leading ~ to the user's home directory
+func expandPath(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve home directory: %w", err)
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// parseFile parses a single JSON configuration file, migrated to
+// CurrentVersion
+func parseFile(path string) (*Config, error) {
+	data, err := readFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("error reading config file: %w", err)
 	}
 
+	migration, err := Migrate(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
 	var cfg Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("error parsing config file: %w", err)
+	if err := json.Unmarshal(migration.Data, &cfg); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	if migration.Migrated() {
+		cfg.outdatedFiles = append(cfg.outdatedFiles, path)
+		for _, warning := range migration.Warnings {
+			cfg.migrationWarnings = append(cfg.migrationWarnings, path+": "+warning)
+		}
 	}
 
 	return &cfg, nil
 }
 
+// includeFields are the fields an included file may set, besides the
+// version of its format.
+var includeFields = map[string]bool{"version": true, "includes": true, "hubs": true, "subscriptions": true}
+
+// definedFields returns the JSON names of the fields an included file sets
+// that only the main file may set.
+func definedFields(part *Config) []string {
+	var fields []string
+	v := reflect.ValueOf(part).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" || includeFields[name] {
+			continue
+		}
+		if !v.Field(i).IsZero() {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// readFile reads a configuration file, or stdin for StdinPath.
+func readFile(path string) ([]byte, error) {
+	if path == StdinPath {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// expandIncludes resolves the include patterns relative to the main file.
+// Matches of each pattern are sorted, patterns keep their listed order.
+func expandIncludes(mainPath string, patterns []string) ([]string, error) {
+	baseDir := filepath.Dir(mainPath)
+
+	var files []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(baseDir, pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("include pattern %s matched no files", pattern)
+		}
+		sort.Strings(matches)
+
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// recordSources records the file as the source of all hubs and subscriptions in cfg
+func (c *Config) recordSources(path string) error {
+	for _, hub := range c.Hubs {
+		if prev, ok := c.Sources.Hubs[hub.Name]; ok {
+			return fmt.Errorf("hub %s is defined in both %s and %s", hub.Name, prev, path)
+		}
+		c.Sources.Hubs[hub.Name] = path
+	}
+	for subID := range c.Subscriptions {
+		c.Sources.Subscriptions[subID] = path
+	}
+	return nil
+}
+
+// merge merges the hubs and subscriptions of an included file into c
+func (c *Config) merge(part *Config, path string) error {
+	for _, hub := range part.Hubs {
+		if prev, ok := c.Sources.Hubs[hub.Name]; ok {
+			return fmt.Errorf("hub %s is defined in both %s and %s", hub.Name, prev, path)
+		}
+		c.Sources.Hubs[hub.Name] = path
+		c.Hubs = append(c.Hubs, hub)
+	}
+
+	if len(part.Subscriptions) > 0 && c.Subscriptions == nil {
+		c.Subscriptions = make(map[string]SubscriptionConfig)
+	}
+	for subID, subCFG := range part.Subscriptions {
+		if prev, ok := c.Sources.Subscriptions[subID]; ok {
+			return fmt.Errorf("subscription %s is defined in both %s and %s", subID, prev, path)
+		}
+		c.Sources.Subscriptions[subID] = path
+		c.Subscriptions[subID] = subCFG
+	}
+
+	return nil
+}
+
+// registerSecrets registers the configured secrets for redaction, before
+// anything can print them.
+func (c *Config) registerSecrets() {
+	redact.Register(c.Azure.ClientSecret, c.Plans.SigningKey)
+	if u, err := url.Parse(c.Azure.HTTPProxy); err == nil && u.User != nil {
+		password, _ := u.User.Password()
+		redact.Register(password)
+	}
+	if c.Notifications.Email != nil {
+		redact.Register(c.Notifications.Email.Password)
+	}
+}
+
 // overrideFromEnv overrides configuration values with environment variables
 func overrideFromEnv(cfg *Config) error {
 	// azure config overrides
@@ -69,6 +423,33 @@ func overrideFromEnv(cfg *Config) error {
 	if val := os.Getenv(EnvPrefix + "AZURE_SUBSCRIPTION_ID"); val != "" {
 		cfg.Azure.SubscriptionID = val
 	}
+	if val := os.Getenv(EnvPrefix + "AZURE_CLIENT_SECRET"); val != "" {
+		cfg.Azure.ClientSecret = val
+	}
+	if val := os.Getenv(EnvPrefix + "AZURE_USE_AZURE_IDENTITY"); val != "" {
+		cfg.Azure.UseAzureIdentity = strings.ToLower(val) == "true"
+	}
+
+	// hub discovery overrides, a configuration without a file has no hubs
+	if val := os.Getenv(EnvPrefix + "HUB_DISCOVERY_SUBSCRIPTIONS"); val != "" {
+		if cfg.HubDiscovery == nil {
+			cfg.HubDiscovery = &HubDiscoveryConfig{}
+		}
+		cfg.HubDiscovery.Enabled = true
+		cfg.HubDiscovery.Subscriptions = nil
+		for _, subID := range strings.Split(val, ",") {
+			if subID = strings.TrimSpace(subID); subID != "" {
+				cfg.HubDiscovery.Subscriptions = append(cfg.HubDiscovery.Subscriptions, subID)
+			}
+		}
+	}
+
+	if val := os.Getenv(EnvPrefix + "READ_ONLY"); val != "" {
+		cfg.ReadOnly = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv(EnvPrefix + "DISPLAY_TIMEZONE"); val != "" {
+		cfg.DisplayTimezone = val
+	}
 
 	// feature flag overrides
 	if val := os.Getenv(EnvPrefix + "FEATURE_IPAM_ENFORCEMENT"); val != "" {
@@ -80,6 +461,12 @@ func overrideFromEnv(cfg *Config) error {
 	if val := os.Getenv(EnvPrefix + "FEATURE_PEERING_ENFORCEMENT"); val != "" {
 		cfg.Features.PeeringEnforcement = strings.ToLower(val) == "true"
 	}
+	if val := os.Getenv(EnvPrefix + "FEATURE_GATEWAY_POLICY"); val != "" {
+		cfg.Features.GatewayPolicy = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv(EnvPrefix + "FEATURE_FLOW_LOGS"); val != "" {
+		cfg.Features.FlowLogs = strings.ToLower(val) == "true"
+	}
 	if val := os.Getenv(EnvPrefix + "FEATURE_COMPLIANCE_SCANNING"); val != "" {
 		cfg.Features.ComplianceScanning = strings.ToLower(val) == "true"
 	}
@@ -98,12 +485,26 @@ func overrideFromEnv(cfg *Config) error {
 			return fmt.Errorf("invalid API port: %s", val)
 		}
 	}
+	if val := os.Getenv(EnvPrefix + "API_UI_ENABLED"); val != "" {
+		cfg.API.UIEnabled = strings.ToLower(val) == "true"
+	}
+
+	// plans config overrides
+	if val := os.Getenv(EnvPrefix + "PLANS_SIGNING_KEY"); val != "" {
+		cfg.Plans.SigningKey = val
+	}
 
 	// logging config overrides
 	if val := os.Getenv(EnvPrefix + "LOGGING_LEVEL"); val != "" {
+		if !isLoggingLevel(val) {
+			return fmt.Errorf("invalid %sLOGGING_LEVEL %q, allowed values are debug, info, warn, error", EnvPrefix, val)
+		}
 		cfg.Logging.Level = val
 	}
 	if val := os.Getenv(EnvPrefix + "LOGGING_FORMAT"); val != "" {
+		if !isLoggingFormat(val) {
+			return fmt.Errorf("invalid %sLOGGING_FORMAT %q, allowed values are json, text", EnvPrefix, val)
+		}
 		cfg.Logging.Format = val
 	}
 
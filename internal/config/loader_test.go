@@ -0,0 +1,150 @@
+package config_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+)
+
+// writeVersioned writes the test configuration in version 1 to a temporary
+// directory: velora.json without its hubs, and hubs.json including them
+// with the deprecated resourceGroup. hubsVersion is the version hubs.json
+// declares, none if 0. Returns the path of velora.json.
+func writeVersioned(t *testing.T, hubsVersion int) string {
+	t.Helper()
+	data, err := json.Marshal(configtest.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	hubs := doc["hubs"].([]any)
+	hubs[0].(map[string]any)["resourceGroup"] = "hub-rg"
+	delete(doc, "hubs")
+	delete(doc, "version")
+	doc["includes"] = []string{"hubs.json"}
+	include := map[string]any{"hubs": hubs}
+	if hubsVersion != 0 {
+		include["version"] = hubsVersion
+	}
+
+	files := make(map[string]string)
+	for name, content := range map[string]any{"velora.json": doc, "hubs.json": include} {
+		data, err := json.MarshalIndent(content, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = string(data)
+	}
+	return filepath.Join(configtest.WriteFiles(t, files), "velora.json")
+}
+
+func TestLoadConfigMigrates(t *testing.T) {
+	path := writeVersioned(t, 0)
+	dir := filepath.Dir(path)
+
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if len(cfg.Hubs) != 1 || cfg.Hubs[0].VNetID != configtest.HubVNetID {
+		t.Errorf("hubs = %+v, want the included hub", cfg.Hubs)
+	}
+	warnings := strings.Join(cfg.Warnings(), "\n")
+	for _, want := range []string{
+		path + ", " + filepath.Join(dir, "hubs.json") + " use an older config version",
+		filepath.Join(dir, "hubs.json") + ": hubs[0].resourceGroup is deprecated and removed",
+	} {
+		if !strings.Contains(warnings, want) {
+			t.Errorf("Warnings() =\n%s\nwant %q", warnings, want)
+		}
+	}
+
+	files, err := config.MigrateFiles(path)
+	if err != nil {
+		t.Fatalf("MigrateFiles() error = %v", err)
+	}
+	if len(files) != 2 || !files[0].Migrated() || !files[1].Migrated() {
+		t.Fatalf("MigrateFiles() = %+v, want both files migrated", files)
+	}
+	for _, file := range files {
+		if err := os.WriteFile(file.Path, file.Data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the migrated files load the same configuration, without warnings
+	migrated, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() of the migrated files error = %v", err)
+	}
+	if got := strings.Join(migrated.Warnings(), "\n"); strings.Contains(got, "config version") || strings.Contains(got, "deprecated") {
+		t.Errorf("Warnings() of the migrated files = %s", got)
+	}
+	if migrated.Hash() != cfg.Hash() {
+		t.Errorf("migrated config differs from the loaded one")
+	}
+}
+
+func TestLoadConfigNewerVersion(t *testing.T) {
+	path := writeVersioned(t, config.CurrentVersion+1)
+	if _, err := config.LoadConfig(path); err == nil || !strings.Contains(err.Error(), "hubs.json") || !strings.Contains(err.Error(), "upgrade velora") {
+		t.Errorf("LoadConfig() error = %v, want the include refused", err)
+	}
+}
+
+func TestLoadConfigIncludeFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		value any
+		want  string
+	}{
+		{name: "hubs only", want: ""},
+		{name: "subscriptions", field: "subscriptions", value: map[string]any{}, want: ""},
+		{name: "read only", field: "readOnly", value: true, want: "it sets readOnly"},
+		{name: "notifications", field: "notifications", value: map[string]any{"webhooks": []any{map[string]any{"name": "ops", "url": "https://example.com/hook"}}}, want: "it sets notifications"},
+		{name: "default timezone", field: "defaultTimezone", value: "Europe/Budapest", want: "it sets defaultTimezone"},
+		{name: "flapping", field: "flapping", value: map[string]any{}, want: "it sets flapping"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeVersioned(t, config.CurrentVersion)
+			hubsPath := filepath.Join(filepath.Dir(path), "hubs.json")
+			data, err := os.ReadFile(hubsPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var include map[string]any
+			if err := json.Unmarshal(data, &include); err != nil {
+				t.Fatal(err)
+			}
+			if tt.field != "" {
+				include[tt.field] = tt.value
+			}
+			if data, err = json.Marshal(include); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(hubsPath, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = config.LoadConfig(path)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("LoadConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "may only define hubs and subscriptions") || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadConfig() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoggingConfig represents the logging configuration.
+type LoggingConfig struct {
+	Level      string `json:"level"`
+	Format     string `json:"format"`
+	OutputPath string `json:"outputPath"`
+	// CompliantSampleRate logs 1 in N compliant resources at info level,
+	// all of them are logged at debug level. 0 uses the default.
+	CompliantSampleRate int `json:"compliantSampleRate"`
+}
+
+// DefaultCompliantSampleRate is the default of logging.compliantSampleRate.
+const DefaultCompliantSampleRate = 100
+
+// EffectiveCompliantSampleRate returns the compliant sample rate, with the default applied.
+func (l *LoggingConfig) EffectiveCompliantSampleRate() int {
+	if l.CompliantSampleRate == 0 {
+		return DefaultCompliantSampleRate
+	}
+	return l.CompliantSampleRate
+}
+
+// validate checks the logging level, format and output path.
+func (l *LoggingConfig) validate() error {
+	if l.Level != "" && !isLoggingLevel(l.Level) {
+		return fmt.Errorf("invalid logging.level %q, allowed values are debug, info, warn, error", l.Level)
+	}
+	if l.Format != "" && !isLoggingFormat(l.Format) {
+		return fmt.Errorf("invalid logging.format %q, allowed values are json, text", l.Format)
+	}
+	if l.CompliantSampleRate < 0 {
+		return fmt.Errorf("invalid logging.compliantSampleRate %d, must not be negative", l.CompliantSampleRate)
+	}
+
+	if path, err := l.FilePath(); err != nil {
+		return fmt.Errorf("invalid logging.outputPath %q: %w", l.OutputPath, err)
+	} else if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return fmt.Errorf("logging.outputPath %q is not writable: %w", l.OutputPath, err)
+		}
+		f.Close()
+	}
+
+	return nil
+}
+
+// FilePath returns the expanded path of the log file, empty if velora logs
+// to stdout or stderr.
+func (l *LoggingConfig) FilePath() (string, error) {
+	if l.OutputPath == "" || l.OutputPath == "stdout" || l.OutputPath == "stderr" {
+		return "", nil
+	}
+	return expandPath(l.OutputPath)
+}
+
+// isLoggingLevel reports whether level is an allowed logging level, case-insensitive.
+func isLoggingLevel(level string) bool {
+	switch strings.ToLower(level) {
+	case "debug", "info", "warn", "error":
+		return true
+	}
+	return false
+}
+
+// isLoggingFormat reports whether format is an allowed logging format, case-insensitive.
+func isLoggingFormat(format string) bool {
+	switch strings.ToLower(format) {
+	case "json", "text":
+		return true
+	}
+	return false
+}
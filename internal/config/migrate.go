@@ -0,0 +1,257 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CurrentVersion is the newest config format version, the one this binary
+// writes. Files without a version are version 1.
+const CurrentVersion = 2
+
+// migration upgrades a config document from version from to from+1. It only
+// transforms the document it's given, so config validate can run it, and
+// returns warnings about the deprecated fields it rewrote.
+type migration struct {
+	from  int
+	apply func(doc *document) []string
+}
+
+// migrations upgrade the config documents to CurrentVersion, in order.
+var migrations = []migration{
+	{from: 1, apply: migrateV1},
+}
+
+// Migration is a config file upgraded to CurrentVersion.
+type Migration struct {
+	// From is the version the file declared.
+	From int
+	// Data is the file in the current format, the original data if it
+	// already was.
+	Data []byte
+	// Warnings describe the deprecated fields that were rewritten.
+	Warnings []string
+}
+
+// Migrated reports whether the file was in an older format.
+func (m *Migration) Migrated() bool {
+	return m.From != CurrentVersion
+}
+
+// Migrate upgrades the config file data to CurrentVersion. Files declaring
+// a newer version than this binary supports are refused.
+func Migrate(data []byte) (*Migration, error) {
+	doc, err := parseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	version, err := documentVersion(doc)
+	if err != nil {
+		return nil, err
+	}
+	if version > CurrentVersion {
+		return nil, fmt.Errorf("config version %d is newer than the supported version %d, upgrade velora", version, CurrentVersion)
+	}
+
+	m := &Migration{From: version, Data: data}
+	if version == CurrentVersion {
+		return m, nil
+	}
+	for _, step := range migrations {
+		if step.from >= version {
+			m.Warnings = append(m.Warnings, step.apply(doc)...)
+		}
+	}
+	doc.setFirst("version", json.Number(fmt.Sprint(CurrentVersion)))
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, fmt.Errorf("failed to encode migrated config: %w", err)
+	}
+	m.Data = buf.Bytes()
+	return m, nil
+}
+
+// documentVersion returns the version the document declares, 1 if none.
+func documentVersion(doc *document) (int, error) {
+	value, ok := doc.get("version")
+	if !ok {
+		return 1, nil
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("invalid config version %v, must be a number", value)
+	}
+	version, err := number.Int64()
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid config version %s, must be a positive integer", number)
+	}
+	return int(version), nil
+}
+
+// migrateV1 removes hubs[].resourceGroup, velora takes the resource group of
+// a hub from its vnetId.
+func migrateV1(doc *document) []string {
+	var warnings []string
+	hubs, _ := doc.values["hubs"].([]any)
+	for i, value := range hubs {
+		hub, ok := value.(*document)
+		if !ok {
+			continue
+		}
+		resourceGroup, ok := hub.get("resourceGroup")
+		if !ok {
+			continue
+		}
+		hub.delete("resourceGroup")
+
+		vnetID, _ := hub.values["vnetId"].(string)
+		if rg, _ := resourceGroup.(string); rg != "" && !strings.EqualFold(rg, resourceGroupOf(vnetID)) {
+			warnings = append(warnings, fmt.Sprintf("hubs[%d].resourceGroup %q is removed, it doesn't match vnetId %s which velora uses", i, rg, vnetID))
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("hubs[%d].resourceGroup is deprecated and removed, the resource group is taken from vnetId", i))
+	}
+	return warnings
+}
+
+// resourceGroupOf returns the resource group segment of a resource ID.
+func resourceGroupOf(id string) string {
+	parts := strings.Split(id, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if strings.EqualFold(parts[i], "resourceGroups") {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// document is a JSON object that keeps the order of its keys, so migrated
+// files keep their layout. Values are *document, []any, json.Number,
+// string, bool or nil.
+type document struct {
+	keys   []string
+	values map[string]any
+}
+
+// get returns the value of the key.
+func (d *document) get(key string) (any, bool) {
+	value, ok := d.values[key]
+	return value, ok
+}
+
+// set sets the value of the key, appending it if it's new.
+func (d *document) set(key string, value any) {
+	if _, ok := d.values[key]; !ok {
+		d.keys = append(d.keys, key)
+	}
+	d.values[key] = value
+}
+
+// setFirst sets the value of the key, putting it first if it's new.
+func (d *document) setFirst(key string, value any) {
+	if _, ok := d.values[key]; !ok {
+		d.keys = append([]string{key}, d.keys...)
+	}
+	d.values[key] = value
+}
+
+// delete removes the key.
+func (d *document) delete(key string) {
+	if _, ok := d.values[key]; !ok {
+		return
+	}
+	delete(d.values, key)
+	for i, k := range d.keys {
+		if k == key {
+			d.keys = append(d.keys[:i], d.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// MarshalJSON implements json.Marshaler, keeping the order of the keys.
+func (d *document) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	buf.WriteByte('{')
+	for i, key := range d.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := enc.Encode(key); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(':')
+		if err := enc.Encode(d.values[key]); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// parseDocument parses a config file into a document.
+func parseDocument(data []byte) (*document, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	value, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("unexpected data after the top-level object")
+	}
+	doc, ok := value.(*document)
+	if !ok {
+		return nil, fmt.Errorf("the config must be a JSON object")
+	}
+	return doc, nil
+}
+
+// decodeValue decodes the next JSON value, objects as documents.
+func decodeValue(dec *json.Decoder) (any, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		doc := &document{values: make(map[string]any)}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			doc.set(key.(string), value)
+		}
+		_, err := dec.Token()
+		return doc, err
+	case json.Delim('['):
+		list := []any{}
+		for dec.More() {
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err := dec.Token()
+		return list, err
+	default:
+		return token, nil
+	}
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		wantFrom     int
+		wantWarnings []string
+		wantErr      string
+	}{
+		{
+			name:     "current",
+			data:     `{"version": 2, "hubs": [{"name": "hub", "vnetId": "/subscriptions/s/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub"}]}`,
+			wantFrom: 2,
+		},
+		{
+			name:         "deprecated resourceGroup",
+			data:         `{"hubs": [{"name": "hub", "resourceGroup": "hub-rg", "vnetId": "/subscriptions/s/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub"}]}`,
+			wantFrom:     1,
+			wantWarnings: []string{"hubs[0].resourceGroup is deprecated and removed, the resource group is taken from vnetId"},
+		},
+		{
+			name:         "resourceGroup not matching vnetId",
+			data:         `{"version": 1, "hubs": [{"name": "hub", "resourceGroup": "other-rg", "vnetId": "/subscriptions/s/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub"}]}`,
+			wantFrom:     1,
+			wantWarnings: []string{`hubs[0].resourceGroup "other-rg" is removed, it doesn't match vnetId /subscriptions/s/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub which velora uses`},
+		},
+		{
+			name:    "newer",
+			data:    `{"version": 3}`,
+			wantErr: "upgrade velora",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Migrate([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Migrate() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Migrate() error = %v", err)
+			}
+			if m.From != tt.wantFrom || strings.Join(m.Warnings, "\n") != strings.Join(tt.wantWarnings, "\n") {
+				t.Errorf("Migrate() = from %d with warnings %q, want from %d with %q", m.From, m.Warnings, tt.wantFrom, tt.wantWarnings)
+			}
+
+			var cfg Config
+			if err := json.Unmarshal(m.Data, &cfg); err != nil {
+				t.Fatalf("migrated config doesn't parse: %v", err)
+			}
+			if cfg.Version != CurrentVersion || len(cfg.Hubs) == 0 || cfg.Hubs[0].VNetID == "" {
+				t.Errorf("migrated config = %s", m.Data)
+			}
+		})
+	}
+}
+
+func TestMigrateInvalidVersion(t *testing.T) {
+	for _, data := range []string{`{"version": "2"}`, `{"version": 0}`, `{"version": 1.5}`, `{"version": null}`} {
+		t.Run(data, func(t *testing.T) {
+			if _, err := Migrate([]byte(data)); err == nil || !strings.Contains(err.Error(), "invalid config version") {
+				t.Errorf("Migrate() error = %v, want an invalid version", err)
+			}
+		})
+	}
+}
+
+// TestMigrateKeepsLayout checks that a migrated file keeps the order of its
+// keys, its _comment keys and its numbers as written, and that migrating it
+// again is a no-op. The input is left untouched.
+func TestMigrateKeepsLayout(t *testing.T) {
+	data := `{
+  "_comment": "hub and spokes of the landing zone",
+  "hubs": [
+    {
+      "_comment": "primary hub",
+      "name": "hub",
+      "resourceGroup": "hub-rg",
+      "vnetId": "/subscriptions/s/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub",
+      "nvaNextHop": "10.0.0.4"
+    }
+  ],
+  "subscriptions": {
+    "s": {"hub": "hub", "maxRoutesPerTable": 400}
+  },
+  "metrics": {"ratio": 0.25, "big": 12345678901234567890}
+}`
+	want := `{
+  "version": 2,
+  "_comment": "hub and spokes of the landing zone",
+  "hubs": [
+    {
+      "_comment": "primary hub",
+      "name": "hub",
+      "vnetId": "/subscriptions/s/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub",
+      "nvaNextHop": "10.0.0.4"
+    }
+  ],
+  "subscriptions": {
+    "s": {
+      "hub": "hub",
+      "maxRoutesPerTable": 400
+    }
+  },
+  "metrics": {
+    "ratio": 0.25,
+    "big": 12345678901234567890
+  }
+}
+`
+	input := []byte(data)
+	m, err := Migrate(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Data) != want {
+		t.Errorf("Migrate() =\n%s\nwant:\n%s", m.Data, want)
+	}
+	if string(input) != data {
+		t.Errorf("Migrate() modified its input")
+	}
+
+	again, err := Migrate(m.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Migrated() || len(again.Warnings) != 0 || string(again.Data) != string(m.Data) {
+		t.Errorf("Migrate() of the migrated file = from %d with %q, want it unchanged", again.From, again.Warnings)
+	}
+}
@@ -2,43 +2,186 @@ package config
 
 import (
 	"fmt"
-	"net"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 )
 
 // Config represents the complete application configuration.
 type Config struct {
+	// Version is the format version of the file, 1 if unset. Older files
+	// are migrated to CurrentVersion when loaded.
+	Version       int                           `json:"version,omitempty"`
+	Includes      []string                      `json:"includes"`
 	Azure         AzureConfig                   `json:"azure"`
 	Hubs          []HubVNetConfig               `json:"hubs"`
+	HubDiscovery  *HubDiscoveryConfig           `json:"hubDiscovery,omitempty"`
 	Subscriptions map[string]SubscriptionConfig `json:"subscriptions"`
 	Features      FeaturesConfig                `json:"features"`
+	Rules         map[string]RuleConfig         `json:"rules"`
+	Notifications NotificationsConfig           `json:"notifications"`
+	State         StateConfig                   `json:"state"`
+	Plans         PlansConfig                   `json:"plans"`
+	Reports       ReportsConfig                 `json:"reports"`
+	SLO           SLOConfig                     `json:"slo"`
+	Scoring       ScoringConfig                 `json:"scoring"`
+	AzureMonitor  *AzureMonitorConfig           `json:"azureMonitor"`
+	Testing       TestingConfig                 `json:"testing"`
 	API           APIConfig                     `json:"api"`
+	Stats         StatsConfig                   `json:"stats"`
 	Logging       LoggingConfig                 `json:"logging"`
+	Sharding      *ShardingConfig               `json:"sharding,omitempty"`
+	Inventory     InventoryConfig               `json:"inventory"`
+	Tagging       TaggingConfig                 `json:"tagging"`
+	Metrics       MetricsConfig                 `json:"metrics"`
+	Limits        LimitsConfig                  `json:"limits"`
+	Queue         *QueueConfig                  `json:"queue,omitempty"`
+	Onboarding    OnboardingConfig              `json:"onboarding"`
+	Peering       PeeringConfig                 `json:"peering"`
+	// ReadOnly disables every write, for identities granted Reader only.
+	ReadOnly bool `json:"readOnly"`
+	// DefaultTimezone is the IANA time zone the times of day of the config
+	// are in, e.g. of the maintenance window, UTC if unset. Persisted times
+	// are always UTC.
+	DefaultTimezone string `json:"defaultTimezone,omitempty"`
+	// DisplayTimezone is the IANA time zone reports, the CLI and the web UI
+	// show times in, UTC if unset.
+	DisplayTimezone string `json:"displayTimezone,omitempty"`
+	// MissingHubAction is what a run does with a subscription whose hub
+	// isn't configured: error (the default), skip or reportOnly.
+	MissingHubAction string `json:"missingHubAction,omitempty"`
+	// MaxUnprocessableFraction is the fraction of a subscription's resources
+	// that may have malformed IDs before the subscription fails, unset uses
+	// DefaultMaxUnprocessableFraction. Below it they're skipped and reported.
+	MaxUnprocessableFraction *float64 `json:"maxUnprocessableFraction,omitempty"`
+	// ControllerOrder is the order the controllers run in, the controllers
+	// not listed run after them in the default order.
+	ControllerOrder []string `json:"controllerOrder,omitempty"`
+	// Controllers are the controllers this instance runs, all if unset.
+	// Instances running different controllers share the state store as
+	// roles, see Role.
+	Controllers []string `json:"controllers,omitempty"`
+	// BreakGlass allows activating break-glass mode with its token, it
+	// can't be activated from the configuration.
+	BreakGlass *BreakGlassConfig `json:"breakGlass,omitempty"`
+	// ServiceRouteMatchers detect the route tables and routes of services
+	// velora hasn't met yet, in addition to BuiltinServiceRouteMatchers.
+	ServiceRouteMatchers []ServiceRouteMatcher `json:"serviceRouteMatchers,omitempty"`
+	// ConfigStaging holds back changed configs whose estimated impact is
+	// too large until they are activated. Unset, every change applies.
+	ConfigStaging *ConfigStagingConfig `json:"configStaging,omitempty"`
+	// Flapping detects resources remediated again and again, usually
+	// because other automation reverts velora's changes. Unset, it's off.
+	Flapping *FlappingConfig `json:"flapping,omitempty"`
+	// Attribution looks up who made the changes velora reports in the
+	// Activity Log. Unset, it's off.
+	Attribution *AttributionConfig `json:"attribution,omitempty"`
+
+	// Sources records which file each hub and subscription was loaded from.
+	Sources Sources `json:"-"`
+	// outdatedFiles are the files loaded in an older version, and
+	// migrationWarnings the deprecated fields migrated in them.
+	outdatedFiles     []string
+	migrationWarnings []string
+}
+
+// Sources maps hub names and subscription IDs to the file defining them.
+type Sources struct {
+	Hubs          map[string]string
+	Subscriptions map[string]string
+}
+
+// Actions for a subscription whose hub isn't configured. error fails the
+// run at the first controller needing the hub. skip skips the checks needing
+// the hub, with a finding, and runs the others. reportOnly also downgrades
+// the subscription to observe mode.
+const (
+	MissingHubError      = "error"
+	MissingHubSkip       = "skip"
+	MissingHubReportOnly = "reportOnly"
+)
+
+// EffectiveMissingHubAction returns the missing hub action, error if unset.
+func (c *Config) EffectiveMissingHubAction() string {
+	if c.MissingHubAction == "" {
+		return MissingHubError
+	}
+	return c.MissingHubAction
+}
+
+// Location returns the location of DefaultTimezone.
+func (c *Config) Location() *time.Location {
+	return location(c.DefaultTimezone)
+}
+
+// DisplayLocation returns the location of DisplayTimezone.
+func (c *Config) DisplayLocation() *time.Location {
+	return location(c.DisplayTimezone)
+}
+
+// location returns the location of the time zone, which was validated, UTC
+// if unset.
+func location(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// validateTimezone checks the time zone is known, the time zone database
+// is embedded in the binary.
+func validateTimezone(name, field string) error {
+	if _, err := time.LoadLocation(name); err != nil {
+		return fmt.Errorf("%s: %w, use an IANA name like Europe/Berlin", field, err)
+	}
+	return nil
+}
+
+// BreakGlassConfig enables break-glass mode. The token is distributed
+// out-of-band and differs from the API keys, only its hash is configured.
+type BreakGlassConfig struct {
+	TokenSHA256 string `json:"tokenSha256"`
 }
 
-// AzureConfig represents the Azure-specific configuration.
-type AzureConfig struct {
-	SubscriptionID   string `json:"subscriptionId"`
-	TenantID         string `json:"tenantId"`
-	ClientID         string `json:"clientId"`
-	ClientSecret     string `json:"clientSecret"`
-	UseAzureIdentity bool   `json:"useAzureIdentity"`
+// DefaultMaxImpactedResources is how many resources a config change may
+// make non-compliant or rewrite before it is staged.
+const DefaultMaxImpactedResources = 50
+
+// ConfigStagingConfig enables staging of changed configs.
+type ConfigStagingConfig struct {
+	// MaxImpactedResources is how many resources a change may make
+	// non-compliant or rewrite and still apply, DefaultMaxImpactedResources
+	// if 0.
+	MaxImpactedResources int `json:"maxImpactedResources,omitempty"`
 }
 
-// HubVNetConfig represents the configuration for a hub VNet.
-type HubVNetConfig struct {
-	VNetID        string `json:"vnetId"`
-	ResourceGroup string `json:"resourceGroup"`
-	Name          string `json:"name"`
-	NVANextHop    string `json:"nvaNextHop"`
+// EffectiveMaxImpactedResources returns the impact a change may have
+// without being staged.
+func (s *ConfigStagingConfig) EffectiveMaxImpactedResources() int {
+	if s.MaxImpactedResources == 0 {
+		return DefaultMaxImpactedResources
+	}
+	return s.MaxImpactedResources
 }
 
-// SubscriptionConfig represents the configuration for a subscription.
-type SubscriptionConfig struct {
-	AllowedCIDRs       []string `json:"allowedCIDRs"`
-	HubName            string   `json:"hubName"`
-	RequireHubPeering  bool     `json:"requireHubPeering"`
-	RequireNVARouting  bool     `json:"requireNVARouting"`
-	SubnetToSubnetDeny bool     `json:"subnetToSubnetDeny"`
+// sha256Hex matches a hex-encoded SHA-256 hash.
+var sha256Hex = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// DefaultMaxUnprocessableFraction is the fraction of a subscription's
+// resources that may have malformed IDs. More than that points to something
+// systemic, like an SDK and API mismatch, rather than a resource being moved.
+const DefaultMaxUnprocessableFraction = 0.1
+
+// EffectiveMaxUnprocessableFraction returns the fraction of a subscription's
+// resources that may be unprocessable, DefaultMaxUnprocessableFraction if unset.
+func (c *Config) EffectiveMaxUnprocessableFraction() float64 {
+	if c.MaxUnprocessableFraction == nil {
+		return DefaultMaxUnprocessableFraction
+	}
+	return *c.MaxUnprocessableFraction
 }
 
 // FeaturesConfig controls enabled features.
@@ -46,50 +189,243 @@ type FeaturesConfig struct {
 	IPAMEnforcement    bool `json:"ipamEnforcement"`
 	RoutingEnforcement bool `json:"routingEnforcement"`
 	PeeringEnforcement bool `json:"peeringEnforcement"`
+	GatewayPolicy      bool `json:"gatewayPolicy"`
+	FlowLogs           bool `json:"flowLogs"`
+	NSGAssociation     bool `json:"nsgAssociation"`
 	ComplianceScanning bool `json:"complianceScanning"`
 	AutoRemediation    bool `json:"autoRemediation"`
 }
 
-// APIConfig represents the API configuration.
-type APIConfig struct {
-	ListenAddress string `json:"listenAddress"`
-	Port          int    `json:"port"`
-	TLSEnabled    bool   `json:"tlsEnabled"`
-	TLSCertPath   string `json:"tlsCertPath"`
-	TLSKeyPath    string `json:"tlsKeyPath"`
+// PlansConfig represents the configuration of two-phase plan/apply runs.
+type PlansConfig struct {
+	// SigningKey is the HMAC key plans are signed and verified with.
+	SigningKey string `json:"signingKey"`
 }
 
-// LoggingConfig represents the logging configuration.
-type LoggingConfig struct {
-	Level      string `json:"level"`
-	Format     string `json:"format"`
-	OutputPath string `json:"outputPath"`
+// Validate performs validation on the configuration, section by section.
+func (c *Config) Validate() error {
+	for _, validate := range []func() error{
+		c.Azure.validate,
+		c.validateSubscriptions,
+		c.validateServiceRouteMatchers,
+		c.validateHubs,
+		c.Logging.validate,
+		c.State.validate,
+		c.Stats.validate,
+		c.validateSettings,
+		c.Limits.validate,
+		c.Onboarding.validate,
+		c.Peering.validate,
+		c.Tagging.validate,
+		c.Reports.validate,
+		c.Inventory.validate,
+		c.API.validate,
+		c.validateSharding,
+		c.Flapping.validate,
+		c.Attribution.validate,
+		c.SLO.validate,
+		c.Scoring.validate,
+		c.Notifications.validate,
+		c.AzureMonitor.validate,
+		c.validateFaultInjection,
+		c.validateRules,
+	} {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Validate performs validation on the configuration.
-func (c *Config) Validate() error {
-	// validate allowed IP ranges
-	for _, subConfig := range c.Subscriptions {
-		for _, cidr := range subConfig.AllowedCIDRs {
-			if _, _, err := net.ParseCIDR(cidr); err != nil {
-				return fmt.Errorf("invalid CIDR in allowedIPRanges: %s", cidr)
+// validateSettings checks the settings at the top level of the file.
+func (c *Config) validateSettings() error {
+	switch c.EffectiveMissingHubAction() {
+	case MissingHubError, MissingHubSkip, MissingHubReportOnly:
+	default:
+		return fmt.Errorf("unknown missingHubAction %q, allowed values are %s, %s, %s",
+			c.MissingHubAction, MissingHubError, MissingHubSkip, MissingHubReportOnly)
+	}
+	if f := c.EffectiveMaxUnprocessableFraction(); f < 0 || f > 1 {
+		return fmt.Errorf("maxUnprocessableFraction must be between 0 and 1")
+	}
+	if err := validateTimezone(c.DefaultTimezone, "defaultTimezone"); err != nil {
+		return err
+	}
+	if err := validateTimezone(c.DisplayTimezone, "displayTimezone"); err != nil {
+		return err
+	}
+	if err := validateControllers(c.ControllerOrder, "controllerOrder"); err != nil {
+		return err
+	}
+	if err := validateControllers(c.Controllers, "controllers"); err != nil {
+		return err
+	}
+	if c.BreakGlass != nil && !sha256Hex.MatchString(c.BreakGlass.TokenSHA256) {
+		return fmt.Errorf("breakGlass.tokenSha256 must be the hex-encoded SHA-256 of the break-glass token")
+	}
+	if c.ConfigStaging != nil && c.ConfigStaging.MaxImpactedResources < 0 {
+		return fmt.Errorf("invalid configStaging.maxImpactedResources %d, must not be negative", c.ConfigStaging.MaxImpactedResources)
+	}
+	return nil
+}
+
+// Hub returns the hub with the given name, or nil if there is none.
+func (c *Config) Hub(name string) *HubVNetConfig {
+	for i := range c.Hubs {
+		if c.Hubs[i].Name == name {
+			return &c.Hubs[i]
+		}
+	}
+	return nil
+}
+
+// WithNextHops returns a copy of the configuration with the NVA next hops of
+// the hubs replaced by the resolved ones.
+func (c *Config) WithNextHops(nextHops map[string]string) *Config {
+	resolved := *c
+	resolved.Hubs = append([]HubVNetConfig(nil), c.Hubs...)
+	for i := range resolved.Hubs {
+		if resolved.Hubs[i].IsVirtualWAN() {
+			continue
+		}
+		if ip, ok := nextHops[resolved.Hubs[i].Name]; ok {
+			resolved.Hubs[i].NVANextHop = ip
+		}
+	}
+	return &resolved
+}
+
+// WithDiscoveredHubs returns a copy of the configuration with the
+// discovered hubs added. Static hubs win, a discovered hub with the name or
+// VNet of a static hub is left out with a warning. Every discovered hub must
+// pass the validation of static hubs, the ones that don't are left out and
+// returned with the error, by VNet ID.
+func (c *Config) WithDiscoveredHubs(hubs []HubVNetConfig) (*Config, map[string]error, []string) {
+	merged := *c
+	merged.Hubs = append([]HubVNetConfig(nil), c.Hubs...)
+	merged.Sources.Hubs = make(map[string]string, len(c.Sources.Hubs)+len(hubs))
+	for name, source := range c.Sources.Hubs {
+		merged.Sources.Hubs[name] = source
+	}
+
+	rejected := make(map[string]error)
+	var warnings []string
+	for _, hub := range hubs {
+		if static := c.Hub(hub.Name); static != nil {
+			warnings = append(warnings, fmt.Sprintf("discovered hub %s from VNet %s ignored, hub %s is configured statically", hub.Name, hub.VNetID, hub.Name))
+			continue
+		}
+		if c.IsHubVNet(hub.VNetID) {
+			warnings = append(warnings, fmt.Sprintf("discovered hub %s ignored, VNet %s is configured statically", hub.Name, hub.VNetID))
+			continue
+		}
+		if merged.Hub(hub.Name) != nil {
+			rejected[hub.VNetID] = fmt.Errorf("hub %s is discovered from more than one VNet", hub.Name)
+			continue
+		}
+
+		// the static configuration is valid, only the hub and the
+		// subscriptions using it are checked
+		candidate := merged
+		candidate.Hubs = append(slices.Clone(merged.Hubs), hub)
+		if err := candidate.validateHub(hub.Name); err != nil {
+			rejected[hub.VNetID] = err
+			continue
+		}
+		merged.Hubs = candidate.Hubs
+		merged.Sources.Hubs[hub.Name] = "discovered from " + hub.VNetID
+	}
+	return &merged, rejected, warnings
+}
+
+// validateHub checks the hub and the default route prefixes the
+// subscriptions using it inherit.
+func (c *Config) validateHub(name string) error {
+	hub := c.Hub(name)
+	if err := hub.validate(c); err != nil {
+		return err
+	}
+	for _, subID := range c.SubscriptionIDs() {
+		if subConfig := c.Subscriptions[subID]; subConfig.HubName == name {
+			if err := c.validateDefaultRoutePrefixes(subID, subConfig); err != nil {
+				return err
 			}
 		}
 	}
+	return nil
+}
 
-	// validate hubs
-	if len(c.Hubs) == 0 {
-		return fmt.Errorf("at least one hub configuration is required")
+// SubscriptionIDs returns the IDs of the managed subscriptions, sorted.
+func (c *Config) SubscriptionIDs() []string {
+	ids := make([]string, 0, len(c.Subscriptions))
+	for id := range c.Subscriptions {
+		ids = append(ids, id)
 	}
+	sort.Strings(ids)
+	return ids
+}
 
-	// validate NVA IPs
+// IsHubVNet reports whether the VNet is one of the configured hubs. IDs are
+// compared case-insensitively, ignoring a trailing slash.
+func (c *Config) IsHubVNet(vnetID string) bool {
+	normalized := strings.TrimRight(vnetID, "/")
 	for _, hub := range c.Hubs {
-		if hub.NVANextHop != "" {
-			if net.ParseIP(hub.NVANextHop) == nil {
-				return fmt.Errorf("invalid NVA IP: %s", hub.NVANextHop)
+		if hub.VNetID != "" && strings.EqualFold(strings.TrimRight(hub.VNetID, "/"), normalized) {
+			return true
+		}
+	}
+	return false
+}
+
+// Manages reports whether the subscription is one of the configured subscriptions.
+func (c *Config) Manages(subscriptionID string) bool {
+	for id := range c.Subscriptions {
+		if strings.EqualFold(id, subscriptionID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Warnings returns non-fatal configuration issues that should be reported
+// to the operator.
+func (c *Config) Warnings() []string {
+	var warnings []string
+	if len(c.outdatedFiles) > 0 {
+		warnings = append(warnings, fmt.Sprintf("%s use an older config version, run velora config migrate --write to upgrade them to version %d",
+			strings.Join(c.outdatedFiles, ", "), CurrentVersion))
+	}
+	warnings = append(warnings, c.migrationWarnings...)
+
+	if ignored := c.Azure.IgnoredCredentialFields(); len(ignored) > 0 {
+		warnings = append(warnings, fmt.Sprintf("%s is set but ignored because azure.useAzureIdentity is true", strings.Join(ignored, ", ")))
+	}
+
+	// overriding a spoke's own range sends its intra-VNet traffic to the NVA
+	for _, subID := range c.SubscriptionIDs() {
+		subConfig := c.Subscriptions[subID]
+		hub := c.Hub(subConfig.HubName)
+		if hub == nil {
+			continue
+		}
+		for _, prefix := range hub.OverriddenOnPremPrefixes {
+			for _, cidr := range subConfig.AllowedCIDRs {
+				if prefixesOverlap(prefix, cidr) {
+					warnings = append(warnings, fmt.Sprintf("overridden on-prem prefix %s of hub %s overlaps %s of subscription %s, traffic within its spokes would be routed through the NVA",
+						prefix, hub.Name, cidr, subID))
+				}
 			}
 		}
 	}
 
-	return nil
+	for _, subID := range c.SubscriptionIDs() {
+		if c.Subscriptions[subID].SubnetIsolationBand != nil && !c.Features.NSGAssociation {
+			warnings = append(warnings, fmt.Sprintf("subnetIsolationBand of subscription %s is set but ignored because features.nsgAssociation is false", subID))
+		}
+		if c.Subscriptions[subID].NICPublicIPScan != nil && !c.Subscriptions[subID].RequireNVARouting {
+			warnings = append(warnings, fmt.Sprintf("nicPublicIpScan of subscription %s is set but ignored because requireNVARouting is false", subID))
+		}
+	}
+
+	return warnings
 }
@@ -0,0 +1,401 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// NotificationsConfig represents the notification channels.
+type NotificationsConfig struct {
+	Email *EmailConfig `json:"email"`
+	// Throttle suppresses the alerts of findings already notified, nil
+	// alerts every finding on every run.
+	Throttle *NotificationThrottleConfig `json:"throttle,omitempty"`
+	// Issues files the findings as work items or issues, nil files none.
+	Issues *IssueExportConfig `json:"issues,omitempty"`
+	// Webhooks are the endpoints signed events are posted to.
+	Webhooks []WebhookConfig `json:"webhooks,omitempty"`
+}
+
+// validate checks the notification channels.
+func (n *NotificationsConfig) validate() error {
+	if n.Email != nil {
+		if err := n.Email.validate(); err != nil {
+			return fmt.Errorf("invalid email notification config: %w", err)
+		}
+	}
+	if n.Throttle != nil {
+		if err := n.Throttle.validate(); err != nil {
+			return err
+		}
+	}
+	if n.Issues != nil {
+		if err := n.Issues.validate(); err != nil {
+			return fmt.Errorf("invalid issue export config: %w", err)
+		}
+	}
+	webhooks := make(map[string]bool)
+	for i, webhook := range n.Webhooks {
+		if err := webhook.validate(); err != nil {
+			return fmt.Errorf("invalid webhook %d config: %w", i, err)
+		}
+		if webhooks[webhook.Name] {
+			return fmt.Errorf("duplicate webhook name %s", webhook.Name)
+		}
+		webhooks[webhook.Name] = true
+	}
+	return nil
+}
+
+// NotificationThrottleConfig represents the deduplication of alerts across
+// runs, by rule and resource.
+type NotificationThrottleConfig struct {
+	// RenotifyHours is how long after its alert an open finding of the
+	// severity is alerted again. Severities without one are alerted once,
+	// until they resolve.
+	RenotifyHours map[string]float64 `json:"renotifyHours,omitempty"`
+}
+
+// validate checks the intervals are positive and keyed by known severities.
+func (t *NotificationThrottleConfig) validate() error {
+	for severity, hours := range t.RenotifyHours {
+		switch severity {
+		case "critical", "high", "medium", "low", "info":
+		default:
+			return fmt.Errorf("unknown severity %q in notifications.throttle.renotifyHours, allowed values are critical, high, medium, low, info", severity)
+		}
+		if hours <= 0 {
+			return fmt.Errorf("invalid notifications.throttle.renotifyHours %v for %s, must be positive", hours, severity)
+		}
+	}
+	return nil
+}
+
+// Issue export authentication methods of Azure DevOps.
+const (
+	AzureDevOpsAuthPAT             = "pat"
+	AzureDevOpsAuthManagedIdentity = "managedIdentity"
+)
+
+// IssueExportConfig represents the export of findings to issue trackers: an
+// item per open finding, updated while it is reported and closed once it
+// resolves.
+type IssueExportConfig struct {
+	// MinSeverity is the lowest severity filed, high if unset.
+	MinSeverity string `json:"minSeverity,omitempty"`
+	// Rules limits the export to the findings of the rule IDs, empty files
+	// every rule.
+	Rules []string `json:"rules,omitempty"`
+	// RequestsPerMinute paces the requests sent to each tracker, 60 if unset.
+	RequestsPerMinute int `json:"requestsPerMinute,omitempty"`
+	// DryRun prints the items a run would create, update and close instead.
+	DryRun      bool                     `json:"dryRun,omitempty"`
+	AzureDevOps *AzureDevOpsIssuesConfig `json:"azureDevOps,omitempty"`
+	GitHub      *GitHubIssuesConfig      `json:"github,omitempty"`
+	// Routes file the findings of subscriptions by their ownership, the
+	// first matching route applies. Findings no route matches are filed
+	// with the defaults of the tracker.
+	Routes []IssueRouteConfig `json:"routes,omitempty"`
+}
+
+// AzureDevOpsIssuesConfig represents the work items filed in Azure Boards.
+type AzureDevOpsIssuesConfig struct {
+	// Organization is the organization name, as in dev.azure.com/<organization>.
+	Organization string `json:"organization"`
+	Project      string `json:"project"`
+	// WorkItemType is the type of the work items, Issue if unset.
+	WorkItemType string   `json:"workItemType,omitempty"`
+	AreaPath     string   `json:"areaPath,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	// ClosedState is the state resolved findings move their work item to,
+	// Done if unset.
+	ClosedState string `json:"closedState,omitempty"`
+	// Auth is pat, with the token in TokenEnv, or managedIdentity, with the
+	// Azure credential of velora. pat if unset.
+	Auth     string `json:"auth,omitempty"`
+	TokenEnv string `json:"tokenEnv,omitempty"`
+}
+
+// WorkItemTypeOrDefault returns the type of the work items filed.
+func (a *AzureDevOpsIssuesConfig) WorkItemTypeOrDefault() string {
+	if a.WorkItemType == "" {
+		return "Issue"
+	}
+	return a.WorkItemType
+}
+
+// ClosedStateOrDefault returns the state of the work items of resolved findings.
+func (a *AzureDevOpsIssuesConfig) ClosedStateOrDefault() string {
+	if a.ClosedState == "" {
+		return "Done"
+	}
+	return a.ClosedState
+}
+
+// validate checks the organization, the project and the authentication.
+func (a *AzureDevOpsIssuesConfig) validate() error {
+	if a.Organization == "" || a.Project == "" {
+		return fmt.Errorf("organization and project are required")
+	}
+	switch a.Auth {
+	case "", AzureDevOpsAuthPAT:
+		if a.TokenEnv == "" {
+			return fmt.Errorf("tokenEnv is required with pat authentication")
+		}
+	case AzureDevOpsAuthManagedIdentity:
+		if a.TokenEnv != "" {
+			return fmt.Errorf("tokenEnv can't be combined with managedIdentity authentication")
+		}
+	default:
+		return fmt.Errorf("unknown auth %q, allowed values are %s, %s", a.Auth, AzureDevOpsAuthPAT, AzureDevOpsAuthManagedIdentity)
+	}
+	return nil
+}
+
+// GitHubIssuesConfig represents the issues filed in a GitHub repository.
+// Exactly one of TokenEnv and App must be set.
+type GitHubIssuesConfig struct {
+	// Repository is the repository as owner/name.
+	Repository string   `json:"repository"`
+	Labels     []string `json:"labels,omitempty"`
+	// APIURL is the REST API of GitHub Enterprise Server, api.github.com if unset.
+	APIURL string `json:"apiUrl,omitempty"`
+	// TokenEnv is the environment variable holding a personal access token.
+	TokenEnv string           `json:"tokenEnv,omitempty"`
+	App      *GitHubAppConfig `json:"app,omitempty"`
+}
+
+// GitHubAppConfig represents the authentication as a GitHub App installation.
+type GitHubAppConfig struct {
+	AppID          int64 `json:"appId"`
+	InstallationID int64 `json:"installationId"`
+	// PrivateKeyEnv is the environment variable holding the PEM encoded
+	// private key of the app.
+	PrivateKeyEnv string `json:"privateKeyEnv"`
+}
+
+// validate checks the repository and the authentication.
+func (g *GitHubIssuesConfig) validate() error {
+	if !validRepository(g.Repository) {
+		return fmt.Errorf("invalid repository %q, must be owner/name", g.Repository)
+	}
+	if g.APIURL != "" {
+		if u, err := url.Parse(g.APIURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid apiUrl: %s", g.APIURL)
+		}
+	}
+	if (g.TokenEnv == "") == (g.App == nil) {
+		return fmt.Errorf("exactly one of tokenEnv and app is required")
+	}
+	if g.App != nil && (g.App.AppID <= 0 || g.App.InstallationID <= 0 || g.App.PrivateKeyEnv == "") {
+		return fmt.Errorf("app requires appId, installationId and privateKeyEnv")
+	}
+	return nil
+}
+
+// validRepository reports whether the repository is owner/name.
+func validRepository(repository string) bool {
+	owner, name, ok := strings.Cut(repository, "/")
+	return ok && owner != "" && name != "" && !strings.Contains(name, "/")
+}
+
+// IssueRouteConfig files the findings of the subscriptions with the
+// ownership. Every matcher set must match, at least one is required.
+type IssueRouteConfig struct {
+	Owner       string `json:"owner,omitempty"`
+	Team        string `json:"team,omitempty"`
+	TicketQueue string `json:"ticketQueue,omitempty"`
+	// Project and AreaPath override those of Azure DevOps.
+	Project  string `json:"project,omitempty"`
+	AreaPath string `json:"areaPath,omitempty"`
+	// Repository overrides the GitHub repository, as owner/name.
+	Repository string `json:"repository,omitempty"`
+	// Labels are added to the labels of GitHub and the tags of Azure DevOps.
+	Labels []string `json:"labels,omitempty"`
+}
+
+// Matches reports whether the route applies to the ownership.
+func (r *IssueRouteConfig) Matches(o *Ownership) bool {
+	if o == nil {
+		return false
+	}
+	for _, m := range []struct{ want, got string }{
+		{r.Owner, o.Owner},
+		{r.Team, o.Team},
+		{r.TicketQueue, o.TicketQueue},
+	} {
+		if m.want != "" && !strings.EqualFold(m.want, m.got) {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks the export has a tracker and valid filters and routes.
+func (i *IssueExportConfig) validate() error {
+	if i.AzureDevOps == nil && i.GitHub == nil {
+		return fmt.Errorf("azureDevOps or github is required")
+	}
+	switch i.MinSeverity {
+	case "", "critical", "high", "medium", "low", "info":
+	default:
+		return fmt.Errorf("unknown minSeverity %q, allowed values are critical, high, medium, low, info", i.MinSeverity)
+	}
+	if i.RequestsPerMinute < 0 {
+		return fmt.Errorf("invalid requestsPerMinute %d, must not be negative", i.RequestsPerMinute)
+	}
+	if i.AzureDevOps != nil {
+		if err := i.AzureDevOps.validate(); err != nil {
+			return fmt.Errorf("invalid azureDevOps config: %w", err)
+		}
+	}
+	if i.GitHub != nil {
+		if err := i.GitHub.validate(); err != nil {
+			return fmt.Errorf("invalid github config: %w", err)
+		}
+	}
+	for n, route := range i.Routes {
+		if route.Owner == "" && route.Team == "" && route.TicketQueue == "" {
+			return fmt.Errorf("route %d requires owner, team or ticketQueue", n)
+		}
+		if route.Repository != "" && !validRepository(route.Repository) {
+			return fmt.Errorf("invalid repository %q in route %d, must be owner/name", route.Repository, n)
+		}
+	}
+	return nil
+}
+
+// Webhook event types.
+const (
+	WebhookEventRunCompleted       = "run.completed"
+	WebhookEventFindingNew         = "finding.new"
+	WebhookEventFindingResolved    = "finding.resolved"
+	WebhookEventGuardrailTriggered = "guardrail.triggered"
+	WebhookEventPauseActivated     = "pause.activated"
+)
+
+// WebhookEvents are the event types a webhook can subscribe to.
+var WebhookEvents = []string{
+	WebhookEventRunCompleted,
+	WebhookEventFindingNew,
+	WebhookEventFindingResolved,
+	WebhookEventGuardrailTriggered,
+	WebhookEventPauseActivated,
+}
+
+// WebhookConfig represents an endpoint events are posted to, signed with
+// HMAC-SHA256 over the body with the secret in SecretEnv.
+type WebhookConfig struct {
+	// Name identifies the endpoint in the output and the dead-letter log.
+	Name string `json:"name"`
+	// URL is the HTTPS endpoint, HTTP is only allowed for localhost.
+	URL string `json:"url"`
+	// SecretEnv is the environment variable holding the signing secret.
+	SecretEnv string `json:"secretEnv"`
+	// Events are the WebhookEvent* types posted, every type if empty.
+	Events []string `json:"events,omitempty"`
+	// MinSeverity is the lowest severity of the finding events posted,
+	// every severity if unset.
+	MinSeverity string `json:"minSeverity,omitempty"`
+}
+
+// Subscribed reports whether the endpoint receives the event type.
+func (w *WebhookConfig) Subscribed(eventType string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, eventType)
+}
+
+// validate checks the name, the URL, the secret and the events.
+func (w *WebhookConfig) validate() error {
+	if w.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid url: %s", w.URL)
+	}
+	local := u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1" || u.Hostname() == "::1"
+	if u.Scheme != "https" && (u.Scheme != "http" || !local) {
+		return fmt.Errorf("invalid url %s, must be https", w.URL)
+	}
+	if w.SecretEnv == "" {
+		return fmt.Errorf("secretEnv is required, deliveries are always signed")
+	}
+	for _, event := range w.Events {
+		if !slices.Contains(WebhookEvents, event) {
+			return fmt.Errorf("unknown event %q, allowed values are %s", event, strings.Join(WebhookEvents, ", "))
+		}
+	}
+	switch w.MinSeverity {
+	case "", "critical", "high", "medium", "low", "info":
+	default:
+		return fmt.Errorf("unknown minSeverity %q, allowed values are critical, high, medium, low, info", w.MinSeverity)
+	}
+	return nil
+}
+
+// EmailConfig represents the SMTP notification channel.
+type EmailConfig struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port"`
+	TLSMode  string   `json:"tlsMode"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	// Mode is either "immediate" (one email per run) or "digest"
+	// (findings are aggregated until the next digest is sent).
+	Mode        string `json:"mode"`
+	MinSeverity string `json:"minSeverity"`
+}
+
+// AzureMonitorConfig represents the export of run statistics to a Log
+// Analytics workspace through the Logs Ingestion API.
+type AzureMonitorConfig struct {
+	// Endpoint is the data collection endpoint, e.g. https://<dce>.ingest.monitor.azure.com
+	Endpoint string `json:"endpoint"`
+	// RuleID is the immutable ID of the data collection rule.
+	RuleID     string `json:"ruleId"`
+	StreamName string `json:"streamName"`
+}
+
+// validate checks the email notification settings.
+func (e *EmailConfig) validate() error {
+	if e.Host == "" || e.Port == 0 {
+		return fmt.Errorf("host and port are required")
+	}
+	if e.From == "" || len(e.To) == 0 {
+		return fmt.Errorf("from and to addresses are required")
+	}
+	switch e.TLSMode {
+	case "", "none", "starttls", "tls":
+	default:
+		return fmt.Errorf("unknown tlsMode %q, allowed values are none, starttls, tls", e.TLSMode)
+	}
+	switch e.Mode {
+	case "", "immediate", "digest":
+	default:
+		return fmt.Errorf("unknown mode %q, allowed values are immediate, digest", e.Mode)
+	}
+	switch e.MinSeverity {
+	case "", "critical", "high", "medium", "low", "info":
+	default:
+		return fmt.Errorf("unknown minSeverity %q, allowed values are critical, high, medium, low, info", e.MinSeverity)
+	}
+	return nil
+}
+
+// validate checks the Azure Monitor export settings, if set.
+func (a *AzureMonitorConfig) validate() error {
+	if a == nil {
+		return nil
+	}
+	if u, err := url.Parse(a.Endpoint); err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid azureMonitor.endpoint: %s", a.Endpoint)
+	}
+	if a.RuleID == "" || a.StreamName == "" {
+		return fmt.Errorf("azureMonitor.ruleId and azureMonitor.streamName are required")
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// NSG association modes.
+const (
+	// NSGAssociationAny requires some NSG on every subnet, it is report-only.
+	NSGAssociationAny = "any"
+	// NSGAssociationSpecific requires the baseline NSG on every subnet.
+	NSGAssociationSpecific = "specific"
+)
+
+// NSGAssociationConfig represents the NSG association baseline of a subscription.
+type NSGAssociationConfig struct {
+	Mode string `json:"mode"`
+	// NSGID is the baseline NSG, required in specific mode.
+	NSGID string `json:"nsgId,omitempty"`
+	// ReplaceExisting allows replacing a different NSG with the baseline NSG.
+	ReplaceExisting bool `json:"replaceExisting,omitempty"`
+	// BaselineRules are the security rules the NSGs of the subnets must
+	// contain, they are reported but not remediated.
+	BaselineRules []NSGRuleConfig `json:"baselineRules,omitempty"`
+	// CompareExpanded compares the IP Groups of the baseline rules by their
+	// addresses. Off, NSGs can't reference IP Groups, so an IP Group
+	// matches whatever other address prefixes a rule has.
+	CompareExpanded bool `json:"compareExpanded,omitempty"`
+	// AdditionalServiceTags are accepted as service tags in addition to the
+	// built-in ones, for the tags Azure added since.
+	AdditionalServiceTags []string `json:"additionalServiceTags,omitempty"`
+}
+
+// validate checks the mode, the baseline NSG and the baseline rules.
+func (n *NSGAssociationConfig) validate() error {
+	switch n.Mode {
+	case NSGAssociationAny:
+		if n.NSGID != "" || n.ReplaceExisting {
+			return fmt.Errorf("nsgId and replaceExisting require mode %s", NSGAssociationSpecific)
+		}
+	case NSGAssociationSpecific:
+		if !strings.Contains(strings.ToLower(n.NSGID), "/providers/microsoft.network/networksecuritygroups/") {
+			return fmt.Errorf("invalid nsgId: %q", n.NSGID)
+		}
+	default:
+		return fmt.Errorf("unknown mode %q, allowed values are %s, %s", n.Mode, NSGAssociationAny, NSGAssociationSpecific)
+	}
+
+	names := make(map[string]bool, len(n.BaselineRules))
+	for i, rule := range n.BaselineRules {
+		if err := rule.validate(n.AdditionalServiceTags); err != nil {
+			return fmt.Errorf("invalid baselineRules[%d]: %w", i, err)
+		}
+		if names[strings.ToLower(rule.Name)] {
+			return fmt.Errorf("duplicate baseline rule %s", rule.Name)
+		}
+		names[strings.ToLower(rule.Name)] = true
+	}
+	return nil
+}
+
+// IPGroups returns the IP Group IDs the baseline rules reference.
+func (n *NSGAssociationConfig) IPGroups() []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, rule := range n.BaselineRules {
+		for _, address := range append(append([]string{}, rule.Sources...), rule.Destinations...) {
+			if IsIPGroupID(address) && !seen[strings.ToLower(address)] {
+				seen[strings.ToLower(address)] = true
+				ids = append(ids, address)
+			}
+		}
+	}
+	return ids
+}
+
+// Directions, accesses and protocols of NSG rules.
+const (
+	NSGRuleInbound  = "Inbound"
+	NSGRuleOutbound = "Outbound"
+	NSGRuleAllow    = "Allow"
+	NSGRuleDeny     = "Deny"
+	// NSGRuleAny is any protocol, address or port.
+	NSGRuleAny = "*"
+)
+
+// Priorities of custom NSG rules.
+const (
+	minNSGRulePriority = 100
+	maxNSGRulePriority = 4096
+)
+
+// nsgRuleProtocols are the protocols of NSG rules.
+var nsgRuleProtocols = []string{NSGRuleAny, "Tcp", "Udp", "Icmp", "Esp", "Ah"}
+
+// NSGRuleConfig represents a security rule of the NSG baseline. Addresses
+// are "*", an IP address or CIDR prefix, a service tag such as AzureMonitor
+// or Storage.WestEurope, or the resource ID of an IP Group.
+type NSGRuleConfig struct {
+	Name      string `json:"name"`
+	Direction string `json:"direction"`
+	Access    string `json:"access"`
+	// Protocol is one of nsgRuleProtocols, * if unset.
+	Protocol     string   `json:"protocol,omitempty"`
+	Sources      []string `json:"sources"`
+	Destinations []string `json:"destinations"`
+	// SourcePorts are ports or port ranges such as 8000-8080, * if unset.
+	SourcePorts      []string `json:"sourcePorts,omitempty"`
+	DestinationPorts []string `json:"destinationPorts"`
+	// Priority is the priority the rule must have, any if unset.
+	Priority int `json:"priority,omitempty"`
+}
+
+// EffectiveProtocol returns the protocol of the rule.
+func (r *NSGRuleConfig) EffectiveProtocol() string {
+	if r.Protocol == "" {
+		return NSGRuleAny
+	}
+	return r.Protocol
+}
+
+// EffectiveSourcePorts returns the source ports of the rule.
+func (r *NSGRuleConfig) EffectiveSourcePorts() []string {
+	if len(r.SourcePorts) == 0 {
+		return []string{NSGRuleAny}
+	}
+	return r.SourcePorts
+}
+
+// validate checks the rule, its service tags against the built-in ones and
+// the additional ones.
+func (r *NSGRuleConfig) validate(additionalServiceTags []string) error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !slices.Contains([]string{NSGRuleInbound, NSGRuleOutbound}, r.Direction) {
+		return fmt.Errorf("unknown direction %q of rule %s, allowed values are %s, %s", r.Direction, r.Name, NSGRuleInbound, NSGRuleOutbound)
+	}
+	if !slices.Contains([]string{NSGRuleAllow, NSGRuleDeny}, r.Access) {
+		return fmt.Errorf("unknown access %q of rule %s, allowed values are %s, %s", r.Access, r.Name, NSGRuleAllow, NSGRuleDeny)
+	}
+	if !slices.Contains(nsgRuleProtocols, r.EffectiveProtocol()) {
+		return fmt.Errorf("unknown protocol %q of rule %s, allowed values are %s", r.Protocol, r.Name, strings.Join(nsgRuleProtocols, ", "))
+	}
+	if r.Priority != 0 && (r.Priority < minNSGRulePriority || r.Priority > maxNSGRulePriority) {
+		return fmt.Errorf("invalid priority %d of rule %s, must be within %d-%d", r.Priority, r.Name, minNSGRulePriority, maxNSGRulePriority)
+	}
+	if len(r.Sources) == 0 || len(r.Destinations) == 0 || len(r.DestinationPorts) == 0 {
+		return fmt.Errorf("sources, destinations and destinationPorts of rule %s are required", r.Name)
+	}
+	for _, address := range append(append([]string{}, r.Sources...), r.Destinations...) {
+		if err := validateNSGAddress(address, additionalServiceTags); err != nil {
+			return fmt.Errorf("invalid address of rule %s: %w", r.Name, err)
+		}
+	}
+	for _, ports := range append(append([]string{}, r.EffectiveSourcePorts()...), r.DestinationPorts...) {
+		if _, _, err := ParsePortRange(ports); err != nil {
+			return fmt.Errorf("invalid port of rule %s: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateNSGAddress checks an address of a baseline rule.
+func validateNSGAddress(address string, additionalServiceTags []string) error {
+	switch {
+	case address == NSGRuleAny, IsIPGroupID(address):
+		return nil
+	case strings.Contains(address, "/providers/"):
+		return fmt.Errorf("%q isn't an IP Group, the only resources rules may reference", address)
+	}
+	if _, _, err := net.ParseCIDR(address); err == nil {
+		return nil
+	}
+	if net.ParseIP(address) != nil {
+		return nil
+	}
+	if !IsServiceTag(address, additionalServiceTags) {
+		return fmt.Errorf("unknown service tag %q, add it to additionalServiceTags if Azure added it since", address)
+	}
+	return nil
+}
+
+// IsIPGroupID reports whether the address is the resource ID of an IP Group.
+func IsIPGroupID(address string) bool {
+	return strings.Contains(strings.ToLower(address), "/providers/microsoft.network/ipgroups/")
+}
+
+// ParsePortRange parses "*", a port, or a port range such as 8000-8080 into
+// its first and last port.
+func ParsePortRange(ports string) (int, int, error) {
+	if ports == NSGRuleAny {
+		return 0, 65535, nil
+	}
+	first, last, isRange := strings.Cut(ports, "-")
+	from, err := strconv.Atoi(first)
+	if err != nil || from < 0 || from > 65535 {
+		return 0, 0, fmt.Errorf("invalid port %q", ports)
+	}
+	if !isRange {
+		return from, from, nil
+	}
+	to, err := strconv.Atoi(last)
+	if err != nil || to < from || to > 65535 {
+		return 0, 0, fmt.Errorf("invalid port range %q", ports)
+	}
+	return from, to, nil
+}
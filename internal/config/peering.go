@@ -0,0 +1,291 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/akos011221/velora/internal/naming"
+)
+
+// Placeholders of the peering naming templates.
+const (
+	PeeringPlaceholderSpokeVNet = "{spokeVnet}"
+	PeeringPlaceholderHub       = "{hub}"
+	PeeringPlaceholderHubVNet   = "{hubVnet}"
+)
+
+// Default peering naming templates, the names velora always created.
+const (
+	DefaultSpokePeeringTemplate = "{spokeVnet}-to-{hubVnet}"
+	DefaultHubPeeringTemplate   = "{hubVnet}-to-{spokeVnet}"
+)
+
+// Orders of re-creating both sides of a misnamed hub peering.
+const (
+	RecreateHubFirst   = "hubFirst"
+	RecreateSpokeFirst = "spokeFirst"
+)
+
+// PeeringConfig represents the naming of the hub peerings velora creates.
+type PeeringConfig struct {
+	// SpokeNameTemplate names the spoke side of hub peerings, e.g.
+	// "peer-{spokeVnet}-to-{hub}". DefaultSpokePeeringTemplate if unset.
+	SpokeNameTemplate string `json:"spokeNameTemplate,omitempty"`
+	// HubNameTemplate names the hub side of hub peerings, e.g.
+	// "peer-{hub}-to-{spokeVnet}". DefaultHubPeeringTemplate if unset.
+	HubNameTemplate string `json:"hubNameTemplate,omitempty"`
+	// RecreateMisnamedPeerings deletes and re-creates connected hub
+	// peerings not named by the templates. Peerings can't be renamed, the
+	// spoke loses connectivity to the hub until both sides are re-created,
+	// so it is only done within the maintenance window.
+	RecreateMisnamedPeerings bool `json:"recreateMisnamedPeerings"`
+	// RecreateOrder is the side re-created first: hubFirst (the default) or
+	// spokeFirst.
+	RecreateOrder string `json:"recreateOrder,omitempty"`
+	// MaintenanceWindow is when misnamed peerings may be re-created,
+	// required with recreateMisnamedPeerings.
+	MaintenanceWindow *MaintenanceWindow `json:"maintenanceWindow,omitempty"`
+}
+
+// MaintenanceWindow is a daily window in local time, e.g. 02:00 to 04:00,
+// optionally restricted to some days of the week. A window ending before
+// it starts spans midnight. Across DST transitions the window follows the
+// wall clock: it is an hour shorter or longer on those days.
+type MaintenanceWindow struct {
+	// Days are the days of the week the window starts on, e.g. "Saturday",
+	// every day if empty.
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+	// Timezone is the IANA time zone of the window, defaultTimezone if
+	// unset.
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// EffectiveSpokeNameTemplate returns the template naming the spoke side of
+// hub peerings.
+func (p *PeeringConfig) EffectiveSpokeNameTemplate() string {
+	if p.SpokeNameTemplate == "" {
+		return DefaultSpokePeeringTemplate
+	}
+	return p.SpokeNameTemplate
+}
+
+// EffectiveHubNameTemplate returns the template naming the hub side of hub
+// peerings.
+func (p *PeeringConfig) EffectiveHubNameTemplate() string {
+	if p.HubNameTemplate == "" {
+		return DefaultHubPeeringTemplate
+	}
+	return p.HubNameTemplate
+}
+
+// EffectiveRecreateOrder returns the side of a misnamed peering re-created
+// first.
+func (p *PeeringConfig) EffectiveRecreateOrder() string {
+	if p.RecreateOrder == "" {
+		return RecreateHubFirst
+	}
+	return p.RecreateOrder
+}
+
+// SpokePeeringName returns the name of the spoke side of the peering of the
+// spoke VNet with the hub.
+func (p *PeeringConfig) SpokePeeringName(spokeVNet string, hub *HubVNetConfig) (string, error) {
+	return peeringName(p.EffectiveSpokeNameTemplate(), spokeVNet, hub)
+}
+
+// HubPeeringName returns the name of the hub side of the peering of the
+// spoke VNet with the hub.
+func (p *PeeringConfig) HubPeeringName(spokeVNet string, hub *HubVNetConfig) (string, error) {
+	return peeringName(p.EffectiveHubNameTemplate(), spokeVNet, hub)
+}
+
+// peeringName expands the naming template. Names breaking the Azure naming
+// rules, e.g. because the VNet names are too long, are adjusted like the
+// other names velora computes.
+func peeringName(template, spokeVNet string, hub *HubVNetConfig) (string, error) {
+	return naming.Expand(template, peeringNameValues(spokeVNet, hub.Name, hubVNetName(hub.VNetID)))
+}
+
+// peeringNameValues returns the values of the placeholders of the peering
+// naming templates.
+func peeringNameValues(spokeVNet, hub, hubVNet string) map[string]string {
+	return map[string]string{
+		strings.Trim(PeeringPlaceholderSpokeVNet, "{}"): spokeVNet,
+		strings.Trim(PeeringPlaceholderHub, "{}"):       hub,
+		strings.Trim(PeeringPlaceholderHubVNet, "{}"):   hubVNet,
+	}
+}
+
+// hubVNetName returns the VNet name of the hub VNet ID.
+func hubVNetName(vnetID string) string {
+	return vnetID[strings.LastIndex(vnetID, "/")+1:]
+}
+
+// validate checks the peering configuration.
+func (p *PeeringConfig) validate() error {
+	for _, t := range []struct {
+		field, template string
+		required        []string
+	}{
+		{"peering.spokeNameTemplate", p.EffectiveSpokeNameTemplate(), []string{PeeringPlaceholderHub, PeeringPlaceholderHubVNet}},
+		{"peering.hubNameTemplate", p.EffectiveHubNameTemplate(), []string{PeeringPlaceholderSpokeVNet}},
+	} {
+		if err := validatePeeringTemplate(t.template, t.required); err != nil {
+			return fmt.Errorf("%s: %w", t.field, err)
+		}
+	}
+
+	switch p.EffectiveRecreateOrder() {
+	case RecreateHubFirst, RecreateSpokeFirst:
+	default:
+		return fmt.Errorf("unknown peering.recreateOrder %q, allowed values are %s, %s", p.RecreateOrder, RecreateHubFirst, RecreateSpokeFirst)
+	}
+	if p.RecreateMisnamedPeerings && p.MaintenanceWindow == nil {
+		return fmt.Errorf("peering.maintenanceWindow is required with peering.recreateMisnamedPeerings")
+	}
+	if p.MaintenanceWindow != nil {
+		if err := p.MaintenanceWindow.validate(); err != nil {
+			return fmt.Errorf("peering.maintenanceWindow: %w", err)
+		}
+	}
+	return nil
+}
+
+// validatePeeringTemplate checks that the template produces valid names
+// from known placeholders, and contains one of the required ones, so the
+// names are unique within the VNet.
+func validatePeeringTemplate(template string, required []string) error {
+	if err := naming.ValidateTemplate(template); err != nil {
+		return err
+	}
+	if _, err := naming.Expand(template, peeringNameValues("spoke", "hub", "hub")); err != nil {
+		return err
+	}
+	for _, placeholder := range required {
+		if strings.Contains(template, placeholder) {
+			return nil
+		}
+	}
+	return fmt.Errorf("template %q must contain %s", template, strings.Join(required, " or "))
+}
+
+// validate checks the maintenance window.
+func (w *MaintenanceWindow) validate() error {
+	if _, err := time.Parse("15:04", w.Start); err != nil {
+		return fmt.Errorf("start %q is not a time of day like 02:00", w.Start)
+	}
+	if _, err := time.Parse("15:04", w.End); err != nil {
+		return fmt.Errorf("end %q is not a time of day like 04:00", w.End)
+	}
+	if w.Start == w.End {
+		return fmt.Errorf("start and end must differ")
+	}
+	if err := validateTimezone(w.Timezone, "timezone"); err != nil {
+		return err
+	}
+	for _, day := range w.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("unknown day %q", day)
+		}
+	}
+	return nil
+}
+
+// weekdays are the days of the week by lower-case name.
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// Location returns the location of the window, defaultLocation unless it
+// has its own time zone.
+func (w *MaintenanceWindow) Location(defaultLocation *time.Location) *time.Location {
+	if w.Timezone == "" {
+		return defaultLocation
+	}
+	return location(w.Timezone)
+}
+
+// Contains reports whether the time is within the window, in its location
+// or defaultLocation. The window was validated.
+func (w *MaintenanceWindow) Contains(t time.Time, defaultLocation *time.Location) bool {
+	t = t.In(w.Location(defaultLocation))
+	start, _ := time.Parse("15:04", w.Start)
+	end, _ := time.Parse("15:04", w.End)
+	minute := t.Hour()*60 + t.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	// the day the window containing t started
+	day := t.Weekday()
+	switch {
+	case startMinute < endMinute:
+		if minute < startMinute || minute >= endMinute {
+			return false
+		}
+	case minute >= startMinute:
+	case minute < endMinute:
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+	return w.startsOn(day)
+}
+
+// startsOn reports whether the window starts on the day of the week.
+func (w *MaintenanceWindow) startsOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStart returns when the window next opens after now. A start skipped
+// by a DST transition opens the window when the clock is moved forward, a
+// start repeated by one opens it the first time.
+func (w *MaintenanceWindow) NextStart(now time.Time, defaultLocation *time.Location) time.Time {
+	loc := w.Location(defaultLocation)
+	local := now.In(loc)
+	start, _ := time.Parse("15:04", w.Start)
+	for i := 0; i <= 7; i++ {
+		day := local.AddDate(0, 0, i)
+		at := startOn(day, start, loc)
+		if at.After(now) && w.startsOn(day.Weekday()) {
+			return at
+		}
+	}
+	return time.Time{}
+}
+
+// startOn returns when the time of day is first on the wall clock on the
+// day, or when the clock is moved forward over it.
+func startOn(day, start time.Time, loc *time.Location) time.Time {
+	at := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, loc)
+	zoneStart, _ := at.ZoneBounds()
+	if at.Hour() != start.Hour() || at.Minute() != start.Minute() {
+		return zoneStart
+	}
+	if zoneStart.IsZero() {
+		return at
+	}
+	// when the clock was moved back, the same time an offset earlier
+	_, offset := at.Zone()
+	_, before := zoneStart.Add(-time.Second).Zone()
+	if first := at.Add(time.Duration(offset-before) * time.Second); first.Before(zoneStart) {
+		return first
+	}
+	return at
+}
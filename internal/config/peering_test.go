@@ -0,0 +1,168 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+)
+
+func TestPeeringNames(t *testing.T) {
+	hub := &config.HubVNetConfig{Name: "weu", VNetID: configtest.HubVNetID}
+	tests := []struct {
+		name      string
+		peering   config.PeeringConfig
+		spokeVNet string
+		wantSpoke string
+		wantHub   string
+	}{
+		{
+			name:      "default templates",
+			spokeVNet: "app",
+			wantSpoke: "app-to-hub-vnet",
+			wantHub:   "hub-vnet-to-app",
+		},
+		{
+			name:      "custom templates",
+			peering:   config.PeeringConfig{SpokeNameTemplate: "peer-{spokeVnet}-to-{hub}", HubNameTemplate: "peer-{hub}-to-{spokeVnet}"},
+			spokeVNet: "app",
+			wantSpoke: "peer-app-to-weu",
+			wantHub:   "peer-weu-to-app",
+		},
+		{
+			// the name is shortened to the 80 characters of the Azure naming
+			// rules, keeping a hash of the full name
+			name:      "long VNet name",
+			spokeVNet: strings.Repeat("v", 90),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spoke, err := tt.peering.SpokePeeringName(tt.spokeVNet, hub)
+			if err != nil {
+				t.Fatalf("SpokePeeringName() error = %v", err)
+			}
+			hubSide, err := tt.peering.HubPeeringName(tt.spokeVNet, hub)
+			if err != nil {
+				t.Fatalf("HubPeeringName() error = %v", err)
+			}
+			if tt.wantSpoke != "" && spoke != tt.wantSpoke {
+				t.Errorf("SpokePeeringName() = %q, want %q", spoke, tt.wantSpoke)
+			}
+			if len(spoke) > 80 || len(hubSide) > 80 {
+				t.Errorf("names %q and %q break the 80 character limit", spoke, hubSide)
+			}
+			if tt.wantHub != "" && hubSide != tt.wantHub {
+				t.Errorf("HubPeeringName() = %q, want %q", hubSide, tt.wantHub)
+			}
+		})
+	}
+}
+
+func TestValidatePeering(t *testing.T) {
+	window := &config.MaintenanceWindow{Start: "02:00", End: "04:00"}
+	tests := []struct {
+		name    string
+		peering config.PeeringConfig
+		wantErr string
+	}{
+		{name: "defaults"},
+		{name: "custom templates", peering: config.PeeringConfig{SpokeNameTemplate: "peer-{spokeVnet}-to-{hub}", HubNameTemplate: "peer-{hub}-to-{spokeVnet}"}},
+		{
+			// every spoke would get the same hub side name
+			name:    "hub template without the spoke",
+			peering: config.PeeringConfig{HubNameTemplate: "peer-{hub}"},
+			wantErr: "must contain {spokeVnet}",
+		},
+		{
+			name:    "spoke template without the hub",
+			peering: config.PeeringConfig{SpokeNameTemplate: "peer-{spokeVnet}"},
+			wantErr: "must contain {hub} or {hubVnet}",
+		},
+		{
+			name:    "unknown placeholder",
+			peering: config.PeeringConfig{SpokeNameTemplate: "{spokeVnet}-to-{region}-{hub}"},
+			wantErr: "peering.spokeNameTemplate",
+		},
+		{
+			name:    "invalid characters",
+			peering: config.PeeringConfig{HubNameTemplate: "{hub}/{spokeVnet}"},
+			wantErr: "peering.hubNameTemplate",
+		},
+		{name: "recreate within a window", peering: config.PeeringConfig{RecreateMisnamedPeerings: true, MaintenanceWindow: window, RecreateOrder: config.RecreateSpokeFirst}},
+		{
+			name:    "recreate without a window",
+			peering: config.PeeringConfig{RecreateMisnamedPeerings: true},
+			wantErr: "maintenanceWindow is required",
+		},
+		{
+			name:    "unknown order",
+			peering: config.PeeringConfig{RecreateOrder: "both"},
+			wantErr: "unknown peering.recreateOrder",
+		},
+		{
+			name:    "invalid window",
+			peering: config.PeeringConfig{MaintenanceWindow: &config.MaintenanceWindow{Start: "2am", End: "04:00"}},
+			wantErr: "peering.maintenanceWindow",
+		},
+		{
+			name:    "unknown day",
+			peering: config.PeeringConfig{MaintenanceWindow: &config.MaintenanceWindow{Days: []string{"Caturday"}, Start: "02:00", End: "04:00"}},
+			wantErr: `unknown day "Caturday"`,
+		},
+		{
+			name:    "unknown window time zone",
+			peering: config.PeeringConfig{MaintenanceWindow: &config.MaintenanceWindow{Start: "02:00", End: "04:00", Timezone: "Europe/Atlantis"}},
+			wantErr: "peering.maintenanceWindow",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t)
+			cfg.Peering = tt.peering
+
+			err := cfg.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowContains(t *testing.T) {
+	// 2026-10-17 is a Saturday
+	saturday := func(hour, minute int) time.Time { return time.Date(2026, 10, 17, hour, minute, 0, 0, time.UTC) }
+	tests := []struct {
+		name   string
+		window config.MaintenanceWindow
+		t      time.Time
+		want   bool
+	}{
+		{name: "inside", window: config.MaintenanceWindow{Start: "02:00", End: "04:00"}, t: saturday(3, 0), want: true},
+		{name: "at the start", window: config.MaintenanceWindow{Start: "02:00", End: "04:00"}, t: saturday(2, 0), want: true},
+		{name: "at the end", window: config.MaintenanceWindow{Start: "02:00", End: "04:00"}, t: saturday(4, 0)},
+		{name: "before", window: config.MaintenanceWindow{Start: "02:00", End: "04:00"}, t: saturday(1, 59)},
+		{name: "day of the window", window: config.MaintenanceWindow{Days: []string{"Saturday"}, Start: "02:00", End: "04:00"}, t: saturday(3, 0), want: true},
+		{name: "other day", window: config.MaintenanceWindow{Days: []string{"sunday"}, Start: "02:00", End: "04:00"}, t: saturday(3, 0)},
+		{name: "spanning midnight, before it", window: config.MaintenanceWindow{Days: []string{"Saturday"}, Start: "23:00", End: "01:00"}, t: saturday(23, 30), want: true},
+		// the window started on Friday
+		{name: "spanning midnight, after it", window: config.MaintenanceWindow{Days: []string{"Saturday"}, Start: "23:00", End: "01:00"}, t: saturday(0, 30)},
+		{name: "spanning midnight, outside", window: config.MaintenanceWindow{Start: "23:00", End: "01:00"}, t: saturday(12, 0)},
+		{name: "time zone of the window", window: config.MaintenanceWindow{Start: "04:00", End: "06:00", Timezone: "Europe/Budapest"}, t: saturday(3, 0), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Contains(tt.t, time.UTC); got != tt.want {
+				t.Errorf("Contains(%s) = %t, want %t", tt.t, got, tt.want)
+			}
+		})
+	}
+}
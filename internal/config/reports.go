@@ -0,0 +1,40 @@
+package config
+
+import "fmt"
+
+// ReportsConfig represents the report configuration.
+type ReportsConfig struct {
+	// IncludeCompliant lists compliant resources in JSON reports, true if unset.
+	// Notifications never include them.
+	IncludeCompliant *bool `json:"includeCompliant"`
+	// MaxCompliantRecords caps the compliant resources a run keeps in
+	// memory, DefaultMaxCompliantRecords if unset. Resources beyond it are
+	// still counted.
+	MaxCompliantRecords int `json:"maxCompliantRecords,omitempty"`
+}
+
+// DefaultMaxCompliantRecords is the default cap of the compliant resources a
+// run keeps in memory.
+const DefaultMaxCompliantRecords = 10000
+
+// EffectiveMaxCompliantRecords returns the cap of the compliant resources a
+// run keeps in memory.
+func (r *ReportsConfig) EffectiveMaxCompliantRecords() int {
+	if r.MaxCompliantRecords <= 0 {
+		return DefaultMaxCompliantRecords
+	}
+	return r.MaxCompliantRecords
+}
+
+// IncludesCompliant reports whether compliant resources are listed in reports.
+func (r *ReportsConfig) IncludesCompliant() bool {
+	return r.IncludeCompliant == nil || *r.IncludeCompliant
+}
+
+// validate checks the compliant record limit.
+func (r *ReportsConfig) validate() error {
+	if r.MaxCompliantRecords < 0 {
+		return fmt.Errorf("reports.maxCompliantRecords must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// RuleConfig represents the per-rule configuration, keyed by rule ID.
+type RuleConfig struct {
+	DocsURL string `json:"docsUrl"`
+	// SeverityModifiers adjust the severity of the rule's findings by the
+	// context of their VNet.
+	SeverityModifiers *SeverityModifiers `json:"severityModifiers,omitempty"`
+}
+
+// MaxSeverityModifier bounds a severity modifier, the span from info to
+// critical.
+const MaxSeverityModifier = 4
+
+// SeverityModifiers are the severity steps added to a finding by the
+// context of its VNet, negative steps lower it. Severities are clamped
+// between info and critical.
+type SeverityModifiers struct {
+	// HubConnected applies to VNets with a Connected peering with a hub.
+	HubConnected int `json:"hubConnected,omitempty"`
+	// NotHubConnected applies to VNets not peered with any hub.
+	NotHubConnected int `json:"notHubConnected,omitempty"`
+}
+
+// validate checks the modifiers are within MaxSeverityModifier.
+func (m *SeverityModifiers) validate() error {
+	for name, steps := range map[string]int{"hubConnected": m.HubConnected, "notHubConnected": m.NotHubConnected} {
+		if steps < -MaxSeverityModifier || steps > MaxSeverityModifier {
+			return fmt.Errorf("severityModifiers.%s must be between -%d and %d", name, MaxSeverityModifier, MaxSeverityModifier)
+		}
+	}
+	return nil
+}
+
+// HasSeverityModifiers reports whether any rule adjusts the severity of its
+// findings.
+func (c *Config) HasSeverityModifiers() bool {
+	for _, rule := range c.Rules {
+		if rule.SeverityModifiers != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRules checks the documentation links and severity modifiers of
+// the rules.
+func (c *Config) validateRules() error {
+	for ruleID, rule := range c.Rules {
+		if rule.DocsURL != "" {
+			if u, err := url.ParseRequestURI(rule.DocsURL); err != nil || u.Host == "" {
+				return fmt.Errorf("invalid docsUrl for rule %s: %s", ruleID, rule.DocsURL)
+			}
+		}
+		if rule.SeverityModifiers != nil {
+			if err := rule.SeverityModifiers.validate(); err != nil {
+				return fmt.Errorf("invalid rule %s: %w", ruleID, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// SchemaDialect is the JSON Schema draft of Schema.
+const SchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaAnnotation is what reflection can't derive for a field: its
+// description, allowed values and the properties Validate requires when the
+// field is set.
+type schemaAnnotation struct {
+	description string
+	enum        []any
+	required    []string
+}
+
+// severityEnum are the severities of findings.
+var severityEnum = []any{"critical", "high", "medium", "low", "info"}
+
+// stringEnum returns the values as allowed values of a schema.
+func stringEnum(values []string) []any {
+	enum := make([]any, len(values))
+	for i, v := range values {
+		enum[i] = v
+	}
+	return enum
+}
+
+// schemaAnnotations annotate the fields by path: the JSON names of the
+// fields, "[]" for the items of arrays and "{}" for the values of maps,
+// e.g. "hubs[].type" or "subscriptions{}.environment".
+var schemaAnnotations = map[string]schemaAnnotation{
+	"":         {description: "velora configuration"},
+	"version":  {description: "Format version of the file, 1 if unset. Older versions are migrated when loaded, velora config migrate --write rewrites the file in the current version."},
+	"includes": {description: "Glob patterns of files merged into this one, relative to it. Included files may only define hubs and subscriptions."},
+	"azure":    {description: "Authentication against Azure."},
+	"api.listenAddress": {
+		description: "Address the web UI listens on, 127.0.0.1 if unset. velora ui refuses a non-loopback address unless api.tokenSha256 is set.",
+	},
+	"api.tokenSha256": {
+		description: "Hex-encoded SHA-256 of the token the web UI requires, as a bearer token or the password of basic auth.",
+	},
+	"api.uiEnabled": {
+		description: "Serves the read-only web UI under /ui with velora ui, and keeps the recent runs with their findings for it.",
+	},
+	"hubs": {description: "The hubs spokes are peered with and routed through. Required unless hubDiscovery is enabled."},
+	"hubs[].type": {
+		description: "classic for a hub VNet with an NVA or firewall, virtualWAN for a virtual WAN hub.",
+		enum:        []any{HubTypeClassic, HubTypeVirtualWAN},
+	},
+	"hubs[].nextHopSource": {
+		description: "Takes the NVA next hop from the private IP of an Azure Firewall instead of nvaNextHop.",
+		required:    []string{"azureFirewallId"},
+	},
+	"hubs[].addressPrefixes": {
+		description: "Address prefixes of the hub VNet, IPv4 or IPv6, used when they can't be read from Azure. They must not overlap and must contain the NVA IPs.",
+	},
+	"hubs[].subnetClasses": {
+		description: "Classes of the spokes' subnets, by subnet name pattern or VNet tags, whose routing policy differs from their subscription's.",
+	},
+	"hubs[].subnetClasses[]": {
+		description: "Matches subnets whose name matches one of subnetNames and whose VNet has all vnetTags.",
+		required:    []string{"name"},
+	},
+	"hubs[].subnetClasses[].subnetNames": {
+		description: "Case-insensitive glob patterns of subnet names, e.g. integration-*.",
+	},
+	"hubs[].subnetClasses[].vnetTags": {
+		description: "Tags the VNet of the subnet must all have, subnets have no tags of their own.",
+	},
+	"hubs[].subnetClasses[].priority": {
+		description: "The highest priority wins between classes matching the same subnet. A tie is a conflict, the subnet isn't routed.",
+	},
+	"hubs[].subnetClasses[].defaultRoute": {
+		description: "Enforce the default route to the NVA on the class, requireNvaRouting of the subscription if unset.",
+	},
+	"hubs[].subnetClasses[].subnetIsolation": {
+		description: "Route the traffic of the class to the other subnets of its VNet through the NVA, subnetToSubnetDeny of the subscription if unset.",
+	},
+	"hubs[].subnetClasses[].bgpPropagation": {
+		description: "BGP route propagation the route tables of the class must have, not checked if unset.",
+		enum:        []any{BGPPropagationEnabled, BGPPropagationDisabled},
+	},
+	"hubs[].flowLogs": {
+		description: "Flow logs created for the spokes of the hub.",
+		required:    []string{"storageAccountId"},
+	},
+	"hubs[].flowLogs.trafficAnalytics": {
+		required: []string{"workspaceResourceId", "workspaceId", "workspaceRegion"},
+	},
+	"hubs[].flowLogs.trafficAnalytics.intervalMinutes": {
+		description: "Processing interval of traffic analytics, 60 if unset.",
+		enum:        []any{10, 60},
+	},
+	"hubs[].capacity": {
+		description: "Number of spokes and of their address prefixes the hub was designed for. Scans warn as they approach the limits and report a critical finding past them.",
+	},
+	"hubs[].virtualWan": {
+		description: "The virtual hub of a virtualWAN hub, required for that type.",
+		required:    []string{"virtualHubId", "nextHopId"},
+	},
+	"hubDiscovery":  {description: "Builds hubs from the tags of the hub VNets in the connectivity subscriptions."},
+	"subscriptions": {description: "The spoke subscriptions by subscription ID."},
+	"subscriptions{}.environment": {
+		description: "Whether the subscription hosts production workloads.",
+		enum:        []any{EnvironmentProd, EnvironmentNonProd},
+	},
+	"subscriptions{}.nsgAssociation": {
+		description: "The NSG every subnet of the subscription must be associated with.",
+		required:    []string{"mode"},
+	},
+	"subscriptions{}.nsgAssociation.mode": {
+		enum: []any{NSGAssociationAny, NSGAssociationSpecific},
+	},
+	"subscriptions{}.nsgAssociation.baselineRules[]": {
+		description: "A security rule the NSGs of the subnets must contain, matched by its semantics rather than its name. Addresses are *, IP addresses, CIDR prefixes, service tags or IP Group resource IDs.",
+		required:    []string{"name", "direction", "access", "sources", "destinations", "destinationPorts"},
+	},
+	"subscriptions{}.nsgAssociation.baselineRules[].direction": {
+		enum: []any{NSGRuleInbound, NSGRuleOutbound},
+	},
+	"subscriptions{}.nsgAssociation.baselineRules[].access": {
+		enum: []any{NSGRuleAllow, NSGRuleDeny},
+	},
+	"subscriptions{}.nsgAssociation.baselineRules[].protocol": {
+		enum: []any{NSGRuleAny, "Tcp", "Udp", "Icmp", "Esp", "Ah"},
+	},
+	"subscriptions{}.nsgAssociation.compareExpanded":       {description: "Compare the IP Groups of the baseline rules with the address prefixes of the NSG rules by their addresses."},
+	"subscriptions{}.nsgAssociation.additionalServiceTags": {description: "Service tags accepted in addition to the built-in ones, for tags Azure added since."},
+	"subscriptions{}.forbiddenNextHops":                    {description: "Next hop types routes of spoke route tables must not use."},
+	"subscriptions{}.forbiddenNextHops.types[]": {
+		enum: []any{"VirtualNetworkGateway", "Internet", "VnetLocal", "None"},
+	},
+	"subscriptions{}.forbiddenNextHops.remediationAction": {
+		enum: []any{ForbiddenNextHopReport, ForbiddenNextHopDelete, ForbiddenNextHopRewrite},
+	},
+	"subscriptions{}.serviceRouteTables": {
+		description: "How route tables managed by services like AKS and Databricks are handled: coexist adds velora's routes without touching the service's, exclude leaves them alone, reportOnly only reports them. Defaults to coexist.",
+		enum:        []any{ServiceRouteTablesCoexist, ServiceRouteTablesExclude, ServiceRouteTablesReportOnly},
+	},
+	"subscriptions{}.subnetIsolationBand": {description: "Also enforces subnetToSubnetDeny with NSG rules denying the traffic between the subnets of each spoke VNet, in a priority band velora owns. Velora only writes rules inside the band and reports any other rule inside it. Defaults to 4000-4095."},
+	"serviceRouteMatchers":                {description: "Detect the route tables and routes of more services, in addition to the built-in AKS and Databricks matchers."},
+	"serviceRouteMatchers[]": {
+		description: "All the criteria set must match. A route table is the service's if it matches, or if one of its routes matches routeNamePrefix.",
+		required:    []string{"service"},
+	},
+	"configStaging":                      {description: "Holds back a changed config whose estimated impact on the latest inventory snapshot is too large, until it is activated with velora config activate."},
+	"configStaging.maxImpactedResources": {description: "How many resources a config change may make non-compliant or rewrite and still apply automatically. Defaults to 50."},
+	"subscriptions{}.nicPublicIpScan":    {description: "Flags the NICs with a public IP in the spoke subnets of a requireNVARouting subscription, with features.complianceScanning. It lists every NIC of the subscription, false turns it off. Defaults to true."},
+	"features":                           {description: "Enables the controllers."},
+	"features.complianceScanning":        {description: "Reports the egress bypassing the NVA of requireNVARouting subscriptions: NAT gateways on spoke subnets and NICs with public IPs. Report-only."},
+
+	"rules": {description: "Overrides of the finding rules by rule ID."},
+	"notifications.email": {
+		description: "Notifications of findings by SMTP.",
+		required:    []string{"host", "port", "from", "to"},
+	},
+	"notifications.email.tlsMode": {enum: []any{"none", "starttls", "tls"}},
+	"notifications.email.mode": {
+		description: "immediate sends one email per run, digest aggregates findings until the next digest.",
+		enum:        []any{"immediate", "digest"},
+	},
+	"notifications.email.minSeverity":        {enum: severityEnum},
+	"notifications.throttle":                 {description: "Suppresses the alerts of findings already notified, by rule and resource, and notifies when they resolve. Digests still include every finding."},
+	"notifications.throttle.renotifyHours":   {description: "Hours after its alert an open finding is alerted again, by severity: critical, high, medium, low or info. Severities without one are alerted once until they resolve."},
+	"notifications.issues":                   {description: "Files the findings in Azure Boards or GitHub issues: an item per open finding, updated while reported and closed once resolved. The finding key in the title keeps re-runs from filing duplicates. A tracker failing never fails the run."},
+	"notifications.issues.minSeverity":       {enum: severityEnum},
+	"notifications.issues.rules":             {description: "Rule IDs whose findings are filed, every rule if empty."},
+	"notifications.issues.requestsPerMinute": {description: "Requests sent to each tracker per minute, 60 if unset. Throttled requests are retried."},
+	"notifications.issues.dryRun":            {description: "Prints the items a run would create, update and close without calling the trackers."},
+	"notifications.issues.azureDevOps":       {required: []string{"organization", "project"}},
+	"notifications.issues.azureDevOps.auth": {
+		description: "pat authenticates with the personal access token in tokenEnv, managedIdentity with the Azure credential of velora.",
+		enum:        []any{AzureDevOpsAuthPAT, AzureDevOpsAuthManagedIdentity},
+	},
+	"notifications.issues.github":          {description: "Authenticates with the personal access token in tokenEnv or as a GitHub App installation.", required: []string{"repository"}},
+	"notifications.issues.github.app":      {required: []string{"appId", "installationId", "privateKeyEnv"}},
+	"notifications.issues.routes[]":        {description: "Files the findings of the subscriptions whose ownership matches every owner, team and ticketQueue set, in another project, area path or repository, with more labels."},
+	"notifications.webhooks":               {description: "Endpoints signed events are posted to as JSON. Each delivery carries X-Velora-Signature: t=TIMESTAMP,v1=SIGNATURE, the hex HMAC-SHA256 of TIMESTAMP.BODY with the secret, TIMESTAMP being Unix seconds. Failed deliveries are retried with backoff, then kept in the dead-letter log, see velora webhook deadletter."},
+	"notifications.webhooks[]":             {required: []string{"name", "url", "secretEnv"}},
+	"notifications.webhooks[].url":         {description: "HTTPS URL of the endpoint, HTTP only for localhost."},
+	"notifications.webhooks[].secretEnv":   {description: "Environment variable holding the secret the deliveries are signed with."},
+	"notifications.webhooks[].events":      {description: "Event types posted, every type if empty."},
+	"notifications.webhooks[].events[]":    {enum: stringEnum(WebhookEvents)},
+	"notifications.webhooks[].minSeverity": {description: "Lowest severity of the finding events posted, every severity if unset.", enum: severityEnum},
+	"state":                                {description: "Where velora keeps its state between runs."},
+	"slo.thresholdHours":                   {description: "Hours an open finding may stay open before it breaches the SLO, by severity: critical, high, medium, low or info."},
+	"scoring.severityWeights":              {description: "How much a finding weighs against a compliant resource in the posture score, by severity: critical, high, medium, low or info. Defaults are 10, 5, 2, 1 and 0."},
+	"azureMonitor": {
+		description: "Export of run statistics to a Log Analytics workspace through the Logs Ingestion API.",
+		required:    []string{"endpoint", "ruleId", "streamName"},
+	},
+	"state.backend": {
+		description: "Where the state is kept: files under state.path, the default, or an S3-compatible bucket.",
+		enum:        []any{StateBackendFile, StateBackendS3},
+	},
+	"state.s3": {
+		description: "S3-compatible bucket keeping the state, e.g. MinIO. The server must support conditional puts.",
+		required:    []string{"endpoint", "bucket"},
+	},
+	"logging.level":  {enum: []any{"debug", "info", "warn", "error"}},
+	"logging.format": {enum: []any{"json", "text"}},
+	"queue.backend": {
+		description: "Where the work queue of targeted runs is kept, state if unset.",
+		enum:        []any{QueueBackendState, QueueBackendStorage},
+	},
+	"peering": {description: "Naming of the hub peerings velora creates."},
+	"attribution": {
+		description: "Looks up who last wrote the resource of a new finding in the Activity Log and attaches the caller to the finding. Requires Microsoft.Insights/eventtypes/values/read, subscriptions without it stay unattributed.",
+	},
+	"peering.recreateOrder": {
+		enum: []any{RecreateHubFirst, RecreateSpokeFirst},
+	},
+	"peering.maintenanceWindow": {
+		required: []string{"start", "end"},
+	},
+	"peering.maintenanceWindow.timezone": {
+		description: "IANA time zone of the window, defaultTimezone if unset.",
+	},
+	"readOnly":        {description: "Disables every write, for identities granted Reader only."},
+	"defaultTimezone": {description: "IANA time zone the times of day of the configuration are in, e.g. of the maintenance window, UTC if unset. Persisted times are always UTC."},
+	"displayTimezone": {description: "IANA time zone reports, the CLI and the web UI show times in, UTC if unset."},
+	"missingHubAction": {
+		description: "What a run does with a subscription whose hub isn't configured, error if unset.",
+		enum:        []any{MissingHubError, MissingHubSkip, MissingHubReportOnly},
+	},
+	"controllerOrder": {description: "Order the controllers run in, the controllers not listed run after them in the default order."},
+	"controllerOrder[]": {
+		enum: stringEnum(DefaultControllerOrder),
+	},
+	"controllers": {description: "The controllers this instance runs, all if unset. Instances running different controllers share the state store as roles: their run history and statistics are merged, the rest of their state is kept apart."},
+	"controllers[]": {
+		enum: stringEnum(DefaultControllerOrder),
+	},
+	"breakGlass": {
+		description: "Allows activating break-glass mode with the token whose hash is configured, it can't be activated from the configuration.",
+		required:    []string{"tokenSha256"},
+	},
+	"breakGlass.tokenSha256":   {description: "Hex-encoded SHA-256 of the break-glass token, distributed out-of-band and different from the API keys."},
+	"maxUnprocessableFraction": {description: "Fraction of a subscription's resources that may have malformed IDs before the subscription fails."},
+}
+
+// Schema returns the JSON Schema of the configuration file. The properties
+// and their types are derived from Config, the descriptions, allowed values
+// and required properties come from schemaAnnotations. Unknown properties
+// are allowed, as the loader ignores them.
+func Schema() map[string]any {
+	schema := schemaOf(reflect.TypeOf(Config{}), "")
+	schema["$schema"] = SchemaDialect
+	return schema
+}
+
+// schemaOf returns the schema of the type at the path.
+func schemaOf(t reflect.Type, path string) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	schema := map[string]any{}
+	switch t.Kind() {
+	case reflect.Struct:
+		schema["type"] = "object"
+		properties := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := jsonName(field)
+			if name == "" {
+				continue
+			}
+			properties[name] = schemaOf(field.Type, joinPath(path, name))
+		}
+		schema["properties"] = properties
+	case reflect.Map:
+		schema["type"] = "object"
+		schema["additionalProperties"] = schemaOf(t.Elem(), path+"{}")
+	case reflect.Slice, reflect.Array:
+		schema["type"] = "array"
+		schema["items"] = schemaOf(t.Elem(), path+"[]")
+	case reflect.String:
+		schema["type"] = "string"
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		schema["type"] = "integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema["type"] = "integer"
+		schema["minimum"] = 0
+	case reflect.Float32, reflect.Float64:
+		schema["type"] = "number"
+	}
+
+	annotation := schemaAnnotations[path]
+	if annotation.description != "" {
+		schema["description"] = annotation.description
+	}
+	if len(annotation.enum) > 0 {
+		schema["enum"] = annotation.enum
+	}
+	if len(annotation.required) > 0 {
+		schema["required"] = annotation.required
+	}
+	return schema
+}
+
+// jsonName returns the JSON name of the struct field, empty if it isn't
+// encoded.
+func jsonName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// joinPath appends the field name to the path.
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,129 @@
+package config_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+// TestSchemaGolden keeps testdata/schema.json in sync with the Config type:
+// changing a field changes the schema, run go test -update to accept it.
+func TestSchemaGolden(t *testing.T) {
+	got, err := json.MarshalIndent(config.Schema(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+
+	golden := filepath.Join("testdata", "schema.json")
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("schema differs from %s, run go test -update to accept it", golden)
+	}
+}
+
+// schemaAt returns the schema at the path of the schema annotations.
+func schemaAt(t *testing.T, schema map[string]any, path string) map[string]any {
+	t.Helper()
+	for _, name := range strings.Split(path, ".") {
+		base := strings.TrimRight(name, "[]{}")
+		schema = schema["properties"].(map[string]any)[base].(map[string]any)
+		for _, suffix := range strings.SplitAfter(strings.TrimPrefix(name, base), "]") {
+			switch {
+			case strings.HasPrefix(suffix, "[]"):
+				schema = schema["items"].(map[string]any)
+			case strings.HasPrefix(suffix, "{}"):
+				schema = schema["additionalProperties"].(map[string]any)
+			}
+		}
+	}
+	return schema
+}
+
+// TestSchemaRequired checks that the properties the schema requires of a
+// section are the ones Validate rejects the section without.
+func TestSchemaRequired(t *testing.T) {
+	withSection := func(cfg *config.Config) {
+		sub := cfg.Subscriptions[configtest.SubscriptionID]
+		sub.NSGAssociation = &config.NSGAssociationConfig{Mode: config.NSGAssociationAny}
+		cfg.Subscriptions[configtest.SubscriptionID] = sub
+		cfg.BreakGlass = &config.BreakGlassConfig{TokenSHA256: strings.Repeat("ab", 32)}
+		cfg.State.Backend = config.StateBackendS3
+		cfg.State.S3 = &config.S3StateConfig{Endpoint: "https://s3.example.com", Bucket: "velora"}
+		cfg.AzureMonitor = &config.AzureMonitorConfig{Endpoint: "https://dce.example.com", RuleID: "dcr-1", StreamName: "Custom-Velora"}
+		cfg.Peering.MaintenanceWindow = &config.MaintenanceWindow{Start: "22:00", End: "04:00"}
+		cfg.Notifications.Webhooks = []config.WebhookConfig{{Name: "siem", URL: "https://siem.example.com", SecretEnv: "SIEM_SECRET"}}
+	}
+	tests := []struct {
+		path     string
+		property string
+		omit     func(cfg *config.Config)
+	}{
+		{
+			path:     "subscriptions{}.nsgAssociation",
+			property: "mode",
+			omit:     func(cfg *config.Config) { cfg.Subscriptions[configtest.SubscriptionID].NSGAssociation.Mode = "" },
+		},
+		{
+			path:     "breakGlass",
+			property: "tokenSha256",
+			omit:     func(cfg *config.Config) { cfg.BreakGlass.TokenSHA256 = "" },
+		},
+		{
+			path:     "state.s3",
+			property: "bucket",
+			omit:     func(cfg *config.Config) { cfg.State.S3.Bucket = "" },
+		},
+		{
+			path:     "azureMonitor",
+			property: "streamName",
+			omit:     func(cfg *config.Config) { cfg.AzureMonitor.StreamName = "" },
+		},
+		{
+			path:     "peering.maintenanceWindow",
+			property: "end",
+			omit:     func(cfg *config.Config) { cfg.Peering.MaintenanceWindow.End = "" },
+		},
+		{
+			path:     "notifications.webhooks[]",
+			property: "secretEnv",
+			omit:     func(cfg *config.Config) { cfg.Notifications.Webhooks[0].SecretEnv = "" },
+		},
+	}
+
+	schema := config.Schema()
+	// the sections are valid with their required properties
+	configtest.New(t, withSection)
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			required, _ := schemaAt(t, schema, tt.path)["required"].([]string)
+			if !slices.Contains(required, tt.property) {
+				t.Errorf("schema of %s requires %v, want %s", tt.path, required, tt.property)
+			}
+
+			cfg := configtest.New(t, withSection)
+			tt.omit(cfg)
+			if err := cfg.Validate(); err == nil {
+				t.Errorf("Validate() without %s.%s = nil, want an error", tt.path, tt.property)
+			}
+		})
+	}
+}
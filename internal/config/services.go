@@ -0,0 +1,139 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Modes of handling route tables managed by services.
+const (
+	// ServiceRouteTablesCoexist adds velora's routes alongside the
+	// service's, whose routes are never modified, deleted or taken over.
+	ServiceRouteTablesCoexist = "coexist"
+	// ServiceRouteTablesExclude leaves the route tables alone entirely.
+	ServiceRouteTablesExclude = "exclude"
+	// ServiceRouteTablesReportOnly evaluates the route tables but doesn't
+	// change them.
+	ServiceRouteTablesReportOnly = "reportOnly"
+)
+
+// ServiceRouteMatcher detects the route tables and routes a service like
+// AKS or Databricks manages. All the criteria set must match. A route table
+// is the service's if it matches, or if one of its routes matches
+// RouteNamePrefix.
+type ServiceRouteMatcher struct {
+	// Service names the service in findings, e.g. aks.
+	Service string `json:"service"`
+	// RouteNamePrefix matches the names of the service's routes.
+	RouteNamePrefix string `json:"routeNamePrefix,omitempty"`
+	// ResourceGroupPrefix matches the resource group of the route table.
+	ResourceGroupPrefix string `json:"resourceGroupPrefix,omitempty"`
+	// TagKey matches a tag of the route table, with the value TagValue if
+	// it is set.
+	TagKey   string `json:"tagKey,omitempty"`
+	TagValue string `json:"tagValue,omitempty"`
+}
+
+// BuiltinServiceRouteMatchers detect the services velora knows about:
+//   - AKS with kubenet names its pod routes after the node, e.g.
+//     aks-nodepool1-12345678-vmss000000____102440024, and creates its route
+//     table in the MC_ node resource group, tagged with the cluster name
+//     on recent versions.
+//   - Databricks tags the resources of its managed resource group with
+//     application: databricks and databricks-environment: true.
+var BuiltinServiceRouteMatchers = []ServiceRouteMatcher{
+	{Service: "aks", RouteNamePrefix: "aks-"},
+	{Service: "aks", ResourceGroupPrefix: "MC_"},
+	{Service: "aks", TagKey: "aks-managed-cluster-name"},
+	{Service: "databricks", TagKey: "application", TagValue: "databricks"},
+	{Service: "databricks", TagKey: "databricks-environment"},
+}
+
+// EffectiveServiceRouteMatchers returns the built-in service route matchers
+// followed by the configured ones.
+func (c *Config) EffectiveServiceRouteMatchers() []ServiceRouteMatcher {
+	return append(slices.Clone(BuiltinServiceRouteMatchers), c.ServiceRouteMatchers...)
+}
+
+// tableMatches reports whether the route table criteria that are set match.
+// Resource group names, tag keys and tag values are matched regardless of
+// case, as Azure does.
+func (m *ServiceRouteMatcher) tableMatches(routeTableID string, tags map[string]string) bool {
+	if m.ResourceGroupPrefix != "" {
+		parts := strings.Split(routeTableID, "/")
+		resourceGroup := ""
+		for i := 1; i < len(parts)-1; i += 2 {
+			if strings.EqualFold(parts[i], "resourceGroups") {
+				resourceGroup = parts[i+1]
+			}
+		}
+		if !strings.HasPrefix(strings.ToLower(resourceGroup), strings.ToLower(m.ResourceGroupPrefix)) {
+			return false
+		}
+	}
+	if m.TagKey != "" {
+		for key, value := range tags {
+			if strings.EqualFold(key, m.TagKey) && (m.TagValue == "" || strings.EqualFold(value, m.TagValue)) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// routeMatches reports whether the route name matches RouteNamePrefix.
+func (m *ServiceRouteMatcher) routeMatches(name string) bool {
+	return m.RouteNamePrefix != "" && strings.HasPrefix(strings.ToLower(name), strings.ToLower(m.RouteNamePrefix))
+}
+
+// ServiceOfRouteTable returns the service managing the route table with
+// the tags and routes, empty if no matcher detects one.
+func ServiceOfRouteTable(matchers []ServiceRouteMatcher, routeTableID string, tags map[string]string, routeNames []string) string {
+	for _, m := range matchers {
+		if !m.tableMatches(routeTableID, tags) {
+			continue
+		}
+		if m.RouteNamePrefix == "" || slices.ContainsFunc(routeNames, m.routeMatches) {
+			return m.Service
+		}
+	}
+	return ""
+}
+
+// ServiceOfRoute returns the service whose route the name is, empty if no
+// matcher with RouteNamePrefix detects one. Other routes of a service's
+// route table may be the service's too, only the caller knows its own.
+func ServiceOfRoute(matchers []ServiceRouteMatcher, routeTableID string, tags map[string]string, name string) string {
+	for _, m := range matchers {
+		if m.routeMatches(name) && m.tableMatches(routeTableID, tags) {
+			return m.Service
+		}
+	}
+	return ""
+}
+
+// validate checks the matcher has a service and criteria.
+func (m *ServiceRouteMatcher) validate() error {
+	if m.Service == "" {
+		return fmt.Errorf("service is required")
+	}
+	if m.RouteNamePrefix == "" && m.ResourceGroupPrefix == "" && m.TagKey == "" {
+		return fmt.Errorf("one of routeNamePrefix, resourceGroupPrefix or tagKey is required")
+	}
+	if m.TagValue != "" && m.TagKey == "" {
+		return fmt.Errorf("tagValue requires tagKey")
+	}
+	return nil
+}
+
+// validateServiceRouteMatchers checks the configured service route matchers.
+func (c *Config) validateServiceRouteMatchers() error {
+	for i, matcher := range c.ServiceRouteMatchers {
+		if err := matcher.validate(); err != nil {
+			return fmt.Errorf("invalid serviceRouteMatchers[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
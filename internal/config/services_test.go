@@ -0,0 +1,153 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+)
+
+// routeTableIn returns the ID of a route table in the resource group.
+func routeTableIn(resourceGroup, name string) string {
+	return "/subscriptions/" + configtest.SubscriptionID + "/resourceGroups/" + resourceGroup + "/providers/Microsoft.Network/routeTables/" + name
+}
+
+func TestServiceOfRouteTable(t *testing.T) {
+	custom := config.ServiceRouteMatcher{Service: "openshift", ResourceGroupPrefix: "aro-", RouteNamePrefix: "aro-"}
+	tests := []struct {
+		name       string
+		routeTable string
+		tags       map[string]string
+		routes     []string
+		custom     bool
+		want       string
+	}{
+		{
+			// route names of AKS kubenet clusters seen in spokes
+			name:       "kubenet node routes",
+			routeTable: routeTableIn("spoke-rg", "aks-rt"),
+			routes:     []string{"aks-nodepool1-12345678-vmss000000____102440024", "aks-agentpool-35064155-vmss000001____10244124"},
+			want:       "aks",
+		},
+		{
+			name:       "node resource group",
+			routeTable: routeTableIn("MC_prod-rg_prod-aks_westeurope", "aks-agentpool-35064155-routetable"),
+			want:       "aks",
+		},
+		{
+			name:       "node resource group in lower case",
+			routeTable: routeTableIn("mc_prod-rg_prod-aks_westeurope", "aks-agentpool-35064155-routetable"),
+			want:       "aks",
+		},
+		{
+			name:       "cluster tag",
+			routeTable: routeTableIn("aks-nodes-rg", "rt"),
+			tags:       map[string]string{"aks-managed-cluster-name": "prod-aks"},
+			want:       "aks",
+		},
+		{
+			name:       "databricks managed resource group",
+			routeTable: routeTableIn("databricks-rg-workspace-abc123", "workers-rt"),
+			tags:       map[string]string{"application": "Databricks", "databricks-environment": "true"},
+			want:       "databricks",
+		},
+		{
+			name:       "databricks environment tag",
+			routeTable: routeTableIn("dbw-rg", "rt"),
+			tags:       map[string]string{"Databricks-Environment": "true"},
+			want:       "databricks",
+		},
+		{
+			name:       "application tag of another application",
+			routeTable: routeTableIn("spoke-rg", "spoke-rt"),
+			tags:       map[string]string{"application": "payments"},
+			routes:     []string{"DefaultRoute-To-NVA"},
+		},
+		{
+			// the prefix is aks- with the dash
+			name:       "route named like aks",
+			routeTable: routeTableIn("spoke-rg", "spoke-rt"),
+			routes:     []string{"aksdefault", "to-aks-subnet"},
+		},
+		{
+			name:       "custom matcher",
+			routeTable: routeTableIn("aro-cluster-rg", "rt"),
+			routes:     []string{"aro-master-0"},
+			custom:     true,
+			want:       "openshift",
+		},
+		{
+			// all the criteria of a matcher must match
+			name:       "custom matcher in another resource group",
+			routeTable: routeTableIn("spoke-rg", "rt"),
+			routes:     []string{"aro-master-0"},
+			custom:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t)
+			if tt.custom {
+				cfg.ServiceRouteMatchers = []config.ServiceRouteMatcher{custom}
+			}
+			if got := config.ServiceOfRouteTable(cfg.EffectiveServiceRouteMatchers(), tt.routeTable, tt.tags, tt.routes); got != tt.want {
+				t.Errorf("ServiceOfRouteTable() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceOfRoute(t *testing.T) {
+	matchers := config.BuiltinServiceRouteMatchers
+	tests := []struct {
+		name       string
+		routeTable string
+		route      string
+		want       string
+	}{
+		{name: "kubenet node route", routeTable: routeTableIn("spoke-rg", "aks-rt"), route: "aks-nodepool1-12345678-vmss000000____102440024", want: "aks"},
+		{name: "velora's route", routeTable: routeTableIn("spoke-rg", "aks-rt"), route: "DefaultRoute-To-NVA"},
+		// only the caller knows which other routes of the table are its own
+		{name: "route of a node resource group", routeTable: routeTableIn("MC_prod-rg_prod-aks_westeurope", "rt"), route: "custom"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := config.ServiceOfRoute(matchers, tt.routeTable, nil, tt.route); got != tt.want {
+				t.Errorf("ServiceOfRoute() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateServiceRouteMatchers(t *testing.T) {
+	tests := []struct {
+		name    string
+		matcher config.ServiceRouteMatcher
+		wantErr string
+	}{
+		{name: "route name prefix", matcher: config.ServiceRouteMatcher{Service: "openshift", RouteNamePrefix: "aro-"}},
+		{name: "without service", matcher: config.ServiceRouteMatcher{RouteNamePrefix: "aro-"}, wantErr: "service is required"},
+		{name: "without criteria", matcher: config.ServiceRouteMatcher{Service: "openshift"}, wantErr: "one of routeNamePrefix, resourceGroupPrefix or tagKey is required"},
+		{name: "tag value without key", matcher: config.ServiceRouteMatcher{Service: "openshift", RouteNamePrefix: "aro-", TagValue: "aro"}, wantErr: "tagValue requires tagKey"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t)
+			cfg.ServiceRouteMatchers = []config.ServiceRouteMatcher{tt.matcher}
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package config
+
+import "strings"
+
+// serviceTags are the Azure service tags NSG rules accept, by lower-case
+// name. Most also have regional variants such as Storage.WestEurope.
+var serviceTags = toSet([]string{
+	"ActionGroup",
+	"ApiManagement",
+	"AppConfiguration",
+	"AppService",
+	"AppServiceManagement",
+	"AzureActiveDirectory",
+	"AzureActiveDirectoryDomainServices",
+	"AzureAdvancedThreatProtection",
+	"AzureArcInfrastructure",
+	"AzureAttestation",
+	"AzureBackup",
+	"AzureBotService",
+	"AzureCloud",
+	"AzureCognitiveSearch",
+	"AzureConnectors",
+	"AzureContainerRegistry",
+	"AzureCosmosDB",
+	"AzureDatabricks",
+	"AzureDataExplorerManagement",
+	"AzureDataLake",
+	"AzureDevOps",
+	"AzureDigitalTwins",
+	"AzureEventGrid",
+	"AzureFrontDoor.Backend",
+	"AzureFrontDoor.FirstParty",
+	"AzureFrontDoor.Frontend",
+	"AzureInformationProtection",
+	"AzureIoTHub",
+	"AzureKeyVault",
+	"AzureLoadBalancer",
+	"AzureMachineLearning",
+	"AzureMonitor",
+	"AzureOpenDatasets",
+	"AzurePlatformDNS",
+	"AzurePlatformIMDS",
+	"AzurePlatformLKM",
+	"AzureResourceManager",
+	"AzureSignalR",
+	"AzureSiteRecovery",
+	"AzureSpringCloud",
+	"AzureTrafficManager",
+	"AzureUpdateDelivery",
+	"AzureWebPubSub",
+	"BatchNodeManagement",
+	"ChaosStudio",
+	"CognitiveServicesFrontend",
+	"CognitiveServicesManagement",
+	"DataFactory",
+	"DataFactoryManagement",
+	"Dynamics365ForMarketingEmail",
+	"EventHub",
+	"GatewayManager",
+	"GuestAndHybridManagement",
+	"HDInsight",
+	"Internet",
+	"LogicApps",
+	"LogicAppsManagement",
+	"M365ManagementActivityApi",
+	"MicrosoftAzureFluidRelay",
+	"MicrosoftCloudAppSecurity",
+	"MicrosoftContainerRegistry",
+	"MicrosoftDefenderForEndpoint",
+	"PowerBI",
+	"PowerPlatformInfra",
+	"PowerQueryOnline",
+	"ServiceBus",
+	"ServiceFabric",
+	"Sql",
+	"SqlManagement",
+	"Storage",
+	"StorageSyncService",
+	"VirtualNetwork",
+	"WindowsAdminCenter",
+	"WindowsVirtualDesktop",
+})
+
+// IsServiceTag reports whether the name is a built-in or additional service
+// tag, or the regional variant of one.
+func IsServiceTag(name string, additional []string) bool {
+	for _, candidate := range []string{name, serviceTagBase(name)} {
+		if serviceTags[strings.ToLower(candidate)] {
+			return true
+		}
+		for _, tag := range additional {
+			if strings.EqualFold(tag, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serviceTagBase returns the tag without its region, Storage for
+// Storage.WestEurope.
+func serviceTagBase(name string) string {
+	if i := strings.LastIndex(name, "."); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// toSet returns the lower-case names as a set.
+func toSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[strings.ToLower(name)] = true
+	}
+	return set
+}
@@ -0,0 +1,86 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+)
+
+func TestIsServiceTag(t *testing.T) {
+	tests := []struct {
+		name       string
+		additional []string
+		want       bool
+	}{
+		{name: "AzureMonitor", want: true},
+		{name: "azuremonitor", want: true},
+		{name: "Storage.WestEurope", want: true},
+		{name: "AzureFrontDoor.Backend", want: true},
+		{name: "Sql.EastUS2", want: true},
+		{name: "AzureMonitorr"},
+		{name: "WestEurope"},
+		{name: "AzureNewService"},
+		{name: "AzureNewService", additional: []string{"azurenewservice"}, want: true},
+		{name: "AzureNewService.WestEurope", additional: []string{"AzureNewService"}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := config.IsServiceTag(tt.name, tt.additional); got != tt.want {
+				t.Errorf("IsServiceTag(%q, %v) = %t, want %t", tt.name, tt.additional, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateBaselineRuleAddresses(t *testing.T) {
+	ipGroup := "/subscriptions/" + configtest.HubSubscriptionID + "/resourceGroups/security-rg/providers/Microsoft.Network/ipGroups/corp"
+	tests := []struct {
+		name       string
+		address    string
+		additional []string
+		wantErr    string
+	}{
+		{name: "any", address: "*"},
+		{name: "address", address: "10.1.0.4"},
+		{name: "prefix", address: "10.1.0.0/16"},
+		{name: "service tag", address: "Storage.WestEurope"},
+		{name: "IP Group", address: ipGroup},
+		{name: "unknown service tag", address: "AzureNewService", wantErr: `unknown service tag "AzureNewService"`},
+		{name: "additional service tag", address: "AzureNewService", additional: []string{"AzureNewService"}},
+		{name: "other resource", address: "/subscriptions/" + configtest.HubSubscriptionID + "/resourceGroups/security-rg/providers/Microsoft.Network/applicationSecurityGroups/app", wantErr: "isn't an IP Group"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t)
+			sub := cfg.Subscriptions[configtest.SubscriptionID]
+			sub.NSGAssociation = &config.NSGAssociationConfig{
+				Mode: config.NSGAssociationAny,
+				BaselineRules: []config.NSGRuleConfig{{
+					Name:             "allow",
+					Direction:        config.NSGRuleOutbound,
+					Access:           config.NSGRuleAllow,
+					Sources:          []string{"10.1.0.0/16"},
+					Destinations:     []string{tt.address},
+					DestinationPorts: []string{"443"},
+				}},
+				AdditionalServiceTags: tt.additional,
+			}
+			cfg.Subscriptions[configtest.SubscriptionID] = sub
+
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
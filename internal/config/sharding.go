@@ -0,0 +1,190 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultShardClaimTTL is how long a shard claim lasts without a run renewing it.
+const DefaultShardClaimTTL = 2 * time.Hour
+
+// ShardingConfig splits the subscriptions between several instances, each
+// enforcing only the subscriptions hashing into its shard.
+type ShardingConfig struct {
+	// ShardCount is the number of instances.
+	ShardCount int `json:"shardCount"`
+	// ShardIndex is the shard of this instance, from 0.
+	ShardIndex *int `json:"shardIndex,omitempty"`
+	// OrdinalEnv derives the shard index from an environment variable
+	// holding an ordinal or a StatefulSet pod name, e.g. HOSTNAME for
+	// velora-2, instead of ShardIndex.
+	OrdinalEnv string `json:"ordinalEnv,omitempty"`
+	// ClaimTTLMinutes is how long the shard claim of an instance that
+	// stopped running blocks other instances, DefaultShardClaimTTL if unset.
+	ClaimTTLMinutes int `json:"claimTTLMinutes,omitempty"`
+}
+
+// Index returns the shard index of this instance.
+func (s *ShardingConfig) Index() (int, error) {
+	if s.ShardIndex != nil {
+		return *s.ShardIndex, nil
+	}
+
+	value := os.Getenv(s.OrdinalEnv)
+	if value == "" {
+		return 0, fmt.Errorf("sharding.ordinalEnv variable %s is not set", s.OrdinalEnv)
+	}
+	// StatefulSet pods are named <statefulset>-<ordinal>
+	ordinal := value[strings.LastIndex(value, "-")+1:]
+	index, err := strconv.Atoi(ordinal)
+	if err != nil || index < 0 {
+		return 0, fmt.Errorf("invalid shard ordinal %q in %s", value, s.OrdinalEnv)
+	}
+	if index >= s.ShardCount {
+		return 0, fmt.Errorf("shard ordinal %d in %s exceeds sharding.shardCount %d", index, s.OrdinalEnv, s.ShardCount)
+	}
+	return index, nil
+}
+
+// ClaimTTL returns the claim TTL, DefaultShardClaimTTL if unset.
+func (s *ShardingConfig) ClaimTTL() time.Duration {
+	if s.ClaimTTLMinutes > 0 {
+		return time.Duration(s.ClaimTTLMinutes) * time.Minute
+	}
+	return DefaultShardClaimTTL
+}
+
+// validate checks the shard settings. The ordinal isn't read, the
+// environment of the instance may differ.
+func (s *ShardingConfig) validate() error {
+	if s.ShardCount < 1 {
+		return fmt.Errorf("invalid sharding.shardCount %d, must be at least 1", s.ShardCount)
+	}
+	if (s.ShardIndex == nil) == (s.OrdinalEnv == "") {
+		return fmt.Errorf("sharding requires exactly one of shardIndex and ordinalEnv")
+	}
+	if s.ShardIndex != nil && (*s.ShardIndex < 0 || *s.ShardIndex >= s.ShardCount) {
+		return fmt.Errorf("invalid sharding.shardIndex %d, must be between 0 and %d", *s.ShardIndex, s.ShardCount-1)
+	}
+	if s.ClaimTTLMinutes < 0 {
+		return fmt.Errorf("invalid sharding.claimTTLMinutes %d, must not be negative", s.ClaimTTLMinutes)
+	}
+	return nil
+}
+
+// Work queue backends.
+const (
+	// QueueBackendState keeps the queue in the state store, for a single
+	// instance.
+	QueueBackendState = "state"
+	// QueueBackendStorage keeps the queue in an Azure Storage queue.
+	QueueBackendStorage = "storage"
+)
+
+// Work queue defaults.
+const (
+	DefaultQueueWorkers           = 2
+	DefaultQueueVisibilityTimeout = 10 * time.Minute
+	DefaultQueueMaxAttempts       = 5
+)
+
+// QueueConfig represents the work queue of targeted enforcement runs.
+type QueueConfig struct {
+	// Backend is QueueBackendState, the default, or QueueBackendStorage.
+	Backend string `json:"backend,omitempty"`
+	// StorageQueueURL is the URL of the Azure Storage queue, e.g.
+	// https://<account>.queue.core.windows.net/velora. The identity needs
+	// the Storage Queue Data Message Processor and Sender roles.
+	StorageQueueURL string `json:"storageQueueUrl,omitempty"`
+	// Workers is the number of items processed at once, 0 uses the default.
+	Workers int `json:"workers,omitempty"`
+	// VisibilityTimeoutSeconds is how long a received item stays hidden
+	// from other workers without its worker renewing it. A failed item is
+	// retried once it expires. 0 uses the default.
+	VisibilityTimeoutSeconds int `json:"visibilityTimeoutSeconds,omitempty"`
+	// MaxAttempts is the number of times an item is processed before it
+	// moves to the dead-letter list, 0 uses the default.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+}
+
+// EffectiveBackend returns the backend, QueueBackendState if unset.
+func (q *QueueConfig) EffectiveBackend() string {
+	if q.Backend == "" {
+		return QueueBackendState
+	}
+	return q.Backend
+}
+
+// EffectiveWorkers returns the number of workers, DefaultQueueWorkers if unset.
+func (q *QueueConfig) EffectiveWorkers() int {
+	if q.Workers > 0 {
+		return q.Workers
+	}
+	return DefaultQueueWorkers
+}
+
+// VisibilityTimeout returns the visibility timeout,
+// DefaultQueueVisibilityTimeout if unset.
+func (q *QueueConfig) VisibilityTimeout() time.Duration {
+	if q.VisibilityTimeoutSeconds > 0 {
+		return time.Duration(q.VisibilityTimeoutSeconds) * time.Second
+	}
+	return DefaultQueueVisibilityTimeout
+}
+
+// EffectiveMaxAttempts returns the attempts per item,
+// DefaultQueueMaxAttempts if unset.
+func (q *QueueConfig) EffectiveMaxAttempts() int {
+	if q.MaxAttempts > 0 {
+		return q.MaxAttempts
+	}
+	return DefaultQueueMaxAttempts
+}
+
+// validate checks the queue settings.
+func (q *QueueConfig) validate() error {
+	switch q.EffectiveBackend() {
+	case QueueBackendState:
+		if q.StorageQueueURL != "" {
+			return fmt.Errorf("queue.storageQueueUrl requires queue.backend %s", QueueBackendStorage)
+		}
+	case QueueBackendStorage:
+		if u, err := url.Parse(q.StorageQueueURL); err != nil || u.Scheme != "https" || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+			return fmt.Errorf("invalid queue.storageQueueUrl: %s", q.StorageQueueURL)
+		}
+	default:
+		return fmt.Errorf("unknown queue.backend %q, allowed values are %s, %s", q.Backend, QueueBackendState, QueueBackendStorage)
+	}
+	if q.Workers < 0 || q.VisibilityTimeoutSeconds < 0 || q.MaxAttempts < 0 {
+		return fmt.Errorf("queue.workers, queue.visibilityTimeoutSeconds and queue.maxAttempts must not be negative")
+	}
+	// the Storage queue's limit
+	if q.VisibilityTimeout() > 7*24*time.Hour {
+		return fmt.Errorf("queue.visibilityTimeoutSeconds must be at most 7 days")
+	}
+	return nil
+}
+
+// validateSharding checks the sharding and the work queue, which can't be
+// combined.
+func (c *Config) validateSharding() error {
+	if c.Sharding != nil {
+		if err := c.Sharding.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Queue != nil {
+		if err := c.Queue.validate(); err != nil {
+			return err
+		}
+		// a worker would receive items of subscriptions of other shards
+		if c.Sharding != nil {
+			return fmt.Errorf("queue can't be combined with sharding")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// State backends.
+const (
+	// StateBackendFile keeps the state in files under Path.
+	StateBackendFile = "file"
+	// StateBackendS3 keeps the state in an S3-compatible bucket, e.g. MinIO.
+	StateBackendS3 = "s3"
+)
+
+// Default environment variables holding the S3 credentials.
+const (
+	DefaultS3AccessKeyIDEnv     = "AWS_ACCESS_KEY_ID"
+	DefaultS3SecretAccessKeyEnv = "AWS_SECRET_ACCESS_KEY"
+)
+
+// StateConfig represents the state store configuration.
+type StateConfig struct {
+	// Backend is StateBackendFile, the default, or StateBackendS3.
+	Backend string `json:"backend,omitempty"`
+	Path    string `json:"path"`
+	// S3 is the bucket of the s3 backend.
+	S3 *S3StateConfig `json:"s3,omitempty"`
+	// Encryption enables encryption of the state at rest, nil stores plaintext.
+	Encryption *StateEncryptionConfig `json:"encryption,omitempty"`
+}
+
+// EffectiveBackend returns the backend, StateBackendFile if unset.
+func (s *StateConfig) EffectiveBackend() string {
+	if s.Backend == "" {
+		return StateBackendFile
+	}
+	return s.Backend
+}
+
+// S3StateConfig is an S3-compatible bucket keeping the state. The server
+// must support conditional puts, as AWS S3 and MinIO do.
+type S3StateConfig struct {
+	// Endpoint is the URL of the server, e.g. https://minio.example.com:9000
+	// or https://s3.eu-central-1.amazonaws.com.
+	Endpoint string `json:"endpoint"`
+	Bucket   string `json:"bucket"`
+	// Region signs the requests, us-east-1 if unset, which MinIO accepts
+	// by default.
+	Region string `json:"region,omitempty"`
+	// Prefix is prepended to the object names, e.g. velora/prod/.
+	Prefix string `json:"prefix,omitempty"`
+	// VirtualHostedStyle addresses the bucket as a subdomain of the
+	// endpoint rather than as its first path segment.
+	VirtualHostedStyle bool `json:"virtualHostedStyle,omitempty"`
+	// AccessKeyIDEnv and SecretAccessKeyEnv are the environment variables
+	// holding the credentials, DefaultS3AccessKeyIDEnv and
+	// DefaultS3SecretAccessKeyEnv if unset.
+	AccessKeyIDEnv     string `json:"accessKeyIdEnv,omitempty"`
+	SecretAccessKeyEnv string `json:"secretAccessKeyEnv,omitempty"`
+}
+
+// EffectiveRegion returns the signing region, us-east-1 if unset.
+func (s *S3StateConfig) EffectiveRegion() string {
+	if s.Region == "" {
+		return "us-east-1"
+	}
+	return s.Region
+}
+
+// EffectiveAccessKeyIDEnv returns the variable holding the access key ID.
+func (s *S3StateConfig) EffectiveAccessKeyIDEnv() string {
+	if s.AccessKeyIDEnv == "" {
+		return DefaultS3AccessKeyIDEnv
+	}
+	return s.AccessKeyIDEnv
+}
+
+// EffectiveSecretAccessKeyEnv returns the variable holding the secret
+// access key.
+func (s *S3StateConfig) EffectiveSecretAccessKeyEnv() string {
+	if s.SecretAccessKeyEnv == "" {
+		return DefaultS3SecretAccessKeyEnv
+	}
+	return s.SecretAccessKeyEnv
+}
+
+// validate checks the state backend and encryption settings.
+func (s *StateConfig) validate() error {
+	switch s.EffectiveBackend() {
+	case StateBackendFile:
+		if s.S3 != nil {
+			return fmt.Errorf("state.s3 is only used with state.backend %s", StateBackendS3)
+		}
+	case StateBackendS3:
+		if s.S3 == nil {
+			return fmt.Errorf("state.s3 is required with state.backend %s", StateBackendS3)
+		}
+		if u, err := url.Parse(s.S3.Endpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("invalid state.s3.endpoint %q, expected a URL like https://minio.example.com:9000", s.S3.Endpoint)
+		}
+		if s.S3.Bucket == "" || strings.ContainsAny(s.S3.Bucket, "/?#") {
+			return fmt.Errorf("invalid state.s3.bucket %q", s.S3.Bucket)
+		}
+		if strings.HasPrefix(s.S3.Prefix, "/") {
+			return fmt.Errorf("state.s3.prefix %q must not start with /", s.S3.Prefix)
+		}
+	default:
+		return fmt.Errorf("unknown state.backend %q, allowed values are %s, %s", s.Backend, StateBackendFile, StateBackendS3)
+	}
+	if s.Encryption != nil {
+		if err := s.Encryption.validate(); err != nil {
+			return fmt.Errorf("invalid state.encryption: %w", err)
+		}
+	}
+	return nil
+}
+
+// StateEncryptionConfig configures envelope encryption of the state store.
+// Exactly one of DataKeyEnv, DataKeySecretID and KeyID must be set.
+type StateEncryptionConfig struct {
+	// DataKeyEnv is the environment variable holding the base64 encoded
+	// 256-bit data key.
+	DataKeyEnv string `json:"dataKeyEnv,omitempty"`
+	// DataKeySecretID is the Key Vault secret holding the base64 encoded data key.
+	DataKeySecretID string `json:"dataKeySecretId,omitempty"`
+	// KeyID is the Key Vault key wrapping a new data key for every write.
+	KeyID string `json:"keyId,omitempty"`
+	// PreviousDataKeyEnvs are environment variables holding rotated out data
+	// keys, state encrypted with them stays readable until it's saved again.
+	PreviousDataKeyEnvs []string `json:"previousDataKeyEnvs,omitempty"`
+}
+
+// UsesKeyVault reports whether the key is read from or wrapped by Key Vault.
+func (s *StateEncryptionConfig) UsesKeyVault() bool {
+	return s.DataKeySecretID != "" || s.KeyID != ""
+}
+
+// validate checks exactly one key source is set.
+func (s *StateEncryptionConfig) validate() error {
+	sources := 0
+	for _, source := range []string{s.DataKeyEnv, s.DataKeySecretID, s.KeyID} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("exactly one of dataKeyEnv, dataKeySecretId and keyId is required")
+	}
+	if s.DataKeySecretID != "" {
+		if u, err := url.Parse(s.DataKeySecretID); err != nil || u.Scheme != "https" || !strings.HasPrefix(u.Path, "/secrets/") {
+			return fmt.Errorf("invalid dataKeySecretId: %s", s.DataKeySecretID)
+		}
+	}
+	if s.KeyID != "" {
+		if u, err := url.Parse(s.KeyID); err != nil || u.Scheme != "https" || !strings.HasPrefix(u.Path, "/keys/") {
+			return fmt.Errorf("invalid keyId: %s", s.KeyID)
+		}
+		if len(s.PreviousDataKeyEnvs) > 0 {
+			return fmt.Errorf("previousDataKeyEnvs can't be used with keyId, Key Vault keeps the previous key versions")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package config
+
+import "fmt"
+
+// Default retention of the enforcement statistics history.
+const (
+	DefaultStatsRetentionDays       = 365
+	DefaultStatsDownsampleAfterDays = 14
+)
+
+// StatsConfig represents the retention of the enforcement statistics history.
+type StatsConfig struct {
+	// RetentionDays is how long statistics are kept.
+	RetentionDays int `json:"retentionDays"`
+	// DownsampleAfterDays is the age after which the statistics of a day's
+	// runs are merged into one daily record.
+	DownsampleAfterDays int `json:"downsampleAfterDays"`
+}
+
+// EffectiveRetentionDays returns the retention, DefaultStatsRetentionDays if unset.
+func (s *StatsConfig) EffectiveRetentionDays() int {
+	if s.RetentionDays > 0 {
+		return s.RetentionDays
+	}
+	return DefaultStatsRetentionDays
+}
+
+// EffectiveDownsampleAfterDays returns the downsampling age,
+// DefaultStatsDownsampleAfterDays if unset.
+func (s *StatsConfig) EffectiveDownsampleAfterDays() int {
+	if s.DownsampleAfterDays > 0 {
+		return s.DownsampleAfterDays
+	}
+	return DefaultStatsDownsampleAfterDays
+}
+
+// validate checks the retention settings.
+func (s *StatsConfig) validate() error {
+	if s.RetentionDays < 0 || s.DownsampleAfterDays < 0 {
+		return fmt.Errorf("stats.retentionDays and stats.downsampleAfterDays must not be negative")
+	}
+	if s.EffectiveDownsampleAfterDays() > s.EffectiveRetentionDays() {
+		return fmt.Errorf("stats.downsampleAfterDays %d exceeds stats.retentionDays %d", s.EffectiveDownsampleAfterDays(), s.EffectiveRetentionDays())
+	}
+	return nil
+}
+
+// MetricsConfig represents the Prometheus metrics written for alerting.
+type MetricsConfig struct {
+	// TextfilePath is the file the metrics are written to at the end of
+	// every run and on pause and resume, for the node exporter's textfile
+	// collector. Empty disables the metrics.
+	TextfilePath string `json:"textfilePath"`
+}
+
+// SLOConfig represents the time-to-remediation objectives.
+type SLOConfig struct {
+	// ThresholdHours is the time an open finding of the severity may stay
+	// open before it breaches the SLO. Severities without one never breach.
+	ThresholdHours map[string]float64 `json:"thresholdHours"`
+}
+
+// validate checks the thresholds are positive and keyed by known severities.
+func (s *SLOConfig) validate() error {
+	for severity, hours := range s.ThresholdHours {
+		switch severity {
+		case "critical", "high", "medium", "low", "info":
+		default:
+			return fmt.Errorf("unknown severity %q in slo.thresholdHours, allowed values are critical, high, medium, low, info", severity)
+		}
+		if hours <= 0 {
+			return fmt.Errorf("invalid slo.thresholdHours %v for %s, must be positive", hours, severity)
+		}
+	}
+	return nil
+}
+
+// ScoringConfig represents the weights of the network posture score.
+type ScoringConfig struct {
+	// SeverityWeights is how much a finding of the severity weighs against
+	// a compliant resource. Severities without one use the default weights.
+	SeverityWeights map[string]float64 `json:"severityWeights"`
+}
+
+// validate checks the weights are keyed by known severities and not negative.
+func (s *ScoringConfig) validate() error {
+	for severity, weight := range s.SeverityWeights {
+		switch severity {
+		case "critical", "high", "medium", "low", "info":
+		default:
+			return fmt.Errorf("unknown severity %q in scoring.severityWeights, allowed values are critical, high, medium, low, info", severity)
+		}
+		if weight < 0 {
+			return fmt.Errorf("invalid scoring.severityWeights %v for %s, must not be negative", weight, severity)
+		}
+	}
+	return nil
+}
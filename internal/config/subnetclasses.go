@@ -0,0 +1,189 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// BGP route propagation settings a subnet class can require.
+const (
+	BGPPropagationEnabled  = "enabled"
+	BGPPropagationDisabled = "disabled"
+)
+
+// SubnetClassConfig classifies the subnets of a hub's spokes by name or by
+// the tags of their VNet, Azure subnets have no tags of their own, and
+// overrides the routing policy of the subscription for them.
+type SubnetClassConfig struct {
+	Name string `json:"name"`
+	// SubnetNames are case-insensitive glob patterns of subnet names, any
+	// subnet matches if unset.
+	SubnetNames []string `json:"subnetNames,omitempty"`
+	// VNetTags are tags the subnet's VNet must all have, any VNet matches
+	// if unset.
+	VNetTags map[string]string `json:"vnetTags,omitempty"`
+	// Priority decides between classes matching the same subnet, the
+	// highest wins. A subnet matching several classes of the same priority
+	// is a conflict and isn't routed.
+	Priority int `json:"priority,omitempty"`
+	// DefaultRoute enforces the default route to the NVA and the on-prem
+	// overrides on the class, requireNvaRouting of the subscription if unset.
+	DefaultRoute *bool `json:"defaultRoute,omitempty"`
+	// SubnetIsolation routes the traffic of the class to the other subnets
+	// of its VNet through the NVA, subnetToSubnetDeny of the subscription if
+	// unset.
+	SubnetIsolation *bool `json:"subnetIsolation,omitempty"`
+	// BGPPropagation is the BGPPropagation* setting the route tables of the
+	// class must have, not checked if unset.
+	BGPPropagation string `json:"bgpPropagation,omitempty"`
+}
+
+// SubnetClassMatch is the class a subnet matched and why.
+type SubnetClassMatch struct {
+	Class *SubnetClassConfig `json:"-"`
+	Name  string             `json:"name"`
+	// Reasons are the patterns and tags the subnet matched.
+	Reasons []string `json:"reasons"`
+	// Outranked are the other classes the subnet matched, with a lower
+	// priority.
+	Outranked []string `json:"outranked,omitempty"`
+}
+
+// String describes the match.
+func (m *SubnetClassMatch) String() string {
+	s := fmt.Sprintf("class %s: %s", m.Name, strings.Join(m.Reasons, ", "))
+	if len(m.Outranked) > 0 {
+		s += fmt.Sprintf(", outranking %s", strings.Join(m.Outranked, ", "))
+	}
+	return s
+}
+
+// match returns why the subnet matches the class, nil if it doesn't.
+func (c *SubnetClassConfig) match(vnetTags map[string]string, subnetName string) []string {
+	var reasons []string
+	if len(c.SubnetNames) > 0 {
+		matched := ""
+		for _, pattern := range c.SubnetNames {
+			if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(subnetName)); ok {
+				matched = pattern
+				break
+			}
+		}
+		if matched == "" {
+			return nil
+		}
+		reasons = append(reasons, fmt.Sprintf("subnet name matches %s", matched))
+	}
+
+	keys := make([]string, 0, len(c.VNetTags))
+	for key := range c.VNetTags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value, ok := lookupTag(vnetTags, key); !ok || !strings.EqualFold(value, c.VNetTags[key]) {
+			return nil
+		}
+		reasons = append(reasons, fmt.Sprintf("VNet tag %s=%s", key, c.VNetTags[key]))
+	}
+	return reasons
+}
+
+// lookupTag returns the value of the tag, tag names are case-insensitive.
+func lookupTag(tags map[string]string, key string) (string, bool) {
+	for k, v := range tags {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// ClassifySubnet returns the class of the hub the subnet matches, nil if
+// none does. Several classes matching with the same highest priority is an
+// error.
+func (h *HubVNetConfig) ClassifySubnet(vnetTags map[string]string, subnetName string) (*SubnetClassMatch, error) {
+	var best *SubnetClassMatch
+	var tied, outranked []string
+	for i := range h.SubnetClasses {
+		class := &h.SubnetClasses[i]
+		reasons := class.match(vnetTags, subnetName)
+		if reasons == nil {
+			continue
+		}
+		switch {
+		case best == nil:
+			best = &SubnetClassMatch{Class: class, Name: class.Name, Reasons: reasons}
+		case class.Priority > best.Class.Priority:
+			outranked = append(append(outranked, best.Name), tied...)
+			tied = nil
+			best = &SubnetClassMatch{Class: class, Name: class.Name, Reasons: reasons}
+		case class.Priority == best.Class.Priority:
+			tied = append(tied, class.Name)
+		default:
+			outranked = append(outranked, class.Name)
+		}
+	}
+	if len(tied) > 0 {
+		return nil, fmt.Errorf("subnet %s matches subnet classes %s, %s with the same priority %d, set priorities to decide",
+			subnetName, best.Name, strings.Join(tied, ", "), best.Class.Priority)
+	}
+	if best != nil {
+		best.Outranked = outranked
+	}
+	return best, nil
+}
+
+// validateSubnetClasses checks the subnet classes of the hub. Overlapping
+// patterns can only be detected once the subnets are known, except for
+// classes sharing a pattern with the same priority and VNet tags.
+func (h *HubVNetConfig) validateSubnetClasses() error {
+	names := make(map[string]bool)
+	// class owning each pattern by priority, tags and lower-case pattern
+	patterns := make(map[string]string)
+	for _, class := range h.SubnetClasses {
+		if class.Name == "" {
+			return fmt.Errorf("subnetClasses of hub %s require a name", h.Name)
+		}
+		if names[strings.ToLower(class.Name)] {
+			return fmt.Errorf("duplicate subnet class %s of hub %s", class.Name, h.Name)
+		}
+		names[strings.ToLower(class.Name)] = true
+		if len(class.SubnetNames) == 0 && len(class.VNetTags) == 0 {
+			return fmt.Errorf("subnet class %s of hub %s requires subnetNames or vnetTags", class.Name, h.Name)
+		}
+		switch class.BGPPropagation {
+		case "", BGPPropagationEnabled, BGPPropagationDisabled:
+		default:
+			return fmt.Errorf("invalid bgpPropagation %q of subnet class %s of hub %s, allowed values are %s, %s",
+				class.BGPPropagation, class.Name, h.Name, BGPPropagationEnabled, BGPPropagationDisabled)
+		}
+		if class.DefaultRoute == nil && class.SubnetIsolation == nil && class.BGPPropagation == "" {
+			return fmt.Errorf("subnet class %s of hub %s overrides nothing, set defaultRoute, subnetIsolation or bgpPropagation", class.Name, h.Name)
+		}
+
+		tags := make([]string, 0, len(class.VNetTags))
+		for key, value := range class.VNetTags {
+			tags = append(tags, strings.ToLower(key+"="+value))
+		}
+		sort.Strings(tags)
+		subnetNames := class.SubnetNames
+		if len(subnetNames) == 0 {
+			subnetNames = []string{"*"}
+		}
+		for _, pattern := range subnetNames {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid subnetNames pattern %q of subnet class %s of hub %s: %w", pattern, class.Name, h.Name, err)
+			}
+			key := fmt.Sprintf("%d|%s|%s", class.Priority, strings.Join(tags, ","), strings.ToLower(pattern))
+			if other, ok := patterns[key]; ok {
+				return fmt.Errorf("subnet classes %s and %s of hub %s both match %s with priority %d, set priorities to decide",
+					other, class.Name, h.Name, pattern, class.Priority)
+			}
+			patterns[key] = class.Name
+		}
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SubscriptionConfig represents the configuration for a subscription.
+type SubscriptionConfig struct {
+	AllowedCIDRs       []string `json:"allowedCIDRs"`
+	HubName            string   `json:"hubName"`
+	RequireHubPeering  bool     `json:"requireHubPeering"`
+	RequireNVARouting  bool     `json:"requireNVARouting"`
+	SubnetToSubnetDeny bool     `json:"subnetToSubnetDeny"`
+	// Environment is prod or nonprod. Prod subscriptions default to
+	// approvalRequired and observeFirst.
+	Environment string `json:"environment"`
+	// Owner, Team, ContactEmail and TicketQueue identify who owns the
+	// resources of the subscription. Findings carry them, so they can be
+	// routed without a lookup.
+	Owner        string `json:"owner,omitempty"`
+	Team         string `json:"team,omitempty"`
+	ContactEmail string `json:"contactEmail,omitempty"`
+	TicketQueue  string `json:"ticketQueue,omitempty"`
+	// ApprovalRequired only makes changes to the subscription by applying
+	// an approved plan, enforcement runs observe it. Unset, it is true in
+	// prod.
+	ApprovalRequired *bool `json:"approvalRequired,omitempty"`
+	// ObserveFirst keeps a new subscription in observe mode until an
+	// operator acknowledges its findings, onboarding.autoAcknowledgeAfterRuns
+	// doesn't apply. False doesn't observe new subscriptions at all. Unset,
+	// it is true in prod.
+	ObserveFirst *bool `json:"observeFirst,omitempty"`
+	// NSGAssociation is the NSG every subnet of the subscription must have.
+	NSGAssociation *NSGAssociationConfig `json:"nsgAssociation,omitempty"`
+	// DefaultRoutePrefixes override the default route prefixes of the hub.
+	DefaultRoutePrefixes []string `json:"defaultRoutePrefixes,omitempty"`
+	// StrictCoverage requires the default route prefixes to cover the
+	// whole 0.0.0.0/0 address space.
+	StrictCoverage bool `json:"strictCoverage,omitempty"`
+	// NewResourceGracePeriodMinutes is how long new resources are reported
+	// but not remediated, while IaC finishes provisioning them. 0 disables it.
+	NewResourceGracePeriodMinutes int `json:"newResourceGracePeriodMinutes,omitempty"`
+	// ForbiddenNextHops flags routes of spoke route tables whose next hop
+	// bypasses the NVA.
+	ForbiddenNextHops *ForbiddenNextHopsConfig `json:"forbiddenNextHops,omitempty"`
+	// EnforceWithCompatRoutes routes Application Gateway and API Management
+	// subnets through the NVA with the routes their service requires to the
+	// Internet, instead of exempting them from the default route.
+	EnforceWithCompatRoutes bool `json:"enforceWithCompatRoutes,omitempty"`
+	// Limits override the Azure limits of the subscription by name, after
+	// a quota increase, e.g. "peeringsPerVNet": 1000.
+	Limits map[string]int `json:"limits,omitempty"`
+	// ServiceRouteTables is how route tables managed by services like AKS
+	// and Databricks are handled, a ServiceRouteTables* mode. Unset, it is
+	// coexist.
+	ServiceRouteTables string `json:"serviceRouteTables,omitempty"`
+	// SubnetIsolationBand also enforces subnetToSubnetDeny with NSG rules:
+	// the NSG of each spoke subnet denies the traffic from the other
+	// subnets of its VNet, with rules in a priority band velora owns.
+	SubnetIsolationBand *PriorityBandConfig `json:"subnetIsolationBand,omitempty"`
+	// NICPublicIPScan flags the NICs with a public IP in the spoke subnets
+	// of a requireNVARouting subscription. It lists every NIC of the
+	// subscription, false turns it off. Unset, it is true.
+	NICPublicIPScan *bool `json:"nicPublicIpScan,omitempty"`
+}
+
+// ScansNICPublicIPs reports whether the NICs of the subscription are
+// scanned for public IPs bypassing the NVA.
+func (s *SubscriptionConfig) ScansNICPublicIPs() bool {
+	return s.NICPublicIPScan == nil || *s.NICPublicIPScan
+}
+
+// Default priority band of the subnet isolation NSG rules, at the end of the
+// custom rule priorities so the customer's rules come first.
+const (
+	DefaultIsolationBandFirst = 4000
+	DefaultIsolationBandLast  = 4095
+)
+
+// PriorityBandConfig represents a range of NSG rule priorities velora owns:
+// it only writes rules inside it, and any other rule found inside it is a
+// violation. Rules outside it are the customer's.
+type PriorityBandConfig struct {
+	// First and Last bound the band, inclusive. Unset, they are
+	// DefaultIsolationBandFirst and DefaultIsolationBandLast.
+	First int `json:"first,omitempty"`
+	Last  int `json:"last,omitempty"`
+}
+
+// Bounds returns the first and last priority of the band.
+func (b *PriorityBandConfig) Bounds() (int, int) {
+	first, last := b.First, b.Last
+	if first == 0 {
+		first = DefaultIsolationBandFirst
+	}
+	if last == 0 {
+		last = DefaultIsolationBandLast
+	}
+	return first, last
+}
+
+// Contains reports whether the priority is inside the band.
+func (b *PriorityBandConfig) Contains(priority int) bool {
+	first, last := b.Bounds()
+	return priority >= first && priority <= last
+}
+
+// validate checks the band is within the priorities of custom NSG rules.
+func (b *PriorityBandConfig) validate() error {
+	first, last := b.Bounds()
+	if first < minNSGRulePriority || last > maxNSGRulePriority || first > last {
+		return fmt.Errorf("invalid priority band %d-%d, must be within %d-%d", first, last, minNSGRulePriority, maxNSGRulePriority)
+	}
+	return nil
+}
+
+// EffectiveServiceRouteTables returns the service route table mode of the
+// subscription, coexist if unset.
+func (s *SubscriptionConfig) EffectiveServiceRouteTables() string {
+	if s.ServiceRouteTables == "" {
+		return ServiceRouteTablesCoexist
+	}
+	return s.ServiceRouteTables
+}
+
+// EffectiveLimit returns the limit of the subscription, its override or
+// DefaultLimits. It is 0 for unknown limits.
+func (s *SubscriptionConfig) EffectiveLimit(name string) int {
+	if limit, ok := s.Limits[name]; ok {
+		return limit
+	}
+	return DefaultLimits[name]
+}
+
+// EffectiveDefaultRoutePrefixes returns the prefixes of the default route
+// enforced in the subscription: its own, the hub's, or DefaultRoutePrefix.
+func (s *SubscriptionConfig) EffectiveDefaultRoutePrefixes(hub *HubVNetConfig) []string {
+	if len(s.DefaultRoutePrefixes) > 0 {
+		return s.DefaultRoutePrefixes
+	}
+	if hub != nil && len(hub.DefaultRoutePrefixes) > 0 {
+		return hub.DefaultRoutePrefixes
+	}
+	return []string{DefaultRoutePrefix}
+}
+
+// GracePeriod returns the grace period of new resources in the subscription.
+func (s *SubscriptionConfig) GracePeriod() time.Duration {
+	return time.Duration(s.NewResourceGracePeriodMinutes) * time.Minute
+}
+
+// Remediation actions of routes with a forbidden next hop.
+const (
+	ForbiddenNextHopReport  = "report"
+	ForbiddenNextHopDelete  = "delete"
+	ForbiddenNextHopRewrite = "rewrite"
+)
+
+// forbiddableNextHopTypes are the route next hop types that can be forbidden.
+var forbiddableNextHopTypes = []string{"VirtualNetworkGateway", "Internet", "VnetLocal", "None"}
+
+// ForbiddenNextHopsConfig represents the next hop types spoke routes must not use.
+type ForbiddenNextHopsConfig struct {
+	// Types are the forbidden next hop types, VirtualNetworkGateway if empty.
+	Types []string `json:"types,omitempty"`
+	// RemediationAction is report (the default), delete, or rewrite to
+	// point the route to the NVA.
+	RemediationAction string `json:"remediationAction,omitempty"`
+}
+
+// EffectiveTypes returns the forbidden next hop types.
+func (f *ForbiddenNextHopsConfig) EffectiveTypes() []string {
+	if len(f.Types) == 0 {
+		return []string{"VirtualNetworkGateway"}
+	}
+	return f.Types
+}
+
+// EffectiveRemediationAction returns the remediation action, report if unset.
+func (f *ForbiddenNextHopsConfig) EffectiveRemediationAction() string {
+	if f.RemediationAction == "" {
+		return ForbiddenNextHopReport
+	}
+	return f.RemediationAction
+}
+
+// validate checks the next hop types and the remediation action.
+func (f *ForbiddenNextHopsConfig) validate() error {
+	for _, t := range f.Types {
+		if !slices.Contains(forbiddableNextHopTypes, t) {
+			return fmt.Errorf("unknown next hop type %q, allowed values are %s", t, strings.Join(forbiddableNextHopTypes, ", "))
+		}
+	}
+	switch f.EffectiveRemediationAction() {
+	case ForbiddenNextHopReport, ForbiddenNextHopDelete, ForbiddenNextHopRewrite:
+	default:
+		return fmt.Errorf("unknown remediationAction %q, allowed values are %s, %s, %s",
+			f.RemediationAction, ForbiddenNextHopReport, ForbiddenNextHopDelete, ForbiddenNextHopRewrite)
+	}
+	return nil
+}
+
+// Environments of subscriptions.
+const (
+	EnvironmentProd    = "prod"
+	EnvironmentNonProd = "nonprod"
+)
+
+// IsProduction reports whether the subscription hosts production workloads.
+func (s *SubscriptionConfig) IsProduction() bool {
+	return strings.EqualFold(s.Environment, EnvironmentProd)
+}
+
+// RequiresApproval reports whether changes to the subscription are only made
+// by applying an approved plan.
+func (s *SubscriptionConfig) RequiresApproval() bool {
+	if s.ApprovalRequired != nil {
+		return *s.ApprovalRequired
+	}
+	return s.IsProduction()
+}
+
+// ObservesFirst reports whether a new subscription is observed until an
+// operator acknowledges its findings, without automatic acknowledgment.
+func (s *SubscriptionConfig) ObservesFirst() bool {
+	if s.ObserveFirst != nil {
+		return *s.ObserveFirst
+	}
+	return s.IsProduction()
+}
+
+// SkipsObservation reports whether observation of a new subscription is
+// turned off with observeFirst false.
+func (s *SubscriptionConfig) SkipsObservation() bool {
+	return s.ObserveFirst != nil && !*s.ObserveFirst
+}
+
+// Ownership identifies who owns the resources of a subscription.
+type Ownership struct {
+	Owner        string `json:"owner,omitempty"`
+	Team         string `json:"team,omitempty"`
+	ContactEmail string `json:"contactEmail,omitempty"`
+	TicketQueue  string `json:"ticketQueue,omitempty"`
+	Environment  string `json:"environment,omitempty"`
+}
+
+// String describes the ownership, e.g. "team network, owner jane, prod".
+func (o *Ownership) String() string {
+	var parts []string
+	for _, part := range []struct{ label, value string }{
+		{"team ", o.Team},
+		{"owner ", o.Owner},
+		{"contact ", o.ContactEmail},
+		{"queue ", o.TicketQueue},
+		{"", o.Environment},
+	} {
+		if part.value != "" {
+			parts = append(parts, part.label+part.value)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Ownership returns the ownership of the subscription, nil if none is
+// configured.
+func (s *SubscriptionConfig) Ownership() *Ownership {
+	o := Ownership{
+		Owner:        s.Owner,
+		Team:         s.Team,
+		ContactEmail: s.ContactEmail,
+		TicketQueue:  s.TicketQueue,
+		Environment:  s.Environment,
+	}
+	if o == (Ownership{}) {
+		return nil
+	}
+	return &o
+}
+
+// AutoAcknowledgeAfterRuns returns after how many stable observe runs the
+// new subscription is acknowledged, 0 if an operator must acknowledge it.
+func (c *Config) AutoAcknowledgeAfterRuns(subscriptionID string) int {
+	if subCFG, ok := c.Subscriptions[subscriptionID]; ok && subCFG.ObservesFirst() {
+		return 0
+	}
+	return c.Onboarding.AutoAcknowledgeAfterRuns
+}
+
+// OnboardingConfig represents the first-run safety of new subscriptions:
+// a subscription velora never ran against is only observed until its
+// findings are acknowledged.
+type OnboardingConfig struct {
+	// AutoAcknowledgeAfterRuns acknowledges a new subscription after this
+	// many consecutive observe runs with the same findings. 0 waits for an
+	// operator.
+	AutoAcknowledgeAfterRuns int `json:"autoAcknowledgeAfterRuns"`
+}
+
+// validate checks the onboarding settings.
+func (o *OnboardingConfig) validate() error {
+	if o.AutoAcknowledgeAfterRuns < 0 {
+		return fmt.Errorf("onboarding.autoAcknowledgeAfterRuns must not be negative")
+	}
+	return nil
+}
+
+// validateDefaultRoutePrefixes checks the default route prefixes of the
+// subscription, as inherited from its hub.
+func (c *Config) validateDefaultRoutePrefixes(subID string, subConfig SubscriptionConfig) error {
+	hub := c.Hub(subConfig.HubName)
+	strict := subConfig.StrictCoverage || (hub != nil && hub.StrictCoverage)
+	if err := validatePrefixes(subConfig.EffectiveDefaultRoutePrefixes(hub), strict); err != nil {
+		return fmt.Errorf("invalid defaultRoutePrefixes for subscription %s: %w", subID, err)
+	}
+	return nil
+}
+
+// validateSubscriptions checks the subscriptions, and the default route
+// prefixes they inherit from their hubs.
+func (c *Config) validateSubscriptions() error {
+	for subID, subConfig := range c.Subscriptions {
+		if err := subConfig.validate(subID); err != nil {
+			return err
+		}
+		if err := c.validateDefaultRoutePrefixes(subID, subConfig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks the settings of the subscription.
+func (s *SubscriptionConfig) validate(subID string) error {
+	for _, cidr := range s.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid CIDR in allowedIPRanges: %s", cidr)
+		}
+	}
+
+	// validate ownership
+	if env := s.Environment; env != "" && !strings.EqualFold(env, EnvironmentProd) && !strings.EqualFold(env, EnvironmentNonProd) {
+		return fmt.Errorf("invalid environment %q for subscription %s, allowed values are %s, %s", env, subID, EnvironmentProd, EnvironmentNonProd)
+	}
+	if email := s.ContactEmail; email != "" {
+		if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+			return fmt.Errorf("invalid contactEmail %q for subscription %s, must be an email address", email, subID)
+		}
+	}
+
+	if s.NewResourceGracePeriodMinutes < 0 {
+		return fmt.Errorf("invalid newResourceGracePeriodMinutes %d for subscription %s, must not be negative",
+			s.NewResourceGracePeriodMinutes, subID)
+	}
+
+	switch s.EffectiveServiceRouteTables() {
+	case ServiceRouteTablesCoexist, ServiceRouteTablesExclude, ServiceRouteTablesReportOnly:
+	default:
+		return fmt.Errorf("invalid serviceRouteTables %q for subscription %s, allowed values are %s, %s, %s", s.ServiceRouteTables,
+			subID, ServiceRouteTablesCoexist, ServiceRouteTablesExclude, ServiceRouteTablesReportOnly)
+	}
+
+	if s.ForbiddenNextHops != nil {
+		if err := s.ForbiddenNextHops.validate(); err != nil {
+			return fmt.Errorf("invalid forbiddenNextHops for subscription %s: %w", subID, err)
+		}
+	}
+	if s.NSGAssociation != nil {
+		if err := s.NSGAssociation.validate(); err != nil {
+			return fmt.Errorf("invalid nsgAssociation for subscription %s: %w", subID, err)
+		}
+	}
+
+	// validate the subnet isolation band, baseline rules must stay out of it
+	if band := s.SubnetIsolationBand; band != nil {
+		if !s.SubnetToSubnetDeny {
+			return fmt.Errorf("subnetIsolationBand of subscription %s requires subnetToSubnetDeny", subID)
+		}
+		if err := band.validate(); err != nil {
+			return fmt.Errorf("invalid subnetIsolationBand for subscription %s: %w", subID, err)
+		}
+		if s.NSGAssociation != nil {
+			for _, rule := range s.NSGAssociation.BaselineRules {
+				if rule.Priority != 0 && band.Contains(rule.Priority) {
+					first, last := band.Bounds()
+					return fmt.Errorf("baseline rule %s of subscription %s has priority %d, inside its subnet isolation band %d-%d", rule.Name, subID, rule.Priority, first, last)
+				}
+			}
+		}
+	}
+
+	for name, limit := range s.Limits {
+		if _, ok := DefaultLimits[name]; !ok {
+			return fmt.Errorf("unknown limit %q of subscription %s", name, subID)
+		}
+		if limit <= 0 {
+			return fmt.Errorf("limit %s of subscription %s must be positive", name, subID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultRestampIntervalDays is how long the last-enforced tag of a compliant,
+// unchanged resource stays before it's written again.
+const DefaultRestampIntervalDays = 7
+
+// TaggingConfig represents the tags velora writes on the resources it
+// enforces, so their last verification shows in the portal.
+type TaggingConfig struct {
+	// WriteLastEnforced stamps verified and remediated route tables with the
+	// velora-last-enforced and velora-rule-version tags. It's off by default,
+	// as it adds a write per resource.
+	WriteLastEnforced bool `json:"writeLastEnforced"`
+	// RestampIntervalDays is the age of the tag after which a compliant,
+	// unchanged resource is stamped again.
+	RestampIntervalDays int `json:"restampIntervalDays"`
+}
+
+// EffectiveRestampInterval returns the restamp interval,
+// DefaultRestampIntervalDays if unset.
+func (t *TaggingConfig) EffectiveRestampInterval() time.Duration {
+	days := t.RestampIntervalDays
+	if days <= 0 {
+		days = DefaultRestampIntervalDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// validate checks the restamp interval.
+func (t *TaggingConfig) validate() error {
+	if t.RestampIntervalDays < 0 {
+		return fmt.Errorf("tagging.restampIntervalDays must not be negative")
+	}
+	return nil
+}
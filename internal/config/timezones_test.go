@@ -0,0 +1,252 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+)
+
+// in Europe/Berlin the clock is moved forward from 02:00 to 03:00 on
+// 2026-03-29 and back from 03:00 to 02:00 on 2026-10-25, both at 01:00 UTC
+var (
+	springForward = time.Date(2026, 3, 29, 0, 0, 0, 0, time.UTC)
+	fallBack      = time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC)
+	ordinaryDay   = time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+)
+
+func berlin(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestValidateTimezones(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*config.Config)
+		wantErr string
+	}{
+		{name: "unset", mutate: func(*config.Config) {}},
+		{
+			name: "IANA names",
+			mutate: func(c *config.Config) {
+				c.DefaultTimezone = "Europe/Berlin"
+				c.DisplayTimezone = "America/New_York"
+			},
+		},
+		{name: "UTC", mutate: func(c *config.Config) { c.DefaultTimezone = "UTC" }},
+		{name: "unknown default", mutate: func(c *config.Config) { c.DefaultTimezone = "Europe/Atlantis" }, wantErr: "defaultTimezone"},
+		{name: "unknown display", mutate: func(c *config.Config) { c.DisplayTimezone = "CEST" }, wantErr: "displayTimezone"},
+		// the abbreviation isn't a location, whatever it means locally
+		{name: "abbreviation", mutate: func(c *config.Config) { c.DefaultTimezone = "CET+1" }, wantErr: "use an IANA name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t)
+			tt.mutate(cfg)
+
+			err := cfg.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLocations(t *testing.T) {
+	cfg := configtest.New(t)
+	if cfg.Location() != time.UTC || cfg.DisplayLocation() != time.UTC {
+		t.Errorf("unset time zones are %s and %s, want UTC", cfg.Location(), cfg.DisplayLocation())
+	}
+	cfg.DefaultTimezone = "Europe/Berlin"
+	cfg.DisplayTimezone = "Asia/Tokyo"
+	if got := cfg.Location().String(); got != "Europe/Berlin" {
+		t.Errorf("Location() = %s, want Europe/Berlin", got)
+	}
+	if got := cfg.DisplayLocation().String(); got != "Asia/Tokyo" {
+		t.Errorf("DisplayLocation() = %s, want Asia/Tokyo", got)
+	}
+
+	window := config.MaintenanceWindow{Start: "02:00", End: "04:00"}
+	if got := window.Location(cfg.Location()).String(); got != "Europe/Berlin" {
+		t.Errorf("window location = %s, want defaultTimezone Europe/Berlin", got)
+	}
+	window.Timezone = "America/New_York"
+	if got := window.Location(cfg.Location()).String(); got != "America/New_York" {
+		t.Errorf("window location = %s, want its own America/New_York", got)
+	}
+}
+
+func TestMaintenanceWindowDST(t *testing.T) {
+	tests := []struct {
+		name        string
+		window      config.MaintenanceWindow
+		day         time.Time
+		wantMinutes int
+		wantFirst   time.Time
+	}{
+		{
+			name:        "ordinary day",
+			window:      config.MaintenanceWindow{Start: "02:00", End: "04:00"},
+			day:         ordinaryDay,
+			wantMinutes: 120,
+			wantFirst:   time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			// 02:00 to 03:00 doesn't exist, the window opens at 03:00
+			name:        "clock moved forward",
+			window:      config.MaintenanceWindow{Start: "02:00", End: "04:00"},
+			day:         springForward,
+			wantMinutes: 60,
+			wantFirst:   time.Date(2026, 3, 29, 1, 0, 0, 0, time.UTC),
+		},
+		{
+			// 02:00 to 03:00 is on the wall clock twice
+			name:        "clock moved back",
+			window:      config.MaintenanceWindow{Start: "02:00", End: "04:00"},
+			day:         fallBack,
+			wantMinutes: 180,
+			wantFirst:   time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "spanning midnight, clock moved forward",
+			window:      config.MaintenanceWindow{Days: []string{"Saturday"}, Start: "23:00", End: "04:00"},
+			day:         springForward,
+			wantMinutes: 240,
+			wantFirst:   time.Date(2026, 3, 28, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "spanning midnight, clock moved back",
+			window:      config.MaintenanceWindow{Days: []string{"Saturday"}, Start: "23:00", End: "04:00"},
+			day:         fallBack,
+			wantMinutes: 360,
+			wantFirst:   time.Date(2026, 10, 24, 21, 0, 0, 0, time.UTC),
+		},
+		{
+			// the window of the day is untouched by the transition
+			name:        "window after the transition",
+			window:      config.MaintenanceWindow{Start: "20:00", End: "22:00"},
+			day:         springForward,
+			wantMinutes: 120,
+			wantFirst:   time.Date(2026, 3, 29, 18, 0, 0, 0, time.UTC),
+		},
+	}
+
+	loc := berlin(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the first opening from the evening before, minute by minute
+			first := tt.day.Add(-3 * time.Hour)
+			for !tt.window.Contains(first, loc) && first.Before(tt.day.Add(24*time.Hour)) {
+				first = first.Add(time.Minute)
+			}
+			var minutes int
+			for m := first; tt.window.Contains(m, loc); m = m.Add(time.Minute) {
+				minutes++
+			}
+			if minutes != tt.wantMinutes {
+				t.Errorf("window is open for %d minutes, want %d", minutes, tt.wantMinutes)
+			}
+			if !first.Equal(tt.wantFirst) {
+				t.Errorf("window opens at %s, want %s", first, tt.wantFirst)
+			}
+
+			// NextStart agrees with Contains
+			if got := tt.window.NextStart(tt.wantFirst.Add(-12*time.Hour), loc); !got.Equal(tt.wantFirst) {
+				t.Errorf("NextStart() = %s, want %s", got.UTC(), tt.wantFirst)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowNextStart(t *testing.T) {
+	tests := []struct {
+		name   string
+		window config.MaintenanceWindow
+		now    time.Time
+		want   time.Time
+	}{
+		{
+			name:   "later today",
+			window: config.MaintenanceWindow{Start: "20:00", End: "22:00"},
+			now:    time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC),
+			want:   time.Date(2026, 10, 15, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			// the window is open, it opens next tomorrow
+			name:   "open now",
+			window: config.MaintenanceWindow{Start: "20:00", End: "22:00"},
+			now:    time.Date(2026, 10, 15, 18, 30, 0, 0, time.UTC),
+			want:   time.Date(2026, 10, 16, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "at the start",
+			window: config.MaintenanceWindow{Start: "20:00", End: "22:00"},
+			now:    time.Date(2026, 10, 15, 18, 0, 0, 0, time.UTC),
+			want:   time.Date(2026, 10, 16, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			// 2026-10-15 is a Thursday
+			name:   "day of the week",
+			window: config.MaintenanceWindow{Days: []string{"Saturday"}, Start: "20:00", End: "22:00"},
+			now:    time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC),
+			want:   time.Date(2026, 10, 17, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "same day next week",
+			window: config.MaintenanceWindow{Days: []string{"Thursday"}, Start: "08:00", End: "09:00"},
+			now:    time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC),
+			want:   time.Date(2026, 10, 22, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			// 02:30 is skipped, the window opens when the clock is moved forward
+			name:   "start skipped by the clock moved forward",
+			window: config.MaintenanceWindow{Start: "02:30", End: "04:00"},
+			now:    springForward,
+			want:   time.Date(2026, 3, 29, 1, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "start repeated by the clock moved back",
+			window: config.MaintenanceWindow{Start: "02:30", End: "04:00"},
+			now:    fallBack,
+			want:   time.Date(2026, 10, 25, 0, 30, 0, 0, time.UTC),
+		},
+		{
+			// the second 02:30 doesn't open the window again
+			name:   "between the repeated starts",
+			window: config.MaintenanceWindow{Start: "02:30", End: "04:00"},
+			now:    time.Date(2026, 10, 25, 1, 0, 0, 0, time.UTC),
+			want:   time.Date(2026, 10, 26, 1, 30, 0, 0, time.UTC),
+		},
+		{
+			name:   "time zone of the window",
+			window: config.MaintenanceWindow{Start: "20:00", End: "22:00", Timezone: "America/New_York"},
+			now:    time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC),
+			want:   time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	loc := berlin(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.window.NextStart(tt.now, loc)
+			if !got.Equal(tt.want) {
+				t.Errorf("NextStart(%s) = %s, want %s", tt.now, got.UTC(), tt.want)
+			}
+			if !tt.window.Contains(got, loc) || tt.window.Contains(got.Add(-time.Minute), loc) {
+				t.Errorf("window doesn't open at %s", got.UTC())
+			}
+		})
+	}
+}
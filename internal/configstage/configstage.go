@@ -0,0 +1,195 @@
+package configstage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/inventory"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// stateKey is the state store key of the active, staged and previous configs.
+const stateKey = "config-stages"
+
+// ActivatedAutomatically is who activated a config whose impact was within
+// the threshold, or the first config seen.
+const ActivatedAutomatically = "velora"
+
+// Stage is a config velora has seen, with the secrets redacted.
+type Stage struct {
+	Hash string `json:"hash"`
+	// SeenAt is when a run first loaded the config.
+	SeenAt      time.Time `json:"seenAt"`
+	ActivatedAt time.Time `json:"activatedAt,omitempty"`
+	ActivatedBy string    `json:"activatedBy,omitempty"`
+	// Replaces is the hash of the config active when it was seen, Impact
+	// is estimated against it. Both are empty for the first config.
+	Replaces string          `json:"replaces,omitempty"`
+	Impact   *Impact         `json:"impact,omitempty"`
+	Config   json.RawMessage `json:"config"`
+}
+
+// String describes the stage for run summaries.
+func (s *Stage) String() string {
+	if s.Impact == nil {
+		return "config " + s.Hash
+	}
+	return fmt.Sprintf("config %s, estimated impact %s", s.Hash, s.Impact)
+}
+
+// config returns the config of the stage.
+func (s *Stage) config() (*config.Config, error) {
+	cfg := &config.Config{}
+	if err := json.Unmarshal(s.Config, cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode config %s: %w", s.Hash, err)
+	}
+	return cfg, nil
+}
+
+// State is the active config, the config staged for activation if any, and
+// the config active before.
+type State struct {
+	Active   *Stage `json:"active,omitempty"`
+	Staged   *Stage `json:"staged,omitempty"`
+	Previous *Stage `json:"previous,omitempty"`
+}
+
+// Manager tracks the configs persisted in the state store.
+type Manager struct {
+	store state.Store
+	mu    sync.Mutex
+}
+
+// NewManager creates a new config stage manager instance.
+func NewManager(store state.Store) *Manager {
+	return &Manager{store: store}
+}
+
+// Reconcile compares the loaded config with the active one. A changed config
+// is estimated against the latest inventory snapshot and activated, unless
+// staging is enabled and the impact exceeds its threshold or can't be
+// estimated. Then it is staged and returned: runs under it must not write
+// until it is activated. Returns nil if the config is active.
+func (m *Manager) Reconcile(cfg *config.Config) (*Stage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	hash := cfg.Hash()
+	switch {
+	case st.Active != nil && st.Active.Hash == hash:
+		return nil, nil
+	case st.Staged != nil && st.Staged.Hash == hash:
+		return st.Staged, nil
+	}
+
+	now := time.Now().UTC()
+	stage, err := newStage(cfg, now)
+	if err != nil {
+		return nil, err
+	}
+	if st.Active == nil {
+		stage.ActivatedAt = now
+		stage.ActivatedBy = ActivatedAutomatically
+		st.Active = stage
+		return nil, m.save(st)
+	}
+
+	active, err := st.Active.config()
+	if err != nil {
+		return nil, err
+	}
+	stage.Replaces = st.Active.Hash
+	snapshot, err := inventory.LoadSnapshot(m.store)
+	if err != nil && !errors.Is(err, inventory.ErrNoSnapshot) {
+		return nil, err
+	}
+	if stage.Impact, err = Estimate(active, cfg, snapshot); err != nil {
+		return nil, err
+	}
+
+	if staging := cfg.ConfigStaging; staging != nil &&
+		(!stage.Impact.Estimated() || stage.Impact.Resources() > staging.EffectiveMaxImpactedResources()) {
+		st.Staged = stage
+		fmt.Printf("WARNING: staged %s, it only applies once activated with velora config activate\n", stage)
+		return stage, m.save(st)
+	}
+
+	stage.ActivatedAt = now
+	stage.ActivatedBy = ActivatedAutomatically
+	st.Previous, st.Active = st.Active, stage
+	// a staged config is superseded by the one activated
+	st.Staged = nil
+	fmt.Printf("activated %s\n", stage)
+	return nil, m.save(st)
+}
+
+// Activate activates the staged config. hash must be the staged config's if
+// set, so a config staged in the meantime isn't activated by mistake.
+func (m *Manager) Activate(hash, by string) (*Stage, error) {
+	if by == "" {
+		return nil, fmt.Errorf("who activates the config is required")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	if st.Staged == nil {
+		return nil, fmt.Errorf("no config is staged")
+	}
+	if hash != "" && hash != st.Staged.Hash {
+		return nil, fmt.Errorf("config %s isn't staged, config %s is", hash, st.Staged.Hash)
+	}
+
+	st.Staged.ActivatedAt = time.Now().UTC()
+	st.Staged.ActivatedBy = by
+	st.Previous, st.Active, st.Staged = st.Active, st.Staged, nil
+	if err := m.save(st); err != nil {
+		return nil, err
+	}
+	return st.Active, nil
+}
+
+// State returns the active, staged and previous configs.
+func (m *Manager) State() (*State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.load()
+}
+
+// newStage returns the stage of the config, not activated.
+func newStage(cfg *config.Config, now time.Time) (*Stage, error) {
+	data, err := json.Marshal(cfg.Redacted())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	return &Stage{Hash: cfg.Hash(), SeenAt: now, Config: data}, nil
+}
+
+// load reads the state from the state store.
+func (m *Manager) load() (*State, error) {
+	st := &State{}
+	if err := m.store.Get(stateKey, st); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load config stages: %w", err)
+	}
+	return st, nil
+}
+
+// save writes the state to the state store.
+func (m *Manager) save(st *State) error {
+	if err := m.store.Put(stateKey, st); err != nil {
+		return fmt.Errorf("failed to save config stages: %w", err)
+	}
+	return nil
+}
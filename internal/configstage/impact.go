@@ -0,0 +1,261 @@
+package configstage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/controllers/routing"
+	"github.com/akos011221/velora/internal/inventory"
+)
+
+// maxValueLength bounds the values shown in the changes of a config.
+const maxValueLength = 80
+
+// Impact is the estimated blast radius of a config change, computed against
+// the latest inventory snapshot. Only routing is estimated, it is what
+// rewrites resources in bulk.
+type Impact struct {
+	// SnapshotAt is when the inventory the estimate is computed on was
+	// collected, zero if there is none.
+	SnapshotAt time.Time `json:"snapshotAt,omitempty"`
+	// Changes describe what differs from the active config.
+	Changes []string `json:"changes"`
+	// NonCompliant counts the compliant resources that would become
+	// non-compliant.
+	NonCompliant int `json:"nonCompliant"`
+	// Rewritten counts the routes that would be created, updated or deleted
+	// that the active config leaves alone.
+	Rewritten int `json:"rewritten"`
+	// Subscriptions counts the subscriptions with impacted resources.
+	Subscriptions int `json:"subscriptions"`
+	// NotEstimated are the subscriptions whose impact couldn't be
+	// estimated, with the reason.
+	NotEstimated []string `json:"notEstimated,omitempty"`
+}
+
+// Resources returns the number of impacted resources.
+func (i *Impact) Resources() int {
+	return i.NonCompliant + i.Rewritten
+}
+
+// Estimated reports whether an inventory snapshot was available.
+func (i *Impact) Estimated() bool {
+	return !i.SnapshotAt.IsZero()
+}
+
+// String summarizes the impact for run summaries and notifications.
+func (i *Impact) String() string {
+	if !i.Estimated() {
+		return "not estimated, no inventory snapshot"
+	}
+	s := fmt.Sprintf("%d resources in %d subscriptions: %d compliant resources become non-compliant, %d routes rewritten",
+		i.Resources(), i.Subscriptions, i.NonCompliant, i.Rewritten)
+	if len(i.NotEstimated) > 0 {
+		s += fmt.Sprintf(", %d subscriptions not estimated", len(i.NotEstimated))
+	}
+	return s
+}
+
+// Estimate estimates the impact of replacing the active config with the
+// candidate against the inventory snapshot, without reading Azure. Without
+// a snapshot only the changes are listed.
+func Estimate(active, candidate *config.Config, snapshot *inventory.Snapshot) (*Impact, error) {
+	changes, err := diff(active, candidate)
+	if err != nil {
+		return nil, err
+	}
+	impact := &Impact{Changes: changes}
+	if snapshot == nil {
+		return impact, nil
+	}
+	impact.SnapshotAt = snapshot.CollectedAt
+
+	inSnapshot := make(map[string]bool, len(snapshot.Subscriptions))
+	for _, subID := range snapshot.Subscriptions {
+		inSnapshot[strings.ToLower(subID)] = true
+	}
+	// subscriptions removed from the config are no longer changed at all
+	for _, subID := range candidate.SubscriptionIDs() {
+		if !inSnapshot[strings.ToLower(subID)] {
+			impact.NotEstimated = append(impact.NotEstimated, subID+": not in the inventory snapshot")
+			continue
+		}
+		inv := routing.SnapshotInventory(snapshot, subID)
+		before, err := evaluate(active, subID, inv)
+		if err != nil {
+			impact.NotEstimated = append(impact.NotEstimated, fmt.Sprintf("%s: %s", subID, err))
+			continue
+		}
+		after, err := evaluate(candidate, subID, inv)
+		if err != nil {
+			impact.NotEstimated = append(impact.NotEstimated, fmt.Sprintf("%s: %s", subID, err))
+			continue
+		}
+
+		compliant := make(map[string]bool, len(after.Compliant))
+		for _, c := range after.Compliant {
+			compliant[c.Rule.ID+" "+strings.ToLower(c.ResourceID)] = true
+		}
+		pending := make(map[string]bool, len(before.Changes))
+		for _, c := range before.Changes {
+			pending[changeKey(c)] = true
+		}
+		nonCompliant, rewritten := 0, 0
+		for _, c := range before.Compliant {
+			if !compliant[c.Rule.ID+" "+strings.ToLower(c.ResourceID)] {
+				nonCompliant++
+			}
+		}
+		for _, c := range after.Changes {
+			if !pending[changeKey(c)] {
+				rewritten++
+			}
+		}
+		if nonCompliant+rewritten > 0 {
+			impact.Subscriptions++
+		}
+		impact.NonCompliant += nonCompliant
+		impact.Rewritten += rewritten
+	}
+	return impact, nil
+}
+
+// evaluate returns the routing changes and compliant resources of the
+// subscription under the config. Subscriptions it doesn't route have none.
+func evaluate(cfg *config.Config, subscriptionID string, inv routing.Inventory) (routing.ChangeSet, error) {
+	subCFG, ok := cfg.Subscriptions[subscriptionID]
+	if !ok || !cfg.Features.RoutingEnforcement ||
+		(!subCFG.RequireNVARouting && !subCFG.SubnetToSubnetDeny && subCFG.ForbiddenNextHops == nil) {
+		return routing.ChangeSet{}, nil
+	}
+	hub := cfg.Hub(subCFG.HubName)
+	if hub == nil {
+		return routing.ChangeSet{}, fmt.Errorf("hub %s isn't configured", subCFG.HubName)
+	}
+	if hub.IsVirtualWAN() {
+		return routing.ChangeSet{}, fmt.Errorf("virtual WAN hub %s isn't estimated", hub.Name)
+	}
+	if hub.NVANextHop == "" {
+		return routing.ChangeSet{}, fmt.Errorf("the NVA of hub %s is resolved at run time", hub.Name)
+	}
+	return routing.Evaluate(routing.PolicyFor(cfg, subCFG, hub), inv)
+}
+
+// changeKey identifies a route change with its outcome.
+func changeKey(c routing.RouteChange) string {
+	return strings.ToLower(c.ID()) + " " + c.Description()
+}
+
+// diff describes the differences between the configs: the fields of the
+// hubs by name and the subscriptions by ID, and the other sections as a
+// whole. Secrets are redacted.
+func diff(active, candidate *config.Config) ([]string, error) {
+	before, err := sections(active)
+	if err != nil {
+		return nil, err
+	}
+	after, err := sections(candidate)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []string
+	for _, key := range sortedKeys(before, after) {
+		switch key {
+		case "hubs":
+			beforeHubs, afterHubs := make(map[string]json.RawMessage), make(map[string]json.RawMessage)
+			for _, hub := range active.Hubs {
+				beforeHubs[hub.Name], _ = json.Marshal(hub)
+			}
+			for _, hub := range candidate.Hubs {
+				afterHubs[hub.Name], _ = json.Marshal(hub)
+			}
+			changes = append(changes, diffObjects("hub", beforeHubs, afterHubs)...)
+		case "subscriptions":
+			beforeSubs, afterSubs := make(map[string]json.RawMessage), make(map[string]json.RawMessage)
+			for subID, sub := range active.Subscriptions {
+				beforeSubs[subID], _ = json.Marshal(sub)
+			}
+			for subID, sub := range candidate.Subscriptions {
+				afterSubs[subID], _ = json.Marshal(sub)
+			}
+			changes = append(changes, diffObjects("subscription", beforeSubs, afterSubs)...)
+		default:
+			if !bytes.Equal(before[key], after[key]) {
+				changes = append(changes, key+" changed")
+			}
+		}
+	}
+	return changes, nil
+}
+
+// diffObjects describes the objects added, removed, and the fields changed.
+func diffObjects(kind string, before, after map[string]json.RawMessage) []string {
+	var changes []string
+	for _, name := range sortedKeys(before, after) {
+		old, hadOld := before[name]
+		value, hasNew := after[name]
+		switch {
+		case !hadOld:
+			changes = append(changes, fmt.Sprintf("%s %s added", kind, name))
+		case !hasNew:
+			changes = append(changes, fmt.Sprintf("%s %s removed", kind, name))
+		case !bytes.Equal(old, value):
+			var oldFields, newFields map[string]json.RawMessage
+			if json.Unmarshal(old, &oldFields) != nil || json.Unmarshal(value, &newFields) != nil {
+				changes = append(changes, fmt.Sprintf("%s %s changed", kind, name))
+				continue
+			}
+			for _, field := range sortedKeys(oldFields, newFields) {
+				if !bytes.Equal(oldFields[field], newFields[field]) {
+					changes = append(changes, fmt.Sprintf("%s %s %s: %s -> %s", kind, name, field, shorten(oldFields[field]), shorten(newFields[field])))
+				}
+			}
+		}
+	}
+	return changes
+}
+
+// sections returns the top-level sections of the redacted config.
+func sections(cfg *config.Config) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(cfg.Redacted())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	var s map[string]json.RawMessage
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to decode config: %w", err)
+	}
+	return s, nil
+}
+
+// shorten returns the JSON value, truncated, "unset" if missing.
+func shorten(value json.RawMessage) string {
+	if len(value) == 0 {
+		return "unset"
+	}
+	if len(value) > maxValueLength {
+		return string(value[:maxValueLength]) + "..."
+	}
+	return string(value)
+}
+
+// sortedKeys returns the keys of both maps, sorted.
+func sortedKeys(a, b map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
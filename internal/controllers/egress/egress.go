@@ -0,0 +1,284 @@
+package egress
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/guard"
+	"github.com/akos011221/velora/internal/inventory"
+)
+
+// reservedSubnets are the subnets of Azure services that need their own
+// public IPs, they aren't spoke workloads.
+var reservedSubnets = map[string]bool{
+	"gatewaysubnet":                 true,
+	"azurefirewallsubnet":           true,
+	"azurefirewallmanagementsubnet": true,
+	"azurebastionsubnet":            true,
+	"routeserversubnet":             true,
+}
+
+// Enforcer flags the egress bypassing the NVA in the subscriptions that
+// require NVA routing: NAT gateways on spoke subnets and NICs with public
+// IPs. The default route to the NVA doesn't apply to either. It is
+// report-only, detaching them would cut the workloads off.
+type Enforcer struct {
+	clientFactory *azure.ClientFactory
+	config        *config.Config
+	inventory     *inventory.RunInventory
+	guard         *guard.Guard
+	findings      []findings.Finding
+	compliance    *findings.ComplianceLog
+}
+
+// NewEnforcer creates a new egress enforcer instance. VNets are read from
+// the run inventory, the guard decides which subscriptions are skipped.
+func NewEnforcer(clientFactory *azure.ClientFactory, config *config.Config, runInventory *inventory.RunInventory, guard *guard.Guard) *Enforcer {
+	return &Enforcer{
+		clientFactory: clientFactory,
+		config:        config,
+		inventory:     runInventory,
+		compliance:    findings.NewComplianceLog(config),
+		guard:         guard,
+	}
+}
+
+// Findings returns the findings recorded during enforcement.
+func (e *Enforcer) Findings() []findings.Finding {
+	return e.findings
+}
+
+// Compliance returns the compliant resources recorded during enforcement.
+func (e *Enforcer) Compliance() *findings.ComplianceLog {
+	return e.compliance
+}
+
+// EnforceAll scans the subscriptions requiring NVA routing for egress
+// bypassing the NVA.
+func (e *Enforcer) EnforceAll(ctx context.Context) error {
+	if !e.config.Features.ComplianceScanning {
+		return nil
+	}
+
+	for _, subID := range e.config.SubscriptionIDs() {
+		subCFG := e.config.Subscriptions[subID]
+		if !subCFG.RequireNVARouting {
+			continue
+		}
+		if reason, err := e.guard.SkipReason(subID); err != nil {
+			return err
+		} else if reason != "" {
+			fmt.Printf("skipped subscription %s: %s\n", subID, reason)
+			continue
+		}
+
+		if err := e.guard.Contain("egress", subID, func() error {
+			return e.scanSubscription(ctx, subID, subCFG)
+		}); err != nil {
+			if guard.IsPanic(err) || e.guard.SkipInactive(subID, err) {
+				continue
+			}
+			return fmt.Errorf("failed to scan egress in subscription %s: %w", subID, err)
+		}
+	}
+	return nil
+}
+
+// scanSubscription checks the spoke subnets for NAT gateways, and the NICs
+// in them for public IPs unless the scan is turned off.
+func (e *Enforcer) scanSubscription(ctx context.Context, subscriptionID string, subCFG config.SubscriptionConfig) error {
+	vnets, err := e.inventory.VNets(ctx, subscriptionID)
+	if err != nil {
+		return err
+	}
+
+	// spoke subnets by lower-case ID
+	spokeSubnets := make(map[string]*armnetwork.Subnet)
+	var natGateways map[string]*armnetwork.NatGateway
+	for _, vnet := range vnets {
+		if vnet.ID == nil || vnet.Properties == nil || e.config.IsHubVNet(*vnet.ID) {
+			continue
+		}
+		for _, subnet := range vnet.Properties.Subnets {
+			if subnet == nil || subnet.ID == nil || subnet.Name == nil || reservedSubnets[strings.ToLower(*subnet.Name)] {
+				continue
+			}
+			spokeSubnets[strings.ToLower(*subnet.ID)] = subnet
+
+			if subnet.Properties == nil || subnet.Properties.NatGateway == nil || subnet.Properties.NatGateway.ID == nil {
+				e.compliance.Record(findings.RuleEgressNATGateway, subscriptionID, *subnet.ID)
+				continue
+			}
+			// NAT gateways are only listed if a subnet uses one
+			if natGateways == nil {
+				if natGateways, err = e.listNATGateways(ctx, subscriptionID); err != nil {
+					return err
+				}
+			}
+			e.reportNATGateway(subscriptionID, vnet, subnet, natGateways[strings.ToLower(*subnet.Properties.NatGateway.ID)])
+		}
+	}
+
+	if !subCFG.ScansNICPublicIPs() {
+		fmt.Printf("skipped NIC public IP scan of subscription %s: nicPublicIpScan is false\n", subscriptionID)
+		return nil
+	}
+	if len(spokeSubnets) == 0 {
+		return nil
+	}
+	return e.scanNICs(ctx, subscriptionID, spokeSubnets)
+}
+
+// reportNATGateway records a finding for the spoke subnet using the NAT
+// gateway, unless the NAT gateway or the VNet is exempt. natGateway is nil
+// if it wasn't listed, e.g. it was created since.
+func (e *Enforcer) reportNATGateway(subscriptionID string, vnet *armnetwork.VirtualNetwork, subnet *armnetwork.Subnet, natGateway *armnetwork.NatGateway) {
+	natGatewayID := *subnet.Properties.NatGateway.ID
+	if findings.Exempt(vnet.Tags, findings.RuleEgressNATGateway.ID) || (natGateway != nil && findings.Exempt(natGateway.Tags, findings.RuleEgressNATGateway.ID)) {
+		fmt.Printf("skipped subnet %s: NAT gateway %s is exempt by its %s tag\n", *subnet.ID, path.Base(natGatewayID), findings.ExemptionTag)
+		return
+	}
+
+	publicIPs := "public IPs unknown"
+	if natGateway != nil && natGateway.Properties != nil {
+		publicIPs = describePublicIPs(natGateway.Properties.PublicIPAddresses, natGateway.Properties.PublicIPPrefixes)
+	}
+	e.findings = append(e.findings, findings.New(findings.RuleEgressNATGateway, e.config.Rules,
+		subscriptionID, *subnet.ID, fmt.Sprintf("subnet %s of VNet %s sends its outbound traffic through NAT gateway %s (%s), bypassing the NVA",
+			*subnet.Name, *vnet.Name, path.Base(natGatewayID), publicIPs),
+		map[string]string{
+			"subnet":     *subnet.Name,
+			"natGateway": path.Base(natGatewayID),
+			"publicIPs":  publicIPs,
+		}))
+}
+
+// listNATGateways returns the NAT gateways of the subscription by lower-case ID.
+func (e *Enforcer) listNATGateways(ctx context.Context, subscriptionID string) (map[string]*armnetwork.NatGateway, error) {
+	natGatewaysClient, err := e.clientFactory.ForSubscription(subscriptionID).NewNatGatewaysClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	natGateways := make(map[string]*armnetwork.NatGateway)
+	pager := natGatewaysClient.NewListAllPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list NAT gateways: %w", err)
+		}
+		for _, natGateway := range page.Value {
+			if natGateway != nil && natGateway.ID != nil {
+				natGateways[strings.ToLower(*natGateway.ID)] = natGateway
+			}
+		}
+	}
+	return natGateways, nil
+}
+
+// scanNICs lists the NICs of the subscription once and records a finding
+// for every NIC in a spoke subnet with a public IP, unless it is exempt.
+func (e *Enforcer) scanNICs(ctx context.Context, subscriptionID string, spokeSubnets map[string]*armnetwork.Subnet) error {
+	interfacesClient, err := e.clientFactory.ForSubscription(subscriptionID).NewInterfacesClient(ctx)
+	if err != nil {
+		return err
+	}
+	pager := interfacesClient.NewListAllPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list network interfaces: %w", err)
+		}
+		for _, nic := range page.Value {
+			if nic == nil || nic.ID == nil || nic.Properties == nil {
+				continue
+			}
+			e.checkNIC(subscriptionID, nic, spokeSubnets)
+		}
+	}
+	return nil
+}
+
+// checkNIC records whether the NIC has a public IP in a spoke subnet.
+func (e *Enforcer) checkNIC(subscriptionID string, nic *armnetwork.Interface, spokeSubnets map[string]*armnetwork.Subnet) {
+	var subnet *armnetwork.Subnet
+	var publicIPs []string
+	for _, ipConfig := range nic.Properties.IPConfigurations {
+		if ipConfig == nil || ipConfig.Properties == nil || ipConfig.Properties.Subnet == nil || ipConfig.Properties.Subnet.ID == nil {
+			continue
+		}
+		spoke, ok := spokeSubnets[strings.ToLower(*ipConfig.Properties.Subnet.ID)]
+		if !ok {
+			continue
+		}
+		if subnet == nil {
+			subnet = spoke
+		}
+		if pip := ipConfig.Properties.PublicIPAddress; pip != nil && pip.ID != nil {
+			publicIPs = append(publicIPs, path.Base(*pip.ID))
+		}
+	}
+	if subnet == nil {
+		return
+	}
+	if len(publicIPs) == 0 {
+		e.compliance.Record(findings.RuleEgressNICPublicIP, subscriptionID, *nic.ID)
+		return
+	}
+	if findings.Exempt(nic.Tags, findings.RuleEgressNICPublicIP.ID) {
+		fmt.Printf("skipped NIC %s: exempt by its %s tag\n", *nic.ID, findings.ExemptionTag)
+		return
+	}
+
+	nicName := path.Base(*nic.ID)
+	attachedTo := attachedResource(nic)
+	e.findings = append(e.findings, findings.New(findings.RuleEgressNICPublicIP, e.config.Rules,
+		subscriptionID, *nic.ID, fmt.Sprintf("NIC %s of %s in subnet %s has public IP %s, giving it direct egress that bypasses the NVA",
+			nicName, attachedTo, *subnet.Name, strings.Join(publicIPs, ", ")),
+		map[string]string{
+			"nic":        nicName,
+			"attachedTo": attachedTo,
+			"subnet":     *subnet.Name,
+			"publicIP":   strings.Join(publicIPs, ", "),
+		}))
+}
+
+// attachedResource describes the resource the NIC is attached to, so the
+// finding reaches the team running it.
+func attachedResource(nic *armnetwork.Interface) string {
+	switch {
+	case nic.Properties.VirtualMachine != nil && nic.Properties.VirtualMachine.ID != nil:
+		return "VM " + path.Base(*nic.Properties.VirtualMachine.ID)
+	case nic.Properties.PrivateEndpoint != nil && nic.Properties.PrivateEndpoint.ID != nil:
+		return "private endpoint " + path.Base(*nic.Properties.PrivateEndpoint.ID)
+	default:
+		return "no VM"
+	}
+}
+
+// describePublicIPs names the public IPs and prefixes of a NAT gateway.
+func describePublicIPs(addresses, prefixes []*armnetwork.SubResource) string {
+	var names []string
+	for _, group := range []struct {
+		label     string
+		resources []*armnetwork.SubResource
+	}{
+		{"public IP ", addresses},
+		{"prefix ", prefixes},
+	} {
+		for _, r := range group.resources {
+			if r != nil && r.ID != nil {
+				names = append(names, group.label+path.Base(*r.ID))
+			}
+		}
+	}
+	if len(names) == 0 {
+		return "no public IPs"
+	}
+	return strings.Join(names, ", ")
+}
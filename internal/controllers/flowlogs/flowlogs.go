@@ -0,0 +1,440 @@
+package flowlogs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/failover"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/guard"
+	"github.com/akos011221/velora/internal/inventory"
+	"github.com/akos011221/velora/internal/naming"
+	"github.com/akos011221/velora/internal/plan"
+	"github.com/akos011221/velora/internal/unprocessable"
+)
+
+// defaultAnalyticsInterval is the traffic analytics interval in minutes.
+const defaultAnalyticsInterval = 60
+
+// watcher is the Network Watcher of a region.
+type watcher struct {
+	subscriptionID string
+	resourceGroup  string
+	name           string
+}
+
+// nsg is an NSG attached to a subnet of a managed VNet.
+type nsg struct {
+	id     string
+	region string
+}
+
+// Enforcer checks that every NSG attached to spoke subnets has a flow log
+// matching the hub's template.
+type Enforcer struct {
+	clientFactory *azure.ClientFactory
+	config        *config.Config
+	inventory     *inventory.RunInventory
+	guard         *guard.Guard
+	failovers     *failover.Manager
+	findings      []findings.Finding
+	compliance    *findings.ComplianceLog
+}
+
+// NewEnforcer creates a new flow log enforcer instance. The NSGs of the
+// spokes are found through the VNets of the run inventory.
+func NewEnforcer(clientFactory *azure.ClientFactory, config *config.Config, runInventory *inventory.RunInventory, guard *guard.Guard,
+	failovers *failover.Manager) *Enforcer {
+	return &Enforcer{
+		clientFactory: clientFactory,
+		config:        config,
+		inventory:     runInventory,
+		compliance:    findings.NewComplianceLog(config),
+		guard:         guard,
+		failovers:     failovers,
+	}
+}
+
+// Findings returns the findings recorded during enforcement.
+func (e *Enforcer) Findings() []findings.Finding {
+	return e.findings
+}
+
+// Compliance returns the compliant resources recorded during enforcement.
+func (e *Enforcer) Compliance() *findings.ComplianceLog {
+	return e.compliance
+}
+
+// EnforceAll checks the flow logs of all subscriptions whose hub has a
+// flow log template.
+func (e *Enforcer) EnforceAll(ctx context.Context) error {
+	if !e.config.Features.FlowLogs {
+		return nil
+	}
+
+	for subID, subCFG := range e.config.Subscriptions {
+		if reason, err := e.guard.SkipReason(subID); err != nil {
+			return err
+		} else if reason != "" {
+			fmt.Printf("skipped subscription %s: %s\n", subID, reason)
+			continue
+		}
+
+		// the hub isn't configured, the checks needing it are skipped
+		if _, missing := e.guard.HubMissing(subID); missing {
+			continue
+		}
+
+		hubCFG, err := e.failovers.ActiveHub(e.config, subCFG.HubName)
+		if err != nil {
+			return fmt.Errorf("failed to resolve hub for subscription %s: %w", subID, err)
+		}
+		if hubCFG.FlowLogs == nil {
+			continue
+		}
+
+		if err := e.guard.Contain("flowlogs", subID, func() error {
+			return e.enforceSubscription(ctx, subID, hubCFG)
+		}); err != nil {
+			if guard.IsPanic(err) || e.guard.SkipInactive(subID, err) {
+				continue
+			}
+			return fmt.Errorf("failed to enforce flow logs for subscription %s: %w", subID, err)
+		}
+	}
+	return nil
+}
+
+// enforceSubscription checks the NSGs attached to the subscription's subnets
+// against the flow logs of the Network Watcher of their region. The watcher
+// is looked up in the NSG's subscription, which can differ from the subnet's.
+func (e *Enforcer) enforceSubscription(ctx context.Context, subscriptionID string, hubCFG *config.HubVNetConfig) error {
+	nsgs, err := listNSGs(ctx, e.inventory, subscriptionID)
+	if err != nil {
+		return err
+	}
+
+	// malformed IDs are skipped, unless there are so many that something
+	// systemic is wrong and nothing should be changed
+	budget := unprocessable.NewBudget(e.config, subscriptionID)
+	bySubscription := make(map[string][]nsg)
+	var order []string
+	for _, n := range nsgs {
+		budget.Seen()
+		if !azure.ValidResourceID(n.id, "networkSecurityGroups") {
+			e.findings = append(e.findings, budget.Skip("NSG", n.id))
+			continue
+		}
+		nsgSubscriptionID := azure.SubscriptionIDOf(n.id)
+		if _, ok := bySubscription[nsgSubscriptionID]; !ok {
+			order = append(order, nsgSubscriptionID)
+		}
+		bySubscription[nsgSubscriptionID] = append(bySubscription[nsgSubscriptionID], n)
+	}
+	if err := budget.Err(); err != nil {
+		return err
+	}
+
+	for _, nsgSubscriptionID := range order {
+		if !strings.EqualFold(nsgSubscriptionID, subscriptionID) && !e.config.Manages(nsgSubscriptionID) &&
+			!strings.EqualFold(nsgSubscriptionID, azure.SubscriptionIDOf(hubCFG.VNetID)) {
+			for _, n := range bySubscription[nsgSubscriptionID] {
+				e.findings = append(e.findings, findings.New(findings.RuleUnmanagedSubscription, e.config.Rules,
+					subscriptionID, n.id, fmt.Sprintf("NSG %s is in unmanaged subscription %s, flow logs not checked", n.id, nsgSubscriptionID),
+					map[string]string{
+						"kind":         "NSG",
+						"resource":     azure.ExtractResourceIDParts(n.id)["networkSecurityGroups"],
+						"subscription": nsgSubscriptionID,
+					}))
+			}
+			continue
+		}
+
+		if err := e.enforceNSGs(ctx, subscriptionID, nsgSubscriptionID, bySubscription[nsgSubscriptionID], hubCFG.FlowLogs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enforceNSGs checks NSGs living in the same subscription.
+func (e *Enforcer) enforceNSGs(ctx context.Context, subscriptionID, nsgSubscriptionID string, nsgs []nsg, template *config.FlowLogsConfig) error {
+	nsgFactory := e.clientFactory.ForSubscription(nsgSubscriptionID)
+
+	watchers, err := listWatchers(ctx, nsgFactory)
+	if err != nil {
+		return err
+	}
+	flowLogsClient, err := nsgFactory.NewFlowLogsClient(ctx)
+	if err != nil {
+		return err
+	}
+	// flow logs per region, keyed by the lower-case target NSG ID
+	flowLogs := make(map[string]map[string]*armnetwork.FlowLog)
+
+	for _, n := range nsgs {
+		w, ok := watchers[n.region]
+		if !ok {
+			e.findings = append(e.findings, findings.New(findings.RuleFlowLogNoWatcher, e.config.Rules,
+				subscriptionID, n.id, fmt.Sprintf("no Network Watcher in region %s, flow logs of NSG %s can't be checked", n.region, n.id),
+				map[string]string{
+					"region":       n.region,
+					"subscription": nsgSubscriptionID,
+				}))
+			continue
+		}
+
+		if _, ok := flowLogs[n.region]; !ok {
+			flowLogs[n.region], err = listFlowLogs(ctx, flowLogsClient, w)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := e.enforceNSG(ctx, subscriptionID, n, w, flowLogs[n.region][strings.ToLower(n.id)], template); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enforceNSG compares the flow log of the NSG with the template and, with
+// auto remediation, creates or updates it.
+func (e *Enforcer) enforceNSG(ctx context.Context, subscriptionID string,
+	n nsg, w watcher, existing *armnetwork.FlowLog, template *config.FlowLogsConfig) error {
+	if matches(existing, template) {
+		e.compliance.Record(findings.RuleFlowLog, subscriptionID, n.id)
+		return nil
+	}
+
+	nsgName := azure.ExtractResourceIDParts(n.id)["networkSecurityGroups"]
+	flowLogName := ""
+	etag := ""
+	if existing != nil && existing.Name != nil {
+		flowLogName = *existing.Name
+		if existing.Etag != nil {
+			etag = *existing.Etag
+		}
+	} else {
+		var err error
+		if flowLogName, err = naming.ResourceName(nsgName + "-flowlog"); err != nil {
+			return err
+		}
+	}
+
+	target := "storage account " + template.StorageAccountID
+	if template.TrafficAnalytics != nil {
+		target += " and traffic analytics to workspace " + template.TrafficAnalytics.WorkspaceResourceID
+	}
+	e.findings = append(e.findings, findings.New(findings.RuleFlowLog, e.config.Rules,
+		subscriptionID, n.id, fmt.Sprintf("NSG %s has no enabled flow log matching the hub template", nsgName),
+		map[string]string{
+			"flowLog": flowLogName,
+			"nsg":     nsgName,
+			"target":  target,
+		}))
+
+	if !e.config.Features.AutoRemediation || !e.guard.WritesAllowed(w.subscriptionID) ||
+		e.guard.InGracePeriod(ctx, subscriptionID, n.id) {
+		return nil
+	}
+
+	flowLog := desiredFlowLog(n, template)
+	body, err := json.Marshal(flowLog)
+	if err != nil {
+		return fmt.Errorf("failed to encode flow log %s: %w", flowLogName, err)
+	}
+	var before json.RawMessage
+	if existing != nil {
+		if before, err = json.Marshal(existing); err != nil {
+			return fmt.Errorf("failed to encode flow log %s: %w", flowLogName, err)
+		}
+	}
+	flowLogID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/networkWatchers/%s/flowLogs/%s",
+		w.subscriptionID, w.resourceGroup, w.name, flowLogName)
+	write := plan.Change{
+		SubscriptionID: w.subscriptionID,
+		ResourceID:     flowLogID,
+		APIVersion:     e.clientFactory.APIVersion(azure.ResourceTypeFlowLogs),
+		Etag:           etag,
+		Body:           body,
+		Description:    fmt.Sprintf("flow log %s on NSG %s", flowLogName, nsgName),
+		Before:         before,
+	}
+	if e.guard.Planned(write) {
+		return nil
+	}
+
+	// a new flow log must not exist yet, an existing one must be unchanged
+	if err := plan.Write(ctx, e.clientFactory, write); err != nil {
+		if e.guard.SkipPolicyDenied(w.subscriptionID, flowLogID, err) {
+			return nil
+		}
+		return fmt.Errorf("failed to create or update flow log %s: %w", flowLogName, err)
+	}
+	return nil
+}
+
+// matches reports whether the flow log is enabled and targets the storage
+// account with the retention and, if configured, the traffic analytics
+// workspace and interval of the template. Everything desiredFlowLog sets is
+// compared, so a remediated flow log isn't written again.
+func matches(flowLog *armnetwork.FlowLog, template *config.FlowLogsConfig) bool {
+	if flowLog == nil || flowLog.Properties == nil {
+		return false
+	}
+	props := flowLog.Properties
+	if props.Enabled == nil || !*props.Enabled || props.StorageID == nil ||
+		!strings.EqualFold(*props.StorageID, template.StorageAccountID) {
+		return false
+	}
+	var days int32
+	retentionEnabled := false
+	if props.RetentionPolicy != nil {
+		if props.RetentionPolicy.Days != nil {
+			days = *props.RetentionPolicy.Days
+		}
+		retentionEnabled = props.RetentionPolicy.Enabled != nil && *props.RetentionPolicy.Enabled
+	}
+	if days != template.RetentionDays || retentionEnabled != (template.RetentionDays > 0) {
+		return false
+	}
+
+	if template.TrafficAnalytics == nil {
+		return true
+	}
+	if props.FlowAnalyticsConfiguration == nil || props.FlowAnalyticsConfiguration.NetworkWatcherFlowAnalyticsConfiguration == nil {
+		return false
+	}
+	analytics := props.FlowAnalyticsConfiguration.NetworkWatcherFlowAnalyticsConfiguration
+	interval := template.TrafficAnalytics.IntervalMinutes
+	if interval == 0 {
+		interval = defaultAnalyticsInterval
+	}
+	return analytics.Enabled != nil && *analytics.Enabled && analytics.WorkspaceResourceID != nil &&
+		strings.EqualFold(*analytics.WorkspaceResourceID, template.TrafficAnalytics.WorkspaceResourceID) &&
+		analytics.TrafficAnalyticsInterval != nil && *analytics.TrafficAnalyticsInterval == interval
+}
+
+// desiredFlowLog builds the flow log of the NSG from the template.
+func desiredFlowLog(n nsg, template *config.FlowLogsConfig) armnetwork.FlowLog {
+	flowLog := armnetwork.FlowLog{
+		Location: to.Ptr(n.region),
+		Properties: &armnetwork.FlowLogPropertiesFormat{
+			TargetResourceID: to.Ptr(n.id),
+			StorageID:        to.Ptr(template.StorageAccountID),
+			Enabled:          to.Ptr(true),
+			RetentionPolicy: &armnetwork.RetentionPolicyParameters{
+				Days:    to.Ptr(template.RetentionDays),
+				Enabled: to.Ptr(template.RetentionDays > 0),
+			},
+		},
+	}
+
+	if ta := template.TrafficAnalytics; ta != nil {
+		interval := ta.IntervalMinutes
+		if interval == 0 {
+			interval = defaultAnalyticsInterval
+		}
+		flowLog.Properties.FlowAnalyticsConfiguration = &armnetwork.TrafficAnalyticsProperties{
+			NetworkWatcherFlowAnalyticsConfiguration: &armnetwork.TrafficAnalyticsConfigurationProperties{
+				Enabled:                  to.Ptr(true),
+				WorkspaceID:              to.Ptr(ta.WorkspaceID),
+				WorkspaceRegion:          to.Ptr(ta.WorkspaceRegion),
+				WorkspaceResourceID:      to.Ptr(ta.WorkspaceResourceID),
+				TrafficAnalyticsInterval: to.Ptr(interval),
+			},
+		}
+	}
+	return flowLog
+}
+
+// listWatchers returns the Network Watchers of the subscription by region.
+func listWatchers(ctx context.Context, clientFactory *azure.ClientFactory) (map[string]watcher, error) {
+	watchersClient, err := clientFactory.NewWatchersClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	watchers := make(map[string]watcher)
+	pager := watchersClient.NewListAllPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list network watchers: %w", err)
+		}
+		for _, w := range page.Value {
+			if w == nil || w.ID == nil || w.Name == nil || w.Location == nil {
+				continue
+			}
+			watchers[normalizeRegion(*w.Location)] = watcher{
+				subscriptionID: azure.SubscriptionIDOf(*w.ID),
+				resourceGroup:  azure.ExtractResourceIDParts(*w.ID)["resourceGroups"],
+				name:           *w.Name,
+			}
+		}
+	}
+	return watchers, nil
+}
+
+// listNSGs returns the NSGs attached to the subnets of the subscription's
+// VNets, with the region of their VNet.
+func listNSGs(ctx context.Context, runInventory *inventory.RunInventory, subscriptionID string) ([]nsg, error) {
+	vnets, err := runInventory.VNets(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var nsgs []nsg
+	seen := make(map[string]bool)
+	for _, vnet := range vnets {
+		if vnet.Location == nil || vnet.Properties == nil {
+			continue
+		}
+		for _, subnet := range vnet.Properties.Subnets {
+			if subnet == nil || subnet.Properties == nil || subnet.Properties.NetworkSecurityGroup == nil ||
+				subnet.Properties.NetworkSecurityGroup.ID == nil {
+				continue
+			}
+			id := *subnet.Properties.NetworkSecurityGroup.ID
+			if seen[strings.ToLower(id)] {
+				continue
+			}
+			seen[strings.ToLower(id)] = true
+			// an NSG is always in the region of the VNets it's attached to
+			nsgs = append(nsgs, nsg{id: id, region: normalizeRegion(*vnet.Location)})
+		}
+	}
+	return nsgs, nil
+}
+
+// listFlowLogs returns the flow logs of the watcher by lower-case target ID.
+func listFlowLogs(ctx context.Context, flowLogsClient *armnetwork.FlowLogsClient, w watcher) (map[string]*armnetwork.FlowLog, error) {
+	flowLogs := make(map[string]*armnetwork.FlowLog)
+	pager := flowLogsClient.NewListPager(w.resourceGroup, w.name, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list flow logs of network watcher %s: %w", w.name, err)
+		}
+		for _, flowLog := range page.Value {
+			if flowLog == nil || flowLog.Properties == nil || flowLog.Properties.TargetResourceID == nil {
+				continue
+			}
+			flowLogs[strings.ToLower(*flowLog.Properties.TargetResourceID)] = flowLog
+		}
+	}
+	return flowLogs, nil
+}
+
+// normalizeRegion normalizes region names, e.g. "West Europe" to "westeurope".
+func normalizeRegion(region string) string {
+	return strings.ToLower(strings.ReplaceAll(region, " ", ""))
+}
@@ -0,0 +1,182 @@
+package gateways
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/guard"
+	"github.com/akos011221/velora/internal/inventory"
+)
+
+// gatewaySubnetName is the subnet name Azure requires for VNet gateways.
+const gatewaySubnetName = "GatewaySubnet"
+
+// Enforcer flags VNet gateways deployed outside the hub VNets. It is
+// report-only, deleting gateways is out of scope.
+type Enforcer struct {
+	clientFactory *azure.ClientFactory
+	config        *config.Config
+	inventory     *inventory.RunInventory
+	guard         *guard.Guard
+	findings      []findings.Finding
+	compliance    *findings.ComplianceLog
+}
+
+// NewEnforcer creates a new gateway policy enforcer instance. VNets are read
+// from the run inventory, the guard decides which subscriptions are skipped.
+func NewEnforcer(clientFactory *azure.ClientFactory, config *config.Config, runInventory *inventory.RunInventory, guard *guard.Guard) *Enforcer {
+	return &Enforcer{
+		clientFactory: clientFactory,
+		config:        config,
+		inventory:     runInventory,
+		compliance:    findings.NewComplianceLog(config),
+		guard:         guard,
+	}
+}
+
+// Findings returns the findings recorded during enforcement.
+func (e *Enforcer) Findings() []findings.Finding {
+	return e.findings
+}
+
+// Compliance returns the compliant resources recorded during enforcement.
+func (e *Enforcer) Compliance() *findings.ComplianceLog {
+	return e.compliance
+}
+
+// EnforceAll scans all subscriptions for gateways outside the hub VNets.
+func (e *Enforcer) EnforceAll(ctx context.Context) error {
+	if !e.config.Features.GatewayPolicy {
+		return nil
+	}
+
+	for subID := range e.config.Subscriptions {
+		if reason, err := e.guard.SkipReason(subID); err != nil {
+			return err
+		} else if reason != "" {
+			fmt.Printf("skipped subscription %s: %s\n", subID, reason)
+			continue
+		}
+
+		if err := e.guard.Contain("gateways", subID, func() error {
+			return e.scanSubscription(ctx, subID)
+		}); err != nil {
+			if guard.IsPanic(err) || e.guard.SkipInactive(subID, err) {
+				continue
+			}
+			return fmt.Errorf("failed to scan gateways in subscription %s: %w", subID, err)
+		}
+	}
+	return nil
+}
+
+// scanSubscription finds the gateways attached to the GatewaySubnet of every
+// non-hub VNet in the subscription.
+func (e *Enforcer) scanSubscription(ctx context.Context, subscriptionID string) error {
+	gatewaysClient, err := e.clientFactory.ForSubscription(subscriptionID).NewVirtualNetworkGatewaysClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	vnets, err := e.inventory.VNets(ctx, subscriptionID)
+	if err != nil {
+		return err
+	}
+	for _, vnet := range vnets {
+		if vnet.ID == nil || vnet.Properties == nil || e.config.IsHubVNet(*vnet.ID) {
+			continue
+		}
+
+		ids := gatewayIDs(vnet)
+		if len(ids) == 0 {
+			e.compliance.Record(findings.RuleSpokeGateway, subscriptionID, *vnet.ID)
+		}
+		for _, gatewayID := range ids {
+			if err := e.reportGateway(ctx, gatewaysClient, subscriptionID, *vnet.ID, gatewayID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// reportGateway records a critical finding for a gateway in a spoke VNet,
+// with its SKU and connection count to help triage.
+func (e *Enforcer) reportGateway(ctx context.Context, gatewaysClient *armnetwork.VirtualNetworkGatewaysClient,
+	subscriptionID, vnetID, gatewayID string) error {
+	parts := azure.ExtractResourceIDParts(gatewayID)
+	resourceGroup := parts["resourceGroups"]
+	gatewayName := parts["virtualNetworkGateways"]
+
+	sku := "unknown"
+	gateway, err := gatewaysClient.Get(ctx, resourceGroup, gatewayName, nil)
+	if err != nil {
+		// the gateway was deleted since its VNet was listed
+		if e.guard.SkipDisappeared(gatewayID, err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get gateway %s: %w", gatewayID, err)
+	}
+	if gateway.Properties != nil && gateway.Properties.SKU != nil && gateway.Properties.SKU.Name != nil {
+		sku = string(*gateway.Properties.SKU.Name)
+	}
+
+	connections := 0
+	pager := gatewaysClient.NewListConnectionsPager(resourceGroup, gatewayName, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			if e.guard.SkipDisappeared(gatewayID, err) {
+				return nil
+			}
+			return fmt.Errorf("failed to list connections of gateway %s: %w", gatewayID, err)
+		}
+		connections += len(page.Value)
+	}
+
+	e.findings = append(e.findings, findings.New(findings.RuleSpokeGateway, e.config.Rules,
+		subscriptionID, gatewayID, fmt.Sprintf("gateway %s (SKU %s, %d connections) is deployed in spoke VNet %s, bypassing the NVA",
+			gatewayName, sku, connections, vnetID),
+		map[string]string{
+			"gateway":     gatewayName,
+			"sku":         sku,
+			"connections": fmt.Sprint(connections),
+		}))
+	return nil
+}
+
+// gatewayIDs returns the IDs of the gateways attached to the GatewaySubnet of the VNet.
+func gatewayIDs(vnet *armnetwork.VirtualNetwork) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, subnet := range vnet.Properties.Subnets {
+		if subnet == nil || subnet.Name == nil || *subnet.Name != gatewaySubnetName || subnet.Properties == nil {
+			continue
+		}
+
+		for _, ipConfig := range subnet.Properties.IPConfigurations {
+			if ipConfig == nil || ipConfig.ID == nil {
+				continue
+			}
+
+			// gateway IP configuration IDs look like
+			// .../virtualNetworkGateways/<name>/ipConfigurations/<config>
+			idx := strings.Index(strings.ToLower(*ipConfig.ID), "/ipconfigurations/")
+			if idx < 0 || !strings.Contains(strings.ToLower(*ipConfig.ID), "/virtualnetworkgateways/") {
+				continue
+			}
+			gatewayID := (*ipConfig.ID)[:idx]
+			if !seen[strings.ToLower(gatewayID)] {
+				seen[strings.ToLower(gatewayID)] = true
+				ids = append(ids, gatewayID)
+			}
+		}
+	}
+	return ids
+}
@@ -0,0 +1,425 @@
+package nsg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/plan"
+)
+
+// Subnet isolation rules live in the NSGs customers manage, next to their own
+// rules and often under their IaC. To coexist, velora owns a priority band of
+// every NSG and nothing else:
+//   - it only creates, updates and deletes rules inside the band, named with
+//     isolationRulePrefix;
+//   - any other rule inside the band is reported and its priority skipped,
+//     it is never modified;
+//   - rules outside the band are only read to report those allowing what an
+//     isolation rule denies, as they take precedence.
+//
+// Drift inside the band is corrected on every run.
+
+// isolationRulePrefix names the subnet isolation rules velora owns.
+const isolationRulePrefix = "velora-isolation-"
+
+// isolationDescription prefixes the description of the isolation rules,
+// followed by the ID of the subnet the rule isolates. A shared NSG holds the
+// rules of several subscriptions, each run only prunes its own.
+const isolationDescription = "velora subnet isolation of "
+
+// maxRuleNameLength is the longest NSG rule name Azure accepts.
+const maxRuleNameLength = 80
+
+// isolationRule is the rule denying the traffic from the other subnets of
+// its VNet to a subnet.
+type isolationRule struct {
+	name         string
+	subnetID     string
+	subnetName   string
+	vnetName     string
+	sources      []string
+	destinations []string
+}
+
+// enforceIsolation maintains the isolation rules of the spoke subnets of the
+// subscription in their NSGs. Subnets without an NSG can't be isolated this
+// way, the NSG association baseline reports them.
+func (e *Enforcer) enforceIsolation(ctx context.Context, subscriptionID string, band *config.PriorityBandConfig) error {
+	vnets, err := e.inventory.VNets(ctx, subscriptionID)
+	if err != nil {
+		return err
+	}
+
+	var nsgIDs []string
+	byNSG := make(map[string][]isolationRule)
+	for _, vnet := range vnets {
+		if vnet.ID == nil || vnet.Name == nil || vnet.Properties == nil || e.config.IsHubVNet(*vnet.ID) {
+			continue
+		}
+		for _, subnet := range vnet.Properties.Subnets {
+			if subnet == nil || subnet.ID == nil || subnet.Name == nil || subnet.Properties == nil {
+				continue
+			}
+			if unsupportedReason(subnet) != "" || len(subnetPrefixes(subnet)) == 0 {
+				continue
+			}
+			if subnet.Properties.NetworkSecurityGroup == nil || subnet.Properties.NetworkSecurityGroup.ID == nil {
+				fmt.Printf("skipped subnet %s: no NSG to add its isolation rule to\n", *subnet.ID)
+				continue
+			}
+			// the NSG is reconciled even if no subnet of it needs a rule
+			// anymore, its stale rules are pruned
+			nsgID := *subnet.Properties.NetworkSecurityGroup.ID
+			key := strings.ToLower(nsgID)
+			if _, ok := byNSG[key]; !ok {
+				nsgIDs = append(nsgIDs, nsgID)
+				byNSG[key] = nil
+			}
+			sources := isolationSources(vnet, *subnet.ID)
+			if len(sources) == 0 {
+				continue
+			}
+			byNSG[key] = append(byNSG[key], isolationRule{
+				name:         isolationRuleName(*vnet.Name, *subnet.Name, *subnet.ID),
+				subnetID:     *subnet.ID,
+				subnetName:   *subnet.Name,
+				vnetName:     *vnet.Name,
+				sources:      sources,
+				destinations: subnetPrefixes(subnet),
+			})
+		}
+	}
+
+	for _, nsgID := range nsgIDs {
+		if err := e.enforceIsolationRules(ctx, subscriptionID, nsgID, byNSG[strings.ToLower(nsgID)], band); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enforceIsolationRules reconciles the band of one NSG with the isolation
+// rules of its subnets in the subscription.
+func (e *Enforcer) enforceIsolationRules(ctx context.Context, subscriptionID, nsgID string, expected []isolationRule, band *config.PriorityBandConfig) error {
+	nsg, err := e.securityGroup(ctx, nsgID)
+	if err != nil {
+		// the NSG was deleted since its subnets were listed
+		if e.guard.SkipDisappeared(nsgID, err) {
+			return nil
+		}
+		return err
+	}
+	nsgName := azure.ExtractResourceIDParts(nsgID)["networkSecurityGroups"]
+	first, last := band.Bounds()
+	bandName := fmt.Sprintf("%d-%d", first, last)
+
+	// the band's rules are velora's of this subscription, kept or pruned,
+	// or occupy their priority: another subscription's or foreign
+	owned := make(map[string]*armnetwork.SecurityRule)
+	occupied := make(map[int]bool)
+	var stale, outside []*armnetwork.SecurityRule
+	var customer []*armnetwork.SecurityRule
+	wanted := make(map[string]bool, len(expected))
+	for _, rule := range expected {
+		wanted[strings.ToLower(rule.name)] = true
+	}
+	if nsg.Properties != nil {
+		for _, rule := range nsg.Properties.SecurityRules {
+			if rule == nil || rule.Name == nil || rule.Properties == nil || rule.Properties.Priority == nil {
+				continue
+			}
+			priority := int(*rule.Properties.Priority)
+			ours := strings.HasPrefix(strings.ToLower(*rule.Name), isolationRulePrefix)
+			switch {
+			case !band.Contains(priority):
+				if ours {
+					outside = append(outside, rule)
+				} else {
+					customer = append(customer, rule)
+				}
+			case !ours:
+				occupied[priority] = true
+				e.findings = append(e.findings, findings.New(findings.RuleNSGIsolationBand, e.config.Rules,
+					subscriptionID, nsgID, fmt.Sprintf("rule %s of NSG %s has priority %d, inside the subnet isolation band %s velora owns", *rule.Name, nsgName, priority, bandName),
+					map[string]string{
+						"rule":     *rule.Name,
+						"priority": strconv.Itoa(priority),
+						"nsg":      nsgName,
+						"band":     bandName,
+					}))
+			case !isolatesSubscription(rule, subscriptionID):
+				occupied[priority] = true
+			case wanted[strings.ToLower(*rule.Name)]:
+				owned[strings.ToLower(*rule.Name)] = rule
+			default:
+				stale = append(stale, rule)
+			}
+		}
+	}
+
+	writable := e.config.Features.AutoRemediation && e.guard.WritesAllowed(subscriptionID) && !e.guard.InGracePeriod(ctx, subscriptionID, nsgID)
+	// stale rules go first, their priorities may be reassigned
+	for _, rule := range stale {
+		if !writable {
+			occupied[int(*rule.Properties.Priority)] = true
+			continue
+		}
+		if err := e.deleteIsolationRule(ctx, subscriptionID, nsgID, rule); err != nil {
+			return err
+		}
+	}
+
+	// kept rules keep their priority, the others get the first free ones
+	sort.Slice(expected, func(i, j int) bool { return expected[i].name < expected[j].name })
+	priorities := make(map[string]int, len(expected))
+	for _, rule := range expected {
+		if existing, ok := owned[strings.ToLower(rule.name)]; ok {
+			priority := int(*existing.Properties.Priority)
+			if !occupied[priority] {
+				priorities[rule.name] = priority
+				occupied[priority] = true
+			}
+		}
+	}
+	next := first
+	for _, rule := range expected {
+		if _, ok := priorities[rule.name]; ok {
+			continue
+		}
+		for next <= last && occupied[next] {
+			next++
+		}
+		if next > last {
+			fmt.Printf("WARNING: skipped isolation rule %s of NSG %s: no free priority left in band %s\n", rule.name, nsgName, bandName)
+			continue
+		}
+		priorities[rule.name] = next
+		occupied[next] = true
+	}
+
+	for _, rule := range expected {
+		priority, ok := priorities[rule.name]
+		if !ok {
+			continue
+		}
+		if conflict := findRule(outside, rule.name); conflict != nil {
+			fmt.Printf("skipped isolation rule %s of NSG %s: a rule of that name has priority %d, outside the band\n",
+				rule.name, nsgName, *conflict.Properties.Priority)
+			continue
+		}
+		desired := rule.properties(priority)
+		e.reportShadowing(subscriptionID, nsgID, nsgName, rule, desired, customer)
+
+		existing := owned[strings.ToLower(rule.name)]
+		if existing != nil && sameIsolationRule(existing.Properties, desired) {
+			e.compliance.Record(findings.RuleNSGSubnetIsolation, subscriptionID, rule.subnetID)
+			continue
+		}
+		state := "missing"
+		if existing != nil {
+			state = "drifted"
+		}
+		e.findings = append(e.findings, findings.New(findings.RuleNSGSubnetIsolation, e.config.Rules,
+			subscriptionID, rule.subnetID, fmt.Sprintf("isolation rule %s of subnet %s in NSG %s is %s", rule.name, rule.subnetName, nsgName, state),
+			map[string]string{
+				"rule":     rule.name,
+				"priority": strconv.Itoa(priority),
+				"nsg":      nsgName,
+				"vnet":     rule.vnetName,
+				"subnet":   rule.subnetName,
+			}))
+		if writable {
+			if err := e.putIsolationRule(ctx, subscriptionID, nsgID, rule, desired, existing); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reportShadowing reports the customer rules allowing traffic the isolation
+// rule denies with a higher precedence.
+func (e *Enforcer) reportShadowing(subscriptionID, nsgID, nsgName string, rule isolationRule, desired *armnetwork.SecurityRulePropertiesFormat,
+	customer []*armnetwork.SecurityRule) {
+	isolation := nsgRule(desired)
+	for _, other := range customer {
+		normalized := nsgRule(other.Properties)
+		if normalized.direction != isolation.direction || normalized.access != strings.ToLower(string(armnetwork.SecurityRuleAccessAllow)) ||
+			normalized.priority >= isolation.priority {
+			continue
+		}
+		if !normalized.sources.overlaps(isolation.sources) || !normalized.destinations.overlaps(isolation.destinations) {
+			continue
+		}
+		e.findings = append(e.findings, findings.New(findings.RuleNSGIsolationShadowed, e.config.Rules,
+			subscriptionID, nsgID, fmt.Sprintf("rule %s of NSG %s allows traffic isolation rule %s denies, with a higher precedence", *other.Name, nsgName, rule.name),
+			map[string]string{
+				"rule":              rule.name,
+				"nsg":               nsgName,
+				"shadowing":         *other.Name,
+				"shadowingPriority": strconv.Itoa(normalized.priority),
+			}))
+	}
+}
+
+// putIsolationRule creates or updates the isolation rule, if it is unchanged
+// since the NSG was read.
+func (e *Enforcer) putIsolationRule(ctx context.Context, subscriptionID, nsgID string, rule isolationRule,
+	desired *armnetwork.SecurityRulePropertiesFormat, existing *armnetwork.SecurityRule) error {
+	ruleID := nsgID + "/securityRules/" + rule.name
+	body, err := json.Marshal(armnetwork.SecurityRule{Properties: desired})
+	if err != nil {
+		return fmt.Errorf("failed to encode isolation rule %s: %w", rule.name, err)
+	}
+	var before json.RawMessage
+	etag := ""
+	if existing != nil {
+		if before, err = json.Marshal(armnetwork.SecurityRule{Properties: existing.Properties}); err != nil {
+			return fmt.Errorf("failed to encode isolation rule %s: %w", rule.name, err)
+		}
+		etag = stringValue(existing.Etag)
+	}
+	apiVersion := e.clientFactory.APIVersion(azure.ResourceTypeSecurityRules)
+	if e.guard.Planned(plan.Change{
+		SubscriptionID: subscriptionID,
+		ResourceID:     ruleID,
+		APIVersion:     apiVersion,
+		Etag:           etag,
+		Body:           body,
+		Description:    fmt.Sprintf("isolate subnet %s with rule %s at priority %d", rule.subnetID, rule.name, *desired.Priority),
+		Before:         before,
+	}) {
+		return nil
+	}
+
+	err = e.clientFactory.ForSubscription(azure.SubscriptionIDOf(nsgID)).PutResource(ctx, ruleID, apiVersion, "", body, etag)
+	if err != nil {
+		if e.guard.SkipDisappeared(nsgID, err) || e.guard.SkipPolicyDenied(subscriptionID, ruleID, err) {
+			return nil
+		}
+		return fmt.Errorf("failed to write isolation rule %s: %w", ruleID, err)
+	}
+	return nil
+}
+
+// deleteIsolationRule deletes a stale isolation rule, if it is unchanged
+// since the NSG was read.
+func (e *Enforcer) deleteIsolationRule(ctx context.Context, subscriptionID, nsgID string, rule *armnetwork.SecurityRule) error {
+	ruleID := nsgID + "/securityRules/" + *rule.Name
+	before, err := json.Marshal(armnetwork.SecurityRule{Properties: rule.Properties})
+	if err != nil {
+		return fmt.Errorf("failed to encode isolation rule %s: %w", *rule.Name, err)
+	}
+	apiVersion := e.clientFactory.APIVersion(azure.ResourceTypeSecurityRules)
+	if e.guard.Planned(plan.Change{
+		SubscriptionID: subscriptionID,
+		ResourceID:     ruleID,
+		APIVersion:     apiVersion,
+		Etag:           stringValue(rule.Etag),
+		Description:    fmt.Sprintf("delete isolation rule %s of NSG %s, its subnet isn't isolated anymore", *rule.Name, nsgID),
+		Delete:         true,
+		Before:         before,
+	}) {
+		return nil
+	}
+
+	err = e.clientFactory.ForSubscription(azure.SubscriptionIDOf(nsgID)).DeleteResource(ctx, ruleID, apiVersion, stringValue(rule.Etag))
+	if err != nil {
+		if e.guard.SkipDisappeared(ruleID, err) || e.guard.SkipPolicyDenied(subscriptionID, ruleID, err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete isolation rule %s: %w", ruleID, err)
+	}
+	return nil
+}
+
+// properties returns the properties of the rule at the priority: inbound
+// traffic from the other subnets is denied, whatever the protocol and ports.
+func (r isolationRule) properties(priority int) *armnetwork.SecurityRulePropertiesFormat {
+	return &armnetwork.SecurityRulePropertiesFormat{
+		Description:                to.Ptr(isolationDescription + r.subnetID),
+		Direction:                  to.Ptr(armnetwork.SecurityRuleDirectionInbound),
+		Access:                     to.Ptr(armnetwork.SecurityRuleAccessDeny),
+		Protocol:                   to.Ptr(armnetwork.SecurityRuleProtocolAsterisk),
+		Priority:                   to.Ptr(int32(priority)),
+		SourceAddressPrefixes:      to.SliceOfPtrs(r.sources...),
+		SourcePortRange:            to.Ptr(config.NSGRuleAny),
+		DestinationAddressPrefixes: to.SliceOfPtrs(r.destinations...),
+		DestinationPortRange:       to.Ptr(config.NSGRuleAny),
+	}
+}
+
+// sameIsolationRule reports whether the rule of the NSG is the desired
+// isolation rule.
+func sameIsolationRule(current, desired *armnetwork.SecurityRulePropertiesFormat) bool {
+	a, b := nsgRule(current), nsgRule(desired)
+	return a.priority == b.priority && a.direction == b.direction && a.access == b.access && a.protocol == b.protocol &&
+		a.sources.equal(b.sources) && a.destinations.equal(b.destinations) &&
+		a.sourcePorts.equal(b.sourcePorts) && a.destinationPorts.equal(b.destinationPorts) &&
+		stringValue(current.Description) == stringValue(desired.Description)
+}
+
+// isolatesSubscription reports whether the isolation rule is for a subnet
+// of the subscription, by its description.
+func isolatesSubscription(rule *armnetwork.SecurityRule, subscriptionID string) bool {
+	subnetID, ok := strings.CutPrefix(stringValue(rule.Properties.Description), isolationDescription)
+	// a rule whose description was edited is taken as this subscription's
+	return !ok || strings.EqualFold(azure.SubscriptionIDOf(subnetID), subscriptionID)
+}
+
+// isolationSources returns the prefixes of the other subnets of the VNet,
+// except those of the subnets Azure reserves for gateways and firewalls,
+// whose traffic must keep reaching the subnets.
+func isolationSources(vnet *armnetwork.VirtualNetwork, subnetID string) []string {
+	var sources []string
+	for _, other := range vnet.Properties.Subnets {
+		if other == nil || other.ID == nil || other.Name == nil || other.Properties == nil {
+			continue
+		}
+		if strings.EqualFold(*other.ID, subnetID) || reservedSubnets[strings.ToLower(*other.Name)] {
+			continue
+		}
+		sources = append(sources, subnetPrefixes(other)...)
+	}
+	return sources
+}
+
+// isolationRuleName returns the name of the isolation rule of the subnet,
+// shortened with a hash of the subnet ID if too long.
+func isolationRuleName(vnetName, subnetName, subnetID string) string {
+	name := isolationRulePrefix + vnetName + "-" + subnetName
+	if len(name) <= maxRuleNameLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(strings.ToLower(subnetID)))
+	suffix := "-" + hex.EncodeToString(sum[:4])
+	return name[:maxRuleNameLength-len(suffix)] + suffix
+}
+
+// subnetPrefixes returns the address prefixes of the subnet.
+func subnetPrefixes(subnet *armnetwork.Subnet) []string {
+	prefixes := values(subnet.Properties.AddressPrefix, subnet.Properties.AddressPrefixes)
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// findRule returns the rule of the name, nil if there is none.
+func findRule(rules []*armnetwork.SecurityRule, name string) *armnetwork.SecurityRule {
+	for _, rule := range rules {
+		if strings.EqualFold(*rule.Name, name) {
+			return rule
+		}
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package nsg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// Isolation rules of the spoke subnets and the rule IDs of the NSG.
+const (
+	appIsolation = isolationRulePrefix + "spoke-app"
+	dbIsolation  = isolationRulePrefix + "spoke-db"
+	rulesPath    = spokeNSGID + "/securityRules/"
+)
+
+// otherSubscriptionSubnet is a subnet of another subscription sharing the
+// spoke NSG.
+const otherSubscriptionSubnet = "/subscriptions/00000000-0000-0000-0000-000000000009/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/other/subnets/app"
+
+// withIsolatedSpoke puts the spoke VNet into the fake ARM with its subnets
+// sharing the spoke NSG, and the gateway subnet.
+func withIsolatedSpoke(arm *azuretest.Server, subnets ...string) {
+	prefixes := map[string]string{"app": "10.1.0.0/24", "db": "10.1.1.0/24"}
+	all := []*armnetwork.Subnet{azuretest.Subnet("GatewaySubnet", "10.1.255.0/27", "")}
+	for _, name := range subnets {
+		subnet := azuretest.Subnet(name, prefixes[name], "")
+		subnet.Properties.NetworkSecurityGroup = &armnetwork.SecurityGroup{ID: to.Ptr(spokeNSGID)}
+		all = append(all, subnet)
+	}
+	arm.Put(spokeVNetID, azuretest.VNet(spokeVNetID, []string{"10.1.0.0/16"}, all...))
+}
+
+// customerRule returns an inbound rule of the customer at the priority.
+func customerRule(name string, priority int32, access armnetwork.SecurityRuleAccess, source string) *armnetwork.SecurityRule {
+	return &armnetwork.SecurityRule{
+		Name: to.Ptr(name),
+		Properties: &armnetwork.SecurityRulePropertiesFormat{
+			Direction:                to.Ptr(armnetwork.SecurityRuleDirectionInbound),
+			Access:                   to.Ptr(access),
+			Protocol:                 to.Ptr(armnetwork.SecurityRuleProtocolTCP),
+			Priority:                 to.Ptr(priority),
+			SourceAddressPrefix:      to.Ptr(source),
+			SourcePortRange:          to.Ptr("*"),
+			DestinationAddressPrefix: to.Ptr("VirtualNetwork"),
+			DestinationPortRange:     to.Ptr("1433"),
+		},
+	}
+}
+
+// customerRules are the rules of the spoke NSG around and inside the band.
+func customerRules() []*armnetwork.SecurityRule {
+	return []*armnetwork.SecurityRule{
+		// allows traffic from db to app before the isolation rules
+		customerRule("allow-db-to-app", 3999, armnetwork.SecurityRuleAccessAllow, "10.1.1.0/24"),
+		customerRule("deny-legacy", 4000, armnetwork.SecurityRuleAccessDeny, "192.168.0.0/16"),
+		customerRule("allow-after-band", 4096, armnetwork.SecurityRuleAccessAllow, "10.1.1.0/24"),
+		{
+			Name: to.Ptr(isolationRulePrefix + "other-app"),
+			Properties: &armnetwork.SecurityRulePropertiesFormat{
+				Description:              to.Ptr(isolationDescription + otherSubscriptionSubnet),
+				Direction:                to.Ptr(armnetwork.SecurityRuleDirectionInbound),
+				Access:                   to.Ptr(armnetwork.SecurityRuleAccessDeny),
+				Protocol:                 to.Ptr(armnetwork.SecurityRuleProtocolAsterisk),
+				Priority:                 to.Ptr[int32](4001),
+				SourceAddressPrefix:      to.Ptr("10.9.1.0/24"),
+				SourcePortRange:          to.Ptr("*"),
+				DestinationAddressPrefix: to.Ptr("10.9.0.0/24"),
+				DestinationPortRange:     to.Ptr("*"),
+			},
+		},
+	}
+}
+
+// withIsolationBand enables the subnet isolation band of the subscription.
+func withIsolationBand(cfg *config.Config) {
+	cfg.Features.NSGAssociation = true
+	sub := cfg.Subscriptions[configtest.SubscriptionID]
+	sub.SubnetToSubnetDeny = true
+	sub.SubnetIsolationBand = &config.PriorityBandConfig{}
+	cfg.Subscriptions[configtest.SubscriptionID] = sub
+}
+
+// isolationOutcome returns the writes reaching ARM, with the priority of the
+// rules written, and the findings, sorted.
+func isolationOutcome(t *testing.T, arm *azuretest.Server, all []findings.Finding) []string {
+	t.Helper()
+	var result []string
+	for _, req := range arm.Writes() {
+		write := req.String()
+		var rule armnetwork.SecurityRule
+		if len(req.Body) == 0 {
+			result = append(result, write)
+			continue
+		}
+		if err := json.Unmarshal(req.Body, &rule); err != nil {
+			t.Fatalf("%s: %v", req, err)
+		}
+		if rule.Properties != nil && rule.Properties.Priority != nil {
+			write += fmt.Sprintf(" %d", *rule.Properties.Priority)
+		}
+		result = append(result, write)
+	}
+	for _, f := range all {
+		result = append(result, f.RuleID+" "+f.ResourceID)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// TestEnforceAllIsolationBand seeds the spoke NSG with customer rules next
+// to and inside the band, and another subscription's isolation rule. Velora
+// writes its rules at the free priorities of the band, heals their drift and
+// prunes them, and the other rules survive untouched.
+func TestEnforceAllIsolationBand(t *testing.T) {
+	subnet := func(name string) string { return spokeVNetID + "/subnets/" + name }
+	foreignInBand := findings.RuleNSGIsolationBand.ID + " " + spokeNSGID
+	shadowed := findings.RuleNSGIsolationShadowed.ID + " " + spokeNSGID
+	tests := []struct {
+		name    string
+		subnets []string
+		// modify changes the NSG after the first run
+		modify func(arm *azuretest.Server)
+		want   []string
+	}{
+		{
+			name:    "in sync",
+			subnets: []string{"app", "db"},
+			want:    []string{foreignInBand, shadowed},
+		},
+		{
+			name:    "rule drifted",
+			subnets: []string{"app", "db"},
+			modify: func(arm *azuretest.Server) {
+				var rule armnetwork.SecurityRule
+				arm.Get(rulesPath+dbIsolation, &rule)
+				rule.Properties.SourceAddressPrefixes = to.SliceOfPtrs("10.1.0.0/25")
+				arm.Put(rulesPath+dbIsolation, &rule)
+			},
+			want: []string{"PUT " + rulesPath + dbIsolation + " 4003", foreignInBand, shadowed, findings.RuleNSGSubnetIsolation.ID + " " + subnet("db")},
+		},
+		{
+			name:    "rule deleted",
+			subnets: []string{"app", "db"},
+			modify:  func(arm *azuretest.Server) { arm.Delete(rulesPath + appIsolation) },
+			want:    []string{"PUT " + rulesPath + appIsolation + " 4002", foreignInBand, shadowed, findings.RuleNSGSubnetIsolation.ID + " " + subnet("app")},
+		},
+		{
+			// app isn't isolated from anything anymore
+			name:    "subnet removed",
+			subnets: []string{"app"},
+			want:    []string{"DELETE " + rulesPath + appIsolation, "DELETE " + rulesPath + dbIsolation, foreignInBand},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arm := newTestARM()
+			withIsolatedSpoke(arm, "app", "db")
+			arm.Put(spokeNSGID, &armnetwork.SecurityGroup{Properties: &armnetwork.SecurityGroupPropertiesFormat{SecurityRules: customerRules()}})
+			cfg := configtest.New(t, withIsolationBand)
+
+			enforcer := newTestEnforcer(t, cfg, arm)
+			if err := enforcer.EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error = %v", err)
+			}
+			// the free priorities of the band are 4002 and 4003
+			want := []string{
+				"PUT " + rulesPath + appIsolation + " 4002",
+				"PUT " + rulesPath + dbIsolation + " 4003",
+				foreignInBand,
+				shadowed,
+				findings.RuleNSGSubnetIsolation.ID + " " + subnet("app"),
+				findings.RuleNSGSubnetIsolation.ID + " " + subnet("db"),
+			}
+			if got := isolationOutcome(t, arm, enforcer.Findings()); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("first run =\n%v\nwant:\n%v", got, want)
+			}
+
+			if tt.modify != nil {
+				tt.modify(arm)
+			}
+			withIsolatedSpoke(arm, tt.subnets...)
+			arm.Reset()
+			enforcer = newTestEnforcer(t, cfg, arm)
+			if err := enforcer.EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error = %v", err)
+			}
+			if got := isolationOutcome(t, arm, enforcer.Findings()); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("second run =\n%v\nwant:\n%v", got, tt.want)
+			}
+
+			for _, want := range customerRules() {
+				var got armnetwork.SecurityRule
+				if !arm.Get(rulesPath+*want.Name, &got) {
+					t.Errorf("rule %s deleted", *want.Name)
+					continue
+				}
+				if !sameIsolationRule(got.Properties, want.Properties) {
+					t.Errorf("rule %s modified", *want.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateIsolationBand(t *testing.T) {
+	tests := []struct {
+		name string
+		// first and last bound the band, the default one if unset
+		first, last int
+		mutate      func(sub *config.SubscriptionConfig)
+		wantErr     bool
+	}{
+		{name: "default band"},
+		{name: "custom band", first: 3000, last: 3099},
+		{name: "band past the custom priorities", last: 4097, wantErr: true},
+		{name: "empty band", first: 3099, last: 3000, wantErr: true},
+		{name: "without subnetToSubnetDeny", mutate: func(sub *config.SubscriptionConfig) { sub.SubnetToSubnetDeny = false }, wantErr: true},
+		{
+			name: "baseline rule next to the band",
+			mutate: func(sub *config.SubscriptionConfig) {
+				rule := httpsToMonitor()
+				rule.Priority = 3999
+				sub.NSGAssociation = &config.NSGAssociationConfig{Mode: config.NSGAssociationAny, BaselineRules: []config.NSGRuleConfig{rule}}
+			},
+		},
+		{
+			name: "baseline rule inside the band",
+			mutate: func(sub *config.SubscriptionConfig) {
+				rule := httpsToMonitor()
+				rule.Priority = 4000
+				sub.NSGAssociation = &config.NSGAssociationConfig{Mode: config.NSGAssociationAny, BaselineRules: []config.NSGRuleConfig{rule}}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t, withIsolationBand)
+			sub := cfg.Subscriptions[configtest.SubscriptionID]
+			sub.SubnetIsolationBand = &config.PriorityBandConfig{First: tt.first, Last: tt.last}
+			if tt.mutate != nil {
+				tt.mutate(&sub)
+			}
+			cfg.Subscriptions[configtest.SubscriptionID] = sub
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, want an error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
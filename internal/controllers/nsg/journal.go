@@ -0,0 +1,129 @@
+package nsg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// stateKey is the state store key holding the NSG associations velora made.
+const stateKey = "nsg-associations"
+
+// Association is an NSG velora associated with a subnet.
+type Association struct {
+	SubnetID       string `json:"subnetId"`
+	SubscriptionID string `json:"subscriptionId"`
+	NSGID          string `json:"nsgId"`
+	// PreviousNSGID is the NSG of the subnet before velora first changed it,
+	// empty if it had none. Rollback restores it.
+	PreviousNSGID string    `json:"previousNsgId,omitempty"`
+	AssociatedAt  time.Time `json:"associatedAt"`
+}
+
+// Journal records the NSG associations velora made, so they can be audited
+// and rolled back.
+type Journal struct {
+	store state.Store
+	mu    sync.Mutex
+}
+
+// NewJournal creates a new journal persisting to the state store.
+func NewJournal(store state.Store) *Journal {
+	return &Journal{store: store}
+}
+
+// Record persists an association. A subnet associated again keeps the NSG
+// it had before velora first changed it.
+func (j *Journal) Record(a Association) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	associations, err := j.load()
+	if err != nil {
+		return err
+	}
+	key := strings.ToLower(a.SubnetID)
+	if previous, ok := associations[key]; ok {
+		a.PreviousNSGID = previous.PreviousNSGID
+	}
+	associations[key] = &a
+
+	return j.store.Put(stateKey, associations)
+}
+
+// List returns the recorded associations, oldest first.
+func (j *Journal) List() ([]Association, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	associations, err := j.load()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Association, 0, len(associations))
+	for _, a := range associations {
+		result = append(result, *a)
+	}
+	sort.Slice(result, func(i, k int) bool {
+		return result[i].AssociatedAt.Before(result[k].AssociatedAt)
+	})
+	return result, nil
+}
+
+// Rollback restores the NSG the subnet had before velora associated the
+// baseline NSG. Subnets whose NSG was changed since are left alone.
+func (j *Journal) Rollback(ctx context.Context, clientFactory *azure.ClientFactory, subnetID string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	associations, err := j.load()
+	if err != nil {
+		return err
+	}
+	key := strings.ToLower(subnetID)
+	a, ok := associations[key]
+	if !ok {
+		return fmt.Errorf("no NSG association recorded for subnet %s", subnetID)
+	}
+
+	err = clientFactory.UpdateSubnet(ctx, a.SubnetID, func(subnet *armnetwork.Subnet) (bool, error) {
+		current := ""
+		if subnet.Properties.NetworkSecurityGroup != nil {
+			current = stringValue(subnet.Properties.NetworkSecurityGroup.ID)
+		}
+		if !strings.EqualFold(current, a.NSGID) {
+			return false, fmt.Errorf("subnet %s has NSG %q since, not rolling back", a.SubnetID, current)
+		}
+
+		subnet.Properties.NetworkSecurityGroup = nil
+		if a.PreviousNSGID != "" {
+			subnet.Properties.NetworkSecurityGroup = &armnetwork.SecurityGroup{ID: to.Ptr(a.PreviousNSGID)}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to roll back NSG association of subnet %s: %w", a.SubnetID, err)
+	}
+
+	delete(associations, key)
+	return j.store.Put(stateKey, associations)
+}
+
+// load reads the associations from the state store, by lower-case subnet ID.
+func (j *Journal) load() (map[string]*Association, error) {
+	associations := make(map[string]*Association)
+	if err := j.store.Get(stateKey, &associations); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load NSG associations: %w", err)
+	}
+	return associations, nil
+}
@@ -0,0 +1,335 @@
+package nsg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/guard"
+	"github.com/akos011221/velora/internal/inventory"
+	"github.com/akos011221/velora/internal/plan"
+)
+
+// reservedSubnets are the subnets Azure doesn't allow NSGs on.
+var reservedSubnets = map[string]bool{
+	"gatewaysubnet":                 true,
+	"azurefirewallsubnet":           true,
+	"azurefirewallmanagementsubnet": true,
+	"routeserversubnet":             true,
+}
+
+// forbiddingDelegations are the subnet delegations that don't allow NSGs, by
+// lower-case service name.
+var forbiddingDelegations = map[string]bool{
+	"microsoft.netapp/volumes": true,
+}
+
+// Enforcer checks that every spoke subnet has an NSG and, in specific mode,
+// associates the subscription's baseline NSG. With a subnet isolation band
+// it also maintains the rules isolating the subnets in their NSGs.
+type Enforcer struct {
+	clientFactory *azure.ClientFactory
+	config        *config.Config
+	inventory     *inventory.RunInventory
+	guard         *guard.Guard
+	journal       *Journal
+	findings      []findings.Finding
+	compliance    *findings.ComplianceLog
+	// ipGroups are the addresses of the IP Groups read, nil if they don't
+	// exist, by lower-case ID.
+	ipGroups map[string][]string
+}
+
+// NewEnforcer creates a new NSG association enforcer instance. Subnets are
+// read from the run inventory, the associations made are recorded in the
+// journal.
+func NewEnforcer(clientFactory *azure.ClientFactory, config *config.Config, runInventory *inventory.RunInventory, guard *guard.Guard,
+	journal *Journal) *Enforcer {
+	return &Enforcer{
+		clientFactory: clientFactory,
+		config:        config,
+		inventory:     runInventory,
+		compliance:    findings.NewComplianceLog(config),
+		guard:         guard,
+		journal:       journal,
+		ipGroups:      make(map[string][]string),
+	}
+}
+
+// Findings returns the findings recorded during enforcement.
+func (e *Enforcer) Findings() []findings.Finding {
+	return e.findings
+}
+
+// Compliance returns the compliant resources recorded during enforcement.
+func (e *Enforcer) Compliance() *findings.ComplianceLog {
+	return e.compliance
+}
+
+// EnforceAll enforces the NSG association baseline and the subnet isolation
+// rules of the subscriptions that have them.
+func (e *Enforcer) EnforceAll(ctx context.Context) error {
+	if !e.config.Features.NSGAssociation {
+		return nil
+	}
+
+	for _, subID := range e.config.SubscriptionIDs() {
+		subCFG := e.config.Subscriptions[subID]
+		baseline, band := subCFG.NSGAssociation, subCFG.SubnetIsolationBand
+		if baseline == nil && band == nil {
+			continue
+		}
+		if reason, err := e.guard.SkipReason(subID); err != nil {
+			return err
+		} else if reason != "" {
+			fmt.Printf("skipped subscription %s: %s\n", subID, reason)
+			continue
+		}
+
+		if err := e.guard.Contain("nsg", subID, func() error {
+			if baseline != nil {
+				if err := e.enforceSubscription(ctx, subID, baseline); err != nil {
+					return err
+				}
+			}
+			if band != nil {
+				return e.enforceIsolation(ctx, subID, band)
+			}
+			return nil
+		}); err != nil {
+			if guard.IsPanic(err) || e.guard.SkipInactive(subID, err) {
+				continue
+			}
+			return fmt.Errorf("failed to enforce NSG association for subscription %s: %w", subID, err)
+		}
+	}
+	return nil
+}
+
+// enforceSubscription checks the subnets of every spoke VNet in the
+// subscription, then the baseline rules of their NSGs.
+func (e *Enforcer) enforceSubscription(ctx context.Context, subscriptionID string, baseline *config.NSGAssociationConfig) error {
+	// the baseline NSG can only be associated with subnets in its region
+	nsgLocation := ""
+	if baseline.Mode == config.NSGAssociationSpecific {
+		location, err := e.nsgLocation(ctx, baseline.NSGID)
+		if err != nil {
+			return err
+		}
+		nsgLocation = location
+	}
+
+	vnets, err := e.inventory.VNets(ctx, subscriptionID)
+	if err != nil {
+		return err
+	}
+	var nsgIDs []string
+	seen := make(map[string]bool)
+	if baseline.NSGID != "" {
+		nsgIDs = append(nsgIDs, baseline.NSGID)
+		seen[strings.ToLower(baseline.NSGID)] = true
+	}
+	for _, vnet := range vnets {
+		if vnet.ID == nil || vnet.Name == nil || vnet.Properties == nil {
+			continue
+		}
+		if e.config.IsHubVNet(*vnet.ID) {
+			fmt.Printf("skipped VNet %s: hub\n", *vnet.ID)
+			continue
+		}
+		sameRegion := nsgLocation == "" || strings.EqualFold(stringValue(vnet.Location), nsgLocation)
+
+		for _, subnet := range vnet.Properties.Subnets {
+			if subnet == nil || subnet.ID == nil || subnet.Name == nil || subnet.Properties == nil {
+				continue
+			}
+			if err := e.enforceSubnet(ctx, subscriptionID, *vnet.Name, subnet, baseline, sameRegion); err != nil {
+				return err
+			}
+			if nsg := subnet.Properties.NetworkSecurityGroup; nsg != nil && nsg.ID != nil && !seen[strings.ToLower(*nsg.ID)] {
+				nsgIDs = append(nsgIDs, *nsg.ID)
+				seen[strings.ToLower(*nsg.ID)] = true
+			}
+		}
+	}
+
+	if len(baseline.BaselineRules) == 0 {
+		return nil
+	}
+	return e.checkBaselineRules(ctx, subscriptionID, baseline, nsgIDs)
+}
+
+// enforceSubnet checks the NSG of one subnet and, in specific mode with auto
+// remediation, associates the baseline NSG.
+func (e *Enforcer) enforceSubnet(ctx context.Context, subscriptionID, vnetName string, subnet *armnetwork.Subnet,
+	baseline *config.NSGAssociationConfig, sameRegion bool) error {
+	if reason := unsupportedReason(subnet); reason != "" {
+		e.findings = append(e.findings, findings.New(findings.RuleNSGUnsupportedSubnet, e.config.Rules,
+			subscriptionID, *subnet.ID, fmt.Sprintf("subnet %s of VNet %s can't have an NSG: %s", *subnet.Name, vnetName, reason),
+			map[string]string{
+				"subnet": *subnet.Name,
+				"vnet":   vnetName,
+				"reason": reason,
+			}))
+		return nil
+	}
+
+	current := ""
+	if subnet.Properties.NetworkSecurityGroup != nil {
+		current = stringValue(subnet.Properties.NetworkSecurityGroup.ID)
+	}
+
+	if baseline.Mode == config.NSGAssociationAny {
+		if current != "" {
+			e.compliance.Record(findings.RuleNSGMissing, subscriptionID, *subnet.ID)
+			return nil
+		}
+		e.findings = append(e.findings, findings.New(findings.RuleNSGMissing, e.config.Rules,
+			subscriptionID, *subnet.ID, fmt.Sprintf("subnet %s of VNet %s has no NSG", *subnet.Name, vnetName),
+			map[string]string{
+				"subnet": *subnet.Name,
+				"vnet":   vnetName,
+			}))
+		return nil
+	}
+
+	if strings.EqualFold(current, baseline.NSGID) {
+		e.compliance.Record(findings.RuleNSGBaseline, subscriptionID, *subnet.ID)
+		return nil
+	}
+
+	currentDescription := "no NSG"
+	if current != "" {
+		currentDescription = "NSG " + current
+	}
+	e.findings = append(e.findings, findings.New(findings.RuleNSGBaseline, e.config.Rules,
+		subscriptionID, *subnet.ID, fmt.Sprintf("subnet %s of VNet %s has %s instead of baseline NSG %s",
+			*subnet.Name, vnetName, currentDescription, baseline.NSGID),
+		map[string]string{
+			"subnet":  *subnet.Name,
+			"vnet":    vnetName,
+			"nsg":     baseline.NSGID,
+			"current": currentDescription,
+		}))
+
+	if !e.config.Features.AutoRemediation {
+		return nil
+	}
+	if current != "" && !baseline.ReplaceExisting {
+		fmt.Printf("skipped subnet %s: has NSG %s, replaceExisting is off\n", *subnet.ID, current)
+		return nil
+	}
+	if !sameRegion {
+		fmt.Printf("WARNING: skipped subnet %s: baseline NSG %s is in another region\n", *subnet.ID, baseline.NSGID)
+		return nil
+	}
+	if !e.guard.WritesAllowed(subscriptionID) || e.guard.InGracePeriod(ctx, subscriptionID, *subnet.ID) {
+		return nil
+	}
+
+	return e.associate(ctx, subscriptionID, *subnet.ID, baseline)
+}
+
+// associate associates the baseline NSG with the subnet and records it in
+// the journal. The subnet is re-read, so its other properties are kept.
+func (e *Enforcer) associate(ctx context.Context, subscriptionID, subnetID string, baseline *config.NSGAssociationConfig) error {
+	previous := ""
+	written := false
+	err := e.clientFactory.UpdateSubnet(ctx, subnetID, func(subnet *armnetwork.Subnet) (bool, error) {
+		if subnet.Properties.NetworkSecurityGroup != nil {
+			previous = stringValue(subnet.Properties.NetworkSecurityGroup.ID)
+		}
+		// the subnet may have changed since it was listed
+		if strings.EqualFold(previous, baseline.NSGID) || (previous != "" && !baseline.ReplaceExisting) {
+			return false, nil
+		}
+		before, err := json.Marshal(subnet)
+		if err != nil {
+			return false, fmt.Errorf("failed to encode subnet %s: %w", subnetID, err)
+		}
+		subnet.Properties.NetworkSecurityGroup = &armnetwork.SecurityGroup{ID: &baseline.NSGID}
+
+		body, err := json.Marshal(subnet)
+		if err != nil {
+			return false, fmt.Errorf("failed to encode subnet %s: %w", subnetID, err)
+		}
+		if e.guard.Planned(plan.Change{
+			SubscriptionID: subscriptionID,
+			ResourceID:     subnetID,
+			APIVersion:     e.clientFactory.APIVersion(azure.ResourceTypeSubnets),
+			Etag:           stringValue(subnet.Etag),
+			Body:           body,
+			Description:    fmt.Sprintf("associate NSG %s with subnet %s", baseline.NSGID, subnetID),
+			Before:         before,
+		}) {
+			return false, nil
+		}
+		written = true
+		return true, nil
+	})
+	if err != nil {
+		// the subnet was deleted since its VNet was listed
+		if e.guard.SkipDisappeared(subnetID, err) || e.guard.SkipPolicyDenied(subscriptionID, subnetID, err) {
+			return nil
+		}
+		return fmt.Errorf("failed to associate NSG with subnet %s: %w", subnetID, err)
+	}
+	if !written {
+		return nil
+	}
+	e.inventory.Invalidate(subscriptionID)
+
+	return e.journal.Record(Association{
+		SubnetID:       subnetID,
+		SubscriptionID: subscriptionID,
+		NSGID:          baseline.NSGID,
+		PreviousNSGID:  previous,
+		AssociatedAt:   time.Now().UTC(),
+	})
+}
+
+// nsgLocation returns the region of the NSG.
+func (e *Enforcer) nsgLocation(ctx context.Context, nsgID string) (string, error) {
+	parts := azure.ExtractResourceIDParts(nsgID)
+	nsgClient, err := e.clientFactory.ForSubscription(azure.SubscriptionIDOf(nsgID)).NewSecurityGroupsClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	resp, err := nsgClient.Get(ctx, parts["resourceGroups"], parts["networkSecurityGroups"], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get baseline NSG %s: %w", nsgID, err)
+	}
+	return stringValue(resp.Location), nil
+}
+
+// unsupportedReason returns why the subnet can't have an NSG, or an empty
+// string if it can.
+func unsupportedReason(subnet *armnetwork.Subnet) string {
+	if reservedSubnets[strings.ToLower(*subnet.Name)] {
+		return "reserved subnet"
+	}
+	for _, delegation := range subnet.Properties.Delegations {
+		if delegation == nil || delegation.Properties == nil {
+			continue
+		}
+		service := stringValue(delegation.Properties.ServiceName)
+		if forbiddingDelegations[strings.ToLower(service)] {
+			return "delegated to " + service
+		}
+	}
+	return ""
+}
+
+// stringValue returns the value of p, or an empty string if p is nil.
+func stringValue(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
@@ -0,0 +1,453 @@
+package nsg
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// serviceTagAliases are the legacy names Azure still returns for some
+// service tags, by lower-case name.
+var serviceTagAliases = map[string]string{
+	"virtual_network":    "virtualnetwork",
+	"azure_loadbalancer": "azureloadbalancer",
+}
+
+// checkBaselineRules reports the baseline rules missing from the NSGs. A
+// rule is present if any rule of the NSG is equivalent, whatever its name
+// and, unless the baseline rule sets one, its priority.
+func (e *Enforcer) checkBaselineRules(ctx context.Context, subscriptionID string, baseline *config.NSGAssociationConfig, nsgIDs []string) error {
+	expected := make([]*securityRule, 0, len(baseline.BaselineRules))
+	for _, rule := range baseline.BaselineRules {
+		normalized, err := e.baselineRule(ctx, subscriptionID, rule, baseline.CompareExpanded)
+		if err != nil {
+			return err
+		}
+		if normalized != nil {
+			expected = append(expected, normalized)
+		}
+	}
+
+	for _, nsgID := range nsgIDs {
+		nsg, err := e.securityGroup(ctx, nsgID)
+		if err != nil {
+			// the NSG was deleted since its subnets were listed
+			if e.guard.SkipDisappeared(nsgID, err) {
+				continue
+			}
+			return err
+		}
+		var existing []*securityRule
+		if nsg.Properties != nil {
+			for _, rule := range nsg.Properties.SecurityRules {
+				if rule != nil && rule.Properties != nil {
+					existing = append(existing, nsgRule(rule.Properties))
+				}
+			}
+		}
+
+		nsgName := azure.ExtractResourceIDParts(nsgID)["networkSecurityGroups"]
+		missing := 0
+		for _, rule := range expected {
+			if rule.presentIn(existing) {
+				continue
+			}
+			missing++
+			e.findings = append(e.findings, findings.New(findings.RuleNSGBaselineRule, e.config.Rules,
+				subscriptionID, nsgID, fmt.Sprintf("NSG %s has no rule equivalent to baseline rule %s", nsgName, rule.name),
+				map[string]string{
+					"rule":     rule.name,
+					"nsg":      nsgName,
+					"expected": rule.description,
+				}))
+		}
+		if missing == 0 {
+			e.compliance.Record(findings.RuleNSGBaselineRule, subscriptionID, nsgID)
+		}
+	}
+	return nil
+}
+
+// baselineRule returns the baseline rule normalized, its IP Groups expanded
+// with compareExpanded. Returns nil if it references an IP Group that
+// doesn't exist, it can't be checked.
+func (e *Enforcer) baselineRule(ctx context.Context, subscriptionID string, rule config.NSGRuleConfig, compareExpanded bool) (*securityRule, error) {
+	normalized := &securityRule{
+		name:             rule.Name,
+		description:      describeRule(rule),
+		direction:        strings.ToLower(rule.Direction),
+		access:           strings.ToLower(rule.Access),
+		protocol:         strings.ToLower(rule.EffectiveProtocol()),
+		priority:         rule.Priority,
+		sourcePorts:      newPortSet(rule.EffectiveSourcePorts()),
+		destinationPorts: newPortSet(rule.DestinationPorts),
+	}
+
+	addresses := func(values []string) (*addressSet, bool, error) {
+		var expanded []string
+		ipGroups := false
+		for _, value := range values {
+			if !config.IsIPGroupID(value) {
+				expanded = append(expanded, value)
+				continue
+			}
+			groupAddresses, exists, err := e.ipGroupAddresses(ctx, value)
+			if err != nil {
+				return nil, false, err
+			}
+			if !exists {
+				e.findings = append(e.findings, findings.New(findings.RuleNSGUnknownIPGroup, e.config.Rules,
+					subscriptionID, value, fmt.Sprintf("IP Group %s of baseline rule %s doesn't exist", value, rule.Name),
+					map[string]string{
+						"ipGroup": value,
+						"rule":    rule.Name,
+					}))
+				fmt.Printf("skipped baseline rule %s: IP Group %s doesn't exist\n", rule.Name, value)
+				return nil, false, nil
+			}
+			if compareExpanded {
+				expanded = append(expanded, groupAddresses...)
+			} else {
+				ipGroups = true
+			}
+		}
+		return newAddressSet(expanded), ipGroups, nil
+	}
+
+	var err error
+	if normalized.sources, normalized.sourceIPGroups, err = addresses(rule.Sources); err != nil || normalized.sources == nil {
+		return nil, err
+	}
+	if normalized.destinations, normalized.destinationIPGroups, err = addresses(rule.Destinations); err != nil || normalized.destinations == nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// ipGroupAddresses returns the addresses of the IP Group, false if it
+// doesn't exist. IP Groups are read once per run.
+func (e *Enforcer) ipGroupAddresses(ctx context.Context, ipGroupID string) ([]string, bool, error) {
+	key := strings.ToLower(ipGroupID)
+	if addresses, ok := e.ipGroups[key]; ok {
+		return addresses, addresses != nil, nil
+	}
+
+	parts := azure.ExtractResourceIDParts(ipGroupID)
+	client, err := e.clientFactory.ForSubscription(azure.SubscriptionIDOf(ipGroupID)).NewIPGroupsClient(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err := client.Get(ctx, parts["resourceGroups"], parts["ipGroups"], nil)
+	if azure.IsNotFound(err) {
+		e.ipGroups[key] = nil
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get IP Group %s: %w", ipGroupID, err)
+	}
+
+	addresses := []string{}
+	if resp.Properties != nil {
+		for _, address := range resp.Properties.IPAddresses {
+			if address != nil {
+				addresses = append(addresses, *address)
+			}
+		}
+	}
+	e.ipGroups[key] = addresses
+	return addresses, true, nil
+}
+
+// securityGroup returns the NSG with its rules.
+func (e *Enforcer) securityGroup(ctx context.Context, nsgID string) (*armnetwork.SecurityGroup, error) {
+	parts := azure.ExtractResourceIDParts(nsgID)
+	client, err := e.clientFactory.ForSubscription(azure.SubscriptionIDOf(nsgID)).NewSecurityGroupsClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Get(ctx, parts["resourceGroups"], parts["networkSecurityGroups"], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get NSG %s: %w", nsgID, err)
+	}
+	return &resp.SecurityGroup, nil
+}
+
+// securityRule is an NSG rule or a baseline rule, normalized for comparison.
+type securityRule struct {
+	name        string
+	description string
+	direction   string
+	access      string
+	protocol    string
+	// priority is that of an NSG rule, or the one a baseline rule
+	// requires, 0 if any.
+	priority int
+	// sourceIPGroups and destinationIPGroups mark baseline rules with
+	// unexpanded IP Groups: their addresses are a subset of the rule's.
+	sources, destinations               *addressSet
+	sourceIPGroups, destinationIPGroups bool
+	sourcePorts, destinationPorts       portSet
+}
+
+// nsgRule returns the rule of an NSG normalized.
+func nsgRule(p *armnetwork.SecurityRulePropertiesFormat) *securityRule {
+	rule := &securityRule{}
+	if p.Direction != nil {
+		rule.direction = strings.ToLower(string(*p.Direction))
+	}
+	if p.Access != nil {
+		rule.access = strings.ToLower(string(*p.Access))
+	}
+	if p.Protocol != nil {
+		rule.protocol = strings.ToLower(string(*p.Protocol))
+	}
+	if p.Priority != nil {
+		rule.priority = int(*p.Priority)
+	}
+	rule.sources = newAddressSet(append(values(p.SourceAddressPrefix, p.SourceAddressPrefixes), asgs(p.SourceApplicationSecurityGroups)...))
+	rule.destinations = newAddressSet(append(values(p.DestinationAddressPrefix, p.DestinationAddressPrefixes), asgs(p.DestinationApplicationSecurityGroups)...))
+	rule.sourcePorts = newPortSet(values(p.SourcePortRange, p.SourcePortRanges))
+	rule.destinationPorts = newPortSet(values(p.DestinationPortRange, p.DestinationPortRanges))
+	return rule
+}
+
+// presentIn reports whether one of the rules is equivalent to the baseline
+// rule.
+func (r *securityRule) presentIn(rules []*securityRule) bool {
+	for _, rule := range rules {
+		if r.matches(rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the rule of an NSG is equivalent to the baseline
+// rule.
+func (r *securityRule) matches(rule *securityRule) bool {
+	if r.direction != rule.direction || r.access != rule.access || r.protocol != rule.protocol {
+		return false
+	}
+	if r.priority != 0 && r.priority != rule.priority {
+		return false
+	}
+	if !r.sourcePorts.equal(rule.sourcePorts) || !r.destinationPorts.equal(rule.destinationPorts) {
+		return false
+	}
+	return matchesAddresses(r.sources, rule.sources, r.sourceIPGroups) &&
+		matchesAddresses(r.destinations, rule.destinations, r.destinationIPGroups)
+}
+
+// matchesAddresses compares the addresses of a baseline rule with the
+// rule's. With unexpanded IP Groups the rule must have the baseline's other
+// addresses and more, the IP Groups'.
+func matchesAddresses(baseline, rule *addressSet, ipGroups bool) bool {
+	if !ipGroups {
+		return baseline.equal(rule)
+	}
+	return rule.covers(baseline) && !baseline.covers(rule)
+}
+
+// describeRule describes the baseline rule for remediations.
+func describeRule(rule config.NSGRuleConfig) string {
+	return fmt.Sprintf("%s %s %s from %s port %s to %s port %s", rule.Direction, rule.Access, rule.EffectiveProtocol(),
+		strings.Join(rule.Sources, ","), strings.Join(rule.EffectiveSourcePorts(), ","),
+		strings.Join(rule.Destinations, ","), strings.Join(rule.DestinationPorts, ","))
+}
+
+// values returns the single value and the list of values of a rule
+// property, Azure sets either.
+func values(single *string, list []*string) []string {
+	var result []string
+	if single != nil && *single != "" {
+		result = append(result, *single)
+	}
+	for _, value := range list {
+		if value != nil && *value != "" {
+			result = append(result, *value)
+		}
+	}
+	return result
+}
+
+// asgs returns the application security groups of a rule as addresses, no
+// baseline address is equivalent to them.
+func asgs(groups []*armnetwork.ApplicationSecurityGroup) []string {
+	var result []string
+	for _, group := range groups {
+		if group != nil && group.ID != nil {
+			result = append(result, "asg:"+*group.ID)
+		}
+	}
+	return result
+}
+
+// interval is an inclusive range of IPv4 addresses or ports.
+type interval struct {
+	first, last uint32
+}
+
+// merge sorts the intervals and merges the overlapping and adjacent ones.
+func merge(intervals []interval) []interval {
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].first < intervals[j].first })
+	var merged []interval
+	for _, iv := range intervals {
+		if n := len(merged); n > 0 && uint64(iv.first) <= uint64(merged[n-1].last)+1 {
+			merged[n-1].last = max(merged[n-1].last, iv.last)
+			continue
+		}
+		merged = append(merged, iv)
+	}
+	return merged
+}
+
+// covers reports whether the merged intervals a contain every interval of b.
+func covers(a, b []interval) bool {
+	for _, iv := range b {
+		i := sort.Search(len(a), func(i int) bool { return a[i].last >= iv.first })
+		if i == len(a) || a[i].first > iv.first || a[i].last < iv.last {
+			return false
+		}
+	}
+	return true
+}
+
+// addressSet is the addresses of a rule: the IPv4 addresses as merged
+// ranges, so 10.0.0.0/24 and 10.0.1.0/24 equal 10.0.0.0/23, and the other
+// addresses, such as service tags, by lower-case name.
+type addressSet struct {
+	ipv4  []interval
+	other map[string]bool
+}
+
+// newAddressSet normalizes the addresses: prefixes, addresses, address
+// ranges, service tags, or *.
+func newAddressSet(addresses []string) *addressSet {
+	s := &addressSet{other: make(map[string]bool)}
+	var ipv4 []interval
+	for _, address := range addresses {
+		address = strings.TrimSpace(address)
+		if iv, ok := ipv4Interval(address); ok {
+			ipv4 = append(ipv4, iv)
+			continue
+		}
+		if _, network, err := net.ParseCIDR(address); err == nil {
+			s.other[network.String()] = true
+			continue
+		}
+		if ip := net.ParseIP(address); ip != nil {
+			s.other[ip.String()] = true
+			continue
+		}
+		key := strings.ToLower(address)
+		if key == "any" {
+			key = config.NSGRuleAny
+		}
+		if alias, ok := serviceTagAliases[key]; ok {
+			key = alias
+		}
+		s.other[key] = true
+	}
+	s.ipv4 = merge(ipv4)
+	return s
+}
+
+// equal reports whether the sets contain the same addresses.
+func (s *addressSet) equal(o *addressSet) bool {
+	return s.covers(o) && o.covers(s)
+}
+
+// covers reports whether the set contains every address of o.
+func (s *addressSet) covers(o *addressSet) bool {
+	for key := range o.other {
+		if !s.other[key] {
+			return false
+		}
+	}
+	return covers(s.ipv4, o.ipv4)
+}
+
+// overlaps reports whether the sets may share an address. * overlaps
+// everything, and the VirtualNetwork tag any IPv4 address, the address
+// space of a VNet and its peerings isn't known here.
+func (s *addressSet) overlaps(o *addressSet) bool {
+	for _, set := range []*addressSet{s, o} {
+		if set.other[config.NSGRuleAny] {
+			return true
+		}
+	}
+	if (s.other["virtualnetwork"] && len(o.ipv4) > 0) || (o.other["virtualnetwork"] && len(s.ipv4) > 0) {
+		return true
+	}
+	for key := range o.other {
+		if s.other[key] {
+			return true
+		}
+	}
+	for _, a := range s.ipv4 {
+		for _, b := range o.ipv4 {
+			if a.first <= b.last && b.first <= a.last {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ipv4Interval returns the range of an IPv4 address, prefix, or range such
+// as 10.0.0.1-10.0.0.9 as IP Groups allow.
+func ipv4Interval(address string) (interval, bool) {
+	if first, last, ok := strings.Cut(address, "-"); ok {
+		from, to := net.ParseIP(first).To4(), net.ParseIP(last).To4()
+		if from == nil || to == nil || binary.BigEndian.Uint32(from) > binary.BigEndian.Uint32(to) {
+			return interval{}, false
+		}
+		return interval{binary.BigEndian.Uint32(from), binary.BigEndian.Uint32(to)}, true
+	}
+	if _, network, err := net.ParseCIDR(address); err == nil {
+		ip := network.IP.To4()
+		if ip == nil {
+			return interval{}, false
+		}
+		ones, _ := network.Mask.Size()
+		first := binary.BigEndian.Uint32(ip)
+		return interval{first, first | uint32(uint64(1)<<(32-ones)-1)}, true
+	}
+	if ip := net.ParseIP(address).To4(); ip != nil {
+		return interval{binary.BigEndian.Uint32(ip), binary.BigEndian.Uint32(ip)}, true
+	}
+	return interval{}, false
+}
+
+// portSet is the ports of a rule as merged ranges, so 80-80 equals 80.
+type portSet []interval
+
+// newPortSet normalizes the ports, * or no ports is every port.
+func newPortSet(ports []string) portSet {
+	if len(ports) == 0 {
+		ports = []string{config.NSGRuleAny}
+	}
+	var intervals []interval
+	for _, value := range ports {
+		first, last, err := config.ParsePortRange(strings.TrimSpace(value))
+		if err != nil {
+			// not comparable to any baseline port
+			return portSet{{first: 1 << 31, last: 1 << 31}}
+		}
+		intervals = append(intervals, interval{uint32(first), uint32(last)})
+	}
+	return merge(intervals)
+}
+
+// equal reports whether the sets contain the same ports.
+func (s portSet) equal(o portSet) bool {
+	return covers(s, o) && covers(o, s)
+}
@@ -0,0 +1,322 @@
+package nsg
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/guard"
+	"github.com/akos011221/velora/internal/inventory"
+	"github.com/akos011221/velora/internal/pause"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// The resources of the tests.
+const (
+	spokeVNetID = "/subscriptions/" + configtest.SubscriptionID + "/resourceGroups/spoke-rg/providers/Microsoft.Network/virtualNetworks/spoke"
+	spokeNSGID  = "/subscriptions/" + configtest.SubscriptionID + "/resourceGroups/spoke-rg/providers/Microsoft.Network/networkSecurityGroups/spoke-nsg"
+	corpIPGroup = "/subscriptions/" + configtest.HubSubscriptionID + "/resourceGroups/security-rg/providers/Microsoft.Network/ipGroups/corp"
+)
+
+// corpAddresses are the addresses of the corp IP Group.
+var corpAddresses = []string{"10.50.0.0/24", "10.51.0.1-10.51.0.9"}
+
+// newTestARM returns a fake ARM with the corp IP Group.
+func newTestARM() *azuretest.Server {
+	arm := azuretest.NewServer()
+	arm.Put(corpIPGroup, &armnetwork.IPGroup{Properties: &armnetwork.IPGroupPropertiesFormat{IPAddresses: to.SliceOfPtrs(corpAddresses...)}})
+	return arm
+}
+
+// newTestEnforcer returns an enforcer of the configuration against the fake
+// ARM, with the state in a temporary directory.
+func newTestEnforcer(t *testing.T, cfg *config.Config, arm *azuretest.Server) *Enforcer {
+	t.Helper()
+	store, err := state.Open(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory := azure.NewClientFactoryWithTransport(&cfg.Azure, azuretest.Credential{}, arm)
+	return NewEnforcer(factory, cfg, inventory.NewRunInventory(factory, cfg.Inventory.EffectiveMaxCachedResources()),
+		guard.New(pause.NewManager(store)), NewJournal(store))
+}
+
+// httpsToMonitor is the baseline rule of the comparison tests.
+func httpsToMonitor() config.NSGRuleConfig {
+	return config.NSGRuleConfig{
+		Name:             "allow-monitor",
+		Direction:        config.NSGRuleOutbound,
+		Access:           config.NSGRuleAllow,
+		Protocol:         "Tcp",
+		Sources:          []string{"10.1.0.0/23"},
+		Destinations:     []string{"AzureMonitor"},
+		DestinationPorts: []string{"443"},
+	}
+}
+
+// nsgRuleTo returns the NSG rule equivalent to httpsToMonitor, with the
+// sources, destinations and destination ports.
+func nsgRuleTo(sources, destinations, ports []string) *armnetwork.SecurityRulePropertiesFormat {
+	return &armnetwork.SecurityRulePropertiesFormat{
+		Direction:                  to.Ptr(armnetwork.SecurityRuleDirectionOutbound),
+		Access:                     to.Ptr(armnetwork.SecurityRuleAccessAllow),
+		Protocol:                   to.Ptr(armnetwork.SecurityRuleProtocolTCP),
+		Priority:                   to.Ptr[int32](200),
+		SourceAddressPrefixes:      to.SliceOfPtrs(sources...),
+		SourcePortRange:            to.Ptr("*"),
+		DestinationAddressPrefixes: to.SliceOfPtrs(destinations...),
+		DestinationPortRanges:      to.SliceOfPtrs(ports...),
+	}
+}
+
+// TestBaselineRuleMatches compares baseline rules with NSG rules by
+// meaning. A false mismatch is a finding every run, so the equivalent forms
+// Azure and people write rules in are covered.
+func TestBaselineRuleMatches(t *testing.T) {
+	tests := []struct {
+		name            string
+		baseline        func(rule *config.NSGRuleConfig)
+		rule            func(p *armnetwork.SecurityRulePropertiesFormat)
+		compareExpanded bool
+		want            bool
+	}{
+		{name: "same rule", want: true},
+		{
+			name: "prefix split in two",
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) {
+				p.SourceAddressPrefixes = to.SliceOfPtrs("10.1.1.0/24", "10.1.0.0/24")
+			},
+			want: true,
+		},
+		{
+			name: "single prefix",
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) {
+				p.SourceAddressPrefixes, p.SourceAddressPrefix = nil, to.Ptr("10.1.0.0/23")
+			},
+			want: true,
+		},
+		{
+			name: "prefix not in canonical form",
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) {
+				p.SourceAddressPrefixes = to.SliceOfPtrs("10.1.1.7/23")
+			},
+			want: true,
+		},
+		{
+			name: "narrower prefix",
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) {
+				p.SourceAddressPrefixes = to.SliceOfPtrs("10.1.0.0/24")
+			},
+		},
+		{
+			name: "wider prefix",
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) {
+				p.SourceAddressPrefixes = to.SliceOfPtrs("10.1.0.0/16")
+			},
+		},
+		{
+			name: "port as a range",
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) {
+				p.DestinationPortRanges, p.DestinationPortRange = nil, to.Ptr("443-443")
+			},
+			want: true,
+		},
+		{
+			name:     "ports in another order",
+			baseline: func(rule *config.NSGRuleConfig) { rule.DestinationPorts = []string{"443", "8443-8444"} },
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) {
+				p.DestinationPortRanges = to.SliceOfPtrs("8444", "8443", "443")
+			},
+			want: true,
+		},
+		{
+			name: "other port",
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) {
+				p.DestinationPortRanges = to.SliceOfPtrs("80")
+			},
+		},
+		{
+			name: "service tag in lower case",
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) {
+				p.DestinationAddressPrefixes = to.SliceOfPtrs("azuremonitor")
+			},
+			want: true,
+		},
+		{
+			name:     "legacy service tag name",
+			baseline: func(rule *config.NSGRuleConfig) { rule.Destinations = []string{"VirtualNetwork"} },
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) {
+				p.DestinationAddressPrefixes = to.SliceOfPtrs("VIRTUAL_NETWORK")
+			},
+			want: true,
+		},
+		{
+			name:     "regional service tag",
+			baseline: func(rule *config.NSGRuleConfig) { rule.Destinations = []string{"Storage.WestEurope"} },
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) {
+				p.DestinationAddressPrefixes = to.SliceOfPtrs("Storage")
+			},
+		},
+		{
+			name: "other access",
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) { p.Access = to.Ptr(armnetwork.SecurityRuleAccessDeny) },
+		},
+		{
+			name: "any protocol",
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) {
+				p.Protocol = to.Ptr(armnetwork.SecurityRuleProtocolAsterisk)
+			},
+		},
+		{
+			name:     "required priority",
+			baseline: func(rule *config.NSGRuleConfig) { rule.Priority = 200 },
+			want:     true,
+		},
+		{
+			name:     "other priority",
+			baseline: func(rule *config.NSGRuleConfig) { rule.Priority = 300 },
+		},
+		{
+			name: "application security group",
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) {
+				p.SourceAddressPrefixes = nil
+				p.SourceApplicationSecurityGroups = []*armnetwork.ApplicationSecurityGroup{{ID: to.Ptr("/subscriptions/" + configtest.SubscriptionID + "/resourceGroups/spoke-rg/providers/Microsoft.Network/applicationSecurityGroups/app")}}
+			},
+		},
+		{
+			// the range of the IP Group is written as prefixes in the NSG
+			name:            "expanded IP Group",
+			baseline:        func(rule *config.NSGRuleConfig) { rule.Sources = []string{corpIPGroup} },
+			compareExpanded: true,
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) {
+				p.SourceAddressPrefixes = to.SliceOfPtrs("10.50.0.0/24", "10.51.0.1", "10.51.0.2/31", "10.51.0.4/30", "10.51.0.8/31")
+			},
+			want: true,
+		},
+		{
+			name:            "expanded IP Group missing addresses",
+			baseline:        func(rule *config.NSGRuleConfig) { rule.Sources = []string{corpIPGroup} },
+			compareExpanded: true,
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) {
+				p.SourceAddressPrefixes = to.SliceOfPtrs("10.50.0.0/24")
+			},
+		},
+		{
+			name:     "IP Group with other addresses",
+			baseline: func(rule *config.NSGRuleConfig) { rule.Sources = []string{corpIPGroup, "10.1.0.0/23"} },
+			rule: func(p *armnetwork.SecurityRulePropertiesFormat) {
+				p.SourceAddressPrefixes = to.SliceOfPtrs("10.1.0.0/23", "192.168.0.0/16")
+			},
+			want: true,
+		},
+		{
+			// the IP Group stands for addresses the rule doesn't have
+			name:     "IP Group without addresses of its own",
+			baseline: func(rule *config.NSGRuleConfig) { rule.Sources = []string{corpIPGroup, "10.1.0.0/23"} },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t)
+			enforcer := newTestEnforcer(t, cfg, newTestARM())
+			baseline := httpsToMonitor()
+			if tt.baseline != nil {
+				tt.baseline(&baseline)
+			}
+			p := nsgRuleTo([]string{"10.1.0.0/23"}, []string{"AzureMonitor"}, []string{"443"})
+			if tt.rule != nil {
+				tt.rule(p)
+			}
+
+			expected, err := enforcer.baselineRule(context.Background(), configtest.SubscriptionID, baseline, tt.compareExpanded)
+			if err != nil || expected == nil {
+				t.Fatalf("baselineRule() = %v, %v", expected, err)
+			}
+			if got := expected.matches(nsgRule(p)); got != tt.want {
+				t.Errorf("matches() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnforceAllBaselineRules(t *testing.T) {
+	missingIPGroup := "/subscriptions/" + configtest.HubSubscriptionID + "/resourceGroups/security-rg/providers/Microsoft.Network/ipGroups/deleted"
+	tests := []struct {
+		name     string
+		baseline []config.NSGRuleConfig
+		want     []string
+	}{
+		{
+			name:     "rule present",
+			baseline: []config.NSGRuleConfig{httpsToMonitor()},
+		},
+		{
+			name: "rule missing",
+			baseline: []config.NSGRuleConfig{func() config.NSGRuleConfig {
+				rule := httpsToMonitor()
+				rule.DestinationPorts = []string{"80"}
+				return rule
+			}()},
+			want: []string{findings.RuleNSGBaselineRule.ID + " " + spokeNSGID},
+		},
+		{
+			// the rule referencing the IP Group can't be checked, the others are
+			name: "unknown IP Group",
+			baseline: []config.NSGRuleConfig{
+				httpsToMonitor(),
+				func() config.NSGRuleConfig {
+					rule := httpsToMonitor()
+					rule.Name = "allow-corp"
+					rule.Sources = []string{missingIPGroup}
+					return rule
+				}(),
+			},
+			want: []string{findings.RuleNSGUnknownIPGroup.ID + " " + missingIPGroup},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arm := newTestARM()
+			subnet := azuretest.Subnet("app", "10.1.0.0/24", "")
+			subnet.Properties.NetworkSecurityGroup = &armnetwork.SecurityGroup{ID: to.Ptr(spokeNSGID)}
+			arm.Put(spokeVNetID, azuretest.VNet(spokeVNetID, []string{"10.1.0.0/16"}, subnet))
+			arm.Put(spokeNSGID, &armnetwork.SecurityGroup{Properties: &armnetwork.SecurityGroupPropertiesFormat{
+				SecurityRules: []*armnetwork.SecurityRule{{
+					Name:       to.Ptr("AllowMonitorOutbound"),
+					Properties: nsgRuleTo([]string{"10.1.0.0/24", "10.1.1.0/24"}, []string{"AzureMonitor"}, []string{"443"}),
+				}},
+			}})
+			cfg := configtest.New(t, func(cfg *config.Config) {
+				cfg.Features.NSGAssociation = true
+				sub := cfg.Subscriptions[configtest.SubscriptionID]
+				sub.NSGAssociation = &config.NSGAssociationConfig{Mode: config.NSGAssociationAny, BaselineRules: tt.baseline}
+				cfg.Subscriptions[configtest.SubscriptionID] = sub
+			})
+			enforcer := newTestEnforcer(t, cfg, arm)
+
+			if err := enforcer.EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error = %v", err)
+			}
+			var got []string
+			for _, f := range enforcer.Findings() {
+				got = append(got, f.RuleID+" "+f.ResourceID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("findings = %v, want %v", got, tt.want)
+			}
+			if writes := arm.Writes(); len(writes) != 0 {
+				t.Errorf("writes = %v, want the baseline rules only reported", writes)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package peering
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/inventory"
+	"github.com/akos011221/velora/internal/plan"
+)
+
+// repairSpokePeering creates the spoke side of the hub peering if it is
+// missing, or re-creates it if it is Disconnected, with the credentials of
+// the spoke's subscription. A new spoke is refused if the hub is at
+// capacity. It returns the peering as it is after the repair, nil if it is
+// still missing. hubInv is nil if the hub isn't readable.
+func (e *Enforcer) repairSpokePeering(ctx context.Context, subscriptionID string, vnet *armnetwork.VirtualNetwork,
+	spokePeering *armnetwork.VirtualNetworkPeering, hubInv *inventory.HubInventory, hubCFG *config.HubVNetConfig) (*armnetwork.VirtualNetworkPeering, error) {
+	disconnected := spokePeering != nil && peeringState(spokePeering) == armnetwork.VirtualNetworkPeeringStateDisconnected
+	if spokePeering != nil && !disconnected {
+		return spokePeering, nil
+	}
+
+	// the VNet isn't peered with the hub. During a failover the hub is the
+	// failover hub, so spokes must be peered with it too.
+	message := fmt.Sprintf("VNet %s is not peered with hub %s", *vnet.Name, hubCFG.Name)
+	if disconnected {
+		message = fmt.Sprintf("peering %s of VNet %s with hub %s is Disconnected", *spokePeering.Name, *vnet.Name, hubCFG.Name)
+	}
+	e.findings = append(e.findings, findings.New(findings.RuleHubPeeringMissing, e.config.Rules,
+		subscriptionID, *vnet.ID, message,
+		map[string]string{
+			"vnet": *vnet.Name,
+			"hub":  hubCFG.Name,
+		}))
+
+	if !e.config.Features.AutoRemediation || !e.guard.WritesAllowed(subscriptionID) ||
+		e.guard.InGracePeriod(ctx, subscriptionID, *vnet.ID) {
+		return spokePeering, nil
+	}
+
+	name, err := e.config.Peering.SpokePeeringName(*vnet.Name, hubCFG)
+	if err != nil {
+		return spokePeering, fmt.Errorf("failed to name the peering of VNet %s with hub %s: %w", *vnet.Name, hubCFG.Name, err)
+	}
+	if disconnected {
+		// a Disconnected peering can't be reconnected, only re-created
+		name = *spokePeering.Name
+		if deleted, err := e.deletePeering(ctx, subscriptionID, spokePeering, "Disconnected"); err != nil || !deleted {
+			return spokePeering, err
+		}
+	} else if full := e.quotas.ReserveSpoke(hubCFG, hubInv, *vnet.ID, len(addressPrefixes(vnet))); full != nil {
+		e.findings = append(e.findings, full.Finding(subscriptionID, *vnet.ID, *vnet.Name, "peering VNet "+*vnet.Name+" with hub "+hubCFG.Name))
+		return nil, nil
+	} else if blocked := e.quotas.Reserve(subscriptionID, config.LimitPeeringsPerVNet, *vnet.ID,
+		len(vnet.Properties.VirtualNetworkPeerings)); blocked != nil {
+		e.findings = append(e.findings, blocked.Finding(subscriptionID, *vnet.ID, "peering VNet "+*vnet.Name+" with hub "+hubCFG.Name))
+		return nil, nil
+	}
+
+	// gateway transit is left off, it fails until the hub side allows it.
+	// checkRemoteGateways reports it once the peering is connected.
+	created := armnetwork.VirtualNetworkPeering{
+		Name: to.Ptr(name),
+		ID:   to.Ptr(*vnet.ID + "/virtualNetworkPeerings/" + name),
+		Properties: &armnetwork.VirtualNetworkPeeringPropertiesFormat{
+			RemoteVirtualNetwork:      &armnetwork.SubResource{ID: to.Ptr(hubCFG.VNetID)},
+			AllowVirtualNetworkAccess: to.Ptr(true),
+			AllowForwardedTraffic:     to.Ptr(true),
+			UseRemoteGateways:         to.Ptr(false),
+		},
+	}
+	// a refused peering is still missing
+	if ok, err := e.createPeering(ctx, subscriptionID, &created); err != nil || !ok {
+		return nil, err
+	}
+	// until the hub side exists
+	created.Properties.PeeringState = to.Ptr(armnetwork.VirtualNetworkPeeringStateInitiated)
+	return &created, nil
+}
+
+// enforceHubSide checks the hub side of the spoke's hub peering and reports
+// whether it is connected. If the hub is readable, hubInv isn't nil and its
+// peering is checked, otherwise the hub side is inferred from the spoke
+// side: Initiated means the hub side is missing. A hub side that is missing
+// or Disconnected is reported with the command fixing it, and fixed by
+// velora itself only if the hub is readable, allows velora to write, and
+// has peerings left within its limit and its capacity.
+func (e *Enforcer) enforceHubSide(ctx context.Context, subscriptionID string, vnet *armnetwork.VirtualNetwork,
+	spokePeering, hubPeering *armnetwork.VirtualNetworkPeering, hubInv *inventory.HubInventory, hubCFG *config.HubVNetConfig) (bool, error) {
+	hubReadable := hubInv != nil
+	state := ""
+	switch {
+	case hubReadable && hubPeering == nil:
+		state = "missing"
+	case hubReadable && peeringState(hubPeering) == armnetwork.VirtualNetworkPeeringStateDisconnected:
+		state = "Disconnected"
+	case !hubReadable && peeringState(spokePeering) == armnetwork.VirtualNetworkPeeringStateInitiated:
+		state = "missing, as the spoke side is Initiated"
+	}
+	if state == "" {
+		return true, nil
+	}
+
+	hubSubscriptionID := azure.SubscriptionIDOf(hubCFG.VNetID)
+	name, err := e.config.Peering.HubPeeringName(*vnet.Name, hubCFG)
+	if err != nil {
+		return false, fmt.Errorf("failed to name the peering of hub %s with VNet %s: %w", hubCFG.Name, *vnet.Name, err)
+	}
+	desired := armnetwork.VirtualNetworkPeering{
+		Name: to.Ptr(name),
+		Properties: &armnetwork.VirtualNetworkPeeringPropertiesFormat{
+			RemoteVirtualNetwork:      &armnetwork.SubResource{ID: vnet.ID},
+			AllowVirtualNetworkAccess: to.Ptr(true),
+			AllowForwardedTraffic:     to.Ptr(true),
+			AllowGatewayTransit:       to.Ptr(hubCFG.GatewayTransitRequired),
+		},
+	}
+	if hubPeering != nil && hubPeering.Name != nil {
+		desired.Name = hubPeering.Name
+	}
+	desired.ID = to.Ptr(hubCFG.VNetID + "/virtualNetworkPeerings/" + *desired.Name)
+
+	command, err := e.hubSideCommand(&desired, hubPeering)
+	if err != nil {
+		return false, err
+	}
+	e.findings = append(e.findings, findings.New(findings.RuleHubSidePeering, e.config.Rules,
+		subscriptionID, *vnet.ID, fmt.Sprintf("hub side of the peering of VNet %s with hub %s is %s", *vnet.Name, hubCFG.Name, state),
+		map[string]string{
+			"vnet":    *vnet.Name,
+			"hub":     hubCFG.Name,
+			"state":   state,
+			"command": command,
+		}))
+
+	if !hubCFG.WritesHubSide() || !hubReadable || !e.config.Features.AutoRemediation ||
+		!e.guard.WritesAllowed(hubSubscriptionID) || e.guard.InGracePeriod(ctx, subscriptionID, *vnet.ID) {
+		return false, nil
+	}
+
+	// a Disconnected peering can't be reconnected, only re-created
+	if hubPeering != nil {
+		if deleted, err := e.deletePeering(ctx, hubSubscriptionID, hubPeering, "Disconnected"); err != nil || !deleted {
+			return false, err
+		}
+	} else if full := e.quotas.ReserveSpoke(hubCFG, hubInv, *vnet.ID, len(addressPrefixes(vnet))); full != nil {
+		e.findings = append(e.findings, full.Finding(subscriptionID, *vnet.ID, *vnet.Name, "peering hub "+hubCFG.Name+" with VNet "+*vnet.Name))
+		return false, nil
+	} else if blocked := e.quotas.Reserve(hubSubscriptionID, config.LimitPeeringsPerVNet, hubCFG.VNetID, len(hubInv.Peerings)); blocked != nil {
+		e.findings = append(e.findings, blocked.Finding(subscriptionID, *vnet.ID, "peering hub "+hubCFG.Name+" with VNet "+*vnet.Name))
+		return false, nil
+	}
+	_, err = e.createPeering(ctx, hubSubscriptionID, &desired)
+	// the hub's peerings changed, other controllers must re-read them
+	e.hubCache.Invalidate(hubCFG.Name)
+	return false, err
+}
+
+// hubSideCommand returns the Azure CLI commands creating the hub side of
+// the peering, deleting the Disconnected one first.
+func (e *Enforcer) hubSideCommand(desired, hubPeering *armnetwork.VirtualNetworkPeering) (string, error) {
+	body, err := json.Marshal(desired)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode peering %s: %w", *desired.Name, err)
+	}
+	apiVersion := e.clientFactory.APIVersion(azure.ResourceTypePeerings)
+
+	var commands []string
+	if hubPeering != nil && hubPeering.ID != nil {
+		commands = append(commands, fmt.Sprintf("az rest --method delete --url 'https://management.azure.com%s?api-version=%s'",
+			*hubPeering.ID, apiVersion))
+	}
+	commands = append(commands, fmt.Sprintf("az rest --method put --url 'https://management.azure.com%s?api-version=%s' --body '%s'",
+		*desired.ID, apiVersion, body))
+	return strings.Join(commands, " && "), nil
+}
+
+// hubSyncCommand returns the Azure CLI command syncing the hub side of the
+// peering with the spoke's address space.
+func hubSyncCommand(hubVNetID string, hubPeering *armnetwork.VirtualNetworkPeering) string {
+	parts := azure.ExtractResourceIDParts(hubVNetID)
+	return fmt.Sprintf("az network vnet peering sync --subscription %s --resource-group %s --vnet-name %s --name %s",
+		parts["subscriptions"], parts["resourceGroups"], parts["virtualNetworks"], stringValue(hubPeering.Name))
+}
+
+// createPeering creates the peering, through the guard, with the
+// credentials of its subscription. It fails if the peering exists. It
+// reports whether the peering was created, or planned to be, false if Azure
+// Policy refused it.
+func (e *Enforcer) createPeering(ctx context.Context, subscriptionID string, peering *armnetwork.VirtualNetworkPeering) (bool, error) {
+	body, err := json.Marshal(peering)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode peering %s: %w", *peering.Name, err)
+	}
+	apiVersion := e.clientFactory.APIVersion(azure.ResourceTypePeerings)
+	if e.guard.Planned(plan.Change{
+		SubscriptionID: subscriptionID,
+		ResourceID:     *peering.ID,
+		APIVersion:     apiVersion,
+		Body:           body,
+		Description:    fmt.Sprintf("create peering %s to %s", *peering.Name, remoteVNetID(peering)),
+	}) {
+		return true, nil
+	}
+
+	err = e.clientFactory.ForSubscription(subscriptionID).PutResource(ctx, *peering.ID, apiVersion, "", body, "")
+	e.inventory.Invalidate(subscriptionID)
+	if err != nil {
+		if e.guard.SkipPolicyDenied(subscriptionID, *peering.ID, err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to create peering %s: %w", *peering.ID, err)
+	}
+	return true, nil
+}
+
+// deletePeering deletes the peering, through the guard, if it is unchanged
+// since it was read. It reports whether the peering is gone, or planned to
+// be, so it can be re-created. reason says why it is re-created, e.g.
+// Disconnected.
+func (e *Enforcer) deletePeering(ctx context.Context, subscriptionID string, peering *armnetwork.VirtualNetworkPeering, reason string) (bool, error) {
+	peeringID, etag := stringValue(peering.ID), stringValue(peering.Etag)
+	before, err := json.Marshal(peering)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode peering %s: %w", peeringID, err)
+	}
+	apiVersion := e.clientFactory.APIVersion(azure.ResourceTypePeerings)
+	if e.guard.Planned(plan.Change{
+		SubscriptionID: subscriptionID,
+		ResourceID:     peeringID,
+		APIVersion:     apiVersion,
+		Etag:           etag,
+		Description:    fmt.Sprintf("delete %s peering %s to re-create it", reason, peeringID),
+		Delete:         true,
+		Before:         before,
+	}) {
+		return true, nil
+	}
+
+	err = e.clientFactory.ForSubscription(subscriptionID).DeleteResource(ctx, peeringID, apiVersion, etag)
+	e.inventory.Invalidate(subscriptionID)
+	if err != nil && !azure.IsNotFound(err) {
+		if e.guard.SkipPolicyDenied(subscriptionID, peeringID, err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to delete peering %s: %w", peeringID, err)
+	}
+	return true, nil
+}
+
+// peeringState returns the state of the peering, empty if unknown.
+func peeringState(peering *armnetwork.VirtualNetworkPeering) armnetwork.VirtualNetworkPeeringState {
+	if peering == nil || peering.Properties == nil || peering.Properties.PeeringState == nil {
+		return ""
+	}
+	return *peering.Properties.PeeringState
+}
@@ -0,0 +1,141 @@
+package peering
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/inventory"
+)
+
+// peeringSide is one side of a hub peering to re-create under its template
+// name.
+type peeringSide struct {
+	subscriptionID string
+	vnetID         string
+	peering        *armnetwork.VirtualNetworkPeering
+	name           string
+}
+
+// checkPeeringNames reports the sides of a connected hub peering not named
+// by the naming templates. Peerings can't be renamed, with
+// recreateMisnamedPeerings both sides are deleted and re-created under
+// their template names, within the maintenance window only. It reports
+// whether the peering was re-created, it isn't connected until both sides
+// are.
+func (e *Enforcer) checkPeeringNames(ctx context.Context, subscriptionID string, vnet *armnetwork.VirtualNetwork,
+	spokePeering, hubPeering *armnetwork.VirtualNetworkPeering, hubInv *inventory.HubInventory, hubCFG *config.HubVNetConfig) (bool, error) {
+	spokeName, err := e.config.Peering.SpokePeeringName(*vnet.Name, hubCFG)
+	if err != nil {
+		return false, fmt.Errorf("failed to name the peering of VNet %s with hub %s: %w", *vnet.Name, hubCFG.Name, err)
+	}
+	hubName, err := e.config.Peering.HubPeeringName(*vnet.Name, hubCFG)
+	if err != nil {
+		return false, fmt.Errorf("failed to name the peering of hub %s with VNet %s: %w", hubCFG.Name, *vnet.Name, err)
+	}
+
+	misnamed := false
+	for _, side := range []struct {
+		peering  *armnetwork.VirtualNetworkPeering
+		expected string
+	}{
+		{spokePeering, spokeName},
+		{hubPeering, hubName},
+	} {
+		if side.peering == nil || side.peering.Name == nil || strings.EqualFold(*side.peering.Name, side.expected) {
+			continue
+		}
+		misnamed = true
+		e.findings = append(e.findings, findings.New(findings.RulePeeringMisnamed, e.config.Rules,
+			subscriptionID, *vnet.ID, fmt.Sprintf("peering %s of VNet %s with hub %s should be named %s",
+				*side.peering.Name, *vnet.Name, hubCFG.Name, side.expected),
+			map[string]string{
+				"peering":  *side.peering.Name,
+				"vnet":     *vnet.Name,
+				"hub":      hubCFG.Name,
+				"expected": side.expected,
+			}))
+	}
+	if !misnamed {
+		e.compliance.Record(findings.RulePeeringMisnamed, subscriptionID, *vnet.ID)
+		return false, nil
+	}
+
+	hubSubscriptionID := azure.SubscriptionIDOf(hubCFG.VNetID)
+	if !e.config.Peering.RecreateMisnamedPeerings || !e.config.Features.AutoRemediation ||
+		!e.guard.WritesAllowed(subscriptionID) || !e.guard.WritesAllowed(hubSubscriptionID) ||
+		e.guard.InGracePeriod(ctx, subscriptionID, *vnet.ID) {
+		return false, nil
+	}
+	// deleting either side disconnects the other, both must be re-created
+	if hubInv == nil || hubPeering == nil || hubPeering.Name == nil || !hubCFG.WritesHubSide() {
+		fmt.Printf("skipped re-creating the peering of VNet %s with hub %s: velora doesn't write the hub side\n", *vnet.Name, hubCFG.Name)
+		return false, nil
+	}
+	if !e.config.Peering.MaintenanceWindow.Contains(time.Now(), e.config.Location()) {
+		if e.guard.BreakGlass() == nil {
+			fmt.Printf("skipped re-creating the peering of VNet %s with hub %s: outside the maintenance window\n", *vnet.Name, hubCFG.Name)
+			return false, nil
+		}
+		fmt.Printf("AUDIT: re-creating the peering of VNet %s with hub %s outside the maintenance window under break-glass mode\n", *vnet.Name, hubCFG.Name)
+	}
+
+	spoke := peeringSide{subscriptionID: subscriptionID, vnetID: *vnet.ID, peering: spokePeering, name: spokeName}
+	hub := peeringSide{subscriptionID: hubSubscriptionID, vnetID: hubCFG.VNetID, peering: hubPeering, name: hubName}
+	sides := []peeringSide{hub, spoke}
+	if e.config.Peering.EffectiveRecreateOrder() == config.RecreateSpokeFirst {
+		sides = []peeringSide{spoke, hub}
+	}
+
+	fmt.Printf("WARNING: re-creating the peering of VNet %s with hub %s to rename it, %s first; the VNet has no connectivity to the hub until both sides are re-created\n",
+		*vnet.Name, hubCFG.Name, e.config.Peering.EffectiveRecreateOrder())
+	for i, side := range sides {
+		// the spoke side can only use the hub's gateways once the hub side
+		// allows it, otherwise checkRemoteGateways reports it next run
+		keepRemoteGateways := i > 0
+		if recreated, err := e.recreatePeering(ctx, side, keepRemoteGateways); err != nil || !recreated {
+			// a side left Disconnected is re-created by the next run
+			e.hubCache.Invalidate(hubCFG.Name)
+			return i > 0, err
+		}
+	}
+	// the hub's peerings changed, other controllers must re-read them
+	e.hubCache.Invalidate(hubCFG.Name)
+	return true, nil
+}
+
+// recreatePeering deletes one side of a hub peering and creates it under its
+// template name with the same settings. It reports whether the side was
+// deleted.
+func (e *Enforcer) recreatePeering(ctx context.Context, side peeringSide, keepRemoteGateways bool) (bool, error) {
+	if deleted, err := e.deletePeering(ctx, side.subscriptionID, side.peering, "misnamed"); err != nil || !deleted {
+		return false, err
+	}
+
+	properties := side.peering.Properties
+	if properties == nil {
+		properties = &armnetwork.VirtualNetworkPeeringPropertiesFormat{}
+	}
+	created := armnetwork.VirtualNetworkPeering{
+		Name: to.Ptr(side.name),
+		ID:   to.Ptr(side.vnetID + "/virtualNetworkPeerings/" + side.name),
+		Properties: &armnetwork.VirtualNetworkPeeringPropertiesFormat{
+			RemoteVirtualNetwork:      properties.RemoteVirtualNetwork,
+			AllowVirtualNetworkAccess: properties.AllowVirtualNetworkAccess,
+			AllowForwardedTraffic:     properties.AllowForwardedTraffic,
+			AllowGatewayTransit:       properties.AllowGatewayTransit,
+			UseRemoteGateways:         to.Ptr(false),
+		},
+	}
+	if keepRemoteGateways && properties.UseRemoteGateways != nil {
+		created.Properties.UseRemoteGateways = properties.UseRemoteGateways
+	}
+	_, err := e.createPeering(ctx, side.subscriptionID, &created)
+	return true, err
+}
@@ -0,0 +1,130 @@
+package peering
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// The hub peering of the spoke as another tool named it.
+const (
+	legacySpokePeeringID = spokeVNetID + "/virtualNetworkPeerings/legacy-spoke"
+	legacyHubPeeringID   = configtest.HubVNetID + "/virtualNetworkPeerings/legacy-hub"
+)
+
+// newMisnamedARM returns a fake ARM with the hub of the test configuration
+// and the spoke, connected by a peering not named by the templates.
+func newMisnamedARM() *azuretest.Server {
+	arm := azuretest.NewServer()
+	hub := azuretest.VNet(configtest.HubVNetID, []string{"10.0.0.0/16"})
+	hub.Properties.VirtualNetworkPeerings = []*armnetwork.VirtualNetworkPeering{
+		azuretest.Peering("legacy-hub", spokeVNetID, spokePrefixes, armnetwork.VirtualNetworkPeeringLevelFullyInSync),
+	}
+	arm.Put(configtest.HubVNetID, hub)
+	spoke := azuretest.VNet(spokeVNetID, spokePrefixes)
+	spoke.Properties.VirtualNetworkPeerings = []*armnetwork.VirtualNetworkPeering{
+		azuretest.Peering("legacy-spoke", configtest.HubVNetID, []string{"10.0.0.0/16"}, armnetwork.VirtualNetworkPeeringLevelFullyInSync),
+	}
+	arm.Put(spokeVNetID, spoke)
+	return arm
+}
+
+// windowAround returns a daily maintenance window from the offsets of now.
+func windowAround(from, to time.Duration) *config.MaintenanceWindow {
+	now := time.Now().UTC()
+	return &config.MaintenanceWindow{Start: now.Add(from).Format("15:04"), End: now.Add(to).Format("15:04")}
+}
+
+func TestEnforceAllMisnamedPeering(t *testing.T) {
+	misnamed := []string{findings.RulePeeringMisnamed.ID, findings.RulePeeringMisnamed.ID}
+	tests := []struct {
+		name       string
+		peering    config.PeeringConfig
+		wantWrites []string
+	}{
+		{name: "reported only"},
+		{
+			name:    "outside the maintenance window",
+			peering: config.PeeringConfig{RecreateMisnamedPeerings: true, MaintenanceWindow: windowAround(time.Hour, 2*time.Hour)},
+		},
+		{
+			name:    "hub side first",
+			peering: config.PeeringConfig{RecreateMisnamedPeerings: true, MaintenanceWindow: windowAround(-time.Hour, time.Hour)},
+			wantWrites: []string{
+				"DELETE " + legacyHubPeeringID, "PUT " + hubPeeringID,
+				"DELETE " + legacySpokePeeringID, "PUT " + spokePeeringID,
+			},
+		},
+		{
+			name: "spoke side first",
+			peering: config.PeeringConfig{RecreateMisnamedPeerings: true, MaintenanceWindow: windowAround(-time.Hour, time.Hour),
+				RecreateOrder: config.RecreateSpokeFirst},
+			wantWrites: []string{
+				"DELETE " + legacySpokePeeringID, "PUT " + spokePeeringID,
+				"DELETE " + legacyHubPeeringID, "PUT " + hubPeeringID,
+			},
+		},
+		{
+			name: "custom templates",
+			peering: config.PeeringConfig{SpokeNameTemplate: "peer-{spokeVnet}-to-{hub}", HubNameTemplate: "peer-{hub}-to-{spokeVnet}",
+				RecreateMisnamedPeerings: true, MaintenanceWindow: windowAround(-time.Hour, time.Hour)},
+			wantWrites: []string{
+				"DELETE " + legacyHubPeeringID, "PUT " + configtest.HubVNetID + "/virtualNetworkPeerings/peer-hub-to-spoke",
+				"DELETE " + legacySpokePeeringID, "PUT " + spokeVNetID + "/virtualNetworkPeerings/peer-spoke-to-hub",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t, withPeering, func(cfg *config.Config) { cfg.Peering = tt.peering })
+			arm := newMisnamedARM()
+			e := newTestEnforcer(t, cfg, arm)
+
+			if err := e.EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error = %v", err)
+			}
+			if got := rulesOf(e.Findings()); !reflect.DeepEqual(got, misnamed) {
+				t.Errorf("findings = %v, want both sides misnamed", got)
+			}
+			var writes []string
+			for _, req := range arm.Writes() {
+				writes = append(writes, req.String())
+			}
+			if !reflect.DeepEqual(writes, tt.wantWrites) {
+				t.Errorf("writes = %v, want %v", writes, tt.wantWrites)
+			}
+			if len(tt.wantWrites) == 0 {
+				return
+			}
+
+			// the re-created peering is named by the templates, the next run
+			// doesn't re-create it again. The fake doesn't fill in the remote
+			// address space of created peerings, so it is synced.
+			arm.Reset()
+			e = newTestEnforcer(t, cfg, arm)
+			if err := e.EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error on the next run = %v", err)
+			}
+			for _, f := range e.Findings() {
+				if f.RuleID == findings.RulePeeringMisnamed.ID {
+					t.Errorf("next run finding = %s", f.Message)
+				}
+			}
+			for _, req := range arm.Writes() {
+				if req.Method == http.MethodDelete {
+					t.Errorf("next run write = %s, want no peering deleted", req)
+				}
+			}
+		})
+	}
+}
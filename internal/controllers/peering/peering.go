@@ -0,0 +1,396 @@
+package peering
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/failover"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/guard"
+	"github.com/akos011221/velora/internal/inventory"
+	"github.com/akos011221/velora/internal/limits"
+	"github.com/akos011221/velora/internal/plan"
+	"github.com/akos011221/velora/internal/unprocessable"
+)
+
+// Enforcer handles peering enforcement in Azure.
+type Enforcer struct {
+	clientFactory *azure.ClientFactory
+	config        *config.Config
+	hubCache      *inventory.HubCache
+	inventory     *inventory.RunInventory
+	guard         *guard.Guard
+	failovers     *failover.Manager
+	quotas        *limits.Gate
+	findings      []findings.Finding
+	compliance    *findings.ComplianceLog
+}
+
+// NewEnforcer creates a new peering enforcer instance. The hub cache and the
+// run inventory are shared with the other controllers of the run, writes go
+// through the guard. Failovers
+// decide which hub subscriptions are enforced against. Peerings that would
+// exceed the limit of their VNet are refused by the quotas gate.
+func NewEnforcer(clientFactory *azure.ClientFactory, config *config.Config, hubCache *inventory.HubCache,
+	runInventory *inventory.RunInventory, guard *guard.Guard, failovers *failover.Manager, quotas *limits.Gate) *Enforcer {
+	return &Enforcer{
+		clientFactory: clientFactory,
+		config:        config,
+		compliance:    findings.NewComplianceLog(config),
+		hubCache:      hubCache,
+		inventory:     runInventory,
+		guard:         guard,
+		failovers:     failovers,
+		quotas:        quotas,
+	}
+}
+
+// Findings returns the findings recorded during enforcement.
+func (e *Enforcer) Findings() []findings.Finding {
+	return e.findings
+}
+
+// Compliance returns the compliant resources recorded during enforcement.
+func (e *Enforcer) Compliance() *findings.ComplianceLog {
+	return e.compliance
+}
+
+// EnforceAll applies peering enforcement to all subscriptions.
+func (e *Enforcer) EnforceAll(ctx context.Context) error {
+	if !e.config.Features.PeeringEnforcement {
+		return nil
+	}
+
+	for subID, subCFG := range e.config.Subscriptions {
+		if !subCFG.RequireHubPeering {
+			continue
+		}
+
+		if reason, err := e.guard.SkipReason(subID); err != nil {
+			return err
+		} else if reason != "" {
+			fmt.Printf("skipped subscription %s: %s\n", subID, reason)
+			continue
+		}
+
+		// the hub isn't configured, the checks needing it are skipped
+		if _, missing := e.guard.HubMissing(subID); missing {
+			continue
+		}
+
+		// find the relevant hub, the failover hub while a failover is active
+		hubCFG, err := e.failovers.ActiveHub(e.config, subCFG.HubName)
+		if err != nil {
+			return fmt.Errorf("failed to resolve hub for subscription %s: %w", subID, err)
+		}
+		// spokes of virtual WAN hubs are connected, not peered
+		if hubCFG.IsVirtualWAN() {
+			continue
+		}
+
+		if err := e.guard.Contain("peering", subID, func() error {
+			return e.enforceAddressSpaceSync(ctx, subID, hubCFG)
+		}); err != nil {
+			if guard.IsPanic(err) || e.guard.SkipInactive(subID, err) {
+				continue
+			}
+			return fmt.Errorf("failed to enforce peering address space sync for subscription %s: %w", subID, err)
+		}
+	}
+	return nil
+}
+
+// enforceAddressSpaceSync makes sure that the spoke VNets are peered with
+// the hub, and that the peerings are in sync with the current address space
+// of the spokes. The hub may be owned by another team and not be readable,
+// the hub side is then inferred from the spokes.
+func (e *Enforcer) enforceAddressSpaceSync(ctx context.Context, subscriptionID string, hubCFG *config.HubVNetConfig) error {
+	hubInv, err := e.hubCache.Get(ctx, *hubCFG)
+	if err != nil {
+		if !azure.IsAccessDenied(err) {
+			return err
+		}
+		fmt.Printf("WARNING: hub %s isn't readable, the hub side of its peerings is inferred from the spokes: %v\n", hubCFG.Name, err)
+		hubInv = nil
+	}
+
+	vnets, err := e.inventory.VNets(ctx, subscriptionID)
+	if err != nil {
+		return err
+	}
+	// malformed IDs are skipped, unless there are so many that something
+	// systemic is wrong and nothing should be changed
+	budget := unprocessable.NewBudget(e.config, subscriptionID)
+	var spokes []*armnetwork.VirtualNetwork
+	for _, vnet := range vnets {
+		if vnet.ID == nil || vnet.Name == nil || vnet.Properties == nil {
+			continue
+		}
+		budget.Seen()
+		if !azure.ValidResourceID(*vnet.ID, "virtualNetworks") {
+			e.findings = append(e.findings, budget.Skip("VNet", *vnet.ID))
+			continue
+		}
+		// hubs aren't spokes
+		if e.config.IsHubVNet(*vnet.ID) {
+			fmt.Printf("skipped VNet %s: hub\n", *vnet.ID)
+			continue
+		}
+		spokes = append(spokes, vnet)
+	}
+	if err := budget.Err(); err != nil {
+		return err
+	}
+
+	for _, vnet := range spokes {
+		if err := e.enforceAddressSpaceSyncForVNet(ctx, subscriptionID, vnet, hubCFG, hubInv); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enforceAddressSpaceSyncForVNet checks both sides of the hub peering of a
+// spoke VNet, repairs them, and syncs them if they're stale. hubInv is nil
+// if the hub isn't readable.
+func (e *Enforcer) enforceAddressSpaceSyncForVNet(ctx context.Context, subscriptionID string,
+	vnet *armnetwork.VirtualNetwork, hubCFG *config.HubVNetConfig, hubInv *inventory.HubInventory) error {
+	currentPrefixes := addressPrefixes(vnet)
+
+	// spoke side: the peering pointing to the hub, listed inline with the VNet
+	var spokePeering *armnetwork.VirtualNetworkPeering
+	for _, peering := range vnet.Properties.VirtualNetworkPeerings {
+		if remoteVNetID(peering) != "" && strings.EqualFold(remoteVNetID(peering), hubCFG.VNetID) {
+			spokePeering = peering
+		}
+	}
+
+	if spokePeering != nil && spokePeering.Name == nil {
+		spokePeering = nil
+	}
+	spokePeering, err := e.repairSpokePeering(ctx, subscriptionID, vnet, spokePeering, hubInv, hubCFG)
+	if err != nil {
+		return err
+	}
+	// the VNet can't reach the hub, the controllers after this one leave it alone
+	if spokePeering == nil || peeringState(spokePeering) == armnetwork.VirtualNetworkPeeringStateDisconnected {
+		e.guard.BlockDownstream(*vnet.ID, findings.RuleHubPeeringMissing.ID,
+			fmt.Sprintf("VNet %s isn't peered with hub %s", *vnet.Name, hubCFG.Name))
+		return nil
+	}
+	// nothing to sync until the spoke side is connected
+	if spokePeering.Properties == nil {
+		return nil
+	}
+
+	// hub side: the peering pointing to this spoke
+	var hubPeering *armnetwork.VirtualNetworkPeering
+	if hubInv != nil {
+		for _, peering := range hubInv.Peerings {
+			if strings.EqualFold(remoteVNetID(peering), *vnet.ID) {
+				hubPeering = peering
+				break
+			}
+		}
+	}
+	if connected, err := e.enforceHubSide(ctx, subscriptionID, vnet, spokePeering, hubPeering, hubInv, hubCFG); err != nil || !connected {
+		return err
+	}
+
+	// a re-created peering is synced once both sides are connected again
+	if recreated, err := e.checkPeeringNames(ctx, subscriptionID, vnet, spokePeering, hubPeering, hubInv, hubCFG); err != nil || recreated {
+		return err
+	}
+
+	e.checkRemoteGateways(subscriptionID, vnet, spokePeering, hubCFG)
+
+	spokeInSync := spokePeering.Properties.PeeringSyncLevel == nil ||
+		*spokePeering.Properties.PeeringSyncLevel == armnetwork.VirtualNetworkPeeringLevelFullyInSync
+	hubInSync := hubPeering == nil || hubPeering.Properties == nil ||
+		samePrefixes(remoteAddressPrefixes(hubPeering), currentPrefixes)
+
+	if spokeInSync && hubInSync {
+		e.compliance.Record(findings.RulePeeringAddressSpaceSync, subscriptionID, *vnet.ID)
+		return nil
+	}
+
+	peeringName := *spokePeering.Name
+	if hubPeering != nil && hubPeering.Name != nil && !hubInSync {
+		peeringName = *hubPeering.Name
+	}
+	e.findings = append(e.findings, findings.New(findings.RulePeeringAddressSpaceSync, e.config.Rules,
+		subscriptionID, *vnet.ID, fmt.Sprintf("hub peering of VNet %s is not in sync with its address space", *vnet.Name),
+		map[string]string{
+			"peering":  peeringName,
+			"vnet":     *vnet.Name,
+			"prefixes": strings.Join(currentPrefixes, ", "),
+		}))
+
+	// the hub team syncs the hub side if velora may not write it
+	if !hubInSync && hubPeering.Name != nil && !hubCFG.WritesHubSide() {
+		e.findings = append(e.findings, findings.New(findings.RuleHubSidePeering, e.config.Rules,
+			subscriptionID, *vnet.ID, fmt.Sprintf("hub side of the peering of VNet %s with hub %s is out of sync", *vnet.Name, hubCFG.Name),
+			map[string]string{
+				"vnet":    *vnet.Name,
+				"hub":     hubCFG.Name,
+				"state":   "out of sync",
+				"command": hubSyncCommand(hubCFG.VNetID, hubPeering),
+			}))
+	}
+
+	if !e.config.Features.AutoRemediation || !e.guard.WritesAllowed(subscriptionID) ||
+		e.guard.InGracePeriod(ctx, subscriptionID, *vnet.ID) {
+		return nil
+	}
+
+	// sync the hub side first, the spoke side follows its remote address
+	// space. A hub side in sync isn't written.
+	if !hubInSync && hubPeering.Name != nil && hubCFG.WritesHubSide() {
+		// the hub can live in another subscription, clients are scoped to it
+		hubSubscriptionID := azure.SubscriptionIDOf(hubCFG.VNetID)
+		write, err := e.syncChange(hubSubscriptionID, hubPeering)
+		if err != nil {
+			return err
+		}
+		if !e.guard.Planned(write) {
+			err = plan.Write(ctx, e.clientFactory, write)
+			// the hub's peerings changed, other controllers must re-read them
+			e.hubCache.Invalidate(hubCFG.Name)
+			e.inventory.Invalidate(hubSubscriptionID)
+			// the peering was deleted since the hub was read, the hub VNet itself is configured
+			if err != nil && hubPeering.ID != nil &&
+				(e.guard.SkipDisappeared(*hubPeering.ID, err) || e.guard.SkipPolicyDenied(hubSubscriptionID, *hubPeering.ID, err)) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to sync hub peering %s: %w", *hubPeering.Name, err)
+			}
+		}
+	}
+
+	write, err := e.syncChange(subscriptionID, spokePeering)
+	if err != nil || e.guard.Planned(write) {
+		return err
+	}
+	err = plan.Write(ctx, e.clientFactory, write)
+	e.inventory.Invalidate(subscriptionID)
+	if err != nil {
+		if e.guard.SkipDisappeared(*vnet.ID, err) || e.guard.SkipPolicyDenied(subscriptionID, stringValue(spokePeering.ID), err) {
+			return nil
+		}
+		return fmt.Errorf("failed to sync peering %s of VNet %s: %w", *spokePeering.Name, *vnet.Name, err)
+	}
+
+	return nil
+}
+
+// syncChange returns the write syncing the peering with the remote address
+// space, with the etag it was read with.
+func (e *Enforcer) syncChange(subscriptionID string, peering *armnetwork.VirtualNetworkPeering) (plan.Change, error) {
+	if peering.ID == nil {
+		return plan.Change{}, fmt.Errorf("peering %s has no ID", *peering.Name)
+	}
+	body, err := json.Marshal(peering)
+	if err != nil {
+		return plan.Change{}, fmt.Errorf("failed to encode peering %s: %w", *peering.Name, err)
+	}
+
+	return plan.Change{
+		SubscriptionID: subscriptionID,
+		ResourceID:     *peering.ID,
+		APIVersion:     e.clientFactory.APIVersion(azure.ResourceTypePeerings),
+		Query:          "syncRemoteAddressSpace=true",
+		Etag:           stringValue(peering.Etag),
+		Body:           body,
+		Description:    fmt.Sprintf("sync peering %s with the remote address space", *peering.Name),
+		// a sync writes the peering as is, the remote address space changes
+		Before: body,
+	}, nil
+}
+
+// checkRemoteGateways flags hub peerings whose useRemoteGateways setting
+// doesn't match the hub's gateway transit requirement. Report-only.
+func (e *Enforcer) checkRemoteGateways(subscriptionID string, vnet *armnetwork.VirtualNetwork,
+	spokePeering *armnetwork.VirtualNetworkPeering, hubCFG *config.HubVNetConfig) {
+	useRemoteGateways := spokePeering.Properties.UseRemoteGateways != nil && *spokePeering.Properties.UseRemoteGateways
+	if useRemoteGateways == hubCFG.GatewayTransitRequired {
+		e.compliance.Record(findings.RuleRemoteGateways, subscriptionID, *vnet.ID)
+		return
+	}
+
+	e.findings = append(e.findings, findings.New(findings.RuleRemoteGateways, e.config.Rules,
+		subscriptionID, *vnet.ID, fmt.Sprintf("peering %s of VNet %s has useRemoteGateways=%t, hub %s requires %t",
+			*spokePeering.Name, *vnet.Name, useRemoteGateways, hubCFG.Name, hubCFG.GatewayTransitRequired),
+		map[string]string{
+			"peering":  *spokePeering.Name,
+			"expected": fmt.Sprint(hubCFG.GatewayTransitRequired),
+		}))
+}
+
+// remoteVNetID returns the remote VNet ID of the peering, safe for nil values.
+func remoteVNetID(peering *armnetwork.VirtualNetworkPeering) string {
+	if peering == nil || peering.Properties == nil || peering.Properties.RemoteVirtualNetwork == nil ||
+		peering.Properties.RemoteVirtualNetwork.ID == nil {
+		return ""
+	}
+	return *peering.Properties.RemoteVirtualNetwork.ID
+}
+
+// remoteAddressPrefixes returns the remote address space recorded on the peering.
+func remoteAddressPrefixes(peering *armnetwork.VirtualNetworkPeering) []string {
+	if peering.Properties.RemoteAddressSpace == nil {
+		return nil
+	}
+	return stringValues(peering.Properties.RemoteAddressSpace.AddressPrefixes)
+}
+
+// addressPrefixes returns the address space of the VNet.
+func addressPrefixes(vnet *armnetwork.VirtualNetwork) []string {
+	if vnet.Properties.AddressSpace == nil {
+		return nil
+	}
+	return stringValues(vnet.Properties.AddressSpace.AddressPrefixes)
+}
+
+// stringValue returns the value of p, or an empty string if p is nil.
+func stringValue(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
+
+// stringValues returns the non-nil values of a slice of string pointers.
+func stringValues(ptrs []*string) []string {
+	var result []string
+	for _, p := range ptrs {
+		if p != nil {
+			result = append(result, *p)
+		}
+	}
+	return result
+}
+
+// samePrefixes reports whether both lists contain the same prefixes in any order.
+func samePrefixes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
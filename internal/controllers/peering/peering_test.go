@@ -0,0 +1,311 @@
+package peering
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/failover"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/guard"
+	"github.com/akos011221/velora/internal/inventory"
+	"github.com/akos011221/velora/internal/limits"
+	"github.com/akos011221/velora/internal/pause"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// The spoke of the test configuration and both sides of its hub peering,
+// named by the default templates.
+const (
+	spokeVNetID      = "/subscriptions/" + configtest.SubscriptionID + "/resourceGroups/spoke-rg/providers/Microsoft.Network/virtualNetworks/spoke"
+	spokePeeringName = "spoke-to-hub-vnet"
+	hubPeeringName   = "hub-vnet-to-spoke"
+	spokePeeringID   = spokeVNetID + "/virtualNetworkPeerings/" + spokePeeringName
+	hubPeeringID     = configtest.HubVNetID + "/virtualNetworkPeerings/" + hubPeeringName
+)
+
+// spokePrefixes is the address space of the spoke.
+var spokePrefixes = []string{"10.1.0.0/16", "10.2.0.0/16"}
+
+// withPeering enforces the hub peering of the spoke.
+func withPeering(cfg *config.Config) {
+	cfg.Features.PeeringEnforcement = true
+	sub := cfg.Subscriptions[configtest.SubscriptionID]
+	sub.RequireHubPeering = true
+	cfg.Subscriptions[configtest.SubscriptionID] = sub
+}
+
+// newTestARM returns a fake ARM with the hub of the test configuration and
+// the spoke, peered on both sides. The spoke side has the sync level, the
+// hub side records the remote address space.
+func newTestARM(syncLevel armnetwork.VirtualNetworkPeeringLevel, hubRemotePrefixes []string) *azuretest.Server {
+	arm := azuretest.NewServer()
+	hub := azuretest.VNet(configtest.HubVNetID, []string{"10.0.0.0/16"})
+	hub.Properties.VirtualNetworkPeerings = []*armnetwork.VirtualNetworkPeering{
+		azuretest.Peering(hubPeeringName, spokeVNetID, hubRemotePrefixes, armnetwork.VirtualNetworkPeeringLevelFullyInSync),
+	}
+	arm.Put(configtest.HubVNetID, hub)
+	spoke := azuretest.VNet(spokeVNetID, spokePrefixes)
+	spoke.Properties.VirtualNetworkPeerings = []*armnetwork.VirtualNetworkPeering{
+		azuretest.Peering(spokePeeringName, configtest.HubVNetID, []string{"10.0.0.0/16"}, syncLevel),
+	}
+	arm.Put(spokeVNetID, spoke)
+	return arm
+}
+
+// newTestEnforcer returns an enforcer of the configuration against the fake
+// ARM, with the state in a temporary directory.
+func newTestEnforcer(t *testing.T, cfg *config.Config, arm *azuretest.Server) *Enforcer {
+	t.Helper()
+	store, err := state.Open(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory := azure.NewClientFactoryWithTransport(&cfg.Azure, azuretest.Credential{ObjectID: "velora"}, arm)
+	return NewEnforcer(factory, cfg,
+		inventory.NewHubCache(inventory.NewAzureHubFetcher(factory), time.Minute),
+		inventory.NewRunInventory(factory, cfg.Inventory.EffectiveMaxCachedResources()),
+		guard.New(pause.NewManager(store)), failover.NewManager(store), limits.NewGate(cfg))
+}
+
+// rulesOf returns the rule IDs of the findings.
+func rulesOf(all []findings.Finding) []string {
+	var rules []string
+	for _, f := range all {
+		rules = append(rules, f.RuleID)
+	}
+	return rules
+}
+
+// syncWrites returns the IDs of the peerings written with
+// syncRemoteAddressSpace, in order.
+func syncWrites(t *testing.T, arm *azuretest.Server) []string {
+	t.Helper()
+	var ids []string
+	for _, req := range arm.Writes() {
+		if req.Query.Get("syncRemoteAddressSpace") != "true" {
+			t.Errorf("write %s doesn't sync the remote address space", req)
+			continue
+		}
+		ids = append(ids, req.Path)
+	}
+	return ids
+}
+
+func TestEnforceAllAddressSpaceSync(t *testing.T) {
+	tests := []struct {
+		name              string
+		syncLevel         armnetwork.VirtualNetworkPeeringLevel
+		hubRemotePrefixes []string
+		mutate            func(*config.Config)
+		wantRules         []string
+		wantSynced        []string
+	}{
+		{
+			name:              "in sync",
+			syncLevel:         armnetwork.VirtualNetworkPeeringLevelFullyInSync,
+			hubRemotePrefixes: spokePrefixes,
+		},
+		{
+			name:              "hub side records the prefixes in another order",
+			syncLevel:         armnetwork.VirtualNetworkPeeringLevelFullyInSync,
+			hubRemotePrefixes: []string{"10.2.0.0/16", "10.1.0.0/16"},
+		},
+		{
+			name:              "spoke side not in sync",
+			syncLevel:         armnetwork.VirtualNetworkPeeringLevelLocalNotInSync,
+			hubRemotePrefixes: spokePrefixes,
+			wantRules:         []string{findings.RulePeeringAddressSpaceSync.ID},
+			wantSynced:        []string{spokePeeringID},
+		},
+		{
+			name:              "hub side records a stale address space",
+			syncLevel:         armnetwork.VirtualNetworkPeeringLevelFullyInSync,
+			hubRemotePrefixes: spokePrefixes[:1],
+			wantRules:         []string{findings.RulePeeringAddressSpaceSync.ID},
+			wantSynced:        []string{hubPeeringID, spokePeeringID},
+		},
+		{
+			name:              "hub side stale without hub write access",
+			syncLevel:         armnetwork.VirtualNetworkPeeringLevelFullyInSync,
+			hubRemotePrefixes: spokePrefixes[:1],
+			mutate:            func(cfg *config.Config) { cfg.Hubs[0].HubWriteAccess = to.Ptr(false) },
+			wantRules:         []string{findings.RulePeeringAddressSpaceSync.ID, findings.RuleHubSidePeering.ID},
+			wantSynced:        []string{spokePeeringID},
+		},
+		{
+			name:              "not in sync without auto-remediation",
+			syncLevel:         armnetwork.VirtualNetworkPeeringLevelRemoteNotInSync,
+			hubRemotePrefixes: spokePrefixes[:1],
+			mutate:            func(cfg *config.Config) { cfg.Features.AutoRemediation = false },
+			wantRules:         []string{findings.RulePeeringAddressSpaceSync.ID},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mutators := []func(*config.Config){withPeering}
+			if tt.mutate != nil {
+				mutators = append(mutators, tt.mutate)
+			}
+			cfg := configtest.New(t, mutators...)
+			arm := newTestARM(tt.syncLevel, tt.hubRemotePrefixes)
+			e := newTestEnforcer(t, cfg, arm)
+
+			if err := e.EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error = %v", err)
+			}
+			if got := rulesOf(e.Findings()); !reflect.DeepEqual(got, tt.wantRules) {
+				t.Errorf("findings = %v, want %v", got, tt.wantRules)
+			}
+			if got := syncWrites(t, arm); !reflect.DeepEqual(got, tt.wantSynced) {
+				t.Errorf("synced peerings = %v, want %v", got, tt.wantSynced)
+			}
+		})
+	}
+}
+
+// The access velora has to a side of the peering.
+const (
+	writable = "writable"
+	readable = "readable"
+	// unreadable is a hub velora can't read, its side is inferred.
+	unreadable = "unreadable"
+)
+
+func TestEnforceAllHubSideAccess(t *testing.T) {
+	tests := []struct {
+		name string
+		// spokeInitiated is whether the spoke side exists, Initiated until
+		// the hub side does. Otherwise neither side exists.
+		spokeInitiated bool
+		spoke, hub     string
+		wantRules      []string
+		wantWrites     []string
+	}{
+		{
+			name:       "both missing, spoke and hub writable",
+			spoke:      writable,
+			hub:        writable,
+			wantRules:  []string{findings.RuleHubPeeringMissing.ID, findings.RuleHubSidePeering.ID},
+			wantWrites: []string{"PUT " + spokePeeringID, "PUT " + hubPeeringID},
+		},
+		{
+			name:       "both missing, hub readable",
+			spoke:      writable,
+			hub:        readable,
+			wantRules:  []string{findings.RuleHubPeeringMissing.ID, findings.RuleHubSidePeering.ID},
+			wantWrites: []string{"PUT " + spokePeeringID},
+		},
+		{
+			// the hub side isn't checked until the spoke side exists
+			name:      "both missing, spoke readable",
+			spoke:     readable,
+			hub:       writable,
+			wantRules: []string{findings.RuleHubPeeringMissing.ID},
+		},
+		{
+			name:      "both missing, spoke and hub readable",
+			spoke:     readable,
+			hub:       readable,
+			wantRules: []string{findings.RuleHubPeeringMissing.ID},
+		},
+		{
+			name:           "hub side missing, spoke and hub writable",
+			spokeInitiated: true,
+			spoke:          writable,
+			hub:            writable,
+			wantRules:      []string{findings.RuleHubSidePeering.ID},
+			wantWrites:     []string{"PUT " + hubPeeringID},
+		},
+		{
+			name:           "hub side missing, hub readable",
+			spokeInitiated: true,
+			spoke:          writable,
+			hub:            readable,
+			wantRules:      []string{findings.RuleHubSidePeering.ID},
+		},
+		{
+			// the hub side is written with the credentials of the hub
+			name:           "hub side missing, spoke readable",
+			spokeInitiated: true,
+			spoke:          readable,
+			hub:            writable,
+			wantRules:      []string{findings.RuleHubSidePeering.ID},
+			wantWrites:     []string{"PUT " + hubPeeringID},
+		},
+		{
+			name:           "hub side missing, spoke and hub readable",
+			spokeInitiated: true,
+			spoke:          readable,
+			hub:            readable,
+			wantRules:      []string{findings.RuleHubSidePeering.ID},
+		},
+		{
+			name:           "hub side inferred from the spoke side",
+			spokeInitiated: true,
+			spoke:          writable,
+			hub:            unreadable,
+			wantRules:      []string{findings.RuleHubSidePeering.ID},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t, withPeering, func(cfg *config.Config) {
+				cfg.Hubs[0].HubWriteAccess = to.Ptr(tt.hub == writable)
+			})
+			arm := azuretest.NewServer()
+			arm.Put(configtest.HubVNetID, azuretest.VNet(configtest.HubVNetID, []string{"10.0.0.0/16"}))
+			spoke := azuretest.VNet(spokeVNetID, spokePrefixes)
+			if tt.spokeInitiated {
+				peering := azuretest.Peering(spokePeeringName, configtest.HubVNetID, []string{"10.0.0.0/16"}, armnetwork.VirtualNetworkPeeringLevelFullyInSync)
+				peering.Properties.PeeringState = to.Ptr(armnetwork.VirtualNetworkPeeringStateInitiated)
+				spoke.Properties.VirtualNetworkPeerings = []*armnetwork.VirtualNetworkPeering{peering}
+			}
+			arm.Put(spokeVNetID, spoke)
+			if tt.hub == unreadable {
+				arm.Handle(http.MethodGet, configtest.HubVNetID, func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("x-ms-error-code", "AuthorizationFailed")
+					w.WriteHeader(http.StatusForbidden)
+					_, _ = w.Write([]byte(`{"error":{"code":"AuthorizationFailed","message":"no access to the hub"}}`))
+				})
+			}
+			e := newTestEnforcer(t, cfg, arm)
+			if tt.spoke == readable {
+				e.guard.SetObserveOnly(configtest.SubscriptionID, "spoke only readable")
+			}
+
+			if err := e.EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error = %v", err)
+			}
+			if got := rulesOf(e.Findings()); !reflect.DeepEqual(got, tt.wantRules) {
+				t.Errorf("findings = %v, want %v", got, tt.wantRules)
+			}
+			var writes []string
+			for _, req := range arm.Writes() {
+				writes = append(writes, req.String())
+			}
+			if !reflect.DeepEqual(writes, tt.wantWrites) {
+				t.Errorf("writes = %v, want %v", writes, tt.wantWrites)
+			}
+
+			// the hub team gets the command creating the hub side
+			for _, f := range e.Findings() {
+				if f.RuleID == findings.RuleHubSidePeering.ID && !strings.Contains(f.Remediation, "--method put --url 'https://management.azure.com"+hubPeeringID) {
+					t.Errorf("hub side finding remediation = %q, want the command creating %s", f.Remediation, hubPeeringID)
+				}
+			}
+		})
+	}
+}
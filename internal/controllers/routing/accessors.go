@@ -0,0 +1,98 @@
+package routing
+
+import (
+	"net"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// unknownID is used in findings for resources returned without an ID.
+const unknownID = "<unknown>"
+
+// stringValue returns the value of p, or an empty string if p is nil.
+func stringValue(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
+
+// idOrUnknown returns the ID, or a placeholder if it's empty.
+func idOrUnknown(id *string) string {
+	if v := stringValue(id); v != "" {
+		return v
+	}
+	return unknownID
+}
+
+// vnetID returns the ID of the VNet, safe for nil values.
+func vnetID(vnet *armnetwork.VirtualNetwork) string {
+	if vnet == nil {
+		return unknownID
+	}
+	return idOrUnknown(vnet.ID)
+}
+
+// subnetID returns the ID of the subnet, safe for nil values.
+func subnetID(subnet *armnetwork.Subnet) string {
+	if subnet == nil {
+		return unknownID
+	}
+	return idOrUnknown(subnet.ID)
+}
+
+// routeID returns the ID of the route, safe for nil values.
+func routeID(route *armnetwork.Route) string {
+	if route == nil {
+		return unknownID
+	}
+	return idOrUnknown(route.ID)
+}
+
+// subnetPrefixes returns the address prefixes of the subnet, AddressPrefix
+// first for subnets that also have AddressPrefixes.
+func subnetPrefixes(subnet *armnetwork.Subnet) []string {
+	if subnet == nil || subnet.Properties == nil {
+		return nil
+	}
+
+	var prefixes []string
+	for _, prefix := range append([]*string{subnet.Properties.AddressPrefix}, subnet.Properties.AddressPrefixes...) {
+		if v := stringValue(prefix); v != "" {
+			prefixes = append(prefixes, v)
+		}
+	}
+	return prefixes
+}
+
+// overlaps returns the first of the address prefixes sharing addresses with
+// prefix, empty if none does.
+func overlaps(prefix string, prefixes []string) string {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return ""
+	}
+	for _, p := range prefixes {
+		_, other, err := net.ParseCIDR(p)
+		if err == nil && (network.Contains(other.IP) || other.Contains(network.IP)) {
+			return p
+		}
+	}
+	return ""
+}
+
+// containsIP reports whether any of the address prefixes contains the IP.
+func containsIP(prefixes []string, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, prefix := range prefixes {
+		_, network, err := net.ParseCIDR(prefix)
+		if err == nil && network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package routing
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// classifySubnets matches the subnets of the spokes with the subnet classes
+// of the hub. Subnets matching several classes of the same priority are
+// reported and left alone.
+func (e *evaluation) classifySubnets() {
+	if len(e.policy.Hub.SubnetClasses) == 0 {
+		return
+	}
+	for _, vnet := range e.inventory.VNets {
+		if e.policy.isHub(vnet.ID) {
+			continue
+		}
+		for _, subnet := range vnet.Subnets {
+			match, err := e.policy.Hub.ClassifySubnet(vnet.Tags, subnet.Name)
+			if err != nil {
+				e.classConflicts[strings.ToLower(subnet.ID)] = true
+				e.note("WARNING: skipped subnet %s: conflicting subnet classes", subnet.Name)
+				e.result.Findings = append(e.result.Findings, findings.New(findings.RuleSubnetClassConflict, e.policy.Rules,
+					e.inventory.SubscriptionID, subnet.ID, err.Error(),
+					map[string]string{
+						"subnet": subnet.Name,
+						"hub":    e.policy.Hub.Name,
+						"reason": err.Error(),
+					}))
+				continue
+			}
+			if match != nil {
+				e.classes[strings.ToLower(subnet.ID)] = match
+			}
+		}
+	}
+}
+
+// class returns the subnet class the subnet matched, nil if none.
+func (e *evaluation) class(subnet Subnet) *config.SubnetClassMatch {
+	return e.classes[strings.ToLower(subnet.ID)]
+}
+
+// classConflict reports whether the subnet matched conflicting classes.
+func (e *evaluation) classConflict(subnet Subnet) bool {
+	return e.classConflicts[strings.ToLower(subnet.ID)]
+}
+
+// nvaRouting reports whether the default route is enforced on the subnet,
+// its class overriding the subscription.
+func (e *evaluation) nvaRouting(subnet Subnet) bool {
+	if match := e.class(subnet); match != nil && match.Class.DefaultRoute != nil {
+		return *match.Class.DefaultRoute
+	}
+	return e.policy.NVARouting
+}
+
+// isolated reports whether the subnet is isolated from the other subnets of
+// its VNet, its class overriding the subscription.
+func (e *evaluation) isolated(subnet Subnet) bool {
+	if match := e.class(subnet); match != nil && match.Class.SubnetIsolation != nil {
+		return *match.Class.SubnetIsolation
+	}
+	return e.policy.SubnetIsolation
+}
+
+// classesEnable reports whether a subnet class of the hub turns the setting
+// on, so it is evaluated even if the subscription doesn't require it.
+func (e *evaluation) classesEnable(setting func(class config.SubnetClassConfig) *bool) bool {
+	for _, class := range e.policy.Hub.SubnetClasses {
+		if enabled := setting(class); enabled != nil && *enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneDefaultRoutes deletes the default routes velora manages from the
+// route table of a subnet whose class excludes the default route. Each
+// route table is pruned once.
+func (e *evaluation) pruneDefaultRoutes(subnet Subnet, match *config.SubnetClassMatch) error {
+	key := strings.ToLower(subnet.RouteTableID)
+	if key == "" || e.prunedDefault[key] || e.excluded(key) {
+		return nil
+	}
+	e.prunedDefault[key] = true
+
+	target, ok := e.target(subnet)
+	if !ok {
+		return nil
+	}
+	hub := e.policy.Hub
+	for _, prefix := range e.policy.defaultRoutePrefixes() {
+		routeName, err := hub.DefaultRouteNameFor(prefix)
+		if err != nil {
+			return err
+		}
+		for _, route := range e.inventory.RouteTables[key].Routes {
+			if !strings.EqualFold(route.AddressPrefix, prefix) || !e.owns(key, route.Name, routeName) {
+				continue
+			}
+			e.result.Findings = append(e.result.Findings, findings.New(findings.RuleSubnetClassDefaultRoute, e.policy.Rules,
+				target.subscriptionID, subnet.ID, fmt.Sprintf("route %s for %s in route table %s sends subnet %s to the NVA, but its subnet class %s excludes the default route",
+					route.Name, route.AddressPrefix, target.rtName, subnet.Name, match.Name),
+				map[string]string{
+					"route":      route.Name,
+					"prefix":     route.AddressPrefix,
+					"routeTable": target.rtName,
+					"subnet":     subnet.Name,
+					"class":      match.Name,
+				}))
+			e.result.Changes = append(e.result.Changes, RouteChange{
+				SubscriptionID: target.rtSubscriptionID,
+				ResourceGroup:  target.rtResourceGroup,
+				RouteTable:     target.rtName,
+				Name:           route.Name,
+				Etag:           route.Etag,
+				Prefix:         route.AddressPrefix,
+				Delete:         true,
+				Before:         &route,
+			})
+		}
+	}
+	return nil
+}
+
+// evaluateBGPPropagation checks the route tables of the VNet's subnets have
+// the BGP route propagation their class requires. It only reports, the
+// setting applies to every subnet of the route table. Subnets without a
+// route table propagate BGP routes.
+func (e *evaluation) evaluateBGPPropagation(vnet VNet) {
+	for _, subnet := range vnet.Subnets {
+		match := e.class(subnet)
+		if match == nil || match.Class.BGPPropagation == "" {
+			continue
+		}
+		want := match.Class.BGPPropagation
+		have := config.BGPPropagationEnabled
+		rtName := "none"
+		if subnet.RouteTableID != "" {
+			rt, ok := e.inventory.RouteTables[strings.ToLower(subnet.RouteTableID)]
+			// the snapshot doesn't record the setting
+			if !ok || rt.DisableBGPRoutePropagation == nil {
+				continue
+			}
+			if *rt.DisableBGPRoutePropagation {
+				have = config.BGPPropagationDisabled
+			}
+			rtName = azure.ExtractResourceIDParts(subnet.RouteTableID)["routeTables"]
+		}
+
+		if have == want {
+			e.result.Compliant = append(e.result.Compliant, CompliantResource{
+				Rule:           findings.RuleBGPPropagation,
+				SubscriptionID: e.inventory.SubscriptionID,
+				ResourceID:     subnet.ID,
+			})
+			continue
+		}
+		e.result.Findings = append(e.result.Findings, findings.New(findings.RuleBGPPropagation, e.policy.Rules,
+			e.inventory.SubscriptionID, subnet.ID, fmt.Sprintf("subnet %s of class %s requires BGP route propagation %s, route table %s has it %s",
+				subnet.Name, match.Name, want, rtName, have),
+			map[string]string{
+				"subnet":     subnet.Name,
+				"class":      match.Name,
+				"want":       want,
+				"have":       have,
+				"routeTable": rtName,
+			}))
+	}
+}
@@ -0,0 +1,72 @@
+package routing
+
+import (
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// Subnet classes with documented restrictions on their routes.
+const (
+	SubnetClassAppGateway    = "application-gateway"
+	SubnetClassAPIManagement = "api-management"
+)
+
+// CompatProfile is the route set a subnet class keeps working with while its
+// default route points to the NVA, as documented by Azure: the service tags
+// of the service's control plane are routed to the Internet.
+type CompatProfile struct {
+	// Name labels the subnets routed under the profile in reports.
+	Name string
+	// InternetServiceTags are the service tags routed to the Internet.
+	InternetServiceTags []string
+}
+
+// CompatProfiles are the compatibility profiles by subnet class.
+var CompatProfiles = map[string]CompatProfile{
+	SubnetClassAppGateway: {
+		Name:                "appgw-v2",
+		InternetServiceTags: []string{"GatewayManager"},
+	},
+	SubnetClassAPIManagement: {
+		Name:                "apim",
+		InternetServiceTags: []string{"ApiManagement"},
+	},
+}
+
+// subnetClass returns the class of the subnet, detected from its delegations
+// and the resources attached to it, empty for plain subnets.
+func subnetClass(subnet *armnetwork.Subnet) string {
+	props := subnet.Properties
+	if len(props.ApplicationGatewayIPConfigurations) > 0 {
+		return SubnetClassAppGateway
+	}
+	for _, delegation := range props.Delegations {
+		if delegation == nil || delegation.Properties == nil {
+			continue
+		}
+		switch strings.ToLower(stringValue(delegation.Properties.ServiceName)) {
+		case "microsoft.network/applicationgateways":
+			return SubnetClassAppGateway
+		case "microsoft.apimanagement/service":
+			return SubnetClassAPIManagement
+		}
+	}
+	for _, link := range props.ServiceAssociationLinks {
+		if link != nil && link.Properties != nil && strings.EqualFold(stringValue(link.Properties.LinkedResourceType), "Microsoft.ApiManagement/service") {
+			return SubnetClassAPIManagement
+		}
+	}
+	for _, link := range props.ResourceNavigationLinks {
+		if link != nil && link.Properties != nil && strings.EqualFold(stringValue(link.Properties.LinkedResourceType), "Microsoft.ApiManagement/service") {
+			return SubnetClassAPIManagement
+		}
+	}
+	// injected API Management instances show as IP configurations of the subnet
+	for _, ipConfig := range props.IPConfigurations {
+		if ipConfig != nil && strings.Contains(strings.ToLower(stringValue(ipConfig.ID)), "/providers/microsoft.apimanagement/") {
+			return SubnetClassAPIManagement
+		}
+	}
+	return ""
+}
@@ -0,0 +1,179 @@
+package routing
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// delegatedTo returns a subnet of the spoke delegated to the service.
+func delegatedTo(name, serviceName string) *armnetwork.Subnet {
+	subnet := azuretest.Subnet(name, "10.1.2.0/24", spokeRouteTable)
+	subnet.Properties.Delegations = []*armnetwork.Delegation{{Properties: &armnetwork.ServiceDelegationPropertiesFormat{ServiceName: to.Ptr(serviceName)}}}
+	return subnet
+}
+
+// appGatewaySubnet returns a subnet of the spoke holding an Application
+// Gateway v2.
+func appGatewaySubnet() *armnetwork.Subnet {
+	subnet := azuretest.Subnet("appgw", "10.1.2.0/24", spokeRouteTable)
+	subnet.Properties.ApplicationGatewayIPConfigurations = []*armnetwork.ApplicationGatewayIPConfiguration{
+		{ID: to.Ptr(spokeRG + "/providers/Microsoft.Network/applicationGateways/appgw/gatewayIPConfigurations/ip")},
+	}
+	return subnet
+}
+
+func TestSubnetClass(t *testing.T) {
+	linked := func(links func(subnet *armnetwork.Subnet)) *armnetwork.Subnet {
+		subnet := azuretest.Subnet("apim", "10.1.2.0/24", "")
+		links(subnet)
+		return subnet
+	}
+	tests := []struct {
+		name   string
+		subnet *armnetwork.Subnet
+		want   string
+	}{
+		{name: "plain subnet", subnet: appSubnet()},
+		{name: "application gateway", subnet: appGatewaySubnet(), want: SubnetClassAppGateway},
+		{name: "application gateway delegation", subnet: delegatedTo("appgw", "Microsoft.Network/applicationGateways"), want: SubnetClassAppGateway},
+		{name: "API Management delegation", subnet: delegatedTo("apim", "microsoft.apimanagement/service"), want: SubnetClassAPIManagement},
+		{name: "other delegation", subnet: delegatedTo("web", "Microsoft.Web/serverFarms")},
+		{
+			name: "API Management service association link",
+			subnet: linked(func(subnet *armnetwork.Subnet) {
+				subnet.Properties.ServiceAssociationLinks = []*armnetwork.ServiceAssociationLink{
+					{Properties: &armnetwork.ServiceAssociationLinkPropertiesFormat{LinkedResourceType: to.Ptr("Microsoft.ApiManagement/service")}},
+				}
+			}),
+			want: SubnetClassAPIManagement,
+		},
+		{
+			name: "API Management navigation link",
+			subnet: linked(func(subnet *armnetwork.Subnet) {
+				subnet.Properties.ResourceNavigationLinks = []*armnetwork.ResourceNavigationLink{
+					{Properties: &armnetwork.ResourceNavigationLinkFormat{LinkedResourceType: to.Ptr("Microsoft.ApiManagement/service")}},
+				}
+			}),
+			want: SubnetClassAPIManagement,
+		},
+		{
+			name: "injected API Management",
+			subnet: linked(func(subnet *armnetwork.Subnet) {
+				subnet.Properties.IPConfigurations = []*armnetwork.IPConfiguration{
+					{ID: to.Ptr(spokeRG + "/providers/Microsoft.ApiManagement/service/apim/ipConfigurations/ip")},
+				}
+			}),
+			want: SubnetClassAPIManagement,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := subnetClass(tt.subnet); got != tt.want {
+				t.Errorf("subnetClass() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompatProfiles(t *testing.T) {
+	want := map[string]string{
+		SubnetClassAppGateway:    "appgw-v2 GatewayManager",
+		SubnetClassAPIManagement: "apim ApiManagement",
+	}
+	if len(CompatProfiles) != len(want) {
+		t.Errorf("CompatProfiles has %d classes, want %d", len(CompatProfiles), len(want))
+	}
+	for class, profile := range want {
+		got := CompatProfiles[class].Name + " " + strings.Join(CompatProfiles[class].InternetServiceTags, ",")
+		if got != profile {
+			t.Errorf("CompatProfiles[%s] = %s, want %s", class, got, profile)
+		}
+	}
+}
+
+func TestEnforceAllCompatProfiles(t *testing.T) {
+	appgwID := spokeVNetID + "/subnets/appgw"
+	apimID := spokeVNetID + "/subnets/apim"
+	tests := []struct {
+		name         string
+		compatRoutes bool
+		subnet       *armnetwork.Subnet
+		routes       []*armnetwork.Route
+		want         []string
+	}{
+		{
+			name:   "application gateway exempt",
+			subnet: appGatewaySubnet(),
+			want:   []string{findings.RuleSubnetClassExempt.ID + " " + appgwID},
+		},
+		{
+			name:   "API Management exempt",
+			subnet: delegatedTo("apim", "Microsoft.ApiManagement/service"),
+			want:   []string{findings.RuleSubnetClassExempt.ID + " " + apimID},
+		},
+		{
+			name:         "application gateway with compatibility routes",
+			compatRoutes: true,
+			subnet:       appGatewaySubnet(),
+			want: []string{
+				"PUT " + spokeRouteTable + "/routes/DefaultRoute-To-NVA 0.0.0.0/0 VirtualAppliance " + configtest.NVANextHop,
+				"PUT " + spokeRouteTable + "/routes/DefaultRoute-To-NVA-gatewaymanager GatewayManager Internet",
+				findings.RuleCompatProfile.ID + " " + appgwID,
+				findings.RuleDefaultRoute.ID + " " + appgwID,
+				findings.RuleDefaultRoute.ID + " " + appgwID,
+			},
+		},
+		{
+			name:         "API Management with compatibility routes",
+			compatRoutes: true,
+			subnet:       delegatedTo("apim", "Microsoft.ApiManagement/service"),
+			routes:       []*armnetwork.Route{defaultRouteTo(configtest.NVANextHop)},
+			want: []string{
+				"PUT " + spokeRouteTable + "/routes/DefaultRoute-To-NVA-apimanagement ApiManagement Internet",
+				findings.RuleCompatProfile.ID + " " + apimID,
+				findings.RuleDefaultRoute.ID + " " + apimID,
+			},
+		},
+		{
+			// the service tag of the existing route is matched regardless of case
+			name:         "compatibility routes in place",
+			compatRoutes: true,
+			subnet:       appGatewaySubnet(),
+			routes: []*armnetwork.Route{
+				defaultRouteTo(configtest.NVANextHop),
+				azuretest.Route("DefaultRoute-To-NVA-gatewaymanager", "gatewaymanager", "Internet"),
+			},
+			want: []string{findings.RuleCompatProfile.ID + " " + appgwID},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arm := newTestARM()
+			withSpoke(arm, []*armnetwork.Subnet{tt.subnet}, tt.routes...)
+			cfg := configtest.New(t, func(cfg *config.Config) {
+				sub := cfg.Subscriptions[configtest.SubscriptionID]
+				sub.EnforceWithCompatRoutes = tt.compatRoutes
+				cfg.Subscriptions[configtest.SubscriptionID] = sub
+			})
+			enforcer := newTestEnforcer(t, cfg, arm)
+
+			if err := enforcer.EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error = %v", err)
+			}
+			if got := outcome(t, arm, enforcer.Findings()); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("EnforceAll() outcome:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
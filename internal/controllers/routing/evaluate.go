@@ -0,0 +1,813 @@
+package routing
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// Policy is the routing policy a subscription is evaluated against.
+type Policy struct {
+	Hub *config.HubVNetConfig
+	// NVARouting requires a default route to the hub NVA on every subnet.
+	NVARouting bool
+	// DefaultRoutePrefixes are the prefixes that make up the default route,
+	// all of them must point to the NVA. nil means config.DefaultRoutePrefix.
+	DefaultRoutePrefixes []string
+	// OnPremOverrides are the on-prem prefixes routed to the NVA along with
+	// the default route. Managed override routes of other prefixes are deleted.
+	OnPremOverrides []string
+	// HubPrefixes are the address prefixes of the hub VNet. On-prem
+	// overrides overlapping any of them are left out, they would send
+	// traffic to the hub through the NVA.
+	HubPrefixes []string
+	// SubnetIsolation requires traffic between the subnets of a VNet to go
+	// through the hub NVA.
+	SubnetIsolation bool
+	// ForbiddenNextHopTypes are the next hop types spoke routes must not
+	// use, e.g. VirtualNetworkGateway to bypass the NVA for on-prem prefixes.
+	ForbiddenNextHopTypes []string
+	// ForbiddenNextHopAction is the config.ForbiddenNextHop* remediation
+	// of routes with a forbidden next hop.
+	ForbiddenNextHopAction string
+	// CompatRoutes routes the subnets of classes with a compatibility
+	// profile through the NVA with the profile's routes. Otherwise they're
+	// exempt from the default route.
+	CompatRoutes bool
+	// Rules is the per-rule configuration of the findings.
+	Rules map[string]config.RuleConfig
+	// IsHubVNet reports whether a VNet is a hub, hubs are never evaluated.
+	// nil means no VNet is a hub.
+	IsHubVNet func(vnetID string) bool
+	// Writable reports whether route tables in a subscription may be
+	// changed. nil means all subscriptions are writable.
+	Writable func(subscriptionID string) bool
+	// ServiceRouteMatchers detect the route tables and routes services like
+	// AKS manage, their routes are never modified. nil detects none.
+	ServiceRouteMatchers []config.ServiceRouteMatcher
+	// ServiceRouteTables is the config.ServiceRouteTables* mode of the
+	// route tables services manage, empty means coexist.
+	ServiceRouteTables string
+}
+
+// isHub reports whether the VNet is a hub.
+func (p *Policy) isHub(vnetID string) bool {
+	return p.IsHubVNet != nil && p.IsHubVNet(vnetID)
+}
+
+// defaultRoutePrefixes returns the prefixes of the default route.
+func (p *Policy) defaultRoutePrefixes() []string {
+	if len(p.DefaultRoutePrefixes) == 0 {
+		return []string{config.DefaultRoutePrefix}
+	}
+	return p.DefaultRoutePrefixes
+}
+
+// writable reports whether route tables in the subscription may be changed.
+func (p *Policy) writable(subscriptionID string) bool {
+	return p.Writable == nil || p.Writable(subscriptionID)
+}
+
+// Inventory is the state of a subscription routing is evaluated against.
+type Inventory struct {
+	SubscriptionID string
+	VNets          []VNet
+	// RouteTables are the route tables referenced by the subnets, by
+	// lower-case ID. A referenced route table that is missing has no routes.
+	RouteTables map[string]RouteTable
+}
+
+// VNet is a virtual network with its subnets.
+type VNet struct {
+	ID      string
+	Name    string
+	Subnets []Subnet
+	// Tags are the tags of the VNet, they classify its subnets.
+	Tags map[string]string
+}
+
+// Subnet is a subnet with its address prefixes and route table.
+type Subnet struct {
+	ID       string
+	Name     string
+	Prefixes []string
+	// RouteTableID is empty if the subnet has no route table.
+	RouteTableID string
+	// Class is the SubnetClass* of subnets with a compatibility profile,
+	// empty for plain subnets.
+	Class string
+}
+
+// prefix returns the first address prefix of the subnet.
+func (s *Subnet) prefix() string {
+	if len(s.Prefixes) == 0 {
+		return ""
+	}
+	return s.Prefixes[0]
+}
+
+// RouteTable is a route table with its routes.
+type RouteTable struct {
+	ID     string
+	Routes []Route
+	// Tags are the tags of the route table, they only detect service
+	// route tables.
+	Tags map[string]string
+	// DisableBGPRoutePropagation is nil if it isn't known.
+	DisableBGPRoutePropagation *bool
+}
+
+// Route is a route of a route table.
+type Route struct {
+	Name             string
+	Etag             string
+	AddressPrefix    string
+	NextHopType      string
+	NextHopIPAddress string
+}
+
+// RouteChange is a route to create or update so it points to the NVA, or
+// to delete.
+type RouteChange struct {
+	// SubscriptionID is the subscription of the route table.
+	SubscriptionID string
+	ResourceGroup  string
+	RouteTable     string
+	Name           string
+	// Etag is the etag of the route being updated, empty for new routes.
+	Etag    string
+	Prefix  string
+	NextHop string
+	// NextHopType is the next hop type of the route, empty for the NVA.
+	NextHopType string
+	// Delete deletes the route, it is no longer wanted.
+	Delete bool
+	// Before is the route as listed, nil for new routes.
+	Before *Route
+}
+
+// ID returns the ID of the route.
+func (c RouteChange) ID() string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/routeTables/%s/routes/%s",
+		c.SubscriptionID, c.ResourceGroup, c.RouteTable, c.Name)
+}
+
+// Description describes the change for plans.
+func (c RouteChange) Description() string {
+	if c.Delete {
+		return fmt.Sprintf("delete route %s %s from route table %s", c.Name, c.Prefix, c.RouteTable)
+	}
+	if c.NextHopType != "" {
+		return fmt.Sprintf("route %s %s -> %s in route table %s", c.Name, c.Prefix, c.NextHopType, c.RouteTable)
+	}
+	return fmt.Sprintf("route %s %s -> %s in route table %s", c.Name, c.Prefix, c.NextHop, c.RouteTable)
+}
+
+// CompliantResource is a resource that passed a rule.
+type CompliantResource struct {
+	Rule           findings.Rule
+	SubscriptionID string
+	ResourceID     string
+}
+
+// ManagedRoute is a route velora owns that was evaluated.
+type ManagedRoute struct {
+	SubscriptionID string
+	ID             string
+}
+
+// ChangeSet is the outcome of evaluating a policy against an inventory.
+type ChangeSet struct {
+	Findings  []findings.Finding
+	Compliant []CompliantResource
+	Changes   []RouteChange
+	Managed   []ManagedRoute
+	// Notes are the informational messages of the evaluation, like skipped resources.
+	Notes []string
+}
+
+// routeTarget identifies the route velora wants in a route table.
+type routeTarget struct {
+	subscriptionID string
+	subnetID       string
+	// rtSubscriptionID is the subscription of the route table, it can differ
+	// from the subnet's in shared services setups.
+	rtSubscriptionID string
+	rtResourceGroup  string
+	rtName           string
+	routeName        string
+	prefix           string
+	// nextHopType is the next hop type the route must have, empty for the NVA.
+	nextHopType string
+	rule        findings.Rule
+	findingData map[string]string
+}
+
+// routeID returns the ID of the route with the given name in the target's route table.
+func (t routeTarget) routeID(name string) string {
+	return RouteChange{SubscriptionID: t.rtSubscriptionID, ResourceGroup: t.rtResourceGroup, RouteTable: t.rtName, Name: name}.ID()
+}
+
+// routeState is the observed state of the route for a prefix in a route table.
+type routeState struct {
+	name    string
+	etag    string
+	exists  bool
+	correct bool
+	// route is the route for the prefix, if it exists.
+	route Route
+}
+
+// evaluation holds the state of a single Evaluate call.
+type evaluation struct {
+	policy    Policy
+	inventory Inventory
+	result    ChangeSet
+	// pruned are the route tables whose stale routes were evaluated, by
+	// lower-case ID, and prunedDefault those whose default routes were.
+	pruned        map[string]bool
+	prunedDefault map[string]bool
+	// conflicted are the route tables shared by subnets with conflicting
+	// policies, by lower-case ID. They aren't modified.
+	conflicted map[string]bool
+	// enforcedPrefixes are the prefixes the NVA routing rules enforce, their
+	// routes are remediated by those rules.
+	enforcedPrefixes []string
+	// services are the services managing the route tables, empty for
+	// route tables no service manages, by lower-case ID.
+	services map[string]string
+	// classes are the subnet classes the subnets matched, and
+	// classConflicts the subnets matching several, by lower-case subnet ID.
+	classes        map[string]*config.SubnetClassMatch
+	classConflicts map[string]bool
+}
+
+// Evaluate evaluates the routing policy against the inventory and returns
+// the findings and the route changes enforcement would make. It does no I/O.
+func Evaluate(policy Policy, inventory Inventory) (ChangeSet, error) {
+	e := &evaluation{policy: policy, inventory: inventory, pruned: make(map[string]bool), prunedDefault: make(map[string]bool),
+		conflicted: make(map[string]bool), services: make(map[string]string),
+		classes: make(map[string]*config.SubnetClassMatch), classConflicts: make(map[string]bool)}
+	if policy.Hub == nil {
+		return e.result, fmt.Errorf("policy has no hub")
+	}
+	e.detectServiceRouteTables()
+	e.classifySubnets()
+	nvaRouting := policy.NVARouting || e.classesEnable(func(c config.SubnetClassConfig) *bool { return c.DefaultRoute })
+	isolation := policy.SubnetIsolation || e.classesEnable(func(c config.SubnetClassConfig) *bool { return c.SubnetIsolation })
+	if nvaRouting || isolation {
+		e.checkSharedRouteTables()
+	}
+
+	for _, vnet := range inventory.VNets {
+		// forcing routes onto a hub's subnets loops traffic through the NVA
+		if policy.isHub(vnet.ID) {
+			e.note("skipped VNet %s: hub", vnet.ID)
+			continue
+		}
+		if nvaRouting {
+			if err := e.evaluateNVARouting(vnet); err != nil {
+				return e.result, err
+			}
+		}
+		e.evaluateBGPPropagation(vnet)
+	}
+	for _, vnet := range inventory.VNets {
+		if policy.isHub(vnet.ID) {
+			continue
+		}
+		if isolation {
+			if err := e.evaluateSubnetIsolation(vnet); err != nil {
+				return e.result, err
+			}
+		}
+	}
+	if len(policy.ForbiddenNextHopTypes) > 0 {
+		if policy.NVARouting {
+			e.enforcedPrefixes = append(slices.Clone(policy.defaultRoutePrefixes()), policy.OnPremOverrides...)
+		}
+		checked := make(map[string]bool)
+		for _, vnet := range inventory.VNets {
+			// hub route tables are exempt
+			if policy.isHub(vnet.ID) {
+				continue
+			}
+			e.evaluateForbiddenNextHops(vnet, checked)
+		}
+	}
+	e.dropServiceChanges()
+	e.result.Changes = dedupeChanges(e.result.Changes)
+	e.result.Managed = dedupeManaged(e.result.Managed)
+	return e.result, nil
+}
+
+// evaluateForbiddenNextHops flags the routes of the VNet's route tables with
+// a forbidden next hop. Each route table is checked once, routes velora
+// manages and routes of prefixes the NVA routing rules enforce are left to
+// those rules.
+func (e *evaluation) evaluateForbiddenNextHops(vnet VNet, checked map[string]bool) {
+	hub := e.policy.Hub
+	for _, subnet := range vnet.Subnets {
+		key := strings.ToLower(subnet.RouteTableID)
+		routeTable, ok := e.inventory.RouteTables[key]
+		if !ok || checked[key] || e.excluded(key) {
+			continue
+		}
+		checked[key] = true
+
+		target, ok := e.target(subnet)
+		if !ok {
+			continue
+		}
+		for _, route := range routeTable.Routes {
+			if !slices.Contains(e.policy.ForbiddenNextHopTypes, route.NextHopType) ||
+				e.owns(key, route.Name, "") || slices.Contains(e.enforcedPrefixes, route.AddressPrefix) {
+				continue
+			}
+			// the service reconciles its own routes, whatever their next hop
+			if service := e.serviceOfRoute(key, route.Name); service != "" {
+				e.note("skipped route %s in route table %s: managed by %s", route.Name, target.rtName, service)
+				continue
+			}
+
+			action := e.policy.ForbiddenNextHopAction
+			message := fmt.Sprintf("route %s for %s in route table %s has next hop %s, bypassing the NVA",
+				route.Name, route.AddressPrefix, target.rtName, route.NextHopType)
+			e.result.Findings = append(e.result.Findings, findings.New(findings.RuleForbiddenNextHop, e.policy.Rules,
+				target.subscriptionID, target.routeID(route.Name), message,
+				map[string]string{
+					"route":       route.Name,
+					"prefix":      route.AddressPrefix,
+					"nextHopType": route.NextHopType,
+					"routeTable":  target.rtName,
+					"action":      action,
+				}))
+
+			change := RouteChange{
+				SubscriptionID: target.rtSubscriptionID,
+				ResourceGroup:  target.rtResourceGroup,
+				RouteTable:     target.rtName,
+				Name:           route.Name,
+				Etag:           route.Etag,
+				Prefix:         route.AddressPrefix,
+				Before:         &route,
+			}
+			switch action {
+			case config.ForbiddenNextHopDelete:
+				change.Delete = true
+			case config.ForbiddenNextHopRewrite:
+				if hub.NVANextHop == "" {
+					e.note("WARNING: not rewriting route %s in route table %s: no NVA IP defined for hub %s", route.Name, target.rtName, hub.Name)
+					continue
+				}
+				change.NextHop = hub.NVANextHop
+			default:
+				continue
+			}
+			e.result.Changes = append(e.result.Changes, change)
+		}
+	}
+}
+
+// evaluateNVARouting checks that all subnets of the VNet route every prefix
+// of the default route, and every overridden on-prem prefix, to the NVA. A
+// subnet is compliant once all are.
+func (e *evaluation) evaluateNVARouting(vnet VNet) error {
+	hub := e.policy.Hub
+	overrides := e.onPremOverrides(vnet)
+	for _, subnet := range vnet.Subnets {
+		if e.classConflict(subnet) {
+			continue
+		}
+		if !e.nvaRouting(subnet) {
+			// a class may only exclude some subnets of a routed subscription
+			if match := e.class(subnet); match != nil && e.policy.NVARouting {
+				e.note("skipped subnet %s: subnet class %s excludes the default route", subnet.Name, match.Name)
+				if err := e.pruneDefaultRoutes(subnet, match); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		profile, special := CompatProfiles[subnet.Class]
+		if special && !e.policy.CompatRoutes {
+			e.note("skipped subnet %s: %s subnet, exempt from the default route", subnet.Name, subnet.Class)
+			e.result.Findings = append(e.result.Findings, findings.New(findings.RuleSubnetClassExempt, e.policy.Rules,
+				e.inventory.SubscriptionID, subnet.ID, fmt.Sprintf("subnet %s is an %s subnet, exempt from the default route to the NVA", subnet.Name, subnet.Class),
+				map[string]string{
+					"subnet":  subnet.Name,
+					"class":   subnet.Class,
+					"profile": profile.Name,
+				}))
+			continue
+		}
+		// some subnets can't have route tables, like "GatewaySubnets"
+		// but for now it is assumed that spoke VNets don't have that.
+		if subnet.RouteTableID == "" {
+			// TODO: handle cases when there's no route table,
+			// as it shouldn't be allowed
+			e.note("WARNING: No route table found for subnet: %s", subnet.Name)
+			continue
+		}
+		if e.excluded(subnet.RouteTableID) {
+			continue
+		}
+		// the NVA's own subnet must never route through the NVA
+		if containsIP(subnet.Prefixes, hub.NVANextHop) {
+			e.note("skipped subnet %s: contains the NVA %s", subnet.Name, hub.NVANextHop)
+			continue
+		}
+
+		base, ok := e.target(subnet)
+		if !ok {
+			continue
+		}
+
+		compliant := true
+		if special {
+			ok, err := e.evaluateCompatRoutes(base, subnet, profile)
+			if err != nil {
+				return err
+			}
+			compliant = ok
+		}
+		for _, prefix := range e.policy.defaultRoutePrefixes() {
+			routeName, err := hub.DefaultRouteNameFor(prefix)
+			if err != nil {
+				return err
+			}
+
+			target := base
+			target.routeName = routeName
+			target.prefix = prefix
+			target.rule = findings.RuleDefaultRoute
+			target.findingData = map[string]string{
+				"prefix":     prefix,
+				"nextHop":    hub.NVANextHop,
+				"routeTable": target.rtName,
+			}
+			ok, err := e.evaluateRoute(target, subnet.RouteTableID)
+			if err != nil {
+				return fmt.Errorf("failed to enforce default route %s for subnet %s: %w", prefix, subnet.Name, err)
+			}
+			compliant = compliant && ok
+		}
+		if compliant {
+			e.compliant(base, findings.RuleDefaultRoute)
+		}
+
+		compliant = true
+		for _, prefix := range overrides {
+			routeName, err := hub.OnPremRouteName(prefix)
+			if err != nil {
+				return err
+			}
+
+			target := base
+			target.routeName = routeName
+			target.prefix = prefix
+			target.rule = findings.RuleOnPremOverride
+			target.findingData = map[string]string{
+				"prefix":     prefix,
+				"nextHop":    hub.NVANextHop,
+				"routeTable": target.rtName,
+			}
+			ok, err := e.evaluateRoute(target, subnet.RouteTableID)
+			if err != nil {
+				return fmt.Errorf("failed to enforce on-prem override %s for subnet %s: %w", prefix, subnet.Name, err)
+			}
+			compliant = compliant && ok
+		}
+		if compliant && len(overrides) > 0 {
+			e.compliant(base, findings.RuleOnPremOverride)
+		}
+		e.pruneOnPremRoutes(base, subnet.RouteTableID)
+	}
+	return nil
+}
+
+// evaluateCompatRoutes checks the subnet has the Internet routes of its
+// compatibility profile, labels it as routed under the profile, and reports
+// whether the routes are all there.
+func (e *evaluation) evaluateCompatRoutes(base routeTarget, subnet Subnet, profile CompatProfile) (bool, error) {
+	hub := e.policy.Hub
+	e.result.Findings = append(e.result.Findings, findings.New(findings.RuleCompatProfile, e.policy.Rules,
+		base.subscriptionID, subnet.ID, fmt.Sprintf("subnet %s is an %s subnet, routed under the %s compatibility profile", subnet.Name, subnet.Class, profile.Name),
+		map[string]string{
+			"subnet":  subnet.Name,
+			"class":   subnet.Class,
+			"profile": profile.Name,
+			"routes":  strings.Join(profile.InternetServiceTags, ", "),
+		}))
+
+	compliant := true
+	for _, tag := range profile.InternetServiceTags {
+		routeName, err := hub.CompatRouteName(tag)
+		if err != nil {
+			return false, err
+		}
+
+		target := base
+		target.routeName = routeName
+		target.prefix = tag
+		target.nextHopType = string(armnetwork.RouteNextHopTypeInternet)
+		target.rule = findings.RuleDefaultRoute
+		target.findingData = map[string]string{
+			"prefix":     tag,
+			"nextHop":    target.nextHopType,
+			"routeTable": target.rtName,
+		}
+		ok, err := e.evaluateRoute(target, subnet.RouteTableID)
+		if err != nil {
+			return false, fmt.Errorf("failed to enforce %s route %s for subnet %s: %w", profile.Name, tag, subnet.Name, err)
+		}
+		compliant = compliant && ok
+	}
+	return compliant, nil
+}
+
+// onPremOverrides returns the overridden on-prem prefixes to enforce in the
+// VNet. Prefixes overlapping its subnets or the hub are left out, they
+// would send traffic within the VNet or to the hub through the NVA.
+func (e *evaluation) onPremOverrides(vnet VNet) []string {
+	var overrides []string
+	for _, prefix := range e.policy.OnPremOverrides {
+		if overlapping := overlapsVNet(prefix, vnet); overlapping != "" {
+			e.note("WARNING: skipped on-prem override %s in VNet %s: overlaps its address range %s", prefix, vnet.Name, overlapping)
+			continue
+		}
+		if overlapping := overlaps(prefix, e.policy.HubPrefixes); overlapping != "" {
+			e.note("WARNING: skipped on-prem override %s in VNet %s: overlaps the hub address range %s", prefix, vnet.Name, overlapping)
+			continue
+		}
+		overrides = append(overrides, prefix)
+	}
+	return overrides
+}
+
+// overlapsVNet returns the address prefix of the VNet's subnets the prefix
+// overlaps, empty if there is none.
+func overlapsVNet(prefix string, vnet VNet) string {
+	for _, subnet := range vnet.Subnets {
+		if p := overlaps(prefix, subnet.Prefixes); p != "" {
+			return p
+		}
+	}
+	return ""
+}
+
+// pruneOnPremRoutes deletes the managed on-prem override routes of the route
+// table whose prefix is no longer overridden. Each route table is pruned once.
+func (e *evaluation) pruneOnPremRoutes(target routeTarget, rtID string) {
+	key := strings.ToLower(rtID)
+	if e.pruned[key] {
+		return
+	}
+	e.pruned[key] = true
+
+	for _, route := range e.inventory.RouteTables[key].Routes {
+		if !e.policy.Hub.IsOnPremRoute(route.Name) || slices.Contains(e.policy.OnPremOverrides, route.AddressPrefix) ||
+			e.serviceOfRoute(rtID, route.Name) != "" {
+			continue
+		}
+		e.result.Findings = append(e.result.Findings, findings.New(findings.RuleStaleOnPremOverride, e.policy.Rules,
+			target.subscriptionID, rtID, fmt.Sprintf("route %s for %s in route table %s overrides an on-prem prefix that is no longer overridden",
+				route.Name, route.AddressPrefix, target.rtName),
+			map[string]string{
+				"route":      route.Name,
+				"prefix":     route.AddressPrefix,
+				"routeTable": target.rtName,
+			}))
+		e.result.Changes = append(e.result.Changes, RouteChange{
+			SubscriptionID: target.rtSubscriptionID,
+			ResourceGroup:  target.rtResourceGroup,
+			RouteTable:     target.rtName,
+			Name:           route.Name,
+			Etag:           route.Etag,
+			Prefix:         route.AddressPrefix,
+			Delete:         true,
+			Before:         &route,
+		})
+	}
+}
+
+// evaluateSubnetIsolation checks that subnets of the VNet reach each other
+// through the NVA.
+func (e *evaluation) evaluateSubnetIsolation(vnet VNet) error {
+	hub := e.policy.Hub
+
+	var subnets []Subnet
+	for _, subnet := range vnet.Subnets {
+		if subnet.prefix() == "" {
+			e.recordUnreadable(subnet.ID, "subnet without name, properties or address prefix")
+			continue
+		}
+		subnets = append(subnets, subnet)
+	}
+
+	for _, subnet := range subnets {
+		if !e.isolated(subnet) || e.classConflict(subnet) {
+			continue
+		}
+		// if subnet doesn't have RT, skip for now
+		// TODO: enforce RTs on all subnets
+		if subnet.RouteTableID == "" || e.excluded(subnet.RouteTableID) {
+			continue
+		}
+		if containsIP(subnet.Prefixes, hub.NVANextHop) {
+			e.note("skipped subnet %s: contains the NVA %s", subnet.Name, hub.NVANextHop)
+			continue
+		}
+
+		base, ok := e.target(subnet)
+		if !ok {
+			continue
+		}
+
+		// towards each other subnet, check the routing
+		for _, other := range subnets {
+			if subnet.Name == other.Name {
+				continue
+			}
+
+			routeName, err := hub.IsolationRouteName(other.Name)
+			if err != nil {
+				return err
+			}
+
+			target := base
+			target.routeName = routeName
+			target.prefix = other.prefix()
+			target.rule = findings.RuleSubnetIsolation
+			target.findingData = map[string]string{
+				"prefix":       other.prefix(),
+				"nextHop":      hub.NVANextHop,
+				"routeTable":   target.rtName,
+				"targetSubnet": other.Name,
+			}
+			ok, err := e.evaluateRoute(target, subnet.RouteTableID)
+			if err != nil {
+				return fmt.Errorf("failed to enforce route for subnet %s to %s: %w", subnet.Name, other.Name, err)
+			}
+			if ok {
+				e.compliant(target, target.rule)
+			}
+		}
+	}
+	return nil
+}
+
+// target returns the route target for the route table of the subnet. Route
+// tables in subscriptions that aren't writable are reported instead, false
+// is returned for them.
+func (e *evaluation) target(subnet Subnet) (routeTarget, bool) {
+	subscriptionID := e.inventory.SubscriptionID
+	rtID := subnet.RouteTableID
+	rtSubscriptionID := azure.SubscriptionIDOf(rtID)
+	if rtSubscriptionID == "" {
+		rtSubscriptionID = subscriptionID
+	}
+	if !strings.EqualFold(rtSubscriptionID, subscriptionID) && !e.policy.writable(rtSubscriptionID) {
+		e.result.Findings = append(e.result.Findings, findings.New(findings.RuleUnmanagedSubscription, e.policy.Rules,
+			subscriptionID, rtID, fmt.Sprintf("route table %s is in unmanaged subscription %s, not modified", rtID, rtSubscriptionID),
+			map[string]string{
+				"kind":         "route table",
+				"resource":     azure.ExtractResourceIDParts(rtID)["routeTables"],
+				"subscription": rtSubscriptionID,
+			}))
+		return routeTarget{}, false
+	}
+
+	rtParts := azure.ExtractResourceIDParts(rtID)
+	return routeTarget{
+		subscriptionID:   subscriptionID,
+		subnetID:         subnet.ID,
+		rtSubscriptionID: rtSubscriptionID,
+		rtResourceGroup:  rtParts["resourceGroups"],
+		rtName:           rtParts["routeTables"],
+	}, true
+}
+
+// findRoute returns the state of the route for the target prefix. A prefix
+// can only appear once in a route table.
+func (e *evaluation) findRoute(rtID string, target routeTarget) routeState {
+	var state routeState
+	for _, route := range e.inventory.RouteTables[strings.ToLower(rtID)].Routes {
+		// service tags are matched regardless of case
+		if !strings.EqualFold(route.AddressPrefix, target.prefix) {
+			continue
+		}
+
+		state.exists = true
+		state.name = route.Name
+		state.etag = route.Etag
+		state.route = route
+		// compliance only depends on the next hop, not on the route name.
+		// Only appliances have a next hop IP, a route written with one is
+		// rewritten without it.
+		if target.nextHopType != "" {
+			state.correct = route.NextHopType == target.nextHopType && route.NextHopIPAddress == ""
+			continue
+		}
+		state.correct = route.NextHopType == string(armnetwork.RouteNextHopTypeVirtualAppliance) &&
+			route.NextHopIPAddress == e.policy.Hub.NVANextHop
+	}
+	return state
+}
+
+// evaluateRoute checks the route for the target prefix points at the NVA
+// and reports whether it does. Routes velora doesn't own are only reported
+// unless the hub allows replacing them.
+func (e *evaluation) evaluateRoute(target routeTarget, rtID string) (bool, error) {
+	hub := e.policy.Hub
+	if hub.NVANextHop == "" {
+		return false, fmt.Errorf("no NVA IPs defined for hub %s", hub.Name)
+	}
+
+	state := e.findRoute(rtID, target)
+	if state.exists && state.correct {
+		if e.owns(rtID, state.name, target.routeName) {
+			e.managed(target, state.name)
+		}
+		return true, nil
+	}
+
+	message := fmt.Sprintf("route table %s has no route %s to the NVA %s", target.rtName, target.prefix, hub.NVANextHop)
+	nextHop := hub.NVANextHop
+	if target.nextHopType != "" {
+		message = fmt.Sprintf("route table %s has no route %s to the %s", target.rtName, target.prefix, target.nextHopType)
+		nextHop = ""
+	}
+	// an existing route for the prefix is updated in place, as a prefix can
+	// only appear once in a route table
+	routeName := target.routeName
+	etag := ""
+	var before *Route
+	if state.exists {
+		// a service's route is never replaced, the service would put it back
+		if service := e.serviceOfRoute(rtID, state.name); service != "" {
+			message = fmt.Sprintf("route %s for %s in route table %s is managed by %s, not modified",
+				state.name, target.prefix, target.rtName, service)
+			e.result.Findings = append(e.result.Findings, findings.New(target.rule, e.policy.Rules, target.subscriptionID, target.subnetID, message, target.findingData))
+			return false, nil
+		}
+		if !e.owns(rtID, state.name, target.routeName) && !hub.ReplaceForeignRoutes {
+			message = fmt.Sprintf("route %s for %s in route table %s doesn't point to the NVA %s and isn't managed by velora, not modified",
+				state.name, target.prefix, target.rtName, hub.NVANextHop)
+			if target.nextHopType != "" {
+				message = fmt.Sprintf("route %s for %s in route table %s doesn't point to the %s and isn't managed by velora, not modified",
+					state.name, target.prefix, target.rtName, target.nextHopType)
+			}
+			e.result.Findings = append(e.result.Findings, findings.New(target.rule, e.policy.Rules, target.subscriptionID, target.subnetID, message, target.findingData))
+			return false, nil
+		}
+		routeName = state.name
+		etag = state.etag
+		before = &state.route
+	}
+	e.managed(target, routeName)
+	e.result.Findings = append(e.result.Findings, findings.New(target.rule, e.policy.Rules, target.subscriptionID, target.subnetID, message, target.findingData))
+	e.result.Changes = append(e.result.Changes, RouteChange{
+		SubscriptionID: target.rtSubscriptionID,
+		ResourceGroup:  target.rtResourceGroup,
+		RouteTable:     target.rtName,
+		Name:           routeName,
+		Etag:           etag,
+		Prefix:         target.prefix,
+		NextHop:        nextHop,
+		NextHopType:    target.nextHopType,
+		Before:         before,
+	})
+	return false, nil
+}
+
+// compliant records the subnet of the target as compliant with the rule.
+func (e *evaluation) compliant(target routeTarget, rule findings.Rule) {
+	e.result.Compliant = append(e.result.Compliant, CompliantResource{
+		Rule:           rule,
+		SubscriptionID: target.subscriptionID,
+		ResourceID:     target.subnetID,
+	})
+}
+
+// managed records a route velora owns.
+func (e *evaluation) managed(target routeTarget, name string) {
+	e.result.Managed = append(e.result.Managed, ManagedRoute{SubscriptionID: target.rtSubscriptionID, ID: target.routeID(name)})
+}
+
+// note records an informational message.
+func (e *evaluation) note(format string, args ...any) {
+	e.result.Notes = append(e.result.Notes, fmt.Sprintf(format, args...))
+}
+
+// recordUnreadable records a resource that couldn't be evaluated.
+func (e *evaluation) recordUnreadable(resourceID, reason string) {
+	e.note("WARNING: skipping unreadable resource: %s - %s", resourceID, reason)
+	e.result.Findings = append(e.result.Findings, findings.New(findings.RuleUnreadableResource, e.policy.Rules,
+		e.inventory.SubscriptionID, resourceID, reason, nil))
+}
@@ -0,0 +1,254 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// withSpoke puts the spoke VNet with the subnets, and its route table with
+// the routes, into the fake ARM.
+func withSpoke(arm *azuretest.Server, subnets []*armnetwork.Subnet, routes ...*armnetwork.Route) {
+	arm.Put(spokeVNetID, azuretest.VNet(spokeVNetID, []string{"10.0.0.0/24", "10.1.0.0/16"}, subnets...))
+	arm.Put(spokeRouteTable, azuretest.RouteTable(spokeRouteTable, routes...))
+}
+
+// appSubnet returns the subnet of the spoke using its route table.
+func appSubnet() *armnetwork.Subnet {
+	return azuretest.Subnet("app", "10.1.0.0/24", spokeRouteTable)
+}
+
+// defaultRouteTo returns the default route to the next hop.
+func defaultRouteTo(nextHop string) *armnetwork.Route {
+	return azuretest.Route("DefaultRoute-To-NVA", "0.0.0.0/0", nextHop)
+}
+
+// putDefaultRoute is the write of the route pointing the default route of
+// the spoke route table to the NVA.
+func putDefaultRoute(routeTable, route string) string {
+	return "PUT " + spokeRG + "/providers/Microsoft.Network/routeTables/" + routeTable + "/routes/" + route + " 0.0.0.0/0 VirtualAppliance " + configtest.NVANextHop
+}
+
+// missingDefaultRoute is the finding of the subnet of the spoke VNet.
+func missingDefaultRoute(vnet, subnet string) string {
+	return findings.RuleDefaultRoute.ID + " " + spokeRG + "/providers/Microsoft.Network/virtualNetworks/" + vnet + "/subnets/" + subnet
+}
+
+// outcome returns the writes reaching ARM, with the prefix and next hop of
+// the routes written, and the findings, sorted.
+func outcome(t *testing.T, arm *azuretest.Server, all []findings.Finding) []string {
+	t.Helper()
+	var result []string
+	for _, req := range arm.Writes() {
+		var route armnetwork.Route
+		if err := json.Unmarshal(req.Body, &route); err != nil {
+			t.Fatalf("%s: %v", req, err)
+		}
+		write := req.String()
+		if p := route.Properties; p != nil && p.AddressPrefix != nil && p.NextHopType != nil {
+			write += fmt.Sprintf(" %s %s", *p.AddressPrefix, *p.NextHopType)
+			if p.NextHopIPAddress != nil {
+				write += " " + *p.NextHopIPAddress
+			}
+		}
+		result = append(result, write)
+	}
+	for _, f := range all {
+		result = append(result, f.RuleID+" "+f.ResourceID)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// TestEnforceAllEquivalence runs the routing controller over fixtures whose
+// outcome was recorded with the controller before it was split into
+// discovery, Evaluate and writes. The split must not change it.
+func TestEnforceAllEquivalence(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(arm *azuretest.Server)
+		mutate func(cfg *config.Config)
+		want   []string
+	}{
+		{
+			name:  "missing default route",
+			setup: func(arm *azuretest.Server) { withSpoke(arm, []*armnetwork.Subnet{appSubnet()}) },
+			want:  []string{putDefaultRoute("spoke-rt", "DefaultRoute-To-NVA"), missingDefaultRoute("spoke", "app")},
+		},
+		{
+			name: "correct default route",
+			setup: func(arm *azuretest.Server) {
+				withSpoke(arm, []*armnetwork.Subnet{appSubnet()}, defaultRouteTo(configtest.NVANextHop))
+			},
+		},
+		{
+			name: "default route to another NVA",
+			setup: func(arm *azuretest.Server) {
+				withSpoke(arm, []*armnetwork.Subnet{appSubnet()}, defaultRouteTo("10.0.0.5"))
+			},
+			want: []string{putDefaultRoute("spoke-rt", "DefaultRoute-To-NVA"), missingDefaultRoute("spoke", "app")},
+		},
+		{
+			name: "default route to the internet",
+			setup: func(arm *azuretest.Server) {
+				withSpoke(arm, []*armnetwork.Subnet{appSubnet()}, defaultRouteTo("Internet"))
+			},
+			want: []string{putDefaultRoute("spoke-rt", "DefaultRoute-To-NVA"), missingDefaultRoute("spoke", "app")},
+		},
+		{
+			name: "foreign default route",
+			setup: func(arm *azuretest.Server) {
+				withSpoke(arm, []*armnetwork.Subnet{appSubnet()}, azuretest.Route("custom", "0.0.0.0/0", "10.9.9.9"))
+			},
+			want: []string{missingDefaultRoute("spoke", "app")},
+		},
+		{
+			name: "foreign default route replaced",
+			setup: func(arm *azuretest.Server) {
+				withSpoke(arm, []*armnetwork.Subnet{appSubnet()}, azuretest.Route("custom", "0.0.0.0/0", "10.9.9.9"))
+			},
+			mutate: func(cfg *config.Config) { cfg.Hubs[0].ReplaceForeignRoutes = true },
+			want:   []string{putDefaultRoute("spoke-rt", "custom"), missingDefaultRoute("spoke", "app")},
+		},
+		{
+			name: "unrelated route",
+			setup: func(arm *azuretest.Server) {
+				withSpoke(arm, []*armnetwork.Subnet{appSubnet()}, azuretest.Route("onprem", "192.168.0.0/16", "VirtualNetworkGateway"))
+			},
+			want: []string{putDefaultRoute("spoke-rt", "DefaultRoute-To-NVA"), missingDefaultRoute("spoke", "app")},
+		},
+		{
+			// the controller re-read the route table after fixing it for the
+			// first subnet, so the others were compliant. Every subnet
+			// missing the route in the inventory is reported now, the route
+			// is still written once.
+			name: "route table shared by subnets",
+			setup: func(arm *azuretest.Server) {
+				withSpoke(arm, []*armnetwork.Subnet{appSubnet(), azuretest.Subnet("db", "10.1.1.0/24", spokeRouteTable)})
+			},
+			want: []string{putDefaultRoute("spoke-rt", "DefaultRoute-To-NVA"), missingDefaultRoute("spoke", "app"), missingDefaultRoute("spoke", "db")},
+		},
+		{
+			name: "subnet without route table",
+			setup: func(arm *azuretest.Server) {
+				withSpoke(arm, []*armnetwork.Subnet{appSubnet(), azuretest.Subnet("web", "10.1.1.0/24", "")})
+			},
+			want: []string{putDefaultRoute("spoke-rt", "DefaultRoute-To-NVA"), missingDefaultRoute("spoke", "app")},
+		},
+		{
+			name: "subnet holding the NVA",
+			setup: func(arm *azuretest.Server) {
+				nvaRouteTable := spokeRG + "/providers/Microsoft.Network/routeTables/nva-rt"
+				arm.Put(nvaRouteTable, azuretest.RouteTable(nvaRouteTable))
+				withSpoke(arm, []*armnetwork.Subnet{appSubnet(), azuretest.Subnet("nva", "10.0.0.0/28", nvaRouteTable)})
+			},
+			want: []string{putDefaultRoute("spoke-rt", "DefaultRoute-To-NVA"), missingDefaultRoute("spoke", "app")},
+		},
+		{
+			name: "two VNets",
+			setup: func(arm *azuretest.Server) {
+				withSpoke(arm, []*armnetwork.Subnet{appSubnet()}, defaultRouteTo(configtest.NVANextHop))
+				vnetID := spokeRG + "/providers/Microsoft.Network/virtualNetworks/other"
+				routeTableID := spokeRG + "/providers/Microsoft.Network/routeTables/other-rt"
+				arm.Put(vnetID, azuretest.VNet(vnetID, []string{"10.2.0.0/16"}, azuretest.Subnet("app", "10.2.0.0/24", routeTableID)))
+				arm.Put(routeTableID, azuretest.RouteTable(routeTableID))
+			},
+			want: []string{putDefaultRoute("other-rt", "DefaultRoute-To-NVA"), missingDefaultRoute("other", "app")},
+		},
+		{
+			// the route without the prefix isn't velora's
+			name: "managed route prefix",
+			setup: func(arm *azuretest.Server) {
+				withSpoke(arm, []*armnetwork.Subnet{appSubnet()}, defaultRouteTo("10.0.0.5"))
+			},
+			mutate: func(cfg *config.Config) { cfg.Hubs[0].ManagedRoutePrefix = "velora-" },
+			want:   []string{missingDefaultRoute("spoke", "app")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arm := newTestARM()
+			tt.setup(arm)
+			var mutators []func(*config.Config)
+			if tt.mutate != nil {
+				mutators = append(mutators, tt.mutate)
+			}
+			enforcer := newTestEnforcer(t, configtest.New(t, mutators...), arm)
+
+			if err := enforcer.EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error = %v", err)
+			}
+			if got := outcome(t, arm, enforcer.Findings()); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("EnforceAll() outcome:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+// TestEnforceAllHubPrefixes checks on-prem overrides against every prefix of
+// a hub address space split in several, IPv4 or IPv6: an override
+// overlapping any of them would send spoke traffic to the hub through the
+// NVA, it is left out.
+func TestEnforceAllHubPrefixes(t *testing.T) {
+	overrides := []string{"10.100.0.0/16", "192.168.0.0/16"}
+	tests := []struct {
+		name     string
+		prefixes []string
+		want     []string
+	}{
+		{
+			name:     "single prefix",
+			prefixes: []string{"10.0.0.0/16"},
+			want:     []string{"10.100.0.0/16", "192.168.0.0/16"},
+		},
+		{
+			name:     "dual prefix",
+			prefixes: []string{"10.0.0.0/16", "10.100.0.0/24"},
+			want:     []string{"192.168.0.0/16"},
+		},
+		{
+			name:     "mixed IPv4 and IPv6",
+			prefixes: []string{"fd00:db8::/48", "10.0.0.0/16", "10.100.128.0/17"},
+			want:     []string{"192.168.0.0/16"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arm := newTestARM()
+			arm.Put(configtest.HubVNetID, azuretest.VNet(configtest.HubVNetID, tt.prefixes, azuretest.Subnet("nva", "10.0.0.0/24", "")))
+			withSpoke(arm, []*armnetwork.Subnet{appSubnet()}, defaultRouteTo(configtest.NVANextHop))
+			cfg := configtest.New(t, func(cfg *config.Config) { cfg.Hubs[0].OverriddenOnPremPrefixes = overrides })
+			enforcer := newTestEnforcer(t, cfg, arm)
+
+			if err := enforcer.EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error = %v", err)
+			}
+			var routed []string
+			for _, req := range arm.Writes() {
+				var route armnetwork.Route
+				if err := json.Unmarshal(req.Body, &route); err != nil {
+					t.Fatalf("%s: %v", req, err)
+				}
+				if p := route.Properties; p != nil && p.AddressPrefix != nil {
+					routed = append(routed, *p.AddressPrefix)
+				}
+			}
+			sort.Strings(routed)
+			if fmt.Sprint(routed) != fmt.Sprint(tt.want) {
+				t.Errorf("override routes written = %v, want %v", routed, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// TestEnforceAllRouteOwnership runs the matrix of the default route's owner
+// (velora by its name, velora by the managed prefix, or someone else), the
+// route table (a service's by its tag, or not), the next hop (the NVA or
+// not) and replaceForeignRoutes. A route to the NVA is compliant whoever
+// owns it, a wrong one is only rewritten if velora owns it, or if
+// replaceForeignRoutes is set and the route table isn't a service's.
+func TestEnforceAllRouteOwnership(t *testing.T) {
+	owners := []struct {
+		name  string
+		route string
+		owned bool
+	}{
+		{name: "default route name", route: "corp-DefaultRoute-To-NVA", owned: true},
+		{name: "managed prefix", route: "corp-legacy", owned: true},
+		{name: "foreign name", route: "legacy-default", owned: false},
+	}
+	tables := []struct {
+		name    string
+		tags    map[string]string
+		service bool
+	}{
+		{name: "unmanaged table"},
+		{name: "service table", tags: map[string]string{"application": "databricks"}, service: true},
+	}
+
+	for _, owner := range owners {
+		for _, table := range tables {
+			for _, correct := range []bool{true, false} {
+				for _, replace := range []bool{false, true} {
+					name := fmt.Sprintf("%s/%s/correct=%t/replaceForeignRoutes=%t", owner.name, table.name, correct, replace)
+					t.Run(name, func(t *testing.T) {
+						nextHop := configtest.NVANextHop
+						if !correct {
+							nextHop = "Internet"
+						}
+						arm := newTestARM()
+						arm.Put(spokeVNetID, azuretest.VNet(spokeVNetID, []string{"10.1.0.0/16"}, azuretest.Subnet("app", "10.1.0.0/24", spokeRouteTable)))
+						rt := azuretest.RouteTable(spokeRouteTable, azuretest.Route(owner.route, "0.0.0.0/0", nextHop))
+						rt.Tags = make(map[string]*string)
+						for key, value := range table.tags {
+							rt.Tags[key] = to.Ptr(value)
+						}
+						arm.Put(spokeRouteTable, rt)
+						cfg := configtest.New(t, func(cfg *config.Config) {
+							cfg.Hubs[0].ManagedRoutePrefix = "corp-"
+							cfg.Hubs[0].ReplaceForeignRoutes = replace
+						})
+						enforcer := newTestEnforcer(t, cfg, arm)
+
+						if err := enforcer.EnforceAll(context.Background()); err != nil {
+							t.Fatalf("EnforceAll() error = %v", err)
+						}
+
+						wantFinding := !correct
+						wantWrite := !correct && (owner.owned || (replace && !table.service))
+						if got := len(findingsOf(enforcer.Findings(), findings.RuleDefaultRoute)) > 0; got != wantFinding {
+							t.Errorf("default route finding = %t, want %t", got, wantFinding)
+						}
+						var writes []string
+						for _, req := range arm.Writes() {
+							writes = append(writes, req.String())
+						}
+						want := []string(nil)
+						if wantWrite {
+							// the route is updated in place, under its name
+							want = []string{"PUT " + spokeRouteTable + "/routes/" + owner.route}
+						}
+						if fmt.Sprint(writes) != fmt.Sprint(want) {
+							t.Errorf("writes = %v, want %v", writes, want)
+						}
+					})
+				}
+			}
+		}
+	}
+}
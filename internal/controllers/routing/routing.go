@@ -2,357 +2,547 @@ package routing
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
 	"github.com/akos011221/velora/internal/azure"
 	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/failover"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/guard"
+	"github.com/akos011221/velora/internal/inventory"
+	"github.com/akos011221/velora/internal/limits"
+	"github.com/akos011221/velora/internal/managed"
+	"github.com/akos011221/velora/internal/plan"
+	"github.com/akos011221/velora/internal/tagging"
+	"github.com/akos011221/velora/internal/unprocessable"
 )
 
 // Enforcer handles routing enforcement in Azure.
 type Enforcer struct {
 	clientFactory *azure.ClientFactory
 	config        *config.Config
+	hubCache      *inventory.HubCache
+	inventory     *inventory.RunInventory
+	guard         *guard.Guard
+	failovers     *failover.Manager
+	managed       *managed.Tracker
+	stamper       *tagging.Stamper
+	quotas        *limits.Gate
+	findings      []findings.Finding
+	compliance    *findings.ComplianceLog
 }
 
-// NewEnforcer creates a new routing enforcer instance.
-func NewEnforcer(clientFactory *azure.ClientFactory, config *config.Config) *Enforcer {
+// NewEnforcer creates a new routing enforcer instance. The hub cache and the
+// run inventory are shared with the other controllers of the run, writes go
+// through the guard. Failovers
+// decide which hub subscriptions are enforced against, the managed routes
+// touched are recorded in the tracker. Enforced route tables are tagged by
+// the stamper. Routes that would exceed the limit of their route table are
+// refused by the quotas gate.
+func NewEnforcer(clientFactory *azure.ClientFactory, config *config.Config, hubCache *inventory.HubCache,
+	runInventory *inventory.RunInventory, guard *guard.Guard, failovers *failover.Manager, managed *managed.Tracker,
+	stamper *tagging.Stamper, quotas *limits.Gate) *Enforcer {
 	return &Enforcer{
 		clientFactory: clientFactory,
 		config:        config,
+		compliance:    findings.NewComplianceLog(config),
+		hubCache:      hubCache,
+		inventory:     runInventory,
+		guard:         guard,
+		failovers:     failovers,
+		managed:       managed,
+		stamper:       stamper,
+		quotas:        quotas,
 	}
 }
 
+// Findings returns the findings recorded during enforcement.
+func (e *Enforcer) Findings() []findings.Finding {
+	return e.findings
+}
+
+// Compliance returns the compliant resources recorded during enforcement.
+func (e *Enforcer) Compliance() *findings.ComplianceLog {
+	return e.compliance
+}
+
 // EnforceAll applies routing enforcement to all subscriptions.
 func (e *Enforcer) EnforceAll(ctx context.Context) error {
 	for subID, subCFG := range e.config.Subscriptions {
+		if reason, err := e.guard.SkipReason(subID); err != nil {
+			return err
+		} else if reason != "" {
+			fmt.Printf("skipped subscription %s: %s\n", subID, reason)
+			continue
+		}
+
 		// sets the subscription ID for the client factory
 		e.clientFactory.SetSubscriptionID(subID)
 
 		/* enforcement logic, if required for the subscription */
 
 		if e.config.Features.RoutingEnforcement {
-			// find the relevant hub
-			var hubCFG *config.HubVNetConfig
-			for _, hub := range e.config.Hubs {
-				if hub.Name == subCFG.HubName {
-					hubCFG = &hub
-					break
-				}
+			if !subCFG.RequireNVARouting && !subCFG.SubnetToSubnetDeny && subCFG.ForbiddenNextHops == nil {
+				continue
 			}
 
-			if hubCFG == nil {
-				return fmt.Errorf("hub %s not found for subscription %s", subCFG.HubName, subID)
+			// the hub isn't configured, the checks needing it are skipped
+			if _, missing := e.guard.HubMissing(subID); missing {
+				continue
 			}
 
-			if subCFG.RequireNVARouting {
-				if err := e.enforceNVARouting(ctx, subID, hubCFG); err != nil {
-					return fmt.Errorf("failed to enforce NVA routing for subscription %s: %w", subID, err)
-				}
+			// find the relevant hub, the failover hub while a failover is active
+			hubCFG, err := e.failovers.ActiveHub(e.config, subCFG.HubName)
+			if err != nil {
+				return fmt.Errorf("failed to resolve hub for subscription %s: %w", subID, err)
+			}
+			// virtual WAN hubs are enforced by the vwan controller
+			if hubCFG.IsVirtualWAN() {
+				continue
 			}
 
-			if subCFG.SubnetToSubnetDeny {
-				if err := e.enforceSubnetIsolation(ctx, subID); err != nil {
-					return fmt.Errorf("failed to enforce subnet isolation for subscription %s: %w", subID, err)
+			if err := e.guard.Contain("routing", subID, func() error {
+				return e.enforceSubscription(ctx, subID, subCFG, hubCFG)
+			}); err != nil {
+				if guard.IsPanic(err) || e.guard.SkipInactive(subID, err) {
+					continue
 				}
+				return fmt.Errorf("failed to enforce routing for subscription %s: %w", subID, err)
 			}
 		}
 	}
 	return nil
 }
 
-// enforceNVARouting makes sure that all subnets using the NVAs as the default route next hop.
-func (e *Enforcer) enforceNVARouting(ctx context.Context, subscriptionID string, hubCFG *config.HubVNetConfig) error {
-	// get all VNets in the subscription
-	vnetsClient, err := e.clientFactory.NewVirtualNeworksClient(ctx)
+// policy returns the routing policy of the subscription.
+func (e *Enforcer) policy(subCFG config.SubscriptionConfig, hubCFG *config.HubVNetConfig) Policy {
+	return PolicyFor(e.config, subCFG, hubCFG)
+}
+
+// PolicyFor returns the routing policy of a subscription of the config
+// against the hub.
+func PolicyFor(cfg *config.Config, subCFG config.SubscriptionConfig, hubCFG *config.HubVNetConfig) Policy {
+	policy := Policy{
+		Hub:                  hubCFG,
+		NVARouting:           subCFG.RequireNVARouting,
+		DefaultRoutePrefixes: subCFG.EffectiveDefaultRoutePrefixes(hubCFG),
+		OnPremOverrides:      hubCFG.OverriddenOnPremPrefixes,
+		HubPrefixes:          hubCFG.AddressPrefixes,
+		SubnetIsolation:      subCFG.SubnetToSubnetDeny,
+		Rules:                cfg.Rules,
+		IsHubVNet:            cfg.IsHubVNet,
+		CompatRoutes:         subCFG.EnforceWithCompatRoutes,
+		ServiceRouteMatchers: cfg.EffectiveServiceRouteMatchers(),
+		ServiceRouteTables:   subCFG.EffectiveServiceRouteTables(),
+		// route tables may be shared from other managed subscriptions or the hub's
+		Writable: func(rtSubscriptionID string) bool {
+			return cfg.Manages(rtSubscriptionID) || strings.EqualFold(rtSubscriptionID, azure.SubscriptionIDOf(hubCFG.VNetID))
+		},
+	}
+	if forbidden := subCFG.ForbiddenNextHops; forbidden != nil {
+		policy.ForbiddenNextHopTypes = forbidden.EffectiveTypes()
+		policy.ForbiddenNextHopAction = forbidden.EffectiveRemediationAction()
+	}
+	return policy
+}
+
+// enforceSubscription discovers the routing of the subscription, evaluates
+// it against the policy and applies the resulting changes.
+func (e *Enforcer) enforceSubscription(ctx context.Context, subscriptionID string, subCFG config.SubscriptionConfig, hubCFG *config.HubVNetConfig) error {
+	policy := e.policy(subCFG, hubCFG)
+	// every prefix of the hub address space, it may be split in several
+	if hubInv, err := e.hubCache.Get(ctx, *hubCFG); err != nil {
+		if len(hubCFG.AddressPrefixes) == 0 {
+			fmt.Printf("WARNING: failed to read the address space of hub %s, on-prem overrides aren't checked against it: %v\n", hubCFG.Name, err)
+		} else {
+			fmt.Printf("WARNING: failed to read the address space of hub %s, using its addressPrefixes: %v\n", hubCFG.Name, err)
+		}
+	} else if len(hubInv.AddressPrefixes) > 0 {
+		policy.HubPrefixes = hubInv.AddressPrefixes
+	}
+
+	inventory, err := e.discover(ctx, subscriptionID, &policy)
+	if err != nil {
+		return err
+	}
+	changeSet, err := Evaluate(policy, inventory)
 	if err != nil {
 		return err
 	}
 
-	// list all VNets in the subscription
-	pager := vnetsClient.NewListAllPager(nil)
-	for pager.More() {
-		page, err := pager.NextPage(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to list virtual networks: %w", err)
-		}
+	for _, note := range changeSet.Notes {
+		fmt.Println(note)
+	}
+	e.findings = append(e.findings, changeSet.Findings...)
+	for _, c := range changeSet.Compliant {
+		e.compliance.Record(c.Rule, c.SubscriptionID, c.ResourceID)
+	}
+	for _, m := range changeSet.Managed {
+		e.managed.Touch(managed.KindRoute, m.SubscriptionID, hubCFG.Name, m.ID)
+	}
 
-		// process each VNet
-		for _, vnet := range page.Value {
-			if err := e.enforceNVARoutingForVNet(ctx, *vnet.ID, *vnet.Name, hubCFG); err != nil {
-				return err
-			}
-		}
+	skipped, err := e.applyChanges(ctx, subscriptionID, inventory, changeSet.Changes)
+	if err != nil {
+		return err
 	}
+	e.stampRouteTables(ctx, inventory, changeSet, skipped)
 	return nil
 }
 
-// enforceNVARoutingForVNet makes sure that the subnets in the VNet have default route pointing to NVA
-func (e *Enforcer) enforceNVARoutingForVNet(ctx context.Context, vnetID, vnetName string, hubCFG *config.HubVNetConfig) error {
-	// get resource group from vnetID
-	parts := extractResourceIDParts(vnetID)
-	if parts["resourceGroups"] == "" {
-		return fmt.Errorf("invalid VNet ID format: %s", vnetID)
+// stampRouteTables tags the route tables found compliant or remediated with
+// when velora enforced them. Route tables with findings or changes left for
+// a later run aren't stamped.
+func (e *Enforcer) stampRouteTables(ctx context.Context, inventory Inventory, changeSet ChangeSet, skipped map[string]bool) {
+	pending := make(map[string]bool)
+	for key := range skipped {
+		pending[key] = true
+	}
+	for _, f := range changeSet.Findings {
+		pending[strings.ToLower(azure.TopLevelResourceID(f.ResourceID))] = true
+	}
+	changed := make(map[string]bool)
+	for _, change := range changeSet.Changes {
+		changed[strings.ToLower(azure.TopLevelResourceID(change.ID()))] = true
 	}
-	resourceGroup := parts["resourceGroups"]
 
-	// subnets client for getting the subnets
-	subnetsClient, err := e.clientFactory.NewSubnetsClient(ctx)
-	if err != nil {
-		return err
+	for key, rt := range inventory.RouteTables {
+		if pending[key] {
+			continue
+		}
+		rtSubscriptionID := azure.SubscriptionIDOf(rt.ID)
+		if rtSubscriptionID == "" {
+			rtSubscriptionID = inventory.SubscriptionID
+		}
+		e.stamper.Stamp(ctx, rtSubscriptionID, rt.ID, rt.Tags, changed[key])
 	}
+}
 
-	pager := subnetsClient.NewListPager(resourceGroup, vnetName, nil)
-	for pager.More() {
-		page, err := pager.NextPage(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to list subnets: %w", err)
+// discover reads the VNets of the subscription with their subnets, and the
+// routes of the route tables the subnets reference, from the run inventory.
+func (e *Enforcer) discover(ctx context.Context, subscriptionID string, policy *Policy) (Inventory, error) {
+	inventory := Inventory{
+		SubscriptionID: subscriptionID,
+		RouteTables:    make(map[string]RouteTable),
+	}
+
+	vnets, err := e.inventory.VNets(ctx, subscriptionID)
+	if err != nil {
+		return inventory, err
+	}
+	// malformed IDs are skipped, unless there are so many that something
+	// systemic is wrong and nothing should be changed
+	budget := unprocessable.NewBudget(e.config, subscriptionID)
+	for _, vnet := range vnets {
+		if vnet.ID == nil || vnet.Name == nil {
+			e.recordUnreadable(subscriptionID, vnetID(vnet), "virtual network without ID or name")
+			continue
+		}
+		budget.Seen()
+		if !azure.ValidResourceID(*vnet.ID, "virtualNetworks") {
+			e.findings = append(e.findings, budget.Skip("VNet", *vnet.ID))
+			continue
+		}
+		// hubs are skipped by the evaluation, their subnets aren't needed
+		if policy.isHub(*vnet.ID) {
+			inventory.VNets = append(inventory.VNets, VNet{ID: *vnet.ID, Name: *vnet.Name})
+			continue
 		}
+		// routes through the hub are pointless until the VNet is peered with it
+		if block, blocked := e.guard.BlockedUpstream(*vnet.ID); blocked {
+			fmt.Printf("skipped VNet %s: %s\n", *vnet.ID, block.Reason)
+			e.findings = append(e.findings, findings.New(findings.RuleBlockedByUpstream, e.config.Rules,
+				subscriptionID, *vnet.ID, fmt.Sprintf("routing of VNet %s held back: %s", *vnet.Name, block.Reason),
+				map[string]string{
+					"controller": "routing",
+					"resource":   "VNet " + *vnet.Name,
+					"reason":     block.Reason,
+					"rule":       block.RuleID,
+				}))
+			continue
+		}
+		inventory.VNets = append(inventory.VNets, VNet{ID: *vnet.ID, Name: *vnet.Name, Subnets: e.discoverSubnets(subscriptionID, vnet, budget),
+			Tags: tagValues(vnet.Tags)})
+	}
+	if err := budget.Err(); err != nil {
+		return inventory, err
+	}
 
-		for _, subnet := range page.Value {
-			// some subnets can't have route tables, like "GatewaySubnets"
-			// but for now it is assumed that spoke VNets don't have that.
-			if subnet.Properties.RouteTable == nil {
-				fmt.Println("WARNING: No route table found for subnet:", *subnet.Name)
-				// TODO: handle cases when there's no route table,
-				// as it shouldn't be allowed
+	// read the routes of every route table velora may change
+	disappeared := make(map[string]bool)
+	routeTables := make(map[string]map[string]*armnetwork.RouteTable)
+	for _, vnet := range inventory.VNets {
+		for _, subnet := range vnet.Subnets {
+			key := strings.ToLower(subnet.RouteTableID)
+			if key == "" {
 				continue
 			}
-
-			// get the resource group and the name of the RT
-			rtParts := extractResourceIDParts(*subnet.Properties.RouteTable.ID)
-			rtResourceGroup := rtParts["resourceGroups"]
-			rtName := rtParts["routeTables"]
-
-			// routes client for route operations
-			routesClient, err := e.clientFactory.NewRoutesClient(ctx)
-			if err != nil {
-				return err
+			if _, ok := inventory.RouteTables[key]; ok || disappeared[key] {
+				continue
 			}
-
-			defaultRouteExists := false
-			defaultRouteCorrect := false
-
-			// get all routes in the RT
-			routePager := routesClient.NewListPager(rtResourceGroup, rtName, nil)
-			for routePager.More() {
-				routePage, err := routePager.NextPage(ctx)
-				if err != nil {
-					return fmt.Errorf("failed to list routes: %w", err)
-				}
-
-				for _, route := range routePage.Value {
-					// is the default route entry found in the route table?
-					if route.Properties.AddressPrefix != nil && *route.Properties.AddressPrefix == "0.0.0.0/0" {
-						defaultRouteExists = true
-
-						// is the default route entry pointing to the NVA?
-						if route.Properties.NextHopType != nil && *route.Properties.NextHopType == armnetwork.RouteNextHopTypeVirtualAppliance {
-							if route.Properties.NextHopIPAddress != nil && *route.Properties.NextHopIPAddress == hubCFG.NVANextHop {
-								defaultRouteCorrect = true
-								break
-
-							}
-						}
-						break
-					}
-				}
+			rtSubscriptionID := azure.SubscriptionIDOf(subnet.RouteTableID)
+			if rtSubscriptionID == "" {
+				rtSubscriptionID = subscriptionID
+			}
+			if !strings.EqualFold(rtSubscriptionID, subscriptionID) && !policy.writable(rtSubscriptionID) {
+				continue
 			}
 
-			// do necessary operations if the default route is missing or
-			// not pointing to the NVA
-			if !defaultRouteExists || !defaultRouteCorrect {
-				if hubCFG.NVANextHop == "" {
-					return fmt.Errorf("no NVA IPs defined for hub %s", hubCFG.Name)
-				}
-				nvaNH := hubCFG.NVANextHop
-
-				// properties for the default route
-				defaultRouteName := "DefaultRoute-To-NVA"
-				addressPrefix := "0.0.0.0/0"
-				nextHopType := armnetwork.RouteNextHopTypeVirtualAppliance
-
-				routeParams := armnetwork.Route{
-					Properties: &armnetwork.RoutePropertiesFormat{
-						AddressPrefix:    &addressPrefix,
-						NextHopType:      &nextHopType,
-						NextHopIPAddress: &nvaNH,
-					},
+			listed, ok := routeTables[strings.ToLower(rtSubscriptionID)]
+			if !ok {
+				if listed, err = e.inventory.RouteTables(ctx, rtSubscriptionID); err != nil {
+					return inventory, err
 				}
+				routeTables[strings.ToLower(rtSubscriptionID)] = listed
+			}
+			rt, ok := listed[key]
+			if !ok {
+				// the route table was deleted since the VNets were listed
+				e.guard.MarkDisappeared(subnet.RouteTableID)
+				disappeared[key] = true
+				continue
+			}
+			inventory.RouteTables[key] = e.discoverRoutes(subscriptionID, subnet.RouteTableID, rt)
+		}
+	}
 
-				// create or update the default route
-				_, err := routesClient.BeginCreateOrUpdate(ctx, rtResourceGroup, rtName, defaultRouteName, routeParams, nil)
-				if err != nil {
-					return fmt.Errorf("failed to create or update default route for subnet %s: %w", *subnet.Name, err)
+	// a missing route table would be evaluated as empty, the subnets still
+	// referencing it are left for the next run
+	if len(disappeared) > 0 {
+		for i, vnet := range inventory.VNets {
+			var subnets []Subnet
+			for _, subnet := range vnet.Subnets {
+				if !disappeared[strings.ToLower(subnet.RouteTableID)] {
+					subnets = append(subnets, subnet)
 				}
 			}
+			inventory.VNets[i].Subnets = subnets
 		}
 	}
 
-	return nil
+	return inventory, nil
 }
 
-// enforceSubnetIsolation makes sures that subnets inside a VNet can't communicate directly.
-func (e *Enforcer) enforceSubnetIsolation(ctx context.Context, subscriptionID string) error {
-	vnetsClient, err := e.clientFactory.NewVirtualNeworksClient(ctx)
-	if err != nil {
-		return err
+// discoverSubnets returns the subnets of the VNet, listed inline with it.
+// Subnets whose ID or route table ID is malformed are skipped.
+func (e *Enforcer) discoverSubnets(subscriptionID string, vnet *armnetwork.VirtualNetwork, budget *unprocessable.Budget) []Subnet {
+	if vnet.Properties == nil {
+		return nil
 	}
 
-	// list all VNets in the subscription
-	pager := vnetsClient.NewListAllPager(nil)
-	for pager.More() {
-		page, err := pager.NextPage(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to list virtual networks: %w", err)
+	var subnets []Subnet
+	for _, subnet := range vnet.Properties.Subnets {
+		if subnet == nil || subnet.Name == nil || subnet.Properties == nil {
+			e.recordUnreadable(subscriptionID, subnetID(subnet), "subnet without name or properties")
+			continue
 		}
-
-		for _, vnet := range page.Value {
-			if err := e.enforceSubnetIsolationForVNet(ctx, *vnet.ID, *vnet.Name); err != nil {
-				return err
+		budget.Seen()
+		if !azure.ValidResourceID(subnetID(subnet), "virtualNetworks", "subnets") {
+			e.findings = append(e.findings, budget.Skip("subnet", subnetID(subnet)))
+			continue
+		}
+		rtID := ""
+		if subnet.Properties.RouteTable != nil {
+			if subnet.Properties.RouteTable.ID == nil {
+				e.recordUnreadable(subscriptionID, subnetID(subnet), "route table reference without ID")
+				continue
+			}
+			rtID = *subnet.Properties.RouteTable.ID
+			if !azure.ValidResourceID(rtID, "routeTables") {
+				e.findings = append(e.findings, budget.Skip("route table", rtID))
+				continue
 			}
 		}
-	}
 
-	return nil
+		subnets = append(subnets, Subnet{
+			ID:           subnetID(subnet),
+			Name:         *subnet.Name,
+			Prefixes:     subnetPrefixes(subnet),
+			RouteTableID: rtID,
+			Class:        subnetClass(subnet),
+		})
+	}
+	return subnets
 }
 
-// enforceSubnetIsolationForVNet ensures subnets in a VNet can't communicate directly.
-func (e *Enforcer) enforceSubnetIsolationForVNet(ctx context.Context, vnetID, vnetName string) error {
-	parts := extractResourceIDParts(vnetID)
-	if parts["resourceGroups"] == "" {
-		return fmt.Errorf("invalid VNet ID format: %s", vnetID)
+// discoverRoutes returns the routes of the route table, listed inline with it.
+func (e *Enforcer) discoverRoutes(subscriptionID, rtID string, rt *armnetwork.RouteTable) RouteTable {
+	routeTable := RouteTable{ID: rtID, Tags: tagValues(rt.Tags)}
+	if rt.Properties == nil {
+		return routeTable
 	}
-	resourceGroup := parts["resourceGroups"]
+	routeTable.DisableBGPRoutePropagation = rt.Properties.DisableBgpRoutePropagation
 
-	subnetsClient, err := e.clientFactory.NewSubnetsClient(ctx)
-	if err != nil {
-		return err
+	for _, route := range rt.Properties.Routes {
+		if route == nil || route.Properties == nil {
+			e.recordUnreadable(subscriptionID, routeID(route), "route without properties")
+			continue
+		}
+		r := Route{
+			Name:             stringValue(route.Name),
+			Etag:             stringValue(route.Etag),
+			AddressPrefix:    stringValue(route.Properties.AddressPrefix),
+			NextHopIPAddress: stringValue(route.Properties.NextHopIPAddress),
+		}
+		if route.Properties.NextHopType != nil {
+			r.NextHopType = string(*route.Properties.NextHopType)
+		}
+		routeTable.Routes = append(routeTable.Routes, r)
 	}
+	return routeTable
+}
 
-	var allSubnets []*armnetwork.Subnet
-	subnetPager := subnetsClient.NewListPager(resourceGroup, vnetName, nil)
-	for subnetPager.More() {
-		page, err := subnetPager.NextPage(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to list subnets: %w", err)
+// applyChanges creates, updates or deletes the routes of the change set,
+// through the guard. New route tables are left alone during the
+// subscription's grace period, and new routes are refused if the route
+// table is at its limit. It returns the lower-case IDs of the route tables
+// whose changes were left for a later run.
+func (e *Enforcer) applyChanges(ctx context.Context, subscriptionID string, inventory Inventory, changes []RouteChange) (map[string]bool, error) {
+	skipped := make(map[string]bool)
+	for _, change := range changes {
+		rtID := azure.TopLevelResourceID(change.ID())
+		if !e.guard.WritesAllowed(change.SubscriptionID) || e.guard.InGracePeriod(ctx, subscriptionID, change.ID()) {
+			skipped[strings.ToLower(rtID)] = true
+			continue
 		}
-		for _, subnet := range page.Value {
-			if subnet.Name != nil {
-				allSubnets = append(allSubnets, subnet)
+		if !change.Delete && change.Etag == "" {
+			used := len(inventory.RouteTables[strings.ToLower(rtID)].Routes)
+			if blocked := e.quotas.Reserve(change.SubscriptionID, config.LimitRoutesPerRouteTable, rtID, used); blocked != nil {
+				e.findings = append(e.findings, blocked.Finding(subscriptionID, rtID, "creating route "+change.Name))
+				skipped[strings.ToLower(rtID)] = true
+				continue
 			}
 		}
-	}
-
-	// make sure that each subnet uses the NVA to reach other subnets
-	for _, subnet := range allSubnets {
-		// if subnet doesn't have RT, skip for now
-		// TODO: enforce RTs on all subnets
-		if subnet.Properties.RouteTable == nil {
+		if change.Delete {
+			if err := e.deleteRoute(ctx, change); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
-		rtParts := extractResourceIDParts(*subnet.Properties.RouteTable.ID)
-		rtResourceGroup := rtParts["resourceGroups"]
-		rtName := rtParts["routeTables"]
+		nextHopType := armnetwork.RouteNextHopTypeVirtualAppliance
+		if change.NextHopType != "" {
+			nextHopType = armnetwork.RouteNextHopType(change.NextHopType)
+		}
+		routeParams := armnetwork.Route{
+			Properties: &armnetwork.RoutePropertiesFormat{
+				AddressPrefix: to.Ptr(change.Prefix),
+				NextHopType:   &nextHopType,
+			},
+		}
+		if change.NextHop != "" {
+			routeParams.Properties.NextHopIPAddress = to.Ptr(change.NextHop)
+		}
 
-		routesClient, err := e.clientFactory.NewRoutesClient(ctx)
+		body, err := json.Marshal(routeParams)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("failed to encode route %s: %w", change.Name, err)
+		}
+		before, err := beforeBody(change)
+		if err != nil {
+			return nil, err
+		}
+		write := plan.Change{
+			SubscriptionID: change.SubscriptionID,
+			ResourceID:     change.ID(),
+			APIVersion:     e.clientFactory.APIVersion(azure.ResourceTypeRoutes),
+			Etag:           change.Etag,
+			Body:           body,
+			Description:    change.Description(),
+			Before:         before,
+		}
+		if e.guard.Planned(write) {
+			continue
 		}
 
-		// towards each other subnet, check the routing
-		for _, otherSubnet := range allSubnets {
-			// skip the subnet itself
-			if *subnet.Name == *otherSubnet.Name {
+		// the etag of the listed route refuses to overwrite a change made
+		// since, a new route must not exist yet
+		if err := plan.Write(ctx, e.clientFactory, write); err != nil {
+			// the route table was deleted since its routes were listed
+			if e.guard.SkipDisappeared(rtID, err) {
 				continue
 			}
-
-			// get the hub's NVA IP
-			var hubCFG *config.HubVNetConfig
-			for _, sub := range e.config.Subscriptions {
-				for _, hub := range e.config.Hubs {
-					if hub.Name == sub.HubName {
-						hubCFG = &hub
-						break
-					}
-				}
-				if hubCFG != nil {
-					break
-				}
-			}
-
-			if hubCFG == nil || hubCFG.NVANextHop == "" {
-				return fmt.Errorf("no hub config with NVA IP found")
+			if e.guard.SkipPolicyDenied(change.SubscriptionID, change.ID(), err) {
+				skipped[strings.ToLower(rtID)] = true
+				continue
 			}
+			return nil, fmt.Errorf("failed to create or update route %s in route table %s: %w", change.Name, change.RouteTable, err)
+		}
+		e.inventory.Invalidate(change.SubscriptionID)
+	}
+	return skipped, nil
+}
 
-			routeName := fmt.Sprintf("Route-To-%s", *otherSubnet.Name)
-
-			// check if route exists
-			routeExists := false
-			routeCorrect := false
-
-			// get all routes
-			routePager := routesClient.NewListPager(rtResourceGroup, rtName, nil)
-			for routePager.More() {
-				routePage, err := routePager.NextPage(ctx)
-				if err != nil {
-					return fmt.Errorf("failed to list routes: %w", err)
-				}
-
-				for _, route := range routePage.Value {
-					if route.Properties.AddressPrefix != nil && *route.Properties.AddressPrefix == *otherSubnet.Properties.AddressPrefix {
-						routeExists = true
-					}
-
-					// check if the next hop is the NVA
-					if route.Properties.NextHopType != nil && *route.Properties.NextHopType == armnetwork.RouteNextHopTypeVirtualAppliance {
-						if route.Properties.NextHopIPAddress != nil && *route.Properties.NextHopIPAddress == hubCFG.NVANextHop {
-							routeCorrect = true
-							break
-						}
-					}
-					break
-				}
+// deleteRoute deletes a route of the change set, if it is unchanged since
+// it was listed.
+func (e *Enforcer) deleteRoute(ctx context.Context, change RouteChange) error {
+	before, err := beforeBody(change)
+	if err != nil {
+		return err
+	}
+	write := plan.Change{
+		SubscriptionID: change.SubscriptionID,
+		ResourceID:     change.ID(),
+		APIVersion:     e.clientFactory.APIVersion(azure.ResourceTypeRoutes),
+		Etag:           change.Etag,
+		Description:    change.Description(),
+		Delete:         true,
+		Before:         before,
+	}
+	if e.guard.Planned(write) {
+		return nil
+	}
 
-				// create or update the route, if needed
-				if !routeExists || !routeCorrect {
-					nvaNH := hubCFG.NVANextHop
-
-					// parameters for the route
-					nextHopType := armnetwork.RouteNextHopTypeVirtualAppliance
-					addressPrefix := *otherSubnet.Properties.AddressPrefix
-
-					routeParams := armnetwork.Route{
-						Properties: &armnetwork.RoutePropertiesFormat{
-							AddressPrefix:    &addressPrefix,
-							NextHopType:      &nextHopType,
-							NextHopIPAddress: &nvaNH,
-						},
-					}
-
-					_, err := routesClient.BeginCreateOrUpdate(ctx, rtResourceGroup, rtName, routeName, routeParams, nil)
-					if err != nil {
-						return fmt.Errorf("failed to create or update route for subnet %s to %s: %w",
-							*subnet.Name, *otherSubnet.Name, err)
-					}
-				}
-			}
+	if err := plan.Write(ctx, e.clientFactory, write); err != nil {
+		if e.guard.SkipDisappeared(change.ID(), err) || e.guard.SkipPolicyDenied(change.SubscriptionID, change.ID(), err) {
+			return nil
 		}
+		return fmt.Errorf("failed to delete route %s from route table %s: %w", change.Name, change.RouteTable, err)
 	}
-
+	e.inventory.Invalidate(change.SubscriptionID)
 	return nil
 }
 
-// extractResourceIDParts is a helper to get resource parts from Azure resource ID.
-func extractResourceIDParts(resourceID string) map[string]string {
-	result := make(map[string]string)
-	parts := strings.Split(resourceID, "/")
-
-	for i := 1; i < len(parts)-1; i += 2 {
-		result[parts[i]] = parts[i+1]
+// beforeBody encodes the route of the change as listed, in the shape of a
+// route body, for plan reviewers. It is nil for new routes.
+func beforeBody(change RouteChange) (json.RawMessage, error) {
+	if change.Before == nil {
+		return nil, nil
+	}
+	route := armnetwork.Route{
+		Properties: &armnetwork.RoutePropertiesFormat{
+			AddressPrefix: to.Ptr(change.Before.AddressPrefix),
+			NextHopType:   to.Ptr(armnetwork.RouteNextHopType(change.Before.NextHopType)),
+		},
 	}
+	if change.Before.NextHopIPAddress != "" {
+		route.Properties.NextHopIPAddress = to.Ptr(change.Before.NextHopIPAddress)
+	}
+	body, err := json.Marshal(route)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode route %s: %w", change.Name, err)
+	}
+	return body, nil
+}
 
-	return result
+// recordUnreadable records a resource that couldn't be evaluated because
+// required fields were missing from the ARM response.
+func (e *Enforcer) recordUnreadable(subscriptionID, resourceID, reason string) {
+	fmt.Println("WARNING: skipping unreadable resource:", resourceID, "-", reason)
+	e.findings = append(e.findings, findings.New(findings.RuleUnreadableResource, e.config.Rules,
+		subscriptionID, resourceID, reason, nil))
+}
+
+// tagValues returns the tags of a resource, dropping those without a value.
+func tagValues(tags map[string]*string) map[string]string {
+	values := make(map[string]string, len(tags))
+	for key, value := range tags {
+		if value != nil {
+			values[key] = *value
+		}
+	}
+	return values
 }
@@ -0,0 +1,477 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/failover"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/guard"
+	"github.com/akos011221/velora/internal/inventory"
+	"github.com/akos011221/velora/internal/limits"
+	"github.com/akos011221/velora/internal/managed"
+	"github.com/akos011221/velora/internal/pause"
+	"github.com/akos011221/velora/internal/state"
+	"github.com/akos011221/velora/internal/tagging"
+)
+
+// The spoke of the test configuration.
+const (
+	spokeRG         = "/subscriptions/" + configtest.SubscriptionID + "/resourceGroups/spoke-rg"
+	spokeVNetID     = spokeRG + "/providers/Microsoft.Network/virtualNetworks/spoke"
+	spokeRouteTable = spokeRG + "/providers/Microsoft.Network/routeTables/spoke-rt"
+	vnetsPath       = "/subscriptions/" + configtest.SubscriptionID + "/providers/Microsoft.Network/virtualNetworks"
+	routeTablesPath = "/subscriptions/" + configtest.SubscriptionID + "/providers/Microsoft.Network/routeTables"
+)
+
+// newTestARM returns a fake ARM with the hub of the test configuration and
+// its NVA.
+func newTestARM() *azuretest.Server {
+	arm := azuretest.NewServer()
+	arm.Put(configtest.HubVNetID, azuretest.VNet(configtest.HubVNetID, []string{"10.0.0.0/16"}, azuretest.Subnet("nva", "10.0.0.0/24", "")))
+	arm.Put("/subscriptions/"+configtest.HubSubscriptionID+"/resourceGroups/hub-rg/providers/Microsoft.Network/networkInterfaces/nva",
+		azuretest.ForwardingNIC("/subscriptions/"+configtest.HubSubscriptionID+"/resourceGroups/hub-rg/providers/Microsoft.Network/networkInterfaces/nva",
+			configtest.HubVNetID+"/subnets/nva", configtest.NVANextHop))
+	return arm
+}
+
+// newTestEnforcer returns an enforcer of the configuration against the fake
+// ARM, with the state in a temporary directory.
+func newTestEnforcer(t *testing.T, cfg *config.Config, arm *azuretest.Server) *Enforcer {
+	t.Helper()
+	store, err := state.Open(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory := azure.NewClientFactoryWithTransport(&cfg.Azure, azuretest.Credential{ObjectID: "velora"}, arm)
+	g := guard.New(pause.NewManager(store))
+	return NewEnforcer(factory, cfg,
+		inventory.NewHubCache(inventory.NewAzureHubFetcher(factory), time.Minute),
+		inventory.NewRunInventory(factory, cfg.Inventory.EffectiveMaxCachedResources()),
+		g, failover.NewManager(store), managed.NewTracker(store), tagging.NewStamper(cfg, factory, g), limits.NewGate(cfg))
+}
+
+// serveJSON serves the body as a 200 JSON response.
+func serveJSON(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+// findingsOf returns the resource IDs of the findings of the rule.
+func findingsOf(all []findings.Finding, rule findings.Rule) []string {
+	var ids []string
+	for _, f := range all {
+		if f.RuleID == rule.ID {
+			ids = append(ids, f.ResourceID)
+		}
+	}
+	return ids
+}
+
+func TestEnforceAllUnreadableResources(t *testing.T) {
+	validVNet := `{"id":"` + spokeVNetID + `","name":"spoke","properties":{"subnets":[
+		{"id":"` + spokeVNetID + `/subnets/app","name":"app","properties":{"addressPrefix":"10.1.0.0/24","routeTable":{"id":"` + spokeRouteTable + `"}}}]}}`
+	validRouteTables := `{"value":[{"id":"` + spokeRouteTable + `","name":"spoke-rt","properties":{"routes":[]}}]}`
+
+	tests := []struct {
+		name        string
+		vnets       string
+		routeTables string
+		unreadable  []string
+		// evaluated is whether the valid subnet is still evaluated
+		evaluated bool
+	}{
+		{
+			name:        "empty value",
+			vnets:       `{"value":[]}`,
+			routeTables: `{"value":[]}`,
+		},
+		{
+			// the run inventory drops it, there is nothing to report
+			name:        "nil entry in the page",
+			vnets:       `{"value":[null,` + validVNet + `]}`,
+			routeTables: validRouteTables,
+			evaluated:   true,
+		},
+		{
+			name:        "VNet without ID",
+			vnets:       `{"value":[{"name":"noid","properties":{}},` + validVNet + `]}`,
+			routeTables: validRouteTables,
+			unreadable:  []string{unknownID},
+			evaluated:   true,
+		},
+		{
+			name:        "VNet without properties",
+			vnets:       `{"value":[{"id":"` + spokeRG + `/providers/Microsoft.Network/virtualNetworks/empty","name":"empty"},` + validVNet + `]}`,
+			routeTables: validRouteTables,
+			evaluated:   true,
+		},
+		{
+			name: "subnet without properties",
+			vnets: `{"value":[{"id":"` + spokeVNetID + `","name":"spoke","properties":{"subnets":[
+				null,
+				{"id":"` + spokeVNetID + `/subnets/gone","name":"gone"},
+				{"id":"` + spokeVNetID + `/subnets/app","name":"app","properties":{"addressPrefix":"10.1.0.0/24","routeTable":{"id":"` + spokeRouteTable + `"}}}]}}]}`,
+			routeTables: validRouteTables,
+			unreadable:  []string{unknownID, spokeVNetID + "/subnets/gone"},
+			evaluated:   true,
+		},
+		{
+			name: "route table reference without ID",
+			vnets: `{"value":[{"id":"` + spokeVNetID + `","name":"spoke","properties":{"subnets":[
+				{"id":"` + spokeVNetID + `/subnets/app","name":"app","properties":{"addressPrefix":"10.1.0.0/24","routeTable":{}}}]}}]}`,
+			routeTables: validRouteTables,
+			unreadable:  []string{spokeVNetID + "/subnets/app"},
+		},
+		{
+			name:  "route without properties",
+			vnets: `{"value":[` + validVNet + `]}`,
+			routeTables: `{"value":[{"id":"` + spokeRouteTable + `","name":"spoke-rt","properties":{"routes":[
+				null,
+				{"id":"` + spokeRouteTable + `/routes/gone","name":"gone"}]}}]}`,
+			unreadable: []string{unknownID, spokeRouteTable + "/routes/gone"},
+			evaluated:  true,
+		},
+		{
+			name:        "route table without properties",
+			vnets:       `{"value":[` + validVNet + `]}`,
+			routeTables: `{"value":[{"id":"` + spokeRouteTable + `","name":"spoke-rt"}]}`,
+			evaluated:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arm := newTestARM()
+			arm.Handle(http.MethodGet, vnetsPath, serveJSON(tt.vnets))
+			arm.Handle(http.MethodGet, routeTablesPath, serveJSON(tt.routeTables))
+			cfg := configtest.New(t, func(cfg *config.Config) { cfg.Features.AutoRemediation = false })
+			enforcer := newTestEnforcer(t, cfg, arm)
+
+			if err := enforcer.EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error = %v", err)
+			}
+
+			unreadable := findingsOf(enforcer.Findings(), findings.RuleUnreadableResource)
+			if strings.Join(unreadable, ",") != strings.Join(tt.unreadable, ",") {
+				t.Errorf("unreadable resources = %v, want %v", unreadable, tt.unreadable)
+			}
+			missing := findingsOf(enforcer.Findings(), findings.RuleDefaultRoute)
+			if evaluated := len(missing) == 1 && missing[0] == spokeVNetID+"/subnets/app"; evaluated != tt.evaluated {
+				t.Errorf("default route findings = %v, want the valid subnet evaluated: %t", missing, tt.evaluated)
+			}
+		})
+	}
+}
+
+func TestEnforceAllMalformedIDRates(t *testing.T) {
+	const vnets = 20
+	tests := []struct {
+		name        string
+		malformed   int
+		maxFraction *float64
+		wantErr     bool
+	}{
+		{name: "none malformed"},
+		{name: "single malformed", malformed: 1},
+		{name: "at the default fraction", malformed: 2},
+		{name: "above the default fraction", malformed: 3, wantErr: true},
+		{name: "above a configured fraction", malformed: 3, maxFraction: to.Ptr(0.1), wantErr: true},
+		{name: "within a configured fraction", malformed: 3, maxFraction: to.Ptr(0.2)},
+		{name: "all malformed", malformed: vnets - 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the spoke VNet is valid, of the others the first ones have no
+			// resource group
+			listing := []string{`{"id":"` + spokeVNetID + `","name":"spoke","properties":{"subnets":[
+				{"id":"` + spokeVNetID + `/subnets/app","name":"app","properties":{"addressPrefix":"10.1.0.0/24","routeTable":{"id":"` + spokeRouteTable + `"}}}]}}`}
+			var wantUnreadable []string
+			for i := 1; i < vnets; i++ {
+				id := fmt.Sprintf("%s/providers/Microsoft.Network/virtualNetworks/vnet%d", spokeRG, i)
+				if i <= tt.malformed {
+					id = fmt.Sprintf("/subscriptions/%s/resourceGroups//providers/Microsoft.Network/virtualNetworks/vnet%d", configtest.SubscriptionID, i)
+					wantUnreadable = append(wantUnreadable, id)
+				}
+				listing = append(listing, fmt.Sprintf(`{"id":%q,"name":"vnet%d","properties":{"subnets":[]}}`, id, i))
+			}
+			arm := newTestARM()
+			arm.Handle(http.MethodGet, vnetsPath, serveJSON(`{"value":[`+strings.Join(listing, ",")+`]}`))
+			arm.Put(spokeRouteTable, azuretest.RouteTable(spokeRouteTable))
+			cfg := configtest.New(t, func(cfg *config.Config) { cfg.MaxUnprocessableFraction = tt.maxFraction })
+			enforcer := newTestEnforcer(t, cfg, arm)
+
+			err := enforcer.EnforceAll(context.Background())
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "malformed IDs") {
+					t.Errorf("EnforceAll() error = %v, want too many malformed IDs", err)
+				}
+				if writes := arm.Writes(); len(writes) != 0 {
+					t.Errorf("writes reaching ARM = %v, want none", writes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EnforceAll() error = %v", err)
+			}
+			if got := findingsOf(enforcer.Findings(), findings.RuleUnreadableResource); strings.Join(got, ",") != strings.Join(wantUnreadable, ",") {
+				t.Errorf("unreadable resources = %v, want %v", got, wantUnreadable)
+			}
+			// the valid VNet is still remediated
+			if writes := arm.Writes(); len(writes) != 1 || writes[0].Method != http.MethodPut {
+				t.Errorf("writes reaching ARM = %v, want the default route of the spoke", writes)
+			}
+		})
+	}
+}
+
+func TestEnforceAllCrossSubscriptionRouteTable(t *testing.T) {
+	const (
+		managedSubscriptionID   = "00000000-0000-0000-0000-000000000003"
+		unmanagedSubscriptionID = "00000000-0000-0000-0000-000000000009"
+	)
+	tests := []struct {
+		name           string
+		subscriptionID string
+		wantWrite      bool
+	}{
+		{name: "hub subscription", subscriptionID: configtest.HubSubscriptionID, wantWrite: true},
+		{name: "other managed subscription", subscriptionID: managedSubscriptionID, wantWrite: true},
+		{name: "unmanaged subscription", subscriptionID: unmanagedSubscriptionID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routeTableID := "/subscriptions/" + tt.subscriptionID + "/resourceGroups/shared-rg/providers/Microsoft.Network/routeTables/shared-rt"
+			arm := newTestARM()
+			arm.Put(spokeVNetID, azuretest.VNet(spokeVNetID, []string{"10.1.0.0/16"}, azuretest.Subnet("app", "10.1.0.0/24", routeTableID)))
+			arm.Put(routeTableID, azuretest.RouteTable(routeTableID))
+			cfg := configtest.New(t, func(cfg *config.Config) {
+				// observed only, its own route tables aren't enforced
+				cfg.Subscriptions[managedSubscriptionID] = config.SubscriptionConfig{HubName: configtest.HubName}
+			})
+			enforcer := newTestEnforcer(t, cfg, arm)
+
+			if err := enforcer.EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error = %v", err)
+			}
+
+			var writes []string
+			for _, req := range arm.Writes() {
+				writes = append(writes, req.String())
+			}
+			unmanaged := findingsOf(enforcer.Findings(), findings.RuleUnmanagedSubscription)
+			if tt.wantWrite {
+				// the route is written with a client of the route table's subscription
+				if want := "PUT " + routeTableID + "/routes/DefaultRoute-To-NVA"; len(writes) != 1 || writes[0] != want {
+					t.Errorf("writes = %v, want %s", writes, want)
+				}
+				if len(unmanaged) != 0 {
+					t.Errorf("unmanaged subscription findings = %v", unmanaged)
+				}
+				return
+			}
+			if len(writes) != 0 {
+				t.Errorf("writes = %v, want none", writes)
+			}
+			if len(unmanaged) != 1 || unmanaged[0] != routeTableID {
+				t.Errorf("unmanaged subscription findings = %v, want %s", unmanaged, routeTableID)
+			}
+			for _, req := range arm.Requests() {
+				if strings.HasPrefix(req.Path, "/subscriptions/"+unmanagedSubscriptionID) {
+					t.Errorf("request to the unmanaged subscription: %s", req)
+				}
+			}
+		})
+	}
+}
+
+func TestEnforceAllSkipsHubs(t *testing.T) {
+	const (
+		hubRG            = "/subscriptions/" + configtest.HubSubscriptionID + "/resourceGroups/hub-rg/providers/Microsoft.Network"
+		nvaRouteTable    = hubRG + "/routeTables/nva-rt"
+		sharedRouteTable = hubRG + "/routeTables/shared-rt"
+	)
+	tests := []struct {
+		name string
+		// hubVNetID is the hub VNet ID as configured
+		hubVNetID string
+	}{
+		{name: "same ID", hubVNetID: configtest.HubVNetID},
+		{name: "resource group case", hubVNetID: strings.Replace(configtest.HubVNetID, "hub-rg", "HUB-RG", 1)},
+		{name: "trailing slash", hubVNetID: configtest.HubVNetID + "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arm := newTestARM()
+			// both subnets of the hub have a route table without the default route
+			arm.Put(configtest.HubVNetID, azuretest.VNet(configtest.HubVNetID, []string{"10.0.0.0/16"},
+				azuretest.Subnet("nva", "10.0.0.0/24", nvaRouteTable), azuretest.Subnet("shared", "10.0.1.0/24", sharedRouteTable)))
+			arm.Put(nvaRouteTable, azuretest.RouteTable(nvaRouteTable))
+			arm.Put(sharedRouteTable, azuretest.RouteTable(sharedRouteTable))
+			cfg := configtest.New(t, func(cfg *config.Config) {
+				cfg.Hubs[0].VNetID = tt.hubVNetID
+				cfg.Subscriptions[configtest.HubSubscriptionID] = cfg.Subscriptions[configtest.SubscriptionID]
+			})
+			enforcer := newTestEnforcer(t, cfg, arm)
+
+			if err := enforcer.EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error = %v", err)
+			}
+			if writes := arm.Writes(); len(writes) != 0 {
+				t.Errorf("writes = %v, want none to the hub", writes)
+			}
+			if missing := findingsOf(enforcer.Findings(), findings.RuleDefaultRoute); len(missing) != 0 {
+				t.Errorf("default route findings = %v, want none for the hub", missing)
+			}
+		})
+	}
+}
+
+func TestEnforceAllSkipsNVASubnet(t *testing.T) {
+	nvaRouteTable := spokeRG + "/providers/Microsoft.Network/routeTables/nva-rt"
+	arm := newTestARM()
+	// a VNet that isn't a configured hub, but holds the NVA next hop
+	arm.Put(spokeVNetID, azuretest.VNet(spokeVNetID, []string{"10.0.0.0/24", "10.1.0.0/16"},
+		azuretest.Subnet("nva", "10.0.0.0/28", nvaRouteTable), azuretest.Subnet("app", "10.1.0.0/24", spokeRouteTable)))
+	arm.Put(nvaRouteTable, azuretest.RouteTable(nvaRouteTable))
+	arm.Put(spokeRouteTable, azuretest.RouteTable(spokeRouteTable))
+	enforcer := newTestEnforcer(t, configtest.New(t), arm)
+
+	if err := enforcer.EnforceAll(context.Background()); err != nil {
+		t.Fatalf("EnforceAll() error = %v", err)
+	}
+	var writes []string
+	for _, req := range arm.Writes() {
+		writes = append(writes, req.String())
+	}
+	if want := "PUT " + spokeRouteTable + "/routes/DefaultRoute-To-NVA"; len(writes) != 1 || writes[0] != want {
+		t.Errorf("writes = %v, want only %s", writes, want)
+	}
+}
+
+// notFound serves an ARM 404 with the error code.
+func notFound(code string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("x-ms-error-code", code)
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":{"code":"` + code + `","message":"` + r.URL.Path + ` was not found"}}`))
+	}
+}
+
+// TestEnforceAllDeletionRace deletes the resources of the spoke at each
+// stage of the enforcement. The run records them as disappeared and goes
+// on, a configured hub that doesn't exist isn't taken for a race.
+func TestEnforceAllDeletionRace(t *testing.T) {
+	const spokeRoute = spokeRouteTable + "/routes/DefaultRoute-To-NVA"
+	tests := []struct {
+		name   string
+		setup  func(arm *azuretest.Server)
+		mutate func(cfg *config.Config)
+		// disappeared are the resources recorded as disappeared
+		disappeared []string
+		// missing are the subnets reported without the default route
+		missing []string
+	}{
+		{
+			// the subnets of the route table are left for the next run
+			name: "route table deleted after the VNets were listed",
+			setup: func(arm *azuretest.Server) {
+				arm.Put(spokeVNetID, azuretest.VNet(spokeVNetID, []string{"10.1.0.0/16"}, appSubnet()))
+			},
+			disappeared: []string{spokeRouteTable},
+		},
+		{
+			name: "route table deleted before the route is written",
+			setup: func(arm *azuretest.Server) {
+				withSpoke(arm, []*armnetwork.Subnet{appSubnet()})
+				arm.Handle(http.MethodPut, spokeRoute, notFound("ParentResourceNotFound"))
+			},
+			disappeared: []string{spokeRouteTable},
+			missing:     []string{spokeVNetID + "/subnets/app"},
+		},
+		{
+			name: "route deleted before it is removed",
+			setup: func(arm *azuretest.Server) {
+				withSpoke(arm, []*armnetwork.Subnet{appSubnet()}, defaultRouteTo(configtest.NVANextHop),
+					azuretest.Route("onprem", "192.168.0.0/16", "VirtualNetworkGateway"))
+				arm.Handle(http.MethodDelete, spokeRouteTable+"/routes/onprem", notFound("ResourceNotFound"))
+			},
+			mutate: func(cfg *config.Config) {
+				sub := cfg.Subscriptions[configtest.SubscriptionID]
+				sub.ForbiddenNextHops = &config.ForbiddenNextHopsConfig{RemediationAction: config.ForbiddenNextHopDelete}
+				cfg.Subscriptions[configtest.SubscriptionID] = sub
+			},
+			disappeared: []string{spokeRouteTable + "/routes/onprem"},
+		},
+		{
+			// the hub is configured, its address space falls back to the config
+			name: "hub VNet not found",
+			setup: func(arm *azuretest.Server) {
+				withSpoke(arm, []*armnetwork.Subnet{appSubnet()})
+				arm.Handle(http.MethodGet, configtest.HubVNetID, notFound("ResourceNotFound"))
+			},
+			missing: []string{spokeVNetID + "/subnets/app"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arm := newTestARM()
+			tt.setup(arm)
+			var mutators []func(*config.Config)
+			if tt.mutate != nil {
+				mutators = append(mutators, tt.mutate)
+			}
+			enforcer := newTestEnforcer(t, configtest.New(t, mutators...), arm)
+
+			if err := enforcer.EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error = %v", err)
+			}
+			if got := enforcer.guard.Disappeared(); strings.Join(got, ",") != strings.Join(tt.disappeared, ",") {
+				t.Errorf("disappeared = %v, want %v", got, tt.disappeared)
+			}
+			if got := findingsOf(enforcer.Findings(), findings.RuleDefaultRoute); strings.Join(got, ",") != strings.Join(tt.missing, ",") {
+				t.Errorf("default route findings = %v, want %v", got, tt.missing)
+			}
+		})
+	}
+}
+
+func TestEnforceAllKeepsConcurrentChanges(t *testing.T) {
+	const routeID = spokeRouteTable + "/routes/DefaultRoute-To-NVA"
+	arm := newTestARM()
+	withSpoke(arm, []*armnetwork.Subnet{appSubnet()}, defaultRouteTo("10.0.0.5"))
+	// the route is changed outside velora after the route tables were listed
+	arm.Handle(http.MethodGet, routeTablesPath, serveJSON(`{"value":[{"id":"`+spokeRouteTable+`","name":"spoke-rt","properties":{"routes":[
+		{"id":"`+routeID+`","name":"DefaultRoute-To-NVA","etag":"W/\"listed\"","properties":{"addressPrefix":"0.0.0.0/0","nextHopType":"VirtualAppliance","nextHopIpAddress":"10.0.0.5"}}]}}]}`))
+	enforcer := newTestEnforcer(t, configtest.New(t), arm)
+
+	if err := enforcer.EnforceAll(context.Background()); err == nil {
+		t.Fatal("EnforceAll() overwrote a route changed since it was listed")
+	}
+	writes := arm.Writes()
+	if len(writes) != 1 || writes[0].Header.Get("If-Match") != `W/"listed"` {
+		t.Fatalf("writes = %v, want one PUT with the listed etag", writes)
+	}
+	var route armnetwork.Route
+	if !arm.Get(routeID, &route) || *route.Properties.NextHopIPAddress != "10.0.0.5" {
+		t.Errorf("route after the run = %+v, want it unchanged", route.Properties)
+	}
+}
@@ -0,0 +1,124 @@
+package routing
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// serviceRouteTables returns the config.ServiceRouteTables* mode of route
+// tables services manage.
+func (p *Policy) serviceRouteTables() string {
+	if p.ServiceRouteTables == "" {
+		return config.ServiceRouteTablesCoexist
+	}
+	return p.ServiceRouteTables
+}
+
+// detectServiceRouteTables finds the route tables of the subnets that a
+// service like AKS or Databricks manages. The service reconciles its own
+// routes, velora taking them over would flip them every run. Excluded and
+// report-only route tables are reported, coexisting ones only noted.
+func (e *evaluation) detectServiceRouteTables() {
+	if len(e.policy.ServiceRouteMatchers) == 0 {
+		return
+	}
+	mode := e.policy.serviceRouteTables()
+	for _, vnet := range e.inventory.VNets {
+		if e.policy.isHub(vnet.ID) {
+			continue
+		}
+		for _, subnet := range vnet.Subnets {
+			key := strings.ToLower(subnet.RouteTableID)
+			if key == "" {
+				continue
+			}
+			if _, seen := e.services[key]; seen {
+				continue
+			}
+
+			rt := e.inventory.RouteTables[key]
+			names := make([]string, 0, len(rt.Routes))
+			for _, route := range rt.Routes {
+				names = append(names, route.Name)
+			}
+			service := config.ServiceOfRouteTable(e.policy.ServiceRouteMatchers, subnet.RouteTableID, rt.Tags, names)
+			e.services[key] = service
+			if service == "" {
+				continue
+			}
+
+			rtName := azure.ExtractResourceIDParts(subnet.RouteTableID)["routeTables"]
+			switch mode {
+			case config.ServiceRouteTablesExclude:
+				e.note("skipped route table %s: managed by %s", rtName, service)
+			case config.ServiceRouteTablesReportOnly:
+				e.note("route table %s is managed by %s, reported but not modified", rtName, service)
+			default:
+				e.note("route table %s is managed by %s, its routes are left alone", rtName, service)
+				continue
+			}
+			e.result.Findings = append(e.result.Findings, findings.New(findings.RuleServiceRouteTable, e.policy.Rules,
+				e.inventory.SubscriptionID, subnet.RouteTableID, fmt.Sprintf("route table %s is managed by %s, not modified (%s)", rtName, service, mode),
+				map[string]string{
+					"routeTable": rtName,
+					"service":    service,
+					"mode":       mode,
+				}))
+		}
+	}
+}
+
+// excluded reports whether the route table is left alone entirely: shared
+// by subnets with conflicting policies, or managed by a service and
+// excluded.
+func (e *evaluation) excluded(rtID string) bool {
+	key := strings.ToLower(rtID)
+	if e.conflicted[key] {
+		return true
+	}
+	return e.services[key] != "" && e.policy.serviceRouteTables() == config.ServiceRouteTablesExclude
+}
+
+// serviceOfRoute returns the service the route of the route table belongs
+// to, empty if it isn't a service's. In a service's route table every route
+// velora doesn't own is the service's.
+func (e *evaluation) serviceOfRoute(rtID, name string) string {
+	if len(e.policy.ServiceRouteMatchers) == 0 {
+		return ""
+	}
+	key := strings.ToLower(rtID)
+	rt := e.inventory.RouteTables[key]
+	if service := config.ServiceOfRoute(e.policy.ServiceRouteMatchers, rtID, rt.Tags, name); service != "" {
+		return service
+	}
+	if service := e.services[key]; service != "" && !e.policy.Hub.OwnsRoute(name, "") {
+		return service
+	}
+	return ""
+}
+
+// owns reports whether velora owns the route of the route table. Service
+// routes are never velora's, whatever their name.
+func (e *evaluation) owns(rtID, name, expectedName string) bool {
+	return e.serviceOfRoute(rtID, name) == "" && e.policy.Hub.OwnsRoute(name, expectedName)
+}
+
+// dropServiceChanges drops the changes of report-only service route tables,
+// their findings stand.
+func (e *evaluation) dropServiceChanges() {
+	if e.policy.serviceRouteTables() != config.ServiceRouteTablesReportOnly {
+		return
+	}
+	changes := e.result.Changes[:0]
+	for _, change := range e.result.Changes {
+		if e.services[strings.ToLower(azure.TopLevelResourceID(change.ID()))] != "" {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	e.result.Changes = changes
+}
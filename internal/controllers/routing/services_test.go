@@ -0,0 +1,75 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// withAKSRouteTable puts the spoke into the fake ARM with its route table
+// managed by an AKS kubenet cluster: a route to each node, and a route of
+// the cluster to the Internet.
+func withAKSRouteTable(arm *azuretest.Server) {
+	withSpoke(arm, []*armnetwork.Subnet{appSubnet()})
+	rt := azuretest.RouteTable(spokeRouteTable,
+		azuretest.Route("aks-nodepool1-12345678-vmss000000____102440024", "10.244.0.0/24", "10.1.0.4"),
+		azuretest.Route("aks-nodepool1-12345678-vmss000001____102441024", "10.244.1.0/24", "10.1.0.5"),
+		azuretest.Route("aks-egress", "20.0.0.0/8", "Internet"))
+	rt.Tags = map[string]*string{"aks-managed-cluster-name": to.Ptr("prod-aks")}
+	arm.Put(spokeRouteTable, rt)
+}
+
+// TestEnforceAllServiceRouteTables checks that velora never deletes or
+// rewrites the routes of a service's route table, even with forbidden next
+// hops deleted and foreign routes replaced, in each serviceRouteTables mode.
+func TestEnforceAllServiceRouteTables(t *testing.T) {
+	serviceRouteTable := findings.RuleServiceRouteTable.ID + " " + spokeRouteTable
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		{
+			// only velora's default route is added next to the cluster's
+			mode: config.ServiceRouteTablesCoexist,
+			want: []string{putDefaultRoute("spoke-rt", "DefaultRoute-To-NVA"), missingDefaultRoute("spoke", "app")},
+		},
+		{
+			mode: config.ServiceRouteTablesExclude,
+			want: []string{serviceRouteTable},
+		},
+		{
+			mode: config.ServiceRouteTablesReportOnly,
+			want: []string{missingDefaultRoute("spoke", "app"), serviceRouteTable},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			arm := newTestARM()
+			withAKSRouteTable(arm)
+			cfg := configtest.New(t, func(cfg *config.Config) {
+				cfg.Hubs[0].ReplaceForeignRoutes = true
+				sub := cfg.Subscriptions[configtest.SubscriptionID]
+				sub.ServiceRouteTables = tt.mode
+				sub.ForbiddenNextHops = &config.ForbiddenNextHopsConfig{Types: []string{"Internet"}, RemediationAction: config.ForbiddenNextHopDelete}
+				cfg.Subscriptions[configtest.SubscriptionID] = sub
+			})
+			enforcer := newTestEnforcer(t, cfg, arm)
+
+			if err := enforcer.EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error = %v", err)
+			}
+			if got := outcome(t, arm, enforcer.Findings()); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("outcome =\n%v\nwant:\n%v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package routing
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// sharedSubnet is a subnet associated with a route table other subnets
+// share, with the policy that applies to it.
+type sharedSubnet struct {
+	vnet   string
+	subnet string
+	policy string
+}
+
+// checkSharedRouteTables finds the route tables associated with several
+// subnets whose policies want different routes in them. Enforcing each
+// subnet's policy would flip the table between them every run, so these
+// tables are reported and left alone.
+func (e *evaluation) checkSharedRouteTables() {
+	byTable := make(map[string][]sharedSubnet)
+	var keys []string
+	for _, vnet := range e.inventory.VNets {
+		for _, subnet := range vnet.Subnets {
+			if subnet.RouteTableID == "" {
+				continue
+			}
+			key := strings.ToLower(subnet.RouteTableID)
+			if _, ok := byTable[key]; !ok {
+				keys = append(keys, key)
+			}
+			byTable[key] = append(byTable[key], sharedSubnet{vnet: vnet.Name, subnet: subnet.Name, policy: e.subnetPolicy(vnet, subnet)})
+		}
+	}
+
+	for _, key := range keys {
+		shared := byTable[key]
+		if len(shared) < 2 || !conflicting(shared) {
+			continue
+		}
+		e.conflicted[key] = true
+
+		rtID := e.routeTableID(key)
+		rtName := azure.ExtractResourceIDParts(rtID)["routeTables"]
+		described := make([]string, 0, len(shared))
+		for _, s := range shared {
+			described = append(described, fmt.Sprintf("%s/%s (%s)", s.vnet, s.subnet, s.policy))
+		}
+		subnets := strings.Join(described, "; ")
+		e.note("WARNING: skipped route table %s: shared by subnets with conflicting policies", rtName)
+		e.result.Findings = append(e.result.Findings, findings.New(findings.RuleSharedRouteTableConflict, e.policy.Rules,
+			e.inventory.SubscriptionID, rtID, fmt.Sprintf("conflicting policy on shared route table %s, not modified: %s", rtName, subnets),
+			map[string]string{
+				"routeTable": rtName,
+				"subnets":    subnets,
+			}))
+	}
+}
+
+// conflicting reports whether the subnets sharing a route table want
+// different routes in it.
+func conflicting(shared []sharedSubnet) bool {
+	for _, s := range shared[1:] {
+		if s.policy != shared[0].policy {
+			return true
+		}
+	}
+	return false
+}
+
+// subnetPolicy describes the routes the policy wants in the route table of
+// the subnet. Subnets with the same description want the same routes.
+func (e *evaluation) subnetPolicy(vnet VNet, subnet Subnet) string {
+	hub := e.policy.Hub
+	if e.policy.isHub(vnet.ID) {
+		return "hub, not routed"
+	}
+	if containsIP(subnet.Prefixes, hub.NVANextHop) {
+		return "contains the NVA, not routed"
+	}
+	if e.classConflict(subnet) {
+		return "conflicting subnet classes, not routed"
+	}
+
+	var parts []string
+	if match := e.class(subnet); match != nil {
+		parts = append(parts, "class "+match.Name)
+		if match.Class.BGPPropagation != "" {
+			parts = append(parts, "BGP propagation "+match.Class.BGPPropagation)
+		}
+	}
+	if e.nvaRouting(subnet) {
+		profile, special := CompatProfiles[subnet.Class]
+		switch {
+		case special && !e.policy.CompatRoutes:
+			parts = append(parts, "exempt "+subnet.Class+" subnet")
+		case special:
+			parts = append(parts, "NVA routing under the "+profile.Name+" profile")
+		default:
+			parts = append(parts, "NVA routing")
+		}
+		if !special || e.policy.CompatRoutes {
+			var overrides []string
+			for _, prefix := range e.policy.OnPremOverrides {
+				if overlapsVNet(prefix, vnet) == "" && overlaps(prefix, e.policy.HubPrefixes) == "" {
+					overrides = append(overrides, prefix)
+				}
+			}
+			if len(overrides) > 0 {
+				parts = append(parts, "on-prem overrides "+strings.Join(overrides, ", "))
+			}
+		}
+	}
+	if e.isolated(subnet) {
+		var others []string
+		for _, other := range vnet.Subnets {
+			if other.Name != subnet.Name && other.prefix() != "" {
+				others = append(others, other.Name)
+			}
+		}
+		if len(others) > 0 {
+			slices.Sort(others)
+			parts = append(parts, "isolated from "+strings.Join(others, ", "))
+		}
+	}
+	if len(parts) == 0 {
+		return "not routed"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// routeTableID returns the ID of the route table with the lower-case key as
+// the subnets reference it.
+func (e *evaluation) routeTableID(key string) string {
+	if rt, ok := e.inventory.RouteTables[key]; ok && rt.ID != "" {
+		return rt.ID
+	}
+	for _, vnet := range e.inventory.VNets {
+		for _, subnet := range vnet.Subnets {
+			if strings.EqualFold(subnet.RouteTableID, key) {
+				return subnet.RouteTableID
+			}
+		}
+	}
+	return key
+}
+
+// dedupeChanges drops the repeated changes of routes, subnets sharing a
+// route table want the same change once per table.
+func dedupeChanges(changes []RouteChange) []RouteChange {
+	seen := make(map[string]bool)
+	var result []RouteChange
+	for _, change := range changes {
+		key := strings.ToLower(change.ID())
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, change)
+	}
+	return result
+}
+
+// dedupeManaged drops the repeated managed routes.
+func dedupeManaged(routes []ManagedRoute) []ManagedRoute {
+	seen := make(map[string]bool)
+	var result []ManagedRoute
+	for _, route := range routes {
+		key := strings.ToLower(route.ID)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, route)
+	}
+	return result
+}
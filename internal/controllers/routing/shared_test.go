@@ -0,0 +1,93 @@
+package routing
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+func TestEnforceAllSharedRouteTable(t *testing.T) {
+	otherVNetID := spokeRG + "/providers/Microsoft.Network/virtualNetworks/other"
+	tests := []struct {
+		name         string
+		compatRoutes bool
+		// other is the subnet of the other VNet sharing the spoke route table
+		other *armnetwork.Subnet
+		// want is the outcome of the first run, writes and findings
+		want []string
+	}{
+		{
+			name:  "subnets of two VNets agreeing",
+			other: azuretest.Subnet("app", "10.2.0.0/24", spokeRouteTable),
+			want: []string{
+				putDefaultRoute("spoke-rt", "DefaultRoute-To-NVA"),
+				missingDefaultRoute("other", "app"),
+				missingDefaultRoute("spoke", "app"),
+			},
+		},
+		{
+			name:  "exempt subnet next to a routed one",
+			other: appGatewayIn("10.2.0.0/24"),
+			want: []string{
+				findings.RuleSharedRouteTableConflict.ID + " " + spokeRouteTable,
+				findings.RuleSubnetClassExempt.ID + " " + otherVNetID + "/subnets/appgw",
+			},
+		},
+		{
+			name:         "compatibility profile next to a plain subnet",
+			compatRoutes: true,
+			other:        appGatewayIn("10.2.0.0/24"),
+			want:         []string{findings.RuleSharedRouteTableConflict.ID + " " + spokeRouteTable},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arm := newTestARM()
+			withSpoke(arm, []*armnetwork.Subnet{appSubnet()})
+			arm.Put(otherVNetID, azuretest.VNet(otherVNetID, []string{"10.2.0.0/16"}, tt.other))
+			cfg := configtest.New(t, func(cfg *config.Config) {
+				sub := cfg.Subscriptions[configtest.SubscriptionID]
+				sub.EnforceWithCompatRoutes = tt.compatRoutes
+				cfg.Subscriptions[configtest.SubscriptionID] = sub
+			})
+
+			enforcer := newTestEnforcer(t, cfg, arm)
+			if err := enforcer.EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error = %v", err)
+			}
+			if got := outcome(t, arm, enforcer.Findings()); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("EnforceAll() outcome:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			for _, f := range enforcer.Findings() {
+				if f.RuleID == findings.RuleSharedRouteTableConflict.ID && (!strings.Contains(f.Message, "spoke/app (") || !strings.Contains(f.Message, "other/appgw (")) {
+					t.Errorf("conflict finding = %q, want each subnet with its policy", f.Message)
+				}
+			}
+
+			// the route table doesn't flip-flop, the next run leaves it alone
+			arm.Reset()
+			if err := newTestEnforcer(t, cfg, arm).EnforceAll(context.Background()); err != nil {
+				t.Fatalf("EnforceAll() error on the second run = %v", err)
+			}
+			if writes := arm.Writes(); len(writes) != 0 {
+				t.Errorf("writes of the second run = %v, want none", writes)
+			}
+		})
+	}
+}
+
+// appGatewayIn returns an Application Gateway subnet with the prefix using
+// the spoke route table.
+func appGatewayIn(prefix string) *armnetwork.Subnet {
+	subnet := appGatewaySubnet()
+	subnet.Properties.AddressPrefix = &prefix
+	return subnet
+}
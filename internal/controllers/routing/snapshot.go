@@ -0,0 +1,65 @@
+package routing
+
+import (
+	"strings"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/inventory"
+)
+
+// SnapshotInventory returns the routing inventory of the subscription as
+// the inventory snapshot recorded it, to evaluate a policy without reading
+// Azure. Snapshots don't record route table tags, etags, compat subnet
+// classes or BGP route propagation.
+func SnapshotInventory(s *inventory.Snapshot, subscriptionID string) Inventory {
+	inv := Inventory{SubscriptionID: subscriptionID, RouteTables: make(map[string]RouteTable)}
+
+	names := make(map[string]string)
+	tags := make(map[string]map[string]string)
+	for _, vnet := range s.VNets {
+		names[strings.ToLower(vnet.ID)] = vnet.Name
+		tags[strings.ToLower(vnet.ID)] = vnet.Tags
+	}
+	byVNet := make(map[string]int)
+	for _, subnet := range s.Subnets {
+		if !strings.EqualFold(subnet.SubscriptionID, subscriptionID) {
+			continue
+		}
+		key := strings.ToLower(subnet.VNetID)
+		i, ok := byVNet[key]
+		if !ok {
+			name := names[key]
+			if name == "" {
+				name = azure.ExtractResourceIDParts(subnet.VNetID)["virtualNetworks"]
+			}
+			inv.VNets = append(inv.VNets, VNet{ID: subnet.VNetID, Name: name, Tags: tags[key]})
+			i = len(inv.VNets) - 1
+			byVNet[key] = i
+		}
+		inv.VNets[i].Subnets = append(inv.VNets[i].Subnets, Subnet{
+			ID:           subnet.ID,
+			Name:         subnet.Name,
+			Prefixes:     subnet.Prefixes,
+			RouteTableID: subnet.RouteTableID,
+		})
+		if subnet.RouteTableID != "" {
+			inv.RouteTables[strings.ToLower(subnet.RouteTableID)] = RouteTable{ID: subnet.RouteTableID}
+		}
+	}
+
+	for _, route := range s.Routes {
+		key := strings.ToLower(route.RouteTableID)
+		rt, ok := inv.RouteTables[key]
+		if !ok {
+			continue
+		}
+		rt.Routes = append(rt.Routes, Route{
+			Name:             route.Name,
+			AddressPrefix:    route.Prefix,
+			NextHopType:      route.NextHopType,
+			NextHopIPAddress: route.NextHopIP,
+		})
+		inv.RouteTables[key] = rt
+	}
+	return inv
+}
@@ -0,0 +1,342 @@
+package vwan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/failover"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/guard"
+	"github.com/akos011221/velora/internal/inventory"
+	"github.com/akos011221/velora/internal/plan"
+)
+
+const (
+	// defaultRoutePrefix is the destination of the default route.
+	defaultRoutePrefix = "0.0.0.0/0"
+	// defaultRouteName is the name of the default route velora adds.
+	defaultRouteName = "velora-default"
+)
+
+// Enforcer enforces routing of spokes connected to virtual WAN hubs: the
+// connection's associated route table and the default route of the hub's
+// default route table.
+type Enforcer struct {
+	clientFactory *azure.ClientFactory
+	config        *config.Config
+	inventory     *inventory.RunInventory
+	guard         *guard.Guard
+	failovers     *failover.Manager
+	findings      []findings.Finding
+	compliance    *findings.ComplianceLog
+	// checkedHubs are the hubs whose default route table was checked this run.
+	checkedHubs map[string]bool
+}
+
+// NewEnforcer creates a new virtual WAN enforcer instance. Spoke VNets are
+// read from the run inventory.
+func NewEnforcer(clientFactory *azure.ClientFactory, config *config.Config, runInventory *inventory.RunInventory, guard *guard.Guard,
+	failovers *failover.Manager) *Enforcer {
+	return &Enforcer{
+		clientFactory: clientFactory,
+		config:        config,
+		inventory:     runInventory,
+		compliance:    findings.NewComplianceLog(config),
+		guard:         guard,
+		failovers:     failovers,
+		checkedHubs:   make(map[string]bool),
+	}
+}
+
+// Findings returns the findings recorded during enforcement.
+func (e *Enforcer) Findings() []findings.Finding {
+	return e.findings
+}
+
+// Compliance returns the compliant resources recorded during enforcement.
+func (e *Enforcer) Compliance() *findings.ComplianceLog {
+	return e.compliance
+}
+
+// EnforceAll enforces the subscriptions whose hub is a virtual WAN hub.
+func (e *Enforcer) EnforceAll(ctx context.Context) error {
+	if !e.config.Features.RoutingEnforcement {
+		return nil
+	}
+
+	for _, subID := range e.config.SubscriptionIDs() {
+		if reason, err := e.guard.SkipReason(subID); err != nil {
+			return err
+		} else if reason != "" {
+			fmt.Printf("skipped subscription %s: %s\n", subID, reason)
+			continue
+		}
+
+		// the hub isn't configured, the checks needing it are skipped
+		if _, missing := e.guard.HubMissing(subID); missing {
+			continue
+		}
+
+		hubCFG, err := e.failovers.ActiveHub(e.config, e.config.Subscriptions[subID].HubName)
+		if err != nil {
+			return fmt.Errorf("failed to resolve hub for subscription %s: %w", subID, err)
+		}
+		if !hubCFG.IsVirtualWAN() {
+			continue
+		}
+
+		if err := e.guard.Contain("vwan", subID, func() error {
+			return e.enforceHub(ctx, hubCFG)
+		}); guard.IsPanic(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to enforce virtual hub %s: %w", hubCFG.Name, err)
+		}
+		if err := e.guard.Contain("vwan", subID, func() error {
+			return e.enforceSubscription(ctx, subID, hubCFG)
+		}); err != nil {
+			if guard.IsPanic(err) || e.guard.SkipInactive(subID, err) {
+				continue
+			}
+			return fmt.Errorf("failed to enforce virtual WAN routing for subscription %s: %w", subID, err)
+		}
+	}
+	return nil
+}
+
+// enforceHub makes sure the default route table of the hub routes
+// 0.0.0.0/0 to the configured next hop. Each hub is checked once per run.
+func (e *Enforcer) enforceHub(ctx context.Context, hubCFG *config.HubVNetConfig) error {
+	if e.checkedHubs[hubCFG.Name] {
+		return nil
+	}
+	e.checkedHubs[hubCFG.Name] = true
+
+	vwan := hubCFG.VirtualWAN
+	hubSubscriptionID := azure.SubscriptionIDOf(vwan.VirtualHubID)
+	parts := azure.ExtractResourceIDParts(vwan.VirtualHubID)
+	routeTablesClient, err := e.clientFactory.ForSubscription(hubSubscriptionID).NewHubRouteTablesClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	resp, err := routeTablesClient.Get(ctx, parts["resourceGroups"], parts["virtualHubs"], config.DefaultHubRouteTable, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get route table %s: %w", config.DefaultHubRouteTable, err)
+	}
+	routeTable := resp.HubRouteTable
+	routeTableID := vwan.VirtualHubID + "/hubRouteTables/" + config.DefaultHubRouteTable
+	if routeTable.Properties == nil {
+		routeTable.Properties = &armnetwork.HubRouteTableProperties{}
+	}
+
+	// the default route may be named anything, it is updated in place
+	var defaultRoute *armnetwork.HubRoute
+	for _, route := range routeTable.Properties.Routes {
+		if route != nil && containsDestination(route, defaultRoutePrefix) {
+			defaultRoute = route
+			break
+		}
+	}
+	if defaultRoute != nil && strings.EqualFold(stringValue(defaultRoute.NextHopType), "ResourceId") &&
+		strings.EqualFold(stringValue(defaultRoute.NextHop), vwan.NextHopID) {
+		e.compliance.Record(findings.RuleVWANDefaultRoute, hubSubscriptionID, routeTableID)
+		return nil
+	}
+
+	e.findings = append(e.findings, findings.New(findings.RuleVWANDefaultRoute, e.config.Rules,
+		hubSubscriptionID, routeTableID, fmt.Sprintf("route table %s of virtual hub %s has no default route to %s",
+			config.DefaultHubRouteTable, parts["virtualHubs"], vwan.NextHopID),
+		map[string]string{
+			"nextHop":    vwan.NextHopID,
+			"routeTable": config.DefaultHubRouteTable,
+			"hub":        parts["virtualHubs"],
+		}))
+
+	if !e.config.Features.AutoRemediation || !e.guard.WritesAllowed(hubSubscriptionID) {
+		return nil
+	}
+
+	before, err := json.Marshal(routeTable)
+	if err != nil {
+		return fmt.Errorf("failed to encode route table %s: %w", config.DefaultHubRouteTable, err)
+	}
+	if defaultRoute == nil {
+		defaultRoute = &armnetwork.HubRoute{
+			Name:            to.Ptr(defaultRouteName),
+			DestinationType: to.Ptr("CIDR"),
+			Destinations:    []*string{to.Ptr(defaultRoutePrefix)},
+		}
+		routeTable.Properties.Routes = append(routeTable.Properties.Routes, defaultRoute)
+	}
+	defaultRoute.NextHopType = to.Ptr("ResourceId")
+	defaultRoute.NextHop = to.Ptr(vwan.NextHopID)
+
+	body, err := json.Marshal(routeTable)
+	if err != nil {
+		return fmt.Errorf("failed to encode route table %s: %w", config.DefaultHubRouteTable, err)
+	}
+	write := plan.Change{
+		SubscriptionID: hubSubscriptionID,
+		ResourceID:     routeTableID,
+		APIVersion:     e.clientFactory.APIVersion(azure.ResourceTypeHubRouteTables),
+		Etag:           stringValue(routeTable.Etag),
+		Body:           body,
+		Description:    fmt.Sprintf("default route -> %s in route table %s of virtual hub %s", vwan.NextHopID, config.DefaultHubRouteTable, parts["virtualHubs"]),
+		Before:         before,
+	}
+	if e.guard.Planned(write) {
+		return nil
+	}
+
+	// the route table is written whole, a change since it was read is refused
+	if err := plan.Write(ctx, e.clientFactory, write); err != nil {
+		if e.guard.SkipPolicyDenied(hubSubscriptionID, routeTableID, err) {
+			return nil
+		}
+		return fmt.Errorf("failed to update route table %s: %w", config.DefaultHubRouteTable, err)
+	}
+	return nil
+}
+
+// enforceSubscription checks that every VNet of the subscription is connected
+// to the hub and associated with the configured route table.
+func (e *Enforcer) enforceSubscription(ctx context.Context, subscriptionID string, hubCFG *config.HubVNetConfig) error {
+	vwan := hubCFG.VirtualWAN
+	parts := azure.ExtractResourceIDParts(vwan.VirtualHubID)
+	connectionsClient, err := e.clientFactory.ForSubscription(azure.SubscriptionIDOf(vwan.VirtualHubID)).NewHubVirtualNetworkConnectionsClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	// connections of the hub by lower-case remote VNet ID
+	connections := make(map[string]*armnetwork.HubVirtualNetworkConnection)
+	pager := connectionsClient.NewListPager(parts["resourceGroups"], parts["virtualHubs"], nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list connections of virtual hub %s: %w", parts["virtualHubs"], err)
+		}
+		for _, conn := range page.Value {
+			if conn == nil || conn.Properties == nil || conn.Properties.RemoteVirtualNetwork == nil {
+				continue
+			}
+			connections[strings.ToLower(stringValue(conn.Properties.RemoteVirtualNetwork.ID))] = conn
+		}
+	}
+
+	vnets, err := e.inventory.VNets(ctx, subscriptionID)
+	if err != nil {
+		return err
+	}
+	for _, vnet := range vnets {
+		if vnet.ID == nil || vnet.Name == nil || e.config.IsHubVNet(*vnet.ID) {
+			continue
+		}
+		if err := e.enforceConnection(ctx, subscriptionID, vnet, connections[strings.ToLower(*vnet.ID)], hubCFG); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enforceConnection checks the hub connection of the VNet is associated with
+// the configured route table and, with auto remediation, associates it.
+func (e *Enforcer) enforceConnection(ctx context.Context, subscriptionID string,
+	vnet *armnetwork.VirtualNetwork, conn *armnetwork.HubVirtualNetworkConnection, hubCFG *config.HubVNetConfig) error {
+	vwan := hubCFG.VirtualWAN
+	hubSubscriptionID := azure.SubscriptionIDOf(vwan.VirtualHubID)
+	parts := azure.ExtractResourceIDParts(vwan.VirtualHubID)
+
+	if conn == nil || conn.Name == nil {
+		e.findings = append(e.findings, findings.New(findings.RuleVWANConnectionMissing, e.config.Rules,
+			subscriptionID, *vnet.ID, fmt.Sprintf("VNet %s is not connected to virtual hub %s", *vnet.Name, parts["virtualHubs"]),
+			map[string]string{
+				"vnet": *vnet.Name,
+				"hub":  parts["virtualHubs"],
+			}))
+		return nil
+	}
+
+	expected := vwan.VirtualHubID + "/hubRouteTables/" + vwan.EffectiveAssociatedRouteTable()
+	routing := conn.Properties.RoutingConfiguration
+	if routing != nil && routing.AssociatedRouteTable != nil &&
+		strings.EqualFold(stringValue(routing.AssociatedRouteTable.ID), expected) {
+		e.compliance.Record(findings.RuleVWANAssociation, subscriptionID, *vnet.ID)
+		return nil
+	}
+
+	e.findings = append(e.findings, findings.New(findings.RuleVWANAssociation, e.config.Rules,
+		subscriptionID, *vnet.ID, fmt.Sprintf("connection %s of VNet %s is not associated with route table %s",
+			*conn.Name, *vnet.Name, vwan.EffectiveAssociatedRouteTable()),
+		map[string]string{
+			"connection": *conn.Name,
+			"routeTable": vwan.EffectiveAssociatedRouteTable(),
+			"hub":        parts["virtualHubs"],
+		}))
+
+	// the connection is a hub resource, writes are guarded for both sides
+	if !e.config.Features.AutoRemediation || !e.guard.WritesAllowed(subscriptionID) || !e.guard.WritesAllowed(hubSubscriptionID) ||
+		e.guard.InGracePeriod(ctx, subscriptionID, *vnet.ID) {
+		return nil
+	}
+
+	before, err := json.Marshal(conn)
+	if err != nil {
+		return fmt.Errorf("failed to encode connection %s: %w", *conn.Name, err)
+	}
+	if routing == nil {
+		routing = &armnetwork.RoutingConfiguration{}
+		conn.Properties.RoutingConfiguration = routing
+	}
+	routing.AssociatedRouteTable = &armnetwork.SubResource{ID: to.Ptr(expected)}
+
+	body, err := json.Marshal(conn)
+	if err != nil {
+		return fmt.Errorf("failed to encode connection %s: %w", *conn.Name, err)
+	}
+	write := plan.Change{
+		SubscriptionID: hubSubscriptionID,
+		ResourceID:     stringValue(conn.ID),
+		APIVersion:     e.clientFactory.APIVersion(azure.ResourceTypeHubConnections),
+		Etag:           stringValue(conn.Etag),
+		Body:           body,
+		Description:    fmt.Sprintf("associate connection %s with route table %s", *conn.Name, vwan.EffectiveAssociatedRouteTable()),
+		Before:         before,
+	}
+	if e.guard.Planned(write) {
+		return nil
+	}
+
+	if err := plan.Write(ctx, e.clientFactory, write); err != nil {
+		if e.guard.SkipPolicyDenied(hubSubscriptionID, stringValue(conn.ID), err) {
+			return nil
+		}
+		return fmt.Errorf("failed to update connection %s: %w", *conn.Name, err)
+	}
+	return nil
+}
+
+// containsDestination reports whether the hub route has the destination.
+func containsDestination(route *armnetwork.HubRoute, destination string) bool {
+	for _, d := range route.Destinations {
+		if stringValue(d) == destination {
+			return true
+		}
+	}
+	return false
+}
+
+// stringValue returns the value of p, or an empty string if p is nil.
+func stringValue(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
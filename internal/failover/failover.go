@@ -0,0 +1,146 @@
+package failover
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// stateKey is the state store key holding the active failovers.
+const stateKey = "failovers"
+
+// Failover repoints the subscriptions of a hub to its failover hub.
+type Failover struct {
+	Hub         string    `json:"hub"`
+	FailoverHub string    `json:"failoverHub"`
+	SetBy       string    `json:"setBy"`
+	SetAt       time.Time `json:"setAt"`
+}
+
+// String describes the failover for reports.
+func (f *Failover) String() string {
+	return fmt.Sprintf("hub %s failed over to %s by %s at %s", f.Hub, f.FailoverHub, f.SetBy, f.SetAt.UTC().Format(time.RFC3339))
+}
+
+// Manager manages failovers persisted in the state store, so a restart
+// doesn't fail back.
+type Manager struct {
+	store state.Store
+	mu    sync.Mutex
+}
+
+// NewManager creates a new failover manager instance.
+func NewManager(store state.Store) *Manager {
+	return &Manager{store: store}
+}
+
+// Failover activates the failover hub configured for the hub.
+func (m *Manager) Failover(cfg *config.Config, hubName, setBy string) (*Failover, error) {
+	hub := cfg.Hub(hubName)
+	if hub == nil {
+		return nil, fmt.Errorf("hub %s not found", hubName)
+	}
+	if hub.FailoverHub == "" {
+		return nil, fmt.Errorf("hub %s has no failoverHub configured", hubName)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	failovers, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Failover{
+		Hub:         hubName,
+		FailoverHub: hub.FailoverHub,
+		SetBy:       setBy,
+		SetAt:       time.Now().UTC(),
+	}
+	failovers[hubName] = f
+
+	if err := m.store.Put(stateKey, failovers); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Failback returns the subscriptions of the hub to the hub itself.
+func (m *Manager) Failback(hubName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	failovers, err := m.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := failovers[hubName]; !ok {
+		return fmt.Errorf("hub %s is not failed over", hubName)
+	}
+	delete(failovers, hubName)
+
+	return m.store.Put(stateKey, failovers)
+}
+
+// List returns the active failovers.
+func (m *Manager) List() ([]*Failover, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	failovers, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Failover, 0, len(failovers))
+	for _, f := range failovers {
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+// ActiveHub returns the hub subscriptions assigned to hubName are enforced
+// against: the failover hub while a failover is active, the hub otherwise.
+func (m *Manager) ActiveHub(cfg *config.Config, hubName string) (*config.HubVNetConfig, error) {
+	m.mu.Lock()
+	failovers, err := m.load()
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	name := hubName
+	if f, ok := failovers[hubName]; ok {
+		name = f.FailoverHub
+	}
+
+	hub := cfg.Hub(name)
+	if hub == nil {
+		return nil, &HubNotFoundError{Name: name}
+	}
+	return hub, nil
+}
+
+// HubNotFoundError is returned for a hub name, or failover hub name, that
+// isn't configured.
+type HubNotFoundError struct {
+	Name string
+}
+
+func (e *HubNotFoundError) Error() string {
+	return fmt.Sprintf("hub %s not found", e.Name)
+}
+
+// load reads the failovers from the state store.
+func (m *Manager) load() (map[string]*Failover, error) {
+	failovers := make(map[string]*Failover)
+	if err := m.store.Get(stateKey, &failovers); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load failovers: %w", err)
+	}
+	return failovers, nil
+}
@@ -0,0 +1,149 @@
+package findings
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/trace"
+)
+
+// Compliant is a resource that passed a rule.
+type Compliant struct {
+	RuleID         string `json:"ruleId"`
+	SubscriptionID string `json:"subscriptionId"`
+	ResourceID     string `json:"resourceId"`
+}
+
+// Summary counts the evaluated resources per rule. It is always complete,
+// regardless of what reports and logs leave out.
+type Summary struct {
+	Compliant    map[string]int `json:"compliant"`
+	NonCompliant map[string]int `json:"nonCompliant"`
+}
+
+// ComplianceLog records the compliant resources of a run. Log lines are
+// sampled and the records are only kept if reports include them, up to
+// maxRecords; the counts are always kept.
+type ComplianceLog struct {
+	sampleRate int
+	debug      bool
+	keep       bool
+	maxRecords int
+
+	mu      sync.Mutex
+	seen    int
+	counts  map[string]int
+	records []Compliant
+	// subscriptions counts the compliant resources per subscription.
+	subscriptions map[string]int
+	tracer        *trace.Tracer
+}
+
+// NewComplianceLog creates a new compliance log for the configuration.
+func NewComplianceLog(cfg *config.Config) *ComplianceLog {
+	return &ComplianceLog{
+		sampleRate:    cfg.Logging.EffectiveCompliantSampleRate(),
+		debug:         strings.EqualFold(cfg.Logging.Level, "debug"),
+		keep:          cfg.Reports.IncludesCompliant(),
+		maxRecords:    cfg.Reports.EffectiveMaxCompliantRecords(),
+		counts:        make(map[string]int),
+		subscriptions: make(map[string]int),
+	}
+}
+
+// SetTracer logs every record of a traced resource, regardless of sampling.
+func (l *ComplianceLog) SetTracer(tracer *trace.Tracer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tracer = tracer
+}
+
+// Record records a resource that passed the rule.
+func (l *ComplianceLog) Record(rule Rule, subscriptionID, resourceID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.counts[rule.ID]++
+	l.subscriptions[subscriptionID]++
+	if l.keep && len(l.records) < l.maxRecords {
+		l.records = append(l.records, Compliant{RuleID: rule.ID, SubscriptionID: subscriptionID, ResourceID: resourceID})
+	}
+
+	// the first one is always logged, so the log shows evaluation happened
+	if l.debug || l.seen%l.sampleRate == 0 {
+		fmt.Printf("compliant, no action: %s %s\n", rule.ID, resourceID)
+	}
+	l.seen++
+	l.tracer.Printf(resourceID, "compliant with %s", rule.ID)
+}
+
+// Merge adds the records and counts of other to the log.
+func (l *ComplianceLog) Merge(other *ComplianceLog) {
+	other.mu.Lock()
+	counts := make(map[string]int, len(other.counts))
+	for ruleID, n := range other.counts {
+		counts[ruleID] = n
+	}
+	subscriptions := make(map[string]int, len(other.subscriptions))
+	for subID, n := range other.subscriptions {
+		subscriptions[subID] = n
+	}
+	records := append([]Compliant(nil), other.records...)
+	other.mu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ruleID, n := range counts {
+		l.counts[ruleID] += n
+	}
+	for subID, n := range subscriptions {
+		l.subscriptions[subID] += n
+	}
+	if l.keep {
+		if room := l.maxRecords - len(l.records); len(records) > room {
+			records = records[:max(room, 0)]
+		}
+		l.records = append(l.records, records...)
+	}
+}
+
+// Records returns the compliant resources, empty if reports exclude them, at
+// most maxRecords of them.
+func (l *ComplianceLog) Records() []Compliant {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Compliant(nil), l.records...)
+}
+
+// BySubscription returns the number of compliant resources per subscription.
+func (l *ComplianceLog) BySubscription() map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	counts := make(map[string]int, len(l.subscriptions))
+	for subID, n := range l.subscriptions {
+		counts[subID] = n
+	}
+	return counts
+}
+
+// Summarize counts the compliant resources of the log and the findings per rule.
+func Summarize(log *ComplianceLog, all []Finding) Summary {
+	summary := Summary{
+		Compliant:    make(map[string]int),
+		NonCompliant: make(map[string]int),
+	}
+
+	log.mu.Lock()
+	for ruleID, n := range log.counts {
+		summary.Compliant[ruleID] = n
+	}
+	log.mu.Unlock()
+
+	for _, f := range all {
+		summary.NonCompliant[f.RuleID]++
+	}
+	return summary
+}
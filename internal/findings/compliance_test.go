@@ -0,0 +1,32 @@
+package findings
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/akos011221/velora/internal/config"
+)
+
+func TestComplianceLogMaxRecords(t *testing.T) {
+	cfg := &config.Config{Reports: config.ReportsConfig{MaxCompliantRecords: 5}}
+	log := NewComplianceLog(cfg)
+	other := NewComplianceLog(cfg)
+	for i := 0; i < 4; i++ {
+		log.Record(RuleDefaultRoute, "sub", fmt.Sprintf("/subnets/%d", i))
+		other.Record(RuleNSGMissing, "other", fmt.Sprintf("/nsgs/%d", i))
+	}
+	log.Merge(other)
+	log.Record(RuleDefaultRoute, "sub", "/subnets/last")
+
+	// records are capped, the counts stay exact
+	if got := len(log.Records()); got != 5 {
+		t.Errorf("records = %d, want the cap of 5", got)
+	}
+	summary := Summarize(log, nil)
+	if summary.Compliant[RuleDefaultRoute.ID] != 5 || summary.Compliant[RuleNSGMissing.ID] != 4 {
+		t.Errorf("compliant counts = %v, want 5 and 4", summary.Compliant)
+	}
+	if got := log.BySubscription(); got["sub"] != 5 || got["other"] != 4 {
+		t.Errorf("BySubscription() = %v, want 5 and 4", got)
+	}
+}
@@ -0,0 +1,22 @@
+package findings
+
+import "strings"
+
+// ExemptionTag exempts a resource from the rules listed in its value,
+// comma separated, or from every rule that honours it with "*".
+const ExemptionTag = "velora-exempt"
+
+// Exempt reports whether the tags of a resource exempt it from the rule.
+func Exempt(tags map[string]*string, ruleID string) bool {
+	for key, value := range tags {
+		if !strings.EqualFold(key, ExemptionTag) || value == nil {
+			continue
+		}
+		for _, rule := range strings.Split(*value, ",") {
+			if rule = strings.TrimSpace(rule); rule == "*" || strings.EqualFold(rule, ruleID) {
+				return true
+			}
+		}
+	}
+	return false
+}
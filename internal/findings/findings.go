@@ -0,0 +1,210 @@
+package findings
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/akos011221/velora/internal/breakglass"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/version"
+)
+
+// Severity is the severity of a finding.
+type Severity string
+
+const (
+	SeverityCritical Severity = "critical"
+	SeverityHigh     Severity = "high"
+	SeverityMedium   Severity = "medium"
+	SeverityLow      Severity = "low"
+	SeverityInfo     Severity = "info"
+)
+
+// severityRanks orders severities from least to most severe.
+var severityRanks = map[Severity]int{
+	SeverityInfo:     0,
+	SeverityLow:      1,
+	SeverityMedium:   2,
+	SeverityHigh:     3,
+	SeverityCritical: 4,
+}
+
+// AtLeast reports whether s is at least as severe as threshold.
+func (s Severity) AtLeast(threshold Severity) bool {
+	return severityRanks[s] >= severityRanks[threshold]
+}
+
+// Valid reports whether s is a known severity.
+func (s Severity) Valid() bool {
+	_, ok := severityRanks[s]
+	return ok
+}
+
+// Finding is a single non-compliance detected on a resource.
+type Finding struct {
+	RuleID         string   `json:"ruleId"`
+	Severity       Severity `json:"severity"`
+	SubscriptionID string   `json:"subscriptionId"`
+	ResourceID     string   `json:"resourceId"`
+	Message        string   `json:"message"`
+	Remediation    string   `json:"remediation,omitempty"`
+	DocsURL        string   `json:"docsUrl,omitempty"`
+	// Ownership is the ownership of the subscription, nil if none is
+	// configured.
+	Ownership *config.Ownership `json:"ownership,omitempty"`
+	// BaseSeverity is the severity of the rule when a severity modifier
+	// changed it, SeverityReason says why.
+	BaseSeverity   Severity `json:"baseSeverity,omitempty"`
+	SeverityReason string   `json:"severityReason,omitempty"`
+	// Caller is the last write of the resource before the finding was
+	// first reported, from the Activity Log, nil if unknown.
+	Caller *Caller `json:"caller,omitempty"`
+}
+
+// Caller is a write of a resource recorded in the Activity Log.
+type Caller struct {
+	// Identity is the user principal name, or the application or object
+	// ID of a service principal.
+	Identity  string    `json:"identity"`
+	Operation string    `json:"operation"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// String describes the write, e.g. "alice@contoso.com, Microsoft.Network/routeTables/write at 2024-05-01T10:00:00Z".
+func (c *Caller) String() string {
+	return fmt.Sprintf("%s, %s at %s", c.Identity, c.Operation, c.Timestamp.UTC().Format(time.RFC3339))
+}
+
+// severitiesByRank are the severities indexed by their rank.
+var severitiesByRank = []Severity{SeverityInfo, SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical}
+
+// AdjustSeverity moves the severity of the finding by steps, clamped between
+// info and critical, and records the rule's severity and the reason. A
+// finding is adjusted once.
+func (f *Finding) AdjustSeverity(steps int, reason string) {
+	if steps == 0 || f.BaseSeverity != "" || !f.Severity.Valid() {
+		return
+	}
+	rank := min(max(severityRanks[f.Severity]+steps, 0), len(severitiesByRank)-1)
+	if severitiesByRank[rank] == f.Severity {
+		return
+	}
+	f.BaseSeverity = f.Severity
+	f.Severity = severitiesByRank[rank]
+	verb := "raised"
+	if steps < 0 {
+		verb = "lowered"
+	}
+	f.SeverityReason = fmt.Sprintf("%s %s to %s: %s", verb, f.BaseSeverity, f.Severity, reason)
+}
+
+// AttachOwnership sets the ownership of the findings from the configuration
+// of their subscriptions.
+func AttachOwnership(all []Finding, cfg *config.Config) {
+	for i := range all {
+		if subCFG, ok := cfg.Subscriptions[all[i].SubscriptionID]; ok {
+			all[i].Ownership = subCFG.Ownership()
+		}
+	}
+}
+
+// Metadata identifies what produced a set of findings, it's stamped on every
+// report and run record.
+type Metadata struct {
+	SchemaVersion  int    `json:"schemaVersion"`
+	VeloraVersion  string `json:"veloraVersion"`
+	Commit         string `json:"commit"`
+	ConfigHash     string `json:"configHash"`
+	RuleSetVersion string `json:"ruleSetVersion"`
+	// NextHops are the next hops enforced per hub, as resolved for the run.
+	NextHops map[string]string `json:"nextHops,omitempty"`
+	// DiscoveredHubs are the VNets of the hubs discovered from their tags,
+	// by hub name.
+	DiscoveredHubs map[string]string `json:"discoveredHubs,omitempty"`
+	// ReadOnly is set if the run made no changes because of read-only mode.
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// Shard is the shard the run enforced as index/count, empty unless sharded.
+	Shard string `json:"shard,omitempty"`
+	// Role is the role the run ran, config.Config.Role, empty if it ran
+	// every controller.
+	Role string `json:"role,omitempty"`
+	// QueueItem is the ID of the work queue item that triggered the run,
+	// empty for runs of the whole configuration.
+	QueueItem string `json:"queueItem,omitempty"`
+	// Scope is the resource group or VNet the run was limited to, empty
+	// for runs covering whole subscriptions.
+	Scope string `json:"scope,omitempty"`
+	// BreakGlass is the break-glass activation the run was executed under.
+	BreakGlass *breakglass.Activation `json:"breakGlass,omitempty"`
+	// StagedConfig is the hash of the config the run loaded, if it was
+	// staged rather than active.
+	StagedConfig string `json:"stagedConfig,omitempty"`
+}
+
+// NewMetadata returns the metadata for findings produced with the given config.
+func NewMetadata(cfg *config.Config) Metadata {
+	info := version.Get()
+	return Metadata{
+		SchemaVersion:  info.ReportSchemaVersion,
+		VeloraVersion:  info.Version,
+		Commit:         info.Commit,
+		ConfigHash:     cfg.Hash(),
+		RuleSetVersion: RuleSetVersion(),
+		ReadOnly:       cfg.ReadOnly,
+	}
+}
+
+// Rule describes a compliance rule and how to remediate its findings.
+type Rule struct {
+	ID       string
+	Severity Severity
+	// Remediation is a text/template rendered with the finding data.
+	Remediation string
+	// Fallback is used when the template can't be rendered, e.g. because
+	// some of the placeholder data is missing.
+	Fallback string
+}
+
+// New creates a finding for the rule, rendering its remediation hint with the
+// given data and attaching the documentation link configured for the rule.
+func New(rule Rule, rules map[string]config.RuleConfig, subscriptionID, resourceID, message string, data map[string]string) Finding {
+	return Finding{
+		RuleID:         rule.ID,
+		Severity:       rule.Severity,
+		SubscriptionID: subscriptionID,
+		ResourceID:     resourceID,
+		Message:        message,
+		Remediation:    rule.RenderRemediation(data),
+		DocsURL:        rules[rule.ID].DocsURL,
+	}
+}
+
+// RenderRemediation renders the remediation template of the rule, falling
+// back to the static hint when placeholder data is missing.
+func (r Rule) RenderRemediation(data map[string]string) string {
+	if r.Remediation == "" {
+		return r.Fallback
+	}
+
+	tmpl, err := template.New(r.ID).Option("missingkey=error").Parse(r.Remediation)
+	if err != nil {
+		return r.Fallback
+	}
+
+	// empty values would produce misleading instructions, they count as
+	// missing. Keys the template doesn't use may be empty.
+	present := make(map[string]string, len(data))
+	for k, v := range data {
+		if v != "" {
+			present[k] = v
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, present); err != nil {
+		return r.Fallback
+	}
+	return buf.String()
+}
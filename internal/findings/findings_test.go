@@ -0,0 +1,372 @@
+package findings
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+	"text/template/parse"
+)
+
+func TestRenderRemediation(t *testing.T) {
+	rule := Rule{
+		ID:          "test/rule",
+		Remediation: "add a route {{.prefix}} -> {{.nextHop}} to route table {{.routeTable}}",
+		Fallback:    "add the route to the route table",
+	}
+	tests := []struct {
+		name string
+		rule Rule
+		data map[string]string
+		want string
+	}{
+		{
+			name: "rendered",
+			rule: rule,
+			data: map[string]string{"prefix": "0.0.0.0/0", "nextHop": "10.0.0.4", "routeTable": "spoke-rt"},
+			want: "add a route 0.0.0.0/0 -> 10.0.0.4 to route table spoke-rt",
+		},
+		{
+			name: "missing key",
+			rule: rule,
+			data: map[string]string{"prefix": "0.0.0.0/0", "nextHop": "10.0.0.4"},
+			want: rule.Fallback,
+		},
+		{
+			name: "nil data",
+			rule: rule,
+			want: rule.Fallback,
+		},
+		{
+			name: "empty value",
+			rule: rule,
+			data: map[string]string{"prefix": "0.0.0.0/0", "nextHop": "", "routeTable": "spoke-rt"},
+			want: rule.Fallback,
+		},
+		{
+			name: "unused key empty",
+			rule: rule,
+			data: map[string]string{"prefix": "0.0.0.0/0", "nextHop": "10.0.0.4", "routeTable": "spoke-rt", "hub": ""},
+			want: "add a route 0.0.0.0/0 -> 10.0.0.4 to route table spoke-rt",
+		},
+		{
+			name: "unparseable template",
+			rule: Rule{ID: "test/rule", Remediation: "add a route {{.prefix", Fallback: rule.Fallback},
+			data: map[string]string{"prefix": "0.0.0.0/0"},
+			want: rule.Fallback,
+		},
+		{
+			name: "no template",
+			rule: Rule{ID: "test/rule", Fallback: rule.Fallback},
+			data: map[string]string{"prefix": "0.0.0.0/0"},
+			want: rule.Fallback,
+		},
+		{
+			name: "static template",
+			rule: Rule{ID: "test/rule", Remediation: "remove the route", Fallback: rule.Fallback},
+			want: "remove the route",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.RenderRemediation(tt.data); got != tt.want {
+				t.Errorf("RenderRemediation() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// callSite is data given as a map literal for a rule, to New or to a
+// variable passed to New later.
+type callSite struct {
+	pos  string
+	rule string
+	keys []string
+	// empty are the keys given an empty string literal.
+	empty []string
+}
+
+// TestRemediationTemplatesMatchCallers checks the remediation of every rule
+// parses, and only uses keys each caller passes for it, so no finding falls
+// back for lack of data. Every rule with a template the code uses must
+// have its data checked.
+func TestRemediationTemplatesMatchCallers(t *testing.T) {
+	byName := ruleNames(t)
+	sites, referenced := newCallSites(t, filepath.Join("..", ".."))
+
+	used := make(map[string][]string)
+	for _, rule := range allRules {
+		if rule.Remediation == "" {
+			continue
+		}
+		tmpl, err := template.New(rule.ID).Parse(rule.Remediation)
+		if err != nil {
+			t.Errorf("remediation of rule %s doesn't parse: %v", rule.ID, err)
+			continue
+		}
+		if rule.Fallback == "" {
+			t.Errorf("rule %s has a remediation template but no fallback", rule.ID)
+		}
+		used[rule.ID] = templateKeys(tmpl.Root)
+	}
+
+	checked := make(map[string]bool)
+	for _, site := range sites {
+		id, ok := byName[site.rule]
+		if !ok {
+			t.Errorf("%s: unknown rule %s", site.pos, site.rule)
+			continue
+		}
+		checked[id] = true
+		for _, key := range used[id] {
+			if !slices.Contains(site.keys, key) {
+				t.Errorf("%s: rule %s uses .%s in its remediation, the caller passes %v", site.pos, id, key, site.keys)
+			}
+			if slices.Contains(site.empty, key) {
+				t.Errorf("%s: rule %s uses .%s in its remediation, the caller passes it empty", site.pos, id, key)
+			}
+		}
+	}
+	for name := range referenced {
+		if id := byName[name]; used[id] != nil && !checked[id] {
+			t.Errorf("rule %s is used, but no data passed for it was found to check its remediation against", id)
+		}
+	}
+}
+
+// ruleNames maps the names of the rule variables in rules.go to the IDs of
+// the rules.
+func ruleNames(t *testing.T) map[string]string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "rules.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]string)
+	ast.Inspect(file, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok || len(spec.Names) != 1 || len(spec.Values) != 1 {
+			return true
+		}
+		lit, ok := spec.Values[0].(*ast.CompositeLit)
+		if !ok {
+			return true
+		}
+		for _, elt := range lit.Elts {
+			kv, ok := elt.(*ast.KeyValueExpr)
+			if !ok {
+				continue
+			}
+			if key, ok := kv.Key.(*ast.Ident); ok && key.Name == "ID" {
+				if value, ok := kv.Value.(*ast.BasicLit); ok {
+					names[spec.Names[0].Name], _ = strconv.Unquote(value.Value)
+				}
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// newCallSites returns the data given for the rules under the root, outside
+// tests, and the names of the rules referenced. Data is found in:
+//   - calls of New with a rule variable, or a variable assigned rules in the
+//     function, and a map literal
+//   - map literals assigned to a field of a value, e.g. target.findingData,
+//     after a rule was assigned to another of its fields
+func newCallSites(t *testing.T, root string) ([]callSite, map[string]bool) {
+	t.Helper()
+	fset := token.NewFileSet()
+	var sites []callSite
+	referenced := make(map[string]bool)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name := d.Name(); name == "testdata" || (strings.HasPrefix(name, ".") && name != "." && name != "..") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+		inFindings := file.Name.Name == "findings"
+		if inFindings && filepath.Base(path) == "rules.go" {
+			return nil
+		}
+		site := func(rule string, data *ast.CompositeLit) callSite {
+			site := callSite{pos: fset.Position(data.Pos()).String(), rule: rule}
+			for _, elt := range data.Elts {
+				kv, ok := elt.(*ast.KeyValueExpr)
+				if !ok {
+					continue
+				}
+				key, ok := kv.Key.(*ast.BasicLit)
+				if !ok || key.Kind != token.STRING {
+					continue
+				}
+				name, _ := strconv.Unquote(key.Value)
+				site.keys = append(site.keys, name)
+				if value, ok := kv.Value.(*ast.BasicLit); ok && value.Value == `""` {
+					site.empty = append(site.empty, name)
+				}
+			}
+			return site
+		}
+
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil {
+				continue
+			}
+			// rules assigned to each variable, and the last rule assigned
+			// to a field of each value
+			assigned := make(map[string][]string)
+			lastRule := make(map[string]string)
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.SelectorExpr, *ast.Ident:
+					if rule := ruleName(n.(ast.Expr), inFindings); rule != "" {
+						referenced[rule] = true
+					}
+				case *ast.AssignStmt:
+					for i, lhs := range n.Lhs {
+						if i >= len(n.Rhs) {
+							break
+						}
+						if rule := ruleName(n.Rhs[i], inFindings); rule != "" {
+							assigned[types.ExprString(lhs)] = append(assigned[types.ExprString(lhs)], rule)
+							if sel, ok := lhs.(*ast.SelectorExpr); ok {
+								lastRule[types.ExprString(sel.X)] = rule
+							}
+							continue
+						}
+						data, ok := n.Rhs[i].(*ast.CompositeLit)
+						sel, isField := lhs.(*ast.SelectorExpr)
+						if ok && isField && isStringMap(data) && lastRule[types.ExprString(sel.X)] != "" {
+							sites = append(sites, site(lastRule[types.ExprString(sel.X)], data))
+						}
+					}
+				case *ast.CallExpr:
+					if len(n.Args) != 6 || !isNewCall(n.Fun, inFindings) {
+						return true
+					}
+					data, ok := n.Args[5].(*ast.CompositeLit)
+					if !ok {
+						return true
+					}
+					rules := assigned[types.ExprString(n.Args[0])]
+					if rule := ruleName(n.Args[0], inFindings); rule != "" {
+						rules = []string{rule}
+					}
+					for _, rule := range rules {
+						sites = append(sites, site(rule, data))
+					}
+				}
+				return true
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sites, referenced
+}
+
+// isStringMap reports whether the literal is a map[string]string.
+func isStringMap(lit *ast.CompositeLit) bool {
+	m, ok := lit.Type.(*ast.MapType)
+	if !ok {
+		return false
+	}
+	key, keyOK := m.Key.(*ast.Ident)
+	value, valueOK := m.Value.(*ast.Ident)
+	return keyOK && valueOK && key.Name == "string" && value.Name == "string"
+}
+
+// isNewCall reports whether the function called is findings.New.
+func isNewCall(fun ast.Expr, inFindings bool) bool {
+	switch fun := fun.(type) {
+	case *ast.SelectorExpr:
+		pkg, ok := fun.X.(*ast.Ident)
+		return ok && pkg.Name == "findings" && fun.Sel.Name == "New"
+	case *ast.Ident:
+		return inFindings && fun.Name == "New"
+	}
+	return false
+}
+
+// ruleName returns the name of the rule variable passed, empty if it isn't
+// one, e.g. a rule chosen at run time.
+func ruleName(arg ast.Expr, inFindings bool) string {
+	switch arg := arg.(type) {
+	case *ast.SelectorExpr:
+		if pkg, ok := arg.X.(*ast.Ident); ok && pkg.Name == "findings" && strings.HasPrefix(arg.Sel.Name, "Rule") {
+			return arg.Sel.Name
+		}
+	case *ast.Ident:
+		if inFindings && strings.HasPrefix(arg.Name, "Rule") {
+			return arg.Name
+		}
+	}
+	return ""
+}
+
+// templateKeys returns the keys of the data the template uses, sorted.
+func templateKeys(node parse.Node) []string {
+	var keys []string
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch node := node.(type) {
+		case *parse.ListNode:
+			if node != nil {
+				for _, n := range node.Nodes {
+					walk(n)
+				}
+			}
+		case *parse.ActionNode:
+			walk(node.Pipe)
+		case *parse.PipeNode:
+			if node != nil {
+				for _, cmd := range node.Cmds {
+					walk(cmd)
+				}
+			}
+		case *parse.CommandNode:
+			for _, arg := range node.Args {
+				walk(arg)
+			}
+		case *parse.FieldNode:
+			keys = append(keys, node.Ident[0])
+		case *parse.IfNode:
+			walk(node.Pipe)
+			walk(node.List)
+			walk(node.ElseList)
+		case *parse.RangeNode:
+			walk(node.Pipe)
+			walk(node.List)
+			walk(node.ElseList)
+		case *parse.WithNode:
+			walk(node.Pipe)
+			walk(node.List)
+			walk(node.ElseList)
+		}
+	}
+	walk(node)
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
@@ -0,0 +1,430 @@
+package findings
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Routing rules.
+var (
+	RuleDefaultRoute = Rule{
+		ID:          "routing/default-route",
+		Severity:    SeverityHigh,
+		Remediation: "add a route {{.prefix}} -> {{.nextHop}} to route table {{.routeTable}}",
+		Fallback:    "add a default route pointing to the hub NVA to the subnet's route table",
+	}
+	RuleRouteTableMissing = Rule{
+		ID:          "routing/route-table-missing",
+		Severity:    SeverityHigh,
+		Remediation: "associate a route table with subnet {{.subnet}} and add a route 0.0.0.0/0 -> {{.nextHop}}",
+		Fallback:    "associate a route table with the subnet and add a default route pointing to the hub NVA",
+	}
+	RuleOnPremOverride = Rule{
+		ID:          "routing/onprem-override",
+		Severity:    SeverityHigh,
+		Remediation: "add a route {{.prefix}} -> {{.nextHop}} to route table {{.routeTable}} so the on-prem prefix traverses the NVA instead of the gateway",
+		Fallback:    "route the overridden on-prem prefixes through the hub NVA",
+	}
+	RuleStaleOnPremOverride = Rule{
+		ID:          "routing/stale-onprem-override",
+		Severity:    SeverityLow,
+		Remediation: "remove route {{.route}} for {{.prefix}} from route table {{.routeTable}}, the prefix is no longer overridden",
+		Fallback:    "remove the managed on-prem override routes of prefixes no longer overridden",
+	}
+	RuleUnownedNextHop = Rule{
+		ID:          "routing/unowned-next-hop",
+		Severity:    SeverityCritical,
+		Remediation: "fix nvaNextHop {{.nextHop}} of hub {{.hub}}: {{.reason}}, routes to it blackhole traffic",
+		Fallback:    "point nvaNextHop of the hub to the IP of the NVA, or of the load balancer in front of it",
+	}
+	RuleNextHopDegraded = Rule{
+		ID:          "routing/next-hop-degraded",
+		Severity:    SeverityHigh,
+		Remediation: "routes of hub {{.hub}} use secondary next hop {{.nextHop}} instead of {{.primary}}: {{.reason}}",
+		Fallback:    "restore the primary NVA of the hub, routes move back to it once it is healthy again",
+	}
+	RuleForbiddenNextHop = Rule{
+		ID:          "routing/forbidden-next-hop",
+		Severity:    SeverityHigh,
+		Remediation: "remove route {{.route}} for {{.prefix}} with next hop {{.nextHopType}} from route table {{.routeTable}}, or point it to the NVA",
+		Fallback:    "remove the routes bypassing the NVA from the spoke route table, or point them to the NVA",
+	}
+	RuleSubnetIsolation = Rule{
+		ID:          "routing/subnet-isolation",
+		Severity:    SeverityMedium,
+		Remediation: "add a route {{.prefix}} -> {{.nextHop}} to route table {{.routeTable}} so traffic to subnet {{.targetSubnet}} traverses the NVA",
+		Fallback:    "route traffic to the other subnets of the VNet through the hub NVA",
+	}
+	RuleSubnetClassExempt = Rule{
+		ID:          "routing/subnet-class-exempt",
+		Severity:    SeverityInfo,
+		Remediation: "subnet {{.subnet}} is an {{.class}} subnet, which breaks with a default route to the NVA alone, so it is exempt; set enforceWithCompatRoutes to route it through the NVA with the {{.profile}} compatibility routes",
+		Fallback:    "the subnet's service breaks with a default route to the NVA alone, it is exempt from the default route",
+	}
+	RuleCompatProfile = Rule{
+		ID:          "routing/compat-profile",
+		Severity:    SeverityInfo,
+		Remediation: "subnet {{.subnet}} is an {{.class}} subnet, routed under the {{.profile}} compatibility profile: the default route to the NVA plus {{.routes}} to the Internet, so its route table differs from plain spokes",
+		Fallback:    "the subnet is routed under a compatibility profile, its route table has the routes its service requires besides the default route",
+	}
+	RuleSubnetClassConflict = Rule{
+		ID:          "routing/subnet-class-conflict",
+		Severity:    SeverityMedium,
+		Remediation: "{{.reason}}; give the subnet classes of hub {{.hub}} different priorities, velora doesn't route subnet {{.subnet}} until then",
+		Fallback:    "the subnet matches several subnet classes with the same priority, give them different priorities",
+	}
+	RuleSubnetClassDefaultRoute = Rule{
+		ID:          "routing/subnet-class-default-route",
+		Severity:    SeverityLow,
+		Remediation: "delete route {{.route}} for {{.prefix}} from route table {{.routeTable}}, subnet {{.subnet}} is of subnet class {{.class}} which excludes the default route to the NVA",
+		Fallback:    "delete the default route to the NVA, the subnet's class excludes it",
+	}
+	RuleBGPPropagation = Rule{
+		ID:          "routing/bgp-propagation",
+		Severity:    SeverityMedium,
+		Remediation: "set BGP route propagation of route table {{.routeTable}} to {{.want}}, subnet {{.subnet}} of subnet class {{.class}} requires it; velora doesn't change it, it applies to every subnet of the route table",
+		Fallback:    "set the BGP route propagation of the route table to what the subnet's class requires",
+	}
+	RuleSharedRouteTableConflict = Rule{
+		ID:          "routing/shared-route-table-conflict",
+		Severity:    SeverityMedium,
+		Remediation: "route table {{.routeTable}} is shared by subnets whose policies want different routes: {{.subnets}}; associate a route table of its own with each policy, velora doesn't modify it until then",
+		Fallback:    "the route table is shared by subnets whose policies want different routes, associate a route table of its own with each policy",
+	}
+	RuleServiceRouteTable = Rule{
+		ID:          "routing/service-route-table",
+		Severity:    SeverityInfo,
+		Remediation: "route table {{.routeTable}} is managed by {{.service}} and not modified in {{.mode}} mode; set serviceRouteTables to coexist to add velora's routes alongside the service's, which are never touched",
+		Fallback:    "the route table is managed by a service like AKS or Databricks, velora doesn't modify it",
+	}
+	RuleAsymmetricRouting = Rule{
+		ID:          "routing/asymmetric-routing",
+		Severity:    SeverityMedium,
+		Remediation: "VNet {{.vnet}} is {{.posture}} while {{.peers}} route through the NVA of hub {{.hub}}; traffic between them passes the NVA one way only and stateful firewalls drop the return path, route every subnet of {{.vnet}} through the NVA",
+		Fallback:    "the spoke doesn't route through the hub NVA like the other spokes of its hub, so traffic between them is asymmetric; route all of its subnets through the NVA",
+	}
+)
+
+// Peering rules.
+var (
+	RulePeeringAddressSpaceSync = Rule{
+		ID:          "peering/address-space-sync",
+		Severity:    SeverityHigh,
+		Remediation: "sync peering {{.peering}} with the current address space of VNet {{.vnet}} ({{.prefixes}}), traffic to new prefixes is dropped until then",
+		Fallback:    "sync the hub peering with the current address space of the spoke VNet",
+	}
+	RuleHubPeeringMissing = Rule{
+		ID:          "peering/hub-peering-missing",
+		Severity:    SeverityHigh,
+		Remediation: "peer VNet {{.vnet}} with hub {{.hub}}",
+		Fallback:    "peer the spoke VNet with its hub",
+	}
+	RuleHubSidePeering = Rule{
+		ID:          "peering/hub-side",
+		Severity:    SeverityHigh,
+		Remediation: "the hub side of the peering of VNet {{.vnet}} with hub {{.hub}} is {{.state}}, run with access to the hub: {{.command}}",
+		Fallback:    "fix the hub side of the spoke's peering, with access to the hub",
+	}
+	RuleRemoteGateways = Rule{
+		ID:          "peering/remote-gateways",
+		Severity:    SeverityMedium,
+		Remediation: "set useRemoteGateways={{.expected}} on peering {{.peering}}",
+		Fallback:    "align useRemoteGateways on the hub peering with the hub's gateway transit setting",
+	}
+	RulePeeringMisnamed = Rule{
+		ID:          "peering/misnamed",
+		Severity:    SeverityLow,
+		Remediation: "peering {{.peering}} of VNet {{.vnet}} with hub {{.hub}} should be named {{.expected}}; peerings can't be renamed, re-create both sides in a maintenance window or set peering.recreateMisnamedPeerings",
+		Fallback:    "re-create the hub peering with the name of the naming template, in a maintenance window",
+	}
+	RuleHubCapacityWarning = Rule{
+		ID:          "peering/hub-capacity-warning",
+		Severity:    SeverityMedium,
+		Remediation: "hub {{.hub}} is at {{.utilization}} of its design capacity: {{.usage}}; plan another hub or raise its capacity before onboarding more spokes",
+		Fallback:    "the hub approaches the number of spokes or spoke prefixes it was designed for, plan another hub before onboarding more spokes",
+	}
+	RuleHubCapacityExceeded = Rule{
+		ID:          "peering/hub-capacity-exceeded",
+		Severity:    SeverityCritical,
+		Remediation: "hub {{.hub}} is past its design capacity: {{.usage}}; move spokes to another hub, or review the hub's design and raise its capacity",
+		Fallback:    "the hub has more spokes or spoke prefixes than it was designed for, move spokes to another hub",
+	}
+	RuleHubAtCapacity = Rule{
+		ID:          "peering/hub-at-capacity",
+		Severity:    SeverityHigh,
+		Remediation: "{{.action}} was refused, hub {{.hub}} is at capacity: {{.usage}}; peer VNet {{.vnet}} with another hub, or raise the capacity of hub {{.hub}}",
+		Fallback:    "the hub is at the capacity it was designed for and refuses new spokes, peer the VNet with another hub",
+	}
+)
+
+// Virtual WAN rules.
+var (
+	RuleVWANConnectionMissing = Rule{
+		ID:          "vwan/connection-missing",
+		Severity:    SeverityHigh,
+		Remediation: "connect VNet {{.vnet}} to virtual hub {{.hub}}",
+		Fallback:    "connect the spoke VNet to its virtual hub",
+	}
+	RuleVWANAssociation = Rule{
+		ID:          "vwan/route-table-association",
+		Severity:    SeverityHigh,
+		Remediation: "associate connection {{.connection}} with route table {{.routeTable}} of virtual hub {{.hub}}",
+		Fallback:    "associate the hub connection with the configured route table of the virtual hub",
+	}
+	RuleVWANDefaultRoute = Rule{
+		ID:          "vwan/default-route",
+		Severity:    SeverityHigh,
+		Remediation: "add a route 0.0.0.0/0 -> {{.nextHop}} to route table {{.routeTable}} of virtual hub {{.hub}}",
+		Fallback:    "add a default route to the firewall or NVA to the route table of the virtual hub",
+	}
+)
+
+// Gateway rules.
+var (
+	RuleSpokeGateway = Rule{
+		ID:          "gateway/spoke-gateway",
+		Severity:    SeverityCritical,
+		Remediation: "remove gateway {{.gateway}} (SKU {{.sku}}, {{.connections}} connections) from the spoke VNet, on-premises traffic must go through the hub",
+		Fallback:    "remove the gateway from the spoke VNet, only hub VNets may contain gateways",
+	}
+)
+
+// Egress rules.
+var (
+	RuleEgressNATGateway = Rule{
+		ID:          "egress/nat-gateway",
+		Severity:    SeverityHigh,
+		Remediation: "subnet {{.subnet}} sends its outbound traffic through NAT gateway {{.natGateway}} ({{.publicIPs}}), bypassing the NVA; detach the NAT gateway from the subnet, or tag the NAT gateway " + ExemptionTag + "=egress/nat-gateway if the direct egress is approved",
+		Fallback:    "the subnet sends its outbound traffic through a NAT gateway, bypassing the NVA; detach the NAT gateway, or tag it " + ExemptionTag + " if the direct egress is approved",
+	}
+	RuleEgressNICPublicIP = Rule{
+		ID:          "egress/nic-public-ip",
+		Severity:    SeverityHigh,
+		Remediation: "NIC {{.nic}} of {{.attachedTo}} in subnet {{.subnet}} has public IP {{.publicIP}}, giving it direct egress that bypasses the NVA; remove the public IP and publish the workload through the hub, or tag the NIC " + ExemptionTag + "=egress/nic-public-ip if it is approved",
+		Fallback:    "the NIC has a public IP, giving it direct egress that bypasses the NVA; remove the public IP, or tag the NIC " + ExemptionTag + " if it is approved",
+	}
+)
+
+// Flow log rules.
+var (
+	RuleFlowLog = Rule{
+		ID:          "flowlogs/nsg-flow-log",
+		Severity:    SeverityMedium,
+		Remediation: "enable flow log {{.flowLog}} on NSG {{.nsg}} targeting {{.target}}",
+		Fallback:    "enable a flow log on the NSG targeting the configured storage account",
+	}
+	RuleFlowLogNoWatcher = Rule{
+		ID:          "flowlogs/no-network-watcher",
+		Severity:    SeverityMedium,
+		Remediation: "enable Network Watcher in region {{.region}} of subscription {{.subscription}}, flow logs can't be created without it",
+		Fallback:    "enable Network Watcher in the region of the NSG",
+	}
+)
+
+// NSG association rules.
+var (
+	RuleNSGMissing = Rule{
+		ID:          "nsg/missing",
+		Severity:    SeverityHigh,
+		Remediation: "associate an NSG with subnet {{.subnet}} of VNet {{.vnet}}",
+		Fallback:    "associate an NSG with the subnet",
+	}
+	RuleNSGBaseline = Rule{
+		ID:          "nsg/baseline",
+		Severity:    SeverityHigh,
+		Remediation: "associate baseline NSG {{.nsg}} with subnet {{.subnet}} of VNet {{.vnet}}, it has {{.current}}",
+		Fallback:    "associate the baseline NSG of the subscription with the subnet",
+	}
+	RuleNSGBaselineRule = Rule{
+		ID:          "nsg/baseline-rule",
+		Severity:    SeverityMedium,
+		Remediation: "add a rule equivalent to baseline rule {{.rule}} to NSG {{.nsg}}: {{.expected}}",
+		Fallback:    "add the baseline rule to the NSG",
+	}
+	RuleNSGUnknownIPGroup = Rule{
+		ID:          "nsg/unknown-ip-group",
+		Severity:    SeverityHigh,
+		Remediation: "create IP Group {{.ipGroup}} or remove it from baseline rule {{.rule}}, the rule can't be checked",
+		Fallback:    "create the IP Group or remove it from the baseline rule",
+	}
+	RuleNSGSubnetIsolation = Rule{
+		ID:          "nsg/subnet-isolation",
+		Severity:    SeverityMedium,
+		Remediation: "add rule {{.rule}} at priority {{.priority}} to NSG {{.nsg}}, denying the traffic from the other subnets of VNet {{.vnet}} to subnet {{.subnet}}",
+		Fallback:    "deny the traffic from the other subnets of the VNet in the NSG of the subnet",
+	}
+	RuleNSGIsolationBand = Rule{
+		ID:          "nsg/isolation-band",
+		Severity:    SeverityHigh,
+		Remediation: "move rule {{.rule}} (priority {{.priority}}) of NSG {{.nsg}} out of priority band {{.band}}, velora owns the band",
+		Fallback:    "move the rule out of the subnet isolation priority band, velora owns the band",
+	}
+	RuleNSGIsolationShadowed = Rule{
+		ID:          "nsg/isolation-shadowed",
+		Severity:    SeverityMedium,
+		Remediation: "rule {{.shadowing}} (priority {{.shadowingPriority}}) of NSG {{.nsg}} allows traffic isolation rule {{.rule}} denies and takes precedence, narrow it or remove it",
+		Fallback:    "narrow or remove the rule allowing the traffic between the subnets before the isolation rules",
+	}
+	RuleNSGUnsupportedSubnet = Rule{
+		ID:          "nsg/unsupported-subnet",
+		Severity:    SeverityInfo,
+		Remediation: "subnet {{.subnet}} of VNet {{.vnet}} can't have an NSG ({{.reason}}), it is excluded from the NSG baseline",
+		Fallback:    "the subnet can't have an NSG, it is excluded from the NSG baseline",
+	}
+)
+
+// General rules.
+var (
+	RuleUnreadableResource = Rule{
+		ID:       "general/unreadable",
+		Severity: SeverityLow,
+		Fallback: "the resource was returned without required fields, it is usually being provisioned or deleted; re-run the scan and check the resource if this persists",
+	}
+	RuleInactiveSubscription = Rule{
+		ID:          "general/inactive-subscription",
+		Severity:    SeverityLow,
+		Remediation: "subscription {{.subscription}} is {{.state}}, reactivate it or remove it from the configuration",
+		Fallback:    "the subscription isn't active, reactivate it or remove it from the configuration",
+	}
+	RuleGracePeriod = Rule{
+		ID:          "general/grace-period",
+		Severity:    SeverityInfo,
+		Remediation: "{{.resource}} was created {{.created}}, its remediation is deferred for {{.remaining}} while it is provisioned",
+		Fallback:    "the resource is new, its remediation is deferred until its grace period ends",
+	}
+	RuleHubNotFound = Rule{
+		ID:          "general/hub-not-found",
+		Severity:    SeverityHigh,
+		Remediation: "hub {{.hub}} of subscription {{.subscription}} isn't configured, its hub checks were skipped; fix hubName or add the hub",
+		Fallback:    "the hub of the subscription isn't configured, its hub checks were skipped; fix hubName or add the hub",
+	}
+	RuleHubTagInvalid = Rule{
+		ID:          "general/hub-tag-invalid",
+		Severity:    SeverityHigh,
+		Remediation: "fix the tags of hub VNet {{.vnet}}: {{.reason}}; hub {{.hub}} isn't discovered until then, its subscriptions are handled as having no hub",
+		Fallback:    "fix the tags of the hub VNet, the hub isn't discovered until then",
+	}
+	RuleUnmanagedSubscription = Rule{
+		ID:          "general/unmanaged-subscription",
+		Severity:    SeverityHigh,
+		Remediation: "{{.kind}} {{.resource}} is in subscription {{.subscription}}, which velora doesn't manage; add the subscription to the configuration or move the {{.kind}}",
+		Fallback:    "the resource is referenced from another subscription velora doesn't manage, add the subscription to the configuration or move the resource",
+	}
+	RuleBlockedByPolicy = Rule{
+		ID:          "general/blocked-by-policy",
+		Severity:    SeverityHigh,
+		Remediation: "remediation of {{.resource}} blocked by Azure Policy {{.assignment}} ({{.link}}); request an exemption for velora's identity, or remediate it manually",
+		Fallback:    "the remediation was blocked by an Azure Policy deny assignment, request an exemption for velora's identity or remediate it manually",
+	}
+	RuleBlockedByQuota = Rule{
+		ID:          "general/blocked-by-quota",
+		Severity:    SeverityHigh,
+		Remediation: "{{.action}} was blocked, {{.scope}} is at {{.used}} of its {{.max}} {{.limit}}; remove unused resources, or request a quota increase and set limits.{{.limit}} of subscription {{.subscription}}",
+		Fallback:    "the change would exceed an Azure limit, remove unused resources or request a quota increase and override the limit of the subscription",
+	}
+	RuleBlockedByUpstream = Rule{
+		ID:          "general/blocked-by-upstream",
+		Severity:    SeverityInfo,
+		Remediation: "{{.controller}} of {{.resource}} was held back: {{.reason}}; resolve the {{.rule}} finding first, it is enforced in the run after",
+		Fallback:    "enforcement was held back by a finding of a controller it depends on, resolve that finding first",
+	}
+	RuleControllerPanic = Rule{
+		ID:          "general/controller-panic",
+		Severity:    SeverityCritical,
+		Remediation: "the {{.controller}} controller panicked enforcing subscription {{.subscription}}: {{.panic}}; the subscription was skipped for the rest of the run, report the stack trace printed by the run",
+		Fallback:    "a controller panicked and the subscription was skipped for the rest of the run, report the stack trace printed by the run",
+	}
+	RuleBreakGlassActive = Rule{
+		ID:          "general/break-glass-active",
+		Severity:    SeverityCritical,
+		Remediation: "subscription {{.subscription}} was enforced under break-glass mode activated by {{.setBy}} until {{.expiresAt}}, reason: {{.reason}}; approvals, onboarding acknowledgment and maintenance windows were bypassed, deactivate it with velora breakglass deactivate once the incident is over",
+		Fallback:    "the subscription was enforced under break-glass mode, approvals, onboarding acknowledgment and maintenance windows were bypassed",
+	}
+	RuleConfigStaged = Rule{
+		ID:          "general/config-staged",
+		Severity:    SeverityHigh,
+		Remediation: "config {{.hash}} is staged instead of replacing the active config {{.activeHash}}, its estimated impact is {{.impact}}; subscription {{.subscription}} is only observed until it is activated with velora config activate --hash {{.hash}}, or the change is reverted",
+		Fallback:    "a config change with a large estimated impact is staged, the subscription is only observed until it is activated with velora config activate",
+	}
+	RulePendingAcknowledgment = Rule{
+		ID:          "general/pending-acknowledgment",
+		Severity:    SeverityInfo,
+		Remediation: "subscription {{.subscription}} is new and only observed, {{.findings}} findings after {{.runs}} runs; review them and run velora subscriptions ack --subscription {{.subscription}} to start remediation",
+		Fallback:    "the subscription is new and only observed, review its findings and acknowledge them to start remediation",
+	}
+	RuleFlappingResource = Rule{
+		ID:          "general/flapping-resource",
+		Severity:    SeverityHigh,
+		Remediation: "{{.resource}} was remediated by {{.remediations}} runs within {{.window}}, at {{.times}}; other automation keeps reverting velora's changes, align it with velora's policy. {{.action}}",
+		Fallback:    "the resource is remediated on run after run, other automation keeps reverting velora's changes; align it with velora's policy",
+	}
+)
+
+// allRules lists every rule, it determines the rule-set version.
+var allRules = []Rule{
+	RuleDefaultRoute,
+	RuleRouteTableMissing,
+	RuleOnPremOverride,
+	RuleStaleOnPremOverride,
+	RuleUnownedNextHop,
+	RuleNextHopDegraded,
+	RuleForbiddenNextHop,
+	RuleSubnetIsolation,
+	RuleSubnetClassExempt,
+	RuleCompatProfile,
+	RuleSubnetClassConflict,
+	RuleSubnetClassDefaultRoute,
+	RuleBGPPropagation,
+	RuleSharedRouteTableConflict,
+	RuleServiceRouteTable,
+	RuleAsymmetricRouting,
+	RulePeeringAddressSpaceSync,
+	RuleHubPeeringMissing,
+	RuleHubSidePeering,
+	RuleRemoteGateways,
+	RulePeeringMisnamed,
+	RuleHubCapacityWarning,
+	RuleHubCapacityExceeded,
+	RuleHubAtCapacity,
+	RuleVWANConnectionMissing,
+	RuleVWANAssociation,
+	RuleVWANDefaultRoute,
+	RuleSpokeGateway,
+	RuleEgressNATGateway,
+	RuleEgressNICPublicIP,
+	RuleFlowLog,
+	RuleFlowLogNoWatcher,
+	RuleNSGMissing,
+	RuleNSGBaseline,
+	RuleNSGBaselineRule,
+	RuleNSGUnknownIPGroup,
+	RuleNSGSubnetIsolation,
+	RuleNSGIsolationBand,
+	RuleNSGIsolationShadowed,
+	RuleNSGUnsupportedSubnet,
+	RuleUnreadableResource,
+	RuleInactiveSubscription,
+	RuleGracePeriod,
+	RuleHubNotFound,
+	RuleHubTagInvalid,
+	RuleUnmanagedSubscription,
+	RuleBlockedByPolicy,
+	RuleBlockedByQuota,
+	RuleBlockedByUpstream,
+	RuleControllerPanic,
+	RuleBreakGlassActive,
+	RuleConfigStaged,
+	RulePendingAcknowledgment,
+	RuleFlappingResource,
+}
+
+// RuleSetVersion returns a short hash of the rule definitions, so reports
+// produced by different rule sets can be told apart.
+func RuleSetVersion() string {
+	h := sha256.New()
+	for _, rule := range allRules {
+		fmt.Fprintf(h, "%s|%s|%s|%s\n", rule.ID, rule.Severity, rule.Remediation, rule.Fallback)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
@@ -0,0 +1,272 @@
+// Package flapping detects resources velora remediates run after run,
+// usually because other automation, e.g. a Terraform pipeline, reverts its
+// changes. Remediations are counted per top-level resource in the state
+// store, flapping resources are reported and may be held from remediation.
+package flapping
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// stateKey is the state store key holding the remediations of the
+// resources, by lower-case top-level resource ID.
+const stateKey = "remediations"
+
+// Resource is the remediation history of a top-level resource: route
+// tables, VNets for their peerings and subnets, NSGs and network watchers
+// for their flow logs.
+type Resource struct {
+	ResourceID     string `json:"resourceId"`
+	SubscriptionID string `json:"subscriptionId"`
+	// Remediations are the times runs remediated it within the window,
+	// oldest first.
+	Remediations []time.Time `json:"remediations"`
+	// HeldSince is when its remediation was held because it flaps, zero
+	// if it isn't held.
+	HeldSince time.Time `json:"heldSince,omitempty"`
+}
+
+// Held reports whether the remediation of the resource is held.
+func (r *Resource) Held() bool {
+	return !r.HeldSince.IsZero()
+}
+
+// Tracker counts the runs remediating each resource. A resource remediated
+// by more than the allowed runs within the window is flapping: it is
+// reported, and with holdRemediation only reported from then on.
+type Tracker struct {
+	config *config.Config
+	store  state.Store
+
+	mu        sync.Mutex
+	resources map[string]*Resource
+	// remediated and reported are the resources remediated and reported by
+	// this run, each is counted and reported once per run.
+	remediated map[string]bool
+	reported   map[string]bool
+	findings   []findings.Finding
+}
+
+// NewTracker creates a tracker of the flapping configuration of the config,
+// which must be set.
+func NewTracker(config *config.Config, store state.Store) *Tracker {
+	return &Tracker{
+		config:     config,
+		store:      store,
+		remediated: make(map[string]bool),
+		reported:   make(map[string]bool),
+	}
+}
+
+// Remediated records that the run is remediating the resource, at most
+// once per run for each top-level resource. The resource is reported if
+// this makes it flap.
+func (t *Tracker) Remediated(subscriptionID, resourceID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := azure.TopLevelResourceID(resourceID)
+	key := strings.ToLower(id)
+	if t.remediated[key] {
+		return
+	}
+	t.remediated[key] = true
+	if err := t.load(); err != nil {
+		fmt.Println("WARNING: remediation not counted for flapping detection:", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	r := t.resources[key]
+	if r == nil {
+		r = &Resource{ResourceID: id, SubscriptionID: subscriptionID}
+		t.resources[key] = r
+	}
+	r.Remediations = append(t.inWindow(r.Remediations, now), now)
+	if len(r.Remediations) <= t.config.Flapping.EffectiveMaxRemediations() {
+		return
+	}
+	if t.config.Flapping.HoldRemediation && !r.Held() {
+		r.HeldSince = now
+	}
+	t.report(r)
+}
+
+// Held reports whether the remediation of the resource is held because it
+// flaps, and reports it. Like pauses, failing to read the state holds it.
+func (t *Tracker) Held(resourceID string) bool {
+	if !t.config.Flapping.HoldRemediation {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := azure.TopLevelResourceID(resourceID)
+	if err := t.load(); err != nil {
+		fmt.Println("WARNING: skipped write, failed to determine whether", id, "flaps:", err)
+		return true
+	}
+	r := t.resources[strings.ToLower(id)]
+	if r == nil || !r.Held() {
+		return false
+	}
+	fmt.Printf("skipped write in subscription %s: %s flaps, its remediation is held since %s\n",
+		r.SubscriptionID, id, r.HeldSince.Format(time.RFC3339))
+	t.report(r)
+	return true
+}
+
+// report records the flapping finding of the resource, once per run.
+func (t *Tracker) report(r *Resource) {
+	key := strings.ToLower(r.ResourceID)
+	if t.reported[key] {
+		return
+	}
+	t.reported[key] = true
+
+	times := make([]string, len(r.Remediations))
+	for i, at := range r.Remediations {
+		times[i] = at.Format(time.RFC3339)
+	}
+	action := "It is remediated again on every run, set flapping.holdRemediation to only report it."
+	// the window is in whole hours
+	window := strings.TrimSuffix(t.config.Flapping.Window().String(), "0m0s")
+	message := fmt.Sprintf("%s flaps, %d runs remediated it within %s", r.ResourceID, len(r.Remediations), window)
+	if r.Held() {
+		action = "Its remediation is held, run velora flapping clear --resource " + r.ResourceID + " once the other automation is fixed."
+		message += fmt.Sprintf(", its remediation is held since %s", r.HeldSince.Format(time.RFC3339))
+	}
+	t.findings = append(t.findings, findings.New(findings.RuleFlappingResource, t.config.Rules, r.SubscriptionID, r.ResourceID, message,
+		map[string]string{
+			"resource":     r.ResourceID,
+			"remediations": fmt.Sprint(len(r.Remediations)),
+			"window":       window,
+			"times":        strings.Join(times, ", "),
+			"action":       action,
+		}))
+}
+
+// Findings returns the flapping resources of the run.
+func (t *Tracker) Findings() []findings.Finding {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.findings
+}
+
+// Commit persists the remediations, dropping those outside the window and
+// the resources left with none that aren't held.
+func (t *Tracker) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.resources == nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	for key, r := range t.resources {
+		r.Remediations = t.inWindow(r.Remediations, now)
+		if len(r.Remediations) == 0 && !r.Held() {
+			delete(t.resources, key)
+		}
+	}
+	if err := t.store.Put(stateKey, t.resources); err != nil {
+		return fmt.Errorf("failed to save remediations: %w", err)
+	}
+	return nil
+}
+
+// inWindow returns the times within the window before now.
+func (t *Tracker) inWindow(times []time.Time, now time.Time) []time.Time {
+	since := now.Add(-t.config.Flapping.Window())
+	var kept []time.Time
+	for _, at := range times {
+		if at.After(since) {
+			kept = append(kept, at)
+		}
+	}
+	return kept
+}
+
+// load reads the remediations once per run.
+func (t *Tracker) load() error {
+	if t.resources != nil {
+		return nil
+	}
+	resources, err := load(t.store)
+	if err != nil {
+		return err
+	}
+	t.resources = resources
+	return nil
+}
+
+// load reads the remediations from the state store.
+func load(store state.Store) (map[string]*Resource, error) {
+	resources := make(map[string]*Resource)
+	if err := store.Get(stateKey, &resources); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load remediations: %w", err)
+	}
+	return resources, nil
+}
+
+// List returns the resources remediated within their window or held,
+// held ones first.
+func List(store state.Store) ([]Resource, error) {
+	resources, err := load(store)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Resource, 0, len(resources))
+	for _, r := range resources {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Held() != list[j].Held() {
+			return list[i].Held()
+		}
+		if len(list[i].Remediations) != len(list[j].Remediations) {
+			return len(list[i].Remediations) > len(list[j].Remediations)
+		}
+		return list[i].ResourceID < list[j].ResourceID
+	})
+	return list, nil
+}
+
+// Clear clears the flag of a held resource, or of all with an empty
+// resourceID, and forgets their remediations so they are remediated again.
+// It returns the resources cleared.
+func Clear(store state.Store, resourceID string) ([]string, error) {
+	resources, err := load(store)
+	if err != nil {
+		return nil, err
+	}
+	var cleared []string
+	for key, r := range resources {
+		if resourceID != "" && key != strings.ToLower(azure.TopLevelResourceID(resourceID)) {
+			continue
+		}
+		if r.Held() || resourceID != "" {
+			cleared = append(cleared, r.ResourceID)
+			delete(resources, key)
+		}
+	}
+	if len(cleared) == 0 {
+		return nil, nil
+	}
+	sort.Strings(cleared)
+	if err := store.Put(stateKey, resources); err != nil {
+		return nil, fmt.Errorf("failed to save remediations: %w", err)
+	}
+	return cleared, nil
+}
@@ -0,0 +1,182 @@
+package grace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// stateKey is the state store key holding when resources without a
+// creation time were first seen drifting.
+const stateKey = "first-seen"
+
+// firstSeenRetention is how long the first-seen time of a resource that is
+// no longer seen is kept.
+const firstSeenRetention = 90 * 24 * time.Hour
+
+// resourceTypes are the top-level resource types controllers remediate,
+// child resources share the grace period of their parent.
+var resourceTypes = []string{
+	azure.ResourceTypeVirtualNetworks,
+	azure.ResourceTypeRouteTables,
+	azure.ResourceTypeSecurityGroups,
+}
+
+// sighting is when a resource without a creation time was first and last seen.
+type sighting struct {
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// Tracker defers the remediation of resources created less than the grace
+// period of their subscription ago, so velora doesn't race the IaC still
+// provisioning them. Creation times come from ARM, resources without one
+// fall back to when velora first saw them drift.
+type Tracker struct {
+	clientFactory *azure.ClientFactory
+	config        *config.Config
+	store         state.Store
+
+	mu        sync.Mutex
+	created   map[string]map[string]time.Time
+	sightings map[string]*sighting
+	deferred  map[string]bool
+	findings  []findings.Finding
+}
+
+// NewTracker creates a new grace period tracker. First-seen times are
+// persisted to the state store.
+func NewTracker(clientFactory *azure.ClientFactory, config *config.Config, store state.Store) *Tracker {
+	return &Tracker{
+		clientFactory: clientFactory,
+		config:        config,
+		store:         store,
+		created:       make(map[string]map[string]time.Time),
+		deferred:      make(map[string]bool),
+	}
+}
+
+// Deferred reports whether the remediation of the resource must be deferred
+// because it is in the grace period of the subscription. The first deferral
+// of a resource is recorded as a finding.
+func (t *Tracker) Deferred(ctx context.Context, subscriptionID, resourceID string) bool {
+	subCFG, ok := t.config.Subscriptions[subscriptionID]
+	if !ok || subCFG.GracePeriod() <= 0 {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := azure.TopLevelResourceID(resourceID)
+	now := time.Now().UTC()
+	created, err := t.createdTime(ctx, id, now)
+	if err != nil {
+		// like pauses, failing to read the state blocks writes
+		fmt.Println("WARNING: skipped write, failed to determine the creation time of", id+":", err)
+		return true
+	}
+
+	remaining := created.Add(subCFG.GracePeriod()).Sub(now)
+	if remaining <= 0 {
+		return false
+	}
+	remaining = max(remaining.Round(time.Minute), time.Minute)
+
+	fmt.Printf("skipped write in subscription %s: %s is in its grace period, %s remaining\n", subscriptionID, id, formatDuration(remaining))
+	if !t.deferred[strings.ToLower(id)] {
+		t.deferred[strings.ToLower(id)] = true
+		t.findings = append(t.findings, findings.New(findings.RuleGracePeriod, t.config.Rules,
+			subscriptionID, id, fmt.Sprintf("%s is in its grace period, remediation is deferred for %s", id, formatDuration(remaining)),
+			map[string]string{
+				"resource":  id,
+				"created":   created.Format(time.RFC3339),
+				"remaining": formatDuration(remaining),
+			}))
+	}
+	return true
+}
+
+// Findings returns the resources whose remediation was deferred.
+func (t *Tracker) Findings() []findings.Finding {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.findings
+}
+
+// Commit persists the first-seen times, dropping resources not seen for
+// longer than the retention.
+func (t *Tracker) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.sightings == nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	for key, s := range t.sightings {
+		if now.Sub(s.LastSeen) > firstSeenRetention {
+			delete(t.sightings, key)
+		}
+	}
+	return t.store.Put(stateKey, t.sightings)
+}
+
+// createdTime returns the creation time of the top-level resource, or when
+// it was first seen if ARM has none. Creation times are listed once per
+// subscription and run.
+func (t *Tracker) createdTime(ctx context.Context, resourceID string, now time.Time) (time.Time, error) {
+	key := strings.ToLower(resourceID)
+	subID := azure.SubscriptionIDOf(resourceID)
+
+	created, ok := t.created[subID]
+	if !ok {
+		var err error
+		created, err = t.clientFactory.ForSubscription(subID).ListCreatedTimes(ctx, resourceTypes...)
+		if err != nil {
+			fmt.Printf("WARNING: failed to list creation times in subscription %s, falling back to first-seen times: %v\n", subID, err)
+			created = make(map[string]time.Time)
+		}
+		t.created[subID] = created
+	}
+	if c, ok := created[key]; ok {
+		return c, nil
+	}
+
+	if err := t.loadSightings(); err != nil {
+		return time.Time{}, err
+	}
+	s, ok := t.sightings[key]
+	if !ok {
+		s = &sighting{FirstSeen: now}
+		t.sightings[key] = s
+	}
+	s.LastSeen = now
+	return s.FirstSeen, nil
+}
+
+// loadSightings reads the first-seen times from the state store, once.
+func (t *Tracker) loadSightings() error {
+	if t.sightings != nil {
+		return nil
+	}
+	sightings := make(map[string]*sighting)
+	if err := t.store.Get(stateKey, &sightings); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return fmt.Errorf("failed to load first-seen times: %w", err)
+	}
+	t.sightings = sightings
+	return nil
+}
+
+// formatDuration formats a whole number of minutes, e.g. "1h12m".
+func formatDuration(d time.Duration) string {
+	return strings.TrimSuffix(d.String(), "0s")
+}
@@ -0,0 +1,381 @@
+package guard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/breakglass"
+	"github.com/akos011221/velora/internal/flapping"
+	"github.com/akos011221/velora/internal/grace"
+	"github.com/akos011221/velora/internal/pause"
+	"github.com/akos011221/velora/internal/plan"
+	"github.com/akos011221/velora/internal/trace"
+)
+
+// Guard decides whether controllers may write to a subscription. It combines
+// the persisted pauses with observe-only downgrades decided during the run.
+type Guard struct {
+	pauses *pause.Manager
+
+	mu          sync.Mutex
+	readOnly    bool
+	breakGlass  *breakglass.Activation
+	observeOnly map[string]string
+	inactive    map[string]string
+	skipped     map[string]string
+	// hubMissing are the subscriptions whose hub checks are skipped.
+	hubMissing map[string]string
+	plan       *plan.Recorder
+	grace      *grace.Tracker
+	flapping   *flapping.Tracker
+	tracer     *trace.Tracer
+	// writes counts the writes made per subscription.
+	writes map[string]int
+	// disappeared are the resources deleted while the run evaluated them.
+	disappeared []string
+	// policyBlocks are the writes Azure Policy refused.
+	policyBlocks []PolicyBlock
+	// upstream are the resources held back by a finding of an earlier
+	// controller of the run, by lower-case ID.
+	upstream map[string]UpstreamBlock
+	// panics are the controller panics recovered by Contain.
+	panics []ControllerPanic
+}
+
+// PolicyBlock is a write of the run an Azure Policy assignment refused.
+type PolicyBlock struct {
+	SubscriptionID string
+	ResourceID     string
+	Denial         *azure.PolicyDenial
+}
+
+// UpstreamBlock is a resource the later controllers of the run leave alone,
+// because a controller it depends on left a finding on it.
+type UpstreamBlock struct {
+	ResourceID string
+	// RuleID is the rule of the upstream finding.
+	RuleID string
+	Reason string
+}
+
+// New creates a new write guard instance.
+func New(pauses *pause.Manager) *Guard {
+	return &Guard{
+		pauses:      pauses,
+		observeOnly: make(map[string]string),
+		inactive:    make(map[string]string),
+		skipped:     make(map[string]string),
+		hubMissing:  make(map[string]string),
+		writes:      make(map[string]int),
+		upstream:    make(map[string]UpstreamBlock),
+	}
+}
+
+// SetReadOnly disables all writes for the run.
+func (g *Guard) SetReadOnly() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.readOnly = true
+}
+
+// ReadOnly reports whether all writes are disabled.
+func (g *Guard) ReadOnly() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.readOnly
+}
+
+// SetBreakGlass runs under break-glass mode: maintenance windows don't
+// hold back writes. Pauses and read-only mode still do.
+func (g *Guard) SetBreakGlass(activation *breakglass.Activation) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.breakGlass = activation
+}
+
+// BreakGlass returns the break-glass activation of the run, nil if it
+// doesn't run under break-glass mode.
+func (g *Guard) BreakGlass() *breakglass.Activation {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.breakGlass
+}
+
+// SetPlan switches the guard to plan mode: controllers record their writes
+// in the plan instead of making them.
+func (g *Guard) SetPlan(recorder *plan.Recorder) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.plan = recorder
+}
+
+// Planning reports whether the guard is in plan mode.
+func (g *Guard) Planning() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.plan != nil
+}
+
+// SetGrace defers the remediation of new resources in their grace period.
+func (g *Guard) SetGrace(tracker *grace.Tracker) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.grace = tracker
+}
+
+// SetFlapping counts the remediations of resources, holding those that
+// flap if configured.
+func (g *Guard) SetFlapping(tracker *flapping.Tracker) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.flapping = tracker
+}
+
+// SetTracer logs the writes and disappearances of traced resources.
+func (g *Guard) SetTracer(tracer *trace.Tracer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.tracer = tracer
+}
+
+// InGracePeriod reports whether the resource is too new to be remediated.
+// Controllers check it with WritesAllowed, with the resource being fixed.
+func (g *Guard) InGracePeriod(ctx context.Context, subscriptionID, resourceID string) bool {
+	g.mu.Lock()
+	tracker := g.grace
+	g.mu.Unlock()
+	return tracker != nil && tracker.Deferred(ctx, subscriptionID, resourceID)
+}
+
+// Planned records the change if the guard is in plan mode and reports
+// whether it did, in which case the controller must not write. It also
+// reports true, without recording, for resources whose remediation is held
+// because they flap. Controllers call it right before every write,
+// otherwise the write is counted.
+func (g *Guard) Planned(change plan.Change) bool {
+	g.mu.Lock()
+	recorder := g.plan
+	flaps := g.flapping
+	g.mu.Unlock()
+	if recorder == nil && flaps != nil {
+		if flaps.Held(change.ResourceID) {
+			return true
+		}
+		flaps.Remediated(change.SubscriptionID, change.ResourceID)
+	}
+
+	g.mu.Lock()
+	if recorder == nil {
+		g.writes[change.SubscriptionID]++
+	}
+	tracer := g.tracer
+	g.mu.Unlock()
+
+	tracer.Printf(change.ResourceID, "%s (planned: %t, etag %q): %s", change.Description, recorder != nil, change.Etag, change.Body)
+	if recorder == nil {
+		return false
+	}
+	recorder.Add(change)
+	return true
+}
+
+// Writes returns the number of writes made per subscription.
+func (g *Guard) Writes() map[string]int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	writes := make(map[string]int, len(g.writes))
+	for subID, n := range g.writes {
+		writes[subID] = n
+	}
+	return writes
+}
+
+// SetObserveOnly downgrades the subscription to observe mode for this run.
+func (g *Guard) SetObserveOnly(subscriptionID, reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.observeOnly[subscriptionID] = reason
+}
+
+// ObserveOnly returns the reason the subscription is in observe mode, if it is.
+func (g *Guard) ObserveOnly(subscriptionID string) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	reason, ok := g.observeOnly[subscriptionID]
+	return reason, ok
+}
+
+// SetInactive skips the subscription for this run, it isn't active in Azure.
+func (g *Guard) SetInactive(subscriptionID, reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inactive[subscriptionID] = reason
+}
+
+// Inactive returns the reason the subscription is inactive, if it is.
+func (g *Guard) Inactive(subscriptionID string) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	reason, ok := g.inactive[subscriptionID]
+	return reason, ok
+}
+
+// SetSkipped skips the subscription for this run.
+func (g *Guard) SetSkipped(subscriptionID, reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.skipped[subscriptionID] = reason
+}
+
+// SetHubMissing skips the checks of the subscription that need its hub for
+// this run, the hub isn't configured. The other checks still run.
+func (g *Guard) SetHubMissing(subscriptionID, reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.hubMissing[subscriptionID] = reason
+}
+
+// HubMissing returns the reason the hub checks of the subscription are
+// skipped, if they are.
+func (g *Guard) HubMissing(subscriptionID string) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	reason, ok := g.hubMissing[subscriptionID]
+	return reason, ok
+}
+
+// SkipDisappeared records the resource as deleted during the run if the
+// error is a NotFound, and reports whether it did. Controllers only call it
+// for resources they discovered in the run, a configured resource that
+// doesn't exist is a misconfiguration and must fail the run.
+func (g *Guard) SkipDisappeared(resourceID string, err error) bool {
+	if !azure.IsNotFound(err) {
+		return false
+	}
+	g.MarkDisappeared(resourceID)
+	return true
+}
+
+// MarkDisappeared records a resource discovered in the run that is gone,
+// such as a route table a subnet still references but that is no longer
+// listed.
+func (g *Guard) MarkDisappeared(resourceID string) {
+	g.mu.Lock()
+	g.disappeared = append(g.disappeared, resourceID)
+	tracer := g.tracer
+	g.mu.Unlock()
+	fmt.Printf("skipped resource %s: disappeared during run\n", resourceID)
+	tracer.Printf(resourceID, "disappeared during run, skipped")
+}
+
+// Disappeared returns the resources deleted while the run evaluated them.
+func (g *Guard) Disappeared() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.disappeared...)
+}
+
+// BlockDownstream holds the resource back from the controllers running
+// after the calling one, because of its finding of the rule.
+func (g *Guard) BlockDownstream(resourceID, ruleID, reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.upstream[strings.ToLower(resourceID)] = UpstreamBlock{ResourceID: resourceID, RuleID: ruleID, Reason: reason}
+}
+
+// BlockedUpstream returns the block of the resource, if an earlier
+// controller of the run held it back.
+func (g *Guard) BlockedUpstream(resourceID string) (UpstreamBlock, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	block, ok := g.upstream[strings.ToLower(resourceID)]
+	return block, ok
+}
+
+// SkipPolicyDenied records the write to the resource if the error was
+// returned because an Azure Policy assignment denies it, and reports whether
+// it did. Controllers continue with the next resource instead of failing
+// the run.
+func (g *Guard) SkipPolicyDenied(subscriptionID, resourceID string, err error) bool {
+	denial, ok := azure.AsPolicyDenial(err)
+	if !ok {
+		return false
+	}
+	g.mu.Lock()
+	g.policyBlocks = append(g.policyBlocks, PolicyBlock{SubscriptionID: subscriptionID, ResourceID: resourceID, Denial: denial})
+	tracer := g.tracer
+	g.mu.Unlock()
+	fmt.Printf("skipped resource %s: write blocked by Azure Policy %s\n", resourceID, denial.Name())
+	tracer.Printf(resourceID, "write blocked by Azure Policy assignment %s", denial.AssignmentID)
+	return true
+}
+
+// PolicyBlocks returns the writes Azure Policy refused during the run.
+func (g *Guard) PolicyBlocks() []PolicyBlock {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]PolicyBlock(nil), g.policyBlocks...)
+}
+
+// SkipInactive marks the subscription inactive if the error was returned
+// because it isn't active in Azure, and reports whether it did. Controllers
+// skip the subscription instead of failing the run.
+func (g *Guard) SkipInactive(subscriptionID string, err error) bool {
+	var respErr *azcore.ResponseError
+	if !azure.IsInactiveSubscriptionError(err) || !errors.As(err, &respErr) {
+		return false
+	}
+	g.SetInactive(subscriptionID, respErr.ErrorCode)
+	fmt.Printf("skipped subscription %s: inactive, %s\n", subscriptionID, respErr.ErrorCode)
+	return true
+}
+
+// SkipReason returns why the subscription must not be evaluated at all,
+// empty if it can be.
+func (g *Guard) SkipReason(subscriptionID string) (string, error) {
+	if reason, ok := g.Inactive(subscriptionID); ok {
+		return "inactive, " + reason, nil
+	}
+	g.mu.Lock()
+	reason, ok := g.skipped[subscriptionID]
+	g.mu.Unlock()
+	if ok {
+		return reason, nil
+	}
+	p, err := g.Halted(subscriptionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to read pause state for subscription %s: %w", subscriptionID, err)
+	}
+	if p != nil {
+		return p.String(), nil
+	}
+	return "", nil
+}
+
+// Halted returns the pause stopping evaluation of the subscription, if any.
+func (g *Guard) Halted(subscriptionID string) (*pause.Pause, error) {
+	p, err := g.pauses.Active(subscriptionID)
+	if err != nil || p == nil || !p.HaltObservation {
+		return nil, err
+	}
+	return p, nil
+}
+
+// WritesAllowed is checked by controllers right before every write.
+func (g *Guard) WritesAllowed(subscriptionID string) bool {
+	if g.ReadOnly() {
+		fmt.Printf("skipped write in subscription %s: read-only mode\n", subscriptionID)
+		return false
+	}
+	if reason, ok := g.ObserveOnly(subscriptionID); ok {
+		fmt.Printf("skipped write in subscription %s: observe only, %s\n", subscriptionID, reason)
+		return false
+	}
+	return g.pauses.WritesAllowed(subscriptionID)
+}
@@ -0,0 +1,78 @@
+package guard
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"runtime/debug"
+
+	"github.com/akos011221/velora/internal/redact"
+)
+
+// ControllerPanic is a panic of a controller enforcing a subscription,
+// recovered by Contain. Value and Stack are sanitized.
+type ControllerPanic struct {
+	Controller     string
+	SubscriptionID string
+	Value          string
+	Stack          string
+}
+
+// PanicError is returned by Contain for a recovered panic.
+type PanicError struct {
+	Panic ControllerPanic
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s controller panicked for subscription %s: %s", e.Panic.Controller, e.Panic.SubscriptionID, e.Panic.Value)
+}
+
+// IsPanic reports whether err is a panic recovered by Contain.
+func IsPanic(err error) bool {
+	var panicErr *PanicError
+	return errors.As(err, &panicErr)
+}
+
+// Contain runs the enforcement of the subscription by the controller and
+// recovers a panic of it, so the other subscriptions are still enforced.
+// The panic is recorded, the subscription is skipped by the controllers
+// after this one, and it is returned as a PanicError: controllers continue
+// with the next subscription when IsPanic reports true.
+func (g *Guard) Contain(controller, subscriptionID string, enforce func() error) (err error) {
+	defer func() {
+		value := recover()
+		if value == nil {
+			return
+		}
+		p := ControllerPanic{
+			Controller:     controller,
+			SubscriptionID: subscriptionID,
+			Value:          redact.String(fmt.Sprint(value)),
+			Stack:          SanitizeStack(debug.Stack()),
+		}
+		g.mu.Lock()
+		g.panics = append(g.panics, p)
+		g.skipped[subscriptionID] = fmt.Sprintf("%s controller panicked", controller)
+		g.mu.Unlock()
+		fmt.Printf("ERROR: %s controller panicked for subscription %s: %s\n%s", controller, subscriptionID, p.Value, p.Stack)
+		err = &PanicError{Panic: p}
+	}()
+	return enforce()
+}
+
+// Panics returns the controller panics recovered during the run.
+func (g *Guard) Panics() []ControllerPanic {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]ControllerPanic(nil), g.panics...)
+}
+
+// stackArguments matches the argument words of a stack frame, like
+// (0xc000123456, 0x5, {0x1, ...}).
+var stackArguments = regexp.MustCompile(`(?m)^(\S.*)\(.*\)$`)
+
+// SanitizeStack returns the stack trace without the argument values of its
+// frames and with the registered secrets redacted.
+func SanitizeStack(stack []byte) string {
+	return redact.String(stackArguments.ReplaceAllString(string(stack), "$1(...)"))
+}
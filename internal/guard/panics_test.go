@@ -0,0 +1,97 @@
+package guard
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/akos011221/velora/internal/pause"
+	"github.com/akos011221/velora/internal/redact"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// newTestGuard returns a guard whose pauses are kept in a temporary
+// directory.
+func newTestGuard(t *testing.T) *Guard {
+	t.Helper()
+	backend, err := state.NewFileBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(pause.NewManager(state.NewBackendStore(backend)))
+}
+
+// panicSecret is a registered secret a panic carries.
+const panicSecret = "panic-client-secret-value"
+
+func TestContain(t *testing.T) {
+	redact.Register(panicSecret)
+	failed := errors.New("failed")
+	tests := []struct {
+		name string
+		// enforce panics with the value, or returns err
+		value     any
+		err       error
+		wantPanic bool
+	}{
+		{name: "no panic"},
+		{name: "error", err: failed},
+		{name: "nil dereference", value: "runtime error: invalid memory address or nil pointer dereference", wantPanic: true},
+		{name: "value holding a secret", value: fmt.Errorf("token %s rejected", panicSecret), wantPanic: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGuard(t)
+			err := g.Contain("routing", "sub", func() error {
+				if tt.value != nil {
+					panic(tt.value)
+				}
+				return tt.err
+			})
+
+			reason, _ := g.SkipReason("sub")
+			if !tt.wantPanic {
+				if err != tt.err || IsPanic(err) || len(g.Panics()) != 0 || reason != "" {
+					t.Errorf("Contain() = %v, panics %v, skipped %q, want %v", err, g.Panics(), reason, tt.err)
+				}
+				return
+			}
+			if !IsPanic(err) {
+				t.Fatalf("Contain() = %v, want a panic", err)
+			}
+			panics := g.Panics()
+			if len(panics) != 1 || panics[0].Controller != "routing" || panics[0].SubscriptionID != "sub" {
+				t.Fatalf("Panics() = %+v, want the routing panic of sub", panics)
+			}
+			if p := panics[0]; strings.Contains(p.Value+p.Stack+err.Error(), panicSecret) {
+				t.Errorf("panic = %+v, leaks the secret", p)
+			}
+			if !strings.Contains(panics[0].Stack, "TestContain") {
+				t.Errorf("panic stack = %s, want the panicking frames", panics[0].Stack)
+			}
+			if reason != "routing controller panicked" {
+				t.Errorf("SkipReason() = %q, want the subscription skipped by the next controllers", reason)
+			}
+		})
+	}
+}
+
+func TestSanitizeStack(t *testing.T) {
+	redact.Register(panicSecret)
+	stack := "goroutine 1 [running]:\n" +
+		"github.com/akos011221/velora/internal/controllers/routing.(*Enforcer).enforce(0xc000123456, {0x1a2b3c, 0x19})\n" +
+		"\t/src/internal/controllers/routing/routing.go:120 +0x1d\n" +
+		"main.main()\n" +
+		"\t/src/cmd/velora/main.go:10 +0x25 " + panicSecret + "\n"
+	want := "goroutine 1 [running]:\n" +
+		"github.com/akos011221/velora/internal/controllers/routing.(*Enforcer).enforce(...)\n" +
+		"\t/src/internal/controllers/routing/routing.go:120 +0x1d\n" +
+		"main.main(...)\n" +
+		"\t/src/cmd/velora/main.go:10 +0x25 " + redact.Placeholder + "\n"
+
+	if got := SanitizeStack([]byte(stack)); got != want {
+		t.Errorf("SanitizeStack() =\n%s\nwant:\n%s", got, want)
+	}
+}
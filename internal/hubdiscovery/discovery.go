@@ -0,0 +1,251 @@
+package hubdiscovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/preflight"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// stateKey is the state store key holding the hubs discovered by the
+// previous run, to log the changes of their tags.
+const stateKey = "discovered-hubs"
+
+// Hub is a hub built from the tags of its VNet.
+type Hub struct {
+	Config config.HubVNetConfig
+	// SubscriptionID is the connectivity subscription of the VNet.
+	SubscriptionID string
+	// Location is the region of the VNet.
+	Location string
+}
+
+// Result is the outcome of a discovery.
+type Result struct {
+	Hubs []Hub
+	// Findings report the hub VNets whose tags are malformed, they aren't
+	// discovered.
+	Findings []findings.Finding
+
+	rules map[string]config.RuleConfig
+}
+
+// Discover searches the connectivity subscriptions of the configuration for
+// VNets bearing the hub tag and builds a hub of each. It fails if a
+// subscription can't be searched, a hub missing from the run could leave
+// its VNet routed like a spoke.
+func Discover(ctx context.Context, cfg *config.Config, clientFactory *azure.ClientFactory) (*Result, error) {
+	discovery := cfg.HubDiscovery
+	result := &Result{rules: cfg.Rules}
+	for _, subID := range discovery.Subscriptions {
+		vnetsClient, err := clientFactory.ForSubscription(subID).NewVirtualNeworksClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		pager := vnetsClient.NewListAllPager(nil)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to discover hubs in subscription %s: %w", subID, err)
+			}
+			for _, vnet := range page.Value {
+				if vnet == nil || vnet.ID == nil {
+					continue
+				}
+				hub, err := hubFromTags(vnet, discovery.EffectiveTag())
+				if errors.Is(err, errNotHub) {
+					continue
+				}
+				if err != nil {
+					result.reject(subID, *vnet.ID, hubName(vnet), err)
+					continue
+				}
+				hub.SubscriptionID = subID
+				result.Hubs = append(result.Hubs, hub)
+			}
+		}
+	}
+	sort.Slice(result.Hubs, func(i, j int) bool {
+		return result.Hubs[i].Config.Name < result.Hubs[j].Config.Name
+	})
+	return result, nil
+}
+
+// errNotHub is returned for VNets without the hub tag.
+var errNotHub = errors.New("not a hub VNet")
+
+// hubFromTags builds the hub of the VNet from its tags.
+func hubFromTags(vnet *armnetwork.VirtualNetwork, hubTag string) (Hub, error) {
+	tags := make(map[string]string)
+	for key, value := range vnet.Tags {
+		if value != nil {
+			tags[strings.ToLower(key)] = strings.TrimSpace(*value)
+		}
+	}
+	marker, tagged := tags[strings.ToLower(hubTag)]
+	if !tagged || strings.EqualFold(marker, "false") {
+		return Hub{}, errNotHub
+	}
+	if !strings.EqualFold(marker, "true") {
+		return Hub{}, fmt.Errorf("tag %s is %q, expected true or false", hubTag, marker)
+	}
+
+	nvaIP, ok := tags[config.HubTagNVAIP]
+	if !ok || nvaIP == "" {
+		return Hub{}, fmt.Errorf("tag %s is missing", config.HubTagNVAIP)
+	}
+	if ip := net.ParseIP(nvaIP); ip == nil || ip.To4() == nil {
+		return Hub{}, fmt.Errorf("tag %s is %q, not an IPv4 address", config.HubTagNVAIP, nvaIP)
+	}
+	var addressSpace []*string
+	if vnet.Properties != nil && vnet.Properties.AddressSpace != nil {
+		addressSpace = vnet.Properties.AddressSpace.AddressPrefixes
+	}
+	if !preflight.InsidePrefixes(nvaIP, addressSpace) {
+		return Hub{}, fmt.Errorf("tag %s is %s, outside the address space of the VNet", config.HubTagNVAIP, nvaIP)
+	}
+
+	hub := Hub{
+		Config: config.HubVNetConfig{
+			VNetID:             *vnet.ID,
+			Name:               hubName(vnet),
+			NVANextHop:         nvaIP,
+			ManagedRoutePrefix: tags[config.HubTagRoutePrefix],
+			DefaultRouteName:   tags[config.HubTagDefaultRouteName],
+		},
+	}
+	if vnet.Location != nil {
+		hub.Location = *vnet.Location
+	}
+	return hub, nil
+}
+
+// hubName returns the name of the hub of the VNet: its name tag, or the
+// VNet name.
+func hubName(vnet *armnetwork.VirtualNetwork) string {
+	for key, value := range vnet.Tags {
+		if strings.EqualFold(key, config.HubTagName) && value != nil && strings.TrimSpace(*value) != "" {
+			return strings.TrimSpace(*value)
+		}
+	}
+	if vnet.Name != nil {
+		return *vnet.Name
+	}
+	return azure.ExtractResourceIDParts(*vnet.ID)["virtualNetworks"]
+}
+
+// Merge returns a copy of the configuration with the discovered hubs added,
+// and warnings about the discovered hubs ignored in favor of static ones.
+// Hubs failing the validation of static hubs are left out with a finding.
+func (r *Result) Merge(cfg *config.Config) (*config.Config, []string) {
+	configs := make([]config.HubVNetConfig, 0, len(r.Hubs))
+	for _, hub := range r.Hubs {
+		configs = append(configs, hub.Config)
+	}
+	merged, rejected, warnings := cfg.WithDiscoveredHubs(configs)
+
+	var accepted []Hub
+	for _, hub := range r.Hubs {
+		if err, ok := rejected[hub.Config.VNetID]; ok {
+			r.reject(hub.SubscriptionID, hub.Config.VNetID, hub.Config.Name, err)
+			continue
+		}
+		// static hubs win, the discovered one is ignored
+		if cfg.Hub(hub.Config.Name) == nil && !cfg.IsHubVNet(hub.Config.VNetID) {
+			accepted = append(accepted, hub)
+		}
+	}
+	r.Hubs = accepted
+	return merged, warnings
+}
+
+// reject reports a hub VNet that isn't discovered.
+func (r *Result) reject(subscriptionID, vnetID, name string, err error) {
+	fmt.Printf("WARNING: hub VNet %s not discovered: %v\n", vnetID, err)
+	r.Findings = append(r.Findings, findings.New(findings.RuleHubTagInvalid, r.rules, subscriptionID, vnetID,
+		fmt.Sprintf("hub VNet %s has malformed hub tags, hub %s isn't discovered: %v", vnetID, name, err),
+		map[string]string{
+			"hub":    name,
+			"vnet":   vnetID,
+			"reason": err.Error(),
+		}))
+}
+
+// discovered is a hub as recorded for the next run.
+type discovered struct {
+	VNetID             string `json:"vnetId"`
+	NVANextHop         string `json:"nvaNextHop"`
+	ManagedRoutePrefix string `json:"managedRoutePrefix,omitempty"`
+	DefaultRouteName   string `json:"defaultRouteName,omitempty"`
+	Location           string `json:"location,omitempty"`
+}
+
+// Track records the discovered hubs and returns how they changed since the
+// previous run: hubs discovered, changed or no longer discovered.
+func (r *Result) Track(store state.Store) ([]string, error) {
+	previous := make(map[string]discovered)
+	if err := store.Get(stateKey, &previous); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load discovered hubs: %w", err)
+	}
+
+	var changes []string
+	current := make(map[string]discovered, len(r.Hubs))
+	for _, hub := range r.Hubs {
+		d := discovered{
+			VNetID:             hub.Config.VNetID,
+			NVANextHop:         hub.Config.NVANextHop,
+			ManagedRoutePrefix: hub.Config.ManagedRoutePrefix,
+			DefaultRouteName:   hub.Config.DefaultRouteName,
+			Location:           hub.Location,
+		}
+		current[hub.Config.Name] = d
+
+		before, ok := previous[hub.Config.Name]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("hub %s discovered from VNet %s in %s, NVA %s", hub.Config.Name, d.VNetID, d.Location, d.NVANextHop))
+			continue
+		}
+		if diff := before.diff(d); len(diff) > 0 {
+			changes = append(changes, fmt.Sprintf("hub %s changed: %s", hub.Config.Name, strings.Join(diff, ", ")))
+		}
+	}
+	var removed []string
+	for name, before := range previous {
+		if _, ok := current[name]; !ok {
+			removed = append(removed, fmt.Sprintf("hub %s is no longer discovered from VNet %s", name, before.VNetID))
+		}
+	}
+	sort.Strings(removed)
+	changes = append(changes, removed...)
+
+	if err := store.Put(stateKey, current); err != nil {
+		return nil, fmt.Errorf("failed to save discovered hubs: %w", err)
+	}
+	return changes, nil
+}
+
+// diff describes the fields that changed from d to other.
+func (d discovered) diff(other discovered) []string {
+	var diff []string
+	for _, field := range []struct{ name, before, after string }{
+		{"vnetId", d.VNetID, other.VNetID},
+		{"nvaNextHop", d.NVANextHop, other.NVANextHop},
+		{"managedRoutePrefix", d.ManagedRoutePrefix, other.ManagedRoutePrefix},
+		{"defaultRouteName", d.DefaultRouteName, other.DefaultRouteName},
+		{"location", d.Location, other.Location},
+	} {
+		if field.before != field.after {
+			diff = append(diff, fmt.Sprintf("%s %q -> %q", field.name, field.before, field.after))
+		}
+	}
+	return diff
+}
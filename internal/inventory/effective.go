@@ -0,0 +1,167 @@
+package inventory
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// RouteSource is where an effective route comes from.
+type RouteSource string
+
+const (
+	// RouteSourceSystem is a route Azure creates for every subnet.
+	RouteSourceSystem RouteSource = "system"
+	// RouteSourceUser is a route of the subnet's route table.
+	RouteSourceUser RouteSource = "user"
+)
+
+// Next hop types of the system routes, as Azure names them.
+const (
+	NextHopVNetLocal   = "VnetLocal"
+	NextHopVNetPeering = "VNetPeering"
+	NextHopInternet    = "Internet"
+	NextHopNone        = "None"
+)
+
+// reservedPrefixes are the prefixes Azure drops traffic to by default,
+// unless the VNet's address space includes them.
+var reservedPrefixes = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10"}
+
+// EffectiveRoute is a system route or a user-defined route of a subnet.
+type EffectiveRoute struct {
+	Source      RouteSource `json:"source"`
+	Prefix      string      `json:"prefix"`
+	NextHopType string      `json:"nextHopType"`
+	NextHopIP   string      `json:"nextHopIp,omitempty"`
+	// Origin says what the route is derived from, e.g. a peering.
+	Origin string `json:"origin"`
+	// RouteID is the ID of a user-defined route.
+	RouteID string `json:"routeId,omitempty"`
+	// Managed is set for user-defined routes velora manages.
+	Managed bool `json:"managed,omitempty"`
+	// OverriddenBy is the user-defined route with the same prefix replacing
+	// a system route, OverriddenByManaged whether velora manages it.
+	OverriddenBy        string `json:"overriddenBy,omitempty"`
+	OverriddenByManaged bool   `json:"overriddenByManaged,omitempty"`
+}
+
+// Active reports whether the route is in effect, i.e. not overridden.
+func (r EffectiveRoute) Active() bool {
+	return r.OverriddenBy == ""
+}
+
+// SubnetRoutes are the derived effective routes of a subnet.
+type SubnetRoutes struct {
+	SubnetID     string           `json:"subnetId"`
+	VNetID       string           `json:"vnetId"`
+	RouteTableID string           `json:"routeTableId,omitempty"`
+	CollectedAt  time.Time        `json:"collectedAt"`
+	Routes       []EffectiveRoute `json:"routes"`
+	// Notes are the limits of the derivation for this subnet.
+	Notes []string `json:"notes,omitempty"`
+}
+
+// EffectiveRoutes derives the routes of the subnet from the snapshot,
+// without the effective routes API: the system routes of the VNet's address
+// space, of its Connected peerings and the defaults, then the routes of its
+// route table. A system route is overridden by the user-defined route with
+// the same prefix; routes with different prefixes are chosen by longest
+// prefix match, so a 0.0.0.0/0 route doesn't override VNet or peering
+// routes. Routes learned from gateways aren't in the inventory and aren't
+// derived. managed reports whether velora manages a route, it may be nil.
+func EffectiveRoutes(s *Snapshot, subnetID string, managed func(route RouteRecord) bool) (*SubnetRoutes, error) {
+	var subnet *SubnetRecord
+	for i := range s.Subnets {
+		if strings.EqualFold(s.Subnets[i].ID, subnetID) {
+			subnet = &s.Subnets[i]
+			break
+		}
+	}
+	if subnet == nil {
+		return nil, fmt.Errorf("subnet %s is not in the inventory", subnetID)
+	}
+	result := &SubnetRoutes{SubnetID: subnet.ID, VNetID: subnet.VNetID, RouteTableID: subnet.RouteTableID, CollectedAt: s.CollectedAt}
+
+	vnet := s.vnet(subnet.VNetID)
+	if vnet == nil {
+		result.Notes = append(result.Notes, "the address space of the VNet isn't in the inventory, refresh it for the VNet routes")
+	} else {
+		for _, prefix := range vnet.AddressPrefixes {
+			result.Routes = append(result.Routes, EffectiveRoute{Source: RouteSourceSystem, Prefix: prefix,
+				NextHopType: NextHopVNetLocal, Origin: "address space of VNet " + vnet.Name})
+		}
+	}
+
+	for _, peering := range s.Peerings {
+		if !strings.EqualFold(peering.VNetID, subnet.VNetID) || peering.State != "Connected" {
+			continue
+		}
+		prefixes := peering.RemoteAddressPrefixes
+		if remote := s.vnet(peering.RemoteVNetID); len(prefixes) == 0 && remote != nil {
+			prefixes = remote.AddressPrefixes
+		}
+		if len(prefixes) == 0 {
+			result.Notes = append(result.Notes, fmt.Sprintf("the address space of peered VNet %s isn't in the inventory", peering.RemoteVNetID))
+		}
+		for _, prefix := range prefixes {
+			result.Routes = append(result.Routes, EffectiveRoute{Source: RouteSourceSystem, Prefix: prefix,
+				NextHopType: NextHopVNetPeering, Origin: fmt.Sprintf("peering %s with VNet %s", peering.Name, peering.RemoteVNetID)})
+		}
+	}
+
+	result.Routes = append(result.Routes, EffectiveRoute{Source: RouteSourceSystem, Prefix: "0.0.0.0/0",
+		NextHopType: NextHopInternet, Origin: "default"})
+	for _, prefix := range reservedPrefixes {
+		if vnet != nil && includesPrefix(vnet.AddressPrefixes, prefix) {
+			continue
+		}
+		result.Routes = append(result.Routes, EffectiveRoute{Source: RouteSourceSystem, Prefix: prefix,
+			NextHopType: NextHopNone, Origin: "default"})
+	}
+
+	if subnet.RouteTableID == "" {
+		return result, nil
+	}
+	for _, route := range s.Routes {
+		if !strings.EqualFold(route.RouteTableID, subnet.RouteTableID) {
+			continue
+		}
+		udr := EffectiveRoute{Source: RouteSourceUser, Prefix: route.Prefix, NextHopType: route.NextHopType,
+			NextHopIP: route.NextHopIP, Origin: "route " + route.Name, RouteID: route.ID, Managed: managed != nil && managed(route)}
+		for i := range result.Routes {
+			system := &result.Routes[i]
+			if system.Source == RouteSourceSystem && system.OverriddenBy == "" && strings.EqualFold(system.Prefix, udr.Prefix) {
+				system.OverriddenBy, system.OverriddenByManaged = route.ID, udr.Managed
+			}
+		}
+		result.Routes = append(result.Routes, udr)
+	}
+	return result, nil
+}
+
+// vnet returns the VNet record, nil if it isn't in the snapshot.
+func (s *Snapshot) vnet(vnetID string) *VNetRecord {
+	for i := range s.VNets {
+		if strings.EqualFold(s.VNets[i].ID, vnetID) {
+			return &s.VNets[i]
+		}
+	}
+	return nil
+}
+
+// includesPrefix reports whether one of the address prefixes includes the
+// prefix, e.g. 10.0.0.0/8 or 10.0.0.0/7 include 10.0.0.0/8.
+func includesPrefix(addressPrefixes []string, prefix string) bool {
+	want, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return false
+	}
+	for _, p := range addressPrefixes {
+		if got, err := netip.ParsePrefix(p); err == nil && got.Bits() <= want.Bits() && got.Contains(want.Addr()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,265 @@
+package inventory
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	effectiveSpokeID = "/subscriptions/00000000-0000-0000-0000-000000000002/resourceGroups/spoke-rg/providers/Microsoft.Network/virtualNetworks/spoke"
+	effectiveHubID   = "/subscriptions/00000000-0000-0000-0000-000000000001/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub"
+	effectiveRTID    = "/subscriptions/00000000-0000-0000-0000-000000000002/resourceGroups/spoke-rg/providers/Microsoft.Network/routeTables/spoke-rt"
+	effectiveAppID   = effectiveSpokeID + "/subnets/app"
+)
+
+// effectiveSnapshot is a spoke peered with a hub, its app subnet using a
+// route table with the given routes.
+func effectiveSnapshot(routes ...RouteRecord) *Snapshot {
+	for i := range routes {
+		routes[i].ID = effectiveRTID + "/routes/" + routes[i].Name
+		routes[i].RouteTableID = effectiveRTID
+	}
+	return &Snapshot{
+		CollectedAt: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+		VNets: []VNetRecord{
+			{ID: effectiveSpokeID, Name: "spoke", AddressPrefixes: []string{"10.1.0.0/16"}},
+			{ID: effectiveHubID, Name: "hub", AddressPrefixes: []string{"10.0.0.0/16"}},
+		},
+		Subnets: []SubnetRecord{
+			{ID: effectiveAppID, VNetID: effectiveSpokeID, Name: "app", Prefixes: []string{"10.1.0.0/24"}, RouteTableID: effectiveRTID},
+		},
+		Peerings: []PeeringRecord{
+			{ID: effectiveSpokeID + "/virtualNetworkPeerings/to-hub", VNetID: effectiveSpokeID, Name: "to-hub",
+				RemoteVNetID: effectiveHubID, State: "Connected"},
+		},
+		Routes: routes,
+	}
+}
+
+// describeRoutes describes each route on a line: its source, prefix and next
+// hop, whether velora manages it and the route overriding it.
+func describeRoutes(routes []EffectiveRoute) string {
+	var lines []string
+	for _, r := range routes {
+		line := fmt.Sprintf("%s %s %s", r.Source, r.Prefix, r.NextHopType)
+		if r.NextHopIP != "" {
+			line += " " + r.NextHopIP
+		}
+		if r.Managed {
+			line += " managed"
+		}
+		if !r.Active() {
+			line += " overridden by " + r.OverriddenBy[strings.LastIndex(r.OverriddenBy, "/")+1:]
+			if r.OverriddenByManaged {
+				line += " managed"
+			}
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// effectiveDefaults are the system routes of the spoke and its peering.
+var effectiveDefaults = []string{
+	"system 10.1.0.0/16 VnetLocal",
+	"system 10.0.0.0/16 VNetPeering",
+}
+
+func TestEffectiveRoutes(t *testing.T) {
+	velora := func(route RouteRecord) bool { return strings.HasPrefix(route.Name, "velora-") }
+	tests := []struct {
+		name      string
+		snapshot  *Snapshot
+		subnetID  string
+		managed   func(route RouteRecord) bool
+		want      []string
+		wantNotes []string
+		wantErr   bool
+	}{
+		{
+			name:     "system routes",
+			snapshot: effectiveSnapshot(),
+			subnetID: effectiveAppID,
+			want: append(effectiveDefaults,
+				"system 0.0.0.0/0 Internet",
+				"system 10.0.0.0/8 None",
+				"system 172.16.0.0/12 None",
+				"system 192.168.0.0/16 None",
+				"system 100.64.0.0/10 None",
+			),
+		},
+		{
+			name: "shadowed by user-defined routes",
+			snapshot: effectiveSnapshot(
+				RouteRecord{Name: "velora-default", Prefix: "0.0.0.0/0", NextHopType: "VirtualAppliance", NextHopIP: "10.0.0.4"},
+				RouteRecord{Name: "blackhole", Prefix: "172.16.0.0/12", NextHopType: "None"},
+				// a shorter prefix overrides nothing, longest prefix match picks
+				RouteRecord{Name: "spoke-wide", Prefix: "10.1.0.0/17", NextHopType: "VirtualAppliance", NextHopIP: "10.0.0.4"},
+			),
+			subnetID: effectiveAppID,
+			managed:  velora,
+			want: append(effectiveDefaults,
+				"system 0.0.0.0/0 Internet overridden by velora-default managed",
+				"system 10.0.0.0/8 None",
+				"system 172.16.0.0/12 None overridden by blackhole",
+				"system 192.168.0.0/16 None",
+				"system 100.64.0.0/10 None",
+				"user 0.0.0.0/0 VirtualAppliance 10.0.0.4 managed",
+				"user 172.16.0.0/12 None",
+				"user 10.1.0.0/17 VirtualAppliance 10.0.0.4",
+			),
+		},
+		{
+			name: "VNet route shadowed",
+			snapshot: effectiveSnapshot(
+				RouteRecord{Name: "inspect-spoke", Prefix: "10.1.0.0/16", NextHopType: "VirtualAppliance", NextHopIP: "10.0.0.4"},
+			),
+			subnetID: effectiveAppID,
+			want: append([]string{
+				"system 10.1.0.0/16 VnetLocal overridden by inspect-spoke",
+				"system 10.0.0.0/16 VNetPeering",
+				"system 0.0.0.0/0 Internet",
+				"system 10.0.0.0/8 None",
+				"system 172.16.0.0/12 None",
+				"system 192.168.0.0/16 None",
+				"system 100.64.0.0/10 None",
+			}, "user 10.1.0.0/16 VirtualAppliance 10.0.0.4"),
+		},
+		{
+			name: "no managed predicate",
+			snapshot: effectiveSnapshot(
+				RouteRecord{Name: "velora-default", Prefix: "0.0.0.0/0", NextHopType: "VirtualAppliance", NextHopIP: "10.0.0.4"},
+			),
+			subnetID: effectiveAppID,
+			want: append(effectiveDefaults,
+				"system 0.0.0.0/0 Internet overridden by velora-default",
+				"system 10.0.0.0/8 None",
+				"system 172.16.0.0/12 None",
+				"system 192.168.0.0/16 None",
+				"system 100.64.0.0/10 None",
+				"user 0.0.0.0/0 VirtualAppliance 10.0.0.4",
+			),
+		},
+		{
+			name: "reserved prefix in the address space",
+			snapshot: func() *Snapshot {
+				s := effectiveSnapshot()
+				s.VNets[0].AddressPrefixes = []string{"10.1.0.0/16", "192.168.0.0/16"}
+				return s
+			}(),
+			subnetID: effectiveAppID,
+			want: []string{
+				"system 10.1.0.0/16 VnetLocal",
+				"system 192.168.0.0/16 VnetLocal",
+				"system 10.0.0.0/16 VNetPeering",
+				"system 0.0.0.0/0 Internet",
+				"system 10.0.0.0/8 None",
+				"system 172.16.0.0/12 None",
+				"system 100.64.0.0/10 None",
+			},
+		},
+		{
+			name: "reserved prefix included in the address space",
+			snapshot: func() *Snapshot {
+				s := effectiveSnapshot()
+				s.VNets[0].AddressPrefixes = []string{"100.0.0.0/8"}
+				return s
+			}(),
+			subnetID: effectiveAppID,
+			want: []string{
+				"system 100.0.0.0/8 VnetLocal",
+				"system 10.0.0.0/16 VNetPeering",
+				"system 0.0.0.0/0 Internet",
+				"system 10.0.0.0/8 None",
+				"system 172.16.0.0/12 None",
+				"system 192.168.0.0/16 None",
+			},
+		},
+		{
+			name: "disconnected peering and no route table",
+			snapshot: func() *Snapshot {
+				s := effectiveSnapshot(RouteRecord{Name: "unused", Prefix: "0.0.0.0/0", NextHopType: "None"})
+				s.Peerings[0].State = "Disconnected"
+				s.Subnets[0].RouteTableID = ""
+				return s
+			}(),
+			subnetID: effectiveAppID,
+			want: []string{
+				"system 10.1.0.0/16 VnetLocal",
+				"system 0.0.0.0/0 Internet",
+				"system 10.0.0.0/8 None",
+				"system 172.16.0.0/12 None",
+				"system 192.168.0.0/16 None",
+				"system 100.64.0.0/10 None",
+			},
+		},
+		{
+			name: "peering knows the remote prefixes",
+			snapshot: func() *Snapshot {
+				s := effectiveSnapshot()
+				s.Peerings[0].RemoteAddressPrefixes = []string{"10.0.0.0/16", "10.200.0.0/24"}
+				return s
+			}(),
+			subnetID: strings.ToUpper(effectiveAppID),
+			want: append(effectiveDefaults,
+				"system 10.200.0.0/24 VNetPeering",
+				"system 0.0.0.0/0 Internet",
+				"system 10.0.0.0/8 None",
+				"system 172.16.0.0/12 None",
+				"system 192.168.0.0/16 None",
+				"system 100.64.0.0/10 None",
+			),
+		},
+		{
+			name: "VNets missing",
+			snapshot: func() *Snapshot {
+				s := effectiveSnapshot()
+				s.VNets = nil
+				return s
+			}(),
+			subnetID: effectiveAppID,
+			want: []string{
+				"system 0.0.0.0/0 Internet",
+				"system 10.0.0.0/8 None",
+				"system 172.16.0.0/12 None",
+				"system 192.168.0.0/16 None",
+				"system 100.64.0.0/10 None",
+			},
+			wantNotes: []string{
+				"the address space of the VNet isn't in the inventory, refresh it for the VNet routes",
+				"the address space of peered VNet " + effectiveHubID + " isn't in the inventory",
+			},
+		},
+		{
+			name:     "missing subnet",
+			snapshot: effectiveSnapshot(),
+			subnetID: effectiveSpokeID + "/subnets/db",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EffectiveRoutes(tt.snapshot, tt.subnetID, tt.managed)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "is not in the inventory") {
+					t.Fatalf("EffectiveRoutes() error = %v, want the subnet not in the inventory", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EffectiveRoutes() error = %v", err)
+			}
+			if got.SubnetID != effectiveAppID || got.VNetID != effectiveSpokeID || !got.CollectedAt.Equal(tt.snapshot.CollectedAt) {
+				t.Errorf("EffectiveRoutes() = subnet %s of VNet %s collected at %s, want the app subnet as collected", got.SubnetID, got.VNetID, got.CollectedAt)
+			}
+			if routes, want := describeRoutes(got.Routes), strings.Join(tt.want, "\n"); routes != want {
+				t.Errorf("EffectiveRoutes() routes =\n%s\nwant:\n%s", routes, want)
+			}
+			if notes, want := strings.Join(got.Notes, "\n"), strings.Join(tt.wantNotes, "\n"); notes != want {
+				t.Errorf("EffectiveRoutes() notes =\n%s\nwant:\n%s", notes, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+)
+
+// HubInventory holds the hub-side data needed by the enforcers.
+type HubInventory struct {
+	AddressPrefixes []string
+	Peerings        []*armnetwork.VirtualNetworkPeering
+	RouteTables     []*armnetwork.RouteTable
+	FetchedAt       time.Time
+}
+
+// HubFetcher fetches the inventory of a single hub.
+type HubFetcher func(ctx context.Context, hub config.HubVNetConfig) (*HubInventory, error)
+
+// CacheStats are the hit and miss counters of the hub cache.
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// hubEntry is a cached hub inventory, or an in-flight fetch of it.
+type hubEntry struct {
+	ready chan struct{}
+	inv   *HubInventory
+	err   error
+}
+
+// HubCache is a concurrency-safe, lazily populated cache of hub inventories
+// keyed by hub name. Concurrent lookups for the same hub share a single fetch.
+type HubCache struct {
+	fetch HubFetcher
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*hubEntry
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewHubCache creates a new hub cache using the given fetcher.
+// A zero ttl keeps entries until they are invalidated.
+func NewHubCache(fetch HubFetcher, ttl time.Duration) *HubCache {
+	return &HubCache{
+		fetch:   fetch,
+		ttl:     ttl,
+		entries: make(map[string]*hubEntry),
+	}
+}
+
+// Get returns the inventory of the hub, fetching it on first use or after expiry.
+func (c *HubCache) Get(ctx context.Context, hub config.HubVNetConfig) (*HubInventory, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[hub.Name]
+	if ok && !c.expired(entry) {
+		c.mu.Unlock()
+		c.hits.Add(1)
+
+		// wait for an in-flight fetch started by another caller
+		select {
+		case <-entry.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return entry.inv, entry.err
+	}
+
+	entry = &hubEntry{ready: make(chan struct{})}
+	c.entries[hub.Name] = entry
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	c.load(ctx, hub, entry)
+	return entry.inv, entry.err
+}
+
+// load fetches the inventory of the entry and releases its waiters, even if
+// the fetch panics.
+func (c *HubCache) load(ctx context.Context, hub config.HubVNetConfig, entry *hubEntry) {
+	defer func() {
+		// failed fetches are not cached, the next caller retries. The entry
+		// may have been invalidated and replaced by a newer one meanwhile.
+		if entry.err != nil {
+			c.mu.Lock()
+			if c.entries[hub.Name] == entry {
+				delete(c.entries, hub.Name)
+			}
+			c.mu.Unlock()
+		}
+		close(entry.ready)
+	}()
+
+	// the waiters get this error if the fetch panics
+	entry.err = fmt.Errorf("fetching hub %s panicked", hub.Name)
+	entry.inv, entry.err = c.fetch(ctx, hub)
+}
+
+// Invalidate drops the cached inventory of the hub, it must be called after
+// any write to the hub side.
+func (c *HubCache) Invalidate(hubName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, hubName)
+}
+
+// Stats returns the hit and miss counters.
+func (c *HubCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+	}
+}
+
+// expired reports whether a completed entry is older than the TTL.
+// In-flight entries never expire. Must be called with c.mu held.
+func (c *HubCache) expired(entry *hubEntry) bool {
+	select {
+	case <-entry.ready:
+	default:
+		return false
+	}
+	if entry.err != nil || entry.inv == nil {
+		return true
+	}
+	return c.ttl > 0 && time.Since(entry.inv.FetchedAt) > c.ttl
+}
+
+// NewAzureHubFetcher returns a HubFetcher that reads the hub from Azure.
+func NewAzureHubFetcher(clientFactory *azure.ClientFactory) HubFetcher {
+	return func(ctx context.Context, hub config.HubVNetConfig) (*HubInventory, error) {
+		parts := azure.ExtractResourceIDParts(hub.VNetID)
+		if parts["subscriptions"] == "" || parts["resourceGroups"] == "" || parts["virtualNetworks"] == "" {
+			return nil, fmt.Errorf("invalid hub VNet ID format: %s", hub.VNetID)
+		}
+		resourceGroup := parts["resourceGroups"]
+		vnetName := parts["virtualNetworks"]
+
+		// the hub may live in a different subscription than the spokes
+		hubFactory := clientFactory.ForSubscription(parts["subscriptions"])
+
+		vnetsClient, err := hubFactory.NewVirtualNeworksClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		vnet, err := vnetsClient.Get(ctx, resourceGroup, vnetName, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hub VNet %s: %w", hub.Name, err)
+		}
+
+		inv := &HubInventory{}
+		if vnet.Properties != nil && vnet.Properties.AddressSpace != nil {
+			for _, prefix := range vnet.Properties.AddressSpace.AddressPrefixes {
+				if prefix != nil {
+					inv.AddressPrefixes = append(inv.AddressPrefixes, *prefix)
+				}
+			}
+		}
+		if len(inv.AddressPrefixes) == 0 {
+			inv.AddressPrefixes = hub.AddressPrefixes
+		}
+
+		peeringsClient, err := hubFactory.NewVirtualNetworkPeeringsClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		pager := peeringsClient.NewListPager(resourceGroup, vnetName, nil)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list peerings of hub %s: %w", hub.Name, err)
+			}
+			inv.Peerings = append(inv.Peerings, page.Value...)
+		}
+
+		// route tables associated with the hub subnets
+		routeTablesClient, err := hubFactory.NewRouteTablesClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
+		if vnet.Properties != nil {
+			for _, subnet := range vnet.Properties.Subnets {
+				if subnet == nil || subnet.Properties == nil || subnet.Properties.RouteTable == nil || subnet.Properties.RouteTable.ID == nil {
+					continue
+				}
+				rtID := *subnet.Properties.RouteTable.ID
+				if seen[rtID] {
+					continue
+				}
+				seen[rtID] = true
+
+				rtParts := azure.ExtractResourceIDParts(rtID)
+				rt, err := routeTablesClient.Get(ctx, rtParts["resourceGroups"], rtParts["routeTables"], nil)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get route table %s of hub %s: %w", rtID, hub.Name, err)
+				}
+				inv.RouteTables = append(inv.RouteTables, &rt.RouteTable)
+			}
+		}
+
+		inv.FetchedAt = time.Now()
+		return inv, nil
+	}
+}
@@ -0,0 +1,226 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+)
+
+var testHub = config.HubVNetConfig{Name: "hub-weu"}
+
+// countingFetcher returns a fetcher counting its calls, returning an
+// inventory fetched at fetchedAt.
+func countingFetcher(calls *atomic.Int32, fetchedAt func() time.Time) HubFetcher {
+	return func(ctx context.Context, hub config.HubVNetConfig) (*HubInventory, error) {
+		calls.Add(1)
+		return &HubInventory{AddressPrefixes: []string{"10.0.0.0/16"}, FetchedAt: fetchedAt()}, nil
+	}
+}
+
+func TestHubCacheSharesInFlightFetch(t *testing.T) {
+	const callers = 20
+	var calls atomic.Int32
+	release := make(chan struct{})
+	cache := NewHubCache(func(ctx context.Context, hub config.HubVNetConfig) (*HubInventory, error) {
+		calls.Add(1)
+		<-release
+		return &HubInventory{FetchedAt: time.Now()}, nil
+	}, 0)
+
+	var wg sync.WaitGroup
+	results := make([]*HubInventory, callers)
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			inv, err := cache.Get(context.Background(), testHub)
+			if err != nil {
+				t.Errorf("Get() error = %v", err)
+			}
+			results[i] = inv
+		}()
+	}
+	// every caller is waiting on the first fetch before it returns
+	for cache.Stats().Hits+cache.Stats().Misses < callers {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("fetches = %d, want 1", n)
+	}
+	for _, inv := range results {
+		if inv == nil || inv != results[0] {
+			t.Fatalf("callers got different inventories")
+		}
+	}
+	if got, want := cache.Stats(), (CacheStats{Hits: callers - 1, Misses: 1}); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestHubCacheTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		age     time.Duration
+		fetches int32
+	}{
+		{name: "fresh", ttl: time.Minute, age: 0, fetches: 1},
+		{name: "expired", ttl: time.Minute, age: 2 * time.Minute, fetches: 2},
+		{name: "no ttl", ttl: 0, age: 24 * time.Hour, fetches: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			cache := NewHubCache(countingFetcher(&calls, func() time.Time { return time.Now().Add(-tt.age) }), tt.ttl)
+			for range 2 {
+				if _, err := cache.Get(context.Background(), testHub); err != nil {
+					t.Fatalf("Get() error = %v", err)
+				}
+			}
+			if n := calls.Load(); n != tt.fetches {
+				t.Errorf("fetches = %d, want %d", n, tt.fetches)
+			}
+		})
+	}
+}
+
+func TestHubCacheInvalidate(t *testing.T) {
+	var calls atomic.Int32
+	cache := NewHubCache(countingFetcher(&calls, time.Now), 0)
+	ctx := context.Background()
+
+	if _, err := cache.Get(ctx, testHub); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	// other hubs are unaffected
+	cache.Invalidate("hub-neu")
+	if _, err := cache.Get(ctx, testHub); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("fetches = %d, want 1 before invalidation", n)
+	}
+	cache.Invalidate(testHub.Name)
+	if _, err := cache.Get(ctx, testHub); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("fetches = %d, want 2 after invalidation", n)
+	}
+}
+
+func TestHubCacheDoesNotCacheErrors(t *testing.T) {
+	var calls atomic.Int32
+	errFetch := errors.New("throttled")
+	cache := NewHubCache(func(ctx context.Context, hub config.HubVNetConfig) (*HubInventory, error) {
+		if calls.Add(1) == 1 {
+			return nil, errFetch
+		}
+		return &HubInventory{FetchedAt: time.Now()}, nil
+	}, 0)
+	ctx := context.Background()
+
+	if _, err := cache.Get(ctx, testHub); !errors.Is(err, errFetch) {
+		t.Fatalf("Get() error = %v, want %v", err, errFetch)
+	}
+	if inv, err := cache.Get(ctx, testHub); err != nil || inv == nil {
+		t.Fatalf("Get() after a failed fetch = %v, %v, want the fetched inventory", inv, err)
+	}
+	if _, err := cache.Get(ctx, testHub); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("fetches = %d, want 2", n)
+	}
+}
+
+// TestHubCacheFailedFetchKeepsNewerEntry checks a fetch failing after its
+// entry was invalidated and fetched again doesn't drop the newer entry.
+func TestHubCacheFailedFetchKeepsNewerEntry(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	cache := NewHubCache(func(ctx context.Context, hub config.HubVNetConfig) (*HubInventory, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-release
+			return nil, errors.New("throttled")
+		}
+		return &HubInventory{FetchedAt: time.Now()}, nil
+	}, 0)
+	ctx := context.Background()
+
+	failed := make(chan error)
+	go func() {
+		_, err := cache.Get(ctx, testHub)
+		failed <- err
+	}()
+	<-started
+	cache.Invalidate(testHub.Name)
+	if _, err := cache.Get(ctx, testHub); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	close(release)
+	if err := <-failed; err == nil {
+		t.Fatal("Get() of the failing fetch succeeded")
+	}
+
+	if _, err := cache.Get(ctx, testHub); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("fetches = %d, want 2, the newer entry stays cached", n)
+	}
+}
+
+// TestHubCachePanickingFetch checks the waiters of a panicking fetch are
+// released with an error, and the next caller fetches again.
+func TestHubCachePanickingFetch(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	cache := NewHubCache(func(ctx context.Context, hub config.HubVNetConfig) (*HubInventory, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-release
+			panic("fetch failed")
+		}
+		return &HubInventory{FetchedAt: time.Now()}, nil
+	}, 0)
+	ctx := context.Background()
+
+	go func() {
+		defer func() { _ = recover() }()
+		_, _ = cache.Get(ctx, testHub)
+	}()
+	<-started
+	waited := make(chan error)
+	go func() {
+		_, err := cache.Get(ctx, testHub)
+		waited <- err
+	}()
+	for cache.Stats().Hits == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	select {
+	case err := <-waited:
+		if err == nil {
+			t.Error("Get() waiting on a panicking fetch succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get() waiting on a panicking fetch hangs")
+	}
+	if inv, err := cache.Get(ctx, testHub); err != nil || inv == nil {
+		t.Errorf("Get() after a panicking fetch = %v, %v, want the fetched inventory", inv, err)
+	}
+}
@@ -0,0 +1,281 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+)
+
+// RunStats are the counters of a run inventory.
+type RunStats struct {
+	// Lists are the subscription-wide lists sent to ARM.
+	Lists uint64 `json:"lists"`
+	// Reused are the lists served from memory instead.
+	Reused uint64 `json:"reused"`
+	// Evicted are the lists dropped to stay within the memory budget.
+	Evicted uint64 `json:"evicted"`
+	// Uncached are the lists too large for the budget, returned without
+	// being kept.
+	Uncached uint64 `json:"uncached"`
+}
+
+// runEntry is a cached list of a subscription's VNets or route tables.
+type runEntry struct {
+	vnets       []*armnetwork.VirtualNetwork
+	routeTables []*armnetwork.RouteTable
+	// size counts the listed resources and their inline children.
+	size     int
+	lastUsed uint64
+}
+
+// RunInventory lists the VNets and route tables of a subscription once per
+// run and shares them between the controllers. VNets come with their subnets
+// and peerings, route tables with their routes, so controllers don't list
+// them per parent. It is safe for concurrent use, concurrent misses for the
+// same subscription may both list it.
+//
+// VNets are compacted as their pages are read, see compactSubnets. A run
+// limited to a resource group only lists that group's VNets, see
+// LimitToResourceGroup.
+//
+// Writes must invalidate the subscription, the next controller then sees
+// the change. Changes made outside velora during the run aren't seen, the
+// controllers write through plan.Write with the etag of the listed resource,
+// so ARM refuses to overwrite them.
+type RunInventory struct {
+	clientFactory *azure.ClientFactory
+	maxResources  int
+	// resourceGroups limit the VNets listed per lower-case subscription ID.
+	resourceGroups map[string]string
+
+	mu      sync.Mutex
+	entries map[string]*runEntry
+	size    int
+	clock   uint64
+
+	lists    atomic.Uint64
+	reused   atomic.Uint64
+	evicted  atomic.Uint64
+	uncached atomic.Uint64
+}
+
+// NewRunInventory creates an empty run inventory holding at most
+// maxResources resources.
+func NewRunInventory(clientFactory *azure.ClientFactory, maxResources int) *RunInventory {
+	return &RunInventory{
+		clientFactory:  clientFactory,
+		maxResources:   maxResources,
+		resourceGroups: make(map[string]string),
+		entries:        make(map[string]*runEntry),
+	}
+}
+
+// LimitToResourceGroup lists only the VNets of the resource group for the
+// subscription, with the resource group scoped list. Route tables are still
+// listed for the whole subscription, subnets can use the route tables of
+// other resource groups. It must be called before the first list.
+func (i *RunInventory) LimitToResourceGroup(subscriptionID, resourceGroup string) {
+	i.resourceGroups[strings.ToLower(subscriptionID)] = resourceGroup
+}
+
+// VNets returns the VNets of the subscription, with their subnets and peerings.
+func (i *RunInventory) VNets(ctx context.Context, subscriptionID string) ([]*armnetwork.VirtualNetwork, error) {
+	key := runKey("vnets", subscriptionID)
+	if entry := i.lookup(key); entry != nil {
+		return entry.vnets, nil
+	}
+
+	vnetsClient, err := i.clientFactory.ForSubscription(subscriptionID).NewVirtualNeworksClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entry := &runEntry{}
+	err = listVNets(ctx, vnetsClient, i.resourceGroups[strings.ToLower(subscriptionID)], func(vnet *armnetwork.VirtualNetwork) {
+		compactSubnets(vnet)
+		entry.vnets = append(entry.vnets, vnet)
+		entry.size++
+		if vnet.Properties != nil {
+			entry.size += len(vnet.Properties.Subnets) + len(vnet.Properties.VirtualNetworkPeerings)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	i.store(key, entry)
+	return entry.vnets, nil
+}
+
+// listVNets calls visit with every VNet of the subscription, or of the
+// resource group if it isn't empty, as their pages are read.
+func listVNets(ctx context.Context, vnetsClient *armnetwork.VirtualNetworksClient, resourceGroup string, visit func(vnet *armnetwork.VirtualNetwork)) error {
+	visitPage := func(vnets []*armnetwork.VirtualNetwork) {
+		for _, vnet := range vnets {
+			if vnet != nil {
+				visit(vnet)
+			}
+		}
+	}
+	if resourceGroup != "" {
+		pager := vnetsClient.NewListPager(resourceGroup, nil)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to list VNets of resource group %s: %w", resourceGroup, err)
+			}
+			visitPage(page.Value)
+		}
+		return nil
+	}
+
+	pager := vnetsClient.NewListAllPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list VNets: %w", err)
+		}
+		visitPage(page.Value)
+	}
+	return nil
+}
+
+// compactSubnets drops the IP configurations of network interfaces from the
+// subnets of the VNet as its page is read. They are the bulk of a large
+// VNet, one per NIC, and no controller reads them; the IP configurations of
+// gateways and injected services are kept.
+func compactSubnets(vnet *armnetwork.VirtualNetwork) {
+	if vnet.Properties == nil {
+		return
+	}
+	for _, subnet := range vnet.Properties.Subnets {
+		if subnet == nil || subnet.Properties == nil || len(subnet.Properties.IPConfigurations) == 0 {
+			continue
+		}
+		var kept []*armnetwork.IPConfiguration
+		for _, ipConfig := range subnet.Properties.IPConfigurations {
+			if ipConfig == nil || ipConfig.ID == nil ||
+				strings.Contains(strings.ToLower(*ipConfig.ID), "/providers/microsoft.network/networkinterfaces/") {
+				continue
+			}
+			kept = append(kept, ipConfig)
+		}
+		subnet.Properties.IPConfigurations = kept
+	}
+}
+
+// RouteTables returns the route tables of the subscription, with their
+// routes, by lower-case ID.
+func (i *RunInventory) RouteTables(ctx context.Context, subscriptionID string) (map[string]*armnetwork.RouteTable, error) {
+	key := runKey("routetables", subscriptionID)
+	entry := i.lookup(key)
+	if entry == nil {
+		routeTablesClient, err := i.clientFactory.ForSubscription(subscriptionID).NewRouteTablesClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		entry = &runEntry{}
+		pager := routeTablesClient.NewListAllPager(nil)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list route tables: %w", err)
+			}
+			for _, rt := range page.Value {
+				if rt == nil || rt.ID == nil {
+					continue
+				}
+				entry.routeTables = append(entry.routeTables, rt)
+				entry.size++
+				if rt.Properties != nil {
+					entry.size += len(rt.Properties.Routes)
+				}
+			}
+		}
+		i.store(key, entry)
+	}
+
+	byID := make(map[string]*armnetwork.RouteTable, len(entry.routeTables))
+	for _, rt := range entry.routeTables {
+		byID[strings.ToLower(*rt.ID)] = rt
+	}
+	return byID, nil
+}
+
+// Invalidate drops the cached lists of the subscription, it must be called
+// after any write to it.
+func (i *RunInventory) Invalidate(subscriptionID string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, kind := range []string{"vnets", "routetables"} {
+		key := runKey(kind, subscriptionID)
+		if entry, ok := i.entries[key]; ok {
+			i.size -= entry.size
+			delete(i.entries, key)
+		}
+	}
+}
+
+// Stats returns the counters of the inventory.
+func (i *RunInventory) Stats() RunStats {
+	return RunStats{
+		Lists:    i.lists.Load(),
+		Reused:   i.reused.Load(),
+		Evicted:  i.evicted.Load(),
+		Uncached: i.uncached.Load(),
+	}
+}
+
+// lookup returns the cached list, nil on a miss.
+func (i *RunInventory) lookup(key string) *runEntry {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	entry, ok := i.entries[key]
+	if !ok {
+		return nil
+	}
+	i.clock++
+	entry.lastUsed = i.clock
+	i.reused.Add(1)
+	return entry
+}
+
+// store caches a list, dropping the least recently used ones until it fits
+// the budget. A list larger than the budget on its own isn't kept.
+func (i *RunInventory) store(key string, entry *runEntry) {
+	i.lists.Add(1)
+	if entry.size > i.maxResources {
+		i.uncached.Add(1)
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if old, ok := i.entries[key]; ok {
+		i.size -= old.size
+		delete(i.entries, key)
+	}
+	for i.size+entry.size > i.maxResources {
+		var oldest string
+		for k, e := range i.entries {
+			if oldest == "" || e.lastUsed < i.entries[oldest].lastUsed {
+				oldest = k
+			}
+		}
+		i.size -= i.entries[oldest].size
+		delete(i.entries, oldest)
+		i.evicted.Add(1)
+	}
+	i.clock++
+	entry.lastUsed = i.clock
+	i.entries[key] = entry
+	i.size += entry.size
+}
+
+// runKey is the cache key of a list kind in a subscription.
+func runKey(kind, subscriptionID string) string {
+	return kind + "/" + strings.ToLower(subscriptionID)
+}
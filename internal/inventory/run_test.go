@@ -0,0 +1,58 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+)
+
+func TestRunInventoryCompactsSubnets(t *testing.T) {
+	const (
+		subscriptionID = "00000000-0000-0000-0000-000000000002"
+		nics           = 500
+	)
+	rg := "/subscriptions/" + subscriptionID + "/resourceGroups/spoke-rg/providers"
+	vnetID := rg + "/Microsoft.Network/virtualNetworks/spoke"
+	app := azuretest.Subnet("app", "10.1.0.0/24", "")
+	for i := 0; i < nics; i++ {
+		app.Properties.IPConfigurations = append(app.Properties.IPConfigurations,
+			&armnetwork.IPConfiguration{ID: to.Ptr(fmt.Sprintf("%s/Microsoft.Network/networkInterfaces/vm%d-nic/ipConfigurations/ipconfig1", rg, i))})
+	}
+	gateway := azuretest.Subnet("GatewaySubnet", "10.1.1.0/27", "")
+	gatewayIPConfig := rg + "/Microsoft.Network/virtualNetworkGateways/vpn/ipConfigurations/default"
+	apimIPConfig := rg + "/Microsoft.ApiManagement/service/apim/ipConfigurations/ip"
+	gateway.Properties.IPConfigurations = []*armnetwork.IPConfiguration{{ID: to.Ptr(gatewayIPConfig)}, {ID: to.Ptr(apimIPConfig)}}
+	arm := azuretest.NewServer()
+	arm.Put(vnetID, azuretest.VNet(vnetID, []string{"10.1.0.0/16"}, app, gateway))
+
+	cfg := &config.Config{}
+	inventory := NewRunInventory(azure.NewClientFactoryWithTransport(&cfg.Azure, azuretest.Credential{}, arm), 0)
+	vnets, err := inventory.VNets(context.Background(), subscriptionID)
+	if err != nil {
+		t.Fatalf("VNets() error = %v", err)
+	}
+	if len(vnets) != 1 || len(vnets[0].Properties.Subnets) != 2 {
+		t.Fatalf("VNets() = %d VNets, want the spoke with its subnets", len(vnets))
+	}
+
+	// the IP configurations of the NICs are dropped, the others kept
+	for _, subnet := range vnets[0].Properties.Subnets {
+		var kept []string
+		for _, ipConfig := range subnet.Properties.IPConfigurations {
+			kept = append(kept, *ipConfig.ID)
+		}
+		switch want := map[string]int{"app": 0, "GatewaySubnet": 2}[*subnet.Name]; {
+		case len(kept) != want:
+			t.Errorf("IP configurations of subnet %s = %d, want %d", *subnet.Name, len(kept), want)
+		case want > 0 && (kept[0] != gatewayIPConfig || kept[1] != apimIPConfig):
+			t.Errorf("IP configurations of subnet %s = %v, want the gateway and API Management ones", *subnet.Name, kept)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package inventory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Searchable record kinds.
+const (
+	KindRoutes   = "routes"
+	KindPeerings = "peerings"
+	KindSubnets  = "subnets"
+)
+
+// DefaultSearchLimit is the page size used when the query doesn't set one.
+const DefaultSearchLimit = 100
+
+// searchFields are the fields that can be filtered on, per kind.
+var searchFields = map[string][]string{
+	KindRoutes:   {"prefix", "next-hop-ip", "next-hop-type", "route-table", "subscription"},
+	KindPeerings: {"remote-vnet", "state", "vnet", "subscription"},
+	KindSubnets:  {"route-table", "nsg", "vnet", "subscription"},
+}
+
+// Filter matches records whose field equals the value, case-insensitively.
+type Filter struct {
+	Field string
+	Value string
+}
+
+// Query selects a page of the records of a kind matching all filters.
+type Query struct {
+	Kind    string
+	Filters []Filter
+	Offset  int
+	Limit   int
+}
+
+// ParseQuery parses field=value terms into a query over the kind.
+func ParseQuery(kind string, terms []string, offset, limit int) (Query, error) {
+	fields, ok := searchFields[kind]
+	if !ok {
+		return Query{}, fmt.Errorf("unknown kind %q, allowed values are %s, %s, %s", kind, KindRoutes, KindPeerings, KindSubnets)
+	}
+	if offset < 0 || limit < 0 {
+		return Query{}, fmt.Errorf("offset and limit must not be negative")
+	}
+	if limit == 0 {
+		limit = DefaultSearchLimit
+	}
+
+	q := Query{Kind: kind, Offset: offset, Limit: limit}
+	for _, term := range terms {
+		field, value, ok := strings.Cut(term, "=")
+		if !ok || value == "" {
+			return Query{}, fmt.Errorf("invalid filter %q, expected field=value", term)
+		}
+		field = strings.ToLower(strings.TrimSpace(field))
+		if !contains(fields, field) {
+			return Query{}, fmt.Errorf("unknown field %q for %s, allowed fields are %s", field, kind, strings.Join(fields, ", "))
+		}
+		q.Filters = append(q.Filters, Filter{Field: field, Value: strings.TrimSpace(value)})
+	}
+	return q, nil
+}
+
+// SearchResult is a page of matching records.
+type SearchResult struct {
+	Kind string `json:"kind"`
+	// CollectedAt is when the searched inventory was collected.
+	CollectedAt time.Time `json:"collectedAt"`
+	Total       int       `json:"total"`
+	Offset      int       `json:"offset"`
+	// NextOffset is the offset of the next page, 0 if this is the last one.
+	NextOffset int             `json:"nextOffset,omitempty"`
+	Routes     []RouteRecord   `json:"routes,omitempty"`
+	Peerings   []PeeringRecord `json:"peerings,omitempty"`
+	Subnets    []SubnetRecord  `json:"subnets,omitempty"`
+}
+
+// index maps field values to the positions of the records having them, so
+// a query only scans the records of its most selective filter.
+type index map[string]map[string][]int
+
+// newIndex indexes n records by the values fieldsOf returns for each.
+func newIndex(n int, fieldsOf func(i int) map[string]string) index {
+	idx := make(index)
+	for i := 0; i < n; i++ {
+		for field, value := range fieldsOf(i) {
+			if value == "" {
+				continue
+			}
+			if idx[field] == nil {
+				idx[field] = make(map[string][]int)
+			}
+			key := strings.ToLower(value)
+			idx[field][key] = append(idx[field][key], i)
+		}
+	}
+	return idx
+}
+
+// match returns the positions of the records matching all filters, in order.
+func (idx index) match(filters []Filter, n int) []int {
+	if len(filters) == 0 {
+		all := make([]int, n)
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+
+	// start from the smallest candidate set and look its positions up in
+	// the others, which are sorted, so the larger sets are never scanned
+	buckets := make([][]int, len(filters))
+	for i, f := range filters {
+		buckets[i] = idx[f.Field][strings.ToLower(f.Value)]
+	}
+	sort.Slice(buckets, func(i, j int) bool { return len(buckets[i]) < len(buckets[j]) })
+
+	var result []int
+	for _, i := range buckets[0] {
+		if inAll(buckets[1:], i) {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// inAll reports whether the position is in every sorted bucket.
+func inAll(buckets [][]int, i int) bool {
+	for _, bucket := range buckets {
+		if j := sort.SearchInts(bucket, i); j == len(bucket) || bucket[j] != i {
+			return false
+		}
+	}
+	return true
+}
+
+// Searcher answers queries over a snapshot using per-kind indexes.
+type Searcher struct {
+	snapshot *Snapshot
+	indexes  map[string]index
+}
+
+// NewSearcher indexes the snapshot for searching.
+func NewSearcher(s *Snapshot) *Searcher {
+	return &Searcher{
+		snapshot: s,
+		indexes: map[string]index{
+			KindRoutes: newIndex(len(s.Routes), func(i int) map[string]string {
+				r := s.Routes[i]
+				return map[string]string{
+					"prefix":        r.Prefix,
+					"next-hop-ip":   r.NextHopIP,
+					"next-hop-type": r.NextHopType,
+					"route-table":   r.RouteTableID,
+					"subscription":  r.SubscriptionID,
+				}
+			}),
+			KindPeerings: newIndex(len(s.Peerings), func(i int) map[string]string {
+				p := s.Peerings[i]
+				return map[string]string{
+					"remote-vnet":  p.RemoteVNetID,
+					"state":        p.State,
+					"vnet":         p.VNetID,
+					"subscription": p.SubscriptionID,
+				}
+			}),
+			KindSubnets: newIndex(len(s.Subnets), func(i int) map[string]string {
+				sn := s.Subnets[i]
+				return map[string]string{
+					"route-table":  sn.RouteTableID,
+					"nsg":          sn.NSGID,
+					"vnet":         sn.VNetID,
+					"subscription": sn.SubscriptionID,
+				}
+			}),
+		},
+	}
+}
+
+// Search returns the page of records matching the query.
+func (s *Searcher) Search(q Query) SearchResult {
+	var n int
+	switch q.Kind {
+	case KindRoutes:
+		n = len(s.snapshot.Routes)
+	case KindPeerings:
+		n = len(s.snapshot.Peerings)
+	case KindSubnets:
+		n = len(s.snapshot.Subnets)
+	}
+
+	matched := s.indexes[q.Kind].match(q.Filters, n)
+	result := SearchResult{
+		Kind:        q.Kind,
+		CollectedAt: s.snapshot.CollectedAt,
+		Total:       len(matched),
+		Offset:      q.Offset,
+	}
+	if q.Offset >= len(matched) {
+		return result
+	}
+	end := q.Offset + q.Limit
+	if end < len(matched) {
+		result.NextOffset = end
+	} else {
+		end = len(matched)
+	}
+
+	for _, i := range matched[q.Offset:end] {
+		switch q.Kind {
+		case KindRoutes:
+			result.Routes = append(result.Routes, s.snapshot.Routes[i])
+		case KindPeerings:
+			result.Peerings = append(result.Peerings, s.snapshot.Peerings[i])
+		case KindSubnets:
+			result.Subnets = append(result.Subnets, s.snapshot.Subnets[i])
+		}
+	}
+	return result
+}
+
+// contains reports whether the value is in the list.
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
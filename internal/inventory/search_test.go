@@ -0,0 +1,298 @@
+package inventory
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		terms   []string
+		offset  int
+		limit   int
+		want    Query
+		wantErr string
+	}{
+		{
+			name: "no filters",
+			kind: KindRoutes,
+			want: Query{Kind: KindRoutes, Limit: DefaultSearchLimit},
+		},
+		{
+			name:   "filters, offset and limit",
+			kind:   KindRoutes,
+			terms:  []string{"next-hop-ip=10.50.0.4", "prefix=0.0.0.0/0"},
+			offset: 20,
+			limit:  10,
+			want: Query{Kind: KindRoutes, Offset: 20, Limit: 10, Filters: []Filter{
+				{Field: "next-hop-ip", Value: "10.50.0.4"},
+				{Field: "prefix", Value: "0.0.0.0/0"},
+			}},
+		},
+		{
+			name:  "field case and spaces",
+			kind:  KindPeerings,
+			terms: []string{" State = Disconnected "},
+			want:  Query{Kind: KindPeerings, Limit: DefaultSearchLimit, Filters: []Filter{{Field: "state", Value: "Disconnected"}}},
+		},
+		{
+			name:  "value holding an equal sign",
+			kind:  KindSubnets,
+			terms: []string{"vnet=/a=b"},
+			want:  Query{Kind: KindSubnets, Limit: DefaultSearchLimit, Filters: []Filter{{Field: "vnet", Value: "/a=b"}}},
+		},
+		{
+			name:    "unknown kind",
+			kind:    "vnets",
+			wantErr: `unknown kind "vnets"`,
+		},
+		{
+			name:    "field of another kind",
+			kind:    KindRoutes,
+			terms:   []string{"nsg=x"},
+			wantErr: `unknown field "nsg" for routes`,
+		},
+		{
+			name:    "term without value",
+			kind:    KindRoutes,
+			terms:   []string{"prefix="},
+			wantErr: `invalid filter "prefix="`,
+		},
+		{
+			name:    "term without equal sign",
+			kind:    KindRoutes,
+			terms:   []string{"prefix"},
+			wantErr: `invalid filter "prefix"`,
+		},
+		{
+			name:    "negative offset",
+			kind:    KindRoutes,
+			offset:  -1,
+			wantErr: "must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseQuery(tt.kind, tt.terms, tt.offset, tt.limit)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseQuery() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseQuery() error = %v", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("ParseQuery() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testSnapshot returns a snapshot of n route tables in two subscriptions,
+// each with a default route to one of ten NVAs and a local route.
+func testSnapshot(n int) *Snapshot {
+	s := &Snapshot{CollectedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	for i := 0; i < n; i++ {
+		subID := fmt.Sprintf("sub-%d", i%2)
+		rtID := fmt.Sprintf("/subscriptions/%s/resourceGroups/rg/providers/Microsoft.Network/routeTables/rt-%d", subID, i)
+		s.Routes = append(s.Routes,
+			RouteRecord{ID: rtID + "/routes/default", SubscriptionID: subID, RouteTableID: rtID, Name: "default",
+				Prefix: "0.0.0.0/0", NextHopType: "VirtualAppliance", NextHopIP: fmt.Sprintf("10.50.0.%d", i%10)},
+			RouteRecord{ID: rtID + "/routes/local", SubscriptionID: subID, RouteTableID: rtID, Name: "local",
+				Prefix: fmt.Sprintf("10.%d.0.0/16", i%250), NextHopType: "VnetLocal"},
+		)
+	}
+	return s
+}
+
+// routeIDs returns the IDs of the routes of the result.
+func routeIDs(result SearchResult) []string {
+	var ids []string
+	for _, r := range result.Routes {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	searcher := NewSearcher(testSnapshot(40))
+	rt := func(i int) string {
+		return fmt.Sprintf("/subscriptions/sub-%d/resourceGroups/rg/providers/Microsoft.Network/routeTables/rt-%d", i%2, i)
+	}
+
+	tests := []struct {
+		name           string
+		filters        []Filter
+		offset, limit  int
+		wantTotal      int
+		wantIDs        []string
+		wantNextOffset int
+	}{
+		{
+			name:      "single filter",
+			filters:   []Filter{{Field: "next-hop-ip", Value: "10.50.0.3"}},
+			limit:     10,
+			wantTotal: 4,
+			wantIDs:   []string{rt(3) + "/routes/default", rt(13) + "/routes/default", rt(23) + "/routes/default", rt(33) + "/routes/default"},
+		},
+		{
+			name:      "filters are ANDed",
+			filters:   []Filter{{Field: "next-hop-ip", Value: "10.50.0.3"}, {Field: "subscription", Value: "sub-1"}},
+			limit:     10,
+			wantTotal: 4,
+			wantIDs:   []string{rt(3) + "/routes/default", rt(13) + "/routes/default", rt(23) + "/routes/default", rt(33) + "/routes/default"},
+		},
+		{
+			name:      "disjoint filters",
+			filters:   []Filter{{Field: "next-hop-ip", Value: "10.50.0.3"}, {Field: "subscription", Value: "sub-0"}},
+			limit:     10,
+			wantTotal: 0,
+		},
+		{
+			name:      "case-insensitive",
+			filters:   []Filter{{Field: "route-table", Value: strings.ToUpper(rt(7))}, {Field: "next-hop-type", Value: "vnetlocal"}},
+			limit:     10,
+			wantTotal: 1,
+			wantIDs:   []string{rt(7) + "/routes/local"},
+		},
+		{
+			name:      "unknown value",
+			filters:   []Filter{{Field: "next-hop-ip", Value: "192.0.2.1"}},
+			limit:     10,
+			wantTotal: 0,
+		},
+		{
+			name:           "first page",
+			filters:        []Filter{{Field: "prefix", Value: "0.0.0.0/0"}},
+			limit:          3,
+			wantTotal:      40,
+			wantIDs:        []string{rt(0) + "/routes/default", rt(1) + "/routes/default", rt(2) + "/routes/default"},
+			wantNextOffset: 3,
+		},
+		{
+			name:      "last page",
+			filters:   []Filter{{Field: "prefix", Value: "0.0.0.0/0"}},
+			offset:    38,
+			limit:     3,
+			wantTotal: 40,
+			wantIDs:   []string{rt(38) + "/routes/default", rt(39) + "/routes/default"},
+		},
+		{
+			name:      "offset past the end",
+			filters:   []Filter{{Field: "prefix", Value: "0.0.0.0/0"}},
+			offset:    40,
+			limit:     3,
+			wantTotal: 40,
+		},
+		{
+			name:           "no filters",
+			limit:          2,
+			wantTotal:      80,
+			wantIDs:        []string{rt(0) + "/routes/default", rt(0) + "/routes/local"},
+			wantNextOffset: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := searcher.Search(Query{Kind: KindRoutes, Filters: tt.filters, Offset: tt.offset, Limit: tt.limit})
+			if result.Total != tt.wantTotal || result.NextOffset != tt.wantNextOffset {
+				t.Errorf("Search() total = %d, next offset = %d, want %d, %d", result.Total, result.NextOffset, tt.wantTotal, tt.wantNextOffset)
+			}
+			if got := routeIDs(result); strings.Join(got, ",") != strings.Join(tt.wantIDs, ",") {
+				t.Errorf("Search() routes = %v, want %v", got, tt.wantIDs)
+			}
+			if want := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC); !result.CollectedAt.Equal(want) {
+				t.Errorf("Search() collected at %s, want %s", result.CollectedAt, want)
+			}
+		})
+	}
+}
+
+func TestSearchPeeringsAndSubnets(t *testing.T) {
+	const remote = "/subscriptions/hub/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub"
+	searcher := NewSearcher(&Snapshot{
+		Peerings: []PeeringRecord{
+			{ID: "p1", SubscriptionID: "sub-1", VNetID: "vnet-1", RemoteVNetID: remote, State: "Connected"},
+			{ID: "p2", SubscriptionID: "sub-2", VNetID: "vnet-2", RemoteVNetID: remote, State: "Disconnected"},
+			{ID: "p3", SubscriptionID: "sub-2", VNetID: "vnet-2", RemoteVNetID: "other", State: "Connected"},
+		},
+		Subnets: []SubnetRecord{
+			{ID: "s1", VNetID: "vnet-1", RouteTableID: "rt-1", NSGID: "nsg-1"},
+			{ID: "s2", VNetID: "vnet-1", RouteTableID: "rt-1"},
+			{ID: "s3", VNetID: "vnet-2", NSGID: "nsg-1"},
+		},
+	})
+
+	peerings := searcher.Search(Query{Kind: KindPeerings, Filters: []Filter{{Field: "remote-vnet", Value: strings.ToLower(remote)}}, Limit: 10})
+	if len(peerings.Peerings) != 2 || peerings.Peerings[0].ID != "p1" || peerings.Peerings[1].ID != "p2" {
+		t.Errorf("peerings of the remote VNet = %+v, want p1, p2", peerings.Peerings)
+	}
+	subnets := searcher.Search(Query{Kind: KindSubnets, Filters: []Filter{{Field: "nsg", Value: "nsg-1"}, {Field: "route-table", Value: "rt-1"}}, Limit: 10})
+	if len(subnets.Subnets) != 1 || subnets.Subnets[0].ID != "s1" {
+		t.Errorf("subnets of the NSG and route table = %+v, want s1", subnets.Subnets)
+	}
+	if routes := searcher.Search(Query{Kind: KindRoutes, Limit: 10}); routes.Total != 0 {
+		t.Errorf("routes of a snapshot without routes = %d, want 0", routes.Total)
+	}
+}
+
+func TestSearchLargeInventory(t *testing.T) {
+	searcher := NewSearcher(testSnapshot(100000))
+	query := Query{Kind: KindRoutes, Filters: []Filter{
+		{Field: "subscription", Value: "sub-1"},
+		{Field: "prefix", Value: "0.0.0.0/0"},
+		{Field: "route-table", Value: "/subscriptions/sub-1/resourceGroups/rg/providers/Microsoft.Network/routeTables/rt-99999"},
+	}, Limit: DefaultSearchLimit}
+
+	result := searcher.Search(query)
+	if result.Total != 1 || result.Routes[0].NextHopIP != "10.50.0.9" {
+		t.Fatalf("Search() = %+v, want the default route of rt-99999", result)
+	}
+	// the intersection starts from the single route table's two routes,
+	// scanning the 100000 routes of a subscription would allocate for each
+	allocs := testing.AllocsPerRun(10, func() { searcher.Search(query) })
+	if allocs > 20 {
+		t.Errorf("Search() allocations = %.0f, want the selective filter to bound the work", allocs)
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	searcher := NewSearcher(testSnapshot(100000))
+	query := Query{Kind: KindRoutes, Filters: []Filter{{Field: "next-hop-ip", Value: "10.50.0.4"}, {Field: "subscription", Value: "sub-0"}}, Limit: DefaultSearchLimit}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		searcher.Search(query)
+	}
+}
+
+func TestSnapshotMerge(t *testing.T) {
+	s := &Snapshot{
+		Subscriptions: []string{"sub-1", "sub-2"},
+		Routes:        []RouteRecord{{ID: "old-1", SubscriptionID: "sub-1"}, {ID: "old-2", SubscriptionID: "sub-2"}},
+		Subnets:       []SubnetRecord{{ID: "subnet-1", SubscriptionID: "sub-1"}},
+	}
+	collectedAt := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	s.Merge(&Snapshot{
+		CollectedAt:   collectedAt,
+		Subscriptions: []string{"SUB-1"},
+		Routes:        []RouteRecord{{ID: "new-1", SubscriptionID: "SUB-1"}},
+	})
+
+	if got := routeIDs(SearchResult{Routes: s.Routes}); strings.Join(got, ",") != "new-1,old-2" {
+		t.Errorf("routes after merge = %v, want new-1, old-2", got)
+	}
+	if len(s.Subnets) != 0 {
+		t.Errorf("subnets after merge = %+v, want the refreshed subscription's only", s.Subnets)
+	}
+	if strings.Join(s.Subscriptions, ",") != "SUB-1,sub-2" || !s.CollectedAt.Equal(collectedAt) {
+		t.Errorf("merged snapshot covers %v collected at %s, want SUB-1, sub-2 at %s", s.Subscriptions, s.CollectedAt, collectedAt)
+	}
+}
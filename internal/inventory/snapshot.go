@@ -0,0 +1,311 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// snapshotKey is the state store key holding the latest inventory snapshot.
+const snapshotKey = "inventory-snapshot"
+
+// ErrNoSnapshot is returned when no inventory has been collected yet.
+var ErrNoSnapshot = errors.New("no inventory collected yet, run with --refresh")
+
+// RouteRecord is a route of a route table, with the subnets using the table.
+type RouteRecord struct {
+	ID             string   `json:"id"`
+	SubscriptionID string   `json:"subscriptionId"`
+	RouteTableID   string   `json:"routeTableId"`
+	Name           string   `json:"name"`
+	Prefix         string   `json:"prefix"`
+	NextHopType    string   `json:"nextHopType"`
+	NextHopIP      string   `json:"nextHopIp,omitempty"`
+	Subnets        []string `json:"subnets,omitempty"`
+}
+
+// PeeringRecord is a VNet peering.
+type PeeringRecord struct {
+	ID             string `json:"id"`
+	SubscriptionID string `json:"subscriptionId"`
+	VNetID         string `json:"vnetId"`
+	Name           string `json:"name"`
+	RemoteVNetID   string `json:"remoteVnetId"`
+	State          string `json:"state"`
+	SyncLevel      string `json:"syncLevel,omitempty"`
+	// RemoteAddressPrefixes are the address prefixes of the remote VNet as
+	// the peering knows them.
+	RemoteAddressPrefixes []string `json:"remoteAddressPrefixes,omitempty"`
+}
+
+// VNetRecord is a VNet with its address space.
+type VNetRecord struct {
+	ID              string            `json:"id"`
+	SubscriptionID  string            `json:"subscriptionId"`
+	Name            string            `json:"name"`
+	AddressPrefixes []string          `json:"addressPrefixes"`
+	Tags            map[string]string `json:"tags,omitempty"`
+}
+
+// SubnetRecord is a subnet with its route table and NSG.
+type SubnetRecord struct {
+	ID             string   `json:"id"`
+	SubscriptionID string   `json:"subscriptionId"`
+	VNetID         string   `json:"vnetId"`
+	Name           string   `json:"name"`
+	Prefixes       []string `json:"prefixes"`
+	RouteTableID   string   `json:"routeTableId,omitempty"`
+	NSGID          string   `json:"nsgId,omitempty"`
+}
+
+// Snapshot is the routing inventory of the managed subscriptions at a point in time.
+type Snapshot struct {
+	CollectedAt time.Time `json:"collectedAt"`
+	// Subscriptions are the subscriptions the snapshot covers.
+	Subscriptions []string        `json:"subscriptions"`
+	Routes        []RouteRecord   `json:"routes"`
+	Peerings      []PeeringRecord `json:"peerings"`
+	Subnets       []SubnetRecord  `json:"subnets"`
+	// VNets are missing from snapshots collected before they were added.
+	VNets []VNetRecord `json:"vnets,omitempty"`
+}
+
+// Collect reads the VNets and route tables of the subscriptions from Azure.
+func Collect(ctx context.Context, clientFactory *azure.ClientFactory, subscriptionIDs []string) (*Snapshot, error) {
+	s := &Snapshot{CollectedAt: time.Now().UTC(), Subscriptions: subscriptionIDs}
+	for _, subID := range subscriptionIDs {
+		if err := s.collectSubscription(ctx, clientFactory.ForSubscription(subID), subID); err != nil {
+			return nil, fmt.Errorf("failed to collect inventory of subscription %s: %w", subID, err)
+		}
+	}
+	return s, nil
+}
+
+// collectSubscription adds the VNets, subnets, peerings and routes of the subscription.
+func (s *Snapshot) collectSubscription(ctx context.Context, clientFactory *azure.ClientFactory, subscriptionID string) error {
+	vnetsClient, err := clientFactory.NewVirtualNeworksClient(ctx)
+	if err != nil {
+		return err
+	}
+	vnetPager := vnetsClient.NewListAllPager(nil)
+	for vnetPager.More() {
+		page, err := vnetPager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list virtual networks: %w", err)
+		}
+		for _, vnet := range page.Value {
+			if vnet == nil || vnet.ID == nil || vnet.Properties == nil {
+				continue
+			}
+			s.addVNet(subscriptionID, vnet)
+		}
+	}
+
+	routeTablesClient, err := clientFactory.NewRouteTablesClient(ctx)
+	if err != nil {
+		return err
+	}
+	rtPager := routeTablesClient.NewListAllPager(nil)
+	for rtPager.More() {
+		page, err := rtPager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list route tables: %w", err)
+		}
+		for _, rt := range page.Value {
+			if rt == nil || rt.ID == nil || rt.Properties == nil {
+				continue
+			}
+			s.addRouteTable(subscriptionID, rt)
+		}
+	}
+	return nil
+}
+
+// addVNet adds the VNet, its subnets and peerings.
+func (s *Snapshot) addVNet(subscriptionID string, vnet *armnetwork.VirtualNetwork) {
+	vnetRecord := VNetRecord{ID: *vnet.ID, SubscriptionID: subscriptionID, Name: stringValue(vnet.Name)}
+	for key, value := range vnet.Tags {
+		if value == nil {
+			continue
+		}
+		if vnetRecord.Tags == nil {
+			vnetRecord.Tags = make(map[string]string)
+		}
+		vnetRecord.Tags[key] = *value
+	}
+	if vnet.Properties.AddressSpace != nil {
+		vnetRecord.AddressPrefixes = stringValues(vnet.Properties.AddressSpace.AddressPrefixes)
+	}
+	s.VNets = append(s.VNets, vnetRecord)
+
+	for _, subnet := range vnet.Properties.Subnets {
+		if subnet == nil || subnet.ID == nil || subnet.Properties == nil {
+			continue
+		}
+		record := SubnetRecord{
+			ID:             *subnet.ID,
+			SubscriptionID: subscriptionID,
+			VNetID:         *vnet.ID,
+			Name:           stringValue(subnet.Name),
+		}
+		if prefix := stringValue(subnet.Properties.AddressPrefix); prefix != "" {
+			record.Prefixes = append(record.Prefixes, prefix)
+		}
+		for _, prefix := range subnet.Properties.AddressPrefixes {
+			if v := stringValue(prefix); v != "" {
+				record.Prefixes = append(record.Prefixes, v)
+			}
+		}
+		if subnet.Properties.RouteTable != nil {
+			record.RouteTableID = stringValue(subnet.Properties.RouteTable.ID)
+		}
+		if subnet.Properties.NetworkSecurityGroup != nil {
+			record.NSGID = stringValue(subnet.Properties.NetworkSecurityGroup.ID)
+		}
+		s.Subnets = append(s.Subnets, record)
+	}
+
+	for _, peering := range vnet.Properties.VirtualNetworkPeerings {
+		if peering == nil || peering.ID == nil || peering.Properties == nil {
+			continue
+		}
+		record := PeeringRecord{
+			ID:             *peering.ID,
+			SubscriptionID: subscriptionID,
+			VNetID:         *vnet.ID,
+			Name:           stringValue(peering.Name),
+		}
+		if peering.Properties.RemoteVirtualNetwork != nil {
+			record.RemoteVNetID = stringValue(peering.Properties.RemoteVirtualNetwork.ID)
+		}
+		if peering.Properties.PeeringState != nil {
+			record.State = string(*peering.Properties.PeeringState)
+		}
+		if peering.Properties.PeeringSyncLevel != nil {
+			record.SyncLevel = string(*peering.Properties.PeeringSyncLevel)
+		}
+		if peering.Properties.RemoteAddressSpace != nil {
+			record.RemoteAddressPrefixes = stringValues(peering.Properties.RemoteAddressSpace.AddressPrefixes)
+		}
+		s.Peerings = append(s.Peerings, record)
+	}
+}
+
+// addRouteTable adds the routes of the route table.
+func (s *Snapshot) addRouteTable(subscriptionID string, rt *armnetwork.RouteTable) {
+	var subnets []string
+	for _, subnet := range rt.Properties.Subnets {
+		if subnet != nil && subnet.ID != nil {
+			subnets = append(subnets, *subnet.ID)
+		}
+	}
+
+	for _, route := range rt.Properties.Routes {
+		if route == nil || route.ID == nil || route.Properties == nil {
+			continue
+		}
+		record := RouteRecord{
+			ID:             *route.ID,
+			SubscriptionID: subscriptionID,
+			RouteTableID:   *rt.ID,
+			Name:           stringValue(route.Name),
+			Prefix:         stringValue(route.Properties.AddressPrefix),
+			NextHopIP:      stringValue(route.Properties.NextHopIPAddress),
+			Subnets:        subnets,
+		}
+		if route.Properties.NextHopType != nil {
+			record.NextHopType = string(*route.Properties.NextHopType)
+		}
+		s.Routes = append(s.Routes, record)
+	}
+}
+
+// Merge replaces the records of the subscriptions other covers with the
+// ones of other, so a targeted refresh keeps the rest of the snapshot.
+// The snapshot takes the collection time of other.
+func (s *Snapshot) Merge(other *Snapshot) {
+	refreshed := make(map[string]bool)
+	for _, subID := range other.Subscriptions {
+		refreshed[strings.ToLower(subID)] = true
+	}
+	keep := func(subID string) bool { return !refreshed[strings.ToLower(subID)] }
+
+	routes := other.Routes
+	for _, r := range s.Routes {
+		if keep(r.SubscriptionID) {
+			routes = append(routes, r)
+		}
+	}
+	peerings := other.Peerings
+	for _, p := range s.Peerings {
+		if keep(p.SubscriptionID) {
+			peerings = append(peerings, p)
+		}
+	}
+	subnets := other.Subnets
+	for _, sn := range s.Subnets {
+		if keep(sn.SubscriptionID) {
+			subnets = append(subnets, sn)
+		}
+	}
+	vnets := other.VNets
+	for _, v := range s.VNets {
+		if keep(v.SubscriptionID) {
+			vnets = append(vnets, v)
+		}
+	}
+	subscriptions := other.Subscriptions
+	for _, subID := range s.Subscriptions {
+		if keep(subID) {
+			subscriptions = append(subscriptions, subID)
+		}
+	}
+
+	s.Routes, s.Peerings, s.Subnets, s.VNets, s.Subscriptions = routes, peerings, subnets, vnets, subscriptions
+	s.CollectedAt = other.CollectedAt
+}
+
+// SaveSnapshot persists the snapshot as the latest one.
+func SaveSnapshot(store state.Store, s *Snapshot) error {
+	if err := store.Put(snapshotKey, s); err != nil {
+		return fmt.Errorf("failed to save inventory snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot reads the latest snapshot, ErrNoSnapshot if there's none.
+func LoadSnapshot(store state.Store) (*Snapshot, error) {
+	s := &Snapshot{}
+	if err := store.Get(snapshotKey, s); err != nil {
+		if errors.Is(err, state.ErrNotFound) {
+			return nil, ErrNoSnapshot
+		}
+		return nil, fmt.Errorf("failed to load inventory snapshot: %w", err)
+	}
+	return s, nil
+}
+
+// stringValue returns the value of p, or an empty string if p is nil.
+func stringValue(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
+
+// stringValues returns the non-empty values of the list.
+func stringValues(list []*string) []string {
+	var values []string
+	for _, p := range list {
+		if v := stringValue(p); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
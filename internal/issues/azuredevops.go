@@ -0,0 +1,171 @@
+package issues
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/redact"
+)
+
+const (
+	// azureDevOpsScope is the token scope of Azure DevOps.
+	azureDevOpsScope = "499b84ac-1321-427f-aa17-267ca6b56798/.default"
+	// azureDevOpsAPIVersion is the REST API version of Azure Boards.
+	azureDevOpsAPIVersion = "7.1"
+	// jsonPatch is the content type of work item writes.
+	jsonPatch = "application/json-patch+json"
+)
+
+// azureDevOps files work items in Azure Boards.
+type azureDevOps struct {
+	cfg    config.AzureDevOpsIssuesConfig
+	cred   azcore.TokenCredential
+	client *client
+}
+
+// newAzureDevOps creates a new Azure Boards tracker.
+func newAzureDevOps(cfg config.AzureDevOpsIssuesConfig, cred azcore.TokenCredential, pace time.Duration) *azureDevOps {
+	a := &azureDevOps{cfg: cfg, cred: cred}
+	a.client = newClient(pace, map[string]string{"Accept": "application/json"}, a.authorize)
+	return a
+}
+
+// patchOp is a JSON Patch operation on a work item.
+type patchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// workItem is the part of a work item velora reads.
+type workItem struct {
+	ID int `json:"id"`
+}
+
+// Name implements Tracker.
+func (a *azureDevOps) Name() string {
+	return "azureDevOps"
+}
+
+// Location implements Tracker, the project.
+func (a *azureDevOps) Location(target Target) string {
+	if target.Project != "" {
+		return target.Project
+	}
+	return a.cfg.Project
+}
+
+// Find implements Tracker with a WIQL query on the title, closed work items
+// excluded.
+func (a *azureDevOps) Find(ctx context.Context, target Target, marker string) (*Item, error) {
+	project := a.Location(target)
+	query := fmt.Sprintf("SELECT [System.Id] FROM WorkItems WHERE [System.TeamProject] = @project AND [System.Title] CONTAINS '%s' AND [System.State] <> '%s'",
+		wiqlEscape(marker), wiqlEscape(a.cfg.ClosedStateOrDefault()))
+
+	var result struct {
+		WorkItems []workItem `json:"workItems"`
+	}
+	if err := a.client.do(ctx, http.MethodPost, a.url(project, "wit/wiql"), "application/json", map[string]string{"query": query}, &result); err != nil {
+		return nil, err
+	}
+	if len(result.WorkItems) == 0 {
+		return nil, nil
+	}
+	id := strconv.Itoa(result.WorkItems[0].ID)
+	return &Item{ID: id, URL: a.webURL(project, id), Location: project}, nil
+}
+
+// Create implements Tracker.
+func (a *azureDevOps) Create(ctx context.Context, target Target, issue Issue) (*Item, error) {
+	project := a.Location(target)
+	ops := []patchOp{
+		{Op: "add", Path: "/fields/System.Title", Value: issue.Title},
+		{Op: "add", Path: "/fields/System.Description", Value: issue.HTML()},
+	}
+	areaPath := a.cfg.AreaPath
+	if target.AreaPath != "" {
+		areaPath = target.AreaPath
+	}
+	if areaPath != "" {
+		ops = append(ops, patchOp{Op: "add", Path: "/fields/System.AreaPath", Value: areaPath})
+	}
+	tags := append(append([]string{"velora"}, a.cfg.Tags...), target.Labels...)
+	ops = append(ops, patchOp{Op: "add", Path: "/fields/System.Tags", Value: strings.Join(tags, "; ")})
+
+	var created workItem
+	endpoint := a.url(project, "wit/workitems/$"+url.PathEscape(a.cfg.WorkItemTypeOrDefault()))
+	if err := a.client.do(ctx, http.MethodPost, endpoint, jsonPatch, ops, &created); err != nil {
+		return nil, err
+	}
+	id := strconv.Itoa(created.ID)
+	return &Item{ID: id, URL: a.webURL(project, id), Location: project}, nil
+}
+
+// Update implements Tracker.
+func (a *azureDevOps) Update(ctx context.Context, item *Item, issue Issue) error {
+	return a.patch(ctx, item, []patchOp{
+		{Op: "add", Path: "/fields/System.Title", Value: issue.Title},
+		{Op: "add", Path: "/fields/System.Description", Value: issue.HTML()},
+	})
+}
+
+// Close implements Tracker, moving the work item to the closed state with
+// the comment in its history.
+func (a *azureDevOps) Close(ctx context.Context, item *Item, comment string) error {
+	return a.patch(ctx, item, []patchOp{
+		{Op: "add", Path: "/fields/System.State", Value: a.cfg.ClosedStateOrDefault()},
+		{Op: "add", Path: "/fields/System.History", Value: comment},
+	})
+}
+
+// patch applies the operations to the work item.
+func (a *azureDevOps) patch(ctx context.Context, item *Item, ops []patchOp) error {
+	return a.client.do(ctx, http.MethodPatch, a.url(item.Location, "wit/workitems/"+url.PathEscape(item.ID)), jsonPatch, ops, nil)
+}
+
+// authorize sets the personal access token or the Entra ID token of the
+// Azure credential.
+func (a *azureDevOps) authorize(ctx context.Context, req *http.Request) error {
+	if a.cfg.Auth == config.AzureDevOpsAuthManagedIdentity {
+		token, err := a.cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{azureDevOpsScope}})
+		if err != nil {
+			return fmt.Errorf("failed to acquire Azure DevOps token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+		return nil
+	}
+
+	pat := os.Getenv(a.cfg.TokenEnv)
+	if pat == "" {
+		return fmt.Errorf("Azure DevOps token variable %s is not set", a.cfg.TokenEnv)
+	}
+	redact.Register(pat)
+	req.SetBasicAuth("", pat)
+	return nil
+}
+
+// url returns the endpoint of the Boards API in the project.
+func (a *azureDevOps) url(project, api string) string {
+	return fmt.Sprintf("https://dev.azure.com/%s/%s/_apis/%s?api-version=%s",
+		url.PathEscape(a.cfg.Organization), url.PathEscape(project), api, azureDevOpsAPIVersion)
+}
+
+// webURL returns the link to the work item.
+func (a *azureDevOps) webURL(project, id string) string {
+	return fmt.Sprintf("https://dev.azure.com/%s/%s/_workitems/edit/%s", url.PathEscape(a.cfg.Organization), url.PathEscape(project), id)
+}
+
+// wiqlEscape escapes a WIQL string literal.
+func wiqlEscape(s string) string {
+	return strings.ReplaceAll(s, "'", "''")
+}
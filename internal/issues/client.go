@@ -0,0 +1,181 @@
+package issues
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sendAttempts   = 4
+	initialBackoff = 2 * time.Second
+	// maxRetryAfter bounds how long a throttled request waits for its
+	// retry, trackers asking for longer are retried by the next run.
+	maxRetryAfter = time.Minute
+)
+
+// apiError is a request a tracker refused.
+type apiError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+// Error implements error.
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Status, e.Message)
+}
+
+// isGone reports whether the request failed because the item no longer
+// exists.
+func isGone(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusGone)
+}
+
+// client sends the requests of a tracker, paced to its rate limit and
+// retrying throttled and failed requests with backoff.
+type client struct {
+	httpClient *http.Client
+	// authorize sets the credentials of a request.
+	authorize func(ctx context.Context, req *http.Request) error
+	headers   map[string]string
+
+	pace time.Duration
+	mu   sync.Mutex
+	next time.Time
+}
+
+// newClient creates a new client sending a request every pace at most.
+func newClient(pace time.Duration, headers map[string]string, authorize func(ctx context.Context, req *http.Request) error) *client {
+	return &client{
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		authorize:  authorize,
+		headers:    headers,
+		pace:       pace,
+	}
+}
+
+// do sends the request with the body encoded as JSON and decodes the
+// response into out, if not nil.
+func (c *client) do(ctx context.Context, method, url, contentType string, body, out any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	backoff := initialBackoff
+	var lastErr error
+	for attempt := 1; attempt <= sendAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			backoff *= 2
+		}
+		if err := c.wait(ctx); err != nil {
+			return err
+		}
+
+		delay, err := c.send(ctx, method, url, contentType, data, out)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if delay < 0 || delay > maxRetryAfter {
+			break
+		}
+		backoff = max(backoff, delay)
+	}
+	return lastErr
+}
+
+// send sends a single request. It returns how long to wait before
+// retrying a failure, negative if it isn't retryable.
+func (c *client) send(ctx context.Context, method, url, contentType string, data []byte, out any) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+	if err != nil {
+		return -1, err
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	if err := c.authorize(ctx, req); err != nil {
+		return -1, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out == nil {
+			return 0, nil
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return -1, fmt.Errorf("failed to decode response: %w", err)
+		}
+		return 0, nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	apiErr := &apiError{StatusCode: resp.StatusCode, Status: resp.Status, Message: strings.TrimSpace(string(msg))}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return retryAfter(resp), apiErr
+	// GitHub answers its secondary rate limits with 403
+	case resp.StatusCode == http.StatusForbidden && (resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0"):
+		return retryAfter(resp), apiErr
+	default:
+		return -1, apiErr
+	}
+}
+
+// wait blocks until the next request may be sent.
+func (c *client) wait(ctx context.Context) error {
+	c.mu.Lock()
+	now := time.Now()
+	at := now
+	if c.next.After(now) {
+		at = c.next
+	}
+	c.next = at.Add(c.pace)
+	c.mu.Unlock()
+
+	select {
+	case <-time.After(at.Sub(now)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryAfter returns the delay the throttled response asks for, from
+// Retry-After or the reset of the GitHub rate limit, zero if none.
+func retryAfter(resp *http.Response) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		return max(time.Until(time.Unix(reset, 0)), 0)
+	}
+	return 0
+}
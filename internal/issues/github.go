@@ -0,0 +1,241 @@
+package issues
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/redact"
+)
+
+const (
+	// defaultGitHubAPI is the REST API of github.com.
+	defaultGitHubAPI = "https://api.github.com"
+	// gitHubAPIVersion is the REST API version of GitHub.
+	gitHubAPIVersion = "2022-11-28"
+)
+
+// gitHub files issues in GitHub repositories.
+type gitHub struct {
+	cfg    config.GitHubIssuesConfig
+	api    string
+	client *client
+	// appClient exchanges the app JWT for installation tokens.
+	appClient *client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// newGitHub creates a new GitHub issues tracker.
+func newGitHub(cfg config.GitHubIssuesConfig, pace time.Duration) *gitHub {
+	api := strings.TrimRight(cfg.APIURL, "/")
+	if api == "" {
+		api = defaultGitHubAPI
+	}
+	headers := map[string]string{"Accept": "application/vnd.github+json", "X-GitHub-Api-Version": gitHubAPIVersion}
+	g := &gitHub{cfg: cfg, api: api}
+	g.client = newClient(pace, headers, g.authorize)
+	g.appClient = newClient(pace, headers, g.authorizeApp)
+	return g
+}
+
+// gitHubIssue is the part of a GitHub issue velora reads.
+type gitHubIssue struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	Title   string `json:"title"`
+}
+
+// Name implements Tracker.
+func (g *gitHub) Name() string {
+	return "github"
+}
+
+// Location implements Tracker, the repository.
+func (g *gitHub) Location(target Target) string {
+	if target.Repository != "" {
+		return target.Repository
+	}
+	return g.cfg.Repository
+}
+
+// Find implements Tracker by searching the open issues of the repository
+// by title.
+func (g *gitHub) Find(ctx context.Context, target Target, marker string) (*Item, error) {
+	repository := g.Location(target)
+	q := fmt.Sprintf("repo:%s is:issue is:open in:title %q", repository, marker)
+
+	var result struct {
+		Items []gitHubIssue `json:"items"`
+	}
+	if err := g.client.do(ctx, http.MethodGet, g.api+"/search/issues?q="+url.QueryEscape(q), "", nil, &result); err != nil {
+		return nil, err
+	}
+	// search matches words, the title must carry the whole marker
+	for _, found := range result.Items {
+		if strings.Contains(found.Title, marker) {
+			return &Item{ID: strconv.Itoa(found.Number), URL: found.HTMLURL, Location: repository}, nil
+		}
+	}
+	return nil, nil
+}
+
+// Create implements Tracker.
+func (g *gitHub) Create(ctx context.Context, target Target, issue Issue) (*Item, error) {
+	repository := g.Location(target)
+	labels := append(append([]string{"velora"}, g.cfg.Labels...), target.Labels...)
+	body := map[string]any{"title": issue.Title, "body": issue.Markdown(), "labels": labels}
+
+	var created struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := g.client.do(ctx, http.MethodPost, g.issuesURL(repository), "application/json", body, &created); err != nil {
+		return nil, err
+	}
+	return &Item{ID: strconv.Itoa(created.Number), URL: created.HTMLURL, Location: repository}, nil
+}
+
+// Update implements Tracker.
+func (g *gitHub) Update(ctx context.Context, item *Item, issue Issue) error {
+	body := map[string]any{"title": issue.Title, "body": issue.Markdown()}
+	return g.client.do(ctx, http.MethodPatch, g.issuesURL(item.Location)+"/"+item.ID, "application/json", body, nil)
+}
+
+// Close implements Tracker, commenting and closing the issue as completed.
+func (g *gitHub) Close(ctx context.Context, item *Item, comment string) error {
+	endpoint := g.issuesURL(item.Location) + "/" + item.ID
+	if err := g.client.do(ctx, http.MethodPost, endpoint+"/comments", "application/json", map[string]any{"body": comment}, nil); err != nil {
+		return err
+	}
+	return g.client.do(ctx, http.MethodPatch, endpoint, "application/json", map[string]any{"state": "closed", "state_reason": "completed"}, nil)
+}
+
+// issuesURL returns the issues endpoint of the repository, owner/name.
+func (g *gitHub) issuesURL(repository string) string {
+	owner, name, _ := strings.Cut(repository, "/")
+	return fmt.Sprintf("%s/repos/%s/%s/issues", g.api, url.PathEscape(owner), url.PathEscape(name))
+}
+
+// authorize sets the personal access token, or the token of the app
+// installation.
+func (g *gitHub) authorize(ctx context.Context, req *http.Request) error {
+	if g.cfg.App == nil {
+		token := os.Getenv(g.cfg.TokenEnv)
+		if token == "" {
+			return fmt.Errorf("GitHub token variable %s is not set", g.cfg.TokenEnv)
+		}
+		redact.Register(token)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+
+	token, err := g.installationToken(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// installationToken returns the token of the app installation, exchanging
+// a new one shortly before the current one expires.
+func (g *gitHub) installationToken(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && time.Until(g.expiresAt) > time.Minute {
+		return g.token, nil
+	}
+
+	var result struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	endpoint := fmt.Sprintf("%s/app/installations/%d/access_tokens", g.api, g.cfg.App.InstallationID)
+	if err := g.appClient.do(ctx, http.MethodPost, endpoint, "application/json", map[string]any{}, &result); err != nil {
+		return "", fmt.Errorf("failed to acquire GitHub App installation token: %w", err)
+	}
+	redact.Register(result.Token)
+	g.token, g.expiresAt = result.Token, result.ExpiresAt
+	return g.token, nil
+}
+
+// authorizeApp sets a JWT of the app, signed with its private key.
+func (g *gitHub) authorizeApp(_ context.Context, req *http.Request) error {
+	pemKey := os.Getenv(g.cfg.App.PrivateKeyEnv)
+	if pemKey == "" {
+		return fmt.Errorf("GitHub App private key variable %s is not set", g.cfg.App.PrivateKeyEnv)
+	}
+	key, err := parsePrivateKey([]byte(pemKey))
+	if err != nil {
+		return fmt.Errorf("invalid GitHub App private key in %s: %w", g.cfg.App.PrivateKeyEnv, err)
+	}
+	jwt, err := appJWT(g.cfg.App.AppID, key, time.Now())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	return nil
+}
+
+// appJWT returns a JWT authenticating as the app for ten minutes, issued a
+// minute in the past against clock drift.
+func appJWT(appID int64, key *rsa.PrivateKey, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(appID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign GitHub App JWT: %w", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parsePrivateKey parses a PEM encoded PKCS #1 or PKCS #8 RSA private key.
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA key")
+	}
+	return key, nil
+}
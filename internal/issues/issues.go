@@ -0,0 +1,421 @@
+// Package issues files findings in issue trackers: a work item or issue per
+// open finding, updated while the finding is reported and closed once it
+// resolves. The finding key is carried in the title of every item, so an
+// item filed by a run whose state was lost is found again rather than
+// filed twice.
+package issues
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/state"
+)
+
+const (
+	// stateKey is the state store key of the items filed.
+	stateKey = "issue-export"
+	// defaultRequestsPerMinute paces the requests sent to a tracker.
+	defaultRequestsPerMinute = 60
+	// maxConsecutiveFailures is how many items in a row may fail before the
+	// tracker is considered down and skipped for the rest of the run.
+	maxConsecutiveFailures = 3
+)
+
+// Item is the work item or issue filed for an open finding.
+type Item struct {
+	Tracker string `json:"tracker"`
+	ID      string `json:"id"`
+	URL     string `json:"url,omitempty"`
+	// Location is the project or repository the item is filed in.
+	Location       string            `json:"location"`
+	RuleID         string            `json:"ruleId"`
+	Severity       findings.Severity `json:"severity"`
+	SubscriptionID string            `json:"subscriptionId"`
+	ResourceID     string            `json:"resourceId"`
+	// Digest is the hash of the content last written, the item is updated
+	// when it changes.
+	Digest  string    `json:"digest"`
+	FiledAt time.Time `json:"filedAt"`
+}
+
+// exportState is the persisted items, keyed by tracker, rule and resource.
+type exportState struct {
+	Items map[string]*Item `json:"items"`
+}
+
+// Target is where the item of a finding is filed, empty fields use the
+// defaults of the tracker.
+type Target struct {
+	Project    string
+	AreaPath   string
+	Repository string
+	// Labels are added to those of the tracker.
+	Labels []string
+}
+
+// Issue is the content of the item of a finding.
+type Issue struct {
+	// Marker is the finding key carried in the title.
+	Marker  string
+	Title   string
+	Message string
+	// Details are the labelled properties of the finding, in order.
+	Details [][2]string
+	Footer  string
+}
+
+// Markdown renders the description of the item as Markdown.
+func (i Issue) Markdown() string {
+	var b strings.Builder
+	b.WriteString(i.Message + "\n\n")
+	for _, d := range i.Details {
+		fmt.Fprintf(&b, "- **%s:** %s\n", d[0], d[1])
+	}
+	b.WriteString("\n_" + i.Footer + "_\n")
+	return b.String()
+}
+
+// HTML renders the description of the item as HTML.
+func (i Issue) HTML() string {
+	var b strings.Builder
+	b.WriteString("<p>" + html.EscapeString(i.Message) + "</p><ul>")
+	for _, d := range i.Details {
+		fmt.Fprintf(&b, "<li><b>%s:</b> %s</li>", html.EscapeString(d[0]), html.EscapeString(d[1]))
+	}
+	b.WriteString("</ul><p><i>" + html.EscapeString(i.Footer) + "</i></p>")
+	return b.String()
+}
+
+// digest returns the hash of the content written to the item.
+func (i Issue) digest() string {
+	sum := sha256.Sum256([]byte(i.Title + "\n" + i.Markdown()))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// Tracker files items in an issue tracker.
+type Tracker interface {
+	// Name identifies the tracker in the state and the output.
+	Name() string
+	// Location returns the project or repository the target files items in.
+	Location(target Target) string
+	// Find returns the open item whose title carries the marker, nil if
+	// there is none.
+	Find(ctx context.Context, target Target, marker string) (*Item, error)
+	Create(ctx context.Context, target Target, issue Issue) (*Item, error)
+	// Update rewrites the title and description of the item, its labels
+	// are left to the people working it.
+	Update(ctx context.Context, item *Item, issue Issue) error
+	// Close closes the item with a comment.
+	Close(ctx context.Context, item *Item, comment string) error
+}
+
+// Outcome is what a run filed in a tracker.
+type Outcome struct {
+	Tracker   string `json:"tracker"`
+	Created   int    `json:"created"`
+	Updated   int    `json:"updated"`
+	Unchanged int    `json:"unchanged"`
+	Closed    int    `json:"closed"`
+	Failed    int    `json:"failed"`
+	// Error is the first failure, empty if every item was filed.
+	Error string `json:"error,omitempty"`
+}
+
+// Exporter files the findings of runs in the configured trackers.
+type Exporter struct {
+	cfg         config.IssueExportConfig
+	store       state.Store
+	trackers    []Tracker
+	minSeverity findings.Severity
+	rules       map[string]bool
+}
+
+// NewExporter creates a new exporter for the configured trackers. Azure
+// DevOps with managedIdentity authenticates with the credential.
+func NewExporter(cfg config.IssueExportConfig, store state.Store, cred azcore.TokenCredential) *Exporter {
+	rpm := cfg.RequestsPerMinute
+	if rpm == 0 {
+		rpm = defaultRequestsPerMinute
+	}
+	pace := time.Minute / time.Duration(rpm)
+
+	var trackers []Tracker
+	if cfg.AzureDevOps != nil {
+		trackers = append(trackers, newAzureDevOps(*cfg.AzureDevOps, cred, pace))
+	}
+	if cfg.GitHub != nil {
+		trackers = append(trackers, newGitHub(*cfg.GitHub, pace))
+	}
+
+	minSeverity := findings.Severity(cfg.MinSeverity)
+	if minSeverity == "" {
+		minSeverity = findings.SeverityHigh
+	}
+	var rules map[string]bool
+	if len(cfg.Rules) > 0 {
+		rules = make(map[string]bool, len(cfg.Rules))
+		for _, rule := range cfg.Rules {
+			rules[rule] = true
+		}
+	}
+	return &Exporter{cfg: cfg, store: store, trackers: trackers, minSeverity: minSeverity, rules: rules}
+}
+
+// Export files the findings of a run in every tracker and closes the items
+// of the findings no longer reported, those of subscriptions the run
+// evaluated. A tracker failing doesn't affect the others, the items it
+// filed are saved and the rest are retried by the next run. Errors are
+// returned for logging only, they must never fail the run.
+func (e *Exporter) Export(ctx context.Context, runFindings []findings.Finding, evaluated func(subscriptionID string) bool) ([]Outcome, error) {
+	st, err := e.load()
+	if err != nil {
+		return nil, err
+	}
+	relevant := e.filter(runFindings)
+
+	outcomes := make([]Outcome, 0, len(e.trackers))
+	for _, tracker := range e.trackers {
+		outcome := e.export(ctx, tracker, relevant, evaluated, st)
+		outcomes = append(outcomes, outcome)
+		if e.cfg.DryRun || outcome.Created+outcome.Updated+outcome.Closed == 0 {
+			continue
+		}
+		if err := e.save(st); err != nil {
+			return outcomes, err
+		}
+	}
+	return outcomes, nil
+}
+
+// export files the findings in the tracker, and closes the items of its
+// resolved findings.
+func (e *Exporter) export(ctx context.Context, tracker Tracker, relevant []findings.Finding, evaluated func(subscriptionID string) bool, st *exportState) Outcome {
+	outcome := Outcome{Tracker: tracker.Name()}
+	failures := 0
+	// fail records a failed item, and reports whether the tracker is down
+	fail := func(err error) bool {
+		outcome.Failed++
+		if outcome.Error == "" {
+			outcome.Error = err.Error()
+		}
+		failures++
+		return failures >= maxConsecutiveFailures || ctx.Err() != nil
+	}
+
+	reported := make(map[string]bool, len(relevant))
+	for _, f := range relevant {
+		k := itemKey(tracker.Name(), f.RuleID, f.ResourceID)
+		if reported[k] {
+			continue
+		}
+		reported[k] = true
+
+		target := e.target(f)
+		issue := newIssue(f)
+		item := st.Items[k]
+
+		if e.cfg.DryRun {
+			switch {
+			case item == nil:
+				fmt.Printf("dry run: would file %s item in %s: %s\n", tracker.Name(), tracker.Location(target), issue.Title)
+			case item.Digest != issue.digest():
+				fmt.Printf("dry run: would update %s item %s: %s\n", tracker.Name(), item.ID, issue.Title)
+			}
+			continue
+		}
+
+		created := false
+		if item == nil {
+			found, err := tracker.Find(ctx, target, issue.Marker)
+			if err != nil {
+				if fail(fmt.Errorf("failed to look up item of %s on %s: %w", f.RuleID, f.ResourceID, err)) {
+					return down(outcome)
+				}
+				continue
+			}
+			if found == nil {
+				if found, err = tracker.Create(ctx, target, issue); err != nil {
+					if fail(fmt.Errorf("failed to file item of %s on %s: %w", f.RuleID, f.ResourceID, err)) {
+						return down(outcome)
+					}
+					continue
+				}
+				created = true
+				found.Digest = issue.digest()
+			}
+			found.Tracker = tracker.Name()
+			found.Location = tracker.Location(target)
+			found.FiledAt = time.Now().UTC()
+			item = found
+		}
+
+		switch {
+		case created:
+			outcome.Created++
+		case item.Digest == issue.digest():
+			outcome.Unchanged++
+		default:
+			if err := tracker.Update(ctx, item, issue); err != nil && !isGone(err) {
+				if fail(fmt.Errorf("failed to update item %s: %w", item.ID, err)) {
+					return down(outcome)
+				}
+				continue
+			}
+			item.Digest = issue.digest()
+			outcome.Updated++
+		}
+		failures = 0
+		item.RuleID = f.RuleID
+		item.Severity = f.Severity
+		item.SubscriptionID = f.SubscriptionID
+		item.ResourceID = f.ResourceID
+		st.Items[k] = item
+	}
+
+	for _, k := range sortedKeys(st.Items) {
+		item := st.Items[k]
+		if item.Tracker != tracker.Name() || reported[k] || !evaluated(item.SubscriptionID) {
+			continue
+		}
+		if e.cfg.DryRun {
+			fmt.Printf("dry run: would close %s item %s of %s on %s, resolved\n", tracker.Name(), item.ID, item.RuleID, item.ResourceID)
+			continue
+		}
+		comment := fmt.Sprintf("Resolved: velora no longer reports %s on %s as of %s.", item.RuleID, item.ResourceID, time.Now().UTC().Format(time.RFC3339))
+		// an item deleted by hand has nothing left to close
+		if err := tracker.Close(ctx, item, comment); err != nil && !isGone(err) {
+			if fail(fmt.Errorf("failed to close item %s: %w", item.ID, err)) {
+				return down(outcome)
+			}
+			continue
+		}
+		failures = 0
+		delete(st.Items, k)
+		outcome.Closed++
+	}
+	return outcome
+}
+
+// down marks the outcome of a tracker given up on for the run.
+func down(outcome Outcome) Outcome {
+	outcome.Error = fmt.Sprintf("gave up after %d failures in a row: %s", maxConsecutiveFailures, outcome.Error)
+	return outcome
+}
+
+// filter returns the findings at or above the severity threshold, of the
+// configured rules.
+func (e *Exporter) filter(all []findings.Finding) []findings.Finding {
+	var result []findings.Finding
+	for _, f := range all {
+		if f.Severity.AtLeast(e.minSeverity) && (e.rules == nil || e.rules[f.RuleID]) {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// target returns where the finding is filed, by the first route matching
+// the ownership of its subscription.
+func (e *Exporter) target(f findings.Finding) Target {
+	for _, route := range e.cfg.Routes {
+		if route.Matches(f.Ownership) {
+			return Target{
+				Project:    route.Project,
+				AreaPath:   route.AreaPath,
+				Repository: route.Repository,
+				Labels:     route.Labels,
+			}
+		}
+	}
+	return Target{}
+}
+
+// newIssue renders the item of the finding.
+func newIssue(f findings.Finding) Issue {
+	marker := Marker(f.RuleID, f.ResourceID)
+	issue := Issue{
+		Marker:  marker,
+		Title:   fmt.Sprintf("velora: %s on %s [%s]", f.RuleID, path.Base(f.ResourceID), marker),
+		Message: f.Message,
+		Footer:  fmt.Sprintf("Filed by velora, finding key %s. It is updated while the finding is reported and closed once it resolves.", marker),
+	}
+	severity := string(f.Severity)
+	if f.SeverityReason != "" {
+		severity += " (" + f.SeverityReason + ")"
+	}
+	for _, d := range [][2]string{
+		{"Rule", f.RuleID},
+		{"Severity", severity},
+		{"Subscription", f.SubscriptionID},
+		{"Resource", f.ResourceID},
+		{"Remediation", f.Remediation},
+		{"Docs", f.DocsURL},
+	} {
+		if d[1] != "" {
+			issue.Details = append(issue.Details, d)
+		}
+	}
+	if f.Caller != nil {
+		issue.Details = append(issue.Details, [2]string{"Changed by", f.Caller.String()})
+	}
+	if f.Ownership != nil {
+		issue.Details = append(issue.Details, [2]string{"Ownership", f.Ownership.String()})
+	}
+	return issue
+}
+
+// Marker returns the finding key carried in the title of the item of a
+// finding, stable across runs.
+func Marker(ruleID, resourceID string) string {
+	sum := sha256.Sum256([]byte(ruleID + "|" + strings.ToLower(resourceID)))
+	return "velora-" + hex.EncodeToString(sum[:])[:12]
+}
+
+// load reads the items filed from the state store.
+func (e *Exporter) load() (*exportState, error) {
+	st := &exportState{}
+	if err := e.store.Get(stateKey, st); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load filed items: %w", err)
+	}
+	if st.Items == nil {
+		st.Items = make(map[string]*Item)
+	}
+	return st, nil
+}
+
+// save writes the items filed to the state store.
+func (e *Exporter) save(st *exportState) error {
+	if err := e.store.Put(stateKey, st); err != nil {
+		return fmt.Errorf("failed to save filed items: %w", err)
+	}
+	return nil
+}
+
+// itemKey identifies the item of a finding in a tracker.
+func itemKey(tracker, ruleID, resourceID string) string {
+	return tracker + "|" + ruleID + "|" + strings.ToLower(resourceID)
+}
+
+// sortedKeys returns the keys of the items in order, so items are closed
+// in the same order on every run.
+func sortedKeys(items map[string]*Item) []string {
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
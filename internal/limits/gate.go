@@ -0,0 +1,82 @@
+package limits
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// Gate refuses creates that would take a scope, like a VNet's peerings or a
+// route table's routes, past the limit of its subscription. The creates it
+// allows are counted, so several creates in the same scope during a run
+// are seen. Hubs refusing new spokes at capacity are enforced the same way.
+// It is safe for concurrent use, a nil gate allows everything.
+type Gate struct {
+	cfg *config.Config
+
+	mu    sync.Mutex
+	added map[string]int
+	// spokes are the address prefixes of the spokes reserved per hub, by
+	// lowercase VNet ID.
+	spokes map[string]map[string]int
+}
+
+// NewGate creates a gate enforcing the limits of the configuration.
+func NewGate(cfg *config.Config) *Gate {
+	return &Gate{cfg: cfg, added: make(map[string]int), spokes: make(map[string]map[string]int)}
+}
+
+// Blocked is a create refused by the gate.
+type Blocked struct {
+	// SubscriptionID is the subscription whose limit was reached.
+	SubscriptionID string
+	Limit          string
+	ScopeID        string
+	Used           int
+	Max            int
+
+	rules map[string]config.RuleConfig
+}
+
+// Reserve counts one more resource in the scope, which held used resources
+// when it was read, and returns nil if it fits within the limit of the
+// subscription. Otherwise nothing is counted and the blocked create is
+// returned. Subscriptions velora doesn't manage, like a hub's, have the
+// default limits.
+func (g *Gate) Reserve(subscriptionID, limit, scopeID string, used int) *Blocked {
+	if g == nil {
+		return nil
+	}
+	subCFG := g.cfg.Subscriptions[subscriptionID]
+	max := subCFG.EffectiveLimit(limit)
+	key := limit + "|" + strings.ToLower(scopeID)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	used += g.added[key]
+	if max > 0 && used+1 > max {
+		return &Blocked{SubscriptionID: subscriptionID, Limit: limit, ScopeID: scopeID, Used: used, Max: max, rules: g.cfg.Rules}
+	}
+	g.added[key]++
+	return nil
+}
+
+// Finding returns the finding of the blocked action on the resource,
+// reported in the given subscription.
+func (b *Blocked) Finding(subscriptionID, resourceID, action string) findings.Finding {
+	fmt.Printf("WARNING: blocked by quota: %s, %s is at %d of %d %s\n", action, b.ScopeID, b.Used, b.Max, b.Limit)
+	return findings.New(findings.RuleBlockedByQuota, b.rules, subscriptionID, resourceID,
+		fmt.Sprintf("%s was blocked, %s is at %d of its %d %s", action, b.ScopeID, b.Used, b.Max, b.Limit),
+		map[string]string{
+			"action":       action,
+			"scope":        b.ScopeID,
+			"used":         strconv.Itoa(b.Used),
+			"max":          strconv.Itoa(b.Max),
+			"limit":        b.Limit,
+			"subscription": b.SubscriptionID,
+		})
+}
@@ -0,0 +1,182 @@
+package limits
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/inventory"
+)
+
+// HubUsage is the consumption of the design capacity of a hub. Spokes are
+// the hub's peerings with VNets other than hubs, in any state.
+type HubUsage struct {
+	Hub    string `json:"hub"`
+	VNetID string `json:"vnetId"`
+	Spokes int    `json:"spokes"`
+	// MaxSpokes is 0 if the number of spokes isn't limited.
+	MaxSpokes     int `json:"maxSpokes,omitempty"`
+	SpokePrefixes int `json:"spokePrefixes"`
+	// MaxTotalSpokePrefixes is 0 if the number of prefixes isn't limited.
+	MaxTotalSpokePrefixes int `json:"maxTotalSpokePrefixes,omitempty"`
+}
+
+// MeasureHub counts the spokes of the hub and their address prefixes, as
+// the peerings of its inventory see them.
+func MeasureHub(cfg *config.Config, hubCFG *config.HubVNetConfig, hubInv *inventory.HubInventory) HubUsage {
+	u := HubUsage{Hub: hubCFG.Name, VNetID: hubCFG.VNetID}
+	if hubCFG.Capacity != nil {
+		u.MaxSpokes = hubCFG.Capacity.MaxSpokes
+		u.MaxTotalSpokePrefixes = hubCFG.Capacity.MaxTotalSpokePrefixes
+	}
+	for _, peering := range hubInv.Peerings {
+		remote := remoteVNetID(peering)
+		if remote == "" || cfg.IsHubVNet(remote) {
+			continue
+		}
+		u.Spokes++
+		if space := peering.Properties.RemoteAddressSpace; space != nil {
+			u.SpokePrefixes += len(space.AddressPrefixes)
+		}
+	}
+	return u
+}
+
+// Utilization returns the used fraction of the most utilized limit of the
+// hub, 0 without limits.
+func (u HubUsage) Utilization() float64 {
+	utilization := 0.0
+	if u.MaxSpokes > 0 {
+		utilization = float64(u.Spokes) / float64(u.MaxSpokes)
+	}
+	if u.MaxTotalSpokePrefixes > 0 {
+		utilization = max(utilization, float64(u.SpokePrefixes)/float64(u.MaxTotalSpokePrefixes))
+	}
+	return utilization
+}
+
+// Exceeded reports whether the hub is past one of its limits.
+func (u HubUsage) Exceeded() bool {
+	return u.MaxSpokes > 0 && u.Spokes > u.MaxSpokes ||
+		u.MaxTotalSpokePrefixes > 0 && u.SpokePrefixes > u.MaxTotalSpokePrefixes
+}
+
+// full reports whether one more spoke with the prefixes would take the hub
+// past one of its limits.
+func (u HubUsage) full(prefixes int) bool {
+	return u.MaxSpokes > 0 && u.Spokes+1 > u.MaxSpokes ||
+		u.MaxTotalSpokePrefixes > 0 && u.SpokePrefixes+prefixes > u.MaxTotalSpokePrefixes
+}
+
+// String describes the usage, e.g. "48 of 50 spokes, 190 of 400 spoke prefixes".
+func (u HubUsage) String() string {
+	spokes := strconv.Itoa(u.Spokes)
+	if u.MaxSpokes > 0 {
+		spokes += " of " + strconv.Itoa(u.MaxSpokes)
+	}
+	prefixes := strconv.Itoa(u.SpokePrefixes)
+	if u.MaxTotalSpokePrefixes > 0 {
+		prefixes += " of " + strconv.Itoa(u.MaxTotalSpokePrefixes)
+	}
+	return spokes + " spokes, " + prefixes + " spoke prefixes"
+}
+
+// CheckHub returns the finding of a hub past its capacity, or at warn of
+// it, reported on the hub VNet. It returns nil below warn.
+func CheckHub(u HubUsage, warn float64, rules map[string]config.RuleConfig) *findings.Finding {
+	rule := findings.RuleHubCapacityWarning
+	message := fmt.Sprintf("hub %s is at %.0f%% of its design capacity: %s", u.Hub, 100*u.Utilization(), u)
+	switch {
+	case u.Exceeded():
+		rule = findings.RuleHubCapacityExceeded
+		message = fmt.Sprintf("hub %s is past its design capacity: %s", u.Hub, u)
+	case u.Utilization() < warn:
+		return nil
+	}
+	fmt.Println("WARNING:", message)
+	f := findings.New(rule, rules, azure.SubscriptionIDOf(u.VNetID), u.VNetID, message,
+		map[string]string{
+			"hub":         u.Hub,
+			"usage":       u.String(),
+			"utilization": fmt.Sprintf("%.0f%%", 100*u.Utilization()),
+		})
+	return &f
+}
+
+// HubFull is a spoke refused by the gate, its hub is at capacity.
+type HubFull struct {
+	Usage HubUsage
+
+	rules map[string]config.RuleConfig
+}
+
+// ReserveSpoke counts the VNet, with its address prefixes, as one more spoke
+// of the hub and returns nil if it fits within the hub's capacity. The
+// capacity is only enforced for hubs refusing new spokes at capacity, whose
+// inventory was read. A VNet the hub is already peered with, or reserved
+// earlier in the run, fits. Otherwise nothing is counted and the refused
+// spoke is returned.
+func (g *Gate) ReserveSpoke(hubCFG *config.HubVNetConfig, hubInv *inventory.HubInventory, vnetID string, prefixes int) *HubFull {
+	if g == nil || hubCFG.Capacity == nil || !hubCFG.Capacity.RefuseAtCapacity || hubInv == nil {
+		return nil
+	}
+	peered := make(map[string]bool, len(hubInv.Peerings))
+	for _, peering := range hubInv.Peerings {
+		peered[strings.ToLower(remoteVNetID(peering))] = true
+	}
+	vnetKey := strings.ToLower(vnetID)
+	if peered[vnetKey] {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	reserved := g.spokes[hubCFG.Name]
+	if _, ok := reserved[vnetKey]; ok {
+		return nil
+	}
+	// spokes reserved earlier may be peered since, they're counted once
+	u := MeasureHub(g.cfg, hubCFG, hubInv)
+	for id, n := range reserved {
+		if !peered[id] {
+			u.Spokes++
+			u.SpokePrefixes += n
+		}
+	}
+	if u.full(prefixes) {
+		return &HubFull{Usage: u, rules: g.cfg.Rules}
+	}
+	if reserved == nil {
+		reserved = make(map[string]int)
+		g.spokes[hubCFG.Name] = reserved
+	}
+	reserved[vnetKey] = prefixes
+	return nil
+}
+
+// Finding returns the finding of the refused action on the VNet, reported
+// in the given subscription.
+func (h *HubFull) Finding(subscriptionID, vnetID, vnetName, action string) findings.Finding {
+	fmt.Printf("WARNING: hub at capacity: %s, hub %s is at %s\n", action, h.Usage.Hub, h.Usage)
+	return findings.New(findings.RuleHubAtCapacity, h.rules, subscriptionID, vnetID,
+		fmt.Sprintf("%s was refused, hub %s is at capacity: %s", action, h.Usage.Hub, h.Usage),
+		map[string]string{
+			"action": action,
+			"hub":    h.Usage.Hub,
+			"usage":  h.Usage.String(),
+			"vnet":   vnetName,
+		})
+}
+
+// remoteVNetID returns the ID of the remote VNet of the peering, empty if unknown.
+func remoteVNetID(peering *armnetwork.VirtualNetworkPeering) string {
+	if peering == nil || peering.Properties == nil || peering.Properties.RemoteVirtualNetwork == nil ||
+		peering.Properties.RemoteVirtualNetwork.ID == nil {
+		return ""
+	}
+	return *peering.Properties.RemoteVirtualNetwork.ID
+}
@@ -0,0 +1,231 @@
+package limits
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/inventory"
+)
+
+// Sources of a usage.
+const (
+	// SourceUsagesAPI is a usage and limit reported by the network usages
+	// API, which includes quota increases.
+	SourceUsagesAPI = "usages"
+	// SourceCounted is a usage counted from the listed resources, against
+	// the configured limit.
+	SourceCounted = "counted"
+)
+
+// regionUsageNames maps the names of the network usages API to the
+// per-region limits.
+var regionUsageNames = map[string]string{
+	"VirtualNetworks":       config.LimitVNetsPerRegion,
+	"RouteTables":           config.LimitRouteTablesPerRegion,
+	"NetworkSecurityGroups": config.LimitNSGsPerRegion,
+}
+
+// Usage is the consumption of a limit in the most utilized scope of a
+// subscription: a region, or a resource like a VNet for its peerings.
+type Usage struct {
+	SubscriptionID string `json:"subscriptionId"`
+	Limit          string `json:"limit"`
+	Scope          string `json:"scope"`
+	Used           int    `json:"used"`
+	Max            int    `json:"max"`
+	Source         string `json:"source"`
+}
+
+// Utilization returns the used fraction of the limit.
+func (u Usage) Utilization() float64 {
+	if u.Max <= 0 {
+		return 0
+	}
+	return float64(u.Used) / float64(u.Max)
+}
+
+// Report is the consumption of the limits of the managed subscriptions.
+type Report struct {
+	Usages []Usage `json:"usages"`
+	// Warnings are the limits above the warning threshold, and the regions
+	// whose usages had to be counted.
+	Warnings []string `json:"warnings,omitempty"`
+	// Failed are the subscriptions that couldn't be read, with the error.
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// Collect reads the consumption of the limits of every managed
+// subscription. Per-region limits come from the network usages API where
+// it answers, the others are counted from the listed resources.
+func Collect(ctx context.Context, cfg *config.Config, clientFactory *azure.ClientFactory, runInventory *inventory.RunInventory) *Report {
+	report := &Report{Failed: make(map[string]string)}
+	threshold := cfg.Limits.EffectiveWarnUtilization()
+	for _, subID := range cfg.SubscriptionIDs() {
+		usages, err := report.collectSubscription(ctx, cfg, clientFactory.ForSubscription(subID), runInventory, subID)
+		if err != nil {
+			report.Failed[subID] = err.Error()
+			continue
+		}
+		for _, u := range usages {
+			if u.Utilization() >= threshold {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("%s of subscription %s is at %d of %d (%.0f%%) in %s",
+					u.Limit, subID, u.Used, u.Max, 100*u.Utilization(), u.Scope))
+			}
+		}
+		report.Usages = append(report.Usages, usages...)
+	}
+	return report
+}
+
+// collectSubscription returns the usage of every limit of the subscription
+// in its most utilized scope, ordered by limit.
+func (r *Report) collectSubscription(ctx context.Context, cfg *config.Config, subFactory *azure.ClientFactory,
+	runInventory *inventory.RunInventory, subscriptionID string) ([]Usage, error) {
+	subCFG := cfg.Subscriptions[subscriptionID]
+	top := make(map[string]Usage)
+	observe := func(limit, scope string, used, max int, source string) {
+		if max <= 0 {
+			max = subCFG.EffectiveLimit(limit)
+		}
+		u := Usage{SubscriptionID: subscriptionID, Limit: limit, Scope: scope, Used: used, Max: max, Source: source}
+		if current, ok := top[limit]; !ok || u.Utilization() > current.Utilization() {
+			top[limit] = u
+		}
+	}
+	// counted are the resources per region, for regions the usages API doesn't answer
+	counted := make(map[string]map[string]int)
+	count := func(limit, location string) {
+		if counted[limit] == nil {
+			counted[limit] = make(map[string]int)
+		}
+		counted[limit][normalizeRegion(location)]++
+	}
+
+	vnets, err := runInventory.VNets(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	for _, vnet := range vnets {
+		if vnet.ID == nil || vnet.Location == nil {
+			continue
+		}
+		count(config.LimitVNetsPerRegion, *vnet.Location)
+		if vnet.Properties != nil {
+			observe(config.LimitPeeringsPerVNet, *vnet.ID, len(vnet.Properties.VirtualNetworkPeerings), 0, SourceCounted)
+		}
+	}
+
+	routeTables, err := runInventory.RouteTables(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	for _, rt := range routeTables {
+		if rt.Location == nil {
+			continue
+		}
+		count(config.LimitRouteTablesPerRegion, *rt.Location)
+		if rt.Properties != nil {
+			observe(config.LimitRoutesPerRouteTable, *rt.ID, len(rt.Properties.Routes), 0, SourceCounted)
+		}
+	}
+
+	securityGroupsClient, err := subFactory.NewSecurityGroupsClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pager := securityGroupsClient.NewListAllPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list network security groups: %w", err)
+		}
+		for _, nsg := range page.Value {
+			if nsg == nil || nsg.ID == nil || nsg.Location == nil {
+				continue
+			}
+			count(config.LimitNSGsPerRegion, *nsg.Location)
+			if nsg.Properties != nil {
+				// the default rules don't count against the limit
+				observe(config.LimitSecurityRulesPerNSG, *nsg.ID, len(nsg.Properties.SecurityRules), 0, SourceCounted)
+			}
+		}
+	}
+
+	regions := make(map[string]bool)
+	for _, byRegion := range counted {
+		for region := range byRegion {
+			regions[region] = true
+		}
+	}
+	for _, region := range sortedKeys(regions) {
+		reported, err := regionUsages(ctx, subFactory, region)
+		if err != nil {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("network usages of region %s of subscription %s unavailable, counted instead: %v",
+				region, subscriptionID, err))
+		}
+		for name, limit := range regionUsageNames {
+			u, ok := reported[name]
+			if !ok {
+				if n := counted[limit][region]; n > 0 {
+					observe(limit, region, n, 0, SourceCounted)
+				}
+				continue
+			}
+			// an override applies until Azure reports the increased quota
+			if override, ok := subCFG.Limits[limit]; ok && override > u.Max {
+				u.Max = override
+			}
+			observe(limit, region, u.Used, u.Max, SourceUsagesAPI)
+		}
+	}
+
+	usages := make([]Usage, 0, len(top))
+	for _, limit := range sortedKeys(top) {
+		usages = append(usages, top[limit])
+	}
+	return usages, nil
+}
+
+// regionUsages returns the usages of the region reported by the network
+// usages API, by name.
+func regionUsages(ctx context.Context, subFactory *azure.ClientFactory, region string) (map[string]Usage, error) {
+	usagesClient, err := subFactory.NewUsagesClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usages := make(map[string]Usage)
+	pager := usagesClient.NewListPager(region, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list network usages: %w", err)
+		}
+		for _, u := range page.Value {
+			if u == nil || u.Name == nil || u.Name.Value == nil || u.CurrentValue == nil || u.Limit == nil {
+				continue
+			}
+			usages[*u.Name.Value] = Usage{Used: int(*u.CurrentValue), Max: int(*u.Limit)}
+		}
+	}
+	return usages, nil
+}
+
+// normalizeRegion normalizes region names, e.g. "West Europe" to "westeurope".
+func normalizeRegion(region string) string {
+	return strings.ToLower(strings.ReplaceAll(region, " ", ""))
+}
+
+// sortedKeys returns the keys of the map in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
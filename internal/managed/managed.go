@@ -0,0 +1,189 @@
+package managed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// stateKey is the state store key holding the managed resource history.
+const stateKey = "managed-resources"
+
+// KindRoute is the kind of the routes velora manages.
+const KindRoute = "route"
+
+// Resource is a resource velora manages.
+type Resource struct {
+	ID             string `json:"id"`
+	Kind           string `json:"kind"`
+	SubscriptionID string `json:"subscriptionId"`
+	Hub            string `json:"hub"`
+	// FirstSeen is when velora first touched or discovered the resource.
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastTouched time.Time `json:"lastTouched,omitempty"`
+	// LastTouchedRun is the run counter value of the last touch, 0 if never.
+	LastTouchedRun int `json:"lastTouchedRun"`
+	// RunsSinceTouched is computed when listing, it isn't persisted.
+	RunsSinceTouched int `json:"runsSinceTouched"`
+}
+
+// history is the persisted managed resource history.
+type history struct {
+	Runs      int                  `json:"runs"`
+	Resources map[string]*Resource `json:"resources"`
+}
+
+// Tracker records the managed resources the controllers touch during a run.
+type Tracker struct {
+	store state.Store
+
+	mu      sync.Mutex
+	touched map[string]Resource
+}
+
+// NewTracker creates a new tracker persisting to the state store.
+func NewTracker(store state.Store) *Tracker {
+	return &Tracker{
+		store:   store,
+		touched: make(map[string]Resource),
+	}
+}
+
+// Touch records that a policy evaluated or wrote the managed resource.
+func (t *Tracker) Touch(kind, subscriptionID, hub, resourceID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.touched[strings.ToLower(resourceID)] = Resource{
+		ID:             resourceID,
+		Kind:           kind,
+		SubscriptionID: subscriptionID,
+		Hub:            hub,
+	}
+}
+
+// Commit persists the touches of the run and counts the run.
+func (t *Tracker) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h, err := load(t.store)
+	if err != nil {
+		return err
+	}
+
+	h.Runs++
+	now := time.Now().UTC()
+	for key, touched := range t.touched {
+		r, ok := h.Resources[key]
+		if !ok {
+			r = &touched
+			r.FirstSeen = now
+			h.Resources[key] = r
+		}
+		r.Hub = touched.Hub
+		r.LastTouched = now
+		r.LastTouchedRun = h.Runs
+	}
+	t.touched = make(map[string]Resource)
+
+	return t.store.Put(stateKey, h)
+}
+
+// List discovers the managed resources in Azure and joins them with the
+// persisted history. Resources never touched are counted as untouched since
+// the first run, so resources created before the state store are included.
+func List(ctx context.Context, cfg *config.Config, clientFactory *azure.ClientFactory, store state.Store) ([]Resource, error) {
+	h, err := load(store)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []Resource
+	for _, subID := range cfg.SubscriptionIDs() {
+		hub := cfg.Hub(cfg.Subscriptions[subID].HubName)
+		if hub == nil {
+			continue
+		}
+
+		routes, err := discoverRoutes(ctx, clientFactory.ForSubscription(subID), hub, cfg.EffectiveServiceRouteMatchers())
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover managed routes in subscription %s: %w", subID, err)
+		}
+
+		for _, id := range routes {
+			r := Resource{ID: id, Kind: KindRoute, SubscriptionID: subID, Hub: hub.Name}
+			if known, ok := h.Resources[strings.ToLower(id)]; ok {
+				r.FirstSeen = known.FirstSeen
+				r.LastTouched = known.LastTouched
+				r.LastTouchedRun = known.LastTouchedRun
+			}
+			r.RunsSinceTouched = h.Runs - r.LastTouchedRun
+			resources = append(resources, r)
+		}
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].ID < resources[j].ID
+	})
+	return resources, nil
+}
+
+// discoverRoutes returns the IDs of the routes the hub's ownership marker
+// matches in the subscription's route tables. Routes of services like AKS
+// are never velora's, whatever their name, they'd look abandoned.
+func discoverRoutes(ctx context.Context, clientFactory *azure.ClientFactory, hub *config.HubVNetConfig,
+	serviceMatchers []config.ServiceRouteMatcher) ([]string, error) {
+	routeTablesClient, err := clientFactory.NewRouteTablesClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	pager := routeTablesClient.NewListAllPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list route tables: %w", err)
+		}
+		for _, rt := range page.Value {
+			if rt == nil || rt.Properties == nil {
+				continue
+			}
+			tags := make(map[string]string, len(rt.Tags))
+			for key, value := range rt.Tags {
+				if value != nil {
+					tags[key] = *value
+				}
+			}
+			for _, route := range rt.Properties.Routes {
+				if route == nil || route.Name == nil || route.ID == nil {
+					continue
+				}
+				if hub.OwnsRoute(*route.Name, "") && config.ServiceOfRoute(serviceMatchers, *route.ID, tags, *route.Name) == "" {
+					ids = append(ids, *route.ID)
+				}
+			}
+		}
+	}
+	return ids, nil
+}
+
+// load reads the history from the state store.
+func load(store state.Store) (*history, error) {
+	h := &history{Resources: make(map[string]*Resource)}
+	if err := store.Get(stateKey, h); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load managed resources: %w", err)
+	}
+	if h.Resources == nil {
+		h.Resources = make(map[string]*Resource)
+	}
+	return h, nil
+}
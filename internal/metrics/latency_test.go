@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure"
+)
+
+// TestWriteTextfileARMLatency checks the exposition of the ARM latency
+// histograms accumulated across runs: cumulative buckets, sum and count.
+func TestWriteTextfileARMLatency(t *testing.T) {
+	s := &Snapshot{ARMLatency: make(map[string]*Histogram)}
+	s.ObserveLatencies(azure.Latencies{
+		"routes/createOrUpdate": {{Duration: 80 * time.Millisecond}, {Duration: 100 * time.Millisecond}},
+	})
+	s.ObserveLatencies(azure.Latencies{
+		"routes/createOrUpdate": {{Duration: 90 * time.Second}},
+		"routeTables/list":      {{Duration: 30 * time.Millisecond, Failed: true}},
+	})
+
+	path := filepath.Join(t.TempDir(), "velora.prom")
+	if err := s.WriteTextfile(path, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.Contains(line, ARMRequestDuration) {
+			got = append(got, line)
+		}
+	}
+
+	want := []string{
+		"# HELP velora_arm_request_duration_seconds Seconds taken by the ARM requests per operation, retries included.",
+		"# TYPE velora_arm_request_duration_seconds histogram",
+	}
+	buckets := []string{"0.05", "0.1", "0.25", "0.5", "1", "2.5", "5", "10", "30", "60", "+Inf"}
+	for _, operation := range []struct {
+		name       string
+		cumulative []string
+		sum, count string
+	}{
+		{name: "routeTables/list", cumulative: []string{"1", "1", "1", "1", "1", "1", "1", "1", "1", "1", "1"}, sum: "0.03", count: "1"},
+		// the 100ms request is in the 0.1 bucket, the 90s one only in +Inf
+		{name: "routes/createOrUpdate", cumulative: []string{"0", "2", "2", "2", "2", "2", "2", "2", "2", "2", "3"}, sum: "90.18", count: "3"},
+	} {
+		for i, bound := range buckets {
+			want = append(want, `velora_arm_request_duration_seconds_bucket{operation="`+operation.name+`",le="`+bound+`"} `+operation.cumulative[i])
+		}
+		want = append(want,
+			`velora_arm_request_duration_seconds_sum{operation="`+operation.name+`"} `+operation.sum,
+			`velora_arm_request_duration_seconds_count{operation="`+operation.name+`"} `+operation.count)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("exposition =\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// TestWriteTextfileARMListPages checks the list pages and their reductions
+// are counted per operation across runs.
+func TestWriteTextfileARMListPages(t *testing.T) {
+	s := &Snapshot{ARMListPages: make(map[string]*ListPagesSnapshot)}
+	s.ObserveListPages(azure.ListPages{
+		"routeTables/list":     {Pages: 3, Reductions: 1, PageSize: 50},
+		"subscriptions/list":   {Pages: 1},
+		"virtualNetworks/list": {Pages: 2, PageSize: 100},
+		"securityRules/list":   {},
+	})
+	s.ObserveListPages(azure.ListPages{
+		"routeTables/list": {Pages: 4, Reductions: 2, PageSize: 25},
+	})
+
+	path := filepath.Join(t.TempDir(), "velora.prom")
+	if err := s.WriteTextfile(path, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.Contains(line, "velora_arm_list_page") {
+			got = append(got, line)
+		}
+	}
+
+	want := []string{
+		"# HELP velora_arm_list_pages_total ARM list pages fetched per operation.",
+		"# TYPE velora_arm_list_pages_total counter",
+		`velora_arm_list_pages_total{operation="routeTables/list"} 7`,
+		`velora_arm_list_pages_total{operation="securityRules/list"} 0`,
+		`velora_arm_list_pages_total{operation="subscriptions/list"} 1`,
+		`velora_arm_list_pages_total{operation="virtualNetworks/list"} 2`,
+		"# HELP velora_arm_list_page_reductions_total ARM list pages fetched again with a smaller page size per operation, too large or timed out.",
+		"# TYPE velora_arm_list_page_reductions_total counter",
+		`velora_arm_list_page_reductions_total{operation="routeTables/list"} 3`,
+		`velora_arm_list_page_reductions_total{operation="securityRules/list"} 0`,
+		`velora_arm_list_page_reductions_total{operation="subscriptions/list"} 0`,
+		`velora_arm_list_page_reductions_total{operation="virtualNetworks/list"} 0`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("exposition =\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,458 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/breakglass"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/inventory"
+	"github.com/akos011221/velora/internal/limits"
+	"github.com/akos011221/velora/internal/pause"
+	"github.com/akos011221/velora/internal/preflight"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// stateKey is the state store key holding the metrics of the last runs.
+const stateKey = "metrics"
+
+// Error classes of ControllerLastError.
+const (
+	ClassNone      = "none"
+	ClassThrottled = "throttled"
+	ClassForbidden = "forbidden"
+	ClassNotFound  = "not_found"
+	ClassTimeout   = "timeout"
+	ClassOther     = "other"
+)
+
+// Snapshot holds the metrics kept between runs. Pauses and break-glass mode
+// aren't kept, they are read when the metrics are written.
+type Snapshot struct {
+	LastSuccess      map[string]time.Time `json:"lastSuccess"`
+	Access           map[string]int       `json:"access"`
+	Unmanaged        int                  `json:"unmanaged"`
+	FindingsOpen     map[string]int       `json:"findingsOpen"`
+	ControllerErrors map[string]string    `json:"controllerErrors"`
+	// ControllerPanics counts the recovered panics per controller, across runs.
+	ControllerPanics map[string]int `json:"controllerPanics,omitempty"`
+	// PostureScores are the posture scores per subscription.
+	PostureScores map[string]float64 `json:"postureScores,omitempty"`
+	// NotificationsSuppressed counts the alerts suppressed per severity,
+	// across runs.
+	NotificationsSuppressed map[string]int `json:"notificationsSuppressed,omitempty"`
+	// Queue is the state of the work queue, nil if no worker ran.
+	Queue *QueueSnapshot `json:"queue,omitempty"`
+	// ARMLatency is the histogram of the ARM requests per operation,
+	// across runs.
+	ARMLatency map[string]*Histogram `json:"armLatency,omitempty"`
+	// ARMListPages counts the list pages and their reductions per
+	// operation, across runs.
+	ARMListPages map[string]*ListPagesSnapshot `json:"armListPages,omitempty"`
+	// HubCapacity is the usage of the hubs with a design capacity, by hub.
+	HubCapacity map[string]HubCapacitySnapshot `json:"hubCapacity,omitempty"`
+	// HubCacheHits and HubCacheMisses count the lookups of the hub
+	// inventory cache, across runs.
+	HubCacheHits   uint64 `json:"hubCacheHits,omitempty"`
+	HubCacheMisses uint64 `json:"hubCacheMisses,omitempty"`
+}
+
+// HubCapacitySnapshot is the usage of a hub as of the last run measuring it.
+type HubCapacitySnapshot struct {
+	Spokes      int     `json:"spokes"`
+	Utilization float64 `json:"utilization"`
+}
+
+// ListPagesSnapshot counts the list pages of an operation.
+type ListPagesSnapshot struct {
+	Pages      uint64 `json:"pages"`
+	Reductions uint64 `json:"reductions,omitempty"`
+}
+
+// latencyBuckets are the upper bounds in seconds of the ARMLatency buckets.
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Histogram counts observations in the latencyBuckets. Counts has a count
+// per bucket, not cumulative, and a last one for those above every bound.
+type Histogram struct {
+	Counts []uint64 `json:"counts"`
+	Sum    float64  `json:"sum"`
+	Count  uint64   `json:"count"`
+}
+
+// observe adds an observation in seconds.
+func (h *Histogram) observe(seconds float64) {
+	if len(h.Counts) != len(latencyBuckets)+1 {
+		h.Counts = make([]uint64, len(latencyBuckets)+1)
+	}
+	i := sort.SearchFloat64s(latencyBuckets, seconds)
+	h.Counts[i]++
+	h.Sum += seconds
+	h.Count++
+}
+
+// QueueSnapshot is the state of the work queue after the last processed item.
+type QueueSnapshot struct {
+	Depth          int     `json:"depth"`
+	LatencySeconds float64 `json:"latencySeconds"`
+	DeadLetters    int     `json:"deadLetters"`
+}
+
+// updates serializes the updates of the snapshot by the runs and queue
+// workers of the process.
+var updates sync.Mutex
+
+// Load reads the snapshot from the state store, empty if there is none.
+func Load(store state.Store) (*Snapshot, error) {
+	s := &Snapshot{}
+	if err := store.Get(stateKey, s); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load metrics: %w", err)
+	}
+	if s.LastSuccess == nil {
+		s.LastSuccess = make(map[string]time.Time)
+	}
+	if s.Access == nil {
+		s.Access = make(map[string]int)
+	}
+	if s.FindingsOpen == nil {
+		s.FindingsOpen = make(map[string]int)
+	}
+	if s.ControllerErrors == nil {
+		s.ControllerErrors = make(map[string]string)
+	}
+	if s.ControllerPanics == nil {
+		s.ControllerPanics = make(map[string]int)
+	}
+	if s.PostureScores == nil {
+		s.PostureScores = make(map[string]float64)
+	}
+	if s.NotificationsSuppressed == nil {
+		s.NotificationsSuppressed = make(map[string]int)
+	}
+	if s.ARMLatency == nil {
+		s.ARMLatency = make(map[string]*Histogram)
+	}
+	if s.HubCapacity == nil {
+		s.HubCapacity = make(map[string]HubCapacitySnapshot)
+	}
+	if s.ARMListPages == nil {
+		s.ARMListPages = make(map[string]*ListPagesSnapshot)
+	}
+	return s, nil
+}
+
+// Save writes the snapshot to the state store.
+func (s *Snapshot) Save(store state.Store) error {
+	if err := store.Put(stateKey, s); err != nil {
+		return fmt.Errorf("failed to save metrics: %w", err)
+	}
+	return nil
+}
+
+// Update loads the snapshot, applies update and saves it.
+func Update(store state.Store, update func(*Snapshot)) error {
+	updates.Lock()
+	defer updates.Unlock()
+	s, err := Load(store)
+	if err != nil {
+		return err
+	}
+	update(s)
+	return s.Save(store)
+}
+
+// Prune drops the subscriptions that aren't configured anymore.
+func (s *Snapshot) Prune(subscriptionIDs []string) {
+	configured := make(map[string]bool, len(subscriptionIDs))
+	for _, subID := range subscriptionIDs {
+		configured[subID] = true
+	}
+	for subID := range s.LastSuccess {
+		if !configured[subID] {
+			delete(s.LastSuccess, subID)
+		}
+	}
+	for subID := range s.Access {
+		if !configured[subID] {
+			delete(s.Access, subID)
+		}
+	}
+	for subID := range s.PostureScores {
+		if !configured[subID] {
+			delete(s.PostureScores, subID)
+		}
+	}
+}
+
+// ObserveAccess records the access found by the preflight checks.
+// Subscriptions whose access wasn't checked keep their previous value.
+func (s *Snapshot) ObserveAccess(report *preflight.Report) {
+	for _, sub := range report.Subscriptions {
+		switch sub.Access {
+		case preflight.AccessReadWrite, preflight.AccessBlocked:
+			s.Access[sub.SubscriptionID] = 2
+		case preflight.AccessRead:
+			s.Access[sub.SubscriptionID] = 1
+		case preflight.AccessNone, preflight.AccessInactive:
+			s.Access[sub.SubscriptionID] = 0
+		}
+	}
+}
+
+// ObserveFindings records the findings of a completed run per severity.
+func (s *Snapshot) ObserveFindings(all []findings.Finding) {
+	s.FindingsOpen = make(map[string]int)
+	for _, severity := range []findings.Severity{findings.SeverityCritical, findings.SeverityHigh,
+		findings.SeverityMedium, findings.SeverityLow, findings.SeverityInfo} {
+		s.FindingsOpen[string(severity)] = 0
+	}
+	for _, f := range all {
+		s.FindingsOpen[string(f.Severity)]++
+	}
+}
+
+// ObserveLatencies adds the ARM requests of a run to the histograms.
+func (s *Snapshot) ObserveLatencies(latencies azure.Latencies) {
+	for operation, samples := range latencies {
+		h := s.ARMLatency[operation]
+		if h == nil {
+			h = &Histogram{}
+			s.ARMLatency[operation] = h
+		}
+		for _, sample := range samples {
+			h.observe(sample.Duration.Seconds())
+		}
+	}
+}
+
+// ObserveListPages adds the list pages of a run to the counters.
+func (s *Snapshot) ObserveListPages(pages azure.ListPages) {
+	for operation, stats := range pages {
+		counted := s.ARMListPages[operation]
+		if counted == nil {
+			counted = &ListPagesSnapshot{}
+			s.ARMListPages[operation] = counted
+		}
+		counted.Pages += stats.Pages
+		counted.Reductions += stats.Reductions
+	}
+}
+
+// ObserveHubCache adds the hub cache lookups of a run to the counters.
+func (s *Snapshot) ObserveHubCache(stats inventory.CacheStats) {
+	s.HubCacheHits += stats.Hits
+	s.HubCacheMisses += stats.Misses
+}
+
+// ObserveHubCapacity records the usage of the hubs measured by a run,
+// replacing that of the hubs no longer measured.
+func (s *Snapshot) ObserveHubCapacity(usages []limits.HubUsage) {
+	s.HubCapacity = make(map[string]HubCapacitySnapshot, len(usages))
+	for _, u := range usages {
+		s.HubCapacity[u.Hub] = HubCapacitySnapshot{Spokes: u.Spokes, Utilization: u.Utilization()}
+	}
+}
+
+// ErrorClass classifies a controller error for ControllerLastError. A token
+// acquisition that timed out is a timeout too.
+func ErrorClass(err error) string {
+	var tokenTimeout *azure.TokenTimeoutError
+	switch {
+	case err == nil:
+		return ClassNone
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &tokenTimeout):
+		return ClassTimeout
+	case azure.IsThrottled(err):
+		return ClassThrottled
+	case azure.IsAccessDenied(err):
+		return ClassForbidden
+	case azure.IsNotFound(err):
+		return ClassNotFound
+	default:
+		return ClassOther
+	}
+}
+
+// WriteTextfile writes the metrics in the Prometheus text format, for the
+// node exporter's textfile collector. The file is replaced atomically, so
+// the collector never reads a partial file.
+func (s *Snapshot) WriteTextfile(path string, pauses []*pause.Pause, breakGlass *breakglass.Activation) error {
+	var b strings.Builder
+	for _, d := range definitions {
+		kind := "gauge"
+		if strings.HasSuffix(d.name, "_total") {
+			kind = "counter"
+		} else if d.name == ARMRequestDuration {
+			kind = "histogram"
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, kind)
+		switch d.name {
+		case LastSuccessfulEnforcement:
+			for _, subID := range sortedKeys(s.LastSuccess) {
+				writeSample(&b, d.name, float64(s.LastSuccess[subID].Unix()), LabelSubscription, subID)
+			}
+		case AccessLevel:
+			for _, subID := range sortedKeys(s.Access) {
+				writeSample(&b, d.name, float64(s.Access[subID]), LabelSubscription, subID)
+			}
+		case UnmanagedSubscriptions:
+			writeSample(&b, d.name, float64(s.Unmanaged))
+		case FindingsOpen:
+			for _, severity := range sortedKeys(s.FindingsOpen) {
+				writeSample(&b, d.name, float64(s.FindingsOpen[severity]), LabelSeverity, severity)
+			}
+		case Paused:
+			for _, p := range pauses {
+				scope := p.Scope
+				if scope == pause.GlobalScope {
+					scope = "global"
+				}
+				writeSample(&b, d.name, 1, LabelSubscription, scope)
+			}
+		case BreakGlassActive:
+			active := 0.0
+			if breakGlass != nil {
+				active = 1
+			}
+			writeSample(&b, d.name, active)
+		case ControllerLastError:
+			for _, controller := range sortedKeys(s.ControllerErrors) {
+				writeSample(&b, d.name, 1, LabelController, controller, LabelClass, s.ControllerErrors[controller])
+			}
+		case ControllerPanics:
+			for _, controller := range sortedKeys(s.ControllerPanics) {
+				writeSample(&b, d.name, float64(s.ControllerPanics[controller]), LabelController, controller)
+			}
+		case PostureScore:
+			for _, subID := range sortedKeys(s.PostureScores) {
+				writeSample(&b, d.name, s.PostureScores[subID], LabelSubscription, subID)
+			}
+		case NotificationsSuppressed:
+			for _, severity := range sortedKeys(s.NotificationsSuppressed) {
+				writeSample(&b, d.name, float64(s.NotificationsSuppressed[severity]), LabelSeverity, severity)
+			}
+		case QueueDepth:
+			if s.Queue != nil {
+				writeSample(&b, d.name, float64(s.Queue.Depth))
+			}
+		case QueueLatency:
+			if s.Queue != nil {
+				writeSample(&b, d.name, s.Queue.LatencySeconds)
+			}
+		case QueueDeadLetters:
+			if s.Queue != nil {
+				writeSample(&b, d.name, float64(s.Queue.DeadLetters))
+			}
+		case ARMRequestDuration:
+			for _, operation := range sortedKeys(s.ARMLatency) {
+				writeHistogram(&b, d.name, s.ARMLatency[operation], LabelOperation, operation)
+			}
+		case ARMListPages:
+			for _, operation := range sortedKeys(s.ARMListPages) {
+				writeSample(&b, d.name, float64(s.ARMListPages[operation].Pages), LabelOperation, operation)
+			}
+		case ARMListPageReductions:
+			for _, operation := range sortedKeys(s.ARMListPages) {
+				writeSample(&b, d.name, float64(s.ARMListPages[operation].Reductions), LabelOperation, operation)
+			}
+		case HubSpokeCount:
+			for _, hub := range sortedKeys(s.HubCapacity) {
+				writeSample(&b, d.name, float64(s.HubCapacity[hub].Spokes), LabelHub, hub)
+			}
+		case HubCapacityUtilization:
+			for _, hub := range sortedKeys(s.HubCapacity) {
+				writeSample(&b, d.name, s.HubCapacity[hub].Utilization, LabelHub, hub)
+			}
+		case HubCacheHits:
+			writeSample(&b, d.name, float64(s.HubCacheHits))
+		case HubCacheMisses:
+			writeSample(&b, d.name, float64(s.HubCacheMisses))
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	// the collector runs as another user
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return nil
+}
+
+// Refresh rewrites the metrics file with the current pauses and break-glass
+// mode, after a pause, resume or break-glass change.
+func Refresh(store state.Store, path string) error {
+	snapshot, err := Load(store)
+	if err != nil {
+		return err
+	}
+	pauses, err := pause.NewManager(store).List()
+	if err != nil {
+		return err
+	}
+	breakGlass, err := breakglass.NewManager(store).Active()
+	if err != nil {
+		return err
+	}
+	return snapshot.WriteTextfile(path, pauses, breakGlass)
+}
+
+// writeSample writes a sample, labels are name and value pairs.
+func writeSample(b *strings.Builder, name string, value float64, labels ...string) {
+	b.WriteString(name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+		}
+		b.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	b.WriteString(" " + strconv.FormatFloat(value, 'f', -1, 64) + "\n")
+}
+
+// writeHistogram writes the cumulative buckets, sum and count of the
+// histogram, labels are name and value pairs.
+func writeHistogram(b *strings.Builder, name string, h *Histogram, labels ...string) {
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		if i < len(h.Counts) {
+			cumulative += h.Counts[i]
+		}
+		writeSample(b, name+"_bucket", float64(cumulative), append(append([]string{}, labels...), "le", strconv.FormatFloat(bound, 'f', -1, 64))...)
+	}
+	writeSample(b, name+"_bucket", float64(h.Count), append(append([]string{}, labels...), "le", "+Inf")...)
+	writeSample(b, name+"_sum", h.Sum, labels...)
+	writeSample(b, name+"_count", float64(h.Count), labels...)
+}
+
+// sortedKeys returns the keys of the map, sorted.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
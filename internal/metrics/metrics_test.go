@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/inventory"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: nil, want: ClassNone},
+		{err: fmt.Errorf("failed to list route tables: %w", context.DeadlineExceeded), want: ClassTimeout},
+		{err: fmt.Errorf("failed to list route tables: %w", &azure.TokenTimeoutError{Endpoint: "login.microsoftonline.com", Timeout: time.Second}), want: ClassTimeout},
+		{err: &azcore.ResponseError{StatusCode: http.StatusTooManyRequests}, want: ClassThrottled},
+		{err: &azcore.ResponseError{StatusCode: http.StatusForbidden}, want: ClassForbidden},
+		{err: &azcore.ResponseError{StatusCode: http.StatusNotFound}, want: ClassNotFound},
+		{err: &azcore.ResponseError{StatusCode: http.StatusInternalServerError}, want: ClassOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.err), func(t *testing.T) {
+			if got := ErrorClass(tt.err); got != tt.want {
+				t.Errorf("ErrorClass(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWriteTextfileHubCache checks the hub cache lookups are counted across
+// runs.
+func TestWriteTextfileHubCache(t *testing.T) {
+	s := &Snapshot{}
+	s.ObserveHubCache(inventory.CacheStats{Hits: 5, Misses: 2})
+	s.ObserveHubCache(inventory.CacheStats{Hits: 3, Misses: 1})
+
+	path := filepath.Join(t.TempDir(), "velora.prom")
+	if err := s.WriteTextfile(path, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# TYPE velora_hub_cache_hits_total counter\nvelora_hub_cache_hits_total 8\n",
+		"# TYPE velora_hub_cache_misses_total counter\nvelora_hub_cache_misses_total 3\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("exposition =\n%s\nwant %q", data, want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package metrics
+
+// Metric names. Every metric is a gauge, alerts compare them directly,
+// except the _total counters and the ARMRequestDuration histogram.
+const (
+	// LastSuccessfulEnforcement is the Unix time of the last run that
+	// evaluated the subscription completely.
+	LastSuccessfulEnforcement = "velora_subscription_last_successful_enforcement_timestamp"
+	// AccessLevel is velora's access to the subscription: 0 none, 1 read,
+	// 2 read-write.
+	AccessLevel = "velora_subscription_access_level"
+	// UnmanagedSubscriptions counts the configured subscriptions the last
+	// run didn't evaluate.
+	UnmanagedSubscriptions = "velora_coverage_unmanaged_subscriptions"
+	// FindingsOpen counts the findings of the last run per severity.
+	FindingsOpen = "velora_findings_open"
+	// Paused is 1 per active pause, the subscription is "global" for a
+	// global pause.
+	Paused = "velora_paused"
+	// BreakGlassActive is 1 while break-glass mode is active, 0 otherwise.
+	BreakGlassActive = "velora_break_glass_active"
+	// ControllerLastError is 1 for the class of the last error of each
+	// controller, "none" if its last run succeeded.
+	ControllerLastError = "velora_controller_last_error_info"
+	// ControllerPanics counts the panics recovered from each controller,
+	// "run" for those outside a controller.
+	ControllerPanics = "velora_controller_panics_total"
+	// PostureScore is the network posture score of the subscription, from
+	// 0 to 100, as of the last run that evaluated it.
+	PostureScore = "velora_posture_score"
+	// NotificationsSuppressed counts the alerts the notification throttle
+	// suppressed per severity, the findings were alerted recently.
+	NotificationsSuppressed = "velora_notifications_suppressed_total"
+	// QueueDepth is the approximate number of work queue items waiting or
+	// being processed.
+	QueueDepth = "velora_queue_depth"
+	// QueueLatency is the time from enqueue to completion of the last
+	// completed work queue item.
+	QueueLatency = "velora_queue_latency_seconds"
+	// QueueDeadLetters counts the work queue items on the dead-letter list.
+	QueueDeadLetters = "velora_queue_dead_letters"
+	// ARMRequestDuration is the histogram of the ARM requests per
+	// operation, retries included, across runs.
+	ARMRequestDuration = "velora_arm_request_duration_seconds"
+	// ARMListPages counts the ARM list pages fetched per operation, across
+	// runs.
+	ARMListPages = "velora_arm_list_pages_total"
+	// ARMListPageReductions counts the ARM list pages fetched again with a
+	// smaller page size per operation, across runs.
+	ARMListPageReductions = "velora_arm_list_page_reductions_total"
+	// HubSpokeCount is the number of spokes peered with each hub with a
+	// design capacity.
+	HubSpokeCount = "velora_hub_spoke_count"
+	// HubCapacityUtilization is the used fraction of the most utilized
+	// capacity limit of each hub, above 1 past its capacity.
+	HubCapacityUtilization = "velora_hub_capacity_utilization"
+	// HubCacheHits counts the hub inventory lookups served from the cache,
+	// in-flight fetches shared included, across runs.
+	HubCacheHits = "velora_hub_cache_hits_total"
+	// HubCacheMisses counts the hub inventory lookups that fetched the hub,
+	// across runs.
+	HubCacheMisses = "velora_hub_cache_misses_total"
+)
+
+// Label names. Labels are limited to these, so the number of series stays
+// bounded by the subscriptions and controllers.
+const (
+	LabelSubscription = "subscription"
+	LabelController   = "controller"
+	LabelSeverity     = "severity"
+	LabelClass        = "class"
+	// LabelOperation is an ARM operation from azure.OperationOf, bounded by
+	// the APIs velora calls.
+	LabelOperation = "operation"
+	// LabelHub is the name of a configured hub.
+	LabelHub = "hub"
+)
+
+// definition describes a metric for the exposition format.
+type definition struct {
+	name   string
+	help   string
+	labels []string
+}
+
+// definitions are the metrics written, in output order.
+var definitions = []definition{
+	{LastSuccessfulEnforcement, "Unix time of the last run that evaluated the subscription completely.", []string{LabelSubscription}},
+	{AccessLevel, "Access to the subscription: 0 none, 1 read, 2 read-write.", []string{LabelSubscription}},
+	{UnmanagedSubscriptions, "Configured subscriptions the last run didn't evaluate.", nil},
+	{FindingsOpen, "Findings of the last run per severity.", []string{LabelSeverity}},
+	{Paused, "1 per active pause, subscription is global for a global pause.", []string{LabelSubscription}},
+	{BreakGlassActive, "1 while break-glass mode is active, 0 otherwise.", nil},
+	{ControllerLastError, "1 for the class of the last error of the controller, none if it succeeded.", []string{LabelController, LabelClass}},
+	{ControllerPanics, "Panics recovered from the controller, run for those outside a controller.", []string{LabelController}},
+	{PostureScore, "Network posture score of the subscription from 0 to 100, as of the last run that evaluated it.", []string{LabelSubscription}},
+	{NotificationsSuppressed, "Alerts suppressed by the notification throttle per severity, the findings were alerted recently.", []string{LabelSeverity}},
+	{QueueDepth, "Work queue items waiting or being processed.", nil},
+	{QueueLatency, "Seconds from enqueue to completion of the last completed work queue item.", nil},
+	{QueueDeadLetters, "Work queue items on the dead-letter list.", nil},
+	{ARMRequestDuration, "Seconds taken by the ARM requests per operation, retries included.", []string{LabelOperation}},
+	{ARMListPages, "ARM list pages fetched per operation.", []string{LabelOperation}},
+	{ARMListPageReductions, "ARM list pages fetched again with a smaller page size per operation, too large or timed out.", []string{LabelOperation}},
+	{HubSpokeCount, "Spokes peered with the hub, for hubs with a design capacity.", []string{LabelHub}},
+	{HubCapacityUtilization, "Used fraction of the most utilized capacity limit of the hub, above 1 past its capacity.", []string{LabelHub}},
+	{HubCacheHits, "Hub inventory lookups served from the cache, in-flight fetches shared included.", nil},
+	{HubCacheMisses, "Hub inventory lookups that fetched the hub.", nil},
+}
+
+// allowedLabels are the only labels a metric may have. Anything else, like a
+// resource ID, would create a series per resource.
+var allowedLabels = map[string]bool{
+	LabelSubscription: true,
+	LabelController:   true,
+	LabelSeverity:     true,
+	LabelClass:        true,
+	LabelOperation:    true,
+	LabelHub:          true,
+}
@@ -0,0 +1,23 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDefinitionsUseAllowedLabels(t *testing.T) {
+	var allowed []string
+	for label := range allowedLabels {
+		allowed = append(allowed, label)
+	}
+	sort.Strings(allowed)
+
+	for _, d := range definitions {
+		for _, label := range d.labels {
+			if !allowedLabels[label] {
+				t.Errorf("metric %s has label %q, allowed labels are %s", d.name, label, strings.Join(allowed, ", "))
+			}
+		}
+	}
+}
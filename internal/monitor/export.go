@@ -0,0 +1,233 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+const (
+	// ingestionScope is the token scope of the Logs Ingestion API.
+	ingestionScope = "https://monitor.azure.com/.default"
+	// ingestionAPIVersion is the Logs Ingestion API version.
+	ingestionAPIVersion = "2023-01-01"
+	// maxRequestBytes is the payload limit of a single ingestion request.
+	maxRequestBytes = 1 << 20
+
+	sendAttempts   = 4
+	initialBackoff = 2 * time.Second
+)
+
+// RecordSchema is the JSON schema of the emitted records. The Log Analytics
+// custom table and the stream declaration of the DCR must match it.
+const RecordSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "VeloraRunSummary",
+  "type": "object",
+  "properties": {
+    "TimeGenerated":    {"type": "string", "format": "date-time"},
+    "RunId":            {"type": "string"},
+    "SubscriptionId":   {"type": "string"},
+    "FindingsCritical": {"type": "integer"},
+    "FindingsHigh":     {"type": "integer"},
+    "FindingsMedium":   {"type": "integer"},
+    "FindingsLow":      {"type": "integer"},
+    "FindingsInfo":     {"type": "integer"},
+    "Remediations":     {"type": "integer"},
+    "DurationSeconds":  {"type": "number"},
+    "ErrorClass":       {"type": "string"},
+    "Shard":            {"type": "string"},
+    "Owner":            {"type": "string"},
+    "Team":             {"type": "string"},
+    "ContactEmail":     {"type": "string"},
+    "TicketQueue":      {"type": "string"},
+    "Environment":      {"type": "string"}
+  },
+  "required": ["TimeGenerated", "RunId", "SubscriptionId"]
+}`
+
+// Record is the per-subscription summary of a run, see RecordSchema.
+type Record struct {
+	TimeGenerated    time.Time `json:"TimeGenerated"`
+	RunID            string    `json:"RunId"`
+	SubscriptionID   string    `json:"SubscriptionId"`
+	FindingsCritical int       `json:"FindingsCritical"`
+	FindingsHigh     int       `json:"FindingsHigh"`
+	FindingsMedium   int       `json:"FindingsMedium"`
+	FindingsLow      int       `json:"FindingsLow"`
+	FindingsInfo     int       `json:"FindingsInfo"`
+	Remediations     int       `json:"Remediations"`
+	DurationSeconds  float64   `json:"DurationSeconds"`
+	ErrorClass       string    `json:"ErrorClass,omitempty"`
+	// Shard is the shard of the instance as index/count, empty unless sharded.
+	Shard string `json:"Shard,omitempty"`
+	// Owner, Team, ContactEmail, TicketQueue and Environment are the
+	// ownership of the subscription, empty if none is configured.
+	Owner        string `json:"Owner,omitempty"`
+	Team         string `json:"Team,omitempty"`
+	ContactEmail string `json:"ContactEmail,omitempty"`
+	TicketQueue  string `json:"TicketQueue,omitempty"`
+	Environment  string `json:"Environment,omitempty"`
+}
+
+// SetOwnership sets the ownership of the subscription in the record.
+func (r *Record) SetOwnership(o *config.Ownership) {
+	if o == nil {
+		return
+	}
+	r.Owner = o.Owner
+	r.Team = o.Team
+	r.ContactEmail = o.ContactEmail
+	r.TicketQueue = o.TicketQueue
+	r.Environment = o.Environment
+}
+
+// AddFinding counts the finding in the record by severity.
+func (r *Record) AddFinding(f findings.Finding) {
+	r.SetOwnership(f.Ownership)
+	switch f.Severity {
+	case findings.SeverityCritical:
+		r.FindingsCritical++
+	case findings.SeverityHigh:
+		r.FindingsHigh++
+	case findings.SeverityMedium:
+		r.FindingsMedium++
+	case findings.SeverityLow:
+		r.FindingsLow++
+	default:
+		r.FindingsInfo++
+	}
+}
+
+// Exporter pushes run summaries to Log Analytics through the Logs Ingestion API.
+type Exporter struct {
+	cfg        config.AzureMonitorConfig
+	cred       azcore.TokenCredential
+	httpClient *http.Client
+}
+
+// NewExporter creates a new exporter authenticating with the given credential.
+func NewExporter(cfg config.AzureMonitorConfig, cred azcore.TokenCredential) *Exporter {
+	return &Exporter{
+		cfg:        cfg,
+		cred:       cred,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Export uploads the records in batches under the request size limit.
+// Errors are returned for logging only, they must never fail the run.
+func (e *Exporter) Export(ctx context.Context, records []Record) error {
+	batches, err := batch(records, maxRequestBytes)
+	if err != nil {
+		return err
+	}
+
+	for i, body := range batches {
+		if err := e.upload(ctx, body); err != nil {
+			return fmt.Errorf("failed to export batch %d of %d to azure monitor: %w", i+1, len(batches), err)
+		}
+	}
+	return nil
+}
+
+// upload sends one batch, retrying throttled and failed requests with backoff.
+func (e *Exporter) upload(ctx context.Context, body []byte) error {
+	endpoint := fmt.Sprintf("%s/dataCollectionRules/%s/streams/%s?api-version=%s",
+		strings.TrimRight(e.cfg.Endpoint, "/"), url.PathEscape(e.cfg.RuleID), url.PathEscape(e.cfg.StreamName), ingestionAPIVersion)
+
+	backoff := initialBackoff
+	var lastErr error
+	for attempt := 1; attempt <= sendAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			backoff *= 2
+		}
+
+		retry, err := e.post(ctx, endpoint, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+	return lastErr
+}
+
+// post sends a single request and reports whether a failure is retryable.
+func (e *Exporter) post(ctx context.Context, endpoint string, body []byte) (bool, error) {
+	token, err := e.cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{ingestionScope}})
+	if err != nil {
+		return true, fmt.Errorf("failed to acquire token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("logs ingestion returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// batch splits the records into JSON arrays no larger than limit bytes.
+func batch(records []Record, limit int) ([][]byte, error) {
+	var batches [][]byte
+	current := []byte{'['}
+
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode record: %w", err)
+		}
+		if len(data)+2 > limit {
+			return nil, fmt.Errorf("record for subscription %s exceeds the request size limit", record.SubscriptionID)
+		}
+
+		// +1 for the separator or the closing bracket
+		if len(current) > 1 && len(current)+len(data)+2 > limit {
+			batches = append(batches, append(current, ']'))
+			current = []byte{'['}
+		}
+		if len(current) > 1 {
+			current = append(current, ',')
+		}
+		current = append(current, data...)
+	}
+
+	if len(current) > 1 {
+		batches = append(batches, append(current, ']'))
+	}
+	return batches, nil
+}
@@ -0,0 +1,133 @@
+package naming
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// MaxLength is the maximum length of route, route table and peering names.
+	MaxLength = 80
+	// hashLength is the length of the hash suffix added to adjusted names.
+	hashLength = 8
+)
+
+// placeholder matches template placeholders like {subnet}.
+var placeholder = regexp.MustCompile(`\{[A-Za-z]+\}`)
+
+// isAlphanumeric reports whether r is an ASCII letter or digit.
+func isAlphanumeric(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+// isAllowed reports whether r may appear in a resource name.
+func isAllowed(r rune) bool {
+	return isAlphanumeric(r) || r == '_' || r == '.' || r == '-'
+}
+
+// Validate checks a name against Azure's constraints for routes, route tables
+// and peerings: 1-80 characters of letters, digits, underscores, periods and
+// hyphens, starting with a letter or digit and ending with a letter, digit
+// or underscore.
+func Validate(name string) error {
+	if name == "" {
+		return fmt.Errorf("name is empty")
+	}
+	if len(name) > MaxLength {
+		return fmt.Errorf("name %q is longer than %d characters", name, MaxLength)
+	}
+	for _, r := range name {
+		if !isAllowed(r) {
+			return fmt.Errorf("name %q contains invalid character %q", name, r)
+		}
+	}
+	first, last := rune(name[0]), rune(name[len(name)-1])
+	if !isAlphanumeric(first) {
+		return fmt.Errorf("name %q must start with a letter or digit", name)
+	}
+	if !isAlphanumeric(last) && last != '_' {
+		return fmt.Errorf("name %q must end with a letter, digit or underscore", name)
+	}
+	return nil
+}
+
+// ResourceName turns a computed name into a valid one. Names that are already
+// valid are returned unchanged. Otherwise invalid characters are replaced, and
+// the name is truncated if needed and suffixed with a short hash of the
+// original, so distinct inputs keep distinct names.
+func ResourceName(name string) (string, error) {
+	if Validate(name) == nil {
+		return name, nil
+	}
+
+	var b strings.Builder
+	for _, r := range name {
+		if isAllowed(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	sanitized := strings.TrimLeftFunc(b.String(), func(r rune) bool { return !isAlphanumeric(r) })
+
+	sum := sha256.Sum256([]byte(name))
+	suffix := hex.EncodeToString(sum[:])[:hashLength]
+
+	maxPrefix := MaxLength - hashLength - 1
+	if len(sanitized) > maxPrefix {
+		sanitized = sanitized[:maxPrefix]
+	}
+	sanitized = strings.TrimRight(sanitized, ".-")
+
+	result := suffix
+	if sanitized != "" {
+		result = sanitized + "-" + suffix
+	}
+
+	if err := Validate(result); err != nil {
+		return "", fmt.Errorf("failed to compute a valid name for %q: %w", name, err)
+	}
+	return result, nil
+}
+
+// ValidateTemplate checks that a naming template can produce valid names. The
+// literal parts must only use allowed characters and leave room for the
+// placeholder values and the hash suffix.
+func ValidateTemplate(template string) error {
+	literal := placeholder.ReplaceAllString(template, "")
+	if strings.ContainsAny(literal, "{}") {
+		return fmt.Errorf("template %q has malformed placeholders", template)
+	}
+	for _, r := range literal {
+		if !isAllowed(r) {
+			return fmt.Errorf("template %q contains invalid character %q", template, r)
+		}
+	}
+	if len(literal) > MaxLength-hashLength-1 {
+		return fmt.Errorf("template %q leaves no room for values within %d characters", template, MaxLength)
+	}
+	if literal == template {
+		// no placeholders, the template is the name itself
+		return Validate(template)
+	}
+	return nil
+}
+
+// Expand substitutes the placeholders of a template and returns a valid name.
+func Expand(template string, values map[string]string) (string, error) {
+	var missing string
+	name := placeholder.ReplaceAllStringFunc(template, func(p string) string {
+		v, ok := values[p[1:len(p)-1]]
+		if !ok {
+			missing = p
+		}
+		return v
+	})
+	if missing != "" {
+		return "", fmt.Errorf("template %q uses unknown placeholder %s", template, missing)
+	}
+	return ResourceName(name)
+}
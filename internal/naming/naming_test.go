@@ -0,0 +1,135 @@
+package naming
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "simple", value: "velora-rt-spoke-app"},
+		{name: "max length", value: strings.Repeat("a", MaxLength)},
+		{name: "ends with underscore", value: "route_"},
+		{name: "single character", value: "a"},
+		{name: "empty", value: "", wantErr: true},
+		{name: "too long", value: strings.Repeat("a", MaxLength+1), wantErr: true},
+		{name: "starts with hyphen", value: "-route", wantErr: true},
+		{name: "starts with underscore", value: "_route", wantErr: true},
+		{name: "ends with period", value: "route.", wantErr: true},
+		{name: "ends with hyphen", value: "route-", wantErr: true},
+		{name: "space", value: "my route", wantErr: true},
+		{name: "non-ASCII letter", value: "réseau", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate(tt.value); (err != nil) != tt.wantErr {
+				t.Errorf("Validate(%q) error = %v, want error %t", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResourceName(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		want       string
+		wantPrefix string
+	}{
+		{name: "valid is unchanged", value: "velora-rt-spoke-app", want: "velora-rt-spoke-app"},
+		{name: "max length is unchanged", value: strings.Repeat("a", MaxLength), want: strings.Repeat("a", MaxLength)},
+		{name: "one over max length", value: strings.Repeat("a", MaxLength+1), wantPrefix: strings.Repeat("a", MaxLength-hashLength-1) + "-"},
+		{name: "invalid characters replaced", value: "velora rt/spoke", wantPrefix: "velora-rt-spoke-"},
+		{name: "Unicode replaced per character", value: "réseau-é", wantPrefix: "r-seau-"},
+		{name: "leading separators trimmed", value: "--route", wantPrefix: "route-"},
+		{name: "trailing period trimmed", value: "route.", wantPrefix: "route-"},
+		{name: "nothing valid left", value: "ééé", wantPrefix: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResourceName(tt.value)
+			if err != nil {
+				t.Fatalf("ResourceName(%q) error = %v", tt.value, err)
+			}
+			if err := Validate(got); err != nil {
+				t.Errorf("ResourceName(%q) = %q is invalid: %v", tt.value, got, err)
+			}
+			if tt.want != "" && got != tt.want {
+				t.Errorf("ResourceName(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+			if tt.want == "" {
+				if !strings.HasPrefix(got, tt.wantPrefix) || len(got) != len(tt.wantPrefix)+hashLength {
+					t.Errorf("ResourceName(%q) = %q, want %q followed by a %d character hash", tt.value, got, tt.wantPrefix, hashLength)
+				}
+			}
+			if again, _ := ResourceName(tt.value); again != got {
+				t.Errorf("ResourceName(%q) = %q, then %q", tt.value, got, again)
+			}
+		})
+	}
+}
+
+func TestResourceNameCollisions(t *testing.T) {
+	long := strings.Repeat("subnet", 20)
+	inputs := []string{
+		long + "-a",
+		long + "-b",
+		// replaced characters would collide without the hash
+		"spoke app",
+		"spoke/app",
+		"spoke-app.",
+	}
+	seen := make(map[string]string)
+	for _, input := range inputs {
+		name, err := ResourceName(input)
+		if err != nil {
+			t.Fatalf("ResourceName(%q) error = %v", input, err)
+		}
+		if other, ok := seen[name]; ok {
+			t.Errorf("ResourceName(%q) = ResourceName(%q) = %q", input, other, name)
+		}
+		seen[name] = input
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  bool
+	}{
+		{template: "velora-rt-{vnet}-{subnet}"},
+		{template: "DefaultRoute-To-NVA"},
+		{template: "{subnet}"},
+		{template: "velora rt-{subnet}", wantErr: true},
+		{template: "velora-{subnet", wantErr: true},
+		{template: strings.Repeat("a", MaxLength-hashLength) + "{subnet}", wantErr: true},
+		{template: "-route", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			if err := ValidateTemplate(tt.template); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateTemplate(%q) error = %v, want error %t", tt.template, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpand(t *testing.T) {
+	got, err := Expand("velora-rt-{vnet}-{subnet}", map[string]string{"vnet": "spoke", "subnet": "app"})
+	if err != nil || got != "velora-rt-spoke-app" {
+		t.Errorf("Expand() = %q, %v, want velora-rt-spoke-app", got, err)
+	}
+	if _, err := Expand("velora-rt-{vnet}", map[string]string{"subnet": "app"}); err == nil {
+		t.Error("Expand() of an unknown placeholder succeeded")
+	}
+	if got, err := Expand("velora-rt-{subnet}", map[string]string{"subnet": strings.Repeat("x", MaxLength)}); err != nil || len(got) != MaxLength {
+		t.Errorf("Expand() of a long value = %q, %v, want a truncated name", got, err)
+	}
+}
@@ -0,0 +1,326 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/limits"
+	"github.com/akos011221/velora/internal/slo"
+	"github.com/akos011221/velora/internal/stats"
+)
+
+const (
+	// sendAttempts is the number of attempts to deliver an email.
+	sendAttempts = 3
+	// initialBackoff is the delay before the first retry, doubled on every retry.
+	initialBackoff = 2 * time.Second
+)
+
+// Outcome is what a run notified.
+type Outcome struct {
+	Alerted  int
+	Resolved int
+	// Suppressed counts the findings not alerted per severity, they were
+	// alerted recently.
+	Suppressed map[findings.Severity]int
+}
+
+// EmailStats are the delivery counters of the email notifier.
+type EmailStats struct {
+	Sent    uint64
+	Failed  uint64
+	Retries uint64
+}
+
+// EmailNotifier sends findings by email, either per run or as a digest.
+type EmailNotifier struct {
+	cfg         config.EmailConfig
+	minSeverity findings.Severity
+	// location is the time zone the emails show times in.
+	location *time.Location
+
+	// throttle deduplicates the alerts of immediate mode, nil alerts every
+	// finding on every run.
+	throttle *Throttle
+
+	mu      sync.Mutex
+	pending []findings.Finding
+	since   time.Time
+
+	sent    atomic.Uint64
+	failed  atomic.Uint64
+	retries atomic.Uint64
+}
+
+// NewEmailNotifier creates a new email notifier instance, its emails show
+// times in location.
+func NewEmailNotifier(cfg config.EmailConfig, location *time.Location) *EmailNotifier {
+	minSeverity := findings.Severity(cfg.MinSeverity)
+	if minSeverity == "" {
+		minSeverity = findings.SeverityHigh
+	}
+
+	return &EmailNotifier{
+		cfg:         cfg,
+		minSeverity: minSeverity,
+		location:    location,
+		since:       time.Now(),
+	}
+}
+
+// SetThrottle deduplicates the alerts of immediate mode across runs.
+func (n *EmailNotifier) SetThrottle(throttle *Throttle) {
+	n.throttle = throttle
+}
+
+// NotifyRun handles the findings of a completed run. In immediate mode a
+// summary is sent when any finding reaches the severity threshold, in digest
+// mode the findings are kept until the next SendDigest. With a throttle,
+// immediate mode only alerts the findings not alerted recently and the
+// alerted findings that resolved, those of subscriptions the run evaluated.
+// Delivery errors are returned for logging only, they must never fail the run.
+func (n *EmailNotifier) NotifyRun(ctx context.Context, runFindings []findings.Finding, evaluated func(subscriptionID string) bool) (Outcome, error) {
+	relevant := n.filter(runFindings)
+
+	if n.cfg.Mode == "digest" {
+		n.mu.Lock()
+		n.pending = append(n.pending, relevant...)
+		n.mu.Unlock()
+		return Outcome{}, nil
+	}
+
+	if n.throttle == nil {
+		if len(relevant) == 0 {
+			return Outcome{}, nil
+		}
+		subject := fmt.Sprintf("velora: %d findings at or above %s", len(relevant), n.minSeverity)
+		return Outcome{Alerted: len(relevant)}, n.send(ctx, subject, summary{Title: "Velora run summary", Findings: relevant})
+	}
+
+	batch, err := n.throttle.Filter(relevant, evaluated, time.Now().UTC())
+	if err != nil {
+		return Outcome{}, err
+	}
+	outcome := Outcome{Alerted: len(batch.Alert), Resolved: len(batch.Resolved), Suppressed: batch.Suppressed}
+	if len(batch.Alert) > 0 || len(batch.Resolved) > 0 {
+		subject := fmt.Sprintf("velora: %d findings at or above %s", len(batch.Alert), n.minSeverity)
+		if len(batch.Resolved) > 0 {
+			subject += fmt.Sprintf(", %d resolved", len(batch.Resolved))
+		}
+		// the batch isn't committed, so the next run alerts it again
+		if err := n.send(ctx, subject, summary{Title: "Velora run summary", Findings: batch.Alert, Resolved: batch.Resolved}); err != nil {
+			return Outcome{Suppressed: batch.Suppressed}, err
+		}
+	}
+	return outcome, n.throttle.Commit(batch)
+}
+
+// SendDigest sends the findings aggregated since the last digest, with the
+// compliance trends and the usage of the hubs' capacity as of the last run.
+// It is called by the scheduler, pending findings are kept if delivery
+// fails.
+func (n *EmailNotifier) SendDigest(ctx context.Context, trends []stats.Trend, hubCapacity []limits.HubUsage) error {
+	n.mu.Lock()
+	pending := n.pending
+	since := n.since
+	n.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	subject := fmt.Sprintf("velora digest: %d findings since %s", len(pending), since.In(n.location).Format(time.RFC3339))
+	if err := n.send(ctx, subject, summary{Title: "Velora digest", Since: since, Findings: pending, Trends: trends, HubCapacity: hubCapacity}); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	n.pending = n.pending[len(pending):]
+	n.since = time.Now()
+	n.mu.Unlock()
+	return nil
+}
+
+// NotifyBreaches sends the open findings breaching the remediation SLO,
+// regardless of their severity and the notification mode.
+// Delivery errors are returned for logging only, they must never fail the run.
+func (n *EmailNotifier) NotifyBreaches(ctx context.Context, breaches []slo.Record) error {
+	if len(breaches) == 0 {
+		return nil
+	}
+
+	subject := fmt.Sprintf("velora: %d findings breaching the remediation SLO", len(breaches))
+	return n.send(ctx, subject, summary{Title: "Velora remediation SLO breaches", Breaches: breaches})
+}
+
+// SendTest sends a test message to verify the channel is configured correctly.
+func (n *EmailNotifier) SendTest(ctx context.Context) error {
+	return n.send(ctx, "velora: test message", summary{Title: "Velora test message, no action required"})
+}
+
+// Stats returns the delivery counters.
+func (n *EmailNotifier) Stats() EmailStats {
+	return EmailStats{
+		Sent:    n.sent.Load(),
+		Failed:  n.failed.Load(),
+		Retries: n.retries.Load(),
+	}
+}
+
+// filter returns the findings at or above the severity threshold.
+func (n *EmailNotifier) filter(all []findings.Finding) []findings.Finding {
+	var result []findings.Finding
+	for _, f := range all {
+		if f.Severity.AtLeast(n.minSeverity) {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// send renders the message and delivers it, retrying with backoff.
+func (n *EmailNotifier) send(ctx context.Context, subject string, s summary) error {
+	msg, err := n.buildMessage(subject, s)
+	if err != nil {
+		return err
+	}
+
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		err = n.deliver(msg)
+		if err == nil {
+			n.sent.Add(1)
+			return nil
+		}
+		if attempt == sendAttempts {
+			break
+		}
+
+		n.retries.Add(1)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			n.failed.Add(1)
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+
+	n.failed.Add(1)
+	return fmt.Errorf("failed to send email after %d attempts: %w", sendAttempts, err)
+}
+
+// buildMessage builds a multipart/alternative message with text and HTML bodies.
+func (n *EmailNotifier) buildMessage(subject string, s summary) ([]byte, error) {
+	s.Location = n.location
+	textBody, htmlBody, err := renderSummary(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", textBody},
+		{"text/html; charset=utf-8", htmlBody},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to build email body: %w", err)
+		}
+		if _, err := w.Write([]byte(part.content)); err != nil {
+			return nil, fmt.Errorf("failed to build email body: %w", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to build email body: %w", err)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
+
+// deliver sends the message over SMTP using the configured TLS mode.
+func (n *EmailNotifier) deliver(msg []byte) error {
+	addr := net.JoinHostPort(n.cfg.Host, strconv.Itoa(n.cfg.Port))
+	tlsConfig := &tls.Config{ServerName: n.cfg.Host}
+
+	var client *smtp.Client
+	if n.cfg.TLSMode == "tls" {
+		conn, err := tls.Dial("tcp", addr, tlsConfig)
+		if err != nil {
+			return fmt.Errorf("failed to connect to SMTP server: %w", err)
+		}
+		client, err = smtp.NewClient(conn, n.cfg.Host)
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to create SMTP client: %w", err)
+		}
+	} else {
+		var err error
+		client, err = smtp.Dial(addr)
+		if err != nil {
+			return fmt.Errorf("failed to connect to SMTP server: %w", err)
+		}
+	}
+	defer client.Close()
+
+	if n.cfg.TLSMode == "starttls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+
+	if n.cfg.Username != "" {
+		auth := smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, n.cfg.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(n.cfg.From); err != nil {
+		return fmt.Errorf("SMTP MAIL command failed: %w", err)
+	}
+	for _, to := range n.cfg.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("SMTP RCPT command failed for %s: %w", to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA command failed: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,112 @@
+package notifications
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"text/template"
+	"time"
+
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/limits"
+	"github.com/akos011221/velora/internal/redact"
+	"github.com/akos011221/velora/internal/slo"
+	"github.com/akos011221/velora/internal/stats"
+)
+
+// summary is the data rendered into notification bodies.
+type summary struct {
+	Title    string
+	Since    time.Time
+	Findings []findings.Finding
+	// Breaches are the open findings breaching the remediation SLO.
+	Breaches []slo.Record
+	// Trends are the compliance trends per subscription, digests only.
+	Trends []stats.Trend
+	// HubCapacity is the usage of the hubs with a design capacity, digests
+	// only.
+	HubCapacity []limits.HubUsage
+	// Resolved are the alerted findings no longer reported, throttled
+	// alerts only.
+	Resolved []Notified
+	// Location is the time zone times are shown in.
+	Location *time.Location
+}
+
+// Time formats a time for the body in the display location.
+func (s summary) Time(t time.Time) string {
+	return t.In(s.Location).Format("2006-01-02 15:04 MST")
+}
+
+// percent formats a fraction as a percentage.
+func percent(fraction float64) string {
+	return fmt.Sprintf("%.0f%%", 100*fraction)
+}
+
+var textSummary = template.Must(template.New("text").Funcs(template.FuncMap{"percent": percent}).Parse(`{{.Title}}
+{{if not .Since.IsZero}}Findings since {{$.Time .Since}}
+{{end}}
+{{range .Findings}}[{{.Severity}}] {{.RuleID}} {{.ResourceID}}
+  {{.Message}}
+{{if .SeverityReason}}  Severity {{.SeverityReason}}
+{{end}}{{if .Ownership}}  Ownership: {{.Ownership}}
+{{end}}{{if .Caller}}  Changed by: {{.Caller}}
+{{end}}{{if .Remediation}}  Remediation: {{.Remediation}}
+{{end}}{{if .DocsURL}}  Docs: {{.DocsURL}}
+{{end}}
+{{end}}{{range .Breaches}}[{{.Severity}}] {{.RuleID}} {{.ResourceID}}
+  Open since {{$.Time .FirstSeen}}, breaching the remediation SLO
+{{end}}{{if .Resolved}}
+Resolved
+{{range .Resolved}}[{{.Severity}}] {{.RuleID}} {{.ResourceID}}
+  Alerted {{$.Time .NotifiedAt}}, no longer reported
+{{end}}{{end}}{{if .Trends}}
+Compliance trend
+{{range .Trends}}  {{.SubscriptionID}}: {{.}}
+{{end}}{{end}}{{if .HubCapacity}}
+Hub capacity
+{{range .HubCapacity}}  {{.Hub}}: {{.}} ({{percent .Utilization}})
+{{end}}{{end}}`))
+
+var htmlSummary = htmltemplate.Must(htmltemplate.New("html").Funcs(htmltemplate.FuncMap{"percent": percent}).Parse(`<html><body>
+<h2>{{.Title}}</h2>
+{{if not .Since.IsZero}}<p>Findings since {{$.Time .Since}}</p>{{end}}
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Severity</th><th>Rule</th><th>Resource</th><th>Message</th><th>Remediation</th><th>Ownership</th><th>Changed by</th></tr>
+{{range .Findings}}<tr><td>{{.Severity}}{{if .SeverityReason}} ({{.SeverityReason}}){{end}}</td><td>{{.RuleID}}</td><td>{{.ResourceID}}</td><td>{{.Message}}</td><td>{{.Remediation}}{{if .DocsURL}} <a href="{{.DocsURL}}">docs</a>{{end}}</td><td>{{if .Ownership}}{{.Ownership}}{{end}}</td><td>{{if .Caller}}{{.Caller}}{{end}}</td></tr>
+{{end}}</table>
+{{if .Breaches}}<h3>Remediation SLO breaches</h3>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Severity</th><th>Rule</th><th>Resource</th><th>Open since</th></tr>
+{{range .Breaches}}<tr><td>{{.Severity}}</td><td>{{.RuleID}}</td><td>{{.ResourceID}}</td><td>{{$.Time .FirstSeen}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Resolved}}<h3>Resolved</h3>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Severity</th><th>Rule</th><th>Resource</th><th>Alerted</th></tr>
+{{range .Resolved}}<tr><td>{{.Severity}}</td><td>{{.RuleID}}</td><td>{{.ResourceID}}</td><td>{{$.Time .NotifiedAt}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Trends}}<h3>Compliance trend</h3>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Subscription</th><th>Compliance</th></tr>
+{{range .Trends}}<tr><td>{{.SubscriptionID}}</td><td>{{.}}</td></tr>
+{{end}}</table>{{end}}
+{{if .HubCapacity}}<h3>Hub capacity</h3>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Hub</th><th>Usage</th><th>Utilization</th></tr>
+{{range .HubCapacity}}<tr><td>{{.Hub}}</td><td>{{.}}</td><td>{{percent .Utilization}}</td></tr>
+{{end}}</table>{{end}}
+</body></html>
+`))
+
+// renderSummary renders the plain-text and HTML bodies of a summary.
+func renderSummary(s summary) (string, string, error) {
+	var text, html bytes.Buffer
+	if err := textSummary.Execute(&text, s); err != nil {
+		return "", "", fmt.Errorf("failed to render text summary: %w", err)
+	}
+	if err := htmlSummary.Execute(&html, s); err != nil {
+		return "", "", fmt.Errorf("failed to render HTML summary: %w", err)
+	}
+	// findings quote resource data, which must never carry a secret
+	return redact.String(text.String()), redact.String(html.String()), nil
+}
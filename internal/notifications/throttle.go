@@ -0,0 +1,196 @@
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// throttleStateKey is the state store key of the findings alerted.
+const throttleStateKey = "notification-throttle"
+
+// Notified is a finding alerted and still open.
+type Notified struct {
+	RuleID         string            `json:"ruleId"`
+	Severity       findings.Severity `json:"severity"`
+	SubscriptionID string            `json:"subscriptionId"`
+	ResourceID     string            `json:"resourceId"`
+	NotifiedAt     time.Time         `json:"notifiedAt"`
+	// Suppressed counts the runs that reported it since its alert.
+	Suppressed int `json:"suppressed"`
+}
+
+// throttleState is the persisted alerted findings, keyed by rule and resource.
+type throttleState struct {
+	Notified map[string]*Notified `json:"notified"`
+}
+
+// Batch is what a run alerts once throttled: the findings not alerted
+// recently and the alerted findings that resolved.
+type Batch struct {
+	Alert    []findings.Finding
+	Resolved []Notified
+	// Suppressed counts the findings alerted recently per severity.
+	Suppressed map[findings.Severity]int
+
+	state *throttleState
+}
+
+// Throttle deduplicates alerts across runs: a finding is alerted again once
+// the renotification interval of its severity has passed, or if its
+// severity rose.
+type Throttle struct {
+	store     state.Store
+	intervals map[findings.Severity]time.Duration
+	mu        sync.Mutex
+}
+
+// NewThrottle creates a new throttle persisting to the state store, with the
+// renotification intervals of the config.
+func NewThrottle(store state.Store, cfg *config.NotificationThrottleConfig) *Throttle {
+	intervals := make(map[findings.Severity]time.Duration)
+	if cfg != nil {
+		for severity, hours := range cfg.RenotifyHours {
+			intervals[findings.Severity(severity)] = time.Duration(hours * float64(time.Hour))
+		}
+	}
+	return &Throttle{store: store, intervals: intervals}
+}
+
+// Filter returns the findings to alert and the alerted findings no longer
+// reported. Findings of subscriptions the run didn't evaluate don't
+// resolve. Nothing is saved until the batch is committed, once delivered.
+func (t *Throttle) Filter(relevant []findings.Finding, evaluated func(subscriptionID string) bool, now time.Time) (*Batch, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, err := t.load()
+	if err != nil {
+		return nil, err
+	}
+	batch := &Batch{Suppressed: make(map[findings.Severity]int), state: st}
+
+	reported := make(map[string]bool, len(relevant))
+	for _, f := range relevant {
+		k := throttleKey(f.RuleID, f.ResourceID)
+		if reported[k] {
+			continue
+		}
+		reported[k] = true
+
+		if n, ok := st.Notified[k]; ok && !t.due(n, f.Severity, now) {
+			n.Suppressed++
+			batch.Suppressed[f.Severity]++
+			continue
+		}
+		batch.Alert = append(batch.Alert, f)
+		st.Notified[k] = &Notified{
+			RuleID:         f.RuleID,
+			Severity:       f.Severity,
+			SubscriptionID: f.SubscriptionID,
+			ResourceID:     f.ResourceID,
+			NotifiedAt:     now,
+		}
+	}
+
+	for k, n := range st.Notified {
+		if !reported[k] && evaluated(n.SubscriptionID) {
+			batch.Resolved = append(batch.Resolved, *n)
+			delete(st.Notified, k)
+		}
+	}
+	sort.Slice(batch.Resolved, func(i, j int) bool {
+		return batch.Resolved[i].NotifiedAt.Before(batch.Resolved[j].NotifiedAt)
+	})
+	return batch, nil
+}
+
+// Commit saves the alerts and resolutions of the delivered batch.
+func (t *Throttle) Commit(batch *Batch) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.save(batch.state)
+}
+
+// List returns the alerted findings still open, oldest alert first.
+func (t *Throttle) List() ([]Notified, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, err := t.load()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Notified, 0, len(st.Notified))
+	for _, n := range st.Notified {
+		result = append(result, *n)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].NotifiedAt.Before(result[j].NotifiedAt) })
+	return result, nil
+}
+
+// Flush forgets the alerted findings of the rule and the resource, every
+// finding if both are empty, so the next run alerts them again. Returns
+// the number of findings flushed.
+func (t *Throttle) Flush(ruleID, resourceID string) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, err := t.load()
+	if err != nil {
+		return 0, err
+	}
+	flushed := 0
+	for k, n := range st.Notified {
+		if (ruleID == "" || n.RuleID == ruleID) && (resourceID == "" || strings.EqualFold(n.ResourceID, resourceID)) {
+			delete(st.Notified, k)
+			flushed++
+		}
+	}
+	if flushed == 0 {
+		return 0, nil
+	}
+	return flushed, t.save(st)
+}
+
+// due reports whether the alerted finding is alerted again: its interval
+// passed, or it is reported with a higher severity.
+func (t *Throttle) due(n *Notified, severity findings.Severity, now time.Time) bool {
+	if severity != n.Severity && severity.AtLeast(n.Severity) {
+		return true
+	}
+	interval, ok := t.intervals[severity]
+	return ok && now.Sub(n.NotifiedAt) >= interval
+}
+
+// load reads the alerted findings from the state store.
+func (t *Throttle) load() (*throttleState, error) {
+	st := &throttleState{}
+	if err := t.store.Get(throttleStateKey, st); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load notified findings: %w", err)
+	}
+	if st.Notified == nil {
+		st.Notified = make(map[string]*Notified)
+	}
+	return st, nil
+}
+
+// save writes the alerted findings to the state store.
+func (t *Throttle) save(st *throttleState) error {
+	if err := t.store.Put(throttleStateKey, st); err != nil {
+		return fmt.Errorf("failed to save notified findings: %w", err)
+	}
+	return nil
+}
+
+// throttleKey identifies a finding by rule and resource.
+func throttleKey(ruleID, resourceID string) string {
+	return ruleID + "|" + strings.ToLower(resourceID)
+}
@@ -0,0 +1,264 @@
+package onboarding
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// stateKey is the state store key holding the onboarding records.
+const stateKey = "onboarding"
+
+// acknowledgedByHistory acknowledges the subscriptions velora ran against
+// before their first onboarding record.
+const acknowledgedByHistory = "existing run history"
+
+// acknowledgedByConfig acknowledges the subscriptions configured with
+// observeFirst false.
+const acknowledgedByConfig = "configuration, observeFirst is false"
+
+// Record is the onboarding state of a subscription. Records are kept when
+// the subscription leaves the configuration, so re-adding it keeps its
+// acknowledgment.
+type Record struct {
+	SubscriptionID string    `json:"subscriptionId"`
+	FirstSeen      time.Time `json:"firstSeen"`
+	// ObserveRuns counts the complete runs that observed the subscription
+	// while it was pending.
+	ObserveRuns int `json:"observeRuns"`
+	// StableRuns counts the consecutive observe runs with the same findings.
+	StableRuns int `json:"stableRuns"`
+	// Findings counts the findings of the last observe run by severity.
+	Findings    map[findings.Severity]int `json:"findings,omitempty"`
+	Fingerprint string                    `json:"fingerprint,omitempty"`
+	// AcknowledgedAt is nil while the subscription is pending.
+	AcknowledgedAt *time.Time `json:"acknowledgedAt,omitempty"`
+	AcknowledgedBy string     `json:"acknowledgedBy,omitempty"`
+}
+
+// Pending reports whether remediation waits for the acknowledgment.
+func (r *Record) Pending() bool {
+	return r.AcknowledgedAt == nil
+}
+
+// FindingCount returns the number of findings of the last observe run.
+func (r *Record) FindingCount() int {
+	n := 0
+	for _, count := range r.Findings {
+		n += count
+	}
+	return n
+}
+
+// Finding returns the finding reporting the pending subscription.
+func (r *Record) Finding(rules map[string]config.RuleConfig) findings.Finding {
+	return findings.New(findings.RulePendingAcknowledgment, rules, r.SubscriptionID, "/subscriptions/"+r.SubscriptionID,
+		fmt.Sprintf("subscription %s is new and only observed until its findings are acknowledged, %d findings after %d observe runs",
+			r.SubscriptionID, r.FindingCount(), r.ObserveRuns),
+		map[string]string{
+			"subscription": r.SubscriptionID,
+			"findings":     strconv.Itoa(r.FindingCount()),
+			"runs":         strconv.Itoa(r.ObserveRuns),
+		})
+}
+
+// Manager manages the onboarding records persisted in the state store.
+type Manager struct {
+	store state.Store
+	mu    sync.Mutex
+}
+
+// NewManager creates a new onboarding manager.
+func NewManager(store state.Store) *Manager {
+	return &Manager{store: store}
+}
+
+// Begin returns the pending records of the subscriptions, creating the
+// records of subscriptions seen for the first time. Those velora ran
+// against before, per ranBefore, and those configured not to be observed,
+// per unobserved, are acknowledged; the others are pending.
+func (m *Manager) Begin(subscriptionIDs []string, ranBefore, unobserved map[string]bool, now time.Time) ([]*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	var pending []*Record
+	changed := false
+	for _, subID := range subscriptionIDs {
+		r, ok := records[subID]
+		if !ok {
+			r = &Record{SubscriptionID: subID, FirstSeen: now}
+			switch {
+			case ranBefore[subID]:
+				r.AcknowledgedAt = &now
+				r.AcknowledgedBy = acknowledgedByHistory
+			case unobserved[subID]:
+				r.AcknowledgedAt = &now
+				r.AcknowledgedBy = acknowledgedByConfig
+			}
+			records[subID] = r
+			changed = true
+		}
+		if r.Pending() {
+			pending = append(pending, r)
+		}
+	}
+	if changed {
+		if err := m.save(records); err != nil {
+			return nil, err
+		}
+	}
+	return pending, nil
+}
+
+// Observe counts a complete run for the pending subscriptions it evaluated
+// and returns the subscriptions still pending. A subscription whose findings
+// stayed the same for the consecutive runs autoAcknowledgeAfterRuns returns
+// for it is acknowledged, 0 never acknowledges automatically.
+func (m *Manager) Observe(subscriptionIDs []string, all []findings.Finding, evaluated func(subscriptionID string) bool,
+	autoAcknowledgeAfterRuns func(subscriptionID string) int, now time.Time) ([]*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	bySubscription := make(map[string][]findings.Finding)
+	for _, f := range all {
+		if f.RuleID != findings.RulePendingAcknowledgment.ID {
+			bySubscription[f.SubscriptionID] = append(bySubscription[f.SubscriptionID], f)
+		}
+	}
+
+	var pending []*Record
+	for _, subID := range subscriptionIDs {
+		r, ok := records[subID]
+		if !ok || !r.Pending() {
+			continue
+		}
+		if !evaluated(subID) {
+			pending = append(pending, r)
+			continue
+		}
+		fingerprint := fingerprint(bySubscription[subID])
+		if r.ObserveRuns > 0 && fingerprint == r.Fingerprint {
+			r.StableRuns++
+		} else {
+			r.StableRuns = 1
+		}
+		r.ObserveRuns++
+		r.Fingerprint = fingerprint
+		r.Findings = make(map[findings.Severity]int)
+		for _, f := range bySubscription[subID] {
+			r.Findings[f.Severity]++
+		}
+
+		if after := autoAcknowledgeAfterRuns(subID); after > 0 && r.StableRuns >= after {
+			r.AcknowledgedAt = &now
+			r.AcknowledgedBy = fmt.Sprintf("velora after %d stable runs", r.StableRuns)
+			fmt.Printf("subscription %s acknowledged by %s, remediation starts with the next run\n", subID, r.AcknowledgedBy)
+			continue
+		}
+		pending = append(pending, r)
+	}
+	if err := m.save(records); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// Acknowledge starts remediation of the subscription. A subscription not
+// seen yet is acknowledged ahead of its first run.
+func (m *Manager) Acknowledge(subscriptionID, by string) (*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	r, ok := records[subscriptionID]
+	if !ok {
+		r = &Record{SubscriptionID: subscriptionID, FirstSeen: time.Now().UTC()}
+		records[subscriptionID] = r
+	}
+	if !r.Pending() {
+		return nil, fmt.Errorf("subscription %s was already acknowledged by %s at %s", subscriptionID, r.AcknowledgedBy,
+			r.AcknowledgedAt.UTC().Format(time.RFC3339))
+	}
+	now := time.Now().UTC()
+	r.AcknowledgedAt = &now
+	r.AcknowledgedBy = by
+	if err := m.save(records); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// List returns the records, ordered by subscription.
+func (m *Manager) List() ([]*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Record, 0, len(records))
+	for _, subID := range sortedKeys(records) {
+		result = append(result, records[subID])
+	}
+	return result, nil
+}
+
+// load reads the records by subscription.
+func (m *Manager) load() (map[string]*Record, error) {
+	records := make(map[string]*Record)
+	if err := m.store.Get(stateKey, &records); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load onboarding state: %w", err)
+	}
+	return records, nil
+}
+
+// save writes the records.
+func (m *Manager) save(records map[string]*Record) error {
+	if err := m.store.Put(stateKey, records); err != nil {
+		return fmt.Errorf("failed to save onboarding state: %w", err)
+	}
+	return nil
+}
+
+// fingerprint identifies a set of findings by rule and resource.
+func fingerprint(all []findings.Finding) string {
+	keys := make([]string, 0, len(all))
+	for _, f := range all {
+		keys = append(keys, f.RuleID+"|"+strings.ToLower(f.ResourceID))
+	}
+	sort.Strings(keys)
+	sum := sha256.Sum256([]byte(strings.Join(keys, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// sortedKeys returns the keys of the map in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,101 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akos011221/velora/internal/azure"
+)
+
+// Status is the outcome of applying a change.
+type Status string
+
+const (
+	StatusApplied Status = "applied"
+	StatusStale   Status = "stale"
+	StatusSkipped Status = "skipped"
+	StatusFailed  Status = "failed"
+	// StatusBlocked is a change an Azure Policy assignment denied.
+	StatusBlocked Status = "blocked"
+)
+
+// Result is the outcome of applying one change.
+type Result struct {
+	Change Change `json:"change"`
+	Status Status `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Denial is the Azure Policy assignment that blocked the change.
+	Denial *azure.PolicyDenial `json:"denial,omitempty"`
+}
+
+// WriteGuard decides whether writes to a subscription are allowed.
+type WriteGuard interface {
+	WritesAllowed(subscriptionID string) bool
+}
+
+// Apply applies the changes in order. A change is only applied if its
+// resource is unchanged since the plan, otherwise it is reported stale.
+func Apply(ctx context.Context, p *Plan, clientFactory *azure.ClientFactory, guard WriteGuard) []Result {
+	results := make([]Result, 0, len(p.Changes))
+	for _, change := range p.Changes {
+		results = append(results, applyChange(ctx, change, clientFactory, guard))
+	}
+	return results
+}
+
+// applyChange re-checks the etag of the resource and writes the change.
+func applyChange(ctx context.Context, change Change, clientFactory *azure.ClientFactory, guard WriteGuard) Result {
+	result := Result{Change: change}
+	subFactory := clientFactory.ForSubscription(change.SubscriptionID)
+
+	etag, exists, err := subFactory.GetResourceEtag(ctx, change.ResourceID, change.APIVersion)
+	if err != nil {
+		result.Status, result.Detail = StatusFailed, err.Error()
+		return result
+	}
+	if etag != change.Etag || (change.Etag == "" && exists) {
+		result.Status, result.Detail = StatusStale, "resource changed since the plan, re-plan required"
+		return result
+	}
+
+	if !guard.WritesAllowed(change.SubscriptionID) {
+		result.Status, result.Detail = StatusSkipped, "writes not allowed"
+		return result
+	}
+
+	// the etag is sent with the write too, a change between the check and
+	// the write is rejected by ARM
+	err = Write(ctx, clientFactory, change)
+	if denial, ok := azure.AsPolicyDenial(err); ok {
+		result.Status, result.Detail, result.Denial = StatusBlocked, "blocked by Azure Policy "+denial.Name(), denial
+		return result
+	}
+	if err != nil {
+		result.Status, result.Detail = StatusFailed, err.Error()
+		return result
+	}
+
+	result.Status = StatusApplied
+	return result
+}
+
+// Write writes the change with its etag, ARM rejects it if the resource
+// changed since the etag was read. Controllers write their changes with it
+// when they aren't planning.
+func Write(ctx context.Context, clientFactory *azure.ClientFactory, change Change) error {
+	subFactory := clientFactory.ForSubscription(change.SubscriptionID)
+	if change.Delete {
+		return subFactory.DeleteResource(ctx, change.ResourceID, change.APIVersion, change.Etag)
+	}
+	return subFactory.PutResource(ctx, change.ResourceID, change.APIVersion, change.Query, change.Body, change.Etag)
+}
+
+// Summary counts the results per status.
+func Summary(results []Result) string {
+	counts := make(map[Status]int)
+	for _, r := range results {
+		counts[r.Status]++
+	}
+	return fmt.Sprintf("%d applied, %d stale, %d skipped, %d blocked, %d failed",
+		counts[StatusApplied], counts[StatusStale], counts[StatusSkipped], counts[StatusBlocked], counts[StatusFailed])
+}
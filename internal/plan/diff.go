@@ -0,0 +1,244 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Diff limits, a change request can't hold an arbitrarily long diff.
+const (
+	// DefaultDiffMaxChanges is the number of changes a diff shows.
+	DefaultDiffMaxChanges = 200
+	// DefaultDiffMaxLines is the number of property lines a change shows.
+	DefaultDiffMaxLines = 40
+)
+
+// Kinds of changes in a diff.
+const (
+	kindCreate = "create"
+	kindUpdate = "update"
+	kindDelete = "delete"
+)
+
+// DiffOptions are the limits of a diff, zero values use the defaults.
+type DiffOptions struct {
+	MaxChanges int
+	MaxLines   int
+}
+
+// kind returns whether the change creates, updates or deletes its resource.
+func (c Change) kind() string {
+	switch {
+	case c.Delete:
+		return kindDelete
+	case c.Etag == "" && len(c.Before) == 0:
+		return kindCreate
+	default:
+		return kindUpdate
+	}
+}
+
+// WriteDiff writes the plan as a human-readable diff, for change requests:
+// a summary, then the changes grouped by subscription and resource, each
+// with its properties before and after as - and + lines. Properties that
+// don't change are counted, not shown. The output only depends on the plan.
+func (p *Plan) WriteDiff(w io.Writer, opts DiffOptions) error {
+	maxChanges, maxLines := opts.MaxChanges, opts.MaxLines
+	if maxChanges <= 0 {
+		maxChanges = DefaultDiffMaxChanges
+	}
+	if maxLines <= 0 {
+		maxLines = DefaultDiffMaxLines
+	}
+
+	changes := append([]Change(nil), p.Changes...)
+	sort.SliceStable(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.SubscriptionID != b.SubscriptionID {
+			return a.SubscriptionID < b.SubscriptionID
+		}
+		return strings.ToLower(a.ResourceID) < strings.ToLower(b.ResourceID)
+	})
+
+	counts := make(map[string]int)
+	subscriptions := make(map[string]bool)
+	for _, c := range changes {
+		counts[c.kind()]++
+		subscriptions[c.SubscriptionID] = true
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "velora plan created %s by velora %s, config %s\n",
+		p.CreatedAt.UTC().Format(time.RFC3339), p.VeloraVersion, p.ConfigHash)
+	fmt.Fprintf(&b, "%d changes in %d subscriptions: %d to create, %d to update, %d to delete\n",
+		len(changes), len(subscriptions), counts[kindCreate], counts[kindUpdate], counts[kindDelete])
+
+	subscriptionID := ""
+	for i, c := range changes {
+		if i == maxChanges {
+			fmt.Fprintf(&b, "\n... %d more changes not shown, see the plan file\n", len(changes)-maxChanges)
+			break
+		}
+		if i == 0 || c.SubscriptionID != subscriptionID {
+			subscriptionID = c.SubscriptionID
+			fmt.Fprintf(&b, "\n=== subscription %s\n", subscriptionID)
+		}
+		if err := writeChangeDiff(&b, c, maxLines); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeChangeDiff writes the diff of a single change.
+func writeChangeDiff(b *strings.Builder, c Change, maxLines int) error {
+	before, err := flatten(c.Before)
+	if err != nil {
+		return fmt.Errorf("failed to read the state before change %q: %w", c.Description, err)
+	}
+	after := map[string]string{}
+	if !c.Delete {
+		if after, err = flatten(c.Body); err != nil {
+			return fmt.Errorf("failed to read change %q: %w", c.Description, err)
+		}
+	}
+
+	kind := c.kind()
+	fmt.Fprintf(b, "\n--- %s (%s)\n", c.ResourceID, kind)
+	fmt.Fprintf(b, "@@ %s @@\n", c.Description)
+	if c.Query != "" {
+		fmt.Fprintf(b, "   query: %s\n", c.Query)
+	}
+
+	paths := make([]string, 0, len(before)+len(after))
+	for path := range before {
+		paths = append(paths, path)
+	}
+	for path := range after {
+		if _, ok := before[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var lines []string
+	unchanged := 0
+	for _, path := range paths {
+		old, hadOld := before[path]
+		value, hasNew := after[path]
+		switch {
+		case hadOld && hasNew && old == value:
+			unchanged++
+		case kind == kindUpdate && !hasNew && !writtenUnder(after, path):
+			// an update only writes the properties of its body
+			unchanged++
+		default:
+			if hadOld {
+				lines = append(lines, fmt.Sprintf("-  %s: %s", path, old))
+			}
+			if hasNew {
+				lines = append(lines, fmt.Sprintf("+  %s: %s", path, value))
+			}
+		}
+	}
+
+	switch {
+	case kind == kindDelete && len(before) == 0:
+		lines = append(lines, "-  (the whole resource)")
+	case kind == kindUpdate && len(before) == 0:
+		lines = []string{"   (state before the change not recorded, showing the properties written)"}
+		for _, path := range paths {
+			lines = append(lines, fmt.Sprintf("+  %s: %s", path, after[path]))
+		}
+		unchanged = 0
+	case len(lines) == 0:
+		lines = append(lines, "   (no property changes)")
+	}
+
+	for i, line := range lines {
+		if i == maxLines {
+			fmt.Fprintf(b, "   ... %d more lines\n", len(lines)-maxLines)
+			break
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	if unchanged > 0 {
+		fmt.Fprintf(b, "   (%d unchanged properties)\n", unchanged)
+	}
+	return nil
+}
+
+// writtenUnder reports whether the properties hold a property nested in
+// the path or one the path is nested in, like an array written in place
+// of an empty one, or an empty array in place of its elements.
+func writtenUnder(properties map[string]string, path string) bool {
+	for p := range properties {
+		if strings.HasPrefix(p, path+".") || strings.HasPrefix(p, path+"[") ||
+			strings.HasPrefix(path, p+".") || strings.HasPrefix(path, p+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// volatileProperties change on every read, they'd only be noise in a diff.
+var volatileProperties = map[string]bool{
+	"etag":                         true,
+	"properties.provisioningState": true,
+	"properties.resourceGuid":      true,
+}
+
+// flatten turns a JSON resource into dotted property paths and their JSON
+// values, e.g. properties.nextHopType: "VirtualAppliance". Array elements
+// are indexed, properties.routes[0].name.
+func flatten(data json.RawMessage) (map[string]string, error) {
+	flat := map[string]string{}
+	if len(data) == 0 {
+		return flat, nil
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	flattenValue(flat, "", v)
+	for path := range flat {
+		if volatileProperties[path] {
+			delete(flat, path)
+		}
+	}
+	return flat, nil
+}
+
+// flattenValue adds the value at the path to flat.
+func flattenValue(flat map[string]string, path string, v any) {
+	switch value := v.(type) {
+	case map[string]any:
+		if len(value) == 0 && path != "" {
+			flat[path] = "{}"
+		}
+		for key, item := range value {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			flattenValue(flat, child, item)
+		}
+	case []any:
+		if len(value) == 0 {
+			flat[path] = "[]"
+		}
+		for i, item := range value {
+			flattenValue(flat, fmt.Sprintf("%s[%d]", path, i), item)
+		}
+	default:
+		encoded, _ := json.Marshal(value)
+		flat[path] = string(encoded)
+	}
+}
@@ -0,0 +1,186 @@
+package plan
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+const (
+	spokeRG = "/subscriptions/00000000-0000-0000-0000-000000000002/resourceGroups/spoke-rg/providers/Microsoft.Network"
+	hubRG   = "/subscriptions/00000000-0000-0000-0000-000000000001/resourceGroups/hub-rg/providers/Microsoft.Network"
+)
+
+// diffPlan returns a plan creating, updating and deleting each type of
+// resource velora manages, in no particular order.
+func diffPlan() *Plan {
+	return &Plan{
+		Version:       FormatVersion,
+		CreatedAt:     time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC),
+		VeloraVersion: "1.4.0",
+		ConfigHash:    "3f2a9c",
+		Changes: []Change{
+			{
+				SubscriptionID: "00000000-0000-0000-0000-000000000002",
+				ResourceID:     spokeRG + "/routeTables/spoke-rt/routes/DefaultRoute-To-NVA",
+				Body:           []byte(`{"properties":{"addressPrefix":"0.0.0.0/0","nextHopType":"VirtualAppliance","nextHopIpAddress":"10.0.0.4"}}`),
+				Description:    "create route DefaultRoute-To-NVA in route table spoke-rt",
+			},
+			{
+				SubscriptionID: "00000000-0000-0000-0000-000000000002",
+				ResourceID:     spokeRG + "/routeTables/app-rt/routes/DefaultRoute-To-NVA",
+				Etag:           `W/"1"`,
+				Body:           []byte(`{"properties":{"addressPrefix":"0.0.0.0/0","nextHopType":"VirtualAppliance","nextHopIpAddress":"10.0.0.4"}}`),
+				Before:         []byte(`{"etag":"W/\"1\"","properties":{"provisioningState":"Succeeded","addressPrefix":"0.0.0.0/0","nextHopType":"VirtualAppliance","nextHopIpAddress":"10.0.0.5"}}`),
+				Description:    "update route DefaultRoute-To-NVA in route table app-rt",
+			},
+			{
+				SubscriptionID: "00000000-0000-0000-0000-000000000002",
+				ResourceID:     spokeRG + "/routeTables/app-rt/routes/To-Internet",
+				Etag:           `W/"2"`,
+				Delete:         true,
+				Before:         []byte(`{"properties":{"addressPrefix":"0.0.0.0/0","nextHopType":"Internet"}}`),
+				Description:    "delete forbidden route To-Internet in route table app-rt",
+			},
+			{
+				SubscriptionID: "00000000-0000-0000-0000-000000000002",
+				ResourceID:     spokeRG + "/virtualNetworks/spoke/virtualNetworkPeerings/spoke-to-hub-vnet",
+				Body:           []byte(`{"properties":{"remoteVirtualNetwork":{"id":"` + hubRG + `/virtualNetworks/hub-vnet"},"allowVirtualNetworkAccess":true,"allowForwardedTraffic":true,"useRemoteGateways":false}}`),
+				Description:    "create peering spoke-to-hub-vnet to " + hubRG + "/virtualNetworks/hub-vnet",
+			},
+			{
+				SubscriptionID: "00000000-0000-0000-0000-000000000001",
+				ResourceID:     hubRG + "/virtualNetworks/hub-vnet/virtualNetworkPeerings/hub-vnet-to-spoke",
+				Etag:           `W/"3"`,
+				Query:          "syncRemoteAddressSpace=true",
+				Body:           []byte(`{"properties":{"remoteVirtualNetwork":{"id":"` + spokeRG + `/virtualNetworks/spoke"},"allowVirtualNetworkAccess":true,"allowForwardedTraffic":true}}`),
+				Before:         []byte(`{"properties":{"remoteVirtualNetwork":{"id":"` + spokeRG + `/virtualNetworks/spoke"},"allowVirtualNetworkAccess":true,"allowForwardedTraffic":true,"peeringSyncLevel":"LocalNotInSync"}}`),
+				Description:    "sync peering hub-vnet-to-spoke with the address space of VNet spoke",
+			},
+			{
+				SubscriptionID: "00000000-0000-0000-0000-000000000002",
+				ResourceID:     spokeRG + "/virtualNetworks/old/virtualNetworkPeerings/legacy-peering",
+				Etag:           `W/"4"`,
+				Delete:         true,
+				Description:    "delete Disconnected peering legacy-peering",
+			},
+			{
+				SubscriptionID: "00000000-0000-0000-0000-000000000002",
+				ResourceID:     spokeRG + "/virtualNetworks/spoke/subnets/app",
+				Etag:           `W/"5"`,
+				Body:           []byte(`{"properties":{"addressPrefix":"10.1.0.0/24","networkSecurityGroup":{"id":"` + hubRG + `/networkSecurityGroups/baseline"},"routeTable":{"id":"` + spokeRG + `/routeTables/spoke-rt"}}}`),
+				Before:         []byte(`{"properties":{"addressPrefix":"10.1.0.0/24","routeTable":{"id":"` + spokeRG + `/routeTables/spoke-rt"}}}`),
+				Description:    "associate NSG baseline with subnet app",
+			},
+			{
+				SubscriptionID: "00000000-0000-0000-0000-000000000002",
+				ResourceID:     spokeRG + "/networkSecurityGroups/app-nsg/securityRules/velora-deny-intra-vnet",
+				Body:           []byte(`{"properties":{"priority":4000,"direction":"Inbound","access":"Deny","protocol":"*","sourceAddressPrefixes":["10.1.1.0/24"],"destinationAddressPrefix":"10.1.0.0/24","sourcePortRange":"*","destinationPortRange":"*"}}`),
+				Description:    "create security rule velora-deny-intra-vnet in NSG app-nsg",
+			},
+			{
+				SubscriptionID: "00000000-0000-0000-0000-000000000001",
+				ResourceID:     hubRG + "/virtualHubs/weu-hub/hubRouteTables/defaultRouteTable",
+				Etag:           `W/"6"`,
+				Body:           []byte(`{"properties":{"routes":[{"name":"default","destinationType":"CIDR","destinations":["0.0.0.0/0"],"nextHopType":"ResourceId","nextHop":"` + hubRG + `/azureFirewalls/fw"}],"labels":["default"]}}`),
+				Before:         []byte(`{"properties":{"routes":[],"labels":["default"]}}`),
+				Description:    "route the default route table of virtual hub weu-hub to the firewall",
+			},
+			{
+				SubscriptionID: "00000000-0000-0000-0000-000000000001",
+				ResourceID:     hubRG + "/virtualHubs/neu-hub/hubRouteTables/defaultRouteTable",
+				Etag:           `W/"8"`,
+				Body:           []byte(`{"properties":{"routes":[],"labels":["default"]}}`),
+				Before:         []byte(`{"properties":{"routes":[{"name":"legacy","destinationType":"CIDR","destinations":["10.0.0.0/8"],"nextHopType":"ResourceId","nextHop":"` + hubRG + `/azureFirewalls/old-fw"}],"labels":["default"]}}`),
+				Description:    "remove the routes of the default route table of virtual hub neu-hub",
+			},
+			{
+				SubscriptionID: "00000000-0000-0000-0000-000000000001",
+				ResourceID:     hubRG + "/networkWatchers/NetworkWatcher_westeurope/flowLogs/spoke-flowlog",
+				Body:           []byte(`{"location":"westeurope","properties":{"targetResourceId":"` + spokeRG + `/virtualNetworks/spoke","storageId":"` + hubRG + `/storageAccounts/flowlogs","enabled":true,"retentionPolicy":{"days":30,"enabled":true}}}`),
+				Description:    "create flow log spoke-flowlog of VNet spoke",
+			},
+			{
+				SubscriptionID: "00000000-0000-0000-0000-000000000001",
+				ResourceID:     hubRG + "/networkWatchers/NetworkWatcher_westeurope/flowLogs/app-flowlog",
+				Etag:           `W/"7"`,
+				Body:           []byte(`{"location":"westeurope","properties":{"targetResourceId":"` + spokeRG + `/virtualNetworks/app","storageId":"` + hubRG + `/storageAccounts/flowlogs","enabled":true,"retentionPolicy":{"days":30,"enabled":true}}}`),
+				Description:    "enable flow log app-flowlog of VNet app",
+			},
+		},
+	}
+}
+
+// checkGolden compares the output with the golden file of testdata.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	golden := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("diff differs from %s, run go test -update to accept it:\n%s", golden, got)
+	}
+}
+
+func TestWriteDiffGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		opts DiffOptions
+	}{
+		{name: "diff.golden"},
+		{name: "diff-truncated.golden", opts: DiffOptions{MaxChanges: 4, MaxLines: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := diffPlan().WriteDiff(&out, tt.opts); err != nil {
+				t.Fatalf("WriteDiff() error = %v", err)
+			}
+			checkGolden(t, tt.name, out.Bytes())
+		})
+	}
+}
+
+// TestWriteDiffOrder checks that the diff only depends on the changes, not
+// on their order in the plan.
+func TestWriteDiffOrder(t *testing.T) {
+	var want bytes.Buffer
+	if err := diffPlan().WriteDiff(&want, DiffOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	reversed := diffPlan()
+	for i, j := 0, len(reversed.Changes)-1; i < j; i, j = i+1, j-1 {
+		reversed.Changes[i], reversed.Changes[j] = reversed.Changes[j], reversed.Changes[i]
+	}
+	var got bytes.Buffer
+	if err := reversed.WriteDiff(&got, DiffOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("diff of the reversed plan:\n%s\nwant:\n%s", got.String(), want.String())
+	}
+}
+
+func TestWriteDiffInvalidBefore(t *testing.T) {
+	p := diffPlan()
+	p.Changes[1].Before = []byte(`{"properties":`)
+	err := p.WriteDiff(&bytes.Buffer{}, DiffOptions{})
+	if err == nil || !strings.Contains(err.Error(), "failed to read the state before change") {
+		t.Errorf("WriteDiff() error = %v, want the state before unreadable", err)
+	}
+}
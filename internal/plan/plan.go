@@ -0,0 +1,154 @@
+package plan
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/version"
+)
+
+// FormatVersion is the version of the plan file format. Plans of another
+// version are rejected. Version 2 added deletes, which older builds would
+// apply as writes. Version 3 added the state of the resources before the
+// changes, which older builds would reject as modified.
+const FormatVersion = 3
+
+// Change is a single write computed during the plan phase.
+type Change struct {
+	SubscriptionID string `json:"subscriptionId"`
+	ResourceID     string `json:"resourceId"`
+	APIVersion     string `json:"apiVersion"`
+	// Query holds extra query parameters of the PUT, URL-encoded.
+	Query string `json:"query,omitempty"`
+	// Etag is the etag of the resource at plan time, empty if it didn't exist.
+	Etag        string          `json:"etag,omitempty"`
+	Body        json.RawMessage `json:"body"`
+	Description string          `json:"description"`
+	// Delete deletes the resource instead of writing Body.
+	Delete bool `json:"delete,omitempty"`
+	// Before is the resource as read at plan time, empty if it didn't
+	// exist. It is only shown to reviewers, Etag guards the write.
+	Before json.RawMessage `json:"before,omitempty"`
+}
+
+// Plan is a reviewed set of changes applied in a later phase.
+type Plan struct {
+	Version       int       `json:"version"`
+	CreatedAt     time.Time `json:"createdAt"`
+	VeloraVersion string    `json:"veloraVersion"`
+	ConfigHash    string    `json:"configHash"`
+	Changes       []Change  `json:"changes"`
+	// Signature is the HMAC-SHA256 of the plan without the signature.
+	Signature string `json:"signature"`
+}
+
+// New creates an empty plan for the configuration.
+func New(cfg *config.Config) *Plan {
+	return &Plan{
+		Version:       FormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		VeloraVersion: version.Version,
+		ConfigHash:    cfg.Hash(),
+		Changes:       []Change{},
+	}
+}
+
+// Sign signs the plan with the key.
+func (p *Plan) Sign(key string) error {
+	sig, err := p.signature(key)
+	if err != nil {
+		return err
+	}
+	p.Signature = sig
+	return nil
+}
+
+// Verify checks the plan was signed with the key and not modified since.
+func (p *Plan) Verify(key string) error {
+	if p.Version != FormatVersion {
+		return fmt.Errorf("unsupported plan version %d, this build supports version %d", p.Version, FormatVersion)
+	}
+	sig, err := p.signature(key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(sig), []byte(p.Signature)) {
+		return fmt.Errorf("invalid plan signature, the plan was modified or signed with another key")
+	}
+	return nil
+}
+
+// signature computes the signature of the plan, ignoring the current one.
+func (p *Plan) signature(key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("plans.signingKey is required to sign and verify plans")
+	}
+
+	unsigned := *p
+	unsigned.Signature = ""
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode plan: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Write signs the plan and writes it to path.
+func (p *Plan) Write(path, key string) error {
+	if err := p.Sign(key); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	return nil
+}
+
+// Read reads the plan at path and verifies its signature.
+func Read(path, key string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %w", err)
+	}
+
+	var p Plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+	if err := p.Verify(key); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Recorder collects the changes of a plan run, it is safe for concurrent use.
+type Recorder struct {
+	mu   sync.Mutex
+	plan *Plan
+}
+
+// NewRecorder creates a new recorder adding changes to the plan.
+func NewRecorder(p *Plan) *Recorder {
+	return &Recorder{plan: p}
+}
+
+// Add adds a change to the plan.
+func (r *Recorder) Add(change Change) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.plan.Changes = append(r.plan.Changes, change)
+}
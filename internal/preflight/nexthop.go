@@ -0,0 +1,265 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// fallbackStateKey is the state store key holding the hubs using a
+// fallback next hop.
+const fallbackStateKey = "next-hop-fallbacks"
+
+// fallbackState is the fallback next hop a hub uses.
+type fallbackState struct {
+	NextHop string `json:"nextHop"`
+	// HealthyChecks counts the runs in a row the primary was owned again.
+	HealthyChecks int `json:"healthyChecks"`
+}
+
+// HubResult is the preflight result of one hub.
+type HubResult struct {
+	Name    string `json:"name"`
+	NextHop string `json:"nextHop,omitempty"`
+	// Source is where the next hop was read from: static or the firewall ID.
+	Source string `json:"source"`
+	// NextHopOwner is the NIC or load balancer owning a static next hop.
+	NextHopOwner string `json:"nextHopOwner,omitempty"`
+	Error        string `json:"error,omitempty"`
+	// Degraded says why a fallback next hop is enforced instead of the
+	// hub's, empty if it isn't.
+	Degraded string `json:"degraded,omitempty"`
+
+	// healthyFallbacks are the fallback next hops a forwarding NVA owns, in
+	// order, with their owners.
+	healthyFallbacks []string
+	fallbackOwners   []string
+}
+
+// ResolveNextHop returns the next hop of the hub, reading it from the
+// configured source.
+func ResolveNextHop(ctx context.Context, clientFactory *azure.ClientFactory, hub config.HubVNetConfig) (string, error) {
+	if hub.NextHopSource == nil {
+		return hub.NVANextHop, nil
+	}
+
+	firewallID := hub.NextHopSource.AzureFirewallID
+	parts := azure.ExtractResourceIDParts(firewallID)
+	firewallsClient, err := clientFactory.ForSubscription(parts["subscriptions"]).NewAzureFirewallsClient(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	firewall, err := firewallsClient.Get(ctx, parts["resourceGroups"], parts["azureFirewalls"], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get firewall %s: %w", firewallID, err)
+	}
+	if firewall.Properties == nil {
+		return "", fmt.Errorf("firewall %s has no properties", firewallID)
+	}
+
+	for _, ipConfig := range firewall.Properties.IPConfigurations {
+		if ipConfig != nil && ipConfig.Properties != nil && ipConfig.Properties.PrivateIPAddress != nil {
+			return *ipConfig.Properties.PrivateIPAddress, nil
+		}
+	}
+	// firewalls deployed in a virtual WAN hub have no IP configurations
+	if hubIPs := firewall.Properties.HubIPAddresses; hubIPs != nil && hubIPs.PrivateIPAddress != nil {
+		return *hubIPs.PrivateIPAddress, nil
+	}
+	return "", fmt.Errorf("firewall %s has no private IP", firewallID)
+}
+
+// NextHops returns the resolved next hops of the hubs that resolved.
+func (r *Report) NextHops() map[string]string {
+	nextHops := make(map[string]string)
+	for _, hub := range r.Hubs {
+		if hub.Error == "" {
+			nextHops[hub.Name] = hub.NextHop
+		}
+	}
+	return nextHops
+}
+
+// resolveHubs resolves the next hops of all hubs.
+func resolveHubs(ctx context.Context, cfg *config.Config, clientFactory *azure.ClientFactory) []HubResult {
+	var results []HubResult
+	for _, hub := range cfg.Hubs {
+		result := HubResult{Name: hub.Name, Source: "static"}
+		if hub.NextHopSource != nil {
+			result.Source = hub.NextHopSource.AzureFirewallID
+		}
+		// virtual WAN hubs route to a resource ID, there is no IP to resolve
+		if hub.IsVirtualWAN() {
+			result.Source = config.HubTypeVirtualWAN
+			if err := checkVirtualHub(ctx, clientFactory, hub); err != nil {
+				result.Error = err.Error()
+			} else {
+				result.NextHop = hub.VirtualWAN.NextHopID
+			}
+			results = append(results, result)
+			continue
+		}
+
+		nextHop, err := ResolveNextHop(ctx, clientFactory, hub)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.NextHop = nextHop
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// verifyNextHops checks that a NIC with IP forwarding enabled or a load
+// balancer frontend in the hub VNet owns the static next hop of each hub.
+// A next hop nobody owns blackholes the traffic of compliant routes, the
+// first fallback next hop that is owned is enforced instead.
+func (r *Report) verifyNextHops(ctx context.Context, cfg *config.Config, clientFactory *azure.ClientFactory) {
+	for i := range r.Hubs {
+		result := &r.Hubs[i]
+		hub := cfg.Hub(result.Name)
+		// firewall next hops are read from the firewall itself
+		if hub == nil || hub.IsVirtualWAN() || hub.NextHopSource != nil || hub.VNetID == "" || result.Error != "" {
+			continue
+		}
+
+		owners, err := clientFactory.ForSubscription(azure.SubscriptionIDOf(hub.VNetID)).ListIPOwners(ctx, hub.VNetID)
+		if err != nil {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("failed to verify next hop %s of hub %s: %v", result.NextHop, hub.Name, err))
+			continue
+		}
+
+		owner, reason := forwardingOwner(owners, result.NextHop)
+		for _, fallback := range hub.FallbackNVANextHops {
+			if fallbackOwner, fallbackReason := forwardingOwner(owners, fallback); fallbackReason == "" {
+				result.healthyFallbacks = append(result.healthyFallbacks, fallback)
+				result.fallbackOwners = append(result.fallbackOwners, fallbackOwner)
+			}
+		}
+		if reason == "" {
+			result.NextHopOwner = owner
+			continue
+		}
+
+		r.Findings = append(r.Findings, findings.New(findings.RuleUnownedNextHop, cfg.Rules,
+			azure.SubscriptionIDOf(hub.VNetID), hub.VNetID, fmt.Sprintf("next hop %s of hub %s is not a forwarding NVA: %s",
+				result.NextHop, hub.Name, reason),
+			map[string]string{
+				"hub":     hub.Name,
+				"nextHop": result.NextHop,
+				"reason":  reason,
+			}))
+		if len(result.healthyFallbacks) > 0 {
+			r.useFallback(cfg, hub, result, 0, reason)
+			continue
+		}
+		if hub.BlockOnUnownedNextHop {
+			r.blockedHubs[hub.Name] = fmt.Sprintf("next hop %s of hub %s is not a forwarding NVA", result.NextHop, hub.Name)
+		}
+	}
+}
+
+// forwardingOwner returns the ID of the NIC or load balancer that owns the
+// IP and forwards traffic, or why there is none.
+func forwardingOwner(owners []azure.IPOwner, ip string) (string, string) {
+	reason := "no NIC or load balancer frontend in the hub VNet owns it"
+	for _, owner := range owners {
+		if owner.PrivateIP != ip {
+			continue
+		}
+		if owner.CanForward() {
+			return owner.ID, ""
+		}
+		reason = fmt.Sprintf("NIC %s owns it but has IP forwarding disabled", owner.ID)
+	}
+	return "", reason
+}
+
+// useFallback enforces the healthy fallback next hop at index i instead of
+// the hub's next hop, marking the hub degraded with a finding.
+func (r *Report) useFallback(cfg *config.Config, hub *config.HubVNetConfig, result *HubResult, i int, reason string) {
+	primary := hub.NVANextHop
+	result.NextHop = result.healthyFallbacks[i]
+	result.NextHopOwner = result.fallbackOwners[i]
+	result.Degraded = fmt.Sprintf("using secondary next hop %s, primary %s: %s", result.NextHop, primary, reason)
+	r.Warnings = append(r.Warnings, fmt.Sprintf("hub %s is degraded: %s", hub.Name, result.Degraded))
+	r.Findings = append(r.Findings, findings.New(findings.RuleNextHopDegraded, cfg.Rules,
+		azure.SubscriptionIDOf(hub.VNetID), hub.VNetID, fmt.Sprintf("hub %s is degraded: %s", hub.Name, result.Degraded),
+		map[string]string{
+			"hub":     hub.Name,
+			"nextHop": result.NextHop,
+			"primary": primary,
+			"reason":  reason,
+		}))
+}
+
+// TrackFallbacks persists the hubs using a fallback next hop and keeps them
+// on it until their primary next hop was owned for
+// failbackAfterHealthyChecks runs in a row, so routes don't flip back and
+// forth with a flapping NVA. A hub whose next hops couldn't be verified
+// keeps its state.
+func (r *Report) TrackFallbacks(store state.Store, cfg *config.Config) error {
+	previous := make(map[string]fallbackState)
+	if err := store.Get(fallbackStateKey, &previous); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return fmt.Errorf("failed to load next hop fallbacks: %w", err)
+	}
+
+	current := make(map[string]fallbackState)
+	for i := range r.Hubs {
+		result := &r.Hubs[i]
+		hub := cfg.Hub(result.Name)
+		if hub == nil || len(hub.FallbackNVANextHops) == 0 {
+			continue
+		}
+		prev, onFallback := previous[hub.Name]
+		switch {
+		case result.Degraded != "":
+			current[hub.Name] = fallbackState{NextHop: result.NextHop}
+		case onFallback && (result.Error != "" || result.NextHopOwner == ""):
+			current[hub.Name] = prev
+		case onFallback:
+			checks := prev.HealthyChecks + 1
+			i := indexOf(result.healthyFallbacks, prev.NextHop)
+			if i >= 0 && checks < hub.EffectiveFailbackAfterHealthyChecks() {
+				r.useFallback(cfg, hub, result, i, fmt.Sprintf("owned again for %d of the %d runs in a row required to fail back",
+					checks, hub.EffectiveFailbackAfterHealthyChecks()))
+				current[hub.Name] = fallbackState{NextHop: prev.NextHop, HealthyChecks: checks}
+				continue
+			}
+			r.Notes = append(r.Notes, fmt.Sprintf("hub %s fails back from next hop %s to %s", hub.Name, prev.NextHop, result.NextHop))
+		}
+	}
+
+	return store.Put(fallbackStateKey, current)
+}
+
+// indexOf returns the index of the value in the list, -1 if it isn't in it.
+func indexOf(list []string, value string) int {
+	for i, v := range list {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkVirtualHub returns an error if the virtual hub can't be read.
+func checkVirtualHub(ctx context.Context, clientFactory *azure.ClientFactory, hub config.HubVNetConfig) error {
+	hubID := hub.VirtualWAN.VirtualHubID
+	parts := azure.ExtractResourceIDParts(hubID)
+	hubsClient, err := clientFactory.ForSubscription(azure.SubscriptionIDOf(hubID)).NewVirtualHubsClient(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := hubsClient.Get(ctx, parts["resourceGroups"], parts["virtualHubs"], nil); err != nil {
+		return fmt.Errorf("failed to get virtual hub %s: %w", hubID, err)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// fallbackNextHops are the fallback next hops of the hub of the tests.
+var fallbackNextHops = []string{"10.0.0.5", "10.0.0.6"}
+
+// withFallbacks configures fallbackNextHops for the hub.
+func withFallbacks(cfg *config.Config) {
+	cfg.Hubs[0].FallbackNVANextHops = fallbackNextHops
+}
+
+// newNVAServer returns a fake ARM with the hub VNet and a forwarding NIC
+// for each of the IPs.
+func newNVAServer(ips ...string) *azuretest.Server {
+	arm := azuretest.NewServer()
+	arm.Put(configtest.HubVNetID, azuretest.VNet(configtest.HubVNetID, []string{"10.0.0.0/16"}, azuretest.Subnet("nva", "10.0.0.0/24", "")))
+	for i, ip := range ips {
+		nicID := fmt.Sprintf("/subscriptions/%s/resourceGroups/hub-rg/providers/Microsoft.Network/networkInterfaces/nva%d", configtest.HubSubscriptionID, i)
+		arm.Put(nicID, azuretest.ForwardingNIC(nicID, configtest.HubVNetID+"/subnets/nva", ip))
+	}
+	return arm
+}
+
+// runPreflight runs the preflight checks against the fake ARM.
+func runPreflight(t *testing.T, cfg *config.Config, arm *azuretest.Server) *Report {
+	t.Helper()
+	return Run(context.Background(), cfg, azure.NewClientFactoryWithTransport(&cfg.Azure, azuretest.Credential{}, arm))
+}
+
+// ruleIDs returns the rule IDs of the findings.
+func ruleIDs(all []findings.Finding) []string {
+	var ids []string
+	for _, f := range all {
+		ids = append(ids, f.RuleID)
+	}
+	return ids
+}
+
+func TestVerifyNextHopsFallback(t *testing.T) {
+	tests := []struct {
+		name string
+		// owned are the IPs of forwarding NICs
+		owned []string
+		block bool
+		want  string
+		// wantDegraded is whether the hub uses a fallback next hop
+		wantDegraded bool
+		wantAccess   AccessLevel
+		wantRules    []string
+	}{
+		{
+			name:       "primary owned",
+			owned:      []string{configtest.NVANextHop, fallbackNextHops[0]},
+			want:       configtest.NVANextHop,
+			wantAccess: AccessReadWrite,
+		},
+		{
+			name:         "first fallback owned",
+			owned:        fallbackNextHops,
+			want:         fallbackNextHops[0],
+			wantDegraded: true,
+			wantAccess:   AccessReadWrite,
+			wantRules:    []string{findings.RuleUnownedNextHop.ID, findings.RuleNextHopDegraded.ID},
+		},
+		{
+			name:         "second fallback owned",
+			owned:        fallbackNextHops[1:],
+			want:         fallbackNextHops[1],
+			wantDegraded: true,
+			wantAccess:   AccessReadWrite,
+			wantRules:    []string{findings.RuleUnownedNextHop.ID, findings.RuleNextHopDegraded.ID},
+		},
+		{
+			// the hub isn't blocked while a fallback is owned
+			name:         "fallback owned with blocking",
+			owned:        fallbackNextHops[1:],
+			block:        true,
+			want:         fallbackNextHops[1],
+			wantDegraded: true,
+			wantAccess:   AccessReadWrite,
+			wantRules:    []string{findings.RuleUnownedNextHop.ID, findings.RuleNextHopDegraded.ID},
+		},
+		{
+			name:       "nothing owned",
+			want:       configtest.NVANextHop,
+			wantAccess: AccessReadWrite,
+			wantRules:  []string{findings.RuleUnownedNextHop.ID},
+		},
+		{
+			name:       "nothing owned with blocking",
+			block:      true,
+			want:       configtest.NVANextHop,
+			wantAccess: AccessBlocked,
+			wantRules:  []string{findings.RuleUnownedNextHop.ID},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t, withFallbacks, func(cfg *config.Config) { cfg.Hubs[0].BlockOnUnownedNextHop = tt.block })
+			report := runPreflight(t, cfg, newNVAServer(tt.owned...))
+
+			hub := report.Hubs[0]
+			if hub.NextHop != tt.want || (hub.Degraded != "") != tt.wantDegraded {
+				t.Errorf("hub next hop = %s (degraded %q), want %s (degraded %t)", hub.NextHop, hub.Degraded, tt.want, tt.wantDegraded)
+			}
+			if got := report.NextHops()[configtest.HubName]; got != tt.want {
+				t.Errorf("NextHops() = %s, want %s", got, tt.want)
+			}
+			if got := report.Subscriptions[0].Access; got != tt.wantAccess {
+				t.Errorf("subscription access = %s, want %s", got, tt.wantAccess)
+			}
+			if got := ruleIDs(report.Findings); strings.Join(got, ",") != strings.Join(tt.wantRules, ",") {
+				t.Errorf("findings = %v, want %v", got, tt.wantRules)
+			}
+			if tt.wantDegraded && !strings.Contains(strings.Join(report.Warnings, "\n"), "is degraded: using secondary next hop "+tt.want) {
+				t.Errorf("warnings = %v, want the hub degraded", report.Warnings)
+			}
+		})
+	}
+}
+
+func TestTrackFallbacksHysteresis(t *testing.T) {
+	cfg := configtest.New(t, withFallbacks)
+	store, err := state.Open(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// owned is whether the primary is owned on each run, want the next hop
+	// enforced
+	runs := []struct {
+		owned bool
+		want  string
+		// wantFailback is whether the run notes failing back
+		wantFailback bool
+	}{
+		{owned: true, want: configtest.NVANextHop},
+		{owned: false, want: fallbackNextHops[0]},
+		{owned: true, want: fallbackNextHops[0]},
+		// a flap restarts the count of healthy checks
+		{owned: false, want: fallbackNextHops[0]},
+		{owned: true, want: fallbackNextHops[0]},
+		{owned: true, want: fallbackNextHops[0]},
+		{owned: true, want: configtest.NVANextHop, wantFailback: true},
+		{owned: true, want: configtest.NVANextHop},
+	}
+
+	for i, run := range runs {
+		owned := fallbackNextHops
+		if run.owned {
+			owned = append([]string{configtest.NVANextHop}, owned...)
+		}
+		report := runPreflight(t, cfg, newNVAServer(owned...))
+		if err := report.TrackFallbacks(store, cfg); err != nil {
+			t.Fatalf("run %d: TrackFallbacks() error = %v", i, err)
+		}
+
+		if got := report.NextHops()[configtest.HubName]; got != run.want {
+			t.Errorf("run %d: next hop = %s, want %s", i, got, run.want)
+		}
+		failback := strings.Contains(strings.Join(report.Notes, "\n"), "fails back from next hop "+fallbackNextHops[0])
+		if failback != run.wantFailback {
+			t.Errorf("run %d: notes = %v, want failing back %t", i, report.Notes, run.wantFailback)
+		}
+	}
+}
+
+func TestTrackFallbacksUnverified(t *testing.T) {
+	cfg := configtest.New(t, withFallbacks, func(cfg *config.Config) { cfg.Hubs[0].FailbackAfterHealthyChecks = 1 })
+	store, err := state.Open(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := runPreflight(t, cfg, newNVAServer(fallbackNextHops...))
+	if err := report.TrackFallbacks(store, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// the NICs can't be listed, the hub stays on its fallback
+	arm := newNVAServer(configtest.NVANextHop)
+	arm.Handle(http.MethodGet, "/subscriptions/"+configtest.HubSubscriptionID+"/providers/Microsoft.Network/networkInterfaces", respond(http.StatusInternalServerError, `{"error":{"code":"InternalServerError","message":"unavailable"}}`))
+	report = runPreflight(t, cfg, arm)
+	if err := report.TrackFallbacks(store, cfg); err != nil {
+		t.Fatal(err)
+	}
+	var saved map[string]fallbackState
+	if err := store.Get(fallbackStateKey, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if got := saved[configtest.HubName]; got.NextHop != fallbackNextHops[0] {
+		t.Errorf("fallback state after an unverified run = %+v, want %s kept", got, fallbackNextHops[0])
+	}
+}
@@ -0,0 +1,203 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/guard"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// AccessLevel is the access velora's identity has to a subscription.
+type AccessLevel string
+
+const (
+	AccessNone      AccessLevel = "no-access"
+	AccessRead      AccessLevel = "read-only"
+	AccessReadWrite AccessLevel = "read-write"
+	// AccessInactive is set for subscriptions that aren't active in Azure.
+	AccessInactive AccessLevel = "inactive"
+	// AccessHubFailed is set for subscriptions whose hub failed preflight,
+	// access isn't checked.
+	AccessHubFailed AccessLevel = "hub-failed"
+	// AccessBlocked is set for writable subscriptions whose hub next hop
+	// isn't owned by an NVA, they are only observed.
+	AccessBlocked AccessLevel = "blocked"
+)
+
+// inactiveStateKey is the state store key holding the subscriptions found
+// inactive, so their reactivation can be reported.
+const inactiveStateKey = "inactive-subscriptions"
+
+// writeActions are the actions remediation needs.
+var writeActions = []string{
+	"Microsoft.Network/routeTables/routes/write",
+	"Microsoft.Network/virtualNetworks/virtualNetworkPeerings/write",
+}
+
+// SubscriptionResult is the preflight result of one subscription.
+type SubscriptionResult struct {
+	SubscriptionID string      `json:"subscriptionId"`
+	Access         AccessLevel `json:"access"`
+	Detail         string      `json:"detail,omitempty"`
+}
+
+// Report is the result of the preflight checks.
+type Report struct {
+	Warnings      []string             `json:"warnings,omitempty"`
+	Notes         []string             `json:"notes,omitempty"`
+	Hubs          []HubResult          `json:"hubs"`
+	Subscriptions []SubscriptionResult `json:"subscriptions"`
+	Findings      []findings.Finding   `json:"findings,omitempty"`
+
+	// blockedHubs are the hubs whose subscriptions are kept in observe
+	// mode, with the reason.
+	blockedHubs map[string]string
+}
+
+// Run performs the preflight checks for all configured subscriptions.
+func Run(ctx context.Context, cfg *config.Config, clientFactory *azure.ClientFactory) *Report {
+	report := &Report{
+		Warnings:    cfg.Warnings(),
+		Hubs:        resolveHubs(ctx, cfg, clientFactory),
+		blockedHubs: make(map[string]string),
+	}
+	report.verifyNextHops(ctx, cfg, clientFactory)
+
+	failedHubs := make(map[string]string)
+	for _, hub := range report.Hubs {
+		if hub.Error != "" {
+			failedHubs[hub.Name] = hub.Error
+		}
+	}
+
+	for _, subID := range cfg.SubscriptionIDs() {
+		// the subscription can't be enforced without the next hop of its hub
+		if reason, failed := failedHubs[cfg.Subscriptions[subID].HubName]; failed {
+			report.Subscriptions = append(report.Subscriptions, SubscriptionResult{
+				SubscriptionID: subID,
+				Access:         AccessHubFailed,
+				Detail:         fmt.Sprintf("next hop of hub %s not resolved: %s", cfg.Subscriptions[subID].HubName, reason),
+			})
+			continue
+		}
+
+		access, detail := CheckAccess(ctx, clientFactory.ForSubscription(subID))
+		result := SubscriptionResult{
+			SubscriptionID: subID,
+			Access:         access,
+			Detail:         detail,
+		}
+		if reason, blocked := report.blockedHubs[cfg.Subscriptions[subID].HubName]; blocked && access == AccessReadWrite {
+			result.Access = AccessBlocked
+			result.Detail = reason
+		}
+		report.Subscriptions = append(report.Subscriptions, result)
+
+		if access == AccessInactive {
+			report.Findings = append(report.Findings, findings.New(findings.RuleInactiveSubscription, cfg.Rules,
+				subID, "/subscriptions/"+subID, fmt.Sprintf("subscription %s is inactive (%s), skipped", subID, detail),
+				map[string]string{
+					"subscription": subID,
+					"state":        detail,
+				}))
+		}
+	}
+
+	return report
+}
+
+// TrackInactive persists the inactive subscriptions and adds a note for
+// every subscription that was inactive on the previous run and is enforced
+// again.
+func (r *Report) TrackInactive(store state.Store) error {
+	previous := make(map[string]time.Time)
+	if err := store.Get(inactiveStateKey, &previous); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return fmt.Errorf("failed to load inactive subscriptions: %w", err)
+	}
+
+	current := make(map[string]time.Time)
+	for _, sub := range r.Subscriptions {
+		since, wasInactive := previous[sub.SubscriptionID]
+		if sub.Access == AccessInactive {
+			if !wasInactive {
+				since = time.Now().UTC()
+			}
+			current[sub.SubscriptionID] = since
+		} else if wasInactive {
+			r.Notes = append(r.Notes, fmt.Sprintf("subscription %s is active again after being inactive since %s, it is enforced from this run",
+				sub.SubscriptionID, since.Format(time.RFC3339)))
+		}
+	}
+
+	return store.Put(inactiveStateKey, current)
+}
+
+// Apply downgrades read-only and blocked subscriptions to observe mode and
+// keeps the guard from writing to subscriptions velora can't access at all.
+func (r *Report) Apply(g *guard.Guard) {
+	for _, sub := range r.Subscriptions {
+		switch sub.Access {
+		case AccessRead:
+			g.SetObserveOnly(sub.SubscriptionID, "identity has read-only access")
+		case AccessNone:
+			g.SetObserveOnly(sub.SubscriptionID, "identity has no access")
+		case AccessInactive:
+			g.SetInactive(sub.SubscriptionID, sub.Detail)
+		case AccessHubFailed:
+			g.SetSkipped(sub.SubscriptionID, sub.Detail)
+		case AccessBlocked:
+			g.SetObserveOnly(sub.SubscriptionID, sub.Detail)
+		}
+	}
+}
+
+// CheckAccess classifies the access to the subscription of the factory with
+// a cheap read probe and the permissions granted at subscription scope.
+func CheckAccess(ctx context.Context, clientFactory *azure.ClientFactory) (AccessLevel, string) {
+	// the state is only used to skip inactive subscriptions, failing to read
+	// it falls through to the probes
+	if subState, err := clientFactory.GetSubscriptionState(ctx); err == nil && subState.Inactive() {
+		return AccessInactive, string(subState)
+	}
+
+	// read probe: the first page of route tables
+	routeTablesClient, err := clientFactory.NewRouteTablesClient(ctx)
+	if err != nil {
+		return AccessNone, err.Error()
+	}
+	pager := routeTablesClient.NewListAllPager(nil)
+	if _, err := pager.NextPage(ctx); err != nil {
+		var timeoutErr *azure.TokenTimeoutError
+		if errors.As(err, &timeoutErr) {
+			return AccessNone, timeoutErr.Error()
+		}
+		var respErr *azcore.ResponseError
+		if azure.IsInactiveSubscriptionError(err) && errors.As(err, &respErr) {
+			return AccessInactive, respErr.ErrorCode
+		}
+		if azure.IsAccessDenied(err) && errors.As(err, &respErr) {
+			return AccessNone, fmt.Sprintf("read probe denied: %s", respErr.ErrorCode)
+		}
+		return AccessNone, fmt.Sprintf("read probe failed: %v", err)
+	}
+
+	// write probe: the actions granted at subscription scope
+	permissions, err := clientFactory.ListPermissions(ctx)
+	if err != nil {
+		return AccessRead, fmt.Sprintf("failed to list permissions, assuming read-only: %v", err)
+	}
+	for _, action := range writeActions {
+		if !azure.Allows(permissions, action) {
+			return AccessRead, fmt.Sprintf("missing %s", action)
+		}
+	}
+
+	return AccessReadWrite, ""
+}
@@ -0,0 +1,119 @@
+package preflight
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config/configtest"
+)
+
+const subscriptionPath = "/subscriptions/" + configtest.SubscriptionID
+
+// respond serves the status and body.
+func respond(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	tests := []struct {
+		name     string
+		handlers map[string]http.HandlerFunc
+		want     AccessLevel
+	}{
+		{
+			name: "read and write",
+			want: AccessReadWrite,
+		},
+		{
+			name: "reader",
+			handlers: map[string]http.HandlerFunc{
+				subscriptionPath + "/providers/Microsoft.Authorization/permissions": respond(http.StatusOK,
+					`{"value":[{"actions":["*/read"],"notActions":[]}]}`),
+			},
+			want: AccessRead,
+		},
+		{
+			name: "write excluded by notActions",
+			handlers: map[string]http.HandlerFunc{
+				subscriptionPath + "/providers/Microsoft.Authorization/permissions": respond(http.StatusOK,
+					`{"value":[{"actions":["*"],"notActions":["Microsoft.Network/routeTables/routes/write"]}]}`),
+			},
+			want: AccessRead,
+		},
+		{
+			name: "permissions not readable",
+			handlers: map[string]http.HandlerFunc{
+				subscriptionPath + "/providers/Microsoft.Authorization/permissions": respond(http.StatusForbidden,
+					`{"error":{"code":"AuthorizationFailed","message":"denied"}}`),
+			},
+			want: AccessRead,
+		},
+		{
+			name: "disabled subscription",
+			handlers: map[string]http.HandlerFunc{
+				subscriptionPath: respond(http.StatusOK, `{"subscriptionId":"`+configtest.SubscriptionID+`","state":"Disabled"}`),
+			},
+			want: AccessInactive,
+		},
+		{
+			name: "read probe denied",
+			handlers: map[string]http.HandlerFunc{
+				subscriptionPath + "/providers/Microsoft.Network/routeTables": respond(http.StatusForbidden,
+					`{"error":{"code":"AuthorizationFailed","message":"denied"}}`),
+			},
+			want: AccessNone,
+		},
+		{
+			name: "read probe refused for an inactive subscription",
+			handlers: map[string]http.HandlerFunc{
+				subscriptionPath + "/providers/Microsoft.Network/routeTables": respond(http.StatusConflict,
+					`{"error":{"code":"ReadOnlyDisabledSubscription","message":"disabled"}}`),
+			},
+			want: AccessInactive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arm := azuretest.NewServer()
+			for path, handler := range tt.handlers {
+				arm.Handle(http.MethodGet, path, handler)
+			}
+			cfg := configtest.New(t)
+			factory := azure.NewClientFactoryWithTransport(&cfg.Azure, azuretest.Credential{}, arm).ForSubscription(configtest.SubscriptionID)
+
+			access, detail := CheckAccess(context.Background(), factory)
+			if access != tt.want {
+				t.Errorf("CheckAccess() = %s (%s), want %s", access, detail, tt.want)
+			}
+		})
+	}
+}
+
+// timedOutCredential fails like a token acquisition that timed out.
+type timedOutCredential struct{}
+
+func (timedOutCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{}, &azure.TokenTimeoutError{Endpoint: "169.254.169.254", Timeout: time.Second}
+}
+
+func TestCheckAccessTokenTimeout(t *testing.T) {
+	cfg := configtest.New(t)
+	factory := azure.NewClientFactoryWithTransport(&cfg.Azure, timedOutCredential{}, azuretest.NewServer()).ForSubscription(configtest.SubscriptionID)
+
+	access, detail := CheckAccess(context.Background(), factory)
+	if want := "token acquisition timed out after 1s talking to 169.254.169.254"; access != AccessNone || detail != want {
+		t.Errorf("CheckAccess() = %s (%s), want %s (%s)", access, detail, AccessNone, want)
+	}
+}
@@ -0,0 +1,213 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+)
+
+// referenceAPIVersions are the API versions used to check that referenced
+// resources outside Microsoft.Network exist.
+var referenceAPIVersions = map[string]string{
+	"microsoft.storage/storageaccounts":        "2023-01-01",
+	"microsoft.operationalinsights/workspaces": "2022-10-01",
+}
+
+// Issue is a problem found by the validation of a configuration.
+type Issue struct {
+	// Subject is the hub, subscription or resource the issue is about.
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+}
+
+// Validation is the result of the deep validation of a configuration.
+type Validation struct {
+	Valid    bool    `json:"valid"`
+	Errors   []Issue `json:"errors"`
+	Warnings []Issue `json:"warnings"`
+}
+
+// Validate checks the resources the configuration references, with reads
+// only: the hubs exist and their NVA next hop is inside the hub address
+// space, the referenced firewalls, NSGs, hub route tables, storage accounts
+// and workspaces exist, and the subscriptions are accessible. The
+// configuration must have passed the static validation.
+func Validate(ctx context.Context, cfg *config.Config, clientFactory *azure.ClientFactory) *Validation {
+	v := &Validation{Errors: []Issue{}, Warnings: []Issue{}}
+	for _, warning := range cfg.Warnings() {
+		v.warn("config", warning)
+	}
+
+	for _, hub := range cfg.Hubs {
+		v.validateHub(ctx, clientFactory, hub)
+	}
+
+	for _, subID := range cfg.SubscriptionIDs() {
+		subCFG := cfg.Subscriptions[subID]
+		switch access, detail := CheckAccess(ctx, clientFactory.ForSubscription(subID)); access {
+		case AccessNone:
+			v.fail(subID, fmt.Sprintf("subscription isn't accessible: %s", detail))
+		case AccessInactive:
+			v.warn(subID, fmt.Sprintf("subscription is inactive (%s), it will be skipped", detail))
+		case AccessRead:
+			if cfg.Features.AutoRemediation && !cfg.ReadOnly {
+				v.warn(subID, fmt.Sprintf("subscription is read-only (%s), it will only be observed", detail))
+			}
+		}
+
+		if nsg := subCFG.NSGAssociation; nsg != nil {
+			v.checkExists(ctx, clientFactory, subID, nsg.NSGID, "baseline NSG")
+			for _, ipGroupID := range nsg.IPGroups() {
+				v.checkExists(ctx, clientFactory, subID, ipGroupID, "IP Group of the baseline rules")
+			}
+		}
+	}
+
+	v.Valid = len(v.Errors) == 0
+	return v
+}
+
+// validateHub checks the resources referenced by the hub.
+func (v *Validation) validateHub(ctx context.Context, clientFactory *azure.ClientFactory, hub config.HubVNetConfig) {
+	subject := "hub " + hub.Name
+	if hub.IsVirtualWAN() {
+		if err := checkVirtualHub(ctx, clientFactory, hub); err != nil {
+			v.fail(subject, err.Error())
+			return
+		}
+		v.checkHubRouteTable(ctx, clientFactory, subject, hub.VirtualWAN)
+		v.checkExists(ctx, clientFactory, subject, hub.VirtualWAN.NextHopID, "next hop")
+		return
+	}
+
+	nextHop, err := ResolveNextHop(ctx, clientFactory, hub)
+	if err != nil {
+		v.fail(subject, err.Error())
+	}
+
+	parts := azure.ExtractResourceIDParts(hub.VNetID)
+	vnetsClient, err := clientFactory.ForSubscription(parts["subscriptions"]).NewVirtualNeworksClient(ctx)
+	if err != nil {
+		v.fail(subject, err.Error())
+		return
+	}
+	vnet, err := vnetsClient.Get(ctx, parts["resourceGroups"], parts["virtualNetworks"], nil)
+	if err != nil {
+		v.fail(subject, fmt.Sprintf("failed to get hub VNet %s: %v", hub.VNetID, err))
+		return
+	}
+	if nextHop != "" && vnet.Properties != nil && vnet.Properties.AddressSpace != nil {
+		if !InsidePrefixes(nextHop, vnet.Properties.AddressSpace.AddressPrefixes) {
+			v.fail(subject, fmt.Sprintf("next hop %s is outside the address space of hub VNet %s", nextHop, hub.VNetID))
+		}
+	}
+	if vnet.Properties != nil && vnet.Properties.AddressSpace != nil {
+		for _, prefix := range hub.AddressPrefixes {
+			if !hasPrefix(vnet.Properties.AddressSpace.AddressPrefixes, prefix) {
+				v.warn(subject, fmt.Sprintf("address prefix %s is configured but not in the address space of hub VNet %s", prefix, hub.VNetID))
+			}
+		}
+	}
+
+	if hub.FlowLogs != nil {
+		v.checkExists(ctx, clientFactory, subject, hub.FlowLogs.StorageAccountID, "flow log storage account")
+		if ta := hub.FlowLogs.TrafficAnalytics; ta != nil {
+			v.checkExists(ctx, clientFactory, subject, ta.WorkspaceResourceID, "traffic analytics workspace")
+		}
+	}
+}
+
+// checkHubRouteTable checks the route table spoke connections must be
+// associated with exists in the virtual hub.
+func (v *Validation) checkHubRouteTable(ctx context.Context, clientFactory *azure.ClientFactory, subject string, vwan *config.VirtualWANConfig) {
+	parts := azure.ExtractResourceIDParts(vwan.VirtualHubID)
+	routeTablesClient, err := clientFactory.ForSubscription(parts["subscriptions"]).NewHubRouteTablesClient(ctx)
+	if err != nil {
+		v.fail(subject, err.Error())
+		return
+	}
+	name := vwan.EffectiveAssociatedRouteTable()
+	if _, err := routeTablesClient.Get(ctx, parts["resourceGroups"], parts["virtualHubs"], name, nil); err != nil {
+		v.fail(subject, fmt.Sprintf("failed to get hub route table %s: %v", name, err))
+	}
+}
+
+// checkExists reports an error if the referenced resource doesn't exist or
+// can't be read.
+func (v *Validation) checkExists(ctx context.Context, clientFactory *azure.ClientFactory, subject, resourceID, kind string) {
+	if resourceID == "" {
+		return
+	}
+	apiVersion := azure.NetworkAPIVersion
+	if version, ok := referenceAPIVersions[resourceType(resourceID)]; ok {
+		apiVersion = version
+	}
+
+	_, exists, err := clientFactory.ForSubscription(azure.SubscriptionIDOf(resourceID)).GetResourceEtag(ctx, resourceID, apiVersion)
+	switch {
+	case err != nil:
+		v.fail(subject, fmt.Sprintf("failed to read %s: %v", kind, err))
+	case !exists:
+		v.fail(subject, fmt.Sprintf("%s %s doesn't exist", kind, resourceID))
+	}
+}
+
+// fail records an error.
+func (v *Validation) fail(subject, message string) {
+	v.Errors = append(v.Errors, Issue{Subject: subject, Message: message})
+}
+
+// warn records a warning.
+func (v *Validation) warn(subject, message string) {
+	v.Warnings = append(v.Warnings, Issue{Subject: subject, Message: message})
+}
+
+// resourceType returns the lower-case top-level resource type of the ID,
+// e.g. "microsoft.storage/storageaccounts".
+func resourceType(resourceID string) string {
+	parts := strings.Split(strings.ToLower(resourceID), "/")
+	for i, part := range parts {
+		if part == "providers" && i+2 < len(parts) {
+			return parts[i+1] + "/" + parts[i+2]
+		}
+	}
+	return ""
+}
+
+// hasPrefix reports whether the prefixes include the prefix.
+func hasPrefix(prefixes []*string, prefix string) bool {
+	want, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return false
+	}
+	for _, p := range prefixes {
+		if p == nil {
+			continue
+		}
+		if got, err := netip.ParsePrefix(*p); err == nil && got.Masked() == want.Masked() {
+			return true
+		}
+	}
+	return false
+}
+
+// InsidePrefixes reports whether the IP is inside one of the prefixes.
+func InsidePrefixes(ip string, prefixes []*string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for _, p := range prefixes {
+		if p == nil {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(*p); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+)
+
+// TestValidateHubPrefixes checks the NVA next hop against every prefix of a
+// hub address space split in several, and the configured addressPrefixes
+// against it.
+func TestValidateHubPrefixes(t *testing.T) {
+	tests := []struct {
+		name       string
+		prefixes   []string
+		configured []string
+		wantErrors []string
+		// wantWarnings are the hub's, not the configuration's
+		wantWarnings []string
+	}{
+		{
+			name:     "NVA in the first prefix",
+			prefixes: []string{"10.0.0.0/24", "10.100.0.0/24"},
+		},
+		{
+			name:     "NVA in the second prefix",
+			prefixes: []string{"fd00:db8::/48", "10.0.0.0/24"},
+		},
+		{
+			name:       "NVA outside every prefix",
+			prefixes:   []string{"10.100.0.0/24", "fd00:db8::/48"},
+			wantErrors: []string{"next hop " + configtest.NVANextHop + " is outside the address space of hub VNet " + configtest.HubVNetID},
+		},
+		{
+			name:       "configured prefixes",
+			prefixes:   []string{"10.0.0.0/24", "fd00:db8::/48"},
+			configured: []string{"10.0.0.0/24", "fd00:db8::/48"},
+		},
+		{
+			name:         "configured prefix missing",
+			prefixes:     []string{"10.0.0.0/24"},
+			configured:   []string{"10.0.0.0/24", "10.100.0.0/24"},
+			wantWarnings: []string{"address prefix 10.100.0.0/24 is configured but not in the address space of hub VNet " + configtest.HubVNetID},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arm := newNVAServer(configtest.NVANextHop)
+			arm.Put(configtest.HubVNetID, azuretest.VNet(configtest.HubVNetID, tt.prefixes, azuretest.Subnet("nva", "10.0.0.0/24", "")))
+			cfg := configtest.New(t, func(cfg *config.Config) { cfg.Hubs[0].AddressPrefixes = tt.configured })
+
+			v := Validate(context.Background(), cfg, azure.NewClientFactoryWithTransport(&cfg.Azure, azuretest.Credential{}, arm))
+			var errs, warnings []string
+			for _, issue := range v.Errors {
+				errs = append(errs, issue.Message)
+			}
+			for _, issue := range v.Warnings {
+				if issue.Subject == "hub "+configtest.HubName {
+					warnings = append(warnings, issue.Message)
+				}
+			}
+			if fmt.Sprint(errs) != fmt.Sprint(tt.wantErrors) || fmt.Sprint(warnings) != fmt.Sprint(tt.wantWarnings) {
+				t.Errorf("Validate() = errors %q, warnings %q, want %q, %q", errs, warnings, tt.wantErrors, tt.wantWarnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package redact
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Placeholder replaces every registered secret.
+const Placeholder = "[REDACTED]"
+
+// partialLineDelay is how long an incomplete line is held back waiting for
+// the rest of it, a prompt is shown after it.
+const partialLineDelay = 50 * time.Millisecond
+
+// minSecretLength is the length below which values aren't registered, they
+// would scrub unrelated output.
+const minSecretLength = 6
+
+var (
+	mu      sync.RWMutex
+	secrets []string
+)
+
+// Register adds secrets to the registry, they are scrubbed from everything
+// redacted afterwards. Config loading registers the configured secrets,
+// secret sources register the values they resolve.
+func Register(values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if len(value) < minSecretLength || contains(value) {
+			continue
+		}
+		secrets = append(secrets, value)
+	}
+	// longest first, so a secret containing another is scrubbed whole
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+}
+
+// contains reports whether the value is registered, mu must be held.
+func contains(value string) bool {
+	for _, s := range secrets {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns s with every registered secret replaced by the placeholder.
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Placeholder)
+	}
+	return s
+}
+
+// Error wraps err so its message is redacted. errors.Is and errors.As still
+// see the original error.
+func Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{err: err}
+}
+
+// redactedError is an error whose message is redacted.
+type redactedError struct {
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return String(e.err.Error())
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Writer redacts everything written to it before passing it on. Output is
+// buffered per line, so secrets split across writes are still scrubbed. An
+// incomplete line, like a prompt, is written once no more output follows it
+// for a short while.
+type Writer struct {
+	mu   sync.Mutex
+	next io.Writer
+	buf  []byte
+	// writes counts the writes, a delayed flush only writes the line if no
+	// write followed the one that scheduled it.
+	writes uint64
+}
+
+// NewWriter creates a new redacting writer in front of next.
+func NewWriter(next io.Writer) *Writer {
+	return &Writer{next: next}
+}
+
+// Write implements io.Writer.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.writes++
+	w.buf = append(w.buf, p...)
+	if idx := bytes.LastIndexByte(w.buf, '\n'); idx >= 0 {
+		if _, err := io.WriteString(w.next, String(string(w.buf[:idx+1]))); err != nil {
+			return 0, err
+		}
+		w.buf = append(w.buf[:0], w.buf[idx+1:]...)
+	}
+	if len(w.buf) > 0 {
+		writes := w.writes
+		time.AfterFunc(partialLineDelay, func() { w.flushIdle(writes) })
+	}
+	return len(p), nil
+}
+
+// flushIdle writes the buffered incomplete line if there was no write since
+// the given one.
+func (w *Writer) flushIdle(writes uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.writes == writes && len(w.buf) > 0 {
+		io.WriteString(w.next, String(string(w.buf)))
+		w.buf = w.buf[:0]
+	}
+}
+
+// Flush writes the buffered incomplete line.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.next, String(string(w.buf)))
+	w.buf = w.buf[:0]
+	return err
+}
+
+// stdio is the redirection of os.Stdout and os.Stderr made by Stdio.
+var stdio struct {
+	mu sync.Mutex
+	// restore restores the original files, nil if they aren't redirected.
+	restore func()
+}
+
+// Stdio redirects os.Stdout and os.Stderr through redacting writers, so
+// nothing printed by the process can leak a registered secret. The returned
+// function flushes the output and restores the original files, it must be
+// called before exiting.
+func Stdio() (func(), error) {
+	stdio.mu.Lock()
+	defer stdio.mu.Unlock()
+	if err := redirectStdio(); err != nil {
+		return nil, err
+	}
+	return func() {
+		stdio.mu.Lock()
+		defer stdio.mu.Unlock()
+		if stdio.restore != nil {
+			stdio.restore()
+			stdio.restore = nil
+		}
+	}, nil
+}
+
+// Prompt prints the prompt, redacted, once everything printed before it is
+// written out. A prompt without a newline would otherwise be held back by
+// the redacting writer while the input is read.
+func Prompt(prompt string) error {
+	stdio.mu.Lock()
+	defer stdio.mu.Unlock()
+	if stdio.restore == nil {
+		_, err := io.WriteString(os.Stdout, String(prompt))
+		return err
+	}
+
+	// restoring the files waits for the pipes to be copied out
+	stdio.restore()
+	stdio.restore = nil
+	if _, err := io.WriteString(os.Stdout, String(prompt)); err != nil {
+		return err
+	}
+	if err := redirectStdio(); err != nil {
+		return fmt.Errorf("failed to redirect the output after the prompt: %w", err)
+	}
+	return nil
+}
+
+// redirectStdio redirects os.Stdout and os.Stderr and sets stdio.restore.
+func redirectStdio() error {
+	stdout, err := redirect(&os.Stdout)
+	if err != nil {
+		return err
+	}
+	stderr, err := redirect(&os.Stderr)
+	if err != nil {
+		stdout()
+		return err
+	}
+	stdio.restore = func() {
+		stderr()
+		stdout()
+	}
+	return nil
+}
+
+// redirect replaces *file with a pipe copied to the original file through
+// a redacting writer.
+func redirect(file **os.File) (func(), error) {
+	original := *file
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	*file = w
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		out := NewWriter(original)
+		io.Copy(out, r)
+		out.Flush()
+	}()
+
+	return func() {
+		*file = original
+		w.Close()
+		<-done
+		r.Close()
+	}, nil
+}
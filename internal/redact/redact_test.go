@@ -0,0 +1,182 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSecret is registered by the tests.
+const testSecret = "s3cr3t-client-value"
+
+// syncBuffer is a buffer safe for the delayed flushes of a Writer.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// secretError is an error whose fields hold the secret, like an SDK error
+// carrying the request.
+type secretError struct {
+	URL string
+}
+
+func (e *secretError) Error() string {
+	return "request to " + e.URL + " failed"
+}
+
+func TestString(t *testing.T) {
+	Register(testSecret, "short")
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "secret=" + testSecret, want: "secret=" + Placeholder},
+		{in: testSecret + testSecret, want: Placeholder + Placeholder},
+		// values below the minimum length aren't registered
+		{in: "short", want: "short"},
+	}
+
+	for _, tt := range tests {
+		if got := String(tt.in); got != tt.want {
+			t.Errorf("String(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestErrorFormatting(t *testing.T) {
+	Register(testSecret)
+	cause := &secretError{URL: "https://vault/secrets/x?sig=" + testSecret}
+	err := Error(fmt.Errorf("failed to load the config: %w", cause))
+
+	for _, verb := range []string{"%v", "%+v", "%s", "%q"} {
+		if got := fmt.Sprintf(verb, err); strings.Contains(got, testSecret) {
+			t.Errorf("Sprintf(%s) = %s, leaks the secret", verb, got)
+		}
+	}
+	var target *secretError
+	if !errors.As(err, &target) || target != cause {
+		t.Error("errors.As() doesn't find the wrapped error")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	Register(testSecret)
+	cause := fmt.Errorf("failed to load the config: %w", &secretError{URL: "https://vault/secrets/x?sig=" + testSecret})
+
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{
+			name:   "wrapped error printed with %+v",
+			writes: []string{fmt.Sprintf("error: %+v\n", cause)},
+			want:   "error: failed to load the config: request to https://vault/secrets/x?sig=" + Placeholder + " failed\n",
+		},
+		{
+			name:   "secret split across writes",
+			writes: []string{"token " + testSecret[:4], testSecret[4:], " used\n"},
+			want:   "token " + Placeholder + " used\n",
+		},
+		{
+			name:   "several lines in a write",
+			writes: []string{"a\n" + testSecret + "\nb\n"},
+			want:   "a\n" + Placeholder + "\nb\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out syncBuffer
+			w := NewWriter(&out)
+			for _, s := range tt.writes {
+				if _, err := w.Write([]byte(s)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriterPartialLine(t *testing.T) {
+	Register(testSecret)
+	var out syncBuffer
+	w := NewWriter(&out)
+
+	// a prompt waiting for input is shown without its newline
+	fmt.Fprint(w, "Hub VNet ID: ")
+	deadline := time.Now().Add(2 * time.Second)
+	for out.String() == "" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := out.String(); got != "Hub VNet ID: " {
+		t.Fatalf("output of the prompt = %q, want it flushed", got)
+	}
+
+	// a partial line still waits for the rest of a secret written quickly
+	fmt.Fprint(w, "key "+testSecret[:4])
+	fmt.Fprint(w, testSecret[4:]+"\n")
+	if got := out.String(); got != "Hub VNet ID: key "+Placeholder+"\n" {
+		t.Errorf("output = %q, want the secret scrubbed", got)
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	Register(testSecret)
+	file, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	stdout := os.Stdout
+	os.Stdout = file
+	defer func() { os.Stdout = stdout }()
+
+	restore, err := Stdio()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restore()
+	fmt.Println("1) 10.0.0.4")
+	if err := Prompt("NVA for " + testSecret + ": "); err != nil {
+		t.Fatalf("Prompt() error = %v", err)
+	}
+
+	// the prompt is written out before the input is read, not after a delay
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "1) 10.0.0.4\nNVA for "+Placeholder+": "; got != want {
+		t.Errorf("output after Prompt() = %q, want %q", got, want)
+	}
+
+	// the output is still redacted after the prompt
+	fmt.Println(testSecret)
+	restore()
+	data, _ = os.ReadFile(file.Name())
+	if !strings.HasSuffix(string(data), ": "+Placeholder+"\n") {
+		t.Errorf("output after the prompt = %q, want it redacted", data)
+	}
+}
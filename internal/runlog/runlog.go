@@ -0,0 +1,172 @@
+// Package runlog keeps the recent runs with their findings, for the web UI
+// to browse. The index of the runs and the findings of each run are kept
+// under separate state keys, so listing the runs doesn't read every finding.
+package runlog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/state"
+	"github.com/akos011221/velora/internal/version"
+)
+
+const (
+	// indexKey is the state store key holding the recent runs, oldest
+	// first. It is one of state.RoleSharedKeys.
+	indexKey = "run-log"
+	// findingsKeyPrefix prefixes the state store keys holding the findings
+	// of each run, shared by the roles too.
+	findingsKeyPrefix = state.RunLogKeyPrefix
+)
+
+// MaxRuns is how many runs are kept, older ones are dropped with their
+// findings.
+const MaxRuns = 50
+
+// Run is a run of the log.
+type Run struct {
+	ID       string            `json:"id"`
+	Time     time.Time         `json:"time"`
+	Metadata findings.Metadata `json:"metadata"`
+	// Error is why the run failed, empty if it completed.
+	Error string `json:"error,omitempty"`
+	// Findings counts the findings of the run per severity.
+	Findings map[findings.Severity]int `json:"findings"`
+	// Skipped are the subscriptions the run didn't evaluate, with the reason.
+	Skipped map[string]string `json:"skipped,omitempty"`
+}
+
+// Total returns the number of findings of the run.
+func (r *Run) Total() int {
+	total := 0
+	for _, n := range r.Findings {
+		total += n
+	}
+	return total
+}
+
+// Log is the log of the recent runs in the state store.
+type Log struct {
+	store state.Store
+	mu    sync.Mutex
+}
+
+// New returns the run log of the store.
+func New(store state.Store) *Log {
+	return &Log{store: store}
+}
+
+// Record adds a run finished at now with its findings, runErr is why it
+// failed if it did. The oldest runs beyond MaxRuns are dropped.
+func (l *Log) Record(now time.Time, metadata findings.Metadata, all []findings.Finding, skipped map[string]string, runErr error) (*Run, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate run ID: %w", err)
+	}
+	run := &Run{
+		ID:       now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b),
+		Time:     now.UTC(),
+		Metadata: metadata,
+		Findings: make(map[findings.Severity]int),
+		Skipped:  skipped,
+	}
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
+	for _, f := range all {
+		run.Findings[f.Severity]++
+	}
+	if all == nil {
+		all = []findings.Finding{}
+	}
+
+	runs, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	// the findings first, an indexed run always has them
+	if err := l.store.Put(findingsKeyPrefix+run.ID, all); err != nil {
+		return nil, fmt.Errorf("failed to save findings of run %s: %w", run.ID, err)
+	}
+	runs = append(runs, *run)
+	var dropped []Run
+	if len(runs) > MaxRuns {
+		dropped = runs[:len(runs)-MaxRuns]
+		runs = runs[len(runs)-MaxRuns:]
+	}
+	if err := l.store.Put(indexKey, runs); err != nil {
+		return nil, fmt.Errorf("failed to save run log: %w", err)
+	}
+	for _, d := range dropped {
+		if err := l.store.Delete(findingsKeyPrefix + d.ID); err != nil {
+			fmt.Printf("WARNING: failed to delete findings of dropped run %s: %v\n", d.ID, err)
+		}
+	}
+	return run, nil
+}
+
+// List returns the recent runs, newest first.
+func (l *Log) List() ([]Run, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	runs, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+	return runs, nil
+}
+
+// Get returns the run and its findings, or state.ErrNotFound if the run
+// isn't in the log.
+func (l *Log) Get(id string) (*Run, []findings.Finding, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	runs, err := l.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range runs {
+		if runs[i].ID != id {
+			continue
+		}
+		var all []findings.Finding
+		if err := l.store.Get(findingsKeyPrefix+id, &all); err != nil {
+			return nil, nil, fmt.Errorf("failed to load findings of run %s: %w", id, err)
+		}
+		return &runs[i], all, nil
+	}
+	return nil, nil, state.ErrNotFound
+}
+
+// load reads the runs from the state store, oldest first. Runs recorded by
+// a newer velora, in a report schema this binary doesn't know, are refused.
+func (l *Log) load() ([]Run, error) {
+	var runs []Run
+	if err := l.store.Get(indexKey, &runs); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load run log: %w", err)
+	}
+	for i := range runs {
+		// runs recorded before the schema was versioned are in the first one
+		if runs[i].Metadata.SchemaVersion == 0 {
+			runs[i].Metadata.SchemaVersion = 1
+		}
+		if err := version.CheckReportSchemaVersion(runs[i].Metadata.SchemaVersion); err != nil {
+			return nil, fmt.Errorf("failed to load run %s: %w", runs[i].ID, err)
+		}
+	}
+	return runs, nil
+}
@@ -0,0 +1,79 @@
+package runlog
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/state"
+	"github.com/akos011221/velora/internal/version"
+)
+
+// newTestStore returns a state store in a temporary directory.
+func newTestStore(t *testing.T) state.Store {
+	t.Helper()
+	store, err := state.Open(configtest.New(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestLogRecord(t *testing.T) {
+	log := New(newTestStore(t))
+	all := []findings.Finding{{RuleID: findings.RuleDefaultRoute.ID, Severity: findings.SeverityHigh}}
+	recorded, err := log.Record(time.Now(), findings.Metadata{SchemaVersion: version.ReportSchemaVersion}, all, nil, errors.New("failed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run, got, err := log.Get(recorded.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if run.Error != "failed" || run.Total() != 1 || len(got) != 1 {
+		t.Errorf("Get() = %+v with %d findings, want the failed run with its finding", run, len(got))
+	}
+	if _, _, err := log.Get("unknown"); !errors.Is(err, state.ErrNotFound) {
+		t.Errorf("Get() of an unknown run error = %v, want %v", err, state.ErrNotFound)
+	}
+}
+
+func TestLogSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name          string
+		schemaVersion int
+		want          int
+		wantErr       string
+	}{
+		{name: "current", schemaVersion: version.ReportSchemaVersion, want: version.ReportSchemaVersion},
+		{name: "recorded before the schema was versioned", schemaVersion: 0, want: 1},
+		{name: "newer", schemaVersion: version.ReportSchemaVersion + 1, wantErr: "produced by newer velora"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			if err := store.Put(indexKey, []Run{{ID: "run", Metadata: findings.Metadata{SchemaVersion: tt.schemaVersion}}}); err != nil {
+				t.Fatal(err)
+			}
+
+			runs, err := New(store).List()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("List() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(runs) != 1 || runs[0].Metadata.SchemaVersion != tt.want {
+				t.Errorf("List() = %+v, want schema version %d", runs, tt.want)
+			}
+		})
+	}
+}
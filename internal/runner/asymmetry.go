@@ -0,0 +1,216 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/failover"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/guard"
+	"github.com/akos011221/velora/internal/inventory"
+)
+
+// reservedSubnets can't route through the NVA, they're left out of the
+// routing posture of a VNet.
+var reservedSubnets = []string{"GatewaySubnet", "AzureFirewallSubnet", "AzureFirewallManagementSubnet",
+	"AzureBastionSubnet", "RouteServerSubnet"}
+
+// maxNamedPeers bounds the spokes routed through the NVA an asymmetric
+// routing finding names, the others are counted.
+const maxNamedPeers = 3
+
+// Routing postures of a spoke VNet.
+const (
+	postureNVA     = "routed through the NVA"
+	posturePartial = "only partly routed through the NVA"
+	postureDirect  = "not routed through the NVA"
+)
+
+// spokePosture is the routing posture of a hub-connected spoke VNet.
+type spokePosture struct {
+	subscriptionID string
+	vnetID         string
+	name           string
+	posture        string
+	production     bool
+}
+
+// analyzeAsymmetricRouting reports the hub-connected spokes that don't
+// route through the NVA of their hub while other spokes of the hub do:
+// traffic between them passes the NVA one way only. It runs after the
+// controllers, on the routes they left. There is one finding per spoke not
+// routed through the NVA, naming the routed ones, rather than one per pair.
+// The severity is raised if either side hosts production workloads.
+func analyzeAsymmetricRouting(ctx context.Context, cfg *config.Config, g *guard.Guard, failovers *failover.Manager,
+	runInventory *inventory.RunInventory) []findings.Finding {
+	spokes := make(map[string][]spokePosture)
+	for _, subID := range cfg.SubscriptionIDs() {
+		if reason, err := g.SkipReason(subID); err != nil || reason != "" {
+			continue
+		}
+		if _, missing := g.HubMissing(subID); missing {
+			continue
+		}
+		subCFG := cfg.Subscriptions[subID]
+		hubCFG, err := failovers.ActiveHub(cfg, subCFG.HubName)
+		if err != nil || hubCFG.IsVirtualWAN() {
+			continue
+		}
+		postures, err := spokePostures(ctx, cfg, runInventory, subID, hubCFG)
+		if err != nil {
+			fmt.Printf("WARNING: asymmetric routing not analyzed for subscription %s: %v\n", subID, err)
+			continue
+		}
+		for i := range postures {
+			postures[i].production = subCFG.IsProduction()
+		}
+		spokes[hubCFG.Name] = append(spokes[hubCFG.Name], postures...)
+	}
+
+	hubNames := make([]string, 0, len(spokes))
+	for hubName := range spokes {
+		hubNames = append(hubNames, hubName)
+	}
+	sort.Strings(hubNames)
+
+	var result []findings.Finding
+	for _, hubName := range hubNames {
+		var routed []spokePosture
+		routedProduction := false
+		for _, spoke := range spokes[hubName] {
+			if spoke.posture == postureNVA {
+				routed = append(routed, spoke)
+				routedProduction = routedProduction || spoke.production
+			}
+		}
+		if len(routed) == 0 || len(routed) == len(spokes[hubName]) {
+			continue
+		}
+
+		names := make([]string, 0, maxNamedPeers)
+		for _, spoke := range routed[:min(len(routed), maxNamedPeers)] {
+			names = append(names, spoke.name)
+		}
+		peers := strings.Join(names, ", ")
+		if len(routed) > maxNamedPeers {
+			peers += fmt.Sprintf(" and %d more", len(routed)-maxNamedPeers)
+		}
+
+		for _, spoke := range spokes[hubName] {
+			if spoke.posture == postureNVA {
+				continue
+			}
+			f := findings.New(findings.RuleAsymmetricRouting, cfg.Rules, spoke.subscriptionID, spoke.vnetID,
+				fmt.Sprintf("VNet %s is %s while %d spokes of hub %s are: %s", spoke.name, spoke.posture, len(routed), hubName, peers),
+				map[string]string{
+					"vnet":    spoke.name,
+					"posture": spoke.posture,
+					"peers":   peers,
+					"hub":     hubName,
+				})
+			if spoke.production || routedProduction {
+				f.AdjustSeverity(1, "production workloads on one side of the asymmetric path")
+			}
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// spokePostures returns the routing posture of the VNets of the
+// subscription with a Connected peering to the hub. A subnet is routed
+// through the NVA if its route table sends 0.0.0.0/0 to a virtual
+// appliance. Subnets whose route table can't be read are left out.
+func spokePostures(ctx context.Context, cfg *config.Config, runInventory *inventory.RunInventory,
+	subscriptionID string, hubCFG *config.HubVNetConfig) ([]spokePosture, error) {
+	vnets, err := runInventory.VNets(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	routeTables := make(map[string]map[string]*armnetwork.RouteTable)
+	var postures []spokePosture
+	for _, vnet := range vnets {
+		if vnet.ID == nil || vnet.Name == nil || vnet.Properties == nil || cfg.IsHubVNet(*vnet.ID) ||
+			!peeredWith(vnet, hubCFG.VNetID) {
+			continue
+		}
+
+		subnets, routed := 0, 0
+		for _, subnet := range vnet.Properties.Subnets {
+			if subnet == nil || subnet.Name == nil || slices.Contains(reservedSubnets, *subnet.Name) {
+				continue
+			}
+			if subnet.Properties == nil || subnet.Properties.RouteTable == nil || subnet.Properties.RouteTable.ID == nil {
+				subnets++
+				continue
+			}
+			rtID := *subnet.Properties.RouteTable.ID
+			rtSubscriptionID := strings.ToLower(azure.SubscriptionIDOf(rtID))
+			listed, ok := routeTables[rtSubscriptionID]
+			if !ok {
+				// route tables shared from an unreadable subscription are left out
+				listed, _ = runInventory.RouteTables(ctx, rtSubscriptionID)
+				routeTables[rtSubscriptionID] = listed
+			}
+			rt, ok := listed[strings.ToLower(rtID)]
+			if !ok {
+				continue
+			}
+			subnets++
+			if routesToNVA(rt) {
+				routed++
+			}
+		}
+
+		posture := posturePartial
+		switch {
+		case subnets == 0:
+			continue
+		case routed == subnets:
+			posture = postureNVA
+		case routed == 0:
+			posture = postureDirect
+		}
+		postures = append(postures, spokePosture{subscriptionID: subscriptionID, vnetID: *vnet.ID, name: *vnet.Name, posture: posture})
+	}
+	return postures, nil
+}
+
+// peeredWith reports whether the VNet has a Connected peering with the remote VNet.
+func peeredWith(vnet *armnetwork.VirtualNetwork, remoteVNetID string) bool {
+	for _, peering := range vnet.Properties.VirtualNetworkPeerings {
+		if peering == nil || peering.Properties == nil || peering.Properties.RemoteVirtualNetwork == nil ||
+			peering.Properties.RemoteVirtualNetwork.ID == nil || peering.Properties.PeeringState == nil {
+			continue
+		}
+		if *peering.Properties.PeeringState == armnetwork.VirtualNetworkPeeringStateConnected &&
+			strings.EqualFold(*peering.Properties.RemoteVirtualNetwork.ID, remoteVNetID) {
+			return true
+		}
+	}
+	return false
+}
+
+// routesToNVA reports whether the route table sends 0.0.0.0/0 to a virtual appliance.
+func routesToNVA(rt *armnetwork.RouteTable) bool {
+	if rt.Properties == nil {
+		return false
+	}
+	for _, route := range rt.Properties.Routes {
+		if route == nil || route.Properties == nil || route.Properties.AddressPrefix == nil || route.Properties.NextHopType == nil {
+			continue
+		}
+		if *route.Properties.AddressPrefix == "0.0.0.0/0" &&
+			*route.Properties.NextHopType == armnetwork.RouteNextHopTypeVirtualAppliance {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/akos011221/velora/internal/breakglass"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// enterBreakGlass runs under break-glass mode if it is active: the run is
+// audited, stamped on the run record and, if it can write, reported with a
+// critical finding per subscription, so the owners are notified. Returns
+// nil if it isn't active.
+func (r *Runner) enterBreakGlass(result *Result) (*breakglass.Activation, error) {
+	var details []string
+	if r.guard.ReadOnly() {
+		details = append(details, "read-only")
+	}
+	if r.guard.Planning() {
+		details = append(details, "plan")
+	}
+	if r.queueItem != "" {
+		details = append(details, "queue item "+r.queueItem)
+	}
+	if r.scope != "" {
+		details = append(details, "scope "+r.scope)
+	}
+	details = append(details, fmt.Sprintf("%d subscriptions", len(r.cfg.Subscriptions)))
+
+	activation, err := breakglass.NewManager(r.store).RecordRun(strings.Join(details, ", "))
+	if err != nil {
+		return nil, err
+	}
+	if activation == nil {
+		return nil, nil
+	}
+
+	fmt.Printf("WARNING: BREAK-GLASS MODE, approvals, onboarding acknowledgment and maintenance windows are bypassed: %s\n", activation)
+	r.guard.SetBreakGlass(activation)
+	result.Metadata.BreakGlass = activation
+	// runs that can't write bypass nothing, scans in pipelines don't fail on it
+	if r.guard.ReadOnly() || r.guard.Planning() {
+		return activation, nil
+	}
+	for _, subID := range r.cfg.SubscriptionIDs() {
+		result.Findings = append(result.Findings, findings.New(findings.RuleBreakGlassActive, r.cfg.Rules,
+			subID, "/subscriptions/"+subID,
+			fmt.Sprintf("subscription enforced under %s", activation),
+			map[string]string{
+				"subscription": subID,
+				"setBy":        activation.SetBy,
+				"reason":       activation.Reason,
+				"expiresAt":    activation.ExpiresAt.Format(time.RFC3339),
+			}))
+	}
+	return activation, nil
+}
@@ -0,0 +1,38 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/inventory"
+	"github.com/akos011221/velora/internal/limits"
+)
+
+// checkHubCapacity measures the hubs with a design capacity, after the
+// controllers so the spokes they peered are counted. A finding is returned
+// per hub at the warning threshold of its capacity or past it. Hubs that
+// can't be read are left out with a warning.
+func checkHubCapacity(ctx context.Context, cfg *config.Config, hubCache *inventory.HubCache) ([]limits.HubUsage, []findings.Finding) {
+	var usages []limits.HubUsage
+	var result []findings.Finding
+	for i := range cfg.Hubs {
+		hubCFG := &cfg.Hubs[i]
+		if hubCFG.Capacity == nil || hubCFG.IsVirtualWAN() {
+			continue
+		}
+		hubInv, err := hubCache.Get(ctx, *hubCFG)
+		if err != nil {
+			fmt.Printf("WARNING: capacity of hub %s not checked: %v\n", hubCFG.Name, err)
+			continue
+		}
+		usage := limits.MeasureHub(cfg, hubCFG, hubInv)
+		usages = append(usages, usage)
+		warn := hubCFG.Capacity.EffectiveWarnUtilization(cfg.Limits.EffectiveWarnUtilization())
+		if f := limits.CheckHub(usage, warn, cfg.Rules); f != nil {
+			result = append(result, *f)
+		}
+	}
+	return usages, result
+}
@@ -0,0 +1,36 @@
+package runner
+
+import (
+	"fmt"
+
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// holdStagedConfig observes every subscription if the run loaded a staged
+// config, and reports it per subscription so the owners are notified of
+// the change waiting for activation.
+func (r *Runner) holdStagedConfig(result *Result) {
+	stage := r.stagedConfig
+	if stage == nil {
+		return
+	}
+
+	fmt.Printf("WARNING: %s is staged, subscriptions are only observed until it is activated\n", stage)
+	result.Metadata.StagedConfig = stage.Hash
+	impact := "not estimated"
+	if stage.Impact != nil {
+		impact = stage.Impact.String()
+	}
+	for _, subID := range r.cfg.SubscriptionIDs() {
+		r.guard.SetObserveOnly(subID, fmt.Sprintf("config %s is staged, activate it with velora config activate", stage.Hash))
+		result.Findings = append(result.Findings, findings.New(findings.RuleConfigStaged, r.cfg.Rules,
+			subID, "/subscriptions/"+subID,
+			fmt.Sprintf("config %s is staged, estimated impact %s", stage.Hash, impact),
+			map[string]string{
+				"subscription": subID,
+				"hash":         stage.Hash,
+				"activeHash":   stage.Replaces,
+				"impact":       impact,
+			}))
+	}
+}
@@ -0,0 +1,146 @@
+package runner
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/metrics"
+	"github.com/akos011221/velora/internal/runlog"
+)
+
+// withFaults injects the faults into the runs, which are recorded in the
+// run log.
+func withFaults(faults ...config.FaultInjectionRule) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Testing.FaultInjection = &config.FaultInjectionConfig{Enabled: true, Seed: 1, Faults: faults}
+		cfg.API.UIEnabled = true
+	}
+}
+
+func TestRunRetriesThrottledReads(t *testing.T) {
+	cfg := configtest.New(t, withFaults(config.FaultInjectionRule{
+		ResourcePattern: `/providers/Microsoft.Network/routeTables$`,
+		Method:          "GET",
+		NthRequest:      2,
+		StatusCode:      429,
+	}))
+	arm := newTestARM([]string{configtest.SubscriptionID})
+
+	result, err := newTestRunner(t, cfg, arm).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if writes := routeWrites(arm); len(writes) != 1 {
+		t.Errorf("route writes = %v, want the default route", writes)
+	}
+	if len(result.Skipped) != 0 {
+		t.Errorf("skipped subscriptions = %v", result.Skipped)
+	}
+}
+
+func TestRunContinuesPastFailingSubscription(t *testing.T) {
+	_, secondRouteTable := spoke(secondSubscriptionID)
+	tests := []struct {
+		name            string
+		fault           config.FaultInjectionRule
+		wantDisappeared []string
+	}{
+		{
+			name: "route table deleted during the run",
+			fault: config.FaultInjectionRule{
+				ResourcePattern: "^" + regexp.QuoteMeta(secondRouteTable) + "/",
+				Method:          "PUT",
+				Probability:     1,
+				StatusCode:      404,
+			},
+			wantDisappeared: []string{secondRouteTable},
+		},
+		{
+			name: "permissions not readable",
+			fault: config.FaultInjectionRule{
+				ResourcePattern: "^/subscriptions/" + secondSubscriptionID + "/providers/Microsoft.Authorization/permissions$",
+				Probability:     1,
+				StatusCode:      500,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t, withSecondSpoke, withFaults(tt.fault))
+			arm := newTestARM([]string{configtest.SubscriptionID, secondSubscriptionID})
+
+			result, err := newTestRunner(t, cfg, arm).Run(context.Background())
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			_, firstRouteTable := spoke(configtest.SubscriptionID)
+			if writes := routeWrites(arm); len(writes) != 1 || !strings.HasPrefix(writes[0], "PUT "+firstRouteTable+"/") {
+				t.Errorf("route writes = %v, want the default route of the first spoke only", writes)
+			}
+			if !reflect.DeepEqual(result.Disappeared, tt.wantDisappeared) {
+				t.Errorf("disappeared = %v, want %v", result.Disappeared, tt.wantDisappeared)
+			}
+		})
+	}
+}
+
+func TestRunRecordsFailedWrites(t *testing.T) {
+	tests := []struct {
+		name      string
+		fault     config.FaultInjectionRule
+		timeout   time.Duration
+		wantClass string
+	}{
+		{
+			name:      "server error",
+			fault:     config.FaultInjectionRule{ResourcePattern: `/routes/`, Method: "PUT", Probability: 1, StatusCode: 500},
+			timeout:   time.Minute,
+			wantClass: metrics.ClassOther,
+		},
+		{
+			name:      "request never completes",
+			fault:     config.FaultInjectionRule{ResourcePattern: `/routes/`, Method: "PUT", Probability: 1, Hang: true},
+			timeout:   time.Second,
+			wantClass: metrics.ClassTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t, withFaults(tt.fault))
+			arm := newTestARM([]string{configtest.SubscriptionID})
+			runner := newTestRunner(t, cfg, arm)
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+
+			result, err := runner.Run(ctx)
+			if err == nil {
+				t.Fatal("Run() succeeded, want the failed write")
+			}
+			if class := metrics.ErrorClass(err); class != tt.wantClass {
+				t.Errorf("ErrorClass(%v) = %s, want %s", err, class, tt.wantClass)
+			}
+			if result == nil || len(result.Findings) == 0 {
+				t.Errorf("Run() result = %+v, want the findings recorded before the failure", result)
+			}
+			if writes := arm.Writes(); len(writes) != 0 {
+				t.Errorf("writes reaching ARM = %v", writes)
+			}
+
+			runs, err := runlog.New(runner.store).List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(runs) != 1 || runs[0].Error == "" {
+				t.Errorf("run log = %+v, want the failed run", runs)
+			}
+		})
+	}
+}
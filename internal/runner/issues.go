@@ -0,0 +1,32 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akos011221/velora/internal/issues"
+)
+
+// exportIssues files the findings of a completed run in the configured
+// issue trackers. Plans file nothing. Trackers failing never fail the run.
+func (r *Runner) exportIssues(ctx context.Context, result *Result) {
+	cfg := r.cfg.Notifications.Issues
+	if cfg == nil || r.guard.Planning() {
+		return
+	}
+
+	exporter := issues.NewExporter(*cfg, r.store, r.clientFactory.GetCredential())
+	outcomes, err := exporter.Export(ctx, result.Findings, r.evaluated(result))
+	if err != nil {
+		fmt.Println("WARNING: issues not filed:", err)
+	}
+	for _, o := range outcomes {
+		if !cfg.DryRun {
+			fmt.Printf("filed findings in %s: %d created, %d updated, %d closed, %d unchanged\n", o.Tracker, o.Created, o.Updated, o.Closed, o.Unchanged)
+		}
+		if o.Error != "" {
+			fmt.Printf("WARNING: %d findings not filed in %s: %s\n", o.Failed, o.Tracker, o.Error)
+		}
+	}
+	result.Issues = outcomes
+}
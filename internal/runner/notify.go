@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akos011221/velora/internal/metrics"
+	"github.com/akos011221/velora/internal/notifications"
+)
+
+// notify sends the findings of a completed run through the configured
+// channels, throttled if configured. Plans notify nothing. Notifications
+// never fail the run.
+func (r *Runner) notify(ctx context.Context, result *Result) {
+	if r.cfg.Notifications.Email == nil || r.guard.Planning() {
+		return
+	}
+
+	notifier := notifications.NewEmailNotifier(*r.cfg.Notifications.Email, r.cfg.DisplayLocation())
+	if r.cfg.Notifications.Throttle != nil {
+		notifier.SetThrottle(notifications.NewThrottle(r.store, r.cfg.Notifications.Throttle))
+	}
+	outcome, err := notifier.NotifyRun(ctx, result.Findings, r.evaluated(result))
+	if err != nil {
+		fmt.Println("WARNING: notification not sent:", err)
+	}
+	suppressed := 0
+	for _, n := range outcome.Suppressed {
+		suppressed += n
+	}
+	if outcome.Alerted+outcome.Resolved+suppressed == 0 {
+		return
+	}
+	result.Notifications = &outcome
+	fmt.Printf("notified %d findings and %d resolved, suppressed %d notified recently\n", outcome.Alerted, outcome.Resolved, suppressed)
+
+	if suppressed == 0 || r.cfg.Metrics.TextfilePath == "" {
+		return
+	}
+	if err := metrics.Update(r.store, func(snapshot *metrics.Snapshot) {
+		for severity, n := range outcome.Suppressed {
+			snapshot.NotificationsSuppressed[string(severity)] += n
+		}
+	}); err != nil {
+		fmt.Println("WARNING: metrics not written:", err)
+		return
+	}
+	if err := metrics.Refresh(r.store, r.cfg.Metrics.TextfilePath); err != nil {
+		fmt.Println("WARNING: metrics not written:", err)
+	}
+}
@@ -0,0 +1,263 @@
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/onboarding"
+	"github.com/akos011221/velora/internal/scoring"
+)
+
+// OutputVersion is the version of the scan output schema. It must be bumped
+// when a field is removed or changes meaning, new fields are compatible.
+const OutputVersion = 1
+
+// Exit codes of velora scan, they are a stable contract for pipelines.
+const (
+	// ExitCompliant means no finding reached the failure threshold.
+	ExitCompliant = 0
+	// ExitError means the scan failed to run.
+	ExitError = 1
+	// ExitFindings means findings reached the failure threshold.
+	ExitFindings = 2
+	// ExitPartial means some subscriptions weren't scanned and no finding
+	// reached the failure threshold in the others.
+	ExitPartial = 3
+)
+
+// Output is the JSON output of velora scan:
+//
+//	{
+//	  "outputVersion": 1,
+//	  "metadata": {"veloraVersion": "v1.2.3", "configHash": "...", ...},
+//	  "summary": {
+//	    "exitCode": 2,
+//	    "compliant": false,
+//	    "partial": false,
+//	    "failOn": "high",
+//	    "severities": {"critical": 0, "high": 1, "medium": 0, "low": 2, "info": 0},
+//	    "failedRules": ["routing/default-route"]
+//	  },
+//	  "scope": "/subscriptions/.../resourceGroups/rg-spoke",
+//	  "skipped": {"<subscription ID>": "<reason>"},
+//	  "disappeared": ["<resource ID>"],
+//	  "blockedByPolicy": [{"assignmentId": "...", "assignmentName": "...", "portalUrl": "...", "blocked": 47}],
+//	  "pendingAcknowledgment": [{"subscriptionId": "...", "observeRuns": 2, "findings": {"high": 3}, ...}],
+//	  "reads": {"arm": 42, "inventory": {"lists": 6, "reused": 18, "evicted": 0, "uncached": 0}, "hubCache": {"hits": 9, "misses": 2}},
+//	  "latencies": [{"operation": "routeTables/list", "count": 3, "p50Ms": 210.5, "p95Ms": 480.2, "maxMs": 480.2, "errorRate": 0}],
+//	  "findings": [{"ruleId": "...", "severity": "high", ...}]
+//	}
+//
+// Every severity is always present in summary.severities and failedRules is
+// never null, so jq expressions don't need defaults.
+type Output struct {
+	OutputVersion int               `json:"outputVersion"`
+	Metadata      findings.Metadata `json:"metadata"`
+	Summary       OutputSummary     `json:"summary"`
+	Scope         string            `json:"scope,omitempty"`
+	Skipped       map[string]string `json:"skipped"`
+	// Disappeared are the resources deleted while the scan evaluated them.
+	Disappeared []string `json:"disappeared,omitempty"`
+	// BlockedByPolicy counts the writes Azure Policy refused per assignment.
+	BlockedByPolicy []PolicyBlocked `json:"blockedByPolicy,omitempty"`
+	// PendingAcknowledgment are the new subscriptions only observed until
+	// their findings are acknowledged.
+	PendingAcknowledgment []*onboarding.Record `json:"pendingAcknowledgment,omitempty"`
+	// Reads are the ARM reads of the scan, nil if no controller ran.
+	Reads *Reads `json:"reads,omitempty"`
+	// Latencies summarize the ARM requests of the scan per operation.
+	Latencies []azure.OperationLatency `json:"latencies,omitempty"`
+	// ListPages are the list pages of the scan per operation.
+	ListPages azure.ListPages `json:"listPages,omitempty"`
+	// Scores are the posture scores of the evaluated subscriptions.
+	Scores   map[string]scoring.Score `json:"scores,omitempty"`
+	Findings []findings.Finding       `json:"findings"`
+}
+
+// OutputSummary is the machine-readable summary block of the scan output.
+type OutputSummary struct {
+	ExitCode  int               `json:"exitCode"`
+	Compliant bool              `json:"compliant"`
+	Partial   bool              `json:"partial"`
+	FailOn    findings.Severity `json:"failOn"`
+	// Severities counts the findings per severity.
+	Severities map[findings.Severity]int `json:"severities"`
+	// FailedRules are the IDs of the rules with findings at or above FailOn.
+	FailedRules []string `json:"failedRules"`
+}
+
+// NewOutput builds the scan output of a completed run. Findings at or above
+// failOn fail the scan.
+func NewOutput(result *Result, failOn findings.Severity, scope string) *Output {
+	summarizer := NewSummarizer(failOn)
+	for _, f := range result.Findings {
+		summarizer.Add(f)
+	}
+	out := NewStreamedOutput(result, summarizer.Summary(len(result.Skipped) > 0), scope)
+	out.Findings = result.Findings
+	if out.Findings == nil {
+		out.Findings = []findings.Finding{}
+	}
+	return out
+}
+
+// NewStreamedOutput builds the scan output of a completed run whose findings
+// were written as they were added: everything but the findings.
+func NewStreamedOutput(result *Result, summary OutputSummary, scope string) *Output {
+	skipped := result.Skipped
+	if skipped == nil {
+		skipped = map[string]string{}
+	}
+	return &Output{
+		OutputVersion:         OutputVersion,
+		Metadata:              result.Metadata,
+		Summary:               summary,
+		Scope:                 scope,
+		Skipped:               skipped,
+		Disappeared:           result.Disappeared,
+		BlockedByPolicy:       result.BlockedByPolicy,
+		PendingAcknowledgment: result.PendingAcknowledgment,
+		Reads:                 result.Reads,
+		Latencies:             result.Latencies.Table(),
+		ListPages:             result.ListPages,
+		Scores:                result.Scores,
+	}
+}
+
+// Summarizer counts the findings of a scan output as they are added, the
+// summary is exact however many findings were streamed.
+type Summarizer struct {
+	failOn     findings.Severity
+	severities map[findings.Severity]int
+	failed     map[string]bool
+}
+
+// NewSummarizer creates a summarizer failing the scan on findings at or
+// above failOn.
+func NewSummarizer(failOn findings.Severity) *Summarizer {
+	return &Summarizer{
+		failOn: failOn,
+		severities: map[findings.Severity]int{
+			findings.SeverityCritical: 0,
+			findings.SeverityHigh:     0,
+			findings.SeverityMedium:   0,
+			findings.SeverityLow:      0,
+			findings.SeverityInfo:     0,
+		},
+		failed: make(map[string]bool),
+	}
+}
+
+// Add counts the finding.
+func (s *Summarizer) Add(f findings.Finding) {
+	s.severities[f.Severity]++
+	if f.Severity.AtLeast(s.failOn) {
+		s.failed[f.RuleID] = true
+	}
+}
+
+// Summary returns the summary of the findings added. partial is set if some
+// subscriptions weren't scanned.
+func (s *Summarizer) Summary(partial bool) OutputSummary {
+	summary := OutputSummary{
+		FailOn:      s.failOn,
+		Severities:  make(map[findings.Severity]int, len(s.severities)),
+		FailedRules: make([]string, 0, len(s.failed)),
+		Partial:     partial,
+	}
+	for severity, n := range s.severities {
+		summary.Severities[severity] = n
+	}
+	for ruleID := range s.failed {
+		summary.FailedRules = append(summary.FailedRules, ruleID)
+	}
+	sort.Strings(summary.FailedRules)
+
+	summary.Compliant = len(summary.FailedRules) == 0
+	switch {
+	case !summary.Compliant:
+		summary.ExitCode = ExitFindings
+	case summary.Partial:
+		summary.ExitCode = ExitPartial
+	default:
+		summary.ExitCode = ExitCompliant
+	}
+	return summary
+}
+
+// OutputWriter writes the scan output incrementally, so the output of a
+// large scan is never held in memory as a whole: findings are written as
+// they are added, the rest of the output once the run is complete.
+type OutputWriter interface {
+	// Add writes a finding.
+	Add(f findings.Finding) error
+	// Flush writes the rest of the output of the completed run, and returns
+	// its summary.
+	Flush(result *Result) (OutputSummary, error)
+}
+
+// jsonOutputWriter writes the scan output as the JSON document of Output.
+// The findings come first, the summary once they are all counted.
+type jsonOutputWriter struct {
+	w          *bufio.Writer
+	scope      string
+	summarizer *Summarizer
+	added      int
+}
+
+// NewJSONOutputWriter creates a writer of the JSON scan output. Findings at
+// or above failOn fail the scan.
+func NewJSONOutputWriter(w io.Writer, failOn findings.Severity, scope string) OutputWriter {
+	return &jsonOutputWriter{w: bufio.NewWriter(w), scope: scope, summarizer: NewSummarizer(failOn)}
+}
+
+// Add writes a finding.
+func (j *jsonOutputWriter) Add(f findings.Finding) error {
+	j.summarizer.Add(f)
+	if j.added == 0 {
+		fmt.Fprintf(j.w, "{\n  \"outputVersion\": %d,\n  \"findings\": [\n    ", OutputVersion)
+	} else {
+		j.w.WriteString(",\n    ")
+	}
+	j.added++
+	data, err := json.MarshalIndent(f, "    ", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode finding: %w", err)
+	}
+	_, err = j.w.Write(data)
+	return err
+}
+
+// Flush writes the rest of the output and returns its summary.
+func (j *jsonOutputWriter) Flush(result *Result) (OutputSummary, error) {
+	summary := j.summarizer.Summary(len(result.Skipped) > 0)
+	if j.added == 0 {
+		fmt.Fprintf(j.w, "{\n  \"outputVersion\": %d,\n  \"findings\": [", OutputVersion)
+	} else {
+		j.w.WriteString("\n  ")
+	}
+	j.w.WriteString("]")
+
+	// the fields of Output but the version and the findings, which the
+	// empty fields shadow
+	data, err := json.MarshalIndent(struct {
+		*Output
+		OutputVersion *int               `json:"outputVersion,omitempty"`
+		Findings      []findings.Finding `json:"findings,omitempty"`
+	}{Output: NewStreamedOutput(result, summary, j.scope)}, "", "  ")
+	if err != nil {
+		return summary, fmt.Errorf("failed to encode output: %w", err)
+	}
+	j.w.WriteString(",")
+	j.w.Write(data[1:])
+	j.w.WriteString("\n")
+	if err := j.w.Flush(); err != nil {
+		return summary, fmt.Errorf("failed to write output: %w", err)
+	}
+	return summary, nil
+}
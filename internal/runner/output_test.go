@@ -0,0 +1,140 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// subnetFinding returns the finding of the i-th subnet of a synthetic
+// inventory, the severities and rules vary with i.
+func subnetFinding(i int) findings.Finding {
+	rule := findings.RuleDefaultRoute
+	if i%3 == 0 {
+		rule = findings.RuleNSGMissing
+	}
+	vnetID := fmt.Sprintf("/subscriptions/00000000-0000-0000-0000-000000000002/resourceGroups/spoke-rg/providers/Microsoft.Network/virtualNetworks/vnet%d", i/100)
+	return findings.Finding{
+		RuleID:         rule.ID,
+		Severity:       []findings.Severity{findings.SeverityHigh, findings.SeverityMedium, findings.SeverityLow}[i%3],
+		SubscriptionID: "00000000-0000-0000-0000-000000000002",
+		ResourceID:     fmt.Sprintf("%s/subnets/subnet%d", vnetID, i),
+		Message:        fmt.Sprintf("subnet subnet%d has no default route to the NVA", i),
+		Remediation:    rule.Fallback,
+	}
+}
+
+func TestJSONOutputWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		findings int
+		skipped  map[string]string
+	}{
+		{name: "no findings"},
+		{name: "findings", findings: 10},
+		{name: "partial", findings: 3, skipped: map[string]string{"00000000-0000-0000-0000-000000000003": "paused"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &Result{Skipped: tt.skipped, Disappeared: []string{"/gone"}}
+			for i := 0; i < tt.findings; i++ {
+				result.Findings = append(result.Findings, subnetFinding(i))
+			}
+
+			var out bytes.Buffer
+			w := NewJSONOutputWriter(&out, findings.SeverityMedium, "scope")
+			for _, f := range result.Findings {
+				if err := w.Add(f); err != nil {
+					t.Fatal(err)
+				}
+			}
+			summary, err := w.Flush(result)
+			if err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+
+			// the streamed document is the output built at once, but for the
+			// order of its fields
+			var got, want Output
+			if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+				t.Fatalf("streamed output isn't JSON: %v\n%s", err, out.String())
+			}
+			data, _ := json.Marshal(NewOutput(result, findings.SeverityMedium, "scope"))
+			if err := json.Unmarshal(data, &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("streamed output = %+v, want %+v", got, want)
+			}
+			if !reflect.DeepEqual(summary, want.Summary) {
+				t.Errorf("Flush() summary = %+v, want %+v", summary, want.Summary)
+			}
+		})
+	}
+}
+
+// streamedSubnets is the size of the synthetic inventory streamed by
+// TestJSONOutputWriterMemory, and maxStreamingHeap the bound of the heap
+// while its findings are written. Holding the findings alone takes more.
+const (
+	streamedSubnets  = 50000
+	maxStreamingHeap = 16 << 20
+)
+
+func TestJSONOutputWriterMemory(t *testing.T) {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	baseline := stats.HeapAlloc
+
+	w := NewJSONOutputWriter(io.Discard, findings.SeverityMedium, "")
+	var peak uint64
+	for i := 0; i < streamedSubnets; i++ {
+		if err := w.Add(subnetFinding(i)); err != nil {
+			t.Fatal(err)
+		}
+		if i%1000 == 0 {
+			runtime.ReadMemStats(&stats)
+			peak = max(peak, stats.HeapAlloc)
+		}
+	}
+	summary, err := w.Flush(&Result{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the counts are exact however many findings were streamed
+	third := streamedSubnets / 3
+	wantSeverities := map[findings.Severity]int{findings.SeverityHigh: third + 1, findings.SeverityMedium: third + 1, findings.SeverityLow: third}
+	for severity, want := range wantSeverities {
+		if summary.Severities[severity] != want {
+			t.Errorf("%s findings = %d, want %d", severity, summary.Severities[severity], want)
+		}
+	}
+	if peak > baseline && peak-baseline > maxStreamingHeap {
+		t.Errorf("heap while streaming %d findings = %d MB above the baseline, want at most %d MB",
+			streamedSubnets, (peak-baseline)>>20, maxStreamingHeap>>20)
+	}
+}
+
+func BenchmarkJSONOutputWriter(b *testing.B) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		w := NewJSONOutputWriter(io.Discard, findings.SeverityMedium, "")
+		for i := 0; i < 1000; i++ {
+			if err := w.Add(subnetFinding(i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if _, err := w.Flush(&Result{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
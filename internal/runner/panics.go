@@ -0,0 +1,135 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/guard"
+	"github.com/akos011221/velora/internal/metrics"
+	"github.com/akos011221/velora/internal/redact"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// Circuit breaker of the subscriptions whose controllers keep panicking.
+const (
+	// PanicBreakerThreshold is the number of consecutive runs with a
+	// controller panic after which a subscription is skipped.
+	PanicBreakerThreshold = 3
+	// PanicBreakerCooldown is how long the subscription is skipped, the run
+	// after it tries again and skips it right away if it panics again.
+	PanicBreakerCooldown = time.Hour
+)
+
+// panicBreakerKey is the state store key of the panic breakers.
+const panicBreakerKey = "panic-breakers"
+
+// panicBreaker is the breaker of a subscription.
+type panicBreaker struct {
+	// ConsecutiveRuns counts the runs in a row a controller panicked for
+	// the subscription.
+	ConsecutiveRuns int       `json:"consecutiveRuns"`
+	LastPanic       time.Time `json:"lastPanic"`
+	// OpenUntil is when the subscription is enforced again, zero while the
+	// breaker is closed.
+	OpenUntil time.Time `json:"openUntil,omitempty"`
+}
+
+// loadPanicBreakers reads the breakers by subscription ID.
+func (r *Runner) loadPanicBreakers() (map[string]*panicBreaker, error) {
+	breakers := make(map[string]*panicBreaker)
+	if err := r.store.Get(panicBreakerKey, &breakers); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load panic breakers: %w", err)
+	}
+	return breakers, nil
+}
+
+// openPanicBreakers skips the subscriptions whose breaker is open.
+func (r *Runner) openPanicBreakers(now time.Time) error {
+	breakers, err := r.loadPanicBreakers()
+	if err != nil {
+		return err
+	}
+	for subID, breaker := range breakers {
+		if now.Before(breaker.OpenUntil) {
+			r.guard.SetSkipped(subID, fmt.Sprintf("controllers panicked in %d consecutive runs, skipped until %s",
+				breaker.ConsecutiveRuns, breaker.OpenUntil.Format(time.RFC3339)))
+		}
+	}
+	return nil
+}
+
+// recordPanics adds a finding per controller panic of the run and updates
+// the breakers: a subscription that panicked counts one more run, one that
+// was evaluated without panicking is closed again. evaluated is nil for
+// failed runs, they close nothing. The breakers never fail the run.
+func (r *Runner) recordPanics(result *Result, evaluated func(subscriptionID string) bool, now time.Time) {
+	panics := r.guard.Panics()
+	panicked := make(map[string]bool)
+	for _, p := range panics {
+		panicked[p.SubscriptionID] = true
+		result.Findings = append(result.Findings, findings.New(findings.RuleControllerPanic, r.cfg.Rules,
+			p.SubscriptionID, "/subscriptions/"+p.SubscriptionID,
+			fmt.Sprintf("%s controller panicked: %s", p.Controller, p.Value),
+			map[string]string{
+				"controller":   p.Controller,
+				"subscription": p.SubscriptionID,
+				"panic":        p.Value,
+			}))
+	}
+
+	breakers, err := r.loadPanicBreakers()
+	if err != nil {
+		fmt.Println("WARNING: panic breakers not updated:", err)
+		return
+	}
+	changed := false
+	for subID := range panicked {
+		breaker := breakers[subID]
+		if breaker == nil {
+			breaker = &panicBreaker{}
+			breakers[subID] = breaker
+		}
+		breaker.ConsecutiveRuns++
+		breaker.LastPanic = now
+		if breaker.ConsecutiveRuns >= PanicBreakerThreshold {
+			breaker.OpenUntil = now.Add(PanicBreakerCooldown)
+			fmt.Printf("WARNING: controllers panicked for subscription %s in %d consecutive runs, skipping it until %s\n",
+				subID, breaker.ConsecutiveRuns, breaker.OpenUntil.Format(time.RFC3339))
+		}
+		changed = true
+	}
+	for subID := range breakers {
+		if !panicked[subID] && evaluated != nil && evaluated(subID) {
+			delete(breakers, subID)
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := r.store.Put(panicBreakerKey, breakers); err != nil {
+		fmt.Println("WARNING: panic breakers not updated:", err)
+	}
+}
+
+// recoverRun turns a panic of the run outside the controllers into an
+// error, so the process serving other runs stays up.
+func (r *Runner) recoverRun(value any) error {
+	message := redact.String(fmt.Sprint(value))
+	fmt.Printf("ERROR: run panicked: %s\n%s", message, guard.SanitizeStack(debug.Stack()))
+	if r.cfg.Metrics.TextfilePath != "" {
+		err := metrics.Update(r.store, func(snapshot *metrics.Snapshot) {
+			snapshot.ControllerPanics["run"]++
+		})
+		if err == nil {
+			err = metrics.Refresh(r.store, r.cfg.Metrics.TextfilePath)
+		}
+		if err != nil {
+			fmt.Println("WARNING: metrics not written:", err)
+		}
+	}
+	return fmt.Errorf("run panicked: %s", message)
+}
@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/metrics"
+)
+
+// panicOnVNets makes listing the VNets of the subscription panic, like a
+// nil dereference in the controller reading them.
+func panicOnVNets(arm *azuretest.Server, subscriptionID string) {
+	arm.Handle(http.MethodGet, "/subscriptions/"+subscriptionID+"/providers/Microsoft.Network/virtualNetworks", func(http.ResponseWriter, *http.Request) {
+		var vnet *struct{ Name string }
+		_ = vnet.Name
+	})
+}
+
+func TestRunControllerPanic(t *testing.T) {
+	cfg := configtest.New(t, withSecondSpoke, func(cfg *config.Config) {
+		cfg.Metrics.TextfilePath = t.TempDir() + "/velora.prom"
+	})
+	_, healthyRouteTable := spoke(secondSubscriptionID)
+
+	for run := 1; run <= PanicBreakerThreshold; run++ {
+		arm := newTestARM([]string{configtest.SubscriptionID, secondSubscriptionID})
+		panicOnVNets(arm, configtest.SubscriptionID)
+		runner := newTestRunner(t, cfg, arm)
+
+		// the run completes for the other subscription
+		result, err := runner.Run(context.Background())
+		if err != nil {
+			t.Fatalf("run %d: Run() error = %v", run, err)
+		}
+		if writes := routeWrites(arm); len(writes) != 1 || !strings.HasPrefix(writes[0], "PUT "+healthyRouteTable+"/") {
+			t.Errorf("run %d: route writes = %v, want the default route of the healthy subscription", run, writes)
+		}
+		var panics []findings.Finding
+		for _, f := range result.Findings {
+			if f.RuleID == findings.RuleControllerPanic.ID {
+				panics = append(panics, f)
+			}
+		}
+		if len(panics) != 1 || panics[0].SubscriptionID != configtest.SubscriptionID || !strings.Contains(panics[0].Message, "nil pointer dereference") {
+			t.Errorf("run %d: panic findings = %+v, want the panic of %s", run, panics, configtest.SubscriptionID)
+		}
+		if _, skipped := result.Skipped[configtest.SubscriptionID]; !skipped {
+			t.Errorf("run %d: skipped = %v, want the subscription that panicked", run, result.Skipped)
+		}
+	}
+
+	snapshot, err := metrics.Load(newTestRunner(t, cfg, azuretest.NewServer()).store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshot.ControllerPanics["routing"]; got != PanicBreakerThreshold {
+		t.Errorf("routing controller panics = %d, want %d", got, PanicBreakerThreshold)
+	}
+
+	// the breaker is open, the subscription isn't read at all
+	arm := newTestARM([]string{configtest.SubscriptionID, secondSubscriptionID})
+	panicOnVNets(arm, configtest.SubscriptionID)
+	runner := newTestRunner(t, cfg, arm)
+	result, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() with the breaker open error = %v", err)
+	}
+	if reason := result.Skipped[configtest.SubscriptionID]; !strings.Contains(reason, "panicked in 3 consecutive runs") {
+		t.Errorf("skipped = %v, want the subscription skipped by the breaker", result.Skipped)
+	}
+	for _, req := range arm.Requests() {
+		if strings.HasPrefix(req.Path, "/subscriptions/"+configtest.SubscriptionID+"/providers/Microsoft.Network/virtualNetworks") {
+			t.Errorf("request %s with the breaker open, want none", req)
+		}
+	}
+
+	// after the cooldown, a run without a panic closes the breaker
+	breakers, err := runner.loadPanicBreakers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	breakers[configtest.SubscriptionID].OpenUntil = time.Now().Add(-time.Minute)
+	if err := runner.store.Put(panicBreakerKey, breakers); err != nil {
+		t.Fatal(err)
+	}
+	arm = newTestARM([]string{configtest.SubscriptionID, secondSubscriptionID})
+	runner = newTestRunner(t, cfg, arm)
+	if result, err = runner.Run(context.Background()); err != nil {
+		t.Fatalf("Run() after the cooldown error = %v", err)
+	}
+	if _, skipped := result.Skipped[configtest.SubscriptionID]; skipped {
+		t.Errorf("skipped = %v, want the subscription enforced again", result.Skipped)
+	}
+	if breakers, _ = runner.loadPanicBreakers(); len(breakers) != 0 {
+		t.Errorf("breakers = %+v, want none", breakers)
+	}
+}
@@ -0,0 +1,76 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// PolicyBlocked counts the remediations an Azure Policy assignment blocked,
+// so a single exemption can be requested for all of them.
+type PolicyBlocked struct {
+	AssignmentID   string `json:"assignmentId"`
+	AssignmentName string `json:"assignmentName"`
+	PortalURL      string `json:"portalUrl,omitempty"`
+	Blocked        int    `json:"blocked"`
+}
+
+// CountPolicyDenials counts the denials per assignment, most blocking first.
+func CountPolicyDenials(denials []*azure.PolicyDenial) []PolicyBlocked {
+	byAssignment := make(map[string]*PolicyBlocked)
+	var result []*PolicyBlocked
+	for _, denial := range denials {
+		key := denial.AssignmentID
+		if key == "" {
+			key = denial.Name()
+		}
+		counted, ok := byAssignment[key]
+		if !ok {
+			counted = &PolicyBlocked{AssignmentID: denial.AssignmentID, AssignmentName: denial.Name()}
+			if denial.AssignmentID != "" {
+				counted.PortalURL = denial.PortalURL()
+			}
+			byAssignment[key] = counted
+			result = append(result, counted)
+		}
+		counted.Blocked++
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Blocked > result[j].Blocked
+	})
+
+	counts := make([]PolicyBlocked, 0, len(result))
+	for _, counted := range result {
+		counts = append(counts, *counted)
+	}
+	return counts
+}
+
+// recordPolicyBlocks adds a finding per write Azure Policy refused to the
+// result, and their counts per assignment.
+func (r *Runner) recordPolicyBlocks(result *Result) {
+	blocks := r.guard.PolicyBlocks()
+	denials := make([]*azure.PolicyDenial, 0, len(blocks))
+	for _, block := range blocks {
+		denials = append(denials, block.Denial)
+		link := block.Denial.AssignmentID
+		if link != "" {
+			link = block.Denial.PortalURL()
+		}
+		result.Findings = append(result.Findings, findings.New(findings.RuleBlockedByPolicy, r.cfg.Rules,
+			block.SubscriptionID, block.ResourceID,
+			fmt.Sprintf("remediation blocked by Azure Policy %s", block.Denial.Name()),
+			map[string]string{
+				"resource":   block.ResourceID,
+				"assignment": block.Denial.Name(),
+				"link":       link,
+			}))
+	}
+	result.BlockedByPolicy = CountPolicyDenials(denials)
+	for _, counted := range result.BlockedByPolicy {
+		fmt.Printf("WARNING: Azure Policy %s blocked %d remediations, an exemption covers them all: %s\n",
+			counted.AssignmentName, counted.Blocked, counted.AssignmentID)
+	}
+}
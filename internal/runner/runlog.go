@@ -0,0 +1,21 @@
+package runner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/akos011221/velora/internal/runlog"
+)
+
+// recordRun keeps the run and its findings in the run log the web UI
+// browses, if the UI is enabled. Plans aren't runs. The run log never fails
+// the run.
+func (r *Runner) recordRun(result *Result, runErr error) {
+	if !r.cfg.API.UIEnabled || r.guard.Planning() || result == nil {
+		return
+	}
+
+	if _, err := runlog.New(r.store).Record(time.Now(), result.Metadata, result.Findings, result.Skipped, runErr); err != nil {
+		fmt.Println("WARNING: run not recorded in the run log:", err)
+	}
+}
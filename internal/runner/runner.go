@@ -0,0 +1,717 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/attribution"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/configstage"
+	"github.com/akos011221/velora/internal/controllers/egress"
+	"github.com/akos011221/velora/internal/controllers/flowlogs"
+	"github.com/akos011221/velora/internal/controllers/gateways"
+	"github.com/akos011221/velora/internal/controllers/nsg"
+	"github.com/akos011221/velora/internal/controllers/peering"
+	"github.com/akos011221/velora/internal/controllers/routing"
+	"github.com/akos011221/velora/internal/controllers/vwan"
+	"github.com/akos011221/velora/internal/failover"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/flapping"
+	"github.com/akos011221/velora/internal/grace"
+	"github.com/akos011221/velora/internal/guard"
+	"github.com/akos011221/velora/internal/hubdiscovery"
+	"github.com/akos011221/velora/internal/inventory"
+	"github.com/akos011221/velora/internal/issues"
+	"github.com/akos011221/velora/internal/limits"
+	"github.com/akos011221/velora/internal/managed"
+	"github.com/akos011221/velora/internal/metrics"
+	"github.com/akos011221/velora/internal/notifications"
+	"github.com/akos011221/velora/internal/onboarding"
+	"github.com/akos011221/velora/internal/pause"
+	"github.com/akos011221/velora/internal/preflight"
+	"github.com/akos011221/velora/internal/scoring"
+	"github.com/akos011221/velora/internal/shard"
+	"github.com/akos011221/velora/internal/slo"
+	"github.com/akos011221/velora/internal/state"
+	"github.com/akos011221/velora/internal/stats"
+	"github.com/akos011221/velora/internal/tagging"
+	"github.com/akos011221/velora/internal/trace"
+	"github.com/akos011221/velora/internal/webhooks"
+)
+
+// hubCacheTTL bounds how long hub inventories are reused within a run.
+const hubCacheTTL = 5 * time.Minute
+
+// Controller is an enforcer run for all subscriptions. Enforcement must be
+// idempotent: a controller compares every property it writes with the
+// observed resource and writes nothing when they match, so a run right
+// after a remediating run makes no writes. `velora plan --expect-no-changes`
+// checks it against a live environment.
+type Controller interface {
+	EnforceAll(ctx context.Context) error
+	Findings() []findings.Finding
+	Compliance() *findings.ComplianceLog
+}
+
+// Result is the outcome of a run.
+type Result struct {
+	Metadata   findings.Metadata
+	Preflight  *preflight.Report
+	Findings   []findings.Finding
+	Compliance *findings.ComplianceLog
+	// SLO is the time-to-remediation report, nil if the run failed.
+	SLO *slo.Report
+	// Skipped are the subscriptions the run didn't evaluate, with the reason.
+	Skipped map[string]string
+	// Disappeared are the resources deleted while the run evaluated them.
+	Disappeared []string
+	// BlockedByPolicy counts the writes Azure Policy refused per assignment.
+	BlockedByPolicy []PolicyBlocked
+	// PendingAcknowledgment are the new subscriptions only observed until
+	// their findings are acknowledged.
+	PendingAcknowledgment []*onboarding.Record
+	// Reads are the reads the run sent to ARM.
+	Reads *Reads
+	// Latencies are the ARM requests of the run by operation.
+	Latencies azure.Latencies
+	// ListPages are the list pages the run fetched by operation.
+	ListPages azure.ListPages
+	// Scores are the posture scores of the evaluated subscriptions, nil if
+	// the run failed.
+	Scores map[string]scoring.Score
+	// Notifications is what the run notified, nil if nothing was.
+	Notifications *notifications.Outcome
+	// Issues is what the run filed per issue tracker, nil if none is
+	// configured.
+	Issues []issues.Outcome
+	// Webhooks is what the run posted per webhook, nil if none is
+	// configured or nothing was posted.
+	Webhooks []webhooks.Outcome
+	// HubCapacity is the usage of the hubs with a design capacity.
+	HubCapacity []limits.HubUsage
+}
+
+// Reads counts the reads of a run: those sent to ARM, the lists of the run
+// inventory, shared by the controllers instead of each listing again, and
+// the lookups of the hub cache.
+type Reads struct {
+	ARM       uint64               `json:"arm"`
+	Inventory inventory.RunStats   `json:"inventory"`
+	HubCache  inventory.CacheStats `json:"hubCache"`
+}
+
+// Summary counts the compliant and non-compliant resources of the run.
+func (r *Result) Summary() findings.Summary {
+	return findings.Summarize(r.Compliance, r.Findings)
+}
+
+// runs serializes the runs of the process, like those of the queue workers:
+// runs read and write whole state values.
+var runs sync.Mutex
+
+// Runner runs the preflight checks and all controllers for a configuration.
+type Runner struct {
+	// cfg is the configuration of the current run, static with the
+	// discovered hubs added.
+	cfg           *config.Config
+	static        *config.Config
+	clientFactory *azure.ClientFactory
+	store         state.Store
+	guard         *guard.Guard
+	// queueItem is the work queue item the run is targeted at, empty for
+	// runs of the whole configuration.
+	queueItem string
+	// scope is the resource group or VNet ID the run is limited to, empty
+	// for runs of whole subscriptions.
+	scope string
+	// stagedConfig is the staged config the run loaded, nil if it is active.
+	stagedConfig *configstage.Stage
+}
+
+// New creates a new runner instance. Pauses and failovers are read
+// from the state store. In read-only mode the guard skips every write and
+// the factory refuses any write that gets past it. With
+// testing.faultInjection enabled the factory's requests are injected faults.
+func New(cfg *config.Config, clientFactory *azure.ClientFactory, store state.Store) *Runner {
+	g := guard.New(pause.NewManager(store))
+	if cfg.ReadOnly {
+		g.SetReadOnly()
+		clientFactory.EnableReadOnly()
+	}
+	if fi := cfg.Testing.FaultInjection; fi != nil && fi.Enabled {
+		if err := clientFactory.EnableFaultInjection(fi); err != nil {
+			fmt.Println("WARNING: fault injection not enabled:", err)
+		} else {
+			fmt.Printf("WARNING: injecting %d ARM faults, testing.faultInjection is enabled\n", len(fi.Faults))
+		}
+	}
+
+	return &Runner{
+		cfg:           cfg,
+		static:        cfg,
+		clientFactory: clientFactory,
+		store:         store,
+		guard:         g,
+	}
+}
+
+// startTracing loads the active traces and logs the ARM requests, decisions
+// and findings about the traced resources. Tracing never fails a run.
+func (r *Runner) startTracing() *trace.Tracer {
+	tracer, err := trace.NewManager(r.store).Tracer()
+	if err != nil {
+		fmt.Println("WARNING: tracing disabled:", err)
+		return nil
+	}
+	if !tracer.Active() {
+		return nil
+	}
+	r.clientFactory.EnableTracing(tracer.Traced)
+	r.guard.SetTracer(tracer)
+	fmt.Printf("tracing %d resources\n", len(tracer.Traces()))
+	return tracer
+}
+
+// SetQueueItem records the work queue item that triggered the run, whose
+// configuration is limited to the item's subscription. The metrics of the
+// subscriptions outside it are kept.
+func (r *Runner) SetQueueItem(itemID string) {
+	r.queueItem = itemID
+}
+
+// SetScope limits the discovery of the run to the VNets of the resource
+// group of the scope, a resource group or VNet ID in the run's only
+// subscription. A scoped run doesn't cover its subscription: it resolves no
+// findings and isn't counted in the statistics or the managed resources.
+func (r *Runner) SetScope(scope string) {
+	r.scope = scope
+}
+
+// SetStagedConfig holds back the writes of the run: the config it loaded is
+// staged, it only applies once activated.
+func (r *Runner) SetStagedConfig(stage *configstage.Stage) {
+	r.stagedConfig = stage
+}
+
+// Guard returns the write guard shared by the controllers of the run.
+func (r *Runner) Guard() *guard.Guard {
+	return r.guard
+}
+
+// Run runs the preflight checks, then every controller in order. It stops
+// at the first controller error, returning the findings recorded so far.
+// The runs of a process take turns.
+func (r *Runner) Run(ctx context.Context) (result *Result, err error) {
+	runs.Lock()
+	defer runs.Unlock()
+	// controllers recover their own panics per subscription, a panic
+	// anywhere else fails the run but not the process
+	defer func() {
+		if value := recover(); value != nil {
+			result, err = nil, r.recoverRun(value)
+		}
+	}()
+
+	result, err = r.run(ctx)
+	// findings are routed by the ownership of their subscription
+	if result != nil {
+		findings.AttachOwnership(result.Findings, r.cfg)
+	}
+	if err == nil {
+		r.notify(ctx, result)
+		r.exportIssues(ctx, result)
+		r.notifyWebhooks(ctx, result)
+	}
+	r.recordRun(result, err)
+	return result, err
+}
+
+// run is Run, with the runs of the process serialized.
+func (r *Runner) run(ctx context.Context) (*Result, error) {
+
+	var runShard string
+	if r.cfg.Sharding != nil {
+		s, err := r.claimShard()
+		if err != nil {
+			return nil, err
+		}
+		runShard = s.String()
+	}
+	// plans don't write, they don't block the role's runs
+	if r.cfg.Role() != "" && !r.guard.Planning() {
+		r.claimControllers()
+	}
+	if r.guard.ReadOnly() {
+		fmt.Println("read-only mode, no changes will be made")
+	}
+	tracer := r.startTracing()
+
+	readsBefore := r.clientFactory.Reads()
+	latencyMark := r.clientFactory.MarkLatencies()
+	pageMark := r.clientFactory.MarkListPages()
+	discovery, err := r.discoverHubs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := preflight.Run(ctx, r.cfg, r.clientFactory)
+	if err := report.TrackInactive(r.store); err != nil {
+		return nil, err
+	}
+	if err := report.TrackFallbacks(r.store, r.cfg); err != nil {
+		return nil, err
+	}
+	report.Apply(r.guard)
+	onboard := onboarding.NewManager(r.store)
+	pending, err := r.beginOnboarding(onboard)
+	if err != nil {
+		return nil, err
+	}
+
+	// next hops are resolved once per run, the controllers see the resolved values
+	cfg := r.cfg.WithNextHops(report.NextHops())
+
+	failovers := failover.NewManager(r.store)
+	missingHubs, err := r.checkHubs(cfg, failovers)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Metadata:   findings.NewMetadata(r.cfg),
+		Preflight:  report,
+		Findings:   append(report.Findings, missingHubs...),
+		Compliance: findings.NewComplianceLog(r.cfg),
+	}
+	result.Metadata.NextHops = report.NextHops()
+	if discovery != nil {
+		result.Findings = append(result.Findings, discovery.Findings...)
+		result.Metadata.DiscoveredHubs = make(map[string]string)
+		for _, hub := range discovery.Hubs {
+			result.Metadata.DiscoveredHubs[hub.Config.Name] = hub.Config.VNetID
+		}
+	}
+	result.Metadata.ReadOnly = r.guard.ReadOnly()
+	result.Metadata.Shard = runShard
+	result.Metadata.Role = r.cfg.Role()
+	result.Metadata.QueueItem = r.queueItem
+	result.Metadata.Scope = r.scope
+	breakGlass, err := r.enterBreakGlass(result)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range pending {
+		if _, observed := r.guard.ObserveOnly(record.SubscriptionID); !observed {
+			if breakGlass != nil {
+				fmt.Printf("AUDIT: acknowledgment of new subscription %s bypassed under break-glass mode\n", record.SubscriptionID)
+			} else {
+				r.guard.SetObserveOnly(record.SubscriptionID, "new subscription pending acknowledgment of its findings")
+			}
+		}
+		result.Findings = append(result.Findings, record.Finding(r.cfg.Rules))
+	}
+	result.PendingAcknowledgment = pending
+	r.holdStagedConfig(result)
+	r.requireApproval()
+	if err := r.openPanicBreakers(time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	hubCache := inventory.NewHubCache(inventory.NewAzureHubFetcher(r.clientFactory), hubCacheTTL)
+	// the VNets and route tables of a subscription are listed once for all controllers
+	runInventory := inventory.NewRunInventory(r.clientFactory, r.cfg.Inventory.EffectiveMaxCachedResources())
+	if r.scope != "" {
+		runInventory.LimitToResourceGroup(azure.SubscriptionIDOf(r.scope), azure.ExtractResourceIDParts(r.scope)["resourceGroups"])
+	}
+	tracker := managed.NewTracker(r.store)
+	stamper := tagging.NewStamper(cfg, r.clientFactory, r.guard)
+	quotas := limits.NewGate(cfg)
+	newResources := grace.NewTracker(r.clientFactory, cfg, r.store)
+	r.guard.SetGrace(newResources)
+	// plans don't remediate, nothing to count
+	var flaps *flapping.Tracker
+	if cfg.Flapping != nil && !r.guard.Planning() {
+		flaps = flapping.NewTracker(cfg, r.store)
+		r.guard.SetFlapping(flaps)
+	}
+	// callers are looked up in the background while the controllers run
+	var callers *attribution.Enricher
+	if cfg.Attribution != nil {
+		if callers, err = attribution.NewEnricher(r.clientFactory, cfg.Attribution, r.store); err != nil {
+			return nil, err
+		}
+		callers.Start(ctx)
+		defer callers.Stop()
+	}
+	controllers := map[string]Controller{
+		"routing":  routing.NewEnforcer(r.clientFactory, cfg, hubCache, runInventory, r.guard, failovers, tracker, stamper, quotas),
+		"peering":  peering.NewEnforcer(r.clientFactory, cfg, hubCache, runInventory, r.guard, failovers, quotas),
+		"gateways": gateways.NewEnforcer(r.clientFactory, cfg, runInventory, r.guard),
+		"vwan":     vwan.NewEnforcer(r.clientFactory, cfg, runInventory, r.guard, failovers),
+		"flowlogs": flowlogs.NewEnforcer(r.clientFactory, cfg, runInventory, r.guard, failovers),
+		"nsg":      nsg.NewEnforcer(r.clientFactory, cfg, runInventory, r.guard, nsg.NewJournal(r.store)),
+		"egress":   egress.NewEnforcer(r.clientFactory, cfg, runInventory, r.guard),
+	}
+
+	errorClasses := make(map[string]string)
+	severities := newSeverityContext(cfg, runInventory)
+	// a controller may hold back resources from the ones after it, through the guard
+	for _, name := range cfg.EffectiveControllerOrder() {
+		// the other controllers run in instances of other roles
+		if !cfg.RunsController(name) {
+			continue
+		}
+		controller := controllers[name]
+		if compliance := controller.Compliance(); compliance != nil {
+			compliance.SetTracer(tracer)
+		}
+		started := time.Now()
+		err := controller.EnforceAll(ctx)
+		controllerFindings := controller.Findings()
+		severities.adjust(ctx, controllerFindings)
+		if callers != nil {
+			callers.Observe(controllerFindings)
+		}
+		for _, f := range controllerFindings {
+			tracer.Printf(f.ResourceID, "%s finding %s [%s]: %s", name, f.RuleID, f.Severity, f.Message)
+		}
+		if tracer.Active() {
+			fmt.Printf("TRACE %s controller took %s\n", name, time.Since(started).Round(time.Millisecond))
+		}
+		result.Findings = append(result.Findings, controllerFindings...)
+		result.Compliance.Merge(controller.Compliance())
+		result.Disappeared = r.guard.Disappeared()
+		result.Reads = &Reads{ARM: r.clientFactory.Reads() - readsBefore, Inventory: runInventory.Stats(), HubCache: hubCache.Stats()}
+		result.Latencies = r.clientFactory.LatenciesSince(latencyMark)
+		result.ListPages = r.clientFactory.ListPagesSince(pageMark)
+		errorClasses[name] = metrics.ErrorClass(err)
+		if err != nil {
+			r.recordPanics(result, nil, time.Now().UTC())
+			r.recordPolicyBlocks(result)
+			r.writeMetrics(result, errorClasses, false)
+			return result, fmt.Errorf("%s controller failed: %w", name, err)
+		}
+	}
+	// spokes are compared once every controller has left its routes, a
+	// scoped run doesn't see all the spokes of a hub
+	if cfg.Features.RoutingEnforcement && r.scope == "" {
+		asymmetric := analyzeAsymmetricRouting(ctx, cfg, r.guard, failovers, runInventory)
+		severities.adjust(ctx, asymmetric)
+		result.Findings = append(result.Findings, asymmetric...)
+	}
+	if cfg.Features.PeeringEnforcement && cfg.RunsController("peering") {
+		usages, capacity := checkHubCapacity(ctx, cfg, hubCache)
+		result.HubCapacity = usages
+		result.Findings = append(result.Findings, capacity...)
+		if result.Reads != nil {
+			result.Reads.HubCache = hubCache.Stats()
+		}
+	}
+	result.Findings = append(result.Findings, newResources.Findings()...)
+	if flaps != nil {
+		result.Findings = append(result.Findings, flaps.Findings()...)
+	}
+	if callers != nil {
+		callers.Wait()
+		callers.Attach(result.Findings)
+	}
+	r.recordPolicyBlocks(result)
+	if n := stamper.Stamped(); n > 0 {
+		fmt.Printf("tagged %d resources as enforced\n", n)
+	}
+	result.Skipped = r.skipped(report)
+	evaluated := r.evaluated(result)
+	r.recordPanics(result, evaluated, time.Now().UTC())
+	result.Latencies = r.clientFactory.LatenciesSince(latencyMark)
+	result.ListPages = r.clientFactory.ListPagesSince(pageMark)
+	if result.PendingAcknowledgment, err = onboard.Observe(r.cfg.SubscriptionIDs(), result.Findings, evaluated,
+		r.cfg.AutoAcknowledgeAfterRuns, time.Now().UTC()); err != nil {
+		return result, err
+	}
+	r.writeMetrics(result, errorClasses, true)
+
+	// routes outside the scope weren't touched, they'd look abandoned
+	if r.scope == "" {
+		if err := tracker.Commit(); err != nil {
+			return result, err
+		}
+	}
+	if err := newResources.Commit(); err != nil {
+		return result, err
+	}
+	if flaps != nil {
+		if err := flaps.Commit(); err != nil {
+			return result, err
+		}
+	}
+	if callers != nil {
+		if err := callers.Commit(result.Findings, evaluated); err != nil {
+			return result, err
+		}
+	}
+
+	// only complete runs resolve findings, a failed controller reports nothing
+	now := time.Now().UTC()
+	sloReport, err := slo.NewTracker(r.store, r.cfg).Observe(result.Findings, evaluated, now)
+	if err != nil {
+		return result, err
+	}
+	result.SLO = sloReport
+
+	// skipped subscriptions leave a gap in the statistics rather than a 100%,
+	// scoped runs aren't in the trends at all
+	var evaluatedIDs []string
+	for _, subID := range r.cfg.SubscriptionIDs() {
+		if evaluated(subID) {
+			evaluatedIDs = append(evaluatedIDs, subID)
+		}
+	}
+	result.Scores = r.postureScores(result, evaluatedIDs)
+	scores := make(map[string]float64, len(result.Scores))
+	for subID, score := range result.Scores {
+		scores[subID] = score.Score
+	}
+	records := stats.Collect(now, evaluatedIDs, result.Compliance.BySubscription(), result.Findings, r.guard.Writes(), scores)
+	for i := range records {
+		records[i].Role = r.cfg.Role()
+	}
+	if err := stats.NewHistory(r.store, r.cfg.Stats).Append(records, now); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// evaluated returns whether the run evaluated a subscription completely. A
+// scoped run didn't see the rest of its subscription.
+func (r *Runner) evaluated(result *Result) func(subscriptionID string) bool {
+	return func(subscriptionID string) bool {
+		if r.scope != "" {
+			return false
+		}
+		_, managed := r.cfg.Subscriptions[subscriptionID]
+		_, skipped := result.Skipped[subscriptionID]
+		return managed && !skipped
+	}
+}
+
+// writeMetrics updates the metrics with the run and writes them, if enabled.
+// Only a completed run updates the findings and the last successful
+// enforcement. Metrics never fail the run.
+func (r *Runner) writeMetrics(result *Result, errorClasses map[string]string, completed bool) {
+	if r.cfg.Metrics.TextfilePath == "" {
+		return
+	}
+
+	err := metrics.Update(r.store, func(snapshot *metrics.Snapshot) {
+		// a targeted run only knows its own subscription, a scoped run
+		// only part of it
+		targeted := r.queueItem != "" || r.scope != ""
+		if !targeted {
+			snapshot.Prune(r.cfg.SubscriptionIDs())
+		}
+		snapshot.ObserveAccess(result.Preflight)
+		snapshot.ObserveLatencies(result.Latencies)
+		snapshot.ObserveListPages(result.ListPages)
+		if result.Reads != nil {
+			snapshot.ObserveHubCache(result.Reads.HubCache)
+		}
+		for controller, class := range errorClasses {
+			snapshot.ControllerErrors[controller] = class
+		}
+		for _, p := range r.guard.Panics() {
+			snapshot.ControllerPanics[p.Controller]++
+		}
+		if completed {
+			now := time.Now().UTC()
+			for _, subID := range r.cfg.SubscriptionIDs() {
+				if _, skipped := result.Skipped[subID]; !skipped && r.scope == "" {
+					snapshot.LastSuccess[subID] = now
+				}
+			}
+			if !targeted {
+				snapshot.Unmanaged = len(result.Skipped)
+				snapshot.ObserveFindings(result.Findings)
+			}
+			// hubs are measured by the instances running the peering controller
+			if r.cfg.Features.PeeringEnforcement && r.cfg.RunsController("peering") {
+				snapshot.ObserveHubCapacity(result.HubCapacity)
+			}
+		}
+	})
+	if err != nil {
+		fmt.Println("WARNING: metrics not written:", err)
+		return
+	}
+	if err := metrics.Refresh(r.store, r.cfg.Metrics.TextfilePath); err != nil {
+		fmt.Println("WARNING: metrics not written:", err)
+	}
+}
+
+// beginOnboarding returns the onboarding records of the subscriptions
+// pending acknowledgment. Subscriptions with statistics of earlier runs
+// aren't new.
+func (r *Runner) beginOnboarding(onboard *onboarding.Manager) ([]*onboarding.Record, error) {
+	points, err := stats.NewHistory(r.store, r.cfg.Stats).Query("", time.Time{}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	ranBefore := make(map[string]bool)
+	for _, p := range points {
+		ranBefore[p.SubscriptionID] = true
+	}
+	unobserved := make(map[string]bool)
+	for subID, subCFG := range r.cfg.Subscriptions {
+		if subCFG.SkipsObservation() {
+			unobserved[subID] = true
+		}
+	}
+	return onboard.Begin(r.cfg.SubscriptionIDs(), ranBefore, unobserved, time.Now().UTC())
+}
+
+// requireApproval downgrades the subscriptions requiring approval to observe
+// mode, unless the run records a plan: their changes are only made by
+// applying an approved plan.
+func (r *Runner) requireApproval() {
+	if r.guard.Planning() {
+		return
+	}
+	for _, subID := range r.cfg.SubscriptionIDs() {
+		subCFG := r.cfg.Subscriptions[subID]
+		if _, observed := r.guard.ObserveOnly(subID); observed || !subCFG.RequiresApproval() {
+			continue
+		}
+		if r.guard.BreakGlass() != nil {
+			fmt.Printf("AUDIT: approval of the changes to subscription %s bypassed under break-glass mode\n", subID)
+			continue
+		}
+		r.guard.SetObserveOnly(subID, "changes require an approved plan, apply one with velora apply")
+	}
+}
+
+// claimShard limits the run to the subscriptions of the instance's shard.
+// If the shard can't be claimed, because another instance overlaps it or
+// the claims can't be read, the run only observes.
+func (r *Runner) claimShard() (shard.Shard, error) {
+	s, err := shard.Resolve(r.cfg.Sharding)
+	if err != nil {
+		return shard.Shard{}, err
+	}
+	r.cfg = shard.Filter(r.cfg, s)
+	fmt.Printf("enforcing shard %s: %d subscriptions\n", s, len(r.cfg.Subscriptions))
+
+	if err := shard.ClaimShard(r.store, s, shard.Instance(), r.cfg.Sharding.ClaimTTL()); err != nil {
+		fmt.Println("WARNING: refusing to write, failed to claim shard:", err)
+		r.guard.SetReadOnly()
+		r.clientFactory.EnableReadOnly()
+	}
+	return s, nil
+}
+
+// claimControllers claims the controllers of the instance's role on its
+// subscriptions. If another role runs one of them on the same subscriptions,
+// or the claims can't be read, the run only observes.
+func (r *Runner) claimControllers() {
+	ttl := config.DefaultShardClaimTTL
+	if r.cfg.Sharding != nil {
+		ttl = r.cfg.Sharding.ClaimTTL()
+	}
+	role := r.cfg.Role()
+	fmt.Printf("running role %s\n", role)
+	if err := shard.ClaimControllers(r.store, shard.Instance(), role, r.cfg.Controllers, r.cfg.SubscriptionIDs(), ttl); err != nil {
+		fmt.Println("WARNING: refusing to write, failed to claim controllers:", err)
+		r.guard.SetReadOnly()
+		r.clientFactory.EnableReadOnly()
+	}
+}
+
+// discoverHubs sets the configuration of the run: the static one, with the
+// hubs discovered from the tags of the hub VNets added if enabled. Hubs are
+// discovered again every run, the changes since the previous run are
+// logged as config-changed events.
+func (r *Runner) discoverHubs(ctx context.Context) (*hubdiscovery.Result, error) {
+	r.cfg = r.static
+	if !r.static.HubDiscovery.IsEnabled() {
+		return nil, nil
+	}
+
+	discovery, err := hubdiscovery.Discover(ctx, r.static, r.clientFactory)
+	if err != nil {
+		return nil, err
+	}
+	merged, warnings := discovery.Merge(r.static)
+	for _, warning := range warnings {
+		fmt.Println(warning)
+	}
+	r.cfg = merged
+	changes, err := discovery.Track(r.store)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		fmt.Println("config-changed:", change)
+	}
+	fmt.Printf("discovered %d hubs\n", len(discovery.Hubs))
+	return discovery, nil
+}
+
+// checkHubs applies the missing hub action to the subscriptions whose hub,
+// or failover hub, isn't configured. With error the controllers needing the
+// hub fail the run, with skip and reportOnly they skip the subscription and
+// a finding is returned for it.
+func (r *Runner) checkHubs(cfg *config.Config, failovers *failover.Manager) ([]findings.Finding, error) {
+	action := cfg.EffectiveMissingHubAction()
+	if action == config.MissingHubError {
+		return nil, nil
+	}
+
+	var result []findings.Finding
+	for _, subID := range cfg.SubscriptionIDs() {
+		hubName := cfg.Subscriptions[subID].HubName
+		_, err := failovers.ActiveHub(cfg, hubName)
+		var notFound *failover.HubNotFoundError
+		if !errors.As(err, &notFound) {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		reason := notFound.Error()
+		r.guard.SetHubMissing(subID, reason)
+		if action == config.MissingHubReportOnly {
+			r.guard.SetObserveOnly(subID, reason)
+		}
+		fmt.Printf("skipped hub checks of subscription %s: %s\n", subID, reason)
+		result = append(result, findings.New(findings.RuleHubNotFound, cfg.Rules, subID, "/subscriptions/"+subID,
+			fmt.Sprintf("hub %s of subscription %s not found, its hub checks were skipped", notFound.Name, subID),
+			map[string]string{
+				"hub":          notFound.Name,
+				"subscription": subID,
+			}))
+	}
+	return result, nil
+}
+
+// skipped returns the subscriptions the guard skipped or the identity can't
+// read, with the reason.
+func (r *Runner) skipped(report *preflight.Report) map[string]string {
+	skipped := make(map[string]string)
+	for _, sub := range report.Subscriptions {
+		if sub.Access == preflight.AccessNone {
+			skipped[sub.SubscriptionID] = "no access, " + sub.Detail
+		}
+	}
+	for _, subID := range r.cfg.SubscriptionIDs() {
+		reason, err := r.guard.SkipReason(subID)
+		if err != nil {
+			reason = err.Error()
+		}
+		if reason != "" {
+			skipped[subID] = reason
+		}
+	}
+	return skipped
+}
@@ -0,0 +1,522 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// secondSubscriptionID is a second spoke subscription, see withSecondSpoke.
+const secondSubscriptionID = "00000000-0000-0000-0000-000000000003"
+
+// spoke returns the IDs of the spoke VNet and route table of the subscription.
+func spoke(subscriptionID string) (vnetID, routeTableID string) {
+	rg := "/subscriptions/" + subscriptionID + "/resourceGroups/spoke-rg/providers/Microsoft.Network"
+	return rg + "/virtualNetworks/spoke", rg + "/routeTables/spoke-rt"
+}
+
+// newTestARM returns a fake ARM with the hub of the test configuration, its
+// NVA, and a spoke VNet per subscription whose subnet has a route table with
+// the routes.
+func newTestARM(subscriptionIDs []string, routes ...*armnetwork.Route) *azuretest.Server {
+	arm := azuretest.NewServer()
+	arm.Put(configtest.HubVNetID, azuretest.VNet(configtest.HubVNetID, []string{"10.0.0.0/16"}, azuretest.Subnet("nva", "10.0.0.0/24", "")))
+	nicID := "/subscriptions/" + configtest.HubSubscriptionID + "/resourceGroups/hub-rg/providers/Microsoft.Network/networkInterfaces/nva"
+	arm.Put(nicID, azuretest.ForwardingNIC(nicID, configtest.HubVNetID+"/subnets/nva", configtest.NVANextHop))
+	for i, subscriptionID := range subscriptionIDs {
+		prefix := fmt.Sprintf("10.%d.0.0/16", i+1)
+		vnetID, routeTableID := spoke(subscriptionID)
+		arm.Put(routeTableID, azuretest.RouteTable(routeTableID, routes...))
+		arm.Put(vnetID, azuretest.VNet(vnetID, []string{prefix}, azuretest.Subnet("app", fmt.Sprintf("10.%d.0.0/24", i+1), routeTableID)))
+	}
+	return arm
+}
+
+// withSecondSpoke adds secondSubscriptionID to the configuration, like the
+// first spoke subscription.
+func withSecondSpoke(cfg *config.Config) {
+	cfg.Subscriptions[secondSubscriptionID] = cfg.Subscriptions[configtest.SubscriptionID]
+}
+
+// newTestRunner returns a runner of the configuration against the fake ARM.
+func newTestRunner(t *testing.T, cfg *config.Config, arm *azuretest.Server) *Runner {
+	t.Helper()
+	store, err := state.Open(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(cfg, azure.NewClientFactoryWithTransport(&cfg.Azure, azuretest.Credential{ObjectID: "velora"}, arm), store)
+}
+
+// routeWrites returns the paths of the writes of routes.
+func routeWrites(arm *azuretest.Server) []string {
+	var paths []string
+	for _, req := range arm.Writes() {
+		if strings.Contains(strings.ToLower(req.Path), "/routes/") {
+			paths = append(paths, req.Method+" "+req.Path)
+		}
+	}
+	return paths
+}
+
+func TestRunReadOnly(t *testing.T) {
+	cfg := configtest.New(t, withSecondSpoke, func(cfg *config.Config) { cfg.ReadOnly = true })
+	arm := newTestARM([]string{configtest.SubscriptionID, secondSubscriptionID})
+
+	result, err := newTestRunner(t, cfg, arm).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !result.Metadata.ReadOnly {
+		t.Error("run metadata isn't read-only")
+	}
+	if len(result.Findings) == 0 {
+		t.Error("Run() found nothing, want the missing default routes")
+	}
+	if writes := arm.Writes(); len(writes) != 0 {
+		t.Errorf("writes reaching ARM = %v, want none", writes)
+	}
+}
+
+func TestRunMissingHub(t *testing.T) {
+	_, goodRouteTable := spoke(configtest.SubscriptionID)
+	tests := []struct {
+		action string
+		// wantErr is whether the run fails, otherwise the good subscription
+		// is remediated and the bad one's NSG checks still run
+		wantErr bool
+		// observed is whether the bad subscription is only observed
+		observed bool
+	}{
+		{action: config.MissingHubError, wantErr: true},
+		{action: config.MissingHubSkip},
+		{action: config.MissingHubReportOnly, observed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			cfg := configtest.New(t, withSecondSpoke, func(cfg *config.Config) {
+				cfg.MissingHubAction = tt.action
+				cfg.Features.NSGAssociation = true
+				bad := cfg.Subscriptions[secondSubscriptionID]
+				bad.HubName = "missing"
+				bad.NSGAssociation = &config.NSGAssociationConfig{Mode: config.NSGAssociationAny}
+				cfg.Subscriptions[secondSubscriptionID] = bad
+			})
+			arm := newTestARM([]string{configtest.SubscriptionID, secondSubscriptionID})
+			runner := newTestRunner(t, cfg, arm)
+
+			result, err := runner.Run(context.Background())
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "missing") {
+					t.Errorf("Run() error = %v, want the missing hub", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if writes := routeWrites(arm); len(writes) != 1 || !strings.HasPrefix(writes[0], "PUT "+goodRouteTable+"/") {
+				t.Errorf("route writes = %v, want the default route of the good subscription only", writes)
+			}
+			rules := make(map[string]bool)
+			for _, f := range result.Findings {
+				if f.SubscriptionID == secondSubscriptionID {
+					rules[f.RuleID] = true
+				}
+			}
+			if !rules[findings.RuleHubNotFound.ID] || !rules[findings.RuleNSGMissing.ID] || rules[findings.RuleDefaultRoute.ID] {
+				t.Errorf("findings of the bad subscription = %v, want %s and %s only", rules, findings.RuleHubNotFound.ID, findings.RuleNSGMissing.ID)
+			}
+			if _, observed := runner.guard.ObserveOnly(secondSubscriptionID); observed != tt.observed {
+				t.Errorf("bad subscription observed only = %t, want %t", observed, tt.observed)
+			}
+		})
+	}
+}
+
+// policyDenied answers with a RequestDisallowedByPolicy of the assignment, as
+// ARM does for a deny assignment.
+func policyDenied(assignmentID string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = fmt.Fprintf(w, `{"error":{"code":"RequestDisallowedByPolicy","message":"Resource was disallowed by policy.",
+			"additionalInfo":[{"type":"PolicyViolation","info":{"policyDefinitionId":"/providers/Microsoft.Authorization/policyDefinitions/deny-routes",
+			"policyAssignmentId":%q,"policyAssignmentName":"deny-routes","policyAssignmentDisplayName":"Deny route changes"}}]}}`, assignmentID)
+	}
+}
+
+func TestRunPolicyDenials(t *testing.T) {
+	const assignmentID = "/providers/Microsoft.Management/managementGroups/platform/providers/Microsoft.Authorization/policyAssignments/deny-routes"
+	cfg := configtest.New(t, withSecondSpoke)
+	arm := newTestARM([]string{configtest.SubscriptionID, secondSubscriptionID})
+	var denied []string
+	for _, subscriptionID := range []string{configtest.SubscriptionID, secondSubscriptionID} {
+		_, routeTableID := spoke(subscriptionID)
+		arm.Handle(http.MethodPut, routeTableID+"/routes/DefaultRoute-To-NVA", policyDenied(assignmentID))
+		denied = append(denied, routeTableID+"/routes/DefaultRoute-To-NVA")
+	}
+
+	// the denied writes don't fail the run
+	result, err := newTestRunner(t, cfg, arm).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	var blocked []string
+	for _, f := range result.Findings {
+		if f.RuleID != findings.RuleBlockedByPolicy.ID {
+			continue
+		}
+		blocked = append(blocked, f.ResourceID)
+		if !strings.Contains(f.Message, "Deny route changes") || !strings.Contains(f.Remediation, "https://portal.azure.com/#resource"+assignmentID) {
+			t.Errorf("finding = %+v, want the assignment and its link", f)
+		}
+	}
+	sort.Strings(blocked)
+	if strings.Join(blocked, ",") != strings.Join(denied, ",") {
+		t.Errorf("blocked by policy = %v, want %v", blocked, denied)
+	}
+
+	// the denials of both subscriptions are counted once per assignment
+	want := []PolicyBlocked{{AssignmentID: assignmentID, AssignmentName: "Deny route changes", PortalURL: "https://portal.azure.com/#resource" + assignmentID, Blocked: 2}}
+	if fmt.Sprint(result.BlockedByPolicy) != fmt.Sprint(want) {
+		t.Errorf("BlockedByPolicy = %+v, want %+v", result.BlockedByPolicy, want)
+	}
+}
+
+// withAppGateway adds a subnet holding an Application Gateway v2 to the
+// spoke VNet of the subscription, with a route table of its own.
+func withAppGateway(arm *azuretest.Server, subscriptionID string) {
+	vnetID, spokeRouteTableID := spoke(subscriptionID)
+	routeTableID := spokeRouteTableID + "-appgw"
+	arm.Put(routeTableID, azuretest.RouteTable(routeTableID))
+	subnet := azuretest.Subnet("appgw", "10.1.2.0/24", routeTableID)
+	subnet.Properties.ApplicationGatewayIPConfigurations = []*armnetwork.ApplicationGatewayIPConfiguration{
+		{ID: to.Ptr(strings.TrimSuffix(vnetID, "/virtualNetworks/spoke") + "/applicationGateways/appgw/gatewayIPConfigurations/ip")},
+	}
+	arm.Put(vnetID+"/subnets/appgw", subnet)
+}
+
+// withHubPeering peers the spoke VNet of the subscription with the hub, both
+// sides connected and in sync.
+func withHubPeering(arm *azuretest.Server, subscriptionID string) {
+	vnetID, _ := spoke(subscriptionID)
+	var spokeVNet armnetwork.VirtualNetwork
+	arm.Get(vnetID, &spokeVNet)
+	var prefixes []string
+	for _, prefix := range spokeVNet.Properties.AddressSpace.AddressPrefixes {
+		prefixes = append(prefixes, *prefix)
+	}
+	// the names of the default peering templates
+	hubName, spokeName := "hub-vnet-to-spoke", "spoke-to-hub-vnet"
+	arm.Put(configtest.HubVNetID+"/virtualNetworkPeerings/"+hubName, azuretest.Peering(hubName, vnetID, prefixes, armnetwork.VirtualNetworkPeeringLevelFullyInSync))
+	arm.Put(vnetID+"/virtualNetworkPeerings/"+spokeName, azuretest.Peering(spokeName, configtest.HubVNetID, []string{"10.0.0.0/16"}, armnetwork.VirtualNetworkPeeringLevelFullyInSync))
+}
+
+// spokeNSGID is the NSG of the spoke subnets, see withSpokeNSG.
+const spokeNSGID = "/subscriptions/" + configtest.SubscriptionID + "/resourceGroups/spoke-rg/providers/Microsoft.Network/networkSecurityGroups/spoke-nsg"
+
+// withSpokeNSG puts the spoke VNet of configtest.SubscriptionID with its app
+// subnet and a db subnet with its own route table, both with spokeNSGID, and
+// the Network Watcher of its region.
+func withSpokeNSG(arm *azuretest.Server) {
+	vnetID, routeTableID := spoke(configtest.SubscriptionID)
+	arm.Put(routeTableID+"-db", azuretest.RouteTable(routeTableID+"-db"))
+	app := azuretest.Subnet("app", "10.1.0.0/24", routeTableID)
+	db := azuretest.Subnet("db", "10.1.1.0/24", routeTableID+"-db")
+	for _, subnet := range []*armnetwork.Subnet{app, db} {
+		subnet.Properties.NetworkSecurityGroup = &armnetwork.SecurityGroup{ID: to.Ptr(spokeNSGID)}
+	}
+	arm.Put(vnetID, azuretest.VNet(vnetID, []string{"10.1.0.0/16"}, app, db))
+	arm.Put(spokeNSGID, map[string]any{"location": azuretest.Location, "properties": map[string]any{}})
+	watcherID := "/subscriptions/" + configtest.SubscriptionID + "/resourceGroups/NetworkWatcherRG/providers/Microsoft.Network/networkWatchers/NetworkWatcher_" + azuretest.Location
+	arm.Put(watcherID, map[string]any{"location": azuretest.Location, "properties": map[string]any{}})
+}
+
+// The virtual hub of withVirtualWAN.
+const (
+	virtualHubID = "/subscriptions/" + configtest.HubSubscriptionID + "/resourceGroups/hub-rg/providers/Microsoft.Network/virtualHubs/vhub"
+	firewallID   = "/subscriptions/" + configtest.HubSubscriptionID + "/resourceGroups/hub-rg/providers/Microsoft.Network/azureFirewalls/fw"
+)
+
+// withVirtualWAN makes the hub of the configuration a virtual WAN hub routing
+// through firewallID.
+func withVirtualWAN(cfg *config.Config) {
+	cfg.Hubs[0] = config.HubVNetConfig{
+		Name:       configtest.HubName,
+		Type:       config.HubTypeVirtualWAN,
+		VirtualWAN: &config.VirtualWANConfig{VirtualHubID: virtualHubID, NextHopID: firewallID},
+	}
+}
+
+// withVirtualHub puts the virtual hub of withVirtualWAN into the fake ARM,
+// its default route table without routes and the spoke VNet of the
+// subscription connected without an associated route table.
+func withVirtualHub(arm *azuretest.Server, subscriptionID string) {
+	arm.Put(virtualHubID, map[string]any{"location": azuretest.Location, "properties": map[string]any{}})
+	arm.Put(virtualHubID+"/hubRouteTables/"+config.DefaultHubRouteTable, map[string]any{"properties": map[string]any{}})
+	vnetID, _ := spoke(subscriptionID)
+	arm.Put(virtualHubID+"/hubVirtualNetworkConnections/spoke", map[string]any{
+		"properties": map[string]any{"remoteVirtualNetwork": map[string]any{"id": vnetID}},
+	})
+}
+
+// TestRunIdempotent remediates, then runs again against the remediated
+// fake ARM: the second run must not write anything.
+func TestRunIdempotent(t *testing.T) {
+	both := []string{configtest.SubscriptionID, secondSubscriptionID}
+	tests := []struct {
+		name          string
+		subscriptions []string
+		setup         func(arm *azuretest.Server)
+		mutate        func(cfg *config.Config)
+		// remediated are path fragments the first run must write, besides
+		// the routes
+		remediated []string
+	}{
+		{name: "routing", subscriptions: []string{configtest.SubscriptionID}},
+		{name: "routing of two subscriptions", subscriptions: both, mutate: withSecondSpoke},
+		{
+			name:          "subnet to subnet deny",
+			subscriptions: []string{configtest.SubscriptionID},
+			mutate: func(cfg *config.Config) {
+				sub := cfg.Subscriptions[configtest.SubscriptionID]
+				sub.SubnetToSubnetDeny = true
+				cfg.Subscriptions[configtest.SubscriptionID] = sub
+			},
+		},
+		{
+			name:          "compat routes",
+			subscriptions: []string{configtest.SubscriptionID},
+			setup:         func(arm *azuretest.Server) { withAppGateway(arm, configtest.SubscriptionID) },
+			mutate: func(cfg *config.Config) {
+				sub := cfg.Subscriptions[configtest.SubscriptionID]
+				sub.EnforceWithCompatRoutes = true
+				cfg.Subscriptions[configtest.SubscriptionID] = sub
+			},
+		},
+		{
+			// the spoke VNets of both subscriptions have the same name, so
+			// would have the same hub peering
+			name:          "hub peering",
+			subscriptions: []string{configtest.SubscriptionID},
+			setup:         func(arm *azuretest.Server) { withHubPeering(arm, configtest.SubscriptionID) },
+			mutate: func(cfg *config.Config) {
+				cfg.Features.PeeringEnforcement = true
+				sub := cfg.Subscriptions[configtest.SubscriptionID]
+				sub.RequireHubPeering = true
+				cfg.Subscriptions[configtest.SubscriptionID] = sub
+			},
+		},
+		{
+			name:          "NSG association",
+			subscriptions: []string{configtest.SubscriptionID},
+			setup: func(arm *azuretest.Server) {
+				arm.Put(testNSGID, map[string]any{"id": testNSGID, "name": "baseline", "properties": map[string]any{}})
+			},
+			mutate: func(cfg *config.Config) {
+				cfg.Features.NSGAssociation = true
+				sub := cfg.Subscriptions[configtest.SubscriptionID]
+				sub.NSGAssociation = &config.NSGAssociationConfig{Mode: config.NSGAssociationSpecific, NSGID: testNSGID}
+				cfg.Subscriptions[configtest.SubscriptionID] = sub
+			},
+		},
+		{
+			name:          "subnet isolation band",
+			subscriptions: []string{configtest.SubscriptionID},
+			setup:         withSpokeNSG,
+			mutate: func(cfg *config.Config) {
+				cfg.Features.NSGAssociation = true
+				sub := cfg.Subscriptions[configtest.SubscriptionID]
+				sub.SubnetToSubnetDeny = true
+				sub.SubnetIsolationBand = &config.PriorityBandConfig{}
+				cfg.Subscriptions[configtest.SubscriptionID] = sub
+			},
+			remediated: []string{"/securityrules/velora-isolation-spoke-app", "/securityrules/velora-isolation-spoke-db"},
+		},
+		{
+			name:          "flow logs",
+			subscriptions: []string{configtest.SubscriptionID},
+			setup:         withSpokeNSG,
+			mutate: func(cfg *config.Config) {
+				cfg.Features.FlowLogs = true
+				cfg.Hubs[0].FlowLogs = &config.FlowLogsConfig{
+					StorageAccountID: "/subscriptions/" + configtest.HubSubscriptionID + "/resourceGroups/hub-rg/providers/Microsoft.Storage/storageAccounts/flowlogs",
+					RetentionDays:    30,
+					TrafficAnalytics: &config.TrafficAnalyticsConfig{
+						WorkspaceResourceID: "/subscriptions/" + configtest.HubSubscriptionID + "/resourceGroups/hub-rg/providers/Microsoft.OperationalInsights/workspaces/logs",
+						WorkspaceID:         "00000000-0000-0000-0000-000000000010",
+						WorkspaceRegion:     azuretest.Location,
+					},
+				}
+			},
+			remediated: []string{"/flowlogs/spoke-nsg-flowlog"},
+		},
+		{
+			name:          "virtual WAN",
+			subscriptions: []string{configtest.SubscriptionID},
+			setup:         func(arm *azuretest.Server) { withVirtualHub(arm, configtest.SubscriptionID) },
+			mutate:        withVirtualWAN,
+			remediated:    []string{"/hubroutetables/defaultroutetable", "/hubvirtualnetworkconnections/spoke"},
+		},
+		{
+			// the route tables are stamped once, when remediated
+			name:          "last enforced tags",
+			subscriptions: []string{configtest.SubscriptionID},
+			mutate:        func(cfg *config.Config) { cfg.Tagging.WriteLastEnforced = true },
+			remediated:    []string{"/routetables/spoke-rt/providers/microsoft.resources/tags/default"},
+		},
+		{
+			name:          "all controllers",
+			subscriptions: []string{configtest.SubscriptionID},
+			setup: func(arm *azuretest.Server) {
+				withAppGateway(arm, configtest.SubscriptionID)
+				withHubPeering(arm, configtest.SubscriptionID)
+				arm.Put(testNSGID, map[string]any{"id": testNSGID, "name": "baseline", "properties": map[string]any{}})
+			},
+			mutate: func(cfg *config.Config) {
+				cfg.Features.PeeringEnforcement = true
+				cfg.Features.NSGAssociation = true
+				sub := cfg.Subscriptions[configtest.SubscriptionID]
+				sub.SubnetToSubnetDeny = true
+				sub.EnforceWithCompatRoutes = true
+				sub.RequireHubPeering = true
+				sub.NSGAssociation = &config.NSGAssociationConfig{Mode: config.NSGAssociationSpecific, NSGID: testNSGID}
+				cfg.Subscriptions[configtest.SubscriptionID] = sub
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mutators []func(*config.Config)
+			if tt.mutate != nil {
+				mutators = append(mutators, tt.mutate)
+			}
+			cfg := configtest.New(t, mutators...)
+			arm := newTestARM(tt.subscriptions)
+			if tt.setup != nil {
+				tt.setup(arm)
+			}
+
+			if _, err := newTestRunner(t, cfg, arm).Run(context.Background()); err != nil {
+				t.Fatalf("first Run() error = %v", err)
+			}
+			if puts := countPuts(arm); puts == 0 {
+				t.Fatalf("first Run() made no PUT, want the remediation")
+			}
+			for _, fragment := range tt.remediated {
+				if !slices.ContainsFunc(arm.Writes(), func(req azuretest.Request) bool {
+					return strings.HasSuffix(strings.ToLower(req.Path), fragment)
+				}) {
+					t.Errorf("first Run() didn't write %s: %v", fragment, arm.Writes())
+				}
+			}
+
+			arm.Reset()
+			if _, err := newTestRunner(t, cfg, arm).Run(context.Background()); err != nil {
+				t.Fatalf("second Run() error = %v", err)
+			}
+			if puts := countPuts(arm); puts != 0 {
+				t.Errorf("second Run() made %d PUTs, want none: %v", puts, arm.Writes())
+			}
+			if writes := arm.Writes(); len(writes) != 0 {
+				t.Errorf("second Run() writes = %v, want none", writes)
+			}
+		})
+	}
+}
+
+// testNSGID is the baseline NSG of the NSG association tests.
+const testNSGID = "/subscriptions/" + configtest.HubSubscriptionID + "/resourceGroups/hub-rg/providers/Microsoft.Network/networkSecurityGroups/baseline"
+
+// countPuts returns the number of PUTs reaching ARM.
+func countPuts(arm *azuretest.Server) int {
+	puts := 0
+	for _, req := range arm.Writes() {
+		if req.Method == http.MethodPut {
+			puts++
+		}
+	}
+	return puts
+}
+
+func TestRunUpstreamBlocking(t *testing.T) {
+	vnetID, routeTableID := spoke(configtest.SubscriptionID)
+	tests := []struct {
+		name string
+		// denied refuses creating the spoke's hub peering
+		denied bool
+		order  []string
+		// wantBlocked is whether routing holds the VNet back instead of
+		// writing its default route
+		wantBlocked bool
+	}{
+		{name: "peering created"},
+		{name: "peering refused", denied: true, wantBlocked: true},
+		{name: "peering refused with routing first", denied: true, order: []string{"routing", "peering"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configtest.New(t, func(cfg *config.Config) {
+				cfg.ControllerOrder = tt.order
+				cfg.Features.PeeringEnforcement = true
+				sub := cfg.Subscriptions[configtest.SubscriptionID]
+				sub.RequireHubPeering = true
+				cfg.Subscriptions[configtest.SubscriptionID] = sub
+			})
+			arm := newTestARM([]string{configtest.SubscriptionID})
+			if tt.denied {
+				arm.Handle(http.MethodPut, vnetID+"/virtualNetworkPeerings/spoke-to-hub-vnet",
+					policyDenied("/providers/Microsoft.Authorization/policyAssignments/deny-peerings"))
+			}
+
+			result, err := newTestRunner(t, cfg, arm).Run(context.Background())
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			var blocked []findings.Finding
+			routed := false
+			for _, f := range result.Findings {
+				switch f.RuleID {
+				case findings.RuleBlockedByUpstream.ID:
+					blocked = append(blocked, f)
+				case findings.RuleDefaultRoute.ID:
+					routed = true
+				}
+			}
+			wantWrites := []string{"PUT " + routeTableID + "/routes/DefaultRoute-To-NVA"}
+			if tt.wantBlocked {
+				wantWrites = nil
+			}
+			if writes := routeWrites(arm); strings.Join(writes, ",") != strings.Join(wantWrites, ",") {
+				t.Errorf("route writes = %v, want %v", writes, wantWrites)
+			}
+			if !tt.wantBlocked {
+				if len(blocked) != 0 || !routed {
+					t.Errorf("blocked findings = %v, default route finding %t, want the VNet routed", blocked, routed)
+				}
+				return
+			}
+			if len(blocked) != 1 || blocked[0].ResourceID != vnetID || !strings.Contains(blocked[0].Remediation+blocked[0].Message, "isn't peered with hub") || routed {
+				t.Fatalf("blocked findings = %+v, default route finding %t, want the VNet held back only", blocked, routed)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package runner
+
+import (
+	"fmt"
+
+	"github.com/akos011221/velora/internal/metrics"
+	"github.com/akos011221/velora/internal/scoring"
+)
+
+// postureScores computes the posture scores of the evaluated subscriptions
+// from the counters of the run and writes them to the metrics, if enabled.
+// Metrics never fail the run.
+func (r *Runner) postureScores(result *Result, evaluatedIDs []string) map[string]scoring.Score {
+	tally := scoring.NewTally(r.cfg)
+	tally.AddCompliant(result.Compliance.BySubscription())
+	for _, f := range result.Findings {
+		tally.AddFinding(f)
+	}
+	tally.AddDisappeared(result.Disappeared)
+	if result.SLO != nil {
+		tally.AddBreaches(result.SLO.Breaches)
+	}
+	scores := tally.Scores(evaluatedIDs)
+
+	if r.cfg.Metrics.TextfilePath == "" || len(scores) == 0 {
+		return scores
+	}
+	err := metrics.Update(r.store, func(snapshot *metrics.Snapshot) {
+		for subID, score := range scores {
+			snapshot.PostureScores[subID] = score.Score
+		}
+	})
+	if err == nil {
+		err = metrics.Refresh(r.store, r.cfg.Metrics.TextfilePath)
+	}
+	if err != nil {
+		fmt.Println("WARNING: metrics not written:", err)
+	}
+	return scores
+}
@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/inventory"
+)
+
+// severityContext adjusts the severity of findings by whether their VNet is
+// peered with a hub, per the severity modifiers of their rule. The VNets
+// come from the run inventory, so the controllers' lists are reused.
+type severityContext struct {
+	cfg       *config.Config
+	inventory *inventory.RunInventory
+	// connected tells whether a VNet is hub-connected by lower-case VNet ID,
+	// per listed subscription. A subscription that failed to list is nil.
+	connected map[string]map[string]bool
+}
+
+// newSeverityContext creates a severity context for the run.
+func newSeverityContext(cfg *config.Config, runInventory *inventory.RunInventory) *severityContext {
+	return &severityContext{cfg: cfg, inventory: runInventory, connected: make(map[string]map[string]bool)}
+}
+
+// adjust adjusts the findings whose rule has severity modifiers. Findings
+// of resources outside a VNet, of hub VNets, or of VNets that couldn't be
+// listed keep their severity.
+func (s *severityContext) adjust(ctx context.Context, all []findings.Finding) {
+	if !s.cfg.HasSeverityModifiers() {
+		return
+	}
+	for i := range all {
+		modifiers := s.cfg.Rules[all[i].RuleID].SeverityModifiers
+		if modifiers == nil {
+			continue
+		}
+		vnetID := azure.TopLevelResourceID(all[i].ResourceID)
+		if !strings.Contains(strings.ToLower(vnetID), "/providers/microsoft.network/virtualnetworks/") || s.cfg.IsHubVNet(vnetID) {
+			continue
+		}
+		connected, known := s.hubConnected(ctx, vnetID)
+		if !known {
+			continue
+		}
+
+		vnetName := azure.ExtractResourceIDParts(vnetID)["virtualNetworks"]
+		if connected {
+			all[i].AdjustSeverity(modifiers.HubConnected, fmt.Sprintf("VNet %s is peered with a hub", vnetName))
+		} else {
+			all[i].AdjustSeverity(modifiers.NotHubConnected, fmt.Sprintf("VNet %s isn't peered with any hub", vnetName))
+		}
+	}
+}
+
+// hubConnected reports whether the VNet has a Connected peering with a hub
+// VNet, and whether the VNet is known to the inventory.
+func (s *severityContext) hubConnected(ctx context.Context, vnetID string) (bool, bool) {
+	subscriptionID := strings.ToLower(azure.SubscriptionIDOf(vnetID))
+	vnets, listed := s.connected[subscriptionID]
+	if !listed {
+		vnets = s.list(ctx, subscriptionID)
+		s.connected[subscriptionID] = vnets
+	}
+	connected, known := vnets[strings.ToLower(vnetID)]
+	return connected, known
+}
+
+// list returns whether each VNet of the subscription is hub-connected, nil
+// if the VNets can't be listed.
+func (s *severityContext) list(ctx context.Context, subscriptionID string) map[string]bool {
+	vnets, err := s.inventory.VNets(ctx, subscriptionID)
+	if err != nil {
+		fmt.Printf("WARNING: severity modifiers not applied to subscription %s: %v\n", subscriptionID, err)
+		return nil
+	}
+
+	connected := make(map[string]bool, len(vnets))
+	for _, vnet := range vnets {
+		if vnet.ID == nil {
+			continue
+		}
+		id := strings.ToLower(*vnet.ID)
+		connected[id] = false
+		if vnet.Properties == nil {
+			continue
+		}
+		for _, peering := range vnet.Properties.VirtualNetworkPeerings {
+			if peering == nil || peering.Properties == nil || peering.Properties.RemoteVirtualNetwork == nil ||
+				peering.Properties.RemoteVirtualNetwork.ID == nil || peering.Properties.PeeringState == nil {
+				continue
+			}
+			if *peering.Properties.PeeringState == armnetwork.VirtualNetworkPeeringStateConnected &&
+				s.cfg.IsHubVNet(*peering.Properties.RemoteVirtualNetwork.ID) {
+				connected[id] = true
+				break
+			}
+		}
+	}
+	return connected
+}
@@ -0,0 +1,30 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akos011221/velora/internal/webhooks"
+)
+
+// notifyWebhooks posts the events of a completed run to the configured
+// webhooks. Plans post nothing. Webhooks failing never fail the run, the
+// events they refuse are kept in the dead-letter log.
+func (r *Runner) notifyWebhooks(ctx context.Context, result *Result) {
+	if len(r.cfg.Notifications.Webhooks) == 0 || r.guard.Planning() {
+		return
+	}
+
+	notifier := webhooks.NewNotifier(r.cfg.Notifications.Webhooks, r.store)
+	outcomes, err := notifier.NotifyRun(ctx, result.Metadata, result.Findings, result.Skipped, r.evaluated(result))
+	if err != nil {
+		fmt.Println("WARNING: webhooks not notified:", err)
+	}
+	for _, o := range outcomes {
+		fmt.Printf("posted %d events to webhook %s\n", o.Delivered, o.Endpoint)
+		if o.Error != "" {
+			fmt.Printf("WARNING: %d events dead-lettered for webhook %s: %s\n", o.DeadLettered, o.Endpoint, o.Error)
+		}
+	}
+	result.Webhooks = outcomes
+}
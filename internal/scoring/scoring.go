@@ -0,0 +1,189 @@
+package scoring
+
+import (
+	"math"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/slo"
+)
+
+// Weights of the components of the score. Without SLO thresholds the SLO
+// adherence doesn't apply and the other two are scaled up to 100.
+const (
+	complianceWeight = 0.6
+	coverageWeight   = 0.2
+	sloWeight        = 0.2
+)
+
+// DefaultSeverityWeights is how much a finding of the severity weighs
+// against a compliant resource, if scoring.severityWeights doesn't set it.
+var DefaultSeverityWeights = map[findings.Severity]float64{
+	findings.SeverityCritical: 10,
+	findings.SeverityHigh:     5,
+	findings.SeverityMedium:   2,
+	findings.SeverityLow:      1,
+	findings.SeverityInfo:     0,
+}
+
+// unevaluatedRules report resources that weren't evaluated: skipped,
+// deferred, held back or excluded. They count against the coverage, not
+// the compliance.
+var unevaluatedRules = map[string]bool{
+	findings.RuleUnreadableResource.ID:   true,
+	findings.RuleGracePeriod.ID:          true,
+	findings.RuleBlockedByUpstream.ID:    true,
+	findings.RuleNSGUnsupportedSubnet.ID: true,
+	findings.RuleSubnetClassExempt.ID:    true,
+	findings.RuleServiceRouteTable.ID:    true,
+}
+
+// Counters are what the score of a subscription is computed from.
+type Counters struct {
+	Compliant int
+	// Findings counts the findings of evaluated resources per severity.
+	Findings map[findings.Severity]int
+	// Unevaluated counts the resources skipped, deferred, held back,
+	// excluded or deleted during the run.
+	Unevaluated int
+	// SLOTracked counts the findings of severities with an SLO threshold,
+	// SLOBreached those open longer than it.
+	SLOTracked  int
+	SLOBreached int
+}
+
+// Score is the network posture score of a subscription and its components,
+// each from 0 to 100.
+type Score struct {
+	Score      float64 `json:"score"`
+	Compliance float64 `json:"compliance"`
+	Coverage   float64 `json:"coverage"`
+	// SLO is the SLO adherence, 100 without SLO thresholds.
+	SLO float64 `json:"slo"`
+}
+
+// Tally counts the findings of a run per subscription as they are added,
+// so scores come from the counters rather than the findings.
+type Tally struct {
+	weights    map[findings.Severity]float64
+	thresholds map[string]float64
+	counters   map[string]*Counters
+}
+
+// NewTally creates an empty tally with the weights and SLO thresholds of
+// the config.
+func NewTally(cfg *config.Config) *Tally {
+	weights := make(map[findings.Severity]float64, len(DefaultSeverityWeights))
+	for severity, weight := range DefaultSeverityWeights {
+		weights[severity] = weight
+	}
+	for severity, weight := range cfg.Scoring.SeverityWeights {
+		weights[findings.Severity(severity)] = weight
+	}
+	return &Tally{weights: weights, thresholds: cfg.SLO.ThresholdHours, counters: make(map[string]*Counters)}
+}
+
+// counter returns the counters of the subscription.
+func (t *Tally) counter(subscriptionID string) *Counters {
+	c, ok := t.counters[subscriptionID]
+	if !ok {
+		c = &Counters{Findings: make(map[findings.Severity]int)}
+		t.counters[subscriptionID] = c
+	}
+	return c
+}
+
+// AddCompliant counts the compliant resources of the subscriptions.
+func (t *Tally) AddCompliant(compliant map[string]int) {
+	for subID, n := range compliant {
+		t.counter(subID).Compliant += n
+	}
+}
+
+// AddFinding counts a finding.
+func (t *Tally) AddFinding(f findings.Finding) {
+	c := t.counter(f.SubscriptionID)
+	if unevaluatedRules[f.RuleID] {
+		c.Unevaluated++
+		return
+	}
+	c.Findings[f.Severity]++
+	if _, ok := t.thresholds[string(f.Severity)]; ok {
+		c.SLOTracked++
+	}
+}
+
+// AddDisappeared counts the resources deleted while the run evaluated them.
+func (t *Tally) AddDisappeared(resourceIDs []string) {
+	for _, id := range resourceIDs {
+		t.counter(azure.SubscriptionIDOf(id)).Unevaluated++
+	}
+}
+
+// AddBreaches counts the findings breaching the SLO.
+func (t *Tally) AddBreaches(breaches []slo.Record) {
+	for _, r := range breaches {
+		t.counter(r.SubscriptionID).SLOBreached++
+	}
+}
+
+// Scores returns the scores of the subscriptions.
+func (t *Tally) Scores(subscriptionIDs []string) map[string]Score {
+	scores := make(map[string]Score, len(subscriptionIDs))
+	for _, subID := range subscriptionIDs {
+		scores[subID] = Compute(*t.counter(subID), t.weights, len(t.thresholds) > 0)
+	}
+	return scores
+}
+
+// Compute returns the score of the counters, each part rounded to one
+// decimal:
+//
+//	compliance = 100 * compliant / (compliant + sum(weight[severity] * findings[severity]))
+//	coverage   = 100 * evaluated / (evaluated + unevaluated), evaluated = compliant + sum(findings)
+//	slo        = 100 * (1 - breached / tracked)
+//	score      = 0.6 * compliance + 0.2 * coverage + 0.2 * slo
+//
+// A part without anything to count is 100. Without SLO thresholds the score
+// is (0.6 * compliance + 0.2 * coverage) / 0.8.
+//
+// For example 90 compliant resources, a critical, 2 medium and 5 info
+// findings with the default weights, 4 unevaluated resources and 1 of 3
+// tracked findings breaching the SLO: compliance is 9000 / 104 = 86.54,
+// coverage 9800 / 102 = 96.08, slo 66.67 and the score 0.6 * 86.54 +
+// 0.2 * 96.08 + 0.2 * 66.67 = 84.47, rounded to 84.5.
+func Compute(c Counters, weights map[findings.Severity]float64, sloEnabled bool) Score {
+	penalty := 0.0
+	evaluated := c.Compliant
+	for severity, n := range c.Findings {
+		penalty += weights[severity] * float64(n)
+		evaluated += n
+	}
+
+	compliance := ratio(float64(c.Compliant), float64(c.Compliant)+penalty)
+	coverage := ratio(float64(evaluated), float64(evaluated+c.Unevaluated))
+	adherence := 100.0
+	if c.SLOTracked > 0 {
+		adherence = math.Max(0, 100-ratio(float64(c.SLOBreached), float64(c.SLOTracked)))
+	}
+
+	score := (complianceWeight*compliance + coverageWeight*coverage) / (complianceWeight + coverageWeight)
+	if sloEnabled {
+		score = complianceWeight*compliance + coverageWeight*coverage + sloWeight*adherence
+	}
+	return Score{Score: round(score), Compliance: round(compliance), Coverage: round(coverage), SLO: round(adherence)}
+}
+
+// ratio returns part of total as a percentage, 100 if total is zero.
+func ratio(part, total float64) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * part / total
+}
+
+// round rounds to one decimal.
+func round(v float64) float64 {
+	return math.Round(v*10) / 10
+}
@@ -0,0 +1,106 @@
+package scoring
+
+import (
+	"testing"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/config/configtest"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/slo"
+)
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name       string
+		counters   Counters
+		sloEnabled bool
+		want       Score
+	}{
+		{
+			name:       "nothing to count",
+			sloEnabled: true,
+			want:       Score{Score: 100, Compliance: 100, Coverage: 100, SLO: 100},
+		},
+		{
+			// the example of Compute's documentation
+			name: "worked example",
+			counters: Counters{
+				Compliant:   90,
+				Findings:    map[findings.Severity]int{findings.SeverityCritical: 1, findings.SeverityMedium: 2, findings.SeverityInfo: 5},
+				Unevaluated: 4,
+				SLOTracked:  3,
+				SLOBreached: 1,
+			},
+			sloEnabled: true,
+			want:       Score{Score: 84.5, Compliance: 86.5, Coverage: 96.1, SLO: 66.7},
+		},
+		{
+			// 50 / 55 = 90.91, (0.6 * 90.91 + 0.2 * 100) / 0.8 = 93.18
+			name:     "without SLO thresholds",
+			counters: Counters{Compliant: 50, Findings: map[findings.Severity]int{findings.SeverityHigh: 1}},
+			want:     Score{Score: 93.2, Compliance: 90.9, Coverage: 100, SLO: 100},
+		},
+		{
+			// 0.6 * 0 + 0.2 * 100 + 0.2 * 0
+			name:       "every finding critical and breaching",
+			counters:   Counters{Findings: map[findings.Severity]int{findings.SeverityCritical: 2}, SLOTracked: 2, SLOBreached: 2},
+			sloEnabled: true,
+			want:       Score{Score: 20, Compliance: 0, Coverage: 100, SLO: 0},
+		},
+		{
+			// breaches of findings no longer reported don't go below 0
+			name:       "more breaches than tracked findings",
+			counters:   Counters{Compliant: 10, SLOTracked: 1, SLOBreached: 3},
+			sloEnabled: true,
+			want:       Score{Score: 80, Compliance: 100, Coverage: 100, SLO: 0},
+		},
+		{
+			// 0 / 0 compliance is 100, 0 / 3 coverage
+			name:     "nothing evaluated",
+			counters: Counters{Unevaluated: 3},
+			want:     Score{Score: 75, Compliance: 100, Coverage: 0, SLO: 100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Compute(tt.counters, DefaultSeverityWeights, tt.sloEnabled); got != tt.want {
+				t.Errorf("Compute() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTallyScores(t *testing.T) {
+	cfg := configtest.New(t, func(cfg *config.Config) {
+		cfg.SLO.ThresholdHours = map[string]float64{"critical": 24}
+		cfg.Scoring.SeverityWeights = map[string]float64{"info": 1}
+	})
+	finding := func(rule findings.Rule) findings.Finding {
+		return findings.New(rule, nil, configtest.SubscriptionID, "/subscriptions/"+configtest.SubscriptionID+"/resourceGroups/rg", "", nil)
+	}
+
+	tally := NewTally(cfg)
+	tally.AddCompliant(map[string]int{configtest.SubscriptionID: 8})
+	tally.AddFinding(finding(findings.RuleUnownedNextHop))
+	tally.AddFinding(finding(findings.RuleCompatProfile))
+	tally.AddFinding(finding(findings.RuleBlockedByUpstream))
+	tally.AddFinding(finding(findings.RuleBlockedByUpstream))
+	tally.AddDisappeared([]string{"/subscriptions/" + configtest.SubscriptionID + "/resourceGroups/rg/providers/Microsoft.Network/routeTables/gone"})
+	tally.AddBreaches([]slo.Record{{SubscriptionID: configtest.SubscriptionID, Severity: findings.SeverityCritical}})
+
+	// the critical finding weighs 10 and the info one 1, the held back
+	// and deleted resources are unevaluated: compliance 8 / 19 = 42.11,
+	// coverage 10 / 13 = 76.92, slo 0 and the score 0.6 * 42.11 + 0.2 *
+	// 76.92 = 40.65
+	got := tally.Scores([]string{configtest.SubscriptionID, "unscanned"})
+	want := map[string]Score{
+		configtest.SubscriptionID: {Score: 40.6, Compliance: 42.1, Coverage: 76.9, SLO: 0},
+		"unscanned":               {Score: 100, Compliance: 100, Coverage: 100, SLO: 100},
+	}
+	for subID, score := range want {
+		if got[subID] != score {
+			t.Errorf("score of %s = %+v, want %+v", subID, got[subID], score)
+		}
+	}
+}
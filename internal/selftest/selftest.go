@@ -0,0 +1,239 @@
+package selftest
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/notifications"
+	"github.com/akos011221/velora/internal/preflight"
+	"github.com/akos011221/velora/internal/state"
+	"github.com/akos011221/velora/internal/trace"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+)
+
+// DefaultTimeout bounds every check, so one hanging dependency doesn't stall
+// the whole self-test.
+const DefaultTimeout = 30 * time.Second
+
+// stateKey is the state store key written and removed by the state check.
+const stateKey = "selftest"
+
+// Check is a named self-test check.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) (Status, string)
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Name     string        `json:"name"`
+	Status   Status        `json:"status"`
+	Detail   string        `json:"detail,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Options selects the optional checks.
+type Options struct {
+	// Notify sends a test message through every notification channel.
+	Notify bool
+}
+
+// Checks returns the checks for a deployment, in the order they should run.
+func Checks(cfg *config.Config, clientFactory *azure.ClientFactory, opts Options) []Check {
+	checks := []Check{
+		{Name: "config", Run: func(ctx context.Context) (Status, string) { return CheckConfig(cfg) }},
+		{Name: "token", Run: func(ctx context.Context) (Status, string) { return CheckToken(ctx, clientFactory) }},
+	}
+	for _, subID := range cfg.SubscriptionIDs() {
+		subID := subID
+		checks = append(checks, Check{
+			Name: "subscription " + subID,
+			Run: func(ctx context.Context) (Status, string) {
+				return CheckSubscription(ctx, clientFactory.ForSubscription(subID))
+			},
+		})
+	}
+	checks = append(checks,
+		Check{Name: "state", Run: func(ctx context.Context) (Status, string) { return CheckState(cfg, clientFactory) }},
+		Check{Name: "traces", Run: func(ctx context.Context) (Status, string) { return CheckTraces(cfg, clientFactory) }},
+		Check{Name: "notifications", Run: func(ctx context.Context) (Status, string) { return CheckNotifications(ctx, cfg, opts.Notify) }},
+		Check{Name: "tls", Run: func(ctx context.Context) (Status, string) { return CheckTLS(cfg) }},
+	)
+	return checks
+}
+
+// Run runs the checks one after the other, each bounded by timeout.
+func Run(ctx context.Context, checks []Check, timeout time.Duration) []Result {
+	results := make([]Result, 0, len(checks))
+	for _, check := range checks {
+		results = append(results, runCheck(ctx, check, timeout))
+	}
+	return results
+}
+
+// Failed reports whether any check failed.
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// runCheck runs a single check. A check not returning within the timeout
+// fails, its goroutine is left to observe the cancelled context.
+func runCheck(ctx context.Context, check Check, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type outcome struct {
+		status Status
+		detail string
+	}
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		status, detail := check.Run(ctx)
+		done <- outcome{status, detail}
+	}()
+
+	result := Result{Name: check.Name}
+	select {
+	case o := <-done:
+		result.Status, result.Detail = o.status, o.detail
+	case <-ctx.Done():
+		result.Status, result.Detail = StatusFail, fmt.Sprintf("timed out after %s", timeout)
+	}
+	result.Duration = time.Since(start)
+	return result
+}
+
+// CheckConfig validates the configuration.
+func CheckConfig(cfg *config.Config) (Status, string) {
+	if err := cfg.Validate(); err != nil {
+		return StatusFail, err.Error()
+	}
+	if warnings := cfg.Warnings(); len(warnings) > 0 {
+		return StatusWarn, fmt.Sprint(warnings)
+	}
+	return StatusPass, ""
+}
+
+// CheckToken acquires an ARM token.
+func CheckToken(ctx context.Context, clientFactory *azure.ClientFactory) (Status, string) {
+	info, err := clientFactory.CheckAuth(ctx)
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	return StatusPass, fmt.Sprintf("%s credential, object %s", info.CredentialType, info.ObjectID)
+}
+
+// CheckSubscription verifies access to a resource of the subscription.
+// Read-only access is enough for velora to run, in observe mode.
+func CheckSubscription(ctx context.Context, clientFactory *azure.ClientFactory) (Status, string) {
+	access, detail := preflight.CheckAccess(ctx, clientFactory)
+	switch access {
+	case preflight.AccessReadWrite:
+		return StatusPass, string(access)
+	case preflight.AccessRead, preflight.AccessInactive:
+		return StatusWarn, fmt.Sprintf("%s: %s", access, detail)
+	default:
+		return StatusFail, fmt.Sprintf("%s: %s", access, detail)
+	}
+}
+
+// CheckState verifies the state store is readable and writable, with the
+// configured encryption.
+func CheckState(cfg *config.Config, clientFactory *azure.ClientFactory) (Status, string) {
+	backend, err := state.OpenBackend(cfg)
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	where := backend.String()
+	store, err := state.Open(cfg, clientFactory.GetCredential())
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+
+	written := time.Now().UTC().Format(time.RFC3339Nano)
+	if err := store.Put(stateKey, written); err != nil {
+		return StatusFail, err.Error()
+	}
+	var read string
+	if err := store.Get(stateKey, &read); err != nil {
+		return StatusFail, err.Error()
+	}
+	if err := store.Delete(stateKey); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return StatusFail, err.Error()
+	}
+	if read != written {
+		return StatusFail, "state store returned a different value than written"
+	}
+	if cfg.State.Encryption != nil {
+		return StatusPass, where + " (encrypted)"
+	}
+	return StatusPass, where
+}
+
+// CheckTraces lists the active traces, which make runs log much more than
+// usual.
+func CheckTraces(cfg *config.Config, clientFactory *azure.ClientFactory) (Status, string) {
+	store, err := state.Open(cfg, clientFactory.GetCredential())
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	traces, err := trace.NewManager(store).List()
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	if len(traces) == 0 {
+		return StatusPass, "none"
+	}
+
+	active := make([]string, 0, len(traces))
+	for _, t := range traces {
+		active = append(active, t.String())
+	}
+	return StatusWarn, strings.Join(active, "; ")
+}
+
+// CheckNotifications sends a test message through the configured channels.
+func CheckNotifications(ctx context.Context, cfg *config.Config, notify bool) (Status, string) {
+	if cfg.Notifications.Email == nil {
+		return StatusPass, "no channels configured"
+	}
+	if !notify {
+		return StatusWarn, "not verified, run with --notify to send a test message"
+	}
+
+	if err := notifications.NewEmailNotifier(*cfg.Notifications.Email, cfg.DisplayLocation()).SendTest(ctx); err != nil {
+		return StatusFail, fmt.Sprintf("email: %v", err)
+	}
+	return StatusPass, "email: test message sent"
+}
+
+// CheckTLS verifies the API TLS keypair loads.
+func CheckTLS(cfg *config.Config) (Status, string) {
+	if !cfg.API.TLSEnabled {
+		return StatusPass, "tls disabled"
+	}
+	if _, err := tls.LoadX509KeyPair(cfg.API.TLSCertPath, cfg.API.TLSKeyPath); err != nil {
+		return StatusFail, fmt.Sprintf("failed to load keypair: %v", err)
+	}
+	return StatusPass, cfg.API.TLSCertPath
+}
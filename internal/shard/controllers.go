@@ -0,0 +1,76 @@
+package shard
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/akos011221/velora/internal/state"
+)
+
+// controllerClaimsKey is the state store key holding the controller claims
+// of all roles. It is one of state.RoleSharedKeys.
+const controllerClaimsKey = "controller-claims"
+
+// ControllerClaim is the claim of an instance running a role, a subset of
+// the controllers, on its subscriptions. It is renewed by every run.
+type ControllerClaim struct {
+	Instance      string    `json:"instance"`
+	Role          string    `json:"role"`
+	Controllers   []string  `json:"controllers"`
+	Subscriptions []string  `json:"subscriptions"`
+	RenewedAt     time.Time `json:"renewedAt"`
+}
+
+// Expired reports whether the claim is no longer renewed.
+func (c ControllerClaim) Expired(now time.Time, ttl time.Duration) bool {
+	return now.Sub(c.RenewedAt) > ttl
+}
+
+// overlaps reports whether the claim runs one of the controllers on one of
+// the subscriptions.
+func (c ControllerClaim) overlaps(controllers, subscriptions []string) bool {
+	return slices.ContainsFunc(c.Controllers, func(name string) bool { return slices.Contains(controllers, name) }) &&
+		slices.ContainsFunc(c.Subscriptions, func(id string) bool { return slices.Contains(subscriptions, id) })
+}
+
+// ClaimControllers renews the instance's claim on the controllers of its
+// role for the subscriptions. It refuses, without claiming, if a live claim
+// of another instance or role runs one of the controllers on one of the
+// subscriptions: two instances would enforce the same resources. The claims
+// are updated like those of ClaimShard.
+func ClaimControllers(store state.Store, instance, role string, controllers, subscriptions []string, ttl time.Duration) error {
+	return state.Update(store, controllerClaimsKey, func(claims *map[string]ControllerClaim) error {
+		now := time.Now().UTC()
+		key := instance + "/" + role
+		live := make(map[string]ControllerClaim)
+		for name, c := range *claims {
+			if c.Expired(now, ttl) {
+				continue
+			}
+			live[name] = c
+			if name == key {
+				continue
+			}
+			if c.overlaps(controllers, subscriptions) {
+				return fmt.Errorf("instance %s already runs role %s on some of the subscriptions until %s, which overlaps role %s",
+					c.Instance, c.Role, c.RenewedAt.Add(ttl).Format(time.RFC3339), role)
+			}
+		}
+
+		live[key] = ControllerClaim{Instance: instance, Role: role, Controllers: controllers, Subscriptions: subscriptions, RenewedAt: now}
+		*claims = live
+		return nil
+	})
+}
+
+// ControllerClaims returns the controller claims of all roles, by instance
+// and role.
+func ControllerClaims(store state.Store) (map[string]ControllerClaim, error) {
+	claims := make(map[string]ControllerClaim)
+	if err := store.Get(controllerClaimsKey, &claims); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load controller claims: %w", err)
+	}
+	return claims, nil
+}
@@ -0,0 +1,139 @@
+package shard
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// claimsKey is the state store key holding the claims of all instances. It
+// is one of state.SharedKeys.
+const claimsKey = "shard-claims"
+
+// Shard is the part of the subscriptions an instance enforces.
+type Shard struct {
+	Index int `json:"index"`
+	Count int `json:"count"`
+}
+
+// String returns the shard as index/count, as it appears in run records.
+func (s Shard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// Owns reports whether the subscription hashes into the shard.
+func (s Shard) Owns(subscriptionID string) bool {
+	return Of(subscriptionID, s.Count) == s.Index
+}
+
+// Resolve returns the shard of this instance.
+func Resolve(cfg *config.ShardingConfig) (Shard, error) {
+	index, err := cfg.Index()
+	if err != nil {
+		return Shard{}, err
+	}
+	return Shard{Index: index, Count: cfg.ShardCount}, nil
+}
+
+// Of returns the shard of the subscription with rendezvous hashing: the
+// shard scoring highest for the subscription owns it. Adding or removing
+// subscriptions never moves the others. Changing the shard count only
+// moves the subscriptions won or lost by the added or removed shards,
+// about 1/count of them.
+func Of(subscriptionID string, count int) int {
+	id := strings.ToLower(subscriptionID)
+	best := 0
+	var bestScore uint64
+	for i := 0; i < count; i++ {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", id, i)))
+		if score := binary.BigEndian.Uint64(sum[:8]); i == 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// Filter returns the config limited to the subscriptions of the shard.
+func Filter(cfg *config.Config, s Shard) *config.Config {
+	filtered := *cfg
+	filtered.Subscriptions = make(map[string]config.SubscriptionConfig)
+	for id, subCFG := range cfg.Subscriptions {
+		if s.Owns(id) {
+			filtered.Subscriptions[id] = subCFG
+		}
+	}
+	return &filtered
+}
+
+// Claim is an instance's claim on a shard, renewed by every run.
+type Claim struct {
+	Shard
+	Instance  string    `json:"instance"`
+	RenewedAt time.Time `json:"renewedAt"`
+}
+
+// Expired reports whether the claim is no longer renewed.
+func (c Claim) Expired(now time.Time, ttl time.Duration) bool {
+	return now.Sub(c.RenewedAt) > ttl
+}
+
+// Instance returns the name identifying this instance in claims, its host name.
+func Instance() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "unknown"
+	}
+	return name
+}
+
+// ClaimShard renews the instance's claim on the shard. It refuses, without
+// claiming, if a live claim of another instance overlaps the shard: one on
+// the same shard, or on a different shard count, which splits the
+// subscriptions differently.
+//
+// The claims are updated with state.Update. With a backend guaranteeing
+// conditional writes, two instances claiming overlapping shards at once
+// can't both succeed. With the file backend, both may read the claims
+// before either writes them: both claim for this run, the last write wins,
+// and the instance whose claim was lost refuses on its next run.
+func ClaimShard(store state.Store, s Shard, instance string, ttl time.Duration) error {
+	return state.Update(store, claimsKey, func(claims *map[string]Claim) error {
+		now := time.Now().UTC()
+		live := make(map[string]Claim)
+		for name, c := range *claims {
+			if c.Expired(now, ttl) {
+				continue
+			}
+			live[name] = c
+			if name == instance {
+				continue
+			}
+			if c.Count != s.Count {
+				return fmt.Errorf("instance %s claims shard %s, which overlaps shard %s of this instance", name, c.Shard, s)
+			}
+			if c.Index == s.Index {
+				return fmt.Errorf("instance %s already claims shard %s", name, s)
+			}
+		}
+
+		live[instance] = Claim{Shard: s, Instance: instance, RenewedAt: now}
+		*claims = live
+		return nil
+	})
+}
+
+// Claims returns the claims of all instances, by instance.
+func Claims(store state.Store) (map[string]Claim, error) {
+	claims := make(map[string]Claim)
+	if err := store.Get(claimsKey, &claims); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load shard claims: %w", err)
+	}
+	return claims, nil
+}
@@ -0,0 +1,296 @@
+package shard
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/akos011221/velora/internal/state"
+)
+
+// subscriptionIDs returns n subscription IDs, numbered from first.
+func subscriptionIDs(first, n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("00000000-0000-0000-0000-%012d", first+i)
+	}
+	return ids
+}
+
+func TestOf(t *testing.T) {
+	const count = 4
+	perShard := make([]int, count)
+	for _, id := range subscriptionIDs(0, 1000) {
+		got := Of(id, count)
+		if got < 0 || got >= count {
+			t.Fatalf("Of(%s, %d) = %d, out of range", id, count, got)
+		}
+		perShard[got]++
+		if again := Of(id, count); again != got {
+			t.Errorf("Of(%s, %d) = %d then %d, want it deterministic", id, count, got, again)
+		}
+		if upper := Of(strings.ToUpper(id), count); upper != got {
+			t.Errorf("Of(%s, %d) = %d in upper case, %d in lower case", id, count, upper, got)
+		}
+	}
+	// rendezvous hashing spreads the subscriptions evenly
+	for shard, n := range perShard {
+		if n < 200 || n > 300 {
+			t.Errorf("shard %d owns %d of 1000 subscriptions, want about 250", shard, n)
+		}
+	}
+	if got := Of(subscriptionIDs(0, 1)[0], 1); got != 0 {
+		t.Errorf("Of() of a single shard = %d, want 0", got)
+	}
+}
+
+// TestOfMoves checks how many subscriptions change shard when shards or
+// subscriptions are added and removed.
+func TestOfMoves(t *testing.T) {
+	ids := subscriptionIDs(0, 2000)
+	tests := []struct {
+		name     string
+		from, to int
+		// wantMoved is the expected share of the subscriptions moving
+		wantMoved float64
+	}{
+		{name: "shard added", from: 4, to: 5, wantMoved: 1.0 / 5},
+		{name: "shard removed", from: 5, to: 4, wantMoved: 1.0 / 5},
+		{name: "shards doubled", from: 2, to: 4, wantMoved: 2.0 / 4},
+		{name: "same count", from: 4, to: 4, wantMoved: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			moved := 0
+			for _, id := range ids {
+				from, to := Of(id, tt.from), Of(id, tt.to)
+				if from == to {
+					continue
+				}
+				moved++
+				// only the added shards win subscriptions, only the removed
+				// ones lose some
+				if to < tt.from && from < tt.to {
+					t.Errorf("%s moved from shard %d to %d, both kept", id, from, to)
+				}
+			}
+			if share := float64(moved) / float64(len(ids)); share < tt.wantMoved-0.05 || share > tt.wantMoved+0.05 {
+				t.Errorf("%d of %d subscriptions moved, want about %.0f%%", moved, len(ids), tt.wantMoved*100)
+			}
+		})
+	}
+
+	// adding subscriptions moves none of the others
+	before := make(map[string]int)
+	for _, id := range ids {
+		before[id] = Of(id, 4)
+	}
+	for _, id := range append(subscriptionIDs(len(ids), 500), ids...) {
+		if want, ok := before[id]; ok && Of(id, 4) != want {
+			t.Errorf("%s moved from shard %d when subscriptions were added", id, want)
+		}
+	}
+}
+
+// memBackend is an in-memory state backend with conditional writes. Before
+// its first conditional write it calls beforeWrite if set, as an instance
+// writing concurrently.
+type memBackend struct {
+	mu          sync.Mutex
+	values      map[string][]byte
+	versions    map[string]int
+	beforeWrite func()
+}
+
+func newMemBackend() *memBackend {
+	return &memBackend{values: make(map[string][]byte), versions: make(map[string]int)}
+}
+
+func (b *memBackend) Read(key string) ([]byte, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.values[key]
+	if !ok {
+		return nil, "", state.ErrNotFound
+	}
+	return data, strconv.Itoa(b.versions[key]), nil
+}
+
+func (b *memBackend) Write(key string, data []byte, ifVersion string) (string, error) {
+	if hook := b.beforeWrite; hook != nil && ifVersion != state.AnyVersion {
+		b.beforeWrite = nil
+		hook()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, exists := b.values[key]
+	switch {
+	case ifVersion == state.NoVersion && exists,
+		ifVersion != state.AnyVersion && ifVersion != state.NoVersion && (!exists || ifVersion != strconv.Itoa(b.versions[key])):
+		return "", state.ErrConflict
+	}
+	b.values[key] = data
+	b.versions[key]++
+	return strconv.Itoa(b.versions[key]), nil
+}
+
+func (b *memBackend) Delete(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.values, key)
+	return nil
+}
+
+func (b *memBackend) List(prefix string) ([]string, error) {
+	return nil, nil
+}
+
+func (b *memBackend) Guarantees() state.Guarantees {
+	return state.Guarantees{ConditionalWrites: true}
+}
+
+func (b *memBackend) String() string {
+	return "memory"
+}
+
+func TestClaimShard(t *testing.T) {
+	const ttl = 10 * time.Minute
+	now := time.Now().UTC()
+	tests := []struct {
+		name    string
+		claims  map[string]Claim
+		wantErr string
+		// want are the instances claiming after the claim
+		want []string
+	}{
+		{name: "first", want: []string{"b"}},
+		{
+			name:   "renewed",
+			claims: map[string]Claim{"b": {Shard: Shard{Index: 1, Count: 4}, Instance: "b", RenewedAt: now.Add(-time.Minute)}},
+			want:   []string{"b"},
+		},
+		{
+			name:   "other shards",
+			claims: map[string]Claim{"a": {Shard: Shard{Index: 0, Count: 4}, Instance: "a", RenewedAt: now}},
+			want:   []string{"a", "b"},
+		},
+		{
+			name:    "same shard",
+			claims:  map[string]Claim{"a": {Shard: Shard{Index: 1, Count: 4}, Instance: "a", RenewedAt: now}},
+			wantErr: "instance a already claims shard 1/4",
+		},
+		{
+			name:    "other count",
+			claims:  map[string]Claim{"a": {Shard: Shard{Index: 0, Count: 2}, Instance: "a", RenewedAt: now}},
+			wantErr: "instance a claims shard 0/2, which overlaps shard 1/4",
+		},
+		{
+			// the expired claim is dropped
+			name:   "expired",
+			claims: map[string]Claim{"a": {Shard: Shard{Index: 1, Count: 4}, Instance: "a", RenewedAt: now.Add(-2 * ttl)}},
+			want:   []string{"b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := state.NewBackendStore(newMemBackend())
+			if tt.claims != nil {
+				if err := store.Put(claimsKey, tt.claims); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := ClaimShard(store, Shard{Index: 1, Count: 4}, "b", ttl)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ClaimShard() error = %v, want %q", err, tt.wantErr)
+				}
+				claims, _ := Claims(store)
+				if _, ok := claims["b"]; ok {
+					t.Error("ClaimShard() refused, but claimed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ClaimShard() error = %v", err)
+			}
+			claims, err := Claims(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for name := range claims {
+				got = append(got, name)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("claims of %v, want %v", got, tt.want)
+			}
+			if c := claims["b"]; c.Shard != (Shard{Index: 1, Count: 4}) || c.Expired(time.Now(), time.Second) {
+				t.Errorf("claim = %+v, want shard 1/4 renewed now", c)
+			}
+		})
+	}
+}
+
+// TestClaimShardConcurrent checks an instance claiming the same shard
+// between the read and the write of the claims isn't overwritten.
+func TestClaimShardConcurrent(t *testing.T) {
+	backend := newMemBackend()
+	store := state.NewShardStore(state.NewBackendStore(backend), 1)
+	s := Shard{Index: 1, Count: 4}
+	backend.beforeWrite = func() {
+		if err := ClaimShard(store, s, "a", time.Minute); err != nil {
+			t.Errorf("ClaimShard() of the concurrent instance error = %v", err)
+		}
+	}
+
+	if err := ClaimShard(store, s, "b", time.Minute); err == nil || !strings.Contains(err.Error(), "instance a already claims shard 1/4") {
+		t.Fatalf("ClaimShard() error = %v, want the concurrent claim", err)
+	}
+	claims, err := Claims(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := claims["a"]; !ok || len(claims) != 1 {
+		t.Errorf("claims = %v, want the concurrent instance's only", claims)
+	}
+}
+
+func TestClaimControllers(t *testing.T) {
+	backend := newMemBackend()
+	store := state.NewBackendStore(backend)
+	subscriptions := []string{"00000000-0000-0000-0000-000000000002"}
+
+	if err := ClaimControllers(store, "a", "routing", []string{"routing"}, subscriptions, time.Minute); err != nil {
+		t.Fatalf("ClaimControllers() error = %v", err)
+	}
+	// a concurrent claim of another role is kept
+	backend.beforeWrite = func() {
+		if err := ClaimControllers(store, "c", "egress", []string{"egress"}, subscriptions, time.Minute); err != nil {
+			t.Errorf("ClaimControllers() of the concurrent instance error = %v", err)
+		}
+	}
+	if err := ClaimControllers(store, "b", "nsg", []string{"nsg"}, subscriptions, time.Minute); err != nil {
+		t.Fatalf("ClaimControllers() error = %v", err)
+	}
+	if err := ClaimControllers(store, "d", "routing+nsg", []string{"routing", "nsg"}, subscriptions, time.Minute); err == nil {
+		t.Error("ClaimControllers() of overlapping controllers succeeded")
+	}
+
+	claims, err := ControllerClaims(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for name := range claims {
+		got = append(got, name)
+	}
+	slices.Sort(got)
+	if want := []string{"a/routing", "b/nsg", "c/egress"}; !slices.Equal(got, want) {
+		t.Errorf("claims of %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,220 @@
+package slo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/state"
+)
+
+const (
+	// stateKey is the state store key holding the finding history.
+	stateKey = "finding-history"
+	// retention is how long resolved findings are kept for the percentiles.
+	retention = 90 * 24 * time.Hour
+)
+
+// HistogramBounds are the upper bounds of the time-to-remediation buckets,
+// the last bucket is unbounded.
+var HistogramBounds = []time.Duration{
+	time.Hour,
+	4 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+	72 * time.Hour,
+	7 * 24 * time.Hour,
+}
+
+// Record is the lifetime of a finding, from the first run that reported it
+// to the first run that found the resource compliant again. Manual fixes are
+// closed the same way as velora remediations.
+type Record struct {
+	RuleID         string            `json:"ruleId"`
+	Severity       findings.Severity `json:"severity"`
+	SubscriptionID string            `json:"subscriptionId"`
+	ResourceID     string            `json:"resourceId"`
+	FirstSeen      time.Time         `json:"firstSeen"`
+	ResolvedAt     time.Time         `json:"resolvedAt,omitempty"`
+}
+
+// Open reports whether the finding is still reported.
+func (r *Record) Open() bool {
+	return r.ResolvedAt.IsZero()
+}
+
+// Age returns the time to remediation of a resolved finding, or how long an
+// open finding has been open at now.
+func (r *Record) Age(now time.Time) time.Duration {
+	if r.Open() {
+		return now.Sub(r.FirstSeen)
+	}
+	return r.ResolvedAt.Sub(r.FirstSeen)
+}
+
+// Bucket is a time-to-remediation histogram bucket.
+type Bucket struct {
+	// UpperBound is the inclusive upper bound, zero for the unbounded bucket.
+	UpperBound time.Duration `json:"upperBound"`
+	Count      int           `json:"count"`
+}
+
+// RuleStats are the time-to-remediation percentiles of a rule.
+type RuleStats struct {
+	RuleID   string        `json:"ruleId"`
+	Resolved int           `json:"resolved"`
+	P50      time.Duration `json:"p50"`
+	P95      time.Duration `json:"p95"`
+}
+
+// Report is the time-to-remediation summary of the retained history.
+type Report struct {
+	Histogram []Bucket    `json:"histogram"`
+	Rules     []RuleStats `json:"rules"`
+	// Breaches are the open findings older than the threshold of their severity.
+	Breaches []Record `json:"breaches"`
+}
+
+// history is the persisted finding history, keyed by rule and resource.
+type history struct {
+	Records map[string]*Record `json:"records"`
+}
+
+// Tracker joins the findings of every run with the finding history.
+type Tracker struct {
+	store      state.Store
+	thresholds map[findings.Severity]time.Duration
+}
+
+// NewTracker creates a new tracker persisting to the state store, with the
+// breach thresholds of the config.
+func NewTracker(store state.Store, cfg *config.Config) *Tracker {
+	thresholds := make(map[findings.Severity]time.Duration)
+	for severity, hours := range cfg.SLO.ThresholdHours {
+		thresholds[findings.Severity(severity)] = time.Duration(hours * float64(time.Hour))
+	}
+	return &Tracker{store: store, thresholds: thresholds}
+}
+
+// Observe opens a record for every new finding and resolves the open
+// records no longer reported. Records of subscriptions the run didn't
+// evaluate are left untouched, a skipped subscription resolves nothing.
+func (t *Tracker) Observe(all []findings.Finding, evaluated func(subscriptionID string) bool, now time.Time) (*Report, error) {
+	h, err := load(t.store)
+	if err != nil {
+		return nil, err
+	}
+
+	reported := make(map[string]bool)
+	for _, f := range all {
+		k := key(f.RuleID, f.ResourceID)
+		reported[k] = true
+		if r, ok := h.Records[k]; ok && r.Open() {
+			r.Severity = f.Severity
+			continue
+		}
+		h.Records[k] = &Record{
+			RuleID:         f.RuleID,
+			Severity:       f.Severity,
+			SubscriptionID: f.SubscriptionID,
+			ResourceID:     f.ResourceID,
+			FirstSeen:      now,
+		}
+	}
+
+	for k, r := range h.Records {
+		switch {
+		case r.Open() && !reported[k] && evaluated(r.SubscriptionID):
+			r.ResolvedAt = now
+		case !r.Open() && now.Sub(r.ResolvedAt) > retention:
+			delete(h.Records, k)
+		}
+	}
+
+	if err := t.store.Put(stateKey, h); err != nil {
+		return nil, fmt.Errorf("failed to save finding history: %w", err)
+	}
+	return t.report(h, now), nil
+}
+
+// Load returns the report of the persisted history without observing a run.
+func (t *Tracker) Load(now time.Time) (*Report, error) {
+	h, err := load(t.store)
+	if err != nil {
+		return nil, err
+	}
+	return t.report(h, now), nil
+}
+
+// report computes the histogram and percentiles of the resolved records and
+// the breaches of the open ones.
+func (t *Tracker) report(h *history, now time.Time) *Report {
+	report := &Report{}
+	for _, bound := range HistogramBounds {
+		report.Histogram = append(report.Histogram, Bucket{UpperBound: bound})
+	}
+	report.Histogram = append(report.Histogram, Bucket{})
+
+	durations := make(map[string][]time.Duration)
+	for _, r := range h.Records {
+		if r.Open() {
+			if threshold, ok := t.thresholds[r.Severity]; ok && r.Age(now) > threshold {
+				report.Breaches = append(report.Breaches, *r)
+			}
+			continue
+		}
+
+		age := r.Age(now)
+		durations[r.RuleID] = append(durations[r.RuleID], age)
+		i := sort.Search(len(HistogramBounds), func(i int) bool { return age <= HistogramBounds[i] })
+		report.Histogram[i].Count++
+	}
+
+	for ruleID, ds := range durations {
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+		report.Rules = append(report.Rules, RuleStats{
+			RuleID:   ruleID,
+			Resolved: len(ds),
+			P50:      percentile(ds, 0.50),
+			P95:      percentile(ds, 0.95),
+		})
+	}
+	sort.Slice(report.Rules, func(i, j int) bool {
+		return report.Rules[i].RuleID < report.Rules[j].RuleID
+	})
+	sort.Slice(report.Breaches, func(i, j int) bool {
+		return report.Breaches[i].FirstSeen.Before(report.Breaches[j].FirstSeen)
+	})
+	return report
+}
+
+// percentile returns the nearest-rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// key identifies a finding across runs.
+func key(ruleID, resourceID string) string {
+	return ruleID + "|" + strings.ToLower(resourceID)
+}
+
+// load reads the finding history from the state store.
+func load(store state.Store) (*history, error) {
+	h := &history{}
+	if err := store.Get(stateKey, h); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load finding history: %w", err)
+	}
+	if h.Records == nil {
+		h.Records = make(map[string]*Record)
+	}
+	return h, nil
+}
@@ -0,0 +1,69 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrConflict is returned by a conditional write when the value changed
+// since the version it was conditioned on.
+var ErrConflict = errors.New("state: value changed since it was read")
+
+// Versions a write may be conditioned on, besides a version returned by
+// the backend.
+const (
+	// AnyVersion writes unconditionally.
+	AnyVersion = ""
+	// NoVersion only writes a key that doesn't exist.
+	NoVersion = "*"
+)
+
+// Backend keeps the raw values of the state: encoded, maybe encrypted, by
+// key. Stores add the encoding, encryption and scoping on top.
+type Backend interface {
+	// Read returns the value of the key and its version, or ErrNotFound.
+	Read(key string) ([]byte, string, error)
+	// Write replaces the value of the key atomically and returns its new
+	// version. Unless ifVersion is AnyVersion, the value is only written if
+	// its version is still ifVersion, or it doesn't exist for NoVersion,
+	// and ErrConflict is returned otherwise. Backends without
+	// ConditionalWrites refuse conditional writes.
+	Write(key string, data []byte, ifVersion string) (string, error)
+	// Delete removes the key, deleting a missing key is not an error.
+	Delete(key string) error
+	// List returns the keys starting with prefix, sorted.
+	List(prefix string) ([]string, error)
+	// Guarantees returns what the backend guarantees to concurrent users.
+	Guarantees() Guarantees
+	// String describes where the backend keeps the values.
+	String() string
+}
+
+// Guarantees are what a backend guarantees to instances sharing it. Every
+// backend replaces values atomically, readers never see a partial value.
+type Guarantees struct {
+	// ConditionalWrites is set if writes conditioned on a version are
+	// atomic, so instances can update a value without losing each other's
+	// changes.
+	ConditionalWrites bool `json:"conditionalWrites"`
+	// Leases is set if a key can be held exclusively for a time, which
+	// leader election requires. None of the backends offers leases yet.
+	Leases bool `json:"leases"`
+}
+
+// Require returns an error naming the feature if the backend lacks one of
+// the guarantees it needs.
+func Require(backend Backend, feature string, need Guarantees) error {
+	has := backend.Guarantees()
+	if need.ConditionalWrites && !has.ConditionalWrites {
+		return fmt.Errorf("%s requires conditional writes, which the %s state backend doesn't guarantee", feature, backend)
+	}
+	if need.Leases && !has.Leases {
+		return fmt.Errorf("%s requires leases, which the %s state backend doesn't offer", feature, backend)
+	}
+	return nil
+}
+
+// errConditionalWrites is returned by backends without ConditionalWrites
+// for a conditional write.
+var errConditionalWrites = fmt.Errorf("conditional writes are not supported: %w", errors.ErrUnsupported)
@@ -0,0 +1,170 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// testBackend runs the conformance tests every backend passes, the
+// conditional writes according to its guarantees.
+func testBackend(t *testing.T, backend Backend) {
+	t.Run("round trip", func(t *testing.T) {
+		version, err := backend.Write("round-trip", []byte(`{"a":1}`), AnyVersion)
+		if err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		data, readVersion, err := backend.Read("round-trip")
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if string(data) != `{"a":1}` {
+			t.Errorf("Read() = %s, want the written value", data)
+		}
+		if version == "" || readVersion != version {
+			t.Errorf("Read() version = %q, want the written version %q", readVersion, version)
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		if _, _, err := backend.Read("missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Read() error = %v, want %v", err, ErrNotFound)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		first, err := backend.Write("overwrite", []byte(`{"a":1}`), AnyVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := backend.Write("overwrite", []byte(`{"a":2}`), AnyVersion)
+		if err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if second == first {
+			t.Errorf("version %q didn't change with the value", second)
+		}
+		if data, _, _ := backend.Read("overwrite"); string(data) != `{"a":2}` {
+			t.Errorf("Read() = %s, want the last value", data)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if _, err := backend.Write("delete", []byte(`{}`), AnyVersion); err != nil {
+			t.Fatal(err)
+		}
+		if err := backend.Delete("delete"); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		if _, _, err := backend.Read("delete"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Read() of a deleted key error = %v, want %v", err, ErrNotFound)
+		}
+		if err := backend.Delete("delete"); err != nil {
+			t.Errorf("Delete() of a missing key error = %v", err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		for _, key := range []string{"list-c", "list-a", "listed", "list-b", "other"} {
+			if _, err := backend.Write(key, []byte(`{}`), AnyVersion); err != nil {
+				t.Fatal(err)
+			}
+		}
+		keys, err := backend.List("list-")
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if want := []string{"list-a", "list-b", "list-c"}; !slices.Equal(keys, want) {
+			t.Errorf("List() = %v, want %v", keys, want)
+		}
+		all, err := backend.List("")
+		if err != nil {
+			t.Fatalf("List() of every key error = %v", err)
+		}
+		if !slices.IsSorted(all) || !slices.Contains(all, "other") || !slices.Contains(all, "listed") {
+			t.Errorf("List() of every key = %v", all)
+		}
+	})
+
+	t.Run("conditional writes", func(t *testing.T) {
+		if !backend.Guarantees().ConditionalWrites {
+			if _, err := backend.Write("conditional", []byte(`{}`), NoVersion); !errors.Is(err, errors.ErrUnsupported) {
+				t.Errorf("conditional Write() error = %v, want it refused", err)
+			}
+			if _, _, err := backend.Read("conditional"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("refused conditional write left a value: %v", err)
+			}
+			if err := Require(backend, "the feature", Guarantees{ConditionalWrites: true}); err == nil {
+				t.Error("Require() of conditional writes succeeded")
+			}
+			return
+		}
+
+		version, err := backend.Write("conditional", []byte(`{"a":1}`), NoVersion)
+		if err != nil {
+			t.Fatalf("Write() of a new key error = %v", err)
+		}
+		if _, err := backend.Write("conditional", []byte(`{"a":2}`), NoVersion); !errors.Is(err, ErrConflict) {
+			t.Errorf("Write() of an existing key error = %v, want %v", err, ErrConflict)
+		}
+		current, err := backend.Write("conditional", []byte(`{"a":3}`), version)
+		if err != nil {
+			t.Fatalf("Write() on the current version error = %v", err)
+		}
+		// another instance wrote since version was read
+		if _, err := backend.Write("conditional", []byte(`{"a":4}`), version); !errors.Is(err, ErrConflict) {
+			t.Errorf("Write() on a stale version error = %v, want %v", err, ErrConflict)
+		}
+		if data, readVersion, _ := backend.Read("conditional"); string(data) != `{"a":3}` || readVersion != current {
+			t.Errorf("Read() = %s, %q, want the last successful write %q", data, readVersion, current)
+		}
+		if err := Require(backend, "the feature", Guarantees{ConditionalWrites: true}); err != nil {
+			t.Errorf("Require() of conditional writes error = %v", err)
+		}
+	})
+
+	t.Run("leases", func(t *testing.T) {
+		// leader election refuses to run without them
+		err := Require(backend, "leader election", Guarantees{Leases: true})
+		if backend.Guarantees().Leases != (err == nil) {
+			t.Errorf("Require() of leases = %v with guarantees %+v", err, backend.Guarantees())
+		}
+	})
+
+	t.Run("encrypted store", func(t *testing.T) {
+		store := NewBackendStore(backend)
+		provider, err := NewStaticKeys(newDataKey(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.SetSealer(NewSealer(provider))
+		want := record{ResourceID: "/subscriptions/s/resourceGroups/confidential-rg"}
+		if err := store.Put("encrypted", want); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+
+		data, _, err := backend.Read("encrypted")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncrypted(data) || bytes.Contains(data, []byte("confidential-rg")) {
+			t.Errorf("stored value isn't encrypted:\n%s", data)
+		}
+		var got record
+		if err := store.Get("encrypted", &got); err != nil || got != want {
+			t.Errorf("Get() = %+v, %v, want %+v", got, err, want)
+		}
+		if err := NewBackendStore(backend).Get("encrypted", &got); err == nil {
+			t.Error("Get() of encrypted state without encryption succeeded")
+		}
+	})
+}
+
+func TestFileBackend(t *testing.T) {
+	backend, err := NewFileBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, backend)
+}
@@ -0,0 +1,177 @@
+package state
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// encryptedPrefix starts every encrypted value, followed by the format
+	// version and a newline.
+	encryptedPrefix = "velora-state-encrypted:"
+	// encryptionVersion is the version of the format written.
+	encryptionVersion = "v1"
+	// DataKeySize is the size of the AES-256 data keys.
+	DataKeySize = 32
+)
+
+// ErrWrongKey is returned when a value can't be decrypted with the configured keys.
+var ErrWrongKey = errors.New("state: wrong encryption key or corrupted value")
+
+// KeyProvider supplies the data keys of encrypted state.
+type KeyProvider interface {
+	// NewDataKey returns the data key for a write, the ID of the key it
+	// comes from and its wrapped form, both stored with the value.
+	NewDataKey() (key []byte, keyID string, wrapped []byte, err error)
+	// DataKey returns the data key of a stored value.
+	DataKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// envelope is the encrypted form of a value, after the header line.
+type envelope struct {
+	KeyID      string `json:"keyId"`
+	WrappedKey []byte `json:"wrappedKey,omitempty"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Sealer encrypts state values with AES-GCM. The key name is authenticated
+// with the value, so values can't be swapped between keys.
+type Sealer struct {
+	keys KeyProvider
+}
+
+// NewSealer creates a new sealer getting its data keys from keys.
+func NewSealer(keys KeyProvider) *Sealer {
+	return &Sealer{keys: keys}
+}
+
+// IsEncrypted reports whether a stored value is encrypted.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedPrefix))
+}
+
+// Seal encrypts the value stored under name.
+func (s *Sealer) Seal(name string, plaintext []byte) ([]byte, error) {
+	key, keyID, wrapped, err := s.keys.NewDataKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data key: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	body, err := json.Marshal(envelope{
+		KeyID:      keyID,
+		WrappedKey: wrapped,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, []byte(name)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(encryptedPrefix+encryptionVersion+"\n"), body...), nil
+}
+
+// Open decrypts the value stored under name. Plaintext values written before
+// encryption was enabled are returned unchanged.
+func (s *Sealer) Open(name string, data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+
+	header, body, _ := bytes.Cut(data[len(encryptedPrefix):], []byte("\n"))
+	if string(header) != encryptionVersion {
+		return nil, fmt.Errorf("unsupported state encryption version %q", header)
+	}
+	var env envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted state: %w", err)
+	}
+
+	key, err := s.keys.DataKey(env.KeyID, env.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != gcm.NonceSize() {
+		return nil, ErrWrongKey
+	}
+
+	plaintext, err := gcm.Open(nil, env.Nonce, env.Ciphertext, []byte(name))
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	return plaintext, nil
+}
+
+// newGCM creates an AES-GCM cipher for the data key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != DataKeySize {
+		return nil, fmt.Errorf("data key must be %d bytes, got %d", DataKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// StaticKeys is a KeyProvider encrypting with a fixed data key. Previous keys
+// still decrypt the values written with them.
+type StaticKeys struct {
+	currentID string
+	keys      map[string][]byte
+}
+
+// NewStaticKeys creates a new static key provider encrypting with current.
+func NewStaticKeys(current []byte, previous ...[]byte) (*StaticKeys, error) {
+	k := &StaticKeys{keys: make(map[string][]byte)}
+	for i, key := range append([][]byte{current}, previous...) {
+		if len(key) != DataKeySize {
+			return nil, fmt.Errorf("data key must be %d bytes, got %d", DataKeySize, len(key))
+		}
+		id := fingerprint(key)
+		if i == 0 {
+			k.currentID = id
+		}
+		k.keys[id] = key
+	}
+	return k, nil
+}
+
+// NewDataKey implements KeyProvider.
+func (k *StaticKeys) NewDataKey() ([]byte, string, []byte, error) {
+	return k.keys[k.currentID], k.currentID, nil, nil
+}
+
+// DataKey implements KeyProvider.
+func (k *StaticKeys) DataKey(keyID string, _ []byte) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("state was encrypted with data key %s, which isn't configured: %w", keyID, ErrWrongKey)
+	}
+	return key, nil
+}
+
+// fingerprint identifies a data key without revealing it.
+func fingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
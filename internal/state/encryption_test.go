@@ -0,0 +1,331 @@
+package state
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+)
+
+// newDataKey returns a random data key.
+func newDataKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, DataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// newEncryptedStore returns a file store encrypting with the data keys, the
+// first one encrypts, and its backend.
+func newEncryptedStore(t *testing.T, dir string, keys ...[]byte) (*BackendStore, *FileBackend) {
+	t.Helper()
+	backend, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewBackendStore(backend)
+	if len(keys) > 0 {
+		provider, err := NewStaticKeys(keys[0], keys[1:]...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.SetSealer(NewSealer(provider))
+	}
+	return store, backend
+}
+
+// record is a value of the tests.
+type record struct {
+	ResourceID string `json:"resourceId"`
+}
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	store, backend := newEncryptedStore(t, t.TempDir(), newDataKey(t))
+	want := record{ResourceID: "/subscriptions/s/resourceGroups/confidential-rg"}
+
+	if err := store.Put("run", want); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	data, _, err := backend.Read("run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(data) || bytes.Contains(data, []byte("confidential-rg")) {
+		t.Errorf("stored value isn't encrypted:\n%s", data)
+	}
+
+	var got record
+	if err := store.Get("run", &got); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("Get() = %+v, want %+v", got, want)
+	}
+}
+
+func TestEncryptedStoreTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, backend *FileBackend)
+	}{
+		{
+			name: "ciphertext changed",
+			tamper: func(t *testing.T, backend *FileBackend) {
+				data, _, _ := backend.Read("run")
+				header, body, _ := bytes.Cut(data, []byte("\n"))
+				var env envelope
+				if err := json.Unmarshal(body, &env); err != nil {
+					t.Fatal(err)
+				}
+				env.Ciphertext[0] ^= 1
+				body, _ = json.Marshal(env)
+				if _, err := backend.Write("run", append(append(header, '\n'), body...), AnyVersion); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "value of another key",
+			tamper: func(t *testing.T, backend *FileBackend) {
+				data, _, _ := backend.Read("other")
+				if _, err := backend.Write("run", data, AnyVersion); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, backend := newEncryptedStore(t, t.TempDir(), newDataKey(t))
+			if err := store.Put("run", record{ResourceID: "run"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Put("other", record{ResourceID: "other"}); err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(t, backend)
+
+			var got record
+			if err := store.Get("run", &got); !errors.Is(err, ErrWrongKey) {
+				t.Errorf("Get() = %+v, %v, want %v", got, err, ErrWrongKey)
+			}
+		})
+	}
+}
+
+func TestEncryptedStoreKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, newKey := newDataKey(t), newDataKey(t)
+	before, _ := newEncryptedStore(t, dir, oldKey)
+	if err := before.Put("run", record{ResourceID: "old"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the old key decrypts the values written before the rotation
+	rotated, backend := newEncryptedStore(t, dir, newKey, oldKey)
+	var got record
+	if err := rotated.Get("run", &got); err != nil || got.ResourceID != "old" {
+		t.Fatalf("Get() after rotation = %+v, %v, want the old value", got, err)
+	}
+
+	// the new key encrypts, the old one is no longer needed once rewritten
+	if err := rotated.Put("run", got); err != nil {
+		t.Fatal(err)
+	}
+	data, _, _ := backend.Read("run")
+	if !bytes.Contains(data, []byte(fingerprint(newKey))) {
+		t.Errorf("rewritten value isn't encrypted with the new key:\n%s", data)
+	}
+	newOnly, _ := newEncryptedStore(t, dir, newKey)
+	if err := newOnly.Get("run", &got); err != nil {
+		t.Errorf("Get() with the new key only = %v", err)
+	}
+
+	// a value of a key that was dropped can't be read
+	if err := before.Get("run", &got); !errors.Is(err, ErrWrongKey) || !strings.Contains(err.Error(), "isn't configured") {
+		t.Errorf("Get() with the old key only = %v, want %v", err, ErrWrongKey)
+	}
+}
+
+func TestEncryptedStorePlaintextFallback(t *testing.T) {
+	dir := t.TempDir()
+	plain, backend := newEncryptedStore(t, dir)
+	if err := plain.Put("run", record{ResourceID: "plain"}); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, _ := newEncryptedStore(t, dir, newDataKey(t))
+	var got record
+	if err := encrypted.Get("run", &got); err != nil || got.ResourceID != "plain" {
+		t.Fatalf("Get() of plaintext state = %+v, %v, want it read", got, err)
+	}
+	if err := encrypted.Put("run", got); err != nil {
+		t.Fatal(err)
+	}
+	if data, _, _ := backend.Read("run"); !IsEncrypted(data) {
+		t.Errorf("plaintext state isn't encrypted once saved again:\n%s", data)
+	}
+
+	// encrypted state isn't silently ignored without encryption
+	if err := plain.Get("run", &got); err == nil || !strings.Contains(err.Error(), "state.encryption must be configured") {
+		t.Errorf("Get() of encrypted state without encryption = %v", err)
+	}
+}
+
+func TestNewKeyProviderFromEnv(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(newDataKey(t))
+	tests := []struct {
+		name    string
+		env     map[string]string
+		enc     config.StateEncryptionConfig
+		wantErr string
+	}{
+		{
+			name: "data key",
+			env:  map[string]string{"TEST_STATE_KEY": key},
+			enc:  config.StateEncryptionConfig{DataKeyEnv: "TEST_STATE_KEY"},
+		},
+		{
+			name:    "variable not set",
+			enc:     config.StateEncryptionConfig{DataKeyEnv: "TEST_STATE_KEY_UNSET"},
+			wantErr: "TEST_STATE_KEY_UNSET is not set",
+		},
+		{
+			name:    "not base64",
+			env:     map[string]string{"TEST_STATE_KEY": "not a key!"},
+			enc:     config.StateEncryptionConfig{DataKeyEnv: "TEST_STATE_KEY"},
+			wantErr: "not valid base64",
+		},
+		{
+			name:    "short key",
+			env:     map[string]string{"TEST_STATE_KEY": base64.StdEncoding.EncodeToString([]byte("short"))},
+			enc:     config.StateEncryptionConfig{DataKeyEnv: "TEST_STATE_KEY"},
+			wantErr: "must be 32 bytes, got 5",
+		},
+		{
+			name:    "previous key not set",
+			env:     map[string]string{"TEST_STATE_KEY": key},
+			enc:     config.StateEncryptionConfig{DataKeyEnv: "TEST_STATE_KEY", PreviousDataKeyEnvs: []string{"TEST_STATE_KEY_OLD"}},
+			wantErr: "TEST_STATE_KEY_OLD is not set",
+		},
+		{
+			name:    "key vault without credential",
+			enc:     config.StateEncryptionConfig{KeyID: "https://vault.vault.azure.net/keys/state"},
+			wantErr: "an azure credential is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			_, err := NewKeyProvider(&tt.enc, nil)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("NewKeyProvider() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewKeyProvider() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fakeKeyVault wraps data keys with the current version of its key, by XOR
+// with the last byte of the version, and counts the unwraps.
+type fakeKeyVault struct {
+	server  *httptest.Server
+	version string
+	unwraps int
+}
+
+func newFakeKeyVault(t *testing.T) *fakeKeyVault {
+	kv := &fakeKeyVault{version: "v1"}
+	kv.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var op keyOperation
+		if err := json.NewDecoder(r.Body).Decode(&op); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		value, _ := base64.RawURLEncoding.DecodeString(op.Value)
+		var version string
+		switch rest := strings.TrimPrefix(r.URL.Path, "/keys/state/"); {
+		case rest == "wrapkey":
+			version = kv.version
+		case strings.HasSuffix(rest, "/unwrapkey"):
+			version = strings.TrimSuffix(rest, "/unwrapkey")
+			kv.unwraps++
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for i := range value {
+			value[i] ^= version[len(version)-1]
+		}
+		_ = json.NewEncoder(w).Encode(keyOperation{KeyID: kv.server.URL + "/keys/state/" + version, Value: base64.RawURLEncoding.EncodeToString(value)})
+	}))
+	t.Cleanup(kv.server.Close)
+	return kv
+}
+
+// keys returns a provider of the fake Key Vault's key.
+func (kv *fakeKeyVault) keys() *KeyVaultKeys {
+	keys := NewKeyVaultKeys(kv.server.URL+"/keys/state", azuretest.Credential{})
+	keys.client.httpClient = kv.server.Client()
+	return keys
+}
+
+func TestKeyVaultKeysRotation(t *testing.T) {
+	kv := newFakeKeyVault(t)
+	dir := t.TempDir()
+	backend, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewBackendStore(backend)
+	store.SetSealer(NewSealer(kv.keys()))
+	if err := store.Put("run", record{ResourceID: "v1"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	// the Key Vault key is rotated, new writes wrap with its new version
+	kv.version = "v2"
+	rotated := NewBackendStore(backend)
+	rotated.SetSealer(NewSealer(kv.keys()))
+	var got record
+	if err := rotated.Get("run", &got); err != nil || got.ResourceID != "v1" {
+		t.Fatalf("Get() after rotation = %+v, %v, want the value wrapped with v1", got, err)
+	}
+	if err := rotated.Put("run", record{ResourceID: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	data, _, _ := backend.Read("run")
+	if !bytes.Contains(data, []byte("/keys/state/v2")) {
+		t.Errorf("value isn't wrapped with the new key version:\n%s", data)
+	}
+
+	// unwrapped data keys are cached
+	unwraps := kv.unwraps
+	for i := 0; i < 3; i++ {
+		if err := rotated.Get("run", &got); err != nil || got.ResourceID != "v2" {
+			t.Fatalf("Get() = %+v, %v", got, err)
+		}
+	}
+	if kv.unwraps != unwraps+1 {
+		t.Errorf("unwraps for three reads = %d, want 1", kv.unwraps-unwraps)
+	}
+}
@@ -0,0 +1,115 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// fileSuffix is the extension of the files of the keys.
+const fileSuffix = ".json"
+
+// FileBackend is a Backend keeping each key as a file in a directory. It
+// doesn't guarantee conditional writes: instances on different hosts may
+// share the directory over a network file system without locks.
+type FileBackend struct {
+	dir string
+}
+
+// NewFileBackend creates a new file backend in dir, creating it if needed.
+func NewFileBackend(dir string) (*FileBackend, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return &FileBackend{dir: dir}, nil
+}
+
+// Read implements Backend, the version is the hash of the content.
+func (b *FileBackend) Read(key string) ([]byte, string, error) {
+	data, err := os.ReadFile(b.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return data, fileVersion(data), nil
+}
+
+// Write implements Backend. The value is written to a temporary file and
+// renamed so readers never see a partially written value.
+func (b *FileBackend) Write(key string, data []byte, ifVersion string) (string, error) {
+	if ifVersion != AnyVersion {
+		return "", errConditionalWrites
+	}
+
+	tmp, err := os.CreateTemp(b.dir, key+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), b.path(key)); err != nil {
+		return "", err
+	}
+	return fileVersion(data), nil
+}
+
+// Delete implements Backend.
+func (b *FileBackend) Delete(key string) error {
+	if err := os.Remove(b.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List implements Backend.
+func (b *FileBackend) List(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the state directory: %w", err)
+	}
+	var keys []string
+	for _, entry := range entries {
+		key, ok := strings.CutSuffix(entry.Name(), fileSuffix)
+		if entry.IsDir() || !ok || !strings.HasPrefix(key, prefix) || !validKey.MatchString(key) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Guarantees implements Backend.
+func (b *FileBackend) Guarantees() Guarantees {
+	return Guarantees{}
+}
+
+// String implements Backend.
+func (b *FileBackend) String() string {
+	return "file " + b.dir
+}
+
+// path returns the file holding the key.
+func (b *FileBackend) path(key string) string {
+	return filepath.Join(b.dir, key+fileSuffix)
+}
+
+// fileVersion returns the version of the content of a file.
+func fileVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,171 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/akos011221/velora/internal/redact"
+)
+
+const (
+	// keyVaultScope is the token scope of Key Vault.
+	keyVaultScope = "https://vault.azure.net/.default"
+	// keyVaultAPIVersion is the Key Vault API version.
+	keyVaultAPIVersion = "7.4"
+	// wrapAlgorithm is the algorithm data keys are wrapped with.
+	wrapAlgorithm = "RSA-OAEP-256"
+	// keyVaultTimeout bounds every Key Vault request.
+	keyVaultTimeout = 30 * time.Second
+)
+
+// keyVaultClient makes Key Vault data plane requests.
+type keyVaultClient struct {
+	cred       azcore.TokenCredential
+	httpClient *http.Client
+}
+
+// newKeyVaultClient creates a new Key Vault client authenticating with cred.
+func newKeyVaultClient(cred azcore.TokenCredential) *keyVaultClient {
+	return &keyVaultClient{cred: cred, httpClient: &http.Client{Timeout: keyVaultTimeout}}
+}
+
+// do sends a request to the Key Vault URL and decodes the response into out.
+func (c *keyVaultClient) do(method, url string, in, out any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), keyVaultTimeout)
+	defer cancel()
+
+	token, err := c.cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{keyVaultScope}})
+	if err != nil {
+		return fmt.Errorf("failed to acquire key vault token: %w", err)
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(url, "/")+"?api-version="+keyVaultAPIVersion, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("key vault returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// getSecret returns the value of the Key Vault secret.
+func (c *keyVaultClient) getSecret(secretID string) (string, error) {
+	var secret struct {
+		Value string `json:"value"`
+	}
+	if err := c.do(http.MethodGet, secretID, nil, &secret); err != nil {
+		return "", fmt.Errorf("failed to get secret %s: %w", secretID, err)
+	}
+	redact.Register(secret.Value)
+	return secret.Value, nil
+}
+
+// keyOperation is the request and response of wrapkey and unwrapkey.
+type keyOperation struct {
+	KeyID     string `json:"kid,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Value     string `json:"value"`
+}
+
+// KeyVaultKeys is a KeyProvider wrapping a new data key for every write
+// with a Key Vault key. The wrapping key version is stored with the value,
+// so rotating the Key Vault key doesn't make older state unreadable.
+type KeyVaultKeys struct {
+	client *keyVaultClient
+	keyID  string
+
+	mu sync.Mutex
+	// unwrapped caches the unwrapped data keys by wrapped key.
+	unwrapped map[string][]byte
+}
+
+// NewKeyVaultKeys creates a new provider wrapping data keys with the key.
+func NewKeyVaultKeys(keyID string, cred azcore.TokenCredential) *KeyVaultKeys {
+	return &KeyVaultKeys{
+		client:    newKeyVaultClient(cred),
+		keyID:     keyID,
+		unwrapped: make(map[string][]byte),
+	}
+}
+
+// NewDataKey implements KeyProvider.
+func (k *KeyVaultKeys) NewDataKey() ([]byte, string, []byte, error) {
+	key := make([]byte, DataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, "", nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	var result keyOperation
+	err := k.client.do(http.MethodPost, k.keyID+"/wrapkey", keyOperation{
+		Algorithm: wrapAlgorithm,
+		Value:     base64.RawURLEncoding.EncodeToString(key),
+	}, &result)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to wrap data key with %s: %w", k.keyID, err)
+	}
+	wrapped, err := base64.RawURLEncoding.DecodeString(result.Value)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to decode wrapped data key: %w", err)
+	}
+	return key, result.KeyID, wrapped, nil
+}
+
+// DataKey implements KeyProvider.
+func (k *KeyVaultKeys) DataKey(keyID string, wrapped []byte) ([]byte, error) {
+	cacheKey := keyID + "|" + string(wrapped)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.unwrapped[cacheKey]; ok {
+		return key, nil
+	}
+
+	if keyID == "" || len(wrapped) == 0 {
+		return nil, fmt.Errorf("state wasn't encrypted with a key vault key: %w", ErrWrongKey)
+	}
+	var result keyOperation
+	err := k.client.do(http.MethodPost, keyID+"/unwrapkey", keyOperation{
+		Algorithm: wrapAlgorithm,
+		Value:     base64.RawURLEncoding.EncodeToString(wrapped),
+	}, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with %s: %w", keyID, err)
+	}
+	key, err := base64.RawURLEncoding.DecodeString(result.Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode unwrapped data key: %w", err)
+	}
+
+	k.unwrapped[cacheKey] = key
+	return key, nil
+}
@@ -0,0 +1,118 @@
+package state
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/redact"
+)
+
+// Open opens the configured state store, encrypted if state.encryption is
+// set, scoped to the shard of the instance if sharding is configured and to
+// its role if it runs only some controllers. cred is only used for keys in
+// Key Vault and may be nil otherwise.
+func Open(cfg *config.Config, cred azcore.TokenCredential) (Store, error) {
+	backend, err := OpenBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	store := NewBackendStore(backend)
+
+	if enc := cfg.State.Encryption; enc != nil {
+		keys, err := NewKeyProvider(enc, cred)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up state encryption: %w", err)
+		}
+		store.SetSealer(NewSealer(keys))
+	}
+
+	var opened Store = store
+	if cfg.Sharding != nil {
+		index, err := cfg.Sharding.Index()
+		if err != nil {
+			return nil, err
+		}
+		opened = NewShardStore(store, index)
+	}
+	if role := cfg.Role(); role != "" {
+		opened = NewRoleStore(opened, role)
+	}
+	return opened, nil
+}
+
+// OpenBackend opens the configured state backend.
+func OpenBackend(cfg *config.Config) (Backend, error) {
+	if cfg.State.EffectiveBackend() == config.StateBackendS3 {
+		return NewS3Backend(cfg.State.S3)
+	}
+	dir, err := cfg.StatePath()
+	if err != nil {
+		return nil, err
+	}
+	return NewFileBackend(dir)
+}
+
+// NewKeyProvider creates the key provider of the encryption configuration.
+func NewKeyProvider(enc *config.StateEncryptionConfig, cred azcore.TokenCredential) (KeyProvider, error) {
+	if enc.UsesKeyVault() && cred == nil {
+		return nil, fmt.Errorf("an azure credential is required for keys in key vault")
+	}
+	if enc.KeyID != "" {
+		return NewKeyVaultKeys(enc.KeyID, cred), nil
+	}
+
+	var current []byte
+	var err error
+	if enc.DataKeySecretID != "" {
+		value, err := newKeyVaultClient(cred).getSecret(enc.DataKeySecretID)
+		if err != nil {
+			return nil, err
+		}
+		if current, err = decodeDataKey(value); err != nil {
+			return nil, fmt.Errorf("invalid data key in secret %s: %w", enc.DataKeySecretID, err)
+		}
+	} else if current, err = dataKeyFromEnv(enc.DataKeyEnv); err != nil {
+		return nil, err
+	}
+
+	var previous [][]byte
+	for _, env := range enc.PreviousDataKeyEnvs {
+		key, err := dataKeyFromEnv(env)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+	return NewStaticKeys(current, previous...)
+}
+
+// dataKeyFromEnv reads a base64 encoded data key from the environment variable.
+func dataKeyFromEnv(name string) ([]byte, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil, fmt.Errorf("state encryption key variable %s is not set", name)
+	}
+	redact.Register(value)
+	key, err := decodeDataKey(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data key in %s: %w", name, err)
+	}
+	return key, nil
+}
+
+// decodeDataKey decodes a base64 encoded 256-bit data key.
+func decodeDataKey(value string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("not valid base64: %w", err)
+	}
+	if len(key) != DataKeySize {
+		return nil, fmt.Errorf("must be %d bytes, got %d", DataKeySize, len(key))
+	}
+	return key, nil
+}
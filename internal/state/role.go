@@ -0,0 +1,68 @@
+package state
+
+import (
+	"slices"
+	"strings"
+)
+
+// RoleSharedKeys are the keys the roles of a deployment split by controller
+// share on top of SharedKeys: the statistics and the run log, with the
+// findings of its runs under RunLogKeyPrefix, so their runs make up one
+// history, and the controller claims detecting overlapping roles. They must
+// match the keys of those packages.
+var RoleSharedKeys = []string{"stats-history", "run-log", "controller-claims"}
+
+// RunLogKeyPrefix prefixes the keys of the findings of the run log.
+const RunLogKeyPrefix = "run-log-"
+
+// RoleStore is a Store keeping the keys of one role apart from the other
+// roles using the same store, except for SharedKeys and RoleSharedKeys.
+type RoleStore struct {
+	store  Store
+	prefix string
+}
+
+// NewRoleStore creates a new store for the role, on top of store. The role
+// is a config.Config.Role, its "+" aren't allowed in keys.
+func NewRoleStore(store Store, role string) *RoleStore {
+	return &RoleStore{store: store, prefix: "role-" + strings.ReplaceAll(role, "+", "_") + "."}
+}
+
+// Get implements Store.
+func (s *RoleStore) Get(key string, v any) error {
+	return s.store.Get(s.key(key), v)
+}
+
+// Put implements Store.
+func (s *RoleStore) Put(key string, v any) error {
+	return s.store.Put(s.key(key), v)
+}
+
+// Delete implements Store.
+func (s *RoleStore) Delete(key string) error {
+	return s.store.Delete(s.key(key))
+}
+
+// getVersion implements versionedStore, if the underlying store does.
+func (s *RoleStore) getVersion(key string, v any) (string, error) {
+	return s.store.(versionedStore).getVersion(s.key(key), v)
+}
+
+// putIfVersion implements versionedStore, if the underlying store does.
+func (s *RoleStore) putIfVersion(key string, v any, ifVersion string) error {
+	return s.store.(versionedStore).putIfVersion(s.key(key), v, ifVersion)
+}
+
+// conditionalWrites implements versionedStore.
+func (s *RoleStore) conditionalWrites() bool {
+	vs, ok := s.store.(versionedStore)
+	return ok && vs.conditionalWrites()
+}
+
+// key returns the key in the underlying store.
+func (s *RoleStore) key(key string) string {
+	if slices.Contains(SharedKeys, key) || slices.Contains(RoleSharedKeys, key) || strings.HasPrefix(key, RunLogKeyPrefix) {
+		return key
+	}
+	return s.prefix + key
+}
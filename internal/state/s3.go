@@ -0,0 +1,369 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/redact"
+)
+
+// s3Timeout bounds every S3 request.
+const s3Timeout = 30 * time.Second
+
+// S3Backend is a Backend keeping each key as an object of an S3-compatible
+// bucket, signed with AWS Signature Version 4. Writes conditioned on a
+// version use the ETag of the object with If-Match, or If-None-Match for
+// NoVersion, which AWS S3 and MinIO support.
+type S3Backend struct {
+	cfg        *config.S3StateConfig
+	endpoint   *url.URL
+	accessKey  string
+	secretKey  string
+	httpClient *http.Client
+}
+
+// NewS3Backend creates a new backend on the bucket of the configuration,
+// with the credentials of its environment variables.
+func NewS3Backend(cfg *config.S3StateConfig) (*S3Backend, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state.s3.endpoint: %w", err)
+	}
+	accessKey := os.Getenv(cfg.EffectiveAccessKeyIDEnv())
+	secretKey := os.Getenv(cfg.EffectiveSecretAccessKeyEnv())
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("S3 credential variables %s and %s must be set", cfg.EffectiveAccessKeyIDEnv(), cfg.EffectiveSecretAccessKeyEnv())
+	}
+	redact.Register(secretKey)
+	return &S3Backend{
+		cfg:        cfg,
+		endpoint:   endpoint,
+		accessKey:  accessKey,
+		secretKey:  secretKey,
+		httpClient: &http.Client{Timeout: s3Timeout},
+	}, nil
+}
+
+// Read implements Backend, the version is the ETag of the object.
+func (b *S3Backend) Read(key string) ([]byte, string, error) {
+	resp, err := b.do(http.MethodGet, b.cfg.Prefix+key+fileSuffix, nil, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if err := s3Error(resp); !isNoSuchKey(err) {
+			return nil, "", err
+		}
+		return nil, "", ErrNotFound
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read object: %w", err)
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+// Write implements Backend.
+func (b *S3Backend) Write(key string, data []byte, ifVersion string) (string, error) {
+	header := http.Header{}
+	switch ifVersion {
+	case AnyVersion:
+	case NoVersion:
+		header.Set("If-None-Match", "*")
+	default:
+		header.Set("If-Match", ifVersion)
+	}
+	header.Set("Content-Type", "application/json")
+
+	resp, err := b.do(http.MethodPut, b.cfg.Prefix+key+fileSuffix, nil, header, data)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Header.Get("ETag"), nil
+	// 409 is a concurrent conditional write in progress
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		if ifVersion != AnyVersion {
+			return "", ErrConflict
+		}
+	}
+	return "", s3Error(resp)
+}
+
+// Delete implements Backend.
+func (b *S3Backend) Delete(key string) error {
+	resp, err := b.do(http.MethodDelete, b.cfg.Prefix+key+fileSuffix, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		if err := s3Error(resp); !isNoSuchKey(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// listResult is the response of ListObjectsV2.
+type listResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List implements Backend, it pages through ListObjectsV2.
+func (b *S3Backend) List(prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {b.cfg.Prefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := b.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		var page listResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse object list: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			key, ok := strings.CutSuffix(strings.TrimPrefix(object.Key, b.cfg.Prefix), fileSuffix)
+			if ok && validKey.MatchString(key) {
+				keys = append(keys, key)
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Guarantees implements Backend.
+func (b *S3Backend) Guarantees() Guarantees {
+	return Guarantees{ConditionalWrites: true}
+}
+
+// String implements Backend.
+func (b *S3Backend) String() string {
+	return fmt.Sprintf("s3 %s/%s/%s", strings.TrimSuffix(b.cfg.Endpoint, "/"), b.cfg.Bucket, b.cfg.Prefix)
+}
+
+// objectURL returns the URL of the object of the bucket, or of the bucket
+// for an empty name.
+func (b *S3Backend) objectURL(name string, query url.Values) *url.URL {
+	u := *b.endpoint
+	path := "/" + b.cfg.Bucket + "/" + name
+	if b.cfg.VirtualHostedStyle {
+		u.Host = b.cfg.Bucket + "." + u.Host
+		path = "/" + name
+	}
+	u.Path = path
+	u.RawPath = s3EscapePath(path)
+	u.RawQuery = s3EscapeQuery(query)
+	return &u
+}
+
+// do sends a signed request for the object, or the bucket for an empty name.
+func (b *S3Backend) do(method, name string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	u := b.objectURL(name, query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.ContentLength = int64(len(body))
+	b.sign(req, body, time.Now().UTC())
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("S3 request failed: %w", err)
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody releases the context of a request once its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close implements io.Closer.
+func (c *cancelBody) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// sign adds the AWS Signature Version 4 of the request, signing its host,
+// the x-amz headers and the conditional headers.
+func (b *S3Backend) sign(req *http.Request, body []byte, now time.Time) {
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "if-match" || lower == "if-none-match" || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + b.cfg.EffectiveRegion() + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + b.secretKey)
+	for _, part := range []string{date, b.cfg.EffectiveRegion(), "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.accessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the data with the key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape escapes all but the unreserved characters, as Signature Version
+// 4 requires.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// s3EscapePath escapes the segments of the path.
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3EscapeQuery encodes the query sorted by name, as Signature Version 4
+// requires.
+func s3EscapeQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, s3Escape(name)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3ResponseError is the error of a failed response.
+type s3ResponseError struct {
+	status  int
+	code    string
+	message string
+}
+
+// Error implements error.
+func (e *s3ResponseError) Error() string {
+	if e.code == "" {
+		return fmt.Sprintf("S3 request failed with status %d", e.status)
+	}
+	return fmt.Sprintf("S3 request failed with status %d: %s: %s", e.status, e.code, e.message)
+}
+
+// s3Error returns the error of a failed response, with the S3 error code.
+func s3Error(resp *http.Response) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	err := &s3ResponseError{status: resp.StatusCode}
+	if xml.Unmarshal(data, &body) == nil {
+		err.code, err.message = body.Code, body.Message
+	}
+	return err
+}
+
+// isNoSuchKey reports whether the error is of a missing object. A missing
+// bucket is a misconfiguration, not an empty state.
+func isNoSuchKey(err error) bool {
+	var respErr *s3ResponseError
+	return errors.As(err, &respErr) && respErr.status == http.StatusNotFound && respErr.code != "NoSuchBucket"
+}
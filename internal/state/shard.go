@@ -0,0 +1,63 @@
+package state
+
+import (
+	"fmt"
+	"slices"
+)
+
+// SharedKeys are the keys all shards of a sharded deployment share: pauses,
+// failovers and traces apply to every instance, the shard claims detect
+// overlapping instances, and acknowledgments follow a subscription moving
+// to another shard. They must match the keys of those packages.
+var SharedKeys = []string{"pauses", "failovers", "traces", "shard-claims", "onboarding"}
+
+// ShardStore is a Store keeping the keys of one shard apart from the other
+// shards using the same store, except for SharedKeys.
+type ShardStore struct {
+	store  Store
+	prefix string
+}
+
+// NewShardStore creates a new store for the shard, on top of store.
+func NewShardStore(store Store, index int) *ShardStore {
+	return &ShardStore{store: store, prefix: fmt.Sprintf("shard-%d.", index)}
+}
+
+// Get implements Store.
+func (s *ShardStore) Get(key string, v any) error {
+	return s.store.Get(s.key(key), v)
+}
+
+// Put implements Store.
+func (s *ShardStore) Put(key string, v any) error {
+	return s.store.Put(s.key(key), v)
+}
+
+// Delete implements Store.
+func (s *ShardStore) Delete(key string) error {
+	return s.store.Delete(s.key(key))
+}
+
+// getVersion implements versionedStore, if the underlying store does.
+func (s *ShardStore) getVersion(key string, v any) (string, error) {
+	return s.store.(versionedStore).getVersion(s.key(key), v)
+}
+
+// putIfVersion implements versionedStore, if the underlying store does.
+func (s *ShardStore) putIfVersion(key string, v any, ifVersion string) error {
+	return s.store.(versionedStore).putIfVersion(s.key(key), v, ifVersion)
+}
+
+// conditionalWrites implements versionedStore.
+func (s *ShardStore) conditionalWrites() bool {
+	vs, ok := s.store.(versionedStore)
+	return ok && vs.conditionalWrites()
+}
+
+// key returns the key in the underlying store.
+func (s *ShardStore) key(key string) string {
+	if slices.Contains(SharedKeys, key) {
+		return key
+	}
+	return s.prefix + key
+}
@@ -0,0 +1,136 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ErrNotFound is returned when a key doesn't exist in the store.
+var ErrNotFound = errors.New("state: key not found")
+
+// validKey restricts keys to safe file and object names.
+var validKey = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Store persists velora's state between runs.
+type Store interface {
+	// Get decodes the value stored under key into v, or returns ErrNotFound.
+	Get(key string, v any) error
+	// Put stores v under key, replacing any previous value.
+	Put(key string, v any) error
+	// Delete removes the key, deleting a missing key is not an error.
+	Delete(key string) error
+}
+
+// BackendStore is a Store keeping each key as a JSON value in a backend,
+// encrypted if a sealer is set.
+type BackendStore struct {
+	backend Backend
+	// sealer encrypts the values, nil stores plaintext.
+	sealer *Sealer
+}
+
+// NewBackendStore creates a new store on top of the backend.
+func NewBackendStore(backend Backend) *BackendStore {
+	return &BackendStore{backend: backend}
+}
+
+// Backend returns the backend of the store.
+func (s *BackendStore) Backend() Backend {
+	return s.backend
+}
+
+// SetSealer encrypts the values written from now on. Plaintext values are
+// still read, and encrypted when they're saved again.
+func (s *BackendStore) SetSealer(sealer *Sealer) {
+	s.sealer = sealer
+}
+
+// Get implements Store.
+func (s *BackendStore) Get(key string, v any) error {
+	_, err := s.getVersion(key, v)
+	return err
+}
+
+// getVersion implements versionedStore.
+func (s *BackendStore) getVersion(key string, v any) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+
+	data, version, err := s.backend.Read(key)
+	if errors.Is(err, ErrNotFound) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read state %s: %w", key, err)
+	}
+
+	if IsEncrypted(data) {
+		if s.sealer == nil {
+			return "", fmt.Errorf("state %s is encrypted, state.encryption must be configured to read it", key)
+		}
+		if data, err = s.sealer.Open(key, data); err != nil {
+			return "", fmt.Errorf("failed to decrypt state %s: %w", key, err)
+		}
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return "", fmt.Errorf("failed to parse state %s: %w", key, err)
+	}
+	return version, nil
+}
+
+// Put implements Store. Backends replace values atomically, readers never
+// see a partially written value.
+func (s *BackendStore) Put(key string, v any) error {
+	return s.putIfVersion(key, v, AnyVersion)
+}
+
+// putIfVersion implements versionedStore.
+func (s *BackendStore) putIfVersion(key string, v any, ifVersion string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state %s: %w", key, err)
+	}
+	if s.sealer != nil {
+		if data, err = s.sealer.Seal(key, data); err != nil {
+			return fmt.Errorf("failed to encrypt state %s: %w", key, err)
+		}
+	}
+
+	if _, err := s.backend.Write(key, data, ifVersion); err != nil {
+		return fmt.Errorf("failed to write state %s: %w", key, err)
+	}
+	return nil
+}
+
+// conditionalWrites implements versionedStore.
+func (s *BackendStore) conditionalWrites() bool {
+	return s.backend.Guarantees().ConditionalWrites
+}
+
+// Delete implements Store.
+func (s *BackendStore) Delete(key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	if err := s.backend.Delete(key); err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to delete state %s: %w", key, err)
+	}
+	return nil
+}
+
+// checkKey checks the key is a safe file and object name.
+func checkKey(key string) error {
+	if !validKey.MatchString(key) {
+		return fmt.Errorf("invalid state key: %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxUpdateAttempts bounds the attempts of an update losing to concurrent
+// updates of the same key.
+const maxUpdateAttempts = 5
+
+// versionedStore is a Store able to condition a write on the version of the
+// value read.
+type versionedStore interface {
+	// getVersion is Get, also returning the version of the value read.
+	getVersion(key string, v any) (string, error)
+	// putIfVersion is Put if the version of the value is still ifVersion,
+	// it returns ErrConflict otherwise.
+	putIfVersion(key string, v any, ifVersion string) error
+	// conditionalWrites reports whether the backend guarantees conditional
+	// writes.
+	conditionalWrites() bool
+}
+
+// Update reads the value of the key, a zero value if it doesn't exist, lets
+// change modify it and writes it back. If change returns an error, nothing
+// is written and the error is returned.
+//
+// With a backend guaranteeing conditional writes, the value is only written
+// if no other instance wrote the key since it was read, otherwise the update
+// starts over from a fresh read. Without, an update written by another
+// instance between the read and the write is lost.
+func Update[T any](store Store, key string, change func(value *T) error) error {
+	vs, ok := store.(versionedStore)
+	if !ok || !vs.conditionalWrites() {
+		var value T
+		if err := store.Get(key, &value); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if err := change(&value); err != nil {
+			return err
+		}
+		return store.Put(key, value)
+	}
+
+	for range maxUpdateAttempts {
+		var value T
+		version, err := vs.getVersion(key, &value)
+		if errors.Is(err, ErrNotFound) {
+			version = NoVersion
+		} else if err != nil {
+			return err
+		}
+		if err := change(&value); err != nil {
+			return err
+		}
+		if err := vs.putIfVersion(key, value, version); !errors.Is(err, ErrConflict) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to update state %s in %d attempts: %w", key, maxUpdateAttempts, ErrConflict)
+}
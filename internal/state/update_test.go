@@ -0,0 +1,103 @@
+package state
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// appendTo returns a change appending the value to a list, writing before
+// through the store first if set, as an instance updating the key
+// concurrently.
+func appendTo(store Store, value string, before []string) func(list *[]string) error {
+	return func(list *[]string) error {
+		if before != nil {
+			if err := store.Put("list", before); err != nil {
+				return err
+			}
+		}
+		*list = append(*list, value)
+		return nil
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	s3, _ := newS3Backend(t, "")
+	file, err := NewFileBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		store Store
+		// concurrent is written by another instance during the first
+		// attempt of the update
+		concurrent []string
+		want       []string
+	}{
+		{name: "conditional", store: NewBackendStore(s3), want: []string{"first", "second"}},
+		{name: "conditional retried", store: NewBackendStore(s3), concurrent: []string{"first", "other"}, want: []string{"first", "other", "second"}},
+		{name: "conditional through scoped stores", store: NewRoleStore(NewShardStore(NewBackendStore(s3), 1), "routing"),
+			concurrent: []string{"first", "other"}, want: []string{"first", "other", "second"}},
+		{name: "unconditional", store: NewBackendStore(file), want: []string{"first", "second"}},
+		// without conditional writes the concurrent update is lost
+		{name: "unconditional lost update", store: NewBackendStore(file), concurrent: []string{"first", "other"}, want: []string{"first", "second"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.store.Delete("list"); err != nil {
+				t.Fatal(err)
+			}
+			if err := Update(tt.store, "list", appendTo(tt.store, "first", nil)); err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+			attempts := 0
+			concurrent := func(list *[]string) error {
+				attempts++
+				if attempts == 1 {
+					return appendTo(tt.store, "second", tt.concurrent)(list)
+				}
+				return appendTo(tt.store, "second", nil)(list)
+			}
+			if err := Update(tt.store, "list", concurrent); err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+			var got []string
+			if err := tt.store.Get("list", &got); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("list = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateErrors(t *testing.T) {
+	s3, _ := newS3Backend(t, "")
+	store := NewBackendStore(s3)
+	errRefused := errors.New("refused")
+
+	if err := store.Put("list", []string{"first"}); err != nil {
+		t.Fatal(err)
+	}
+	refuse := func(list *[]string) error {
+		*list = append(*list, "second")
+		return errRefused
+	}
+	if err := Update(store, "list", refuse); !errors.Is(err, errRefused) {
+		t.Fatalf("Update() error = %v, want %v", err, errRefused)
+	}
+	var got []string
+	if err := store.Get("list", &got); err != nil || !slices.Equal(got, []string{"first"}) {
+		t.Errorf("list = %v, %v after a refused update, want it unchanged", got, err)
+	}
+
+	// an update always losing to concurrent ones gives up
+	always := func(list *[]string) error {
+		return appendTo(store, "second", append(*list, "other"))(list)
+	}
+	if err := Update(store, "list", always); !errors.Is(err, ErrConflict) {
+		t.Errorf("Update() error = %v, want %v", err, ErrConflict)
+	}
+}
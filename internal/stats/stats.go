@@ -0,0 +1,405 @@
+package stats
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/state"
+)
+
+// stateKey is the state store key holding the statistics history. It is
+// kept apart from run records, so it can be retained much longer.
+const stateKey = "stats-history"
+
+// trendWindow is the period compared by the trends.
+const trendWindow = 7 * 24 * time.Hour
+
+// scoreTolerance is the change of the posture score a trend shows as
+// steady.
+const scoreTolerance = 1.0
+
+// severities are the finding severities, in report order.
+var severities = []findings.Severity{
+	findings.SeverityCritical,
+	findings.SeverityHigh,
+	findings.SeverityMedium,
+	findings.SeverityLow,
+	findings.SeverityInfo,
+}
+
+// Record is the enforcement statistics of a subscription for one run, or
+// the sum over the runs of a day once downsampled.
+type Record struct {
+	Time           time.Time `json:"time"`
+	SubscriptionID string    `json:"subscriptionId"`
+	// Runs is the number of runs summed up, 1 until downsampled.
+	Runs         int                       `json:"runs"`
+	Evaluated    int                       `json:"evaluated"`
+	Compliant    int                       `json:"compliant"`
+	Findings     map[findings.Severity]int `json:"findings"`
+	Remediations int                       `json:"remediations"`
+	// Score is the posture score, summed like the counts, over ScoredRuns
+	// as runs before the score existed have none.
+	Score      float64 `json:"score,omitempty"`
+	ScoredRuns int     `json:"scoredRuns,omitempty"`
+	// Role is the role of the run, config.Config.Role, empty for runs of
+	// every controller and once downsampled. The records of the roles sum
+	// up to the subscription's.
+	Role string `json:"role,omitempty"`
+}
+
+// add adds the counts of other to the record.
+func (r *Record) add(other Record) {
+	r.Runs += other.Runs
+	r.Evaluated += other.Evaluated
+	r.Compliant += other.Compliant
+	r.Remediations += other.Remediations
+	r.Score += other.Score
+	r.ScoredRuns += other.ScoredRuns
+	for severity, n := range other.Findings {
+		r.Findings[severity] += n
+	}
+}
+
+// ComplianceRate returns the percentage of evaluated resources that were
+// compliant, 100 if nothing was evaluated.
+func (r *Record) ComplianceRate() float64 {
+	if r.Evaluated == 0 {
+		return 100
+	}
+	return float64(r.Compliant) / float64(r.Evaluated) * 100
+}
+
+// PostureScore returns the posture score averaged over the scored runs,
+// false if none was scored.
+func (r *Record) PostureScore() (float64, bool) {
+	if r.ScoredRuns == 0 {
+		return 0, false
+	}
+	return math.Round(r.Score/float64(r.ScoredRuns)*10) / 10, true
+}
+
+// Point is a record of the time series, with the counts averaged per run.
+type Point struct {
+	Time           time.Time                 `json:"time"`
+	SubscriptionID string                    `json:"subscriptionId"`
+	Runs           int                       `json:"runs"`
+	Evaluated      int                       `json:"evaluated"`
+	Compliant      int                       `json:"compliant"`
+	ComplianceRate float64                   `json:"complianceRate"`
+	Findings       map[findings.Severity]int `json:"findings"`
+	Remediations   int                       `json:"remediations"`
+	// Score is the posture score, nil for runs before the score existed.
+	Score *float64 `json:"score,omitempty"`
+	Role  string   `json:"role,omitempty"`
+}
+
+// Trend compares the compliance of a subscription over the last week with
+// the week before.
+type Trend struct {
+	SubscriptionID string  `json:"subscriptionId"`
+	Current        float64 `json:"current"`
+	Previous       float64 `json:"previous"`
+	// HasPrevious is false if there are no statistics for the week before.
+	HasPrevious bool `json:"hasPrevious"`
+	// Score and PreviousScore are the posture scores of the weeks, nil if
+	// no run of the week was scored.
+	Score         *float64 `json:"score,omitempty"`
+	PreviousScore *float64 `json:"previousScore,omitempty"`
+}
+
+// ScoreArrow shows the direction of the posture score against last week:
+// ↑, ↓ or → for changes within scoreTolerance, empty without both scores.
+func (t Trend) ScoreArrow() string {
+	switch {
+	case t.Score == nil || t.PreviousScore == nil:
+		return ""
+	case *t.Score > *t.PreviousScore+scoreTolerance:
+		return "↑"
+	case *t.Score < *t.PreviousScore-scoreTolerance:
+		return "↓"
+	default:
+		return "→"
+	}
+}
+
+// String describes the trend, e.g. "97.5% (+2.3% vs last week), posture
+// score 84.5 ↑".
+func (t Trend) String() string {
+	s := fmt.Sprintf("%.1f%% (no data for last week)", t.Current)
+	if t.HasPrevious {
+		s = fmt.Sprintf("%.1f%% (%+.1f%% vs last week)", t.Current, t.Current-t.Previous)
+	}
+	if t.Score != nil {
+		s += fmt.Sprintf(", posture score %.1f", *t.Score)
+		if arrow := t.ScoreArrow(); arrow != "" {
+			s += " " + arrow
+		}
+	}
+	return s
+}
+
+// Collect builds the records of a run for the evaluated subscriptions from
+// the compliant resource counts, the findings, the writes made and the
+// posture scores.
+func Collect(now time.Time, subscriptionIDs []string, compliant map[string]int, all []findings.Finding, remediations map[string]int,
+	scores map[string]float64) []Record {
+	records := make(map[string]*Record, len(subscriptionIDs))
+	for _, subID := range subscriptionIDs {
+		records[subID] = &Record{
+			Time:           now,
+			SubscriptionID: subID,
+			Runs:           1,
+			Evaluated:      compliant[subID],
+			Compliant:      compliant[subID],
+			Findings:       make(map[findings.Severity]int),
+			Remediations:   remediations[subID],
+		}
+		if score, ok := scores[subID]; ok {
+			records[subID].Score = score
+			records[subID].ScoredRuns = 1
+		}
+	}
+	for _, f := range all {
+		if r, ok := records[f.SubscriptionID]; ok {
+			r.Evaluated++
+			r.Findings[f.Severity]++
+		}
+	}
+
+	result := make([]Record, 0, len(records))
+	for _, subID := range subscriptionIDs {
+		result = append(result, *records[subID])
+	}
+	return result
+}
+
+// History is the statistics history persisted in the state store.
+type History struct {
+	store state.Store
+	cfg   config.StatsConfig
+}
+
+// NewHistory creates a new history persisting to the state store.
+func NewHistory(store state.Store, cfg config.StatsConfig) *History {
+	return &History{store: store, cfg: cfg}
+}
+
+// Append adds the records of a run, then drops the records beyond the
+// retention and downsamples the ones older than the downsampling age.
+func (h *History) Append(records []Record, now time.Time) error {
+	all, err := h.load()
+	if err != nil {
+		return err
+	}
+	all = h.compact(append(all, records...), now)
+	return h.store.Put(stateKey, all)
+}
+
+// Query returns the time series between from and to, of one subscription or
+// of all if subscriptionID is empty. Zero times leave the range open.
+func (h *History) Query(subscriptionID string, from, to time.Time) ([]Point, error) {
+	all, err := h.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var points []Point
+	for _, r := range all {
+		if subscriptionID != "" && r.SubscriptionID != subscriptionID {
+			continue
+		}
+		if (!from.IsZero() && r.Time.Before(from)) || (!to.IsZero() && r.Time.After(to)) {
+			continue
+		}
+		points = append(points, point(r))
+	}
+	return points, nil
+}
+
+// Recent returns the records of the last n runs, oldest first. Downsampled
+// records count as one run.
+func (h *History) Recent(n int) ([]Record, error) {
+	all, err := h.load()
+	if err != nil {
+		return nil, err
+	}
+
+	runs := 0
+	start := len(all)
+	for start > 0 && runs < n {
+		runTime := all[start-1].Time
+		for start > 0 && all[start-1].Time.Equal(runTime) {
+			start--
+		}
+		runs++
+	}
+	return all[start:], nil
+}
+
+// Trends returns the compliance trend of every subscription with statistics
+// in the last week.
+func (h *History) Trends(now time.Time) ([]Trend, error) {
+	all, err := h.load()
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]*Record)
+	previous := make(map[string]*Record)
+	for _, r := range all {
+		var sums map[string]*Record
+		switch age := now.Sub(r.Time); {
+		case age < trendWindow:
+			sums = current
+		case age < 2*trendWindow:
+			sums = previous
+		default:
+			continue
+		}
+		if _, ok := sums[r.SubscriptionID]; !ok {
+			sums[r.SubscriptionID] = &Record{Findings: make(map[findings.Severity]int)}
+		}
+		sums[r.SubscriptionID].add(r)
+	}
+
+	trends := make([]Trend, 0, len(current))
+	for subID, sum := range current {
+		trend := Trend{SubscriptionID: subID, Current: sum.ComplianceRate()}
+		if score, ok := sum.PostureScore(); ok {
+			trend.Score = &score
+		}
+		if prev, ok := previous[subID]; ok {
+			trend.Previous = prev.ComplianceRate()
+			trend.HasPrevious = true
+			if score, ok := prev.PostureScore(); ok {
+				trend.PreviousScore = &score
+			}
+		}
+		trends = append(trends, trend)
+	}
+	sort.Slice(trends, func(i, j int) bool {
+		return trends[i].SubscriptionID < trends[j].SubscriptionID
+	})
+	return trends, nil
+}
+
+// compact drops the records beyond the retention and merges the records
+// older than the downsampling age into one record per subscription and day.
+func (h *History) compact(all []Record, now time.Time) []Record {
+	retainFrom := now.Add(-time.Duration(h.cfg.EffectiveRetentionDays()) * 24 * time.Hour)
+	downsampleBefore := now.Add(-time.Duration(h.cfg.EffectiveDownsampleAfterDays()) * 24 * time.Hour)
+
+	type day struct {
+		subscriptionID string
+		date           time.Time
+	}
+	daily := make(map[day]*Record)
+	var result []Record
+	for _, r := range all {
+		if r.Time.Before(retainFrom) {
+			continue
+		}
+		if !r.Time.Before(downsampleBefore) {
+			result = append(result, r)
+			continue
+		}
+
+		key := day{r.SubscriptionID, r.Time.UTC().Truncate(24 * time.Hour)}
+		if d, ok := daily[key]; ok {
+			d.add(r)
+			continue
+		}
+		d := Record{Time: key.date, SubscriptionID: r.SubscriptionID, Findings: make(map[findings.Severity]int)}
+		d.add(r)
+		daily[key] = &d
+	}
+	for _, d := range daily {
+		result = append(result, *d)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+	return result
+}
+
+// load reads the history from the state store, a missing history is empty.
+func (h *History) load() ([]Record, error) {
+	var all []Record
+	if err := h.store.Get(stateKey, &all); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load statistics history: %w", err)
+	}
+	return all, nil
+}
+
+// point converts a record to a point, averaging downsampled counts per run.
+func point(r Record) Point {
+	runs := r.Runs
+	if runs < 1 {
+		runs = 1
+	}
+	p := Point{
+		Time:           r.Time,
+		SubscriptionID: r.SubscriptionID,
+		Runs:           r.Runs,
+		Evaluated:      r.Evaluated / runs,
+		Compliant:      r.Compliant / runs,
+		ComplianceRate: r.ComplianceRate(),
+		Findings:       make(map[findings.Severity]int, len(r.Findings)),
+		Remediations:   r.Remediations,
+		Role:           r.Role,
+	}
+	for severity, n := range r.Findings {
+		p.Findings[severity] = n / runs
+	}
+	if score, ok := r.PostureScore(); ok {
+		p.Score = &score
+	}
+	return p
+}
+
+// WriteCSV writes the time series as CSV, for spreadsheets.
+func WriteCSV(w io.Writer, points []Point) error {
+	cw := csv.NewWriter(w)
+	header := []string{"time", "subscription", "runs", "evaluated", "compliant", "compliance_percent"}
+	for _, severity := range severities {
+		header = append(header, "findings_"+string(severity))
+	}
+	header = append(header, "remediations", "posture_score")
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, p := range points {
+		row := []string{
+			p.Time.UTC().Format(time.RFC3339),
+			p.SubscriptionID,
+			strconv.Itoa(p.Runs),
+			strconv.Itoa(p.Evaluated),
+			strconv.Itoa(p.Compliant),
+			strconv.FormatFloat(p.ComplianceRate, 'f', 2, 64),
+		}
+		for _, severity := range severities {
+			row = append(row, strconv.Itoa(p.Findings[severity]))
+		}
+		score := ""
+		if p.Score != nil {
+			score = strconv.FormatFloat(*p.Score, 'f', 1, 64)
+		}
+		row = append(row, strconv.Itoa(p.Remediations), score)
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,106 @@
+package tagging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/guard"
+)
+
+// Tags written on the resources velora enforces.
+const (
+	// TagLastEnforced is when velora last verified or remediated the
+	// resource, in RFC 3339.
+	TagLastEnforced = "velora-last-enforced"
+	// TagRuleVersion is the hash of the configuration it was enforced with,
+	// the configHash of the reports.
+	TagRuleVersion = "velora-rule-version"
+)
+
+// Stamper tags the resources velora verified or remediated with when it
+// did, so auditors see it in the portal. Tag writes are metadata: they
+// don't count as changes, and a failed one only prints a warning.
+type Stamper struct {
+	clientFactory *azure.ClientFactory
+	guard         *guard.Guard
+	enabled       bool
+	interval      time.Duration
+	ruleVersion   string
+
+	stamped atomic.Uint64
+}
+
+// NewStamper creates a new stamper instance for the configuration. Without
+// tagging.writeLastEnforced it stamps nothing.
+func NewStamper(cfg *config.Config, clientFactory *azure.ClientFactory, guard *guard.Guard) *Stamper {
+	return &Stamper{
+		clientFactory: clientFactory,
+		guard:         guard,
+		enabled:       cfg.Tagging.WriteLastEnforced,
+		interval:      cfg.Tagging.EffectiveRestampInterval(),
+		ruleVersion:   cfg.Hash(),
+	}
+}
+
+// Stamp tags the resource as enforced now. A resource that wasn't changed
+// is only stamped again once its tag is older than the restamp interval or
+// the rule version changed. tags are the current tags of the resource.
+func (s *Stamper) Stamp(ctx context.Context, subscriptionID, resourceID string, tags map[string]string, changed bool) {
+	if s == nil || !s.enabled || !s.due(tags, changed) {
+		return
+	}
+	// plans only hold changes, and no write is made while planning
+	if s.guard.Planning() || !s.guard.WritesAllowed(subscriptionID) {
+		return
+	}
+
+	err := s.clientFactory.ForSubscription(subscriptionID).MergeTags(ctx, resourceID, map[string]string{
+		TagLastEnforced: time.Now().UTC().Format(time.RFC3339),
+		TagRuleVersion:  s.ruleVersion,
+	})
+	switch {
+	case err == nil:
+		s.stamped.Add(1)
+	case azure.IsNotFound(err):
+		// deleted since it was verified
+	case azure.IsScopeLocked(err):
+		fmt.Printf("skipped tagging %s: locked\n", resourceID)
+	default:
+		fmt.Printf("WARNING: failed to tag %s: %v\n", resourceID, err)
+	}
+}
+
+// Stamped returns the number of resources stamped.
+func (s *Stamper) Stamped() uint64 {
+	if s == nil {
+		return 0
+	}
+	return s.stamped.Load()
+}
+
+// due reports whether the resource must be stamped.
+func (s *Stamper) due(tags map[string]string, changed bool) bool {
+	if changed {
+		return true
+	}
+	lastEnforced, ruleVersion := "", ""
+	for key, value := range tags {
+		// tag names are case-insensitive
+		switch {
+		case strings.EqualFold(key, TagLastEnforced):
+			lastEnforced = value
+		case strings.EqualFold(key, TagRuleVersion):
+			ruleVersion = value
+		}
+	}
+	if ruleVersion != s.ruleVersion {
+		return true
+	}
+	stampedAt, err := time.Parse(time.RFC3339, lastEnforced)
+	return err != nil || time.Since(stampedAt) >= s.interval
+}
@@ -0,0 +1,199 @@
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/state"
+)
+
+// stateKey is the state store key holding the active traces. It is one of
+// state.SharedKeys.
+const stateKey = "traces"
+
+// MaxActive caps the traces active at once. Tracing is for a misbehaving
+// resource, not a way to turn on verbose logging for everything.
+const MaxActive = 20
+
+// MaxDuration bounds how long a trace lasts.
+const MaxDuration = 24 * time.Hour
+
+// Trace logs every decision and ARM request about a resource, its parents
+// and its children until it expires.
+type Trace struct {
+	ResourceID string    `json:"resourceId"`
+	SetBy      string    `json:"setBy"`
+	StartedAt  time.Time `json:"startedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// Expired reports whether the trace has ended.
+func (t *Trace) Expired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
+
+// Covers reports whether the resource is the traced one, one of its
+// parents or one of its children.
+func (t *Trace) Covers(resourceID string) bool {
+	traced := strings.ToLower(strings.TrimRight(t.ResourceID, "/"))
+	id := strings.ToLower(strings.TrimRight(resourceID, "/"))
+	return id == traced || strings.HasPrefix(traced, id+"/") || strings.HasPrefix(id, traced+"/")
+}
+
+// String describes the trace for listings.
+func (t *Trace) String() string {
+	return fmt.Sprintf("tracing %s, started by %s, until %s", t.ResourceID, t.SetBy, t.ExpiresAt.UTC().Format(time.RFC3339))
+}
+
+// Manager manages the traces persisted in the state store, so they survive
+// a restart and expire on their own.
+type Manager struct {
+	store state.Store
+	mu    sync.Mutex
+}
+
+// NewManager creates a new trace manager instance.
+func NewManager(store state.Store) *Manager {
+	return &Manager{store: store}
+}
+
+// Start traces the resource for the duration. Starting an active trace
+// again extends it.
+func (m *Manager) Start(resourceID, setBy string, duration time.Duration) (*Trace, error) {
+	if !strings.HasPrefix(strings.ToLower(resourceID), "/subscriptions/") {
+		return nil, fmt.Errorf("invalid resource ID: %s", resourceID)
+	}
+	if duration <= 0 || duration > MaxDuration {
+		return nil, fmt.Errorf("trace duration must be between 0 and %s", MaxDuration)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	traces, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	key := strings.ToLower(resourceID)
+	if _, ok := traces[key]; !ok && len(traces) >= MaxActive {
+		return nil, fmt.Errorf("%d traces are active already, cancel one first", MaxActive)
+	}
+
+	now := time.Now().UTC()
+	t := &Trace{ResourceID: resourceID, SetBy: setBy, StartedAt: now, ExpiresAt: now.Add(duration)}
+	traces[key] = t
+	if err := m.store.Put(stateKey, traces); err != nil {
+		return nil, fmt.Errorf("failed to save traces: %w", err)
+	}
+	return t, nil
+}
+
+// Cancel ends the trace of the resource.
+func (m *Manager) Cancel(resourceID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	traces, err := m.load()
+	if err != nil {
+		return err
+	}
+	key := strings.ToLower(resourceID)
+	if _, ok := traces[key]; !ok {
+		return fmt.Errorf("%s is not traced", resourceID)
+	}
+	delete(traces, key)
+
+	if err := m.store.Put(stateKey, traces); err != nil {
+		return fmt.Errorf("failed to save traces: %w", err)
+	}
+	return nil
+}
+
+// List returns the active traces, by resource ID.
+func (m *Manager) List() ([]*Trace, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	traces, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Trace, 0, len(traces))
+	for _, t := range traces {
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ResourceID < result[j].ResourceID
+	})
+	return result, nil
+}
+
+// Tracer returns a tracer for the active traces.
+func (m *Manager) Tracer() (*Tracer, error) {
+	traces, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+	return &Tracer{traces: traces}, nil
+}
+
+// load reads the traces from the state store, without the expired ones.
+func (m *Manager) load() (map[string]*Trace, error) {
+	traces := make(map[string]*Trace)
+	if err := m.store.Get(stateKey, &traces); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load traces: %w", err)
+	}
+	now := time.Now()
+	for key, t := range traces {
+		if t.Expired(now) {
+			delete(traces, key)
+		}
+	}
+	return traces, nil
+}
+
+// Tracer logs about the traced resources during a run. A nil Tracer traces
+// nothing.
+type Tracer struct {
+	traces []*Trace
+}
+
+// Traced reports whether the resource is covered by a trace that hasn't
+// expired.
+func (t *Tracer) Traced(resourceID string) bool {
+	if t == nil {
+		return false
+	}
+	now := time.Now()
+	for _, trace := range t.traces {
+		if !trace.Expired(now) && trace.Covers(resourceID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Printf logs the message if the resource is traced.
+func (t *Tracer) Printf(resourceID, format string, args ...any) {
+	if !t.Traced(resourceID) {
+		return
+	}
+	fmt.Printf("TRACE %s: %s\n", resourceID, fmt.Sprintf(format, args...))
+}
+
+// Traces returns the traces of the tracer.
+func (t *Tracer) Traces() []*Trace {
+	if t == nil {
+		return nil
+	}
+	return t.traces
+}
+
+// Active reports whether any trace is active.
+func (t *Tracer) Active() bool {
+	return t != nil && len(t.traces) > 0
+}
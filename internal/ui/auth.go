@@ -0,0 +1,44 @@
+package ui
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// RequireToken returns a handler that serves only the requests carrying the
+// token hashing to the hex-encoded SHA-256, as a bearer token or the
+// password of basic auth so browsers can prompt for it.
+func RequireToken(next http.Handler, tokenSHA256 string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !verifyToken(requestToken(r), tokenSHA256) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="velora", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestToken returns the token of the request, empty if it carries none.
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+// verifyToken reports whether the token is set and hashes to the hex-encoded
+// SHA-256.
+func verifyToken(token, tokenSHA256 string) bool {
+	if token == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(tokenSHA256))) == 1
+}
@@ -0,0 +1,55 @@
+package ui
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireToken(t *testing.T) {
+	sum := sha256.Sum256([]byte("s3cret"))
+	handler := RequireToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), hex.EncodeToString(sum[:]))
+
+	tests := []struct {
+		name       string
+		bearer     string
+		password   string
+		wantStatus int
+	}{
+		{name: "no token", wantStatus: http.StatusUnauthorized},
+		{name: "wrong bearer token", bearer: "guess", wantStatus: http.StatusUnauthorized},
+		{name: "wrong password", password: "guess", wantStatus: http.StatusUnauthorized},
+		{name: "bearer token", bearer: "s3cret", wantStatus: http.StatusNoContent},
+		{name: "basic auth password", password: "s3cret", wantStatus: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, Prefix, nil)
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			if tt.password != "" {
+				req.SetBasicAuth("admin", tt.password)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("unauthorized response without WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestVerifyTokenEmptyHash(t *testing.T) {
+	if verifyToken("", "") {
+		t.Error("empty token verified against an empty hash")
+	}
+}
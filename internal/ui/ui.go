@@ -0,0 +1,344 @@
+// Package ui is the read-only web UI of velora: the recent runs with their
+// findings, the coverage of the managed subscriptions, the pauses and the
+// redacted configuration. Templates and assets are embedded in the binary.
+package ui
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/pause"
+	"github.com/akos011221/velora/internal/runlog"
+	"github.com/akos011221/velora/internal/state"
+	"github.com/akos011221/velora/internal/stats"
+	"github.com/akos011221/velora/internal/version"
+)
+
+// Prefix is the path the UI is served under.
+const Prefix = "/ui/"
+
+// PageSize is how many findings a page of a run shows.
+const PageSize = 100
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+//go:embed static
+var staticFS embed.FS
+
+// severities are the finding severities, in report order.
+var severities = []findings.Severity{
+	findings.SeverityCritical,
+	findings.SeverityHigh,
+	findings.SeverityMedium,
+	findings.SeverityLow,
+	findings.SeverityInfo,
+}
+
+// funcs returns the functions the templates use, showing times in the
+// display location.
+func funcs(loc *time.Location) template.FuncMap {
+	return template.FuncMap{
+		"formatTime": func(t time.Time) string {
+			if t.IsZero() {
+				return "-"
+			}
+			return t.In(loc).Format(time.RFC3339)
+		},
+		"severities": func() []findings.Severity { return severities },
+	}
+}
+
+// server serves the pages from the state store.
+type server struct {
+	cfg   *config.Config
+	store state.Store
+	pages map[string]*template.Template
+}
+
+// Handler returns the handler of the UI, serving GET requests under Prefix
+// only: the UI never changes anything.
+func Handler(cfg *config.Config, store state.Store) (http.Handler, error) {
+	s := &server{cfg: cfg, store: store, pages: make(map[string]*template.Template)}
+	// every page is parsed with the layout, they all define its content
+	for _, page := range []string{"runs", "run", "coverage", "pauses", "config"} {
+		t, err := template.New(page).Funcs(funcs(cfg.DisplayLocation())).ParseFS(templateFS, "templates/layout.html", "templates/"+page+".html")
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template of page %s: %w", page, err)
+		}
+		s.pages[page] = t
+	}
+	static, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open static assets: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+Prefix+"{$}", s.runs)
+	mux.HandleFunc("GET "+Prefix+"runs/{id}", s.run)
+	mux.HandleFunc("GET "+Prefix+"coverage", s.coverage)
+	mux.HandleFunc("GET "+Prefix+"pauses", s.pauses)
+	mux.HandleFunc("GET "+Prefix+"config", s.config)
+	mux.Handle("GET "+Prefix+"static/", http.StripPrefix(Prefix+"static/", http.FileServerFS(static)))
+	return secureHeaders(mux), nil
+}
+
+// secureHeaders keeps the pages from loading anything but their own assets
+// or being framed.
+func secureHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// render writes the page with the data, or an error page.
+func (s *server) render(w http.ResponseWriter, page, title string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := s.pages[page].ExecuteTemplate(w, "layout", map[string]any{
+		"Title":   title,
+		"Page":    page,
+		"Version": version.Version,
+		"Data":    data,
+	})
+	if err != nil {
+		fmt.Printf("WARNING: failed to render page %s: %v\n", page, err)
+	}
+}
+
+// fail writes an error response, the details are logged rather than shown.
+func fail(w http.ResponseWriter, status int, message string, err error) {
+	if err != nil {
+		fmt.Printf("WARNING: %s: %v\n", message, err)
+	}
+	http.Error(w, message, status)
+}
+
+// runs serves the list of the recent runs.
+func (s *server) runs(w http.ResponseWriter, r *http.Request) {
+	runs, err := runlog.New(s.store).List()
+	if err != nil {
+		fail(w, http.StatusInternalServerError, "failed to load the runs", err)
+		return
+	}
+	s.render(w, "runs", "Runs", runs)
+}
+
+// runPage is a page of the findings of a run, filtered.
+type runPage struct {
+	Run      *runlog.Run
+	Findings []findings.Finding
+	// Matching counts the findings matching the filters, over every page.
+	Matching int
+	Page     int
+	Pages    int
+	Filter   filter
+	// Rules and Subscriptions are the values the filters may take.
+	Rules         []string
+	Subscriptions []string
+	Prev, Next    string
+}
+
+// filter selects findings, empty fields select every finding.
+type filter struct {
+	Severity     string
+	Rule         string
+	Subscription string
+}
+
+// matches reports whether the finding is selected.
+func (f filter) matches(finding findings.Finding) bool {
+	return (f.Severity == "" || string(finding.Severity) == f.Severity) &&
+		(f.Rule == "" || finding.RuleID == f.Rule) &&
+		(f.Subscription == "" || finding.SubscriptionID == f.Subscription)
+}
+
+// query returns the query string of the filter on the page.
+func (f filter) query(page int) string {
+	q := url.Values{}
+	if f.Severity != "" {
+		q.Set("severity", f.Severity)
+	}
+	if f.Rule != "" {
+		q.Set("rule", f.Rule)
+	}
+	if f.Subscription != "" {
+		q.Set("subscription", f.Subscription)
+	}
+	q.Set("page", strconv.Itoa(page))
+	return "?" + q.Encode()
+}
+
+// run serves a page of the findings of a run, filtered by severity, rule
+// and subscription. Findings are paginated here, so runs with thousands of
+// them render quickly.
+func (s *server) run(w http.ResponseWriter, r *http.Request) {
+	run, all, err := runlog.New(s.store).Get(r.PathValue("id"))
+	if errors.Is(err, state.ErrNotFound) {
+		fail(w, http.StatusNotFound, "run not found, it may have been dropped from the run log", nil)
+		return
+	}
+	if err != nil {
+		fail(w, http.StatusInternalServerError, "failed to load the run", err)
+		return
+	}
+
+	q := r.URL.Query()
+	data := runPage{
+		Run:    run,
+		Filter: filter{Severity: q.Get("severity"), Rule: q.Get("rule"), Subscription: q.Get("subscription")},
+		Page:   1,
+	}
+	if page, err := strconv.Atoi(q.Get("page")); err == nil && page > 1 {
+		data.Page = page
+	}
+
+	var matching []findings.Finding
+	for _, f := range all {
+		if !slices.Contains(data.Rules, f.RuleID) {
+			data.Rules = append(data.Rules, f.RuleID)
+		}
+		if !slices.Contains(data.Subscriptions, f.SubscriptionID) {
+			data.Subscriptions = append(data.Subscriptions, f.SubscriptionID)
+		}
+		if data.Filter.matches(f) {
+			matching = append(matching, f)
+		}
+	}
+	slices.Sort(data.Rules)
+	slices.Sort(data.Subscriptions)
+	// the most severe first
+	slices.SortStableFunc(matching, func(a, b findings.Finding) int {
+		return slices.Index(severities, a.Severity) - slices.Index(severities, b.Severity)
+	})
+
+	data.Matching = len(matching)
+	data.Pages = max((len(matching)+PageSize-1)/PageSize, 1)
+	data.Page = min(data.Page, data.Pages)
+	start := (data.Page - 1) * PageSize
+	data.Findings = matching[start:min(start+PageSize, len(matching))]
+	if data.Page > 1 {
+		data.Prev = data.Filter.query(data.Page - 1)
+	}
+	if data.Page < data.Pages {
+		data.Next = data.Filter.query(data.Page + 1)
+	}
+	s.render(w, "run", "Run "+run.ID, data)
+}
+
+// coverageRow is the last evaluation of a managed subscription.
+type coverageRow struct {
+	SubscriptionID string
+	Environment    string
+	// Last is the statistics of its last evaluation, nil if it was never
+	// evaluated.
+	Last *stats.Point
+}
+
+// coverage serves the last evaluation of every managed subscription.
+func (s *server) coverage(w http.ResponseWriter, r *http.Request) {
+	points, err := stats.NewHistory(s.store, s.cfg.Stats).Query("", time.Time{}, time.Time{})
+	if err != nil {
+		fail(w, http.StatusInternalServerError, "failed to load the statistics", err)
+		return
+	}
+	// instances running different roles each evaluate part of a
+	// subscription, its coverage is their last points together
+	last := make(map[string]map[string]stats.Point)
+	for _, p := range points {
+		if last[p.SubscriptionID] == nil {
+			last[p.SubscriptionID] = make(map[string]stats.Point)
+		}
+		last[p.SubscriptionID][p.Role] = p
+	}
+
+	var rows []coverageRow
+	for _, subID := range s.cfg.SubscriptionIDs() {
+		row := coverageRow{SubscriptionID: subID, Environment: s.cfg.Subscriptions[subID].Environment}
+		if roles, ok := last[subID]; ok {
+			p := mergeRoles(roles)
+			row.Last = &p
+		}
+		rows = append(rows, row)
+	}
+	s.render(w, "coverage", "Coverage", rows)
+}
+
+// mergeRoles sums the last points of the roles into one, as of the latest.
+// A run of every controller after them supersedes them. Posture scores of
+// partial evaluations don't add up, a merged point has none.
+func mergeRoles(roles map[string]stats.Point) stats.Point {
+	if all, ok := roles[""]; ok {
+		superseded := true
+		for role, p := range roles {
+			if role != "" && p.Time.After(all.Time) {
+				superseded = false
+			}
+		}
+		if superseded {
+			return all
+		}
+		delete(roles, "")
+	}
+	if len(roles) == 1 {
+		for _, p := range roles {
+			return p
+		}
+	}
+	merged := stats.Point{Findings: make(map[findings.Severity]int)}
+	for _, p := range roles {
+		if p.Time.After(merged.Time) {
+			merged.Time = p.Time
+		}
+		merged.SubscriptionID = p.SubscriptionID
+		merged.Runs += p.Runs
+		merged.Evaluated += p.Evaluated
+		merged.Compliant += p.Compliant
+		merged.Remediations += p.Remediations
+		for severity, n := range p.Findings {
+			merged.Findings[severity] += n
+		}
+	}
+	merged.ComplianceRate = 100
+	if merged.Evaluated > 0 {
+		merged.ComplianceRate = float64(merged.Compliant) / float64(merged.Evaluated) * 100
+	}
+	return merged
+}
+
+// pauses serves the active pauses.
+func (s *server) pauses(w http.ResponseWriter, r *http.Request) {
+	pauses, err := pause.NewManager(s.store).List()
+	if err != nil {
+		fail(w, http.StatusInternalServerError, "failed to load the pauses", err)
+		return
+	}
+	slices.SortFunc(pauses, func(a, b *pause.Pause) int {
+		return a.SetAt.Compare(b.SetAt)
+	})
+	s.render(w, "pauses", "Pauses", map[string]any{"Pauses": pauses, "GlobalScope": pause.GlobalScope})
+}
+
+// config serves the effective configuration, with its secrets redacted.
+func (s *server) config(w http.ResponseWriter, r *http.Request) {
+	out, err := json.MarshalIndent(s.cfg.Redacted(), "", "  ")
+	if err != nil {
+		fail(w, http.StatusInternalServerError, "failed to encode the configuration", err)
+		return
+	}
+	s.render(w, "config", "Configuration", string(out))
+}
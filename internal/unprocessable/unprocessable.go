@@ -0,0 +1,58 @@
+package unprocessable
+
+import (
+	"fmt"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+// Budget counts the resources of a subscription a controller processes, and
+// the ones it skips because their ID is malformed. ARM returns truncated IDs
+// for a short while when resources are moved, so a few are skipped and
+// reported instead of failing the subscription. Many point to something
+// systemic, and the subscription fails.
+type Budget struct {
+	subscriptionID string
+	maxFraction    float64
+	rules          map[string]config.RuleConfig
+
+	seen    int
+	skipped []string
+}
+
+// NewBudget creates an empty budget for the subscription.
+func NewBudget(cfg *config.Config, subscriptionID string) *Budget {
+	return &Budget{
+		subscriptionID: subscriptionID,
+		maxFraction:    cfg.EffectiveMaxUnprocessableFraction(),
+		rules:          cfg.Rules,
+	}
+}
+
+// Seen counts a resource of the subscription, processable or not.
+func (b *Budget) Seen() {
+	b.seen++
+}
+
+// Skip records a resource whose ID is malformed, and returns the finding
+// reporting it with the raw ID.
+func (b *Budget) Skip(kind, resourceID string) findings.Finding {
+	b.skipped = append(b.skipped, resourceID)
+	fmt.Printf("WARNING: skipping %s with malformed ID %q\n", kind, resourceID)
+	return findings.New(findings.RuleUnreadableResource, b.rules, b.subscriptionID, resourceID,
+		fmt.Sprintf("%s has a malformed ID %q, it was skipped", kind, resourceID), nil)
+}
+
+// Err returns an error if more than the allowed fraction of the resources
+// seen were skipped. A single skipped resource never fails the subscription.
+func (b *Budget) Err() error {
+	if len(b.skipped) <= 1 || b.seen == 0 {
+		return nil
+	}
+	if float64(len(b.skipped)) <= b.maxFraction*float64(b.seen) {
+		return nil
+	}
+	return fmt.Errorf("%d of %d resources of subscription %s have malformed IDs, e.g. %q, more than the allowed %.0f%%",
+		len(b.skipped), b.seen, b.subscriptionID, b.skipped[0], b.maxFraction*100)
+}
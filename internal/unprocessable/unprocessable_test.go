@@ -0,0 +1,55 @@
+package unprocessable
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+func TestBudget(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxFraction *float64
+		seen        int
+		skipped     int
+		wantErr     bool
+	}{
+		{name: "nothing seen"},
+		{name: "none skipped", seen: 20},
+		// a single resource being moved never fails the subscription
+		{name: "single skipped", seen: 1, skipped: 1},
+		{name: "at the default fraction", seen: 20, skipped: 2},
+		{name: "above the default fraction", seen: 20, skipped: 3, wantErr: true},
+		{name: "all skipped", seen: 5, skipped: 5, wantErr: true},
+		{name: "at a configured fraction", maxFraction: ptr(0.5), seen: 10, skipped: 5},
+		{name: "above a configured fraction", maxFraction: ptr(0.5), seen: 10, skipped: 6, wantErr: true},
+		{name: "none allowed", maxFraction: ptr(0), seen: 100, skipped: 2, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			budget := NewBudget(&config.Config{MaxUnprocessableFraction: tt.maxFraction}, "sub")
+			for i := 0; i < tt.seen; i++ {
+				budget.Seen()
+			}
+			for i := 0; i < tt.skipped; i++ {
+				f := budget.Skip("VNet", fmt.Sprintf("/subscriptions/sub/resourceGroups//vnet%d", i))
+				if f.RuleID != findings.RuleUnreadableResource.ID || !strings.Contains(f.Message, f.ResourceID) {
+					t.Errorf("Skip() = %+v, want an unreadable resource finding with the raw ID", f)
+				}
+			}
+
+			err := budget.Err()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Err() = %v, want error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func ptr(f float64) *float64 {
+	return &f
+}
@@ -0,0 +1,65 @@
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Build metadata, injected at build time:
+//
+//	go build -ldflags "-X github.com/akos011221/velora/internal/version.Version=v1.2.3 \
+//	  -X github.com/akos011221/velora/internal/version.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/akos011221/velora/internal/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+// ReportSchemaVersion is the version of the persisted report and state schema.
+// It must be bumped whenever the Finding or report structures change.
+const ReportSchemaVersion = 1
+
+// Info is the build metadata of the running binary.
+type Info struct {
+	Version             string `json:"version"`
+	Commit              string `json:"commit"`
+	Date                string `json:"date"`
+	GoVersion           string `json:"goVersion"`
+	ReportSchemaVersion int    `json:"reportSchemaVersion"`
+}
+
+// Get returns the build metadata of the running binary.
+func Get() Info {
+	return Info{
+		Version:             Version,
+		Commit:              Commit,
+		Date:                Date,
+		GoVersion:           runtime.Version(),
+		ReportSchemaVersion: ReportSchemaVersion,
+	}
+}
+
+// String returns a one-line description of the build, used in startup logs.
+func (i Info) String() string {
+	return fmt.Sprintf("velora %s (commit %s, built %s, %s, report schema v%d)",
+		i.Version, i.Commit, i.Date, i.GoVersion, i.ReportSchemaVersion)
+}
+
+// ApplicationID returns the identifier added to the User-Agent of ARM requests.
+func ApplicationID() string {
+	return "velora/" + Version
+}
+
+// CheckReportSchemaVersion returns an error if data persisted with the given
+// schema version can't be read by this binary.
+func CheckReportSchemaVersion(schemaVersion int) error {
+	if schemaVersion > ReportSchemaVersion {
+		return fmt.Errorf("produced by newer velora (report schema v%d, this binary supports up to v%d), upgrade velora",
+			schemaVersion, ReportSchemaVersion)
+	}
+	if schemaVersion < 1 {
+		return fmt.Errorf("invalid report schema version %d", schemaVersion)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+// Package webhooks posts signed events to HTTP endpoints: completed runs,
+// findings opening and resolving, guardrails stopping writes and pauses.
+// Every delivery is signed, see Sign, and deliveries failing every attempt
+// are kept in a dead-letter log.
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/pause"
+	"github.com/akos011221/velora/internal/version"
+)
+
+// SchemaVersion is the version of the event payload. Fields are only added
+// within a version, receivers must ignore those they don't know.
+const SchemaVersion = 1
+
+// Event is the payload of a delivery. Exactly one of Run, Finding,
+// Guardrail and Pause is set, by Type.
+type Event struct {
+	SchemaVersion int `json:"schemaVersion"`
+	// ID identifies the event, it is the same on every retry and endpoint
+	// so receivers can deduplicate.
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Test is set on the sample events of velora webhook test.
+	Test bool `json:"test,omitempty"`
+
+	Run       *Run              `json:"run,omitempty"`
+	Finding   *findings.Finding `json:"finding,omitempty"`
+	Guardrail *Guardrail        `json:"guardrail,omitempty"`
+	Pause     *pause.Pause      `json:"pause,omitempty"`
+}
+
+// Run is the outcome of a completed run.
+type Run struct {
+	Metadata findings.Metadata `json:"metadata"`
+	// Findings counts the findings of the run per severity.
+	Findings map[findings.Severity]int `json:"findings"`
+	// New and Resolved count the findings that opened and resolved.
+	New      int `json:"new"`
+	Resolved int `json:"resolved"`
+	// Skipped are the subscriptions the run didn't evaluate, with the reason.
+	Skipped map[string]string `json:"skipped,omitempty"`
+}
+
+// Guardrail is a write a guardrail of velora or Azure stopped.
+type Guardrail struct {
+	// Name is the guardrail, e.g. quota.
+	Name           string `json:"name"`
+	RuleID         string `json:"ruleId"`
+	SubscriptionID string `json:"subscriptionId"`
+	ResourceID     string `json:"resourceId"`
+	Message        string `json:"message"`
+}
+
+// guardrails names the guardrails by the rule of their findings.
+var guardrails = map[string]string{
+	findings.RuleBlockedByPolicy.ID: "azure-policy",
+	findings.RuleBlockedByQuota.ID:  "quota",
+	findings.RuleControllerPanic.ID: "panic-breaker",
+}
+
+// newEvent returns an event of the type, with a new ID.
+func newEvent(eventType string) (Event, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return Event{}, fmt.Errorf("failed to generate event ID: %w", err)
+	}
+	return Event{SchemaVersion: SchemaVersion, ID: hex.EncodeToString(b), Type: eventType, CreatedAt: time.Now().UTC()}, nil
+}
+
+// PauseActivated returns the event of the pause.
+func PauseActivated(p *pause.Pause) (Event, error) {
+	event, err := newEvent(config.WebhookEventPauseActivated)
+	event.Pause = p
+	return event, err
+}
+
+// Sample returns a test event of the type, with made-up content.
+func Sample(eventType string) (Event, error) {
+	event, err := newEvent(eventType)
+	if err != nil {
+		return event, err
+	}
+	event.Test = true
+
+	subscriptionID := "00000000-0000-0000-0000-000000000000"
+	resourceID := "/subscriptions/" + subscriptionID + "/resourceGroups/rg-sample/providers/Microsoft.Network/routeTables/rt-sample"
+	switch eventType {
+	case config.WebhookEventRunCompleted:
+		event.Run = &Run{
+			Metadata: findings.Metadata{SchemaVersion: version.ReportSchemaVersion, VeloraVersion: version.Version, Commit: version.Commit,
+				RuleSetVersion: findings.RuleSetVersion()},
+			Findings: map[findings.Severity]int{findings.SeverityHigh: 1},
+			New:      1,
+		}
+	case config.WebhookEventFindingNew, config.WebhookEventFindingResolved:
+		event.Finding = &findings.Finding{
+			RuleID:         findings.RuleDefaultRoute.ID,
+			Severity:       findings.RuleDefaultRoute.Severity,
+			SubscriptionID: subscriptionID,
+			ResourceID:     resourceID,
+			Message:        "sample finding sent by velora webhook test",
+		}
+	case config.WebhookEventGuardrailTriggered:
+		event.Guardrail = &Guardrail{
+			Name:           guardrails[findings.RuleBlockedByQuota.ID],
+			RuleID:         findings.RuleBlockedByQuota.ID,
+			SubscriptionID: subscriptionID,
+			ResourceID:     resourceID,
+			Message:        "sample guardrail sent by velora webhook test",
+		}
+	case config.WebhookEventPauseActivated:
+		event.Pause = &pause.Pause{Reason: "sample pause sent by velora webhook test", SetBy: "velora", SetAt: event.CreatedAt}
+	default:
+		return event, fmt.Errorf("unknown event type %q", eventType)
+	}
+	return event, nil
+}
@@ -0,0 +1,66 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader is the header carrying the signature of a delivery, as
+// t=TIMESTAMP,v1=SIGNATURE.
+const SignatureHeader = "X-Velora-Signature"
+
+// DefaultTolerance is how old a delivery Verify accepts by default.
+const DefaultTolerance = 5 * time.Minute
+
+// Sign returns the signature header of the body sent at the time: the hex
+// HMAC-SHA256 of "TIMESTAMP.BODY" with the secret, TIMESTAMP being Unix
+// seconds. Signing the timestamp lets receivers refuse replayed deliveries.
+func Sign(secret []byte, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",v1=" + signature(secret, t, body)
+}
+
+// signature returns the hex HMAC-SHA256 of the timestamp and the body.
+func signature(secret []byte, t string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature header of a delivery received at now: one of
+// its v1 signatures matches the body and its timestamp is within the
+// tolerance of now.
+func Verify(secret []byte, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var t string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return fmt.Errorf("malformed %s header", SignatureHeader)
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("delivery timestamp is %s off, more than the tolerance of %s", age.Round(time.Second), tolerance)
+	}
+
+	expected := signature(secret, t, body)
+	for _, s := range signatures {
+		if hmac.Equal([]byte(s), []byte(expected)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature doesn't match the body")
+}
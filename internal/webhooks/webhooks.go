@@ -0,0 +1,368 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/findings"
+	"github.com/akos011221/velora/internal/redact"
+	"github.com/akos011221/velora/internal/state"
+	"github.com/akos011221/velora/internal/version"
+)
+
+const (
+	// findingsStateKey is the state store key of the open findings, to
+	// tell new and resolved findings apart.
+	findingsStateKey = "webhook-findings"
+	// deadLettersKey is the state store key of the dead-letter log.
+	deadLettersKey = "webhook-dead-letters"
+	// maxDeadLetters bounds the dead-letter log, the oldest are dropped.
+	maxDeadLetters = 1000
+
+	// sendAttempts is the number of attempts to deliver an event.
+	sendAttempts = 5
+	// initialBackoff is the delay before the first retry, doubled on every retry.
+	initialBackoff = time.Second
+	// maxConsecutiveFailures is how many events in a row may fail before
+	// the endpoint is considered down, the rest of the events are
+	// dead-lettered without being sent.
+	maxConsecutiveFailures = 3
+)
+
+// Outcome is what was delivered to an endpoint.
+type Outcome struct {
+	Endpoint     string `json:"endpoint"`
+	Delivered    int    `json:"delivered"`
+	DeadLettered int    `json:"deadLettered"`
+	// Error is the first failure, empty if every event was delivered.
+	Error string `json:"error,omitempty"`
+}
+
+// DeadLetter is an event an endpoint didn't accept after every attempt.
+type DeadLetter struct {
+	Endpoint string    `json:"endpoint"`
+	Event    Event     `json:"event"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	At       time.Time `json:"at"`
+}
+
+// Delivery is a single attempt to deliver an event.
+type Delivery struct {
+	Body      []byte
+	Signature string
+	// StatusCode is zero if no response was received.
+	StatusCode int
+}
+
+// openFinding is a finding reported by the last run.
+type openFinding struct {
+	RuleID         string            `json:"ruleId"`
+	Severity       findings.Severity `json:"severity"`
+	SubscriptionID string            `json:"subscriptionId"`
+	ResourceID     string            `json:"resourceId"`
+	OpenedAt       time.Time         `json:"openedAt"`
+}
+
+// findingsState is the persisted open findings, keyed by rule and resource.
+type findingsState struct {
+	Open map[string]*openFinding `json:"open"`
+}
+
+// Notifier delivers events to the configured endpoints.
+type Notifier struct {
+	endpoints  []config.WebhookConfig
+	store      state.Store
+	httpClient *http.Client
+	mu         sync.Mutex
+}
+
+// NewNotifier creates a new notifier for the endpoints, keeping its state
+// and dead letters in the store.
+func NewNotifier(endpoints []config.WebhookConfig, store state.Store) *Notifier {
+	return &Notifier{
+		endpoints:  endpoints,
+		store:      store,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// NotifyRun delivers the events of a completed run: the findings that
+// opened and resolved since the last run, the guardrails triggered and the
+// run itself, last. Findings of subscriptions the run didn't evaluate don't
+// resolve. Errors are returned for logging only, they must never fail the
+// run.
+func (n *Notifier) NotifyRun(ctx context.Context, metadata findings.Metadata, runFindings []findings.Finding, skipped map[string]string,
+	evaluated func(subscriptionID string) bool) ([]Outcome, error) {
+	st, err := n.load()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	run := &Run{Metadata: metadata, Findings: make(map[findings.Severity]int), Skipped: skipped}
+
+	var events []Event
+	add := func(eventType string, set func(event *Event)) error {
+		event, err := newEvent(eventType)
+		if err != nil {
+			return err
+		}
+		set(&event)
+		events = append(events, event)
+		return nil
+	}
+
+	reported := make(map[string]bool, len(runFindings))
+	for i := range runFindings {
+		f := runFindings[i]
+		run.Findings[f.Severity]++
+		if name, ok := guardrails[f.RuleID]; ok {
+			if err := add(config.WebhookEventGuardrailTriggered, func(event *Event) {
+				event.Guardrail = &Guardrail{Name: name, RuleID: f.RuleID, SubscriptionID: f.SubscriptionID, ResourceID: f.ResourceID, Message: f.Message}
+			}); err != nil {
+				return nil, err
+			}
+		}
+
+		k := findingKey(f.RuleID, f.ResourceID)
+		if reported[k] {
+			continue
+		}
+		reported[k] = true
+		if open, ok := st.Open[k]; ok {
+			open.Severity = f.Severity
+			continue
+		}
+		st.Open[k] = &openFinding{RuleID: f.RuleID, Severity: f.Severity, SubscriptionID: f.SubscriptionID, ResourceID: f.ResourceID, OpenedAt: now}
+		run.New++
+		if err := add(config.WebhookEventFindingNew, func(event *Event) { event.Finding = &f }); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make([]string, 0, len(st.Open))
+	for k := range st.Open {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		open := st.Open[k]
+		if reported[k] || !evaluated(open.SubscriptionID) {
+			continue
+		}
+		delete(st.Open, k)
+		run.Resolved++
+		if err := add(config.WebhookEventFindingResolved, func(event *Event) {
+			event.Finding = &findings.Finding{
+				RuleID:         open.RuleID,
+				Severity:       open.Severity,
+				SubscriptionID: open.SubscriptionID,
+				ResourceID:     open.ResourceID,
+				Message:        fmt.Sprintf("%s on %s is no longer reported, it was opened %s", open.RuleID, open.ResourceID, open.OpenedAt.Format(time.RFC3339)),
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if err := add(config.WebhookEventRunCompleted, func(event *Event) { event.Run = run }); err != nil {
+		return nil, err
+	}
+
+	// undelivered events are dead-lettered, so the state moves on regardless
+	if err := n.save(st); err != nil {
+		return nil, err
+	}
+	return n.Deliver(ctx, events)
+}
+
+// Deliver posts the events to the endpoints subscribed to them, retrying
+// failed deliveries with backoff. Events an endpoint doesn't accept are
+// added to the dead-letter log.
+func (n *Notifier) Deliver(ctx context.Context, events []Event) ([]Outcome, error) {
+	var outcomes []Outcome
+	var deadLetters []DeadLetter
+	for _, endpoint := range n.endpoints {
+		outcome := Outcome{Endpoint: endpoint.Name}
+		secret, secretErr := Secret(endpoint)
+		failures := 0
+		for _, event := range events {
+			if !subscribed(endpoint, event) {
+				continue
+			}
+
+			attempts, err := 0, secretErr
+			switch {
+			case err != nil:
+			case failures >= maxConsecutiveFailures:
+				err = fmt.Errorf("not sent, the endpoint failed %d deliveries in a row", maxConsecutiveFailures)
+			default:
+				attempts, err = n.deliver(ctx, endpoint, secret, event)
+			}
+			if err == nil {
+				outcome.Delivered++
+				failures = 0
+				continue
+			}
+			if attempts > 0 {
+				failures++
+			}
+			outcome.DeadLettered++
+			if outcome.Error == "" {
+				outcome.Error = err.Error()
+			}
+			deadLetters = append(deadLetters, DeadLetter{Endpoint: endpoint.Name, Event: event, Attempts: attempts, Error: err.Error(), At: time.Now().UTC()})
+		}
+		if outcome.Delivered+outcome.DeadLettered > 0 {
+			outcomes = append(outcomes, outcome)
+		}
+	}
+	if len(deadLetters) == 0 {
+		return outcomes, nil
+	}
+	return outcomes, n.addDeadLetters(deadLetters)
+}
+
+// subscribed reports whether the endpoint receives the event.
+func subscribed(endpoint config.WebhookConfig, event Event) bool {
+	if !endpoint.Subscribed(event.Type) {
+		return false
+	}
+	if event.Finding != nil && endpoint.MinSeverity != "" {
+		return event.Finding.Severity.AtLeast(findings.Severity(endpoint.MinSeverity))
+	}
+	return true
+}
+
+// deliver posts the event to the endpoint until it is accepted, refused or
+// the attempts run out. It returns the attempts made.
+func (n *Notifier) deliver(ctx context.Context, endpoint config.WebhookConfig, secret []byte, event Event) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	backoff := initialBackoff
+	var lastErr error
+	for attempt := 1; attempt <= sendAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return attempt - 1, ctx.Err()
+			}
+			backoff *= 2
+		}
+		delivery, err := n.Send(ctx, endpoint, secret, event.Type, event.ID, body)
+		if err == nil {
+			return attempt, nil
+		}
+		lastErr = err
+		// the endpoint refused the event, sending it again won't help
+		if delivery.StatusCode >= 400 && delivery.StatusCode < 500 &&
+			delivery.StatusCode != http.StatusRequestTimeout && delivery.StatusCode != http.StatusTooManyRequests {
+			return attempt, err
+		}
+	}
+	return sendAttempts, lastErr
+}
+
+// Send makes a single attempt to deliver the body, signed with the secret
+// at the time of sending. Any 2xx status is accepted.
+func (n *Notifier) Send(ctx context.Context, endpoint config.WebhookConfig, secret []byte, eventType, eventID string, body []byte) (*Delivery, error) {
+	delivery := &Delivery{Body: body, Signature: Sign(secret, time.Now(), body)}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return delivery, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "velora/"+version.Version)
+	req.Header.Set("X-Velora-Event", eventType)
+	req.Header.Set("X-Velora-Delivery", eventID)
+	req.Header.Set(SignatureHeader, delivery.Signature)
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return delivery, err
+	}
+	defer resp.Body.Close()
+	delivery.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return delivery, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return delivery, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// Secret returns the signing secret of the endpoint from its environment
+// variable, registered for redaction.
+func Secret(endpoint config.WebhookConfig) ([]byte, error) {
+	secret := os.Getenv(endpoint.SecretEnv)
+	if secret == "" {
+		return nil, fmt.Errorf("environment variable %s holding the secret of webhook %s is empty", endpoint.SecretEnv, endpoint.Name)
+	}
+	redact.Register(secret)
+	return []byte(secret), nil
+}
+
+// DeadLetters returns the dead-letter log, oldest first.
+func DeadLetters(store state.Store) ([]DeadLetter, error) {
+	var deadLetters []DeadLetter
+	if err := store.Get(deadLettersKey, &deadLetters); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load webhook dead-letter log: %w", err)
+	}
+	return deadLetters, nil
+}
+
+// addDeadLetters appends to the dead-letter log.
+func (n *Notifier) addDeadLetters(add []DeadLetter) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	deadLetters, err := DeadLetters(n.store)
+	if err != nil {
+		return err
+	}
+	deadLetters = append(deadLetters, add...)
+	if len(deadLetters) > maxDeadLetters {
+		deadLetters = deadLetters[len(deadLetters)-maxDeadLetters:]
+	}
+	if err := n.store.Put(deadLettersKey, deadLetters); err != nil {
+		return fmt.Errorf("failed to save webhook dead-letter log: %w", err)
+	}
+	return nil
+}
+
+// load reads the open findings from the state store.
+func (n *Notifier) load() (*findingsState, error) {
+	st := &findingsState{}
+	if err := n.store.Get(findingsStateKey, st); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load webhook findings: %w", err)
+	}
+	if st.Open == nil {
+		st.Open = make(map[string]*openFinding)
+	}
+	return st, nil
+}
+
+// save writes the open findings to the state store.
+func (n *Notifier) save(st *findingsState) error {
+	if err := n.store.Put(findingsStateKey, st); err != nil {
+		return fmt.Errorf("failed to save webhook findings: %w", err)
+	}
+	return nil
+}
+
+// findingKey identifies a finding by rule and resource.
+func findingKey(ruleID, resourceID string) string {
+	return ruleID + "|" + strings.ToLower(resourceID)
+}
@@ -0,0 +1,54 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akos011221/velora/internal/azure"
+	"github.com/akos011221/velora/internal/azure/azuretest"
+	"github.com/akos011221/velora/internal/config"
+)
+
+// TestSendBypassesAzureProxy checks webhooks are posted directly, although
+// the ARM clients of the process send through azure.httpProxy.
+func TestSendBypassesAzureProxy(t *testing.T) {
+	proxy := azuretest.NewProxy(t, "")
+	cfg := &config.AzureConfig{
+		TenantID:     "00000000-0000-0000-0000-000000000001",
+		ClientID:     "00000000-0000-0000-0000-000000000002",
+		ClientSecret: "secret",
+		HTTPProxy:    proxy.URL,
+	}
+	if _, err := azure.NewClientFactory(cfg); err != nil {
+		t.Fatalf("NewClientFactory() error = %v", err)
+	}
+
+	secret := []byte("webhook-secret")
+	var received []byte
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := Verify(secret, r.Header.Get(SignatureHeader), body, time.Minute, time.Now()); err != nil {
+			t.Errorf("Verify() error = %v", err)
+		}
+		received = body
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer endpoint.Close()
+
+	notifier := NewNotifier(nil, nil)
+	body := []byte(`{"type":"run.completed"}`)
+	delivery, err := notifier.Send(context.Background(), config.WebhookConfig{Name: "ops", URL: endpoint.URL}, secret, "run.completed", "1", body)
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if delivery.StatusCode != http.StatusNoContent || string(received) != string(body) {
+		t.Errorf("Send() = %d with %s received, want the body delivered", delivery.StatusCode, received)
+	}
+	if hosts := proxy.Hosts(); len(hosts) > 0 {
+		t.Errorf("webhook went through the azure proxy: %v", hosts)
+	}
+}
@@ -0,0 +1,240 @@
+package workqueue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/state"
+)
+
+const (
+	// pendingKey is the state store key of the items waiting to be received
+	// by scope, to deduplicate them.
+	pendingKey = "queue-pending"
+	// deadLettersKey is the state store key of the dead-letter list.
+	deadLettersKey = "queue-deadletters"
+	// pendingTTL is how long an item deduplicates identical items. It only
+	// matters for items lost from the backend, e.g. by purging the queue.
+	pendingTTL = 24 * time.Hour
+	// maxDeadLetters bounds the dead-letter list, the oldest are dropped.
+	maxDeadLetters = 1000
+)
+
+// SourceCLI is the source of items enqueued with velora queue enqueue.
+const SourceCLI = "cli"
+
+// Item is a targeted enforcement request: a run limited to a resource group
+// or VNet.
+type Item struct {
+	ID string `json:"id"`
+	// Scope is the resource group or VNet ID to enforce.
+	Scope string `json:"scope"`
+	// Source is what enqueued the item, e.g. SourceCLI.
+	Source     string    `json:"source"`
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+}
+
+// key identifies identical items.
+func (i Item) key() string {
+	return strings.ToLower(strings.TrimRight(i.Scope, "/"))
+}
+
+// Message is an item received from a backend. It is hidden from other
+// receivers until its visibility timeout expires.
+type Message struct {
+	Item Item
+	// DequeueCount is the number of times the item was received, this
+	// time included.
+	DequeueCount int
+
+	// id and receipt identify the message and this receipt of it to the
+	// backend.
+	id      string
+	receipt string
+}
+
+// Backend stores the items of a queue.
+type Backend interface {
+	// Send adds the item to the queue.
+	Send(ctx context.Context, item Item) error
+	// Receive returns the next visible item, hidden for the visibility
+	// timeout, or nil if there is none.
+	Receive(ctx context.Context, visibility time.Duration) (*Message, error)
+	// Extend hides the received item for the visibility timeout from now.
+	Extend(ctx context.Context, msg *Message, visibility time.Duration) error
+	// Delete removes the received item.
+	Delete(ctx context.Context, msg *Message) error
+	// Depth returns the approximate number of items.
+	Depth(ctx context.Context) (int, error)
+}
+
+// DeadLetter is an item that failed every attempt.
+type DeadLetter struct {
+	Item     Item      `json:"item"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	At       time.Time `json:"at"`
+}
+
+// pendingItem is an item waiting to be received.
+type pendingItem struct {
+	ID         string    `json:"id"`
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+}
+
+// Queue is the work queue of targeted enforcement runs. Items are kept by
+// the backend, the pending items and the dead-letter list in the state
+// store.
+type Queue struct {
+	cfg     config.QueueConfig
+	backend Backend
+	store   state.Store
+
+	// mu serializes the updates of the pending items and dead letters.
+	mu sync.Mutex
+}
+
+// New creates a new queue on the backend.
+func New(cfg config.QueueConfig, backend Backend, store state.Store) *Queue {
+	return &Queue{cfg: cfg, backend: backend, store: store}
+}
+
+// Open opens the configured queue. cred is only used by the Storage queue
+// backend.
+func Open(cfg *config.Config, store state.Store, cred azcore.TokenCredential) (*Queue, error) {
+	if cfg.Queue == nil {
+		return nil, fmt.Errorf("no work queue is configured, see queue in the configuration")
+	}
+	var backend Backend
+	switch cfg.Queue.EffectiveBackend() {
+	case config.QueueBackendStorage:
+		if cred == nil {
+			return nil, fmt.Errorf("an azure credential is required for the storage queue")
+		}
+		backend = NewStorageBackend(cfg.Queue.StorageQueueURL, cred)
+	default:
+		backend = NewStateBackend(store)
+	}
+	return New(*cfg.Queue, backend, store), nil
+}
+
+// Enqueue adds an item for the scope, unless an identical item is waiting.
+// It returns the item and whether it was added, the waiting item otherwise.
+// An item being processed doesn't deduplicate, its run may have read the
+// resources before the change the new item is for.
+func (q *Queue) Enqueue(ctx context.Context, scope, source string) (Item, bool, error) {
+	item := Item{Scope: scope, Source: source, EnqueuedAt: time.Now().UTC()}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending, err := q.loadPending()
+	if err != nil {
+		return Item{}, false, err
+	}
+	if p, ok := pending[item.key()]; ok && item.EnqueuedAt.Sub(p.EnqueuedAt) < pendingTTL {
+		return Item{ID: p.ID, Scope: scope, EnqueuedAt: p.EnqueuedAt}, false, nil
+	}
+
+	if item.ID, err = newID(); err != nil {
+		return Item{}, false, err
+	}
+	if err := q.backend.Send(ctx, item); err != nil {
+		return Item{}, false, fmt.Errorf("failed to enqueue %s: %w", scope, err)
+	}
+	pending[item.key()] = pendingItem{ID: item.ID, EnqueuedAt: item.EnqueuedAt}
+	if err := q.store.Put(pendingKey, pending); err != nil {
+		return Item{}, false, fmt.Errorf("failed to save pending queue items: %w", err)
+	}
+	return item, true, nil
+}
+
+// Depth returns the approximate number of items waiting or being processed.
+func (q *Queue) Depth(ctx context.Context) (int, error) {
+	depth, err := q.backend.Depth(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read queue depth: %w", err)
+	}
+	return depth, nil
+}
+
+// DeadLetters returns the dead-letter list, oldest first.
+func (q *Queue) DeadLetters() ([]DeadLetter, error) {
+	var deadLetters []DeadLetter
+	if err := q.store.Get(deadLettersKey, &deadLetters); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load dead-letter list: %w", err)
+	}
+	return deadLetters, nil
+}
+
+// complete deletes the processed item.
+func (q *Queue) complete(ctx context.Context, msg *Message) error {
+	if err := q.backend.Delete(ctx, msg); err != nil {
+		return fmt.Errorf("failed to delete queue item %s: %w", msg.Item.ID, err)
+	}
+	return nil
+}
+
+// deadLetter moves the item to the dead-letter list.
+func (q *Queue) deadLetter(ctx context.Context, msg *Message, cause string) error {
+	q.mu.Lock()
+	deadLetters, err := q.DeadLetters()
+	if err == nil {
+		deadLetters = append(deadLetters, DeadLetter{Item: msg.Item, Attempts: msg.DequeueCount, Error: cause, At: time.Now().UTC()})
+		if len(deadLetters) > maxDeadLetters {
+			deadLetters = deadLetters[len(deadLetters)-maxDeadLetters:]
+		}
+		if err = q.store.Put(deadLettersKey, deadLetters); err != nil {
+			err = fmt.Errorf("failed to save dead-letter list: %w", err)
+		}
+	}
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return q.complete(ctx, msg)
+}
+
+// forget ends the deduplication of the received item.
+func (q *Queue) forget(item Item) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending, err := q.loadPending()
+	if err != nil {
+		return err
+	}
+	if p, ok := pending[item.key()]; !ok || p.ID != item.ID {
+		return nil
+	}
+	delete(pending, item.key())
+	if err := q.store.Put(pendingKey, pending); err != nil {
+		return fmt.Errorf("failed to save pending queue items: %w", err)
+	}
+	return nil
+}
+
+// loadPending returns the pending items by key.
+func (q *Queue) loadPending() (map[string]pendingItem, error) {
+	pending := make(map[string]pendingItem)
+	if err := q.store.Get(pendingKey, &pending); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load pending queue items: %w", err)
+	}
+	return pending, nil
+}
+
+// newID returns a random item ID.
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate queue item ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,147 @@
+package workqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/state"
+)
+
+// itemsKey is the state store key of the items of the state backend.
+const itemsKey = "queue-items"
+
+// storedMessage is an item of the state backend.
+type storedMessage struct {
+	Item         Item      `json:"item"`
+	DequeueCount int       `json:"dequeueCount"`
+	VisibleAt    time.Time `json:"visibleAt"`
+	Receipt      string    `json:"receipt,omitempty"`
+}
+
+// StateBackend is a Backend keeping the items in the state store, so they
+// survive restarts. The workers of one instance share it, like the rest of
+// the state.
+type StateBackend struct {
+	store state.Store
+
+	mu sync.Mutex
+}
+
+// NewStateBackend creates a new backend on the store.
+func NewStateBackend(store state.Store) *StateBackend {
+	return &StateBackend{store: store}
+}
+
+// Send implements Backend.
+func (b *StateBackend) Send(_ context.Context, item Item) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	messages, err := b.load()
+	if err != nil {
+		return err
+	}
+	return b.save(append(messages, storedMessage{Item: item, VisibleAt: item.EnqueuedAt}))
+}
+
+// Receive implements Backend.
+func (b *StateBackend) Receive(_ context.Context, visibility time.Duration) (*Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	messages, err := b.load()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	for i := range messages {
+		m := &messages[i]
+		if m.VisibleAt.After(now) {
+			continue
+		}
+		receipt, err := newID()
+		if err != nil {
+			return nil, err
+		}
+		m.DequeueCount++
+		m.VisibleAt = now.Add(visibility)
+		m.Receipt = receipt
+		if err := b.save(messages); err != nil {
+			return nil, err
+		}
+		return &Message{Item: m.Item, DequeueCount: m.DequeueCount, id: m.Item.ID, receipt: receipt}, nil
+	}
+	return nil, nil
+}
+
+// Extend implements Backend.
+func (b *StateBackend) Extend(_ context.Context, msg *Message, visibility time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	messages, err := b.load()
+	if err != nil {
+		return err
+	}
+	i, err := find(messages, msg)
+	if err != nil {
+		return err
+	}
+	messages[i].VisibleAt = time.Now().UTC().Add(visibility)
+	return b.save(messages)
+}
+
+// Delete implements Backend.
+func (b *StateBackend) Delete(_ context.Context, msg *Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	messages, err := b.load()
+	if err != nil {
+		return err
+	}
+	i, err := find(messages, msg)
+	if err != nil {
+		return err
+	}
+	return b.save(append(messages[:i], messages[i+1:]...))
+}
+
+// Depth implements Backend.
+func (b *StateBackend) Depth(_ context.Context) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	messages, err := b.load()
+	return len(messages), err
+}
+
+// find returns the index of the received message, or an error if it was
+// received again since.
+func find(messages []storedMessage, msg *Message) (int, error) {
+	for i, m := range messages {
+		if m.Item.ID != msg.id {
+			continue
+		}
+		if m.Receipt != msg.receipt {
+			return 0, fmt.Errorf("queue item %s was received by another worker", msg.id)
+		}
+		return i, nil
+	}
+	return 0, fmt.Errorf("queue item %s not found", msg.id)
+}
+
+// load returns the stored items in the order they were sent.
+func (b *StateBackend) load() ([]storedMessage, error) {
+	var messages []storedMessage
+	if err := b.store.Get(itemsKey, &messages); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load queue items: %w", err)
+	}
+	return messages, nil
+}
+
+// save stores the items.
+func (b *StateBackend) save(messages []storedMessage) error {
+	if err := b.store.Put(itemsKey, messages); err != nil {
+		return fmt.Errorf("failed to save queue items: %w", err)
+	}
+	return nil
+}
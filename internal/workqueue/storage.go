@@ -0,0 +1,190 @@
+package workqueue
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+const (
+	// storageScope is the token scope of Azure Storage.
+	storageScope = "https://storage.azure.com/.default"
+	// storageAPIVersion is the Storage API version, at least 2017-11-09
+	// for Entra ID authentication.
+	storageAPIVersion = "2021-12-02"
+	// storageTimeout bounds every Storage request.
+	storageTimeout = 30 * time.Second
+)
+
+// StorageBackend is a Backend keeping the items in an Azure Storage queue,
+// shared by every instance sending to or receiving from it. Items never
+// expire, a poison item leaves through the dead-letter list.
+type StorageBackend struct {
+	queueURL   string
+	cred       azcore.TokenCredential
+	httpClient *http.Client
+}
+
+// NewStorageBackend creates a new backend on the queue at queueURL,
+// authenticating with cred.
+func NewStorageBackend(queueURL string, cred azcore.TokenCredential) *StorageBackend {
+	return &StorageBackend{
+		queueURL:   strings.TrimRight(queueURL, "/"),
+		cred:       cred,
+		httpClient: &http.Client{Timeout: storageTimeout},
+	}
+}
+
+// storageMessage is a message of the Storage queue API.
+type storageMessage struct {
+	MessageID    string `xml:"MessageId,omitempty"`
+	PopReceipt   string `xml:"PopReceipt,omitempty"`
+	DequeueCount int    `xml:"DequeueCount,omitempty"`
+	MessageText  string `xml:"MessageText"`
+}
+
+// Send implements Backend.
+func (b *StorageBackend) Send(ctx context.Context, item Item) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	body, err := xml.Marshal(struct {
+		XMLName     xml.Name `xml:"QueueMessage"`
+		MessageText string   `xml:"MessageText"`
+	}{MessageText: base64.StdEncoding.EncodeToString(data)})
+	if err != nil {
+		return err
+	}
+	_, err = b.do(ctx, http.MethodPost, "/messages", url.Values{"messagettl": {"-1"}}, body, http.StatusCreated)
+	return err
+}
+
+// Receive implements Backend.
+func (b *StorageBackend) Receive(ctx context.Context, visibility time.Duration) (*Message, error) {
+	resp, err := b.do(ctx, http.MethodGet, "/messages", url.Values{
+		"numofmessages":     {"1"},
+		"visibilitytimeout": {seconds(visibility)},
+	}, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Messages []storageMessage `xml:"QueueMessage"`
+	}
+	if err := xml.Unmarshal(resp.body, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse queue messages: %w", err)
+	}
+	if len(list.Messages) == 0 {
+		return nil, nil
+	}
+
+	m := list.Messages[0]
+	msg := &Message{DequeueCount: m.DequeueCount, id: m.MessageID, receipt: m.PopReceipt}
+	data, err := base64.StdEncoding.DecodeString(m.MessageText)
+	if err == nil {
+		err = json.Unmarshal(data, &msg.Item)
+	}
+	if err != nil {
+		// not sent by velora, it fails every attempt and is dead-lettered
+		msg.Item = Item{ID: m.MessageID, Scope: m.MessageText}
+	}
+	return msg, nil
+}
+
+// Extend implements Backend.
+func (b *StorageBackend) Extend(ctx context.Context, msg *Message, visibility time.Duration) error {
+	resp, err := b.do(ctx, http.MethodPut, "/messages/"+url.PathEscape(msg.id), url.Values{
+		"popreceipt":        {msg.receipt},
+		"visibilitytimeout": {seconds(visibility)},
+	}, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	msg.receipt = resp.header.Get("x-ms-popreceipt")
+	return nil
+}
+
+// Delete implements Backend.
+func (b *StorageBackend) Delete(ctx context.Context, msg *Message) error {
+	_, err := b.do(ctx, http.MethodDelete, "/messages/"+url.PathEscape(msg.id), url.Values{"popreceipt": {msg.receipt}}, nil, http.StatusNoContent)
+	return err
+}
+
+// Depth implements Backend.
+func (b *StorageBackend) Depth(ctx context.Context) (int, error) {
+	resp, err := b.do(ctx, http.MethodGet, "", url.Values{"comp": {"metadata"}}, nil, http.StatusOK)
+	if err != nil {
+		return 0, err
+	}
+	count, err := strconv.Atoi(resp.header.Get("x-ms-approximate-messages-count"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid approximate message count: %w", err)
+	}
+	return count, nil
+}
+
+// storageResponse is a successful Storage response.
+type storageResponse struct {
+	header http.Header
+	body   []byte
+}
+
+// do sends a request to the path of the queue and returns the response if
+// it has the expected status.
+func (b *StorageBackend) do(ctx context.Context, method, path string, query url.Values, body []byte, expected int) (*storageResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, storageTimeout)
+	defer cancel()
+
+	token, err := b.cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{storageScope}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire storage token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, b.queueURL+path+"?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	req.Header.Set("x-ms-version", storageAPIVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != expected {
+		msg := data
+		if len(msg) > 1024 {
+			msg = msg[:1024]
+		}
+		return nil, fmt.Errorf("storage queue returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return &storageResponse{header: resp.Header, body: data}, nil
+}
+
+// seconds formats the duration in whole seconds.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(d / time.Second))
+}
@@ -0,0 +1,150 @@
+package workqueue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/akos011221/velora/internal/metrics"
+)
+
+// pollInterval is how long an idle worker waits before receiving again.
+const pollInterval = 10 * time.Second
+
+// Handler processes an item, attempt counts from 1.
+type Handler func(ctx context.Context, item Item, attempt int) error
+
+// Work processes items with the configured number of workers until ctx is
+// done, or with untilEmpty until no item is visible. A worker renews the
+// visibility timeout of its item while processing it; a failed item is
+// retried by whichever worker receives it once the timeout expires, and
+// moved to the dead-letter list after the last attempt. The queue metrics
+// are written to the state store after every item, and to the textfile if
+// metricsPath is set.
+func (q *Queue) Work(ctx context.Context, handle Handler, untilEmpty bool, metricsPath string) {
+	var wg sync.WaitGroup
+	for i := 0; i < q.cfg.EffectiveWorkers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				processed, err := q.processNext(ctx, handle, metricsPath)
+				if err != nil {
+					fmt.Println("WARNING: work queue:", err)
+				}
+				if ctx.Err() != nil || (!processed && untilEmpty) {
+					return
+				}
+				if !processed {
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(pollInterval):
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// processNext receives and processes one item, reporting whether there was
+// one.
+func (q *Queue) processNext(ctx context.Context, handle Handler, metricsPath string) (bool, error) {
+	visibility := q.cfg.VisibilityTimeout()
+	msg, err := q.backend.Receive(ctx, visibility)
+	if err != nil {
+		return false, fmt.Errorf("failed to receive: %w", err)
+	}
+	if msg == nil {
+		return false, nil
+	}
+	// latency is set once the item completes
+	var latency time.Duration
+	defer func() { q.observe(ctx, latency, metricsPath) }()
+	if err := q.forget(msg.Item); err != nil {
+		fmt.Println("WARNING: work queue:", err)
+	}
+
+	maxAttempts := q.cfg.EffectiveMaxAttempts()
+	// a worker stopped while processing the item, as often as it may fail
+	if msg.DequeueCount > maxAttempts {
+		fmt.Printf("WARNING: queue item %s for %s abandoned %d times, moved to the dead-letter list\n", msg.Item.ID, msg.Item.Scope, msg.DequeueCount-1)
+		return true, q.deadLetter(ctx, msg, fmt.Sprintf("abandoned by %d workers", msg.DequeueCount-1))
+	}
+
+	handleErr := q.handleRenewing(ctx, handle, msg, visibility)
+	if handleErr == nil {
+		if err := q.complete(ctx, msg); err != nil {
+			return true, err
+		}
+		latency = time.Since(msg.Item.EnqueuedAt)
+		return true, nil
+	}
+	if msg.DequeueCount >= maxAttempts {
+		fmt.Printf("WARNING: queue item %s for %s failed %d times, moved to the dead-letter list: %v\n", msg.Item.ID, msg.Item.Scope, msg.DequeueCount, handleErr)
+		return true, q.deadLetter(ctx, msg, handleErr.Error())
+	}
+	fmt.Printf("WARNING: queue item %s for %s failed attempt %d of %d, retrying in %s: %v\n",
+		msg.Item.ID, msg.Item.Scope, msg.DequeueCount, maxAttempts, visibility, handleErr)
+	return true, nil
+}
+
+// handleRenewing runs the handler, renewing the visibility timeout of the
+// item at half of it until the handler returns.
+func (q *Queue) handleRenewing(ctx context.Context, handle Handler, msg *Message, visibility time.Duration) error {
+	done := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(visibility / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := q.backend.Extend(ctx, msg, visibility); err != nil {
+					fmt.Printf("WARNING: failed to renew queue item %s, another worker may process it: %v\n", msg.Item.ID, err)
+				}
+			}
+		}
+	}()
+	err := handle(ctx, msg.Item, msg.DequeueCount)
+	close(done)
+	// the receipt changes with every renewal
+	<-renewed
+	return err
+}
+
+// observe writes the queue metrics after processing an item, with the
+// latency of the item if it completed. Metrics never fail the item.
+func (q *Queue) observe(ctx context.Context, latency time.Duration, metricsPath string) {
+	depth, err := q.Depth(ctx)
+	if err != nil {
+		fmt.Println("WARNING: queue metrics not written:", err)
+		return
+	}
+	deadLetters, err := q.DeadLetters()
+	if err != nil {
+		fmt.Println("WARNING: queue metrics not written:", err)
+		return
+	}
+	err = metrics.Update(q.store, func(snapshot *metrics.Snapshot) {
+		if snapshot.Queue == nil {
+			snapshot.Queue = &metrics.QueueSnapshot{}
+		}
+		snapshot.Queue.Depth = depth
+		if latency > 0 {
+			snapshot.Queue.LatencySeconds = latency.Seconds()
+		}
+		snapshot.Queue.DeadLetters = len(deadLetters)
+	})
+	if err == nil && metricsPath != "" {
+		err = metrics.Refresh(q.store, metricsPath)
+	}
+	if err != nil {
+		fmt.Println("WARNING: queue metrics not written:", err)
+	}
+}
@@ -0,0 +1,51 @@
+// Package velora exposes the evaluation logic of velora to library
+// consumers. Evaluation does no discovery and no writes: callers bring the
+// inventory, e.g. from their own Resource Graph pipeline, and get back the
+// findings and the changes enforcement would make.
+package velora
+
+import (
+	"github.com/akos011221/velora/internal/config"
+	"github.com/akos011221/velora/internal/controllers/routing"
+	"github.com/akos011221/velora/internal/findings"
+)
+
+type (
+	// HubConfig is the configuration of a hub.
+	HubConfig = config.HubVNetConfig
+	// RuleConfig is the per-rule configuration, keyed by rule ID.
+	RuleConfig = config.RuleConfig
+	// Finding is a policy violation.
+	Finding = findings.Finding
+	// Rule is a policy rule findings refer to.
+	Rule = findings.Rule
+)
+
+type (
+	// RoutingPolicy is the routing policy a subscription is evaluated against.
+	RoutingPolicy = routing.Policy
+	// RoutingInventory is the state of a subscription routing is evaluated against.
+	RoutingInventory = routing.Inventory
+	// VNet is a virtual network with its subnets.
+	VNet = routing.VNet
+	// Subnet is a subnet with its address prefixes and route table.
+	Subnet = routing.Subnet
+	// RouteTable is a route table with its routes.
+	RouteTable = routing.RouteTable
+	// Route is a route of a route table.
+	Route = routing.Route
+	// RoutingChangeSet is the outcome of a routing evaluation.
+	RoutingChangeSet = routing.ChangeSet
+	// RouteChange is a route to create or update.
+	RouteChange = routing.RouteChange
+	// CompliantResource is a resource that passed a rule.
+	CompliantResource = routing.CompliantResource
+	// ManagedRoute is a route velora owns that was evaluated.
+	ManagedRoute = routing.ManagedRoute
+)
+
+// EvaluateRouting evaluates the routing policy against the inventory. It
+// returns the same findings and changes the routing controller would.
+func EvaluateRouting(policy RoutingPolicy, inventory RoutingInventory) (RoutingChangeSet, error) {
+	return routing.Evaluate(policy, inventory)
+}
@@ -0,0 +1,31 @@
+package velora
+
+import (
+	"time"
+
+	"github.com/akos011221/velora/internal/webhooks"
+)
+
+type (
+	// WebhookEvent is the payload of a webhook delivery.
+	WebhookEvent = webhooks.Event
+	// WebhookRun is the outcome of a run in a run.completed event.
+	WebhookRun = webhooks.Run
+	// WebhookGuardrail is the write a guardrail stopped in a
+	// guardrail.triggered event.
+	WebhookGuardrail = webhooks.Guardrail
+)
+
+// WebhookSignatureHeader is the header carrying the signature of a webhook
+// delivery.
+const WebhookSignatureHeader = webhooks.SignatureHeader
+
+// VerifyWebhook checks the signature header of a webhook delivery against
+// its raw body and the secret of the endpoint, refusing deliveries older
+// than the tolerance, five minutes if zero, to prevent replays.
+func VerifyWebhook(secret []byte, signatureHeader string, body []byte, tolerance time.Duration) error {
+	if tolerance == 0 {
+		tolerance = webhooks.DefaultTolerance
+	}
+	return webhooks.Verify(secret, signatureHeader, body, tolerance, time.Now())
+}